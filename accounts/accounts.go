@@ -0,0 +1,245 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package accounts
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/rightfoot-consulting/p2pbbs/bbscrypto"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/ssh"
+)
+
+// StoreFile is the name of the file in the data directory holding the local accounts.
+const StoreFile = "accounts.json"
+
+// KeyDir is the name of the directory in the data directory holding the identity keys
+// generated for local accounts.
+const KeyDir = "accounts"
+
+// MinPasswordLength is the shortest password accepted for telnet logins.
+const MinPasswordLength = 8
+
+var validName = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_-]{0,23}$`)
+
+// ErrLoginFailed is returned for an unknown account or a wrong password, deliberately without
+// saying which.
+var ErrLoginFailed = errors.New("login incorrect")
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     []byte
+)
+
+// Account is a local user of a node.  Each account has its own p2pbbs identity, so the posts
+// and messages of users sharing a node are signed by different keys.
+type Account struct {
+	Name           string   `json:"name"`
+	KeyFile        string   `json:"key_file"`
+	AuthorizedKeys []string `json:"authorized_keys,omitempty"`
+	PasswordHash   string   `json:"password_hash,omitempty"`
+}
+
+// Store holds the accounts of a node, persisted as JSON in its data directory.
+type Store struct {
+	file string
+
+	mu       sync.RWMutex
+	accounts map[string]*Account
+}
+
+// LoadStore reads the accounts in file, a missing file gives an empty store.
+func LoadStore(file string) (store *Store, err error) {
+	store = &Store{
+		file:     file,
+		accounts: make(map[string]*Account),
+	}
+	jsonBytes, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var accounts []*Account
+	if err = json.Unmarshal(jsonBytes, &accounts); err != nil {
+		return nil, err
+	}
+	for _, a := range accounts {
+		store.accounts[strings.ToLower(a.Name)] = a
+	}
+	return
+}
+
+// Create adds an account.  When keyFile is empty a new ed25519 identity is generated next to
+// the store.
+func (s *Store) Create(name string, keyFile string) (account *Account, err error) {
+	if !validName.MatchString(name) {
+		return nil, fmt.Errorf("invalid account name %q, use up to 24 letters, digits, - or _ starting with a letter", name)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.accounts[strings.ToLower(name)]; ok {
+		return nil, fmt.Errorf("account %s already exists", name)
+	}
+	if keyFile == "" {
+		keyDir := filepath.Join(filepath.Dir(s.file), KeyDir)
+		if err = os.MkdirAll(keyDir, 0700); err != nil {
+			return
+		}
+		keyFile = filepath.Join(keyDir, strings.ToLower(name)+".key")
+		var privateKey crypto.PrivKey
+		privateKey, _, err = crypto.GenerateKeyPair(crypto.Ed25519, -1)
+		if err != nil {
+			return
+		}
+		if err = bbscrypto.SavePrivateKey(keyFile, privateKey); err != nil {
+			return
+		}
+	} else if _, err = bbscrypto.LoadPrivateKey(keyFile); err != nil {
+		return
+	}
+	account = &Account{Name: name, KeyFile: keyFile}
+	s.accounts[strings.ToLower(name)] = account
+	err = s.save()
+	return
+}
+
+// AuthorizeKey lets the holder of an SSH key log in to an account.  authorizedKey is a line
+// in the OpenSSH authorized_keys format.
+func (s *Store) AuthorizeKey(name string, authorizedKey string) (err error) {
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(authorizedKey))
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	account, ok := s.accounts[strings.ToLower(name)]
+	if !ok {
+		return fmt.Errorf("no account named %s", name)
+	}
+	for _, other := range s.accounts {
+		if other.hasKey(key) {
+			return fmt.Errorf("the key is already authorized for %s", other.Name)
+		}
+	}
+	account.AuthorizedKeys = append(account.AuthorizedKeys, strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))))
+	return s.save()
+}
+
+// SetPassword lets an account log in with a password, which front ends without public key
+// authentication such as telnet need.  Only a bcrypt hash is stored.
+func (s *Store) SetPassword(name string, password string) (err error) {
+	if len(password) < MinPasswordLength {
+		return fmt.Errorf("passwords must be at least %d characters", MinPasswordLength)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	account, ok := s.accounts[strings.ToLower(name)]
+	if !ok {
+		return fmt.Errorf("no account named %s", name)
+	}
+	account.PasswordHash = string(hash)
+	return s.save()
+}
+
+// Authenticate checks a name and password, returning the account on success.
+func (s *Store) Authenticate(name string, password string) (*Account, error) {
+	account, ok := s.Get(name)
+	if !ok || account.PasswordHash == "" {
+		// spend the same time as a real check so names can't be probed
+		dummyHashOnce.Do(func() {
+			dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not a password"), bcrypt.DefaultCost)
+		})
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil, ErrLoginFailed
+	}
+	if bcrypt.CompareHashAndPassword([]byte(account.PasswordHash), []byte(password)) != nil {
+		return nil, ErrLoginFailed
+	}
+	return account, nil
+}
+
+// Get returns an account by name, ignoring case.
+func (s *Store) Get(name string) (*Account, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	account, ok := s.accounts[strings.ToLower(name)]
+	return account, ok
+}
+
+// All returns every account sorted by name.
+func (s *Store) All() []*Account {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	all := make([]*Account, 0, len(s.accounts))
+	for _, a := range s.accounts {
+		all = append(all, a)
+	}
+	sort.Slice(all, func(i, j int) bool { return strings.ToLower(all[i].Name) < strings.ToLower(all[j].Name) })
+	return all
+}
+
+// ByPublicKey returns the account an SSH key is authorized for.
+func (s *Store) ByPublicKey(key ssh.PublicKey) (*Account, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, a := range s.accounts {
+		if a.hasKey(key) {
+			return a, true
+		}
+	}
+	return nil, false
+}
+
+// Identity loads the private key of an account.
+func (a *Account) Identity() (privateKey crypto.PrivKey, id peer.ID, err error) {
+	privateKey, err = bbscrypto.LoadPrivateKey(a.KeyFile)
+	if err != nil {
+		return
+	}
+	id, err = peer.IDFromPrivateKey(privateKey)
+	return
+}
+
+func (a *Account) hasKey(key ssh.PublicKey) bool {
+	marshalled := key.Marshal()
+	for _, line := range a.AuthorizedKeys {
+		authorized, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+		if err == nil && bytes.Equal(authorized.Marshal(), marshalled) {
+			return true
+		}
+	}
+	return false
+}
+
+// save writes the store to its file, the caller must hold the lock.
+func (s *Store) save() error {
+	all := make([]*Account, 0, len(s.accounts))
+	for _, a := range s.accounts {
+		all = append(all, a)
+	}
+	sort.Slice(all, func(i, j int) bool { return strings.ToLower(all[i].Name) < strings.ToLower(all[j].Name) })
+	jsonBytes, err := json.MarshalIndent(all, "", "    ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.file, jsonBytes, 0600)
+}
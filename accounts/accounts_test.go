@@ -0,0 +1,64 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package accounts
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestLogins(t *testing.T) {
+	file := filepath.Join(t.TempDir(), StoreFile)
+	store, err := LoadStore(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = store.Create("alice", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = store.Create("Alice", ""); err == nil {
+		t.Errorf("names should be unique ignoring case")
+	}
+	publicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshKey, err := ssh.NewPublicKey(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = store.AuthorizeKey("alice", string(ssh.MarshalAuthorizedKey(sshKey))); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.SetPassword("alice", "short"); err == nil {
+		t.Errorf("short password accepted")
+	}
+	if err = store.SetPassword("alice", "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := LoadStore(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account, ok := reloaded.ByPublicKey(sshKey); !ok || account.Name != "alice" {
+		t.Errorf("ssh key not mapped to alice")
+	}
+	if _, err = reloaded.Authenticate("ALICE", "correct horse"); err != nil {
+		t.Errorf("login failed: %v", err)
+	}
+	if _, err = reloaded.Authenticate("alice", "wrong horse"); err != ErrLoginFailed {
+		t.Errorf("wrong password accepted")
+	}
+	if _, err = reloaded.Authenticate("bob", "correct horse"); err != ErrLoginFailed {
+		t.Errorf("unknown account accepted")
+	}
+	if _, _, err = reloaded.All()[0].Identity(); err != nil {
+		t.Errorf("identity not usable: %v", err)
+	}
+}
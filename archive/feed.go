@@ -0,0 +1,170 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package archive
+
+import (
+	"encoding/xml"
+	"io"
+	"time"
+
+	"github.com/rightfoot-consulting/p2pbbs/boards"
+)
+
+// atomFeed is an Atom feed (RFC 4287).
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	Title     string      `xml:"title"`
+	ID        string      `xml:"id"`
+	Published string      `xml:"published"`
+	Updated   string      `xml:"updated"`
+	Author    atomAuthor  `xml:"author"`
+	Links     []atomLink  `xml:"link"`
+	Content   atomContent `xml:"content"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+	URI  string `xml:"uri"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+// rssFeed is an RSS 2.0 feed.
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+	Description string  `xml:"description"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+// entryID is the Atom id of a post, its content id never changes.
+func entryID(id string) string {
+	return "urn:p2pbbs:" + id
+}
+
+// updated returns when the newest post in a feed was made.
+func (f *feed) updated() time.Time {
+	if len(f.posts) == 0 {
+		return time.Unix(0, 0)
+	}
+	return f.posts[0].Created
+}
+
+// writeAtom writes the Atom feed at path.
+func (s *Site) writeAtom(w io.Writer, path string, f *feed) error {
+	if f == nil {
+		return ErrNotFound
+	}
+	atom := &atomFeed{
+		Title:   s.title + ": " + f.title,
+		ID:      entryID(path),
+		Updated: f.updated().UTC().Format(time.RFC3339),
+		Links: []atomLink{
+			{Rel: "self", Type: "application/atom+xml", Href: s.link(path, path, true)},
+			{Rel: "alternate", Type: "text/html", Href: s.link(path, f.page, true)},
+		},
+	}
+	for _, p := range f.posts {
+		created := p.Created.UTC().Format(time.RFC3339)
+		atom.Entries = append(atom.Entries, atomEntry{
+			Title:     p.Subject,
+			ID:        entryID(p.ID),
+			Published: created,
+			Updated:   created,
+			Author:    atomAuthor{Name: p.Nick, URI: entryID(p.Author)},
+			Links:     []atomLink{{Rel: "alternate", Type: "text/html", Href: s.link(path, PostPath(p.ID), true)}},
+			Content:   atomContent{Type: "text", Body: p.Body},
+		})
+	}
+	return writeXML(w, atom)
+}
+
+// writeRSS writes the RSS feed at path.
+func (s *Site) writeRSS(w io.Writer, path string, f *feed) error {
+	if f == nil {
+		return ErrNotFound
+	}
+	rss := &rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:         s.title + ": " + f.title,
+			Link:          s.link(path, f.page, true),
+			Description:   "Posts on " + f.title,
+			LastBuildDate: f.updated().Format(time.RFC1123Z),
+		},
+	}
+	for _, p := range f.posts {
+		link := s.link(path, PostPath(p.ID), true)
+		rss.Channel.Items = append(rss.Channel.Items, rssItem{
+			Title:       p.Subject,
+			Link:        link,
+			GUID:        rssGUID{IsPermaLink: s.baseURL != "", Value: link},
+			PubDate:     p.Created.Format(time.RFC1123Z),
+			Description: authorLine(p) + "\n\n" + p.Body,
+		})
+	}
+	return writeXML(w, rss)
+}
+
+func writeXML(w io.Writer, v interface{}) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// authorLine names the author of a post by nick and short peer id.
+func authorLine(p *boards.Post) string {
+	return p.Nick + " (" + shortID(p.Author) + ")"
+}
+
+func shortID(id string) string {
+	if len(id) > 8 {
+		return id[len(id)-8:]
+	}
+	return id
+}
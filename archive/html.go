@@ -0,0 +1,152 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package archive
+
+import (
+	"html/template"
+	"io"
+	"time"
+
+	"github.com/rightfoot-consulting/p2pbbs/boards"
+)
+
+// dateFormat is how post dates are shown on the pages.
+const dateFormat = "2006-01-02 15:04 MST"
+
+var pages = template.Must(template.New("layout").Funcs(template.FuncMap{
+	"date":   func(t time.Time) string { return t.UTC().Format(dateFormat) },
+	"author": authorLine,
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+{{range .Feeds}}<link rel="alternate" type="{{.Type}}" title="{{.Title}}" href="{{.Href}}">
+{{end}}<style>
+body { font-family: sans-serif; max-width: 50em; margin: 1em auto; padding: 0 1em; }
+.meta { color: #666; font-size: 0.9em; }
+.body { white-space: pre-wrap; }
+article { border-top: 1px solid #ccc; padding: 0.5em 0; }
+</style>
+</head>
+<body>
+<nav><a href="{{.Root}}index.html">{{.Site}}</a>{{with .Board}} &rsaquo; <a href="{{$.Root}}board/{{.}}/index.html">{{.}}</a>{{end}}</nav>
+<h1>{{.Title}}</h1>
+{{template "content" .}}
+</body>
+</html>
+{{define "index"}}<ul>
+{{range .Boards}}<li><a href="board/{{.}}/index.html">{{.}}</a></li>
+{{else}}<li>No posts yet.</li>
+{{end}}</ul>
+{{end}}
+{{define "board"}}<p><a href="atom.xml">Atom</a> &middot; <a href="rss.xml">RSS</a></p>
+<table>
+<tr><th>Subject</th><th>Author</th><th>Replies</th><th>Last post</th></tr>
+{{range .Threads}}<tr><td><a href="{{$.Root}}thread/{{.Root.ID}}.html">{{.Root.Subject}}</a></td><td>{{author .Root}}</td><td>{{.Replies}}</td><td>{{date .LastPost}}</td></tr>
+{{end}}</table>
+{{end}}
+{{define "posts"}}{{range .Posts}}<article id="{{.ID}}">
+<h2>{{.Subject}}</h2>
+<p class="meta">{{author .}} &middot; {{date .Created}} &middot; <a href="{{$.Root}}post/{{.ID}}.html">permalink</a>{{if .ReplyTo}} &middot; in reply to <a href="{{$.Root}}post/{{.ReplyTo}}.html">{{.ReplyTo}}</a>{{end}}</p>
+<div class="body">{{.Body}}</div>
+</article>
+{{end}}{{end}}
+{{define "thread"}}<p><a href="{{.Root}}thread/{{.ThreadID}}/atom.xml">Atom</a> &middot; <a href="{{.Root}}thread/{{.ThreadID}}/rss.xml">RSS</a></p>
+{{template "posts" .}}{{end}}
+{{define "post"}}{{template "posts" .}}<p><a href="{{.Root}}thread/{{.ThreadID}}.html#{{(index .Posts 0).ID}}">Read the whole thread</a></p>
+{{end}}
+`))
+
+// pageData is what the page templates are given.  Root is the relative path from the page to
+// the root of the site.
+type pageData struct {
+	Site     string
+	Title    string
+	Root     string
+	Board    string
+	Feeds    []feedLink
+	Boards   []string
+	Threads  []*boards.Thread
+	ThreadID string
+	Posts    []*boards.Post
+}
+
+type feedLink struct {
+	Type  string
+	Title string
+	Href  string
+}
+
+// writePage renders a page with the content template named content.
+func (s *Site) writePage(w io.Writer, path string, content string, data *pageData) error {
+	t, err := pages.Clone()
+	if err != nil {
+		return err
+	}
+	if _, err = t.New("content").Parse(`{{template "` + content + `" .}}`); err != nil {
+		return err
+	}
+	data.Site = s.title
+	data.Root = s.link(path, "", false)
+	return t.ExecuteTemplate(w, "layout", data)
+}
+
+// feedLinks returns the feed links of a board or thread page.
+func (s *Site) feedLinks(path string, dir string, title string) []feedLink {
+	return []feedLink{
+		{Type: "application/atom+xml", Title: title + " (Atom)", Href: s.link(path, dir+"/atom.xml", false)},
+		{Type: "application/rss+xml", Title: title + " (RSS)", Href: s.link(path, dir+"/rss.xml", false)},
+	}
+}
+
+func (s *Site) writeIndex(w io.Writer) error {
+	return s.writePage(w, "index.html", "index", &pageData{Title: "Boards", Boards: s.boards()})
+}
+
+func (s *Site) writeBoard(w io.Writer, board string) error {
+	threads := s.store.Threads(board)
+	if len(threads) == 0 || !s.Shows(board) {
+		return ErrNotFound
+	}
+	path := BoardPath(board)
+	return s.writePage(w, path, "board", &pageData{
+		Title:   board,
+		Board:   board,
+		Feeds:   s.feedLinks(path, "board/"+board, board),
+		Threads: threads,
+	})
+}
+
+func (s *Site) writeThread(w io.Writer, id string) error {
+	posts := s.thread(id)
+	if posts == nil {
+		return ErrNotFound
+	}
+	path := ThreadPath(id)
+	return s.writePage(w, path, "thread", &pageData{
+		Title:    posts[0].Subject,
+		Board:    posts[0].Board,
+		Feeds:    s.feedLinks(path, "thread/"+id, posts[0].Subject),
+		ThreadID: id,
+		Posts:    posts,
+	})
+}
+
+func (s *Site) writePost(w io.Writer, id string) error {
+	p, ok := s.post(id)
+	if !ok {
+		return ErrNotFound
+	}
+	thread := p.Thread
+	if p.IsThread() {
+		thread = p.ID
+	}
+	return s.writePage(w, PostPath(id), "post", &pageData{
+		Title:    p.Subject,
+		Board:    p.Board,
+		ThreadID: thread,
+		Posts:    []*boards.Post{p},
+	})
+}
@@ -0,0 +1,246 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package archive
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/rightfoot-consulting/p2pbbs/boards"
+)
+
+// ErrNotFound is returned when asked for a page of a board, thread or post the store doesn't
+// have.
+var ErrNotFound = errors.New("no such page")
+
+// FeedLength bounds the number of posts in a board's feeds.
+const FeedLength = 50
+
+// Content types of the pages.
+const (
+	HTML = "text/html; charset=utf-8"
+	Atom = "application/atom+xml; charset=utf-8"
+	RSS  = "application/rss+xml; charset=utf-8"
+)
+
+// Site renders the boards of a store as linked HTML pages and Atom and RSS feeds.  Every page
+// has a path relative to the root of the site and links to the others relatively, so the same
+// pages work served over HTTP and exported to a directory:
+//
+//	index.html                  the boards
+//	board/<name>/index.html     the threads of a board
+//	board/<name>/atom.xml       the latest posts of a board, also rss.xml
+//	thread/<id>.html            a thread, every post anchored by its id
+//	thread/<id>/atom.xml        the posts of a thread, also rss.xml
+//	post/<id>.html              the permalink of a post
+//
+// Ids are post content ids, so links stay valid however the archive grows.
+type Site struct {
+	store   *boards.Store
+	title   string
+	baseURL string
+	only    map[string]bool
+}
+
+// NewSite returns a site for the posts in store.  baseURL, when set, is the absolute URL of
+// the root of the site used in feeds, otherwise feeds link relatively.
+func NewSite(store *boards.Store, title string, baseURL string) *Site {
+	if baseURL != "" && !strings.HasSuffix(baseURL, "/") {
+		baseURL += "/"
+	}
+	return &Site{store: store, title: title, baseURL: baseURL}
+}
+
+// Limit restricts the site to the given boards, so a public board can be mirrored without the
+// others.  No boards means every board.
+func (s *Site) Limit(boards ...string) *Site {
+	s.only = nil
+	if len(boards) > 0 {
+		s.only = make(map[string]bool, len(boards))
+		for _, board := range boards {
+			s.only[board] = true
+		}
+	}
+	return s
+}
+
+// BaseURL returns the absolute URL of the root of the site, empty when it links relatively.
+func (s *Site) BaseURL() string {
+	return s.baseURL
+}
+
+// WithBaseURL returns a copy of the site whose feeds link to baseURL.
+func (s *Site) WithBaseURL(baseURL string) *Site {
+	site := NewSite(s.store, s.title, baseURL)
+	site.only = s.only
+	return site
+}
+
+// BoardPath returns the path of the page listing the threads of a board.
+func BoardPath(board string) string {
+	return "board/" + board + "/index.html"
+}
+
+// ThreadPath returns the path of the page showing a thread.
+func ThreadPath(id string) string {
+	return "thread/" + id + ".html"
+}
+
+// PostPath returns the permalink of a post.
+func PostPath(id string) string {
+	return "post/" + id + ".html"
+}
+
+// Paths lists every page of the site.
+func (s *Site) Paths() []string {
+	paths := []string{"index.html"}
+	for _, board := range s.boards() {
+		paths = append(paths, BoardPath(board), "board/"+board+"/atom.xml", "board/"+board+"/rss.xml")
+		for _, p := range s.store.Posts(board) {
+			if p.IsThread() {
+				paths = append(paths, ThreadPath(p.ID), "thread/"+p.ID+"/atom.xml", "thread/"+p.ID+"/rss.xml")
+			}
+			paths = append(paths, PostPath(p.ID))
+		}
+	}
+	return paths
+}
+
+// Render writes the page at path, returning its content type.
+func (s *Site) Render(w io.Writer, path string) (contentType string, err error) {
+	parts := strings.Split(path, "/")
+	switch {
+	case path == "index.html":
+		return HTML, s.writeIndex(w)
+	case len(parts) == 3 && parts[0] == "board" && boards.ValidBoardName(parts[1]):
+		switch parts[2] {
+		case "index.html":
+			return HTML, s.writeBoard(w, parts[1])
+		case "atom.xml":
+			return Atom, s.writeAtom(w, path, s.boardFeed(parts[1]))
+		case "rss.xml":
+			return RSS, s.writeRSS(w, path, s.boardFeed(parts[1]))
+		}
+	case len(parts) == 2 && parts[0] == "thread":
+		if id, ok := strings.CutSuffix(parts[1], ".html"); ok {
+			return HTML, s.writeThread(w, id)
+		}
+	case len(parts) == 3 && parts[0] == "thread":
+		switch parts[2] {
+		case "atom.xml":
+			return Atom, s.writeAtom(w, path, s.threadFeed(parts[1]))
+		case "rss.xml":
+			return RSS, s.writeRSS(w, path, s.threadFeed(parts[1]))
+		}
+	case len(parts) == 2 && parts[0] == "post":
+		if id, ok := strings.CutSuffix(parts[1], ".html"); ok {
+			return HTML, s.writePost(w, id)
+		}
+	}
+	return "", ErrNotFound
+}
+
+// Export writes every page of the site under dir.
+func (s *Site) Export(dir string) (pages int, err error) {
+	for _, path := range s.Paths() {
+		file := filepath.Join(dir, filepath.FromSlash(path))
+		if err = os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			return
+		}
+		var f *os.File
+		if f, err = os.Create(file); err != nil {
+			return
+		}
+		_, err = s.Render(f, path)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return
+		}
+		pages++
+	}
+	return
+}
+
+// boards returns the boards on the site with posts, sorted by name.
+func (s *Site) boards() []string {
+	names := make([]string, 0)
+	for _, board := range s.store.Boards() {
+		if s.Shows(board) {
+			names = append(names, board)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Shows reports whether a board is on the site.
+func (s *Site) Shows(board string) bool {
+	return s.only == nil || s.only[board]
+}
+
+// post returns a post on a board the site shows.
+func (s *Site) post(id string) (*boards.Post, bool) {
+	p, ok := s.store.Get(id)
+	if !ok || !s.Shows(p.Board) {
+		return nil, false
+	}
+	return p, true
+}
+
+// thread returns the posts of a thread on a board the site shows, nil when there is none.
+func (s *Site) thread(id string) []*boards.Post {
+	posts := s.store.Thread(id)
+	if len(posts) == 0 || !posts[0].IsThread() || !s.Shows(posts[0].Board) {
+		return nil
+	}
+	return posts
+}
+
+// feed is the title, page and posts, newest first, of a board or thread feed.
+type feed struct {
+	title string
+	page  string
+	posts []*boards.Post
+}
+
+// boardFeed returns the latest posts of a board, nil when it has none.
+func (s *Site) boardFeed(board string) *feed {
+	posts := s.store.Posts(board)
+	if len(posts) == 0 || !s.Shows(board) {
+		return nil
+	}
+	latest := make([]*boards.Post, 0, FeedLength)
+	for i := len(posts) - 1; i >= 0 && len(latest) < FeedLength; i-- {
+		latest = append(latest, posts[i])
+	}
+	return &feed{title: board, page: BoardPath(board), posts: latest}
+}
+
+// threadFeed returns the posts of a thread, nil when the thread is unknown.
+func (s *Site) threadFeed(id string) *feed {
+	posts := s.thread(id)
+	if posts == nil {
+		return nil
+	}
+	newest := make([]*boards.Post, len(posts))
+	for i, p := range posts {
+		newest[len(posts)-1-i] = p
+	}
+	return &feed{title: posts[0].Subject, page: ThreadPath(id), posts: newest}
+}
+
+// link returns the URL of page as seen from the page at from: relative unless absolute is set
+// and the site has a base URL.
+func (s *Site) link(from string, page string, absolute bool) string {
+	if absolute && s.baseURL != "" {
+		return s.baseURL + page
+	}
+	return strings.Repeat("../", strings.Count(from, "/")) + page
+}
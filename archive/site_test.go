@@ -0,0 +1,110 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package archive
+
+import (
+	"bytes"
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/rightfoot-consulting/p2pbbs/boards"
+)
+
+func testStore(t *testing.T) (*boards.Store, *boards.Post, *boards.Post) {
+	t.Helper()
+	store, err := boards.OpenStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _, _ := crypto.GenerateEd25519Key(nil)
+	root, _ := boards.NewPost(key, "alice", "general", "Hello <world>", "first & foremost", nil)
+	reply, _ := boards.NewPost(key, "alice", "general", "", "a reply", root)
+	for _, p := range []*boards.Post{root, reply} {
+		if _, err = store.Add(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return store, root, reply
+}
+
+func TestPages(t *testing.T) {
+	store, root, reply := testStore(t)
+	site := NewSite(store, "Test BBS", "")
+	var page bytes.Buffer
+	contentType, err := site.Render(&page, ThreadPath(root.ID))
+	if err != nil || contentType != HTML {
+		t.Fatal(contentType, err)
+	}
+	html := page.String()
+	for _, want := range []string{
+		`<h1>Hello &lt;world&gt;</h1>`,
+		`<article id="` + reply.ID + `">`,
+		`href="../post/` + reply.ID + `.html"`,
+		`first &amp; foremost`,
+		`href="../thread/` + root.ID + `/atom.xml"`,
+	} {
+		if !strings.Contains(html, want) {
+			t.Errorf("thread page is missing %s:\n%s", want, html)
+		}
+	}
+	page.Reset()
+	if _, err = site.Render(&page, PostPath(reply.ID)); err != nil || !strings.Contains(page.String(), `href="../thread/`+root.ID+`.html#`+reply.ID+`"`) {
+		t.Errorf("permalink doesn't link to the thread: %v\n%s", err, page.String())
+	}
+	if _, err = site.Render(&page, PostPath("unknown")); err != ErrNotFound {
+		t.Errorf("rendered an unknown post: %v", err)
+	}
+	if _, err = site.Render(&page, "board/../../etc/index.html"); err != ErrNotFound {
+		t.Errorf("rendered a bad path: %v", err)
+	}
+}
+
+func TestFeeds(t *testing.T) {
+	store, root, reply := testStore(t)
+	site := NewSite(store, "Test BBS", "https://bbs.example.org")
+	var atom bytes.Buffer
+	if _, err := site.Render(&atom, "board/general/atom.xml"); err != nil {
+		t.Fatal(err)
+	}
+	var feed atomFeed
+	if err := xml.Unmarshal(atom.Bytes(), &feed); err != nil {
+		t.Fatal(err)
+	}
+	if len(feed.Entries) != 2 || feed.Entries[0].ID != "urn:p2pbbs:"+reply.ID || feed.Entries[1].Title != root.Subject ||
+		feed.Entries[1].Links[0].Href != "https://bbs.example.org/post/"+root.ID+".html" {
+		t.Errorf("unexpected feed %+v", feed)
+	}
+	var rss bytes.Buffer
+	if _, err := site.Render(&rss, "thread/"+root.ID+"/rss.xml"); err != nil {
+		t.Fatal(err)
+	}
+	var channel rssFeed
+	if err := xml.Unmarshal(rss.Bytes(), &channel); err != nil {
+		t.Fatal(err)
+	}
+	if len(channel.Channel.Items) != 2 || channel.Channel.Link != "https://bbs.example.org/thread/"+root.ID+".html" {
+		t.Errorf("unexpected feed %+v", channel)
+	}
+}
+
+func TestExport(t *testing.T) {
+	store, root, _ := testStore(t)
+	dir := t.TempDir()
+	pages, err := NewSite(store, "Test BBS", "").Export(dir)
+	if err != nil || pages != 9 {
+		t.Fatalf("exported %d pages: %v", pages, err)
+	}
+	for _, path := range []string{"index.html", "board/general/rss.xml", "thread/" + root.ID + "/atom.xml", PostPath(root.ID)} {
+		if _, err = os.Stat(filepath.Join(dir, path)); err != nil {
+			t.Error(err)
+		}
+	}
+	if pages, err = NewSite(store, "Test BBS", "").Limit("announcements").Export(t.TempDir()); err != nil || pages != 1 {
+		t.Errorf("exported %d pages of other boards: %v", pages, err)
+	}
+}
@@ -0,0 +1,207 @@
+package bbscrypto
+
+import (
+	"bytes"
+	stdecdsa "crypto/ecdsa"
+	stdrsa "crypto/rsa"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/crypto/pb"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/mr-tron/base58/base58"
+	"github.com/multiformats/go-multibase"
+)
+
+// Kinds of material KeyInfo can describe.
+const (
+	KindPrivateKey = "private-key"
+	KindPublicKey  = "public-key"
+	KindPeerID     = "peer-id"
+	KindEncrypted  = "encrypted"
+)
+
+// KeyInfo describes everything that can be derived from a key file, a public key or a peer id.
+// Fields that cannot be derived from the input are left empty.
+type KeyInfo struct {
+	File      string   `json:"file,omitempty"`
+	Kind      string   `json:"kind"`
+	KeyType   string   `json:"key_type,omitempty"`
+	Curve     string   `json:"curve,omitempty"`
+	Bits      int      `json:"bits,omitempty"`
+	PublicKey string   `json:"public_key,omitempty"`
+	PeerID    string   `json:"peer_id,omitempty"`
+	PeerIDCid string   `json:"peer_id_cid,omitempty"`
+	Encrypted bool     `json:"encrypted"`
+	Warnings  []string `json:"warnings,omitempty"`
+}
+
+// InspectKeyFile reads a file holding a private key, public key or peer id and describes it.
+// Private key files that other users can read produce a warning.
+func InspectKeyFile(filepath string) (info *KeyInfo, err error) {
+	stat, err := os.Stat(filepath)
+	if err != nil {
+		return
+	}
+	contents, err := os.ReadFile(filepath)
+	if err != nil {
+		return
+	}
+	info, err = InspectKey(contents)
+	if err != nil {
+		err = fmt.Errorf("%s: %w", filepath, err)
+		return
+	}
+	info.File = filepath
+	if info.Kind == KindPrivateKey || info.Kind == KindEncrypted {
+		if perm := stat.Mode().Perm(); perm&0077 != 0 {
+			info.Warnings = append(info.Warnings,
+				fmt.Sprintf("file mode %04o allows access by group or others, expected 0600", perm))
+		}
+	}
+	return
+}
+
+// InspectKey describes key material in any of the encodings p2pbbs reads: a base58 private key
+// as written by SavePrivateKey, a public key protobuf in base58, base64 or multibase, a peer id
+// in base58 or CID form, or an encrypted PEM block.
+func InspectKey(contents []byte) (info *KeyInfo, err error) {
+	text := strings.TrimSpace(string(contents))
+	if text == "" {
+		err = fmt.Errorf("no key material found")
+		return
+	}
+	if block, _ := pem.Decode([]byte(text)); block != nil {
+		if isEncryptedPEM(block) {
+			info = &KeyInfo{Kind: KindEncrypted, Encrypted: true}
+			return
+		}
+		err = fmt.Errorf("unsupported unencrypted PEM block %q", block.Type)
+		return
+	}
+	if raw, decodeErr := base58.Decode(text); decodeErr == nil {
+		if privateKey, keyErr := crypto.UnmarshalPrivateKey(raw); keyErr == nil {
+			info, err = describePublicKey(privateKey.GetPublic())
+			if info != nil {
+				info.Kind = KindPrivateKey
+			}
+			return
+		}
+	}
+	if id, decodeErr := peer.Decode(text); decodeErr == nil {
+		return describePeerID(id)
+	}
+	for _, raw := range publicKeyCandidates(text) {
+		if publicKey, keyErr := crypto.UnmarshalPublicKey(raw); keyErr == nil {
+			return describePublicKey(publicKey)
+		}
+	}
+	err = fmt.Errorf("unrecognised key material")
+	return
+}
+
+// EncodePublicKey returns the multibase (base64) encoding of a public key protobuf.
+func EncodePublicKey(publicKey crypto.PubKey) (encoded string, err error) {
+	raw, err := crypto.MarshalPublicKey(publicKey)
+	if err != nil {
+		return
+	}
+	return multibase.Encode(multibase.Base64, raw)
+}
+
+func describePublicKey(publicKey crypto.PubKey) (info *KeyInfo, err error) {
+	id, err := peer.IDFromPublicKey(publicKey)
+	if err != nil {
+		return
+	}
+	info, err = describePeerID(id)
+	if err != nil {
+		return
+	}
+	info.Kind = KindPublicKey
+	info.PublicKey, err = EncodePublicKey(publicKey)
+	if err != nil {
+		return
+	}
+	err = describeKeyType(info, publicKey)
+	return
+}
+
+// describePeerID fills in the peer id forms, and for ids that inline their key (ed25519 and
+// secp256k1) everything that can be learned from the embedded public key.
+func describePeerID(id peer.ID) (info *KeyInfo, err error) {
+	cidString, err := peer.ToCid(id).StringOfBase(multibase.Base36)
+	if err != nil {
+		return
+	}
+	info = &KeyInfo{
+		Kind:      KindPeerID,
+		PeerID:    id.String(),
+		PeerIDCid: cidString,
+	}
+	if publicKey, extractErr := id.ExtractPublicKey(); extractErr == nil {
+		info.PublicKey, err = EncodePublicKey(publicKey)
+		if err != nil {
+			return
+		}
+		err = describeKeyType(info, publicKey)
+	}
+	return
+}
+
+func describeKeyType(info *KeyInfo, publicKey crypto.PubKey) (err error) {
+	info.KeyType = strings.ToLower(pb.KeyType_name[int32(publicKey.Type())])
+	switch publicKey.Type() {
+	case crypto.Ed25519:
+		info.Curve = "ed25519"
+		info.Bits = 256
+	case crypto.Secp256k1:
+		info.Curve = "secp256k1"
+		info.Bits = 256
+	case crypto.ECDSA, crypto.RSA:
+		var stdKey interface{}
+		stdKey, err = crypto.PubKeyToStdKey(publicKey)
+		if err != nil {
+			return
+		}
+		switch key := stdKey.(type) {
+		case *stdecdsa.PublicKey:
+			info.Curve = strings.ToLower(strings.ReplaceAll(key.Curve.Params().Name, "-", ""))
+			info.Bits = key.Curve.Params().BitSize
+		case *stdrsa.PublicKey:
+			info.Bits = key.N.BitLen()
+		}
+	}
+	return
+}
+
+func publicKeyCandidates(text string) (candidates [][]byte) {
+	if _, raw, err := multibase.Decode(text); err == nil {
+		candidates = append(candidates, raw)
+	}
+	if raw, err := base58.Decode(text); err == nil {
+		candidates = append(candidates, raw)
+	}
+	if raw, err := base64.StdEncoding.DecodeString(text); err == nil {
+		candidates = append(candidates, raw)
+	}
+	return
+}
+
+func isEncryptedPEM(block *pem.Block) bool {
+	if strings.Contains(block.Type, "ENCRYPTED") {
+		return true
+	}
+	if strings.Contains(block.Headers["Proc-Type"], "ENCRYPTED") {
+		return true
+	}
+	// OpenSSH keys record their cipher inside the body, unencrypted keys use "none"
+	if block.Type == "OPENSSH PRIVATE KEY" {
+		return !bytes.Contains(block.Bytes, []byte("none"))
+	}
+	return false
+}
@@ -0,0 +1,122 @@
+package bbscrypto
+
+import (
+	"crypto/rand"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"io"
+
+	"filippo.io/edwards25519"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
+	"golang.org/x/crypto/salsa20/salsa"
+)
+
+// nonceSize is the length of the random nonce that starts every sealed box.
+const nonceSize = 24
+
+// SealOverhead is how much longer a sealed box is than the data in it.
+const SealOverhead = nonceSize + box.Overhead
+
+// ErrNotSealable is returned for keys that can't be used to seal, only ed25519 keys can.
+var ErrNotSealable = errors.New("only ed25519 keys can seal messages")
+
+// Seal encrypts data so that only privateKey and peerKey can read it, using NaCl box with the
+// curve25519 forms of the two ed25519 keys.  Either side opens it with Open.
+func Seal(privateKey crypto.PrivKey, peerKey crypto.PubKey, data []byte) (sealed []byte, err error) {
+	shared, err := sharedKey(privateKey, peerKey)
+	if err != nil {
+		return
+	}
+	var nonce [nonceSize]byte
+	if _, err = io.ReadFull(rand.Reader, nonce[:]); err != nil {
+		return
+	}
+	sealed = box.SealAfterPrecomputation(nonce[:], data, &nonce, shared)
+	return
+}
+
+// Open decrypts a box sealed between privateKey and peerKey, by either of them.
+func Open(privateKey crypto.PrivKey, peerKey crypto.PubKey, sealed []byte) (data []byte, err error) {
+	if len(sealed) < SealOverhead {
+		return nil, errors.New("sealed box is too short")
+	}
+	shared, err := sharedKey(privateKey, peerKey)
+	if err != nil {
+		return
+	}
+	var nonce [nonceSize]byte
+	copy(nonce[:], sealed)
+	data, ok := box.OpenAfterPrecomputation(nil, sealed[nonceSize:], &nonce, shared)
+	if !ok {
+		return nil, errors.New("sealed box could not be opened")
+	}
+	return
+}
+
+// Sealable reports whether Seal can be used between privateKey and peerKey.
+func Sealable(privateKey crypto.PrivKey, peerKey crypto.PubKey) bool {
+	return privateKey.Type() == crypto.Ed25519 && peerKey.Type() == crypto.Ed25519
+}
+
+// sharedKey returns the box key shared by the owners of privateKey and peerKey.
+func sharedKey(privateKey crypto.PrivKey, peerKey crypto.PubKey) (shared *[32]byte, err error) {
+	private, err := curvePrivateKey(privateKey)
+	if err != nil {
+		return
+	}
+	public, err := curvePublicKey(peerKey)
+	if err != nil {
+		return
+	}
+	// box.Precompute without its curve25519.ScalarMult, which gives the all zero point anyone
+	// can compute for a low order peer key where X25519 refuses it
+	point, err := curve25519.X25519(private[:], public[:])
+	if err != nil {
+		return nil, fmt.Errorf("ed25519 public key is of low order: %w", err)
+	}
+	shared = new([32]byte)
+	copy(shared[:], point)
+	salsa.HSalsa20(shared, new([16]byte), shared, &salsa.Sigma)
+	return
+}
+
+// curvePrivateKey returns the curve25519 scalar of an ed25519 private key, the clamped first
+// half of the SHA-512 hash of its seed as described in RFC 8032.
+func curvePrivateKey(privateKey crypto.PrivKey) (*[32]byte, error) {
+	if privateKey.Type() != crypto.Ed25519 {
+		return nil, ErrNotSealable
+	}
+	raw, err := privateKey.Raw()
+	if err != nil {
+		return nil, err
+	}
+	digest := sha512.Sum512(raw[:32])
+	scalar := new([32]byte)
+	copy(scalar[:], digest[:32])
+	scalar[0] &= 248
+	scalar[31] &= 127
+	scalar[31] |= 64
+	return scalar, nil
+}
+
+// curvePublicKey maps an ed25519 public key to its curve25519 form, the Montgomery u-coordinate
+// of the point.  Keys that aren't a valid point are refused.
+func curvePublicKey(publicKey crypto.PubKey) (*[32]byte, error) {
+	if publicKey.Type() != crypto.Ed25519 {
+		return nil, ErrNotSealable
+	}
+	raw, err := publicKey.Raw()
+	if err != nil {
+		return nil, err
+	}
+	p, err := new(edwards25519.Point).SetBytes(raw)
+	if err != nil {
+		return nil, fmt.Errorf("ed25519 public key is not on the curve: %w", err)
+	}
+	point := new([32]byte)
+	copy(point[:], p.BytesMontgomery())
+	return point, nil
+}
@@ -0,0 +1,102 @@
+package bbscrypto
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
+)
+
+func TestCurveKeys(t *testing.T) {
+	for i := 0; i < 10; i++ {
+		privateKey, publicKey, err := crypto.GenerateEd25519Key(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		scalar, err := curvePrivateKey(privateKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		point, err := curvePublicKey(publicKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := curve25519.X25519(scalar[:], curve25519.Basepoint)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(point[:], want) {
+			t.Fatalf("converted public key %x does not match the converted private key %x", point, want)
+		}
+	}
+}
+
+func TestSeal(t *testing.T) {
+	alice, alicePublic, _ := crypto.GenerateEd25519Key(rand.Reader)
+	bob, bobPublic, _ := crypto.GenerateEd25519Key(rand.Reader)
+	mallory, _, _ := crypto.GenerateEd25519Key(rand.Reader)
+	sealed, err := Seal(alice, bobPublic, []byte("meet at noon"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sealed) != len("meet at noon")+SealOverhead || bytes.Contains(sealed, []byte("noon")) {
+		t.Fatalf("unexpected box %x", sealed)
+	}
+	for name, open := range map[string]func() ([]byte, error){
+		"recipient": func() ([]byte, error) { return Open(bob, alicePublic, sealed) },
+		"sender":    func() ([]byte, error) { return Open(alice, bobPublic, sealed) },
+	} {
+		data, err := open()
+		if err != nil || string(data) != "meet at noon" {
+			t.Errorf("%s opened %q, %v", name, data, err)
+		}
+	}
+	if _, err = Open(mallory, alicePublic, sealed); err == nil {
+		t.Error("a third party opened the box")
+	}
+	sealed[len(sealed)-1] ^= 1
+	if _, err = Open(bob, alicePublic, sealed); err == nil {
+		t.Error("opened a tampered box")
+	}
+	rsaKey, _, _ := crypto.GenerateKeyPairWithReader(crypto.RSA, 2048, rand.Reader)
+	if Sealable(rsaKey, bobPublic) {
+		t.Error("rsa keys reported as sealable")
+	}
+	if _, err = Seal(rsaKey, bobPublic, []byte("x")); err != ErrNotSealable {
+		t.Errorf("sealed with an rsa key: %v", err)
+	}
+}
+
+func TestSharedKey(t *testing.T) {
+	alice, _, _ := crypto.GenerateEd25519Key(rand.Reader)
+	_, bobPublic, _ := crypto.GenerateEd25519Key(rand.Reader)
+	shared, err := sharedKey(alice, bobPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	private, _ := curvePrivateKey(alice)
+	public, _ := curvePublicKey(bobPublic)
+	var want [32]byte
+	box.Precompute(&want, public, private)
+	if *shared != want {
+		t.Errorf("shared key %x is not the box key %x", shared, want)
+	}
+
+	// the identity and the point of order two have a curve25519 form anyone shares a key with
+	identity := make([]byte, 32)
+	identity[0] = 1
+	orderTwo := bytes.Repeat([]byte{0xff}, 32)
+	orderTwo[0], orderTwo[31] = 0xec, 0x7f
+	for _, raw := range [][]byte{identity, orderTwo} {
+		lowOrder, err := crypto.UnmarshalEd25519PublicKey(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = Seal(alice, lowOrder, []byte("x")); err == nil {
+			t.Errorf("sealed to the low order key %x", raw)
+		}
+	}
+}
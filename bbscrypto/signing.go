@@ -0,0 +1,71 @@
+package bbscrypto
+
+import (
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multihash"
+)
+
+// VerificationKey returns the marshalled public key that must travel with a signature made by
+// privateKey.  Peer ids of ed25519 and secp256k1 keys embed the public key, so for those it
+// returns nil.
+func VerificationKey(privateKey crypto.PrivKey) (publicKey []byte, err error) {
+	id, err := peer.IDFromPrivateKey(privateKey)
+	if err != nil {
+		return
+	}
+	if _, extractErr := id.ExtractPublicKey(); extractErr != nil {
+		publicKey, err = crypto.MarshalPublicKey(privateKey.GetPublic())
+	}
+	return
+}
+
+// Verify checks that signature was made over data by the key belonging to signer.  publicKey is
+// only needed when the signer's peer id does not embed its key.
+func Verify(signer peer.ID, publicKey []byte, data []byte, signature []byte) (err error) {
+	key, err := SignerKey(signer, publicKey)
+	if err != nil {
+		return
+	}
+	ok, err := key.Verify(data, signature)
+	if err != nil {
+		return
+	}
+	if !ok {
+		err = fmt.Errorf("invalid signature from %s", signer)
+	}
+	return
+}
+
+// SignerKey returns the public key of signer, either extracted from the peer id or unmarshalled
+// from publicKey after checking that it hashes to signer.
+func SignerKey(signer peer.ID, publicKey []byte) (key crypto.PubKey, err error) {
+	if len(publicKey) == 0 {
+		key, err = signer.ExtractPublicKey()
+		if err != nil {
+			err = fmt.Errorf("no public key available for %s: %w", signer, err)
+		}
+		return
+	}
+	key, err = crypto.UnmarshalPublicKey(publicKey)
+	if err != nil {
+		return
+	}
+	if !signer.MatchesPublicKey(key) {
+		err = fmt.Errorf("public key does not match %s", signer)
+		key = nil
+	}
+	return
+}
+
+// ContentID names a signed record by its content: the CIDv1 of the sha256 hash of data.
+func ContentID(data []byte) (string, error) {
+	hash, err := multihash.Sum(data, multihash.SHA2_256, -1)
+	if err != nil {
+		return "", err
+	}
+	return cid.NewCidV1(cid.Raw, hash).String(), nil
+}
@@ -0,0 +1,62 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package bbsdht
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	maddr "github.com/multiformats/go-multiaddr"
+	"github.com/rightfoot-consulting/p2pbbs/profile"
+)
+
+// ProtocolPrefix keeps the p2pbbs DHT separate from the public IPFS DHT, which only accepts
+// public key and IPNS records.
+const ProtocolPrefix = "/p2pbbs"
+
+// New starts a DHT that speaks the p2pbbs protocol and validates the p2pbbs record namespaces.
+// Every node in a cluster, bootstrap or client, must be created this way to share records.
+func New(ctx context.Context, h host.Host, mode dht.ModeOpt, bootstrapPeers []peer.AddrInfo) (*dht.IpfsDHT, error) {
+	return dht.New(ctx, h,
+		dht.ProtocolPrefix(ProtocolPrefix),
+		dht.NamespacedValidator(profile.Namespace, profile.Validator{}),
+		dht.BootstrapPeers(bootstrapPeers...),
+		dht.Mode(mode),
+	)
+}
+
+// AddrInfos converts bootstrap peer multiaddrs into peer address infos.
+func AddrInfos(addrs []maddr.Multiaddr) (infos []peer.AddrInfo, err error) {
+	infos = make([]peer.AddrInfo, 0, len(addrs))
+	for _, addr := range addrs {
+		var info *peer.AddrInfo
+		info, err = peer.AddrInfoFromP2pAddr(addr)
+		if err != nil {
+			err = fmt.Errorf("unable to get address info from address: %v", addr)
+			infos = nil
+			return
+		}
+		infos = append(infos, *info)
+	}
+	return
+}
+
+// WaitForPeers blocks until the routing table of d holds at least one peer, so that puts and
+// gets have somewhere to go.
+func WaitForPeers(ctx context.Context, d *dht.IpfsDHT) error {
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+	for d.RoutingTable().Size() == 0 {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("no dht peers found: %w", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
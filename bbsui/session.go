@@ -0,0 +1,523 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package bbsui
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/rightfoot-consulting/p2pbbs/accounts"
+	"github.com/rightfoot-consulting/p2pbbs/boards"
+	"github.com/rightfoot-consulting/p2pbbs/chatv2"
+	"github.com/rightfoot-consulting/p2pbbs/dm"
+	"github.com/rightfoot-consulting/p2pbbs/doors"
+	"github.com/rightfoot-consulting/p2pbbs/sanitize"
+	"github.com/rivo/tview"
+)
+
+// timeFormat is how dates are shown in lists and post headers.
+const timeFormat = "2006-01-02 15:04"
+
+// Session is a classic BBS menu for one logged in local user: boards, threads, chat rooms
+// and direct messages, all served by a node shared with the other users of the front end.
+// Posts and messages are signed with the user's own identity.
+type Session struct {
+	node       *chatv2.ChatV2Node
+	accounts   *accounts.Store
+	account    *accounts.Account
+	privateKey crypto.PrivKey
+	self       peer.ID
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	app    *tview.Application
+	pages  *tview.Pages
+	tty    *Tty
+
+	// refresh redraws the page showing live content, it only runs on the UI goroutine
+	refresh func()
+	room    *chatv2.ChatRoom
+}
+
+// NewSession prepares a session for account.  accounts is used to address direct messages to
+// other local users by name.
+func NewSession(ctx context.Context, node *chatv2.ChatV2Node, store *accounts.Store, account *accounts.Account) (s *Session, err error) {
+	privateKey, self, err := account.Identity()
+	if err != nil {
+		return
+	}
+	if err = node.DMs.Listen(privateKey); err != nil {
+		return
+	}
+	s = &Session{
+		node:       node,
+		accounts:   store,
+		account:    account,
+		privateKey: privateKey,
+		self:       self,
+		app:        tview.NewApplication(),
+		pages:      tview.NewPages(),
+	}
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	return
+}
+
+// Run draws the menu on screen until the user logs off or the session's context ends.  tty is
+// the terminal under the screen, lent to the doors the user plays.
+func (s *Session) Run(screen tcell.Screen, tty *Tty) error {
+	defer s.cancel()
+	s.tty = tty
+	s.app.SetScreen(screen)
+	s.app.SetRoot(s.pages, true)
+	s.showMenu()
+	go s.watch()
+	defer s.leaveRoom()
+	return s.app.Run()
+}
+
+// watch redraws the current page when posts or messages arrive, and stops the UI when the
+// session ends.
+func (s *Session) watch() {
+	posts, stopPosts := s.node.Boards.Store().Subscribe()
+	defer stopPosts()
+	messages, stopMessages := s.node.DMs.Mailbox().Subscribe(s.self)
+	defer stopMessages()
+	for {
+		select {
+		case <-posts:
+		case <-messages:
+		case <-s.ctx.Done():
+			s.app.Stop()
+			return
+		}
+		s.app.QueueUpdateDraw(func() {
+			if s.refresh != nil {
+				s.refresh()
+			}
+		})
+	}
+}
+
+// show replaces the current page.  refresh, if not nil, is run when new content arrives.
+func (s *Session) show(name string, page tview.Primitive, refresh func()) {
+	s.refresh = refresh
+	s.pages.AddAndSwitchToPage(name, page, true)
+}
+
+func (s *Session) showMenu() {
+	menu := tview.NewList().
+		AddItem("Boards", "Read and post to the message boards", 'b', s.showBoards).
+		AddItem("Chat", "Join a chat room", 'c', s.showJoinRoom).
+		AddItem("Direct messages", "Private messages to and from other users", 'd', s.showConversations).
+		AddItem("Doors", "Play door games", 'o', s.showDoors).
+		AddItem("Goodbye", "Log off", 'g', s.app.Stop)
+	menu.SetBorder(true).SetTitle(fmt.Sprintf(" p2pbbs - welcome %s ", sanitize.TviewLine(s.account.Name)))
+	s.show("menu", menu, nil)
+}
+
+func (s *Session) showBoards() {
+	list := tview.NewList()
+	list.SetBorder(true).SetTitle(" Boards ")
+	list.SetDoneFunc(s.showMenu)
+	fill := func() {
+		current := list.GetCurrentItem()
+		list.Clear()
+		for _, board := range s.node.Boards.Boards() {
+			board := board
+			threads := s.node.Boards.Store().Threads(board)
+			list.AddItem(board, fmt.Sprintf("%d threads", len(threads)), 0, func() { s.showThreads(board) })
+		}
+		list.SetCurrentItem(current)
+	}
+	fill()
+	s.show("boards", list, fill)
+}
+
+func (s *Session) showThreads(board string) {
+	list := tview.NewList()
+	list.SetBorder(true).SetTitle(fmt.Sprintf(" %s - Esc to go back ", board))
+	list.SetDoneFunc(s.showBoards)
+	fill := func() {
+		current := list.GetCurrentItem()
+		list.Clear()
+		list.AddItem("+ New thread", "", 'n', func() { s.showCompose(board, nil) })
+		for _, t := range s.node.Boards.Store().Threads(board) {
+			root := t.Root
+			secondary := fmt.Sprintf("by %s, %d replies, last post %s", authorName(root.Nick, root.AuthorID()), t.Replies, t.LastPost.Local().Format(timeFormat))
+			list.AddItem(sanitize.TviewLine(root.Subject), sanitize.TviewLine(secondary), 0, func() { s.showThread(root.ID) })
+		}
+		list.SetCurrentItem(current)
+	}
+	fill()
+	s.show("threads", list, fill)
+}
+
+func (s *Session) showThread(id string) {
+	view := tview.NewTextView().SetDynamicColors(true).SetWordWrap(true)
+	posts := s.node.Boards.Store().Thread(id)
+	if len(posts) == 0 {
+		return
+	}
+	root := posts[0]
+	view.SetBorder(true).SetTitle(fmt.Sprintf(" %s - r to reply, Esc to go back ", sanitize.TviewLine(root.Subject)))
+	fill := func() {
+		view.Clear()
+		posts = s.node.Boards.Store().Thread(id)
+		for i, p := range posts {
+			fmt.Fprintf(view, "[yellow]#%d %s[-] [green]%s[-]\n", i+1, p.Created.Local().Format(timeFormat), sanitize.TviewLine(authorName(p.Nick, p.AuthorID())))
+			if !p.IsThread() {
+				fmt.Fprintf(view, "[blue]%s[-]\n", sanitize.TviewLine(p.Subject))
+			}
+			fmt.Fprintf(view, "%s\n\n", sanitize.TviewText(p.Body))
+		}
+	}
+	fill()
+	view.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch {
+		case event.Key() == tcell.KeyEscape:
+			s.showThreads(root.Board)
+			return nil
+		case event.Rune() == 'r':
+			s.showCompose(root.Board, posts[len(posts)-1])
+			return nil
+		}
+		return event
+	})
+	s.show("thread", view, fill)
+}
+
+// showCompose asks for a new thread on board, or a reply to parent when it isn't nil.
+func (s *Session) showCompose(board string, parent *boards.Post) {
+	form := tview.NewForm()
+	back := func() { s.showThreads(board) }
+	subject := ""
+	if parent != nil {
+		back = func() { s.showThread(parent.ThreadID()) }
+		subject = parent.Subject
+		if !strings.HasPrefix(subject, "Re: ") {
+			subject = "Re: " + subject
+		}
+		form.SetTitle(" Reply ")
+	} else {
+		form.SetTitle(fmt.Sprintf(" New thread on %s ", board))
+	}
+	form.SetBorder(true)
+	form.AddInputField("Subject", subject, 0, nil, nil)
+	form.AddTextArea("Message", "", 0, 0, boards.MaxBodyLength, nil)
+	status := tview.NewTextView().SetDynamicColors(true)
+	form.AddButton("Post", func() {
+		subject := form.GetFormItemByLabel("Subject").(*tview.InputField).GetText()
+		body := form.GetFormItemByLabel("Message").(*tview.TextArea).GetText()
+		p, err := boards.NewPost(s.privateKey, s.account.Name, board, subject, body, parent)
+		if err == nil {
+			err = s.node.Boards.Publish(p)
+		}
+		if err != nil {
+			status.SetText(fmt.Sprintf("[red]%s[-]", sanitize.TviewLine(err.Error())))
+			return
+		}
+		s.showThread(p.ThreadID())
+	})
+	form.AddButton("Cancel", back)
+	form.SetCancelFunc(back)
+	layout := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(form, 0, 1, true).
+		AddItem(status, 1, 0, false)
+	s.show("compose", layout, nil)
+}
+
+func (s *Session) showJoinRoom() {
+	form := tview.NewForm()
+	form.SetBorder(true).SetTitle(" Join a chat room ")
+	form.AddInputField("Room", chatv2.DefaultRoom, 40, nil, nil)
+	form.AddButton("Join", func() {
+		room := strings.TrimSpace(form.GetFormItemByLabel("Room").(*tview.InputField).GetText())
+		if room != "" {
+			s.showRoom(room)
+		}
+	})
+	form.AddButton("Cancel", s.showMenu)
+	form.SetCancelFunc(s.showMenu)
+	s.show("join", form, nil)
+}
+
+func (s *Session) showRoom(name string) {
+	s.leaveRoom()
+	room, err := s.node.JoinRoom(s.ctx, s.account.Name, name)
+	if err != nil {
+		s.showError(err, s.showMenu)
+		return
+	}
+	s.room = room
+
+	messages := tview.NewTextView().SetDynamicColors(true).SetWordWrap(true)
+	messages.SetBorder(true).SetTitle(fmt.Sprintf(" Room: %s - /who, /quit ", sanitize.TviewLine(name)))
+	messages.SetChangedFunc(func() { messages.ScrollToEnd() })
+	input := tview.NewInputField().SetLabel(s.account.Name + " > ").SetFieldWidth(0)
+	leave := func() {
+		s.leaveRoom()
+		s.showMenu()
+	}
+	input.SetDoneFunc(func(key tcell.Key) {
+		if key == tcell.KeyEscape {
+			leave()
+			return
+		}
+		if key != tcell.KeyEnter {
+			return
+		}
+		line := input.GetText()
+		input.SetText("")
+		switch {
+		case line == "":
+		case line == "/quit":
+			leave()
+		case line == "/who":
+			peers := room.ListPeers()
+			names := make([]string, len(peers))
+			for i, p := range peers {
+				names[i] = s.peerName(p)
+			}
+			fmt.Fprintf(messages, "[blue]%d peers: %s[-]\n", len(peers), sanitize.TviewLine(strings.Join(names, ", ")))
+		default:
+			if err := room.Publish(line); err != nil {
+				fmt.Fprintf(messages, "[red]%s[-]\n", sanitize.TviewLine(err.Error()))
+				return
+			}
+			fmt.Fprintf(messages, "[yellow]<%s>:[-] %s\n", sanitize.TviewLine(s.account.Name), sanitize.TviewLine(line))
+		}
+	})
+	layout := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(messages, 0, 1, false).
+		AddItem(input, 1, 0, true)
+	s.show("room", layout, nil)
+
+	go func() {
+		for m := range room.Messages {
+			m := m
+			s.app.QueueUpdateDraw(func() {
+				fmt.Fprintf(messages, "[green]<%s>:[-] %s\n", sanitize.TviewLine(m.SenderNick), sanitize.TviewLine(m.Message))
+			})
+		}
+	}()
+}
+
+func (s *Session) leaveRoom() {
+	if s.room != nil {
+		s.room.Leave()
+		s.room = nil
+	}
+}
+
+func (s *Session) showConversations() {
+	list := tview.NewList()
+	list.SetBorder(true).SetTitle(" Direct messages - Esc to go back ")
+	list.SetDoneFunc(s.showMenu)
+	fill := func() {
+		current := list.GetCurrentItem()
+		list.Clear()
+		list.AddItem("+ New message", "", 'n', s.showNewMessage)
+		conversations, err := s.node.DMs.Mailbox().Conversations(s.self)
+		if err != nil {
+			list.AddItem(sanitize.TviewLine(err.Error()), "", 0, nil)
+		}
+		for _, c := range conversations {
+			other := c.Peer
+			secondary := fmt.Sprintf("%d messages, last %s", c.Count, c.Last.Local().Format(timeFormat))
+			list.AddItem(sanitize.TviewLine(authorName(c.Nick, other)), secondary, 0, func() { s.showConversation(other) })
+		}
+		list.SetCurrentItem(current)
+	}
+	fill()
+	s.show("conversations", list, fill)
+}
+
+func (s *Session) showNewMessage() {
+	form := tview.NewForm()
+	form.SetBorder(true).SetTitle(" New message ")
+	form.AddInputField("To", "", 0, nil, nil)
+	form.AddTextArea("Message", "", 0, 0, dm.MaxBodyLength, nil)
+	status := tview.NewTextView().SetDynamicColors(true)
+	form.AddButton("Send", func() {
+		to, err := s.resolve(form.GetFormItemByLabel("To").(*tview.InputField).GetText())
+		if err == nil {
+			body := form.GetFormItemByLabel("Message").(*tview.TextArea).GetText()
+			_, err = s.node.DMs.Send(s.privateKey, s.account.Name, to, body)
+		}
+		if err != nil {
+			status.SetText(fmt.Sprintf("[red]%s[-]", sanitize.TviewLine(err.Error())))
+			return
+		}
+		s.showConversation(to)
+	})
+	form.AddButton("Cancel", s.showConversations)
+	form.SetCancelFunc(s.showConversations)
+	layout := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(form, 0, 1, true).
+		AddItem(status, 1, 0, false)
+	s.show("compose-dm", layout, nil)
+}
+
+func (s *Session) showConversation(other peer.ID) {
+	view := tview.NewTextView().SetDynamicColors(true).SetWordWrap(true)
+	view.SetBorder(true).SetTitle(fmt.Sprintf(" %s - Esc to go back ", sanitize.TviewLine(s.peerName(other))))
+	view.SetChangedFunc(func() { view.ScrollToEnd() })
+	fill := func() {
+		view.Clear()
+		messages, err := s.node.DMs.Mailbox().With(s.self, other)
+		if err != nil {
+			fmt.Fprintf(view, "[red]%s[-]\n", sanitize.TviewLine(err.Error()))
+		}
+		for _, m := range messages {
+			color := "green"
+			if m.FromID() == s.self {
+				color = "yellow"
+			}
+			fmt.Fprintf(view, "[%s]%s <%s>:[-] %s\n", color, m.Created.Local().Format(timeFormat), sanitize.TviewLine(m.Nick), sanitize.TviewText(m.Body))
+		}
+	}
+	fill()
+	input := tview.NewInputField().SetLabel("> ").SetFieldWidth(0)
+	input.SetDoneFunc(func(key tcell.Key) {
+		if key == tcell.KeyEscape {
+			s.showConversations()
+			return
+		}
+		if key != tcell.KeyEnter || input.GetText() == "" {
+			return
+		}
+		if _, err := s.node.DMs.Send(s.privateKey, s.account.Name, other, input.GetText()); err != nil {
+			fmt.Fprintf(view, "[red]%s[-]\n", sanitize.TviewLine(err.Error()))
+			return
+		}
+		input.SetText("")
+		fill()
+	})
+	layout := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(view, 0, 1, false).
+		AddItem(input, 1, 0, true)
+	s.show("conversation", layout, fill)
+}
+
+func (s *Session) showDoors() {
+	list := tview.NewList()
+	list.SetBorder(true).SetTitle(" Doors - Esc to go back ")
+	list.SetDoneFunc(s.showMenu)
+	for _, door := range s.node.Config.DoorList() {
+		door := door
+		list.AddItem(sanitize.TviewLine(door.Name), sanitize.TviewLine(door.Description), 0, func() { s.showDoor(door, "") })
+	}
+	s.show("doors", list, nil)
+}
+
+// showDoor shows the high scores of a door, with the outcome of the last game if there was one.
+func (s *Session) showDoor(door *doors.Door, outcome string) {
+	view := tview.NewTextView().SetDynamicColors(true)
+	view.SetBorder(true).SetTitle(fmt.Sprintf(" %s - Enter to play, Esc to go back ", sanitize.TviewLine(door.Name)))
+	fill := func() {
+		var text strings.Builder
+		if outcome != "" {
+			fmt.Fprintf(&text, "[yellow]%s[-]\n\n", sanitize.TviewLine(outcome))
+		}
+		fmt.Fprintf(&text, "%s\nTime limit %s\n\nHigh scores, as claimed by the players\n\n", sanitize.TviewLine(door.Description), door.Limit())
+		for i, score := range doors.HighScores(s.node.Boards.Store(), door.Name, 10) {
+			fmt.Fprintf(&text, "%2d. %-30s %6d  %s\n", i+1, sanitize.TviewLine(authorName(score.Nick, score.Author)), score.Score, score.Posted.Local().Format(timeFormat))
+		}
+		view.SetText(text.String())
+	}
+	fill()
+	view.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch event.Key() {
+		case tcell.KeyEnter:
+			s.runDoor(door)
+			return nil
+		case tcell.KeyEscape:
+			s.showDoors()
+			return nil
+		}
+		return event
+	})
+	s.show("door", view, fill)
+}
+
+// runDoor suspends the screen and lends the terminal to a door until it exits, then posts the
+// score it recorded to the score board.
+func (s *Session) runDoor(door *doors.Door) {
+	size, _ := s.tty.WindowSize()
+	info := s.node.DropInfo(s.account.Name, s.self, size.Width, size.Height)
+	var result *doors.Result
+	var err error
+	s.app.Suspend(func() {
+		input, release := s.tty.Lend()
+		defer release()
+		result, err = doors.Run(s.ctx, door, info, input, s.tty)
+	})
+	if err != nil {
+		s.showError(fmt.Errorf("%s failed: %w", door.Name, err), func() { s.showDoor(door, "") })
+		return
+	}
+	var outcome []string
+	if result.TimedOut {
+		outcome = append(outcome, "Your time ran out.")
+	}
+	if !result.Scored {
+		outcome = append(outcome, "No score recorded.")
+	} else if _, err = doors.PostScore(s.node.Boards, s.privateKey, s.account.Name, door.Name, result); err != nil {
+		s.showError(fmt.Errorf("unable to post your score: %w", err), func() { s.showDoor(door, "") })
+		return
+	} else {
+		outcome = append(outcome, fmt.Sprintf("You scored %d.", result.Score))
+	}
+	s.showDoor(door, strings.Join(outcome, " "))
+}
+
+func (s *Session) showError(err error, back func()) {
+	modal := tview.NewModal().
+		SetText(err.Error()).
+		AddButtons([]string{"OK"}).
+		SetDoneFunc(func(int, string) { back() })
+	s.show("error", modal, nil)
+}
+
+// resolve finds the peer a user means: a peer id, the name of a local account or a petname.
+func (s *Session) resolve(name string) (peer.ID, error) {
+	name = strings.TrimSpace(name)
+	if id, err := peer.Decode(name); err == nil {
+		return id, nil
+	}
+	if account, ok := s.accounts.Get(name); ok {
+		_, id, err := account.Identity()
+		return id, err
+	}
+	if id, ok := s.node.Names.Lookup(name, nil); ok {
+		return id, nil
+	}
+	return "", fmt.Errorf("no user or petname %s, use a peer id", name)
+}
+
+// peerName names a peer by petname, last seen nick or short id.
+func (s *Session) peerName(id peer.ID) string {
+	if petname, ok := s.node.Names.Petname(id); ok {
+		return petname
+	}
+	nick, _ := s.node.Names.Nick(id)
+	return authorName(nick, id)
+}
+
+// authorName shows a self declared nick with the last 8 chars of the peer id, since anyone can
+// claim any nick.
+func authorName(nick string, id peer.ID) string {
+	short := id.String()
+	if len(short) > 8 {
+		short = short[len(short)-8:]
+	}
+	if nick == "" {
+		return short
+	}
+	return nick + "#" + short
+}
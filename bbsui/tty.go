@@ -0,0 +1,203 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package bbsui
+
+import (
+	"io"
+	"sync"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// Tty adapts a network connection to the tcell.Tty interface so a BBS session can be drawn
+// on a remote terminal.  The front end owns the connection: it handles the terminal
+// negotiation of its protocol and reports size changes with Resize.
+type Tty struct {
+	rw io.ReadWriteCloser
+
+	mu       sync.Mutex
+	width    int
+	height   int
+	onResize func()
+	drain    chan struct{}
+	drained  bool
+
+	readMu    sync.Mutex
+	input     chan []byte
+	pending   []byte
+	readErr   error
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewTty wraps rw, a terminal of width by height cells.
+func NewTty(rw io.ReadWriteCloser, width int, height int) *Tty {
+	t := &Tty{
+		rw:     rw,
+		width:  width,
+		height: height,
+		drain:  make(chan struct{}),
+		input:  make(chan []byte),
+		done:   make(chan struct{}),
+	}
+	go t.readLoop()
+	return t
+}
+
+// Resize records a new terminal size and tells the screen about it.
+func (t *Tty) Resize(width int, height int) {
+	t.mu.Lock()
+	t.width, t.height = width, height
+	onResize := t.onResize
+	t.mu.Unlock()
+	if onResize != nil {
+		onResize()
+	}
+}
+
+// Start prepares the tty for a screen, the remote terminal is already in raw mode.
+func (t *Tty) Start() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.drained {
+		t.drain = make(chan struct{})
+		t.drained = false
+	}
+	return nil
+}
+
+// Stop does nothing, the connection stays open until the front end closes it.
+func (t *Tty) Stop() error {
+	return nil
+}
+
+// Drain wakes up a screen blocked in Read so it can shut down.
+func (t *Tty) Drain() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.drained {
+		close(t.drain)
+		t.drained = true
+	}
+	return nil
+}
+
+// NotifyResize registers the callback run by Resize.
+func (t *Tty) NotifyResize(cb func()) {
+	t.mu.Lock()
+	t.onResize = cb
+	t.mu.Unlock()
+}
+
+// WindowSize returns the size last reported by the front end.
+func (t *Tty) WindowSize() (tcell.WindowSize, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return tcell.WindowSize{Width: t.width, Height: t.height}, nil
+}
+
+// Read returns input from the connection, or nothing once the tty has been drained.
+func (t *Tty) Read(p []byte) (n int, err error) {
+	t.mu.Lock()
+	drain := t.drain
+	t.mu.Unlock()
+	n, err = t.read(p, drain)
+	return
+}
+
+// read returns input from the connection, or nothing once stop is closed.  Readers take
+// turns so a screen resuming after a program borrowed the tty can't race it for input.
+func (t *Tty) read(p []byte, stop <-chan struct{}) (n int, err error) {
+	t.readMu.Lock()
+	defer t.readMu.Unlock()
+	if len(t.pending) == 0 {
+		select {
+		case <-stop:
+			return 0, nil
+		default:
+		}
+		select {
+		case chunk, ok := <-t.input:
+			if !ok {
+				return 0, t.readErr
+			}
+			t.pending = chunk
+		case <-stop:
+			return 0, nil
+		}
+	}
+	n = copy(p, t.pending)
+	t.pending = t.pending[n:]
+	return
+}
+
+// Lend gives the input of the terminal to an external program while the screen is suspended.
+// The returned reader ends with io.EOF once release is called.
+func (t *Tty) Lend() (input io.Reader, release func()) {
+	stop := make(chan struct{})
+	var once sync.Once
+	return &lentInput{t: t, stop: stop}, func() { once.Do(func() { close(stop) }) }
+}
+
+// lentInput reads from a lent tty until it is released.
+type lentInput struct {
+	t    *Tty
+	stop chan struct{}
+}
+
+func (l *lentInput) Read(p []byte) (n int, err error) {
+	n, err = l.t.read(p, l.stop)
+	if n == 0 && err == nil {
+		err = io.EOF
+	}
+	return
+}
+
+// Write sends output to the connection.
+func (t *Tty) Write(p []byte) (int, error) {
+	return t.rw.Write(p)
+}
+
+// Close closes the connection.
+func (t *Tty) Close() (err error) {
+	t.closeOnce.Do(func() {
+		close(t.done)
+		err = t.rw.Close()
+	})
+	return
+}
+
+// readLoop moves input from the connection to Read, so a drained screen doesn't have to wait
+// for the remote user to press a key.
+func (t *Tty) readLoop() {
+	for {
+		buf := make([]byte, 256)
+		n, err := t.rw.Read(buf)
+		if n > 0 {
+			select {
+			case t.input <- buf[:n]:
+			case <-t.done:
+				return
+			}
+		}
+		if err != nil {
+			t.readErr = err
+			close(t.input)
+			return
+		}
+	}
+}
+
+// NewScreen returns a screen drawing on tty for a terminal of the given type, falling back to
+// xterm when the type is unknown.
+func NewScreen(tty *Tty, term string) (tcell.Screen, error) {
+	ti, err := tcell.LookupTerminfo(term)
+	if err != nil {
+		ti, err = tcell.LookupTerminfo("xterm")
+		if err != nil {
+			return nil, err
+		}
+	}
+	return tcell.NewTerminfoScreenFromTtyTerminfo(tty, ti)
+}
@@ -0,0 +1,181 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package boards
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/rightfoot-consulting/p2pbbs/bbscrypto"
+)
+
+const (
+	// MaxSubjectLength bounds the subject line of a post.
+	MaxSubjectLength = 128
+	// MaxBodyLength bounds the text of a post.
+	MaxBodyLength = 64 << 10
+	// MaxClockSkew is how far in the future a post may be dated before it is rejected.
+	MaxClockSkew = 10 * time.Minute
+)
+
+// validBoardName keeps board names usable as topic names, directory names, gopher selectors
+// and newsgroup names.
+var validBoardName = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,31}$`)
+
+// Post is a signed message on a board.  A post that starts a thread has no ReplyTo, replies
+// name the post they answer in ReplyTo and the first post of the thread in Thread.  ID is the
+// content id of the signed post and is what every other record uses to refer to it.  Stamp is a
+// proof of work over ID that boards can ask for, it is added after signing.
+type Post struct {
+	ID        string    `json:"id,omitempty"`
+	Board     string    `json:"board"`
+	Author    string    `json:"author"`
+	Nick      string    `json:"nick"`
+	Subject   string    `json:"subject"`
+	Body      string    `json:"body"`
+	ReplyTo   string    `json:"reply_to,omitempty"`
+	Thread    string    `json:"thread,omitempty"`
+	Created   time.Time `json:"created"`
+	PublicKey []byte    `json:"public_key,omitempty"`
+	Signature []byte    `json:"signature,omitempty"`
+	Stamp     uint64    `json:"stamp,omitempty"`
+}
+
+// ValidBoardName reports whether name can be used for a board.
+func ValidBoardName(name string) bool {
+	return validBoardName.MatchString(name)
+}
+
+// NewPost creates and signs a post on board by the owner of privateKey.  When parent is not
+// nil the post is a reply to it and joins its thread, an empty subject then defaults to the
+// parent's subject.
+func NewPost(privateKey crypto.PrivKey, nick string, board string, subject string, body string, parent *Post) (p *Post, err error) {
+	author, err := peer.IDFromPrivateKey(privateKey)
+	if err != nil {
+		return
+	}
+	p = &Post{
+		Board:   board,
+		Author:  author.String(),
+		Nick:    nick,
+		Subject: strings.TrimSpace(subject),
+		Body:    body,
+		Created: time.Now().UTC(),
+	}
+	if parent != nil {
+		p.Board = parent.Board
+		p.ReplyTo = parent.ID
+		p.Thread = parent.ThreadID()
+		if p.Subject == "" {
+			p.Subject = parent.Subject
+			if !strings.HasPrefix(p.Subject, "Re: ") {
+				p.Subject = "Re: " + p.Subject
+			}
+		}
+	}
+	p.PublicKey, err = bbscrypto.VerificationKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	data, err := p.signingBytes()
+	if err != nil {
+		return nil, err
+	}
+	p.Signature, err = privateKey.Sign(data)
+	if err != nil {
+		return nil, err
+	}
+	p.ID, err = p.contentID()
+	if err != nil {
+		return nil, err
+	}
+	if err = p.Verify(); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Verify checks that the post is well formed, signed by its author and that ID matches its
+// content.
+func (p *Post) Verify() (err error) {
+	if !ValidBoardName(p.Board) {
+		return fmt.Errorf("invalid board name %q", p.Board)
+	}
+	author, err := peer.Decode(p.Author)
+	if err != nil {
+		return
+	}
+	if p.Subject == "" || len(p.Subject) > MaxSubjectLength || strings.ContainsAny(p.Subject, "\r\n") {
+		return fmt.Errorf("invalid subject %q", p.Subject)
+	}
+	if len(p.Body) > MaxBodyLength {
+		return fmt.Errorf("post body is %d bytes, the limit is %d", len(p.Body), MaxBodyLength)
+	}
+	if (p.ReplyTo == "") != (p.Thread == "") {
+		return fmt.Errorf("a reply must name both the post it answers and its thread")
+	}
+	if p.Created.After(time.Now().Add(MaxClockSkew)) {
+		return fmt.Errorf("post created in the future: %v", p.Created)
+	}
+	data, err := p.signingBytes()
+	if err != nil {
+		return
+	}
+	if err = bbscrypto.Verify(author, p.PublicKey, data, p.Signature); err != nil {
+		return
+	}
+	id, err := p.contentID()
+	if err != nil {
+		return
+	}
+	if id != p.ID {
+		return fmt.Errorf("post id %s does not match its content %s", p.ID, id)
+	}
+	return
+}
+
+// AuthorID returns the decoded author, the post must have been verified.
+func (p *Post) AuthorID() peer.ID {
+	id, _ := peer.Decode(p.Author)
+	return id
+}
+
+// ThreadID returns the id of the first post of the thread the post belongs to.
+func (p *Post) ThreadID() string {
+	if p.Thread != "" {
+		return p.Thread
+	}
+	return p.ID
+}
+
+// IsThread reports whether the post starts a thread.
+func (p *Post) IsThread() bool {
+	return p.ReplyTo == ""
+}
+
+// signingBytes is the JSON encoding of the post without its id, signature or stamp.
+func (p *Post) signingBytes() ([]byte, error) {
+	unsigned := *p
+	unsigned.ID = ""
+	unsigned.Signature = nil
+	unsigned.Stamp = 0
+	return json.Marshal(&unsigned)
+}
+
+// contentID hashes the signed post without its id or stamp.
+func (p *Post) contentID() (string, error) {
+	content := *p
+	content.ID = ""
+	content.Stamp = 0
+	data, err := json.Marshal(&content)
+	if err != nil {
+		return "", err
+	}
+	return bbscrypto.ContentID(data)
+}
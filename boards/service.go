@@ -0,0 +1,183 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package boards
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	logging "github.com/ipfs/go-log/v2"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+var logger = logging.Logger("boards")
+
+// DefaultBoard is joined when the configuration doesn't name any boards.
+const DefaultBoard = "general"
+
+// Service publishes posts to the PubSub topic of their board and saves the verified posts
+// other peers publish there.
+type Service struct {
+	ctx   context.Context
+	ps    *pubsub.PubSub
+	store *Store
+
+	mu     sync.Mutex
+	topics map[string]*pubsub.Topic
+}
+
+// NewService returns a service that keeps the posts it receives in store.
+func NewService(ctx context.Context, ps *pubsub.PubSub, store *Store) *Service {
+	return &Service{
+		ctx:    ctx,
+		ps:     ps,
+		store:  store,
+		topics: make(map[string]*pubsub.Topic),
+	}
+}
+
+// Store returns the post store the service fills.
+func (s *Service) Store() *Store {
+	return s.store
+}
+
+// Join subscribes to the topic of a board so posts published there are saved.
+func (s *Service) Join(board string) (err error) {
+	if !ValidBoardName(board) {
+		return fmt.Errorf("invalid board name %q", board)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.topics[board]; ok {
+		return
+	}
+	if err = s.ps.RegisterTopicValidator(topicName(board), s.validate); err != nil {
+		return
+	}
+	topic, err := s.ps.Join(topicName(board))
+	if err != nil {
+		s.ps.UnregisterTopicValidator(topicName(board))
+		return
+	}
+	sub, err := topic.Subscribe()
+	if err != nil {
+		topic.Close()
+		s.ps.UnregisterTopicValidator(topicName(board))
+		return
+	}
+	s.topics[board] = topic
+	go s.readLoop(board, sub)
+	return
+}
+
+// Boards returns the boards we have joined or hold posts for, sorted.
+func (s *Service) Boards() []string {
+	names := s.store.Boards()
+	s.mu.Lock()
+	for board := range s.topics {
+		names = append(names, board)
+	}
+	s.mu.Unlock()
+	sort.Strings(names)
+	unique := names[:0]
+	for i, name := range names {
+		if i == 0 || names[i-1] != name {
+			unique = append(unique, name)
+		}
+	}
+	return unique
+}
+
+// Mine stamps a post with the proof of work its board asks for now, if it lacks one, as peers
+// check live posts against their current volume.  progress is called with the number of hashes
+// tried so far and the difficulty being mined.
+func (s *Service) Mine(ctx context.Context, p *Post, progress func(tried uint64, difficulty int)) (err error) {
+	difficulty := s.store.StampDifficulty(p.Board)
+	if p.StampBits() >= difficulty {
+		return
+	}
+	return p.Mine(ctx, difficulty, func(tried uint64) {
+		if progress != nil {
+			progress(tried, difficulty)
+		}
+	})
+}
+
+// Publish saves a post and sends it to the other peers on its board, joining the board first
+// if needed.  A post lacking the proof of work its board asks for is stamped first, which can
+// take a while, front ends call Mine beforehand to show progress.
+func (s *Service) Publish(p *Post) (err error) {
+	if err = s.Join(p.Board); err != nil {
+		return
+	}
+	if err = s.Mine(s.ctx, p, nil); err != nil {
+		return
+	}
+	if _, err = s.store.AddLive(p); err != nil {
+		return
+	}
+	msgBytes, err := json.Marshal(p)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	topic := s.topics[p.Board]
+	s.mu.Unlock()
+	return topic.Publish(s.ctx, msgBytes)
+}
+
+// readLoop saves the posts published on a board until the service's context ends.
+func (s *Service) readLoop(board string, sub *pubsub.Subscription) {
+	for {
+		msg, err := sub.Next(s.ctx)
+		if err != nil {
+			return
+		}
+		p := new(Post)
+		if err = json.Unmarshal(msg.Data, p); err != nil {
+			continue
+		}
+		if p.Board != board {
+			logger.Debugf("post %s for %s published on %s", p.ID, p.Board, board)
+			continue
+		}
+		if _, err = s.store.AddLive(p); err != nil {
+			logger.Debugf("rejected post from %s: %v", msg.GetFrom(), err)
+		}
+	}
+}
+
+// validate is the validator of board topics, rejecting malformed, forged and understamped posts
+// before they are delivered or forwarded.  Posts the moderators deleted or whose author they
+// silenced are ignored rather than rejected, as peers may not have heard of the action yet, and
+// so are posts only short of the difficulty the volume of the board adds, which every node
+// counts from the posts it received.
+func (s *Service) validate(_ context.Context, _ peer.ID, msg *pubsub.Message) pubsub.ValidationResult {
+	p := new(Post)
+	if err := json.Unmarshal(msg.Data, p); err != nil {
+		return pubsub.ValidationReject
+	}
+	if topicName(p.Board) != msg.GetTopic() {
+		return pubsub.ValidationReject
+	}
+	switch err := s.store.CheckLive(p); {
+	case err == nil:
+		return pubsub.ValidationAccept
+	case errors.Is(err, ErrDeleted) || errors.Is(err, ErrSilenced) || errors.Is(err, ErrBusy):
+		logger.Debugf("ignoring post from %s: %v", msg.GetFrom(), err)
+		return pubsub.ValidationIgnore
+	default:
+		logger.Debugf("rejecting post from %s: %v", msg.GetFrom(), err)
+		return pubsub.ValidationReject
+	}
+}
+
+func topicName(board string) string {
+	return "board:" + board
+}
@@ -0,0 +1,26 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package boards
+
+import (
+	"context"
+
+	"github.com/rightfoot-consulting/p2pbbs/pow"
+)
+
+// StampBits returns the difficulty of the post's stamp.
+func (p *Post) StampBits() int {
+	return pow.Bits([]byte(p.ID), p.Stamp)
+}
+
+// Mine stamps the signed post with a proof of work of at least difficulty bits, calling progress
+// with the number of hashes tried as it goes.  Mine gives up when ctx ends.
+func (p *Post) Mine(ctx context.Context, difficulty int, progress func(tried uint64)) (err error) {
+	nonce, err := pow.Mine(ctx, []byte(p.ID), difficulty, progress)
+	if err != nil {
+		return
+	}
+	p.Stamp = nonce
+	return
+}
@@ -0,0 +1,391 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package boards
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rightfoot-consulting/p2pbbs/moderation"
+)
+
+// StoreDir is the name of the directory in the data directory holding board posts.
+const StoreDir = "boards"
+
+var (
+	// ErrDeleted is returned for posts the moderators of their board deleted.
+	ErrDeleted = errors.New("the post was deleted by a moderator")
+	// ErrSilenced is returned for posts by authors the moderators of their board muted or banned.
+	ErrSilenced = errors.New("the author was silenced by a moderator")
+	// ErrUnderstamped is returned for posts lacking the proof of work their board asks for.
+	ErrUnderstamped = errors.New("the post lacks the proof of work its board asks for")
+	// ErrBusy is returned with ErrUnderstamped for posts stamped at the base difficulty of their
+	// board but short of the difficulty its recent volume adds.  Every node counts the posts it
+	// received, so another may rightly have accepted the post.
+	ErrBusy = errors.New("the board is busier than the stamp pays for")
+)
+
+// Thread summarises a thread on a board.  Pinned threads were pinned by a moderator.
+type Thread struct {
+	Root     *Post
+	Replies  int
+	LastPost time.Time
+	Pinned   bool
+}
+
+// Store keeps every verified post the node has seen, one JSON file per post in a directory per
+// board.  Posts are immutable so the files are written once and never rewritten, but they are
+// removed when a moderator deletes them.  When a post was received is the time its file was
+// written.
+type Store struct {
+	dir string
+
+	mu          sync.RWMutex
+	moderation  *moderation.Store
+	posts       map[string]*Post
+	boards      map[string][]*Post
+	received    map[string]time.Time
+	subscribers map[chan *Post]struct{}
+}
+
+// OpenStore loads the posts under dir, creating it if needed.  Files that no longer verify are
+// skipped.
+func OpenStore(dir string) (store *Store, err error) {
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return
+	}
+	store = &Store{
+		dir:         dir,
+		posts:       make(map[string]*Post),
+		boards:      make(map[string][]*Post),
+		received:    make(map[string]time.Time),
+		subscribers: make(map[chan *Post]struct{}),
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*", "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for _, file := range files {
+		jsonBytes, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		p := new(Post)
+		if json.Unmarshal(jsonBytes, p) != nil || p.Verify() != nil {
+			continue
+		}
+		received := now
+		if info, err := os.Stat(file); err == nil && info.ModTime().Before(now) {
+			received = info.ModTime()
+		}
+		store.index(p, received)
+	}
+	for board := range store.boards {
+		store.sortBoard(board)
+	}
+	return
+}
+
+// Moderate makes the store follow the moderators of its boards: deleted posts are removed
+// and refused, and peers who are muted or banned can't post.  Deletions arriving later are
+// applied as they come.
+func (s *Store) Moderate(m *moderation.Store) {
+	changes, _ := m.Subscribe()
+	s.mu.Lock()
+	s.moderation = m
+	for board := range s.boards {
+		s.purge(board)
+	}
+	s.mu.Unlock()
+	go func() {
+		for scope := range changes {
+			s.mu.Lock()
+			for board := range s.boards {
+				if moderation.BoardScope(board) == scope {
+					s.purge(board)
+				}
+			}
+			s.mu.Unlock()
+		}
+	}()
+}
+
+// Check verifies a post caught up on through history sync and checks its board accepts it: the
+// moderators didn't delete it or silence its author, and it carries the proof of work the board
+// asks for.  Posts older than the StampWindow were mined for a volume we can't know, so they only
+// need the base difficulty of the board, newer ones are checked like live posts.  Live posts are
+// checked with CheckLive.
+func (s *Store) Check(p *Post) error {
+	return s.check(p, time.Since(p.Created) < moderation.StampWindow)
+}
+
+// CheckLive checks a post arriving over PubSub like Check, except that the proof of work is the
+// one the board asks for now whenever it claims to have been created.  Created is chosen by the
+// author, so a post backdated to a quiet hour still pays for the posts the board is receiving.
+func (s *Store) CheckLive(p *Post) error {
+	return s.check(p, true)
+}
+
+// check checks a post, asking for the difficulty the volume of the board adds when live.
+func (s *Store) check(p *Post, live bool) (err error) {
+	if err = p.Verify(); err != nil {
+		return
+	}
+	moderators := s.moderators()
+	if moderators == nil {
+		return
+	}
+	view := moderators.View(moderation.BoardScope(p.Board))
+	if view.Deleted(p.ID) {
+		return ErrDeleted
+	}
+	// posts can be backdated, so a mute covers what arrives while it lasts
+	if view.Silenced(p.AuthorID(), time.Now()) {
+		return fmt.Errorf("%w: %s may not post on %s", ErrSilenced, p.Author, p.Board)
+	}
+	if policy := view.Stamp(); policy != nil {
+		switch bits := p.StampBits(); {
+		case bits < policy.Bits:
+			return fmt.Errorf("%w: %d bits", ErrUnderstamped, bits)
+		case live && !policy.Accepts(bits, s.recent(p.Board)):
+			return fmt.Errorf("%w, %w: %d bits", ErrUnderstamped, ErrBusy, bits)
+		}
+	}
+	return
+}
+
+// Add verifies a post caught up on through history sync and saves it if it is new and its board
+// accepts it.  Subscribers are told about new posts.
+func (s *Store) Add(p *Post) (added bool, err error) {
+	return s.add(p, time.Since(p.Created) < moderation.StampWindow)
+}
+
+// AddLive saves a post arriving over PubSub, or published here, like Add but checking it with
+// CheckLive.
+func (s *Store) AddLive(p *Post) (added bool, err error) {
+	return s.add(p, true)
+}
+
+// add saves a post, checked against the difficulty the volume of the board adds when live.
+func (s *Store) add(p *Post, live bool) (added bool, err error) {
+	if err = s.check(p, live); err != nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.posts[p.ID]; ok {
+		return
+	}
+	jsonBytes, err := json.MarshalIndent(p, "", "    ")
+	if err != nil {
+		return
+	}
+	boardDir := filepath.Join(s.dir, p.Board)
+	if err = os.MkdirAll(boardDir, 0700); err != nil {
+		return
+	}
+	if err = os.WriteFile(filepath.Join(boardDir, p.ID+".json"), jsonBytes, 0600); err != nil {
+		return
+	}
+	s.index(p, time.Now())
+	s.sortBoard(p.Board)
+	for ch := range s.subscribers {
+		select {
+		case ch <- p:
+		default:
+			// a slow subscriber misses live updates but can always re-read the store
+		}
+	}
+	return true, nil
+}
+
+// StampDifficulty returns the difficulty of the stamp a post to board must be mined at now, 0
+// when the board asks for no proof of work.
+func (s *Store) StampDifficulty(board string) int {
+	moderators := s.moderators()
+	if moderators == nil {
+		return 0
+	}
+	policy := moderators.View(moderation.BoardScope(board)).Stamp()
+	if policy == nil {
+		return 0
+	}
+	return policy.Required(s.recent(board))
+}
+
+// moderators returns the moderation store the boards follow, nil until Moderate is called.
+func (s *Store) moderators() *moderation.Store {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.moderation
+}
+
+// recent counts the posts on board received in the last StampWindow.  When they were created is
+// up to their authors, so it doesn't count.
+func (s *Store) recent(board string) (count int) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	from := time.Now().Add(-moderation.StampWindow)
+	for _, p := range s.boards[board] {
+		if s.received[p.ID].After(from) {
+			count++
+		}
+	}
+	return
+}
+
+// Get returns the post with the given id.
+func (s *Store) Get(id string) (*Post, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	p, ok := s.posts[id]
+	return p, ok
+}
+
+// Boards returns the names of the boards we hold posts for, sorted.
+func (s *Store) Boards() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	names := make([]string, 0, len(s.boards))
+	for name := range s.boards {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Posts returns every post on a board, oldest first.
+func (s *Store) Posts(board string) []*Post {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]*Post(nil), s.boards[board]...)
+}
+
+// Threads returns the threads on a board, pinned threads first and then the most recently
+// active.  Replies whose first post we haven't seen yet are left out until it arrives.
+func (s *Store) Threads(board string) []*Thread {
+	var view *moderation.View
+	if moderators := s.moderators(); moderators != nil {
+		view = moderators.View(moderation.BoardScope(board))
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	threads := make(map[string]*Thread)
+	for _, p := range s.boards[board] {
+		if p.IsThread() {
+			threads[p.ID] = &Thread{Root: p, LastPost: p.Created, Pinned: view != nil && view.IsPinned(p.ID)}
+		}
+	}
+	for _, p := range s.boards[board] {
+		if t, ok := threads[p.Thread]; ok && !p.IsThread() {
+			t.Replies++
+			if p.Created.After(t.LastPost) {
+				t.LastPost = p.Created
+			}
+		}
+	}
+	sorted := make([]*Thread, 0, len(threads))
+	for _, t := range threads {
+		sorted = append(sorted, t)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Pinned != sorted[j].Pinned {
+			return sorted[i].Pinned
+		}
+		return sorted[i].LastPost.After(sorted[j].LastPost)
+	})
+	return sorted
+}
+
+// Thread returns the first post of a thread followed by its replies, oldest first.
+func (s *Store) Thread(id string) []*Post {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	root, ok := s.posts[id]
+	if !ok {
+		return nil
+	}
+	posts := []*Post{root}
+	for _, p := range s.boards[root.Board] {
+		if p.Thread == id {
+			posts = append(posts, p)
+		}
+	}
+	return posts
+}
+
+// Replies returns the posts that answer the post with the given id, oldest first.
+func (s *Store) Replies(id string) []*Post {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	root, ok := s.posts[id]
+	if !ok {
+		return nil
+	}
+	replies := make([]*Post, 0)
+	for _, p := range s.boards[root.Board] {
+		if p.ReplyTo == id {
+			replies = append(replies, p)
+		}
+	}
+	return replies
+}
+
+// Subscribe returns a channel receiving every post added from now on.  Call the returned
+// function to stop receiving.
+func (s *Store) Subscribe() (<-chan *Post, func()) {
+	ch := make(chan *Post, 64)
+	s.mu.Lock()
+	s.subscribers[ch] = struct{}{}
+	s.mu.Unlock()
+	return ch, func() {
+		s.mu.Lock()
+		delete(s.subscribers, ch)
+		s.mu.Unlock()
+	}
+}
+
+// index adds a post received at time at to the in memory maps, the caller must hold the lock.
+func (s *Store) index(p *Post, at time.Time) {
+	s.posts[p.ID] = p
+	s.boards[p.Board] = append(s.boards[p.Board], p)
+	s.received[p.ID] = at
+}
+
+// purge removes the posts of a board its moderators deleted, the caller must hold the lock.
+func (s *Store) purge(board string) {
+	view := s.moderation.View(moderation.BoardScope(board))
+	kept := s.boards[board][:0]
+	for _, p := range s.boards[board] {
+		if !view.Deleted(p.ID) {
+			kept = append(kept, p)
+			continue
+		}
+		delete(s.posts, p.ID)
+		delete(s.received, p.ID)
+		if err := os.Remove(filepath.Join(s.dir, p.Board, p.ID+".json")); err != nil && !errors.Is(err, os.ErrNotExist) {
+			logger.Warnf("unable to remove deleted post %s: %v", p.ID, err)
+		}
+	}
+	s.boards[board] = kept
+}
+
+// sortBoard orders the posts of a board by creation time, the caller must hold the lock.
+func (s *Store) sortBoard(board string) {
+	posts := s.boards[board]
+	sort.SliceStable(posts, func(i, j int) bool {
+		if posts[i].Created.Equal(posts[j].Created) {
+			return strings.Compare(posts[i].ID, posts[j].ID) < 0
+		}
+		return posts[i].Created.Before(posts[j].Created)
+	})
+}
@@ -0,0 +1,222 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package boards
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/rightfoot-consulting/p2pbbs/moderation"
+)
+
+func TestThreads(t *testing.T) {
+	dir := t.TempDir()
+	store, err := OpenStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sk, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := NewPost(sk, "alice", "general", "Hello", "first post", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, err := NewPost(sk, "alice", "", "", "a reply", root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Subject != "Re: Hello" || reply.Board != "general" || reply.Thread != root.ID {
+		t.Errorf("reply not linked to its parent: %+v", reply)
+	}
+	// replies can arrive before the post they answer
+	for _, p := range []*Post{reply, root, root} {
+		if _, err = store.Add(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reopened, err := OpenStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	threads := reopened.Threads("general")
+	if len(threads) != 1 || threads[0].Root.ID != root.ID || threads[0].Replies != 1 {
+		t.Fatalf("unexpected threads %+v", threads)
+	}
+	posts := reopened.Thread(root.ID)
+	if len(posts) != 2 || posts[1].ID != reply.ID {
+		t.Errorf("unexpected thread %+v", posts)
+	}
+}
+
+func TestTamperedPost(t *testing.T) {
+	sk, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewPost(sk, "alice", "general", "Hello", "first post", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Body = "something else"
+	if err = p.Verify(); err == nil {
+		t.Errorf("tampered post verified")
+	}
+	if _, err = NewPost(sk, "alice", "Not A Board", "Hello", "", nil); err == nil {
+		t.Errorf("invalid board name accepted")
+	}
+}
+
+func TestModeratedStore(t *testing.T) {
+	store, err := OpenStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	owner, _, _ := crypto.GenerateEd25519Key(rand.Reader)
+	spammer, _, _ := crypto.GenerateEd25519Key(rand.Reader)
+	spammerID, _ := peer.IDFromPrivateKey(spammer)
+	ownerID, _ := peer.IDFromPrivateKey(owner)
+	older, _ := NewPost(owner, "owner", "general", "Rules", "be nice", nil)
+	spam, _ := NewPost(spammer, "spammer", "general", "Buy now", "cheap", nil)
+	for _, p := range []*Post{older, spam} {
+		if _, err = store.Add(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	scope := moderation.BoardScope("general")
+	decisions, err := moderation.LoadStore(filepath.Join(t.TempDir(), moderation.StoreFile), map[string]peer.ID{scope: ownerID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Moderate(decisions)
+	manifest, _ := moderation.NewManifest(owner, scope, nil, nil)
+	if _, err = decisions.AddManifest(manifest); err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range []struct {
+		kind   moderation.Kind
+		target string
+	}{
+		{moderation.Pin, older.ID},
+		{moderation.Delete, spam.ID},
+		{moderation.Ban, spammerID.String()},
+	} {
+		action, err := moderation.NewAction(owner, scope, a.kind, a.target, "", 0, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = decisions.AddAction(action); err != nil {
+			t.Fatal(err)
+		}
+	}
+	newer, _ := NewPost(owner, "owner", "general", "News", "later", nil)
+	if _, err = store.Add(newer); err != nil {
+		t.Fatal(err)
+	}
+
+	// the deletion is applied in the background
+	for i := 0; i < 100 && len(store.Posts("general")) != 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, ok := store.Get(spam.ID); ok {
+		t.Error("the deleted post is still in the store")
+	}
+	if _, err = store.Add(spam); err != ErrDeleted {
+		t.Errorf("a deleted post came back: %v", err)
+	}
+	more, _ := NewPost(spammer, "spammer", "general", "Buy more", "cheaper", nil)
+	if _, err = store.Add(more); err == nil {
+		t.Error("a banned peer posted")
+	}
+	threads := store.Threads("general")
+	if len(threads) != 2 || threads[0].Root.ID != older.ID || !threads[0].Pinned {
+		t.Errorf("the pinned thread isn't first: %+v", threads)
+	}
+}
+
+func TestStampedStore(t *testing.T) {
+	store, err := OpenStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	owner, _, _ := crypto.GenerateEd25519Key(rand.Reader)
+	ownerID, _ := peer.IDFromPrivateKey(owner)
+	decisions, err := moderation.LoadStore(filepath.Join(t.TempDir(), moderation.StoreFile), map[string]peer.ID{moderation.BoardScope("general"): ownerID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Moderate(decisions)
+	policy := &moderation.StampPolicy{Bits: 4, MaxBits: 12, TargetPerHour: 2}
+	manifest, err := moderation.NewManifest(owner, moderation.BoardScope("general"), nil, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = decisions.AddManifest(manifest); err != nil {
+		t.Fatal(err)
+	}
+	// stampedAt gives a post a stamp of exactly bits
+	stampedAt := func(p *Post, bits int) *Post {
+		for p.Stamp = 0; p.StampBits() != bits; p.Stamp++ {
+		}
+		return p
+	}
+
+	p, _ := NewPost(owner, "owner", "general", "Free", "for all", nil)
+	if _, err = store.Add(stampedAt(p, 3)); !errors.Is(err, ErrUnderstamped) || errors.Is(err, ErrBusy) {
+		t.Errorf("a post stamped below the base difficulty wasn't refused: %v", err)
+	}
+	// the difficulty rises a bit for every doubling of the posts beyond the target
+	for i := 0; i < 7; i++ {
+		p, _ = NewPost(owner, "owner", "general", "Busy", "board", nil)
+		if err = p.Mine(context.Background(), store.StampDifficulty("general"), nil); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = store.Add(p); err != nil {
+			t.Fatalf("post %d: %v", i, err)
+		}
+	}
+	p, _ = NewPost(owner, "owner", "general", "Late", "again", nil)
+	if difficulty := store.StampDifficulty("general"); difficulty != 6 {
+		t.Errorf("difficulty %d after 7 posts", difficulty)
+	}
+	if _, err = store.Add(stampedAt(p, 4)); !errors.Is(err, ErrBusy) {
+		t.Errorf("a post stamped below the adapted difficulty was accepted: %v", err)
+	}
+	// nodes count slightly different volumes, so one bit short is tolerated
+	if _, err = store.Add(stampedAt(p, 5)); err != nil {
+		t.Errorf("a post stamped a bit short was refused: %v", err)
+	}
+
+	// a post backdated to a quiet hour pays for the posts the board receives now when live, and
+	// history sync asks it for no less than the base difficulty
+	backdated, _ := NewPost(owner, "owner", "general", "Quiet", "hour", nil)
+	backdated.Created = backdated.Created.Add(-2 * moderation.StampWindow)
+	backdated.Signature = nil
+	data, _ := backdated.signingBytes()
+	backdated.Signature, _ = owner.Sign(data)
+	backdated.ID, _ = backdated.contentID()
+	if err = store.Check(stampedAt(backdated, policy.Bits-1)); !errors.Is(err, ErrUnderstamped) {
+		t.Errorf("a synced post stamped below the base difficulty was accepted: %v", err)
+	}
+	stampedAt(backdated, policy.Bits)
+	if err = store.CheckLive(backdated); !errors.Is(err, ErrBusy) {
+		t.Errorf("a backdated live post paid only the base stamp: %v", err)
+	}
+	if _, err = store.Add(backdated); err != nil {
+		t.Errorf("an old synced post was checked against the current volume: %v", err)
+	}
+	// it counts as received now however old it claims to be
+	if difficulty := store.StampDifficulty("general"); difficulty != 7 {
+		t.Errorf("difficulty %d after 9 posts", difficulty)
+	}
+}
@@ -1,85 +1,168 @@
-/*
-Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
-*/
-package chat
-
-import (
-	"encoding/json"
-	"fmt"
-	"os"
-	"strings"
-
-	maddr "github.com/multiformats/go-multiaddr"
-)
-
-type Configuration struct {
-	Port             int      `json:"port"`
-	RendezvousString string   `json:"redezvous_string"`
-	BootstrapPeers   []string `json:"bootstrap_peers"`
-	ListenIps        []string `json:"listen_ips"`
-	ProtocolID       string   `json:"protocol_id"`
-	KeyFile          string   `json:"key_file"`
-}
-
-func LoadChatConfig(filename string) (config *Configuration, err error) {
-	var cfg Configuration
-	jsonBytes, err := os.ReadFile(filename)
-	if err != nil {
-		return
-	}
-	err = json.Unmarshal(jsonBytes, &cfg)
-	if err == nil {
-		config = &cfg
-	}
-	return
-}
-
-func (cfg *Configuration) GetBootstrapPeers(exclude []string) (peers []maddr.Multiaddr, err error) {
-	peers = make([]maddr.Multiaddr, 0, len(cfg.BootstrapPeers))
-	for _, addrString := range cfg.BootstrapPeers {
-		if addrString == "" {
-			continue
-		}
-		var addr maddr.Multiaddr
-		addrString := strings.Replace(addrString, "//", "/", 1)
-		addr, err = maddr.NewMultiaddr(strings.Replace("//", "/", addrString, 1))
-		if err != nil {
-			peers = nil
-			return
-		}
-		// Don't add self to bootstrap peers
-		excluded := false
-		for _, e := range exclude {
-			if e == addr.String() {
-				excluded = true
-				break
-			}
-		}
-		if !excluded {
-			logger.Infof("Adding bootstrap peer: %s", addr.String())
-			peers = append(peers, addr)
-		}
-	}
-	return
-}
-
-func (cfg *Configuration) GetListenAddresses() (addresses []maddr.Multiaddr, err error) {
-	addresses = make([]maddr.Multiaddr, 0)
-	ipList := cfg.ListenIps
-	if len(ipList) < 1 {
-		fmt.Printf("Configuration has no IPs for listening, defaulting to 0.0.0.0\n")
-		ipList = append(ipList, "0.0.0.0")
-	}
-	fmt.Printf("iplist: %v\n", ipList)
-	for _, ipString := range ipList {
-		addrString := fmt.Sprintf("/ip4/%s/tcp/%d", ipString, cfg.Port)
-		var addr maddr.Multiaddr
-		addr, err = maddr.NewMultiaddr(addrString)
-		if err != nil {
-			addresses = nil
-			return
-		}
-		addresses = append(addresses, addr)
-	}
-	return
-}
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package chat
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/pnet"
+	maddr "github.com/multiformats/go-multiaddr"
+	"github.com/rightfoot-consulting/p2pbbs/transcript"
+)
+
+type Configuration struct {
+	Port             int                `json:"port"`
+	RendezvousString string             `json:"rendezvous_string"`
+	BootstrapPeers   []string           `json:"bootstrap_peers"`
+	ListenIps        []string           `json:"listen_ips"`
+	ProtocolID       string             `json:"protocol_id"`
+	KeyFile          string             `json:"key_file"`
+	DataDir          string             `json:"data_dir,omitempty"`
+	SwarmKey         string             `json:"swarm_key,omitempty"`
+	Transcripts      *transcript.Config `json:"transcripts,omitempty"`
+}
+
+const (
+	// DefaultDataDir holds a node's local state when the configuration doesn't name a directory.
+	DefaultDataDir = "~/.p2pbbs"
+	// SwarmKeySize is the size of the pre-shared key of a private swarm, it is written in
+	// configurations as hex.
+	SwarmKeySize = 32
+)
+
+func LoadChatConfig(filename string) (config *Configuration, err error) {
+	// settings missing from the transcripts section keep their defaults
+	cfg := Configuration{Transcripts: transcript.DefaultConfig()}
+	jsonBytes, err := os.ReadFile(filename)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(jsonBytes, &cfg)
+	if err == nil {
+		config = &cfg
+	}
+	return
+}
+
+func (cfg *Configuration) SaveChatConfig(filename string) (err error) {
+	jsonBytes, err := json.MarshalIndent(cfg, "", "    ")
+	if err != nil {
+		return
+	}
+	err = os.WriteFile(filename, jsonBytes, 0644)
+	return
+}
+
+func (cfg *Configuration) GetBootstrapPeers(exclude []string) (peers []maddr.Multiaddr, err error) {
+	peers = make([]maddr.Multiaddr, 0, len(cfg.BootstrapPeers))
+	for _, addrString := range cfg.BootstrapPeers {
+		if addrString == "" {
+			continue
+		}
+		var addr maddr.Multiaddr
+		addrString := strings.Replace(addrString, "//", "/", 1)
+		addr, err = maddr.NewMultiaddr(strings.Replace("//", "/", addrString, 1))
+		if err != nil {
+			peers = nil
+			return
+		}
+		// Don't add self to bootstrap peers
+		excluded := false
+		for _, e := range exclude {
+			if e == addr.String() {
+				excluded = true
+				break
+			}
+		}
+		if !excluded {
+			logger.Infof("Adding bootstrap peer: %s", addr.String())
+			peers = append(peers, addr)
+		}
+	}
+	return
+}
+
+func (cfg *Configuration) GetListenAddresses() (addresses []maddr.Multiaddr, err error) {
+	addresses = make([]maddr.Multiaddr, 0)
+	ipList := cfg.ListenIps
+	if len(ipList) < 1 {
+		fmt.Fprintf(os.Stderr, "Configuration has no IPs for listening, defaulting to 0.0.0.0\n")
+		ipList = append(ipList, "0.0.0.0")
+	}
+	fmt.Fprintf(os.Stderr, "iplist: %v\n", ipList)
+	for _, ipString := range ipList {
+		addrString := fmt.Sprintf("/ip4/%s/tcp/%d", ipString, cfg.Port)
+		var addr maddr.Multiaddr
+		addr, err = maddr.NewMultiaddr(addrString)
+		if err != nil {
+			addresses = nil
+			return
+		}
+		addresses = append(addresses, addr)
+	}
+	return
+}
+
+// DataDirectory returns the directory holding the node's local state, creating it if needed.
+// A leading ~ is expanded to the user's home directory.
+func (cfg *Configuration) DataDirectory() (dir string, err error) {
+	dir = cfg.DataDir
+	if dir == "" {
+		dir = DefaultDataDir
+	}
+	if dir == "~" || strings.HasPrefix(dir, "~/") {
+		var home string
+		home, err = os.UserHomeDir()
+		if err != nil {
+			return
+		}
+		dir = filepath.Join(home, dir[1:])
+	}
+	err = os.MkdirAll(dir, 0700)
+	return
+}
+
+// NewSwarmKey returns a random pre-shared key for a private swarm, as hex.
+func NewSwarmKey() (key string, err error) {
+	psk := make([]byte, SwarmKeySize)
+	if _, err = rand.Read(psk); err != nil {
+		return
+	}
+	key = hex.EncodeToString(psk)
+	return
+}
+
+// DecodeSwarmKey decodes the pre-shared key of a private swarm written as hex.
+func DecodeSwarmKey(key string) (psk pnet.PSK, err error) {
+	psk, err = hex.DecodeString(key)
+	if err == nil && len(psk) != SwarmKeySize {
+		err = fmt.Errorf("swarm keys are %d bytes, not %d", SwarmKeySize, len(psk))
+	}
+	if err != nil {
+		psk = nil
+	}
+	return
+}
+
+// PrivateNetwork returns the host options keeping the node to the private swarm of swarm_key,
+// none when the swarm is public.  Only peers holding the same key can connect to a private
+// swarm, and it runs over TCP and WebSocket only.
+func (cfg *Configuration) PrivateNetwork() (options []libp2p.Option, err error) {
+	if cfg.SwarmKey == "" {
+		return
+	}
+	psk, err := DecodeSwarmKey(cfg.SwarmKey)
+	if err != nil {
+		return
+	}
+	options = append(options, libp2p.PrivateNetwork(psk))
+	return
+}
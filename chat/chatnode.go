@@ -1,278 +1,442 @@
-/*
-Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
-*/
-package chat
-
-import (
-	"bufio"
-	"context"
-	"fmt"
-	"os"
-	"time"
-
-	log "github.com/ipfs/go-log/v2"
-	"github.com/libp2p/go-libp2p"
-	dht "github.com/libp2p/go-libp2p-kad-dht"
-	p2pconfig "github.com/libp2p/go-libp2p/config"
-	"github.com/libp2p/go-libp2p/core/crypto"
-	"github.com/libp2p/go-libp2p/core/network"
-	"github.com/libp2p/go-libp2p/core/peer"
-	"github.com/libp2p/go-libp2p/core/protocol"
-	drouting "github.com/libp2p/go-libp2p/p2p/discovery/routing"
-	dutil "github.com/libp2p/go-libp2p/p2p/discovery/util"
-	"github.com/multiformats/go-multiaddr"
-	"github.com/rightfoot-consulting/p2pbbs/bbscrypto"
-)
-
-var logger = log.Logger("chatnode")
-
-type ChatNode struct {
-	Config *Configuration
-}
-
-func NewChatNode(config *Configuration) (node *ChatNode, err error) {
-	node = &ChatNode{
-		Config: config,
-	}
-	return
-}
-
-func (node *ChatNode) Query() {
-	config := node.Config
-	listenAddresses, err := config.GetListenAddresses()
-	if err != nil {
-		panic(err)
-	}
-	if len(listenAddresses) < 1 {
-		err = fmt.Errorf("no Listen IPs configured")
-		panic(err)
-	}
-	var sk crypto.PrivKey = nil
-	if node.Config.KeyFile != "" {
-		sk, err = bbscrypto.LoadPrivateKey(config.KeyFile)
-		if err != nil {
-			panic(err)
-		}
-	}
-	var options []p2pconfig.Option = []p2pconfig.Option{
-		libp2p.ListenAddrs([]multiaddr.Multiaddr(listenAddresses)...),
-		libp2p.Identity(sk),
-	}
-	host, err := libp2p.New(options...)
-	if err != nil {
-		panic(err)
-	}
-	ourAddresses := make([]string, len(host.Addrs()))
-	for i, addr := range host.Addrs() {
-		ourAddresses[i] = addr.String() + "/p2p/" + host.ID().String()
-	}
-	bootstrapPeers, err := config.GetBootstrapPeers(ourAddresses)
-	if err != nil {
-		panic(err)
-	}
-
-	fmt.Printf("\nThis node will listen on the following addresses:\n")
-	for _, addr := range ourAddresses {
-		fmt.Printf("\t%s\n", addr)
-	}
-	if sk != nil {
-		id, err := peer.IDFromPrivateKey(sk)
-		if err != nil {
-			panic(err)
-		}
-		fmt.Printf("\nNode id will be: %s\n", id.String())
-	} else {
-		fmt.Printf("\nNode id will be random\n")
-	}
-	fmt.Println("Bootstrap Peers: ")
-	for _, addr := range bootstrapPeers {
-		fmt.Printf("\t%s\n", addr.String())
-	}
-}
-
-func (node *ChatNode) Run() {
-	log.SetAllLoggers(log.LevelWarn)
-	log.SetLogLevel("chatnode", "info")
-	config := node.Config
-
-	// libp2p.New constructs a new libp2p Host. Other options can be added
-	// here.
-	listenAddresses, err := config.GetListenAddresses()
-	if err != nil {
-		panic(err)
-	}
-	if len(listenAddresses) < 1 {
-		err = fmt.Errorf("no Listen IPs configured")
-		panic(err)
-	}
-	var sk crypto.PrivKey = nil
-	if node.Config.KeyFile != "" {
-		sk, err = bbscrypto.LoadPrivateKey(config.KeyFile)
-		if err != nil {
-			panic(err)
-		}
-	}
-
-	//  Set options for
-	var options []p2pconfig.Option = []p2pconfig.Option{
-		libp2p.ListenAddrs([]multiaddr.Multiaddr(listenAddresses)...),
-		libp2p.Identity(sk),
-	}
-	host, err := libp2p.New(options...)
-	if err != nil {
-		panic(err)
-	}
-	ourAddresses := make([]string, len(host.Addrs()))
-	for i, addr := range host.Addrs() {
-		ourAddresses[i] = addr.String() + "/p2p/" + host.ID().String()
-	}
-	logger.Info("Host created. We are:", ourAddresses)
-	logger.Info(host.Addrs())
-
-	// Set a function as stream handler. This function is called when a peer
-	// initiates a connection and starts a stream with this peer.
-	host.SetStreamHandler(protocol.ID(config.ProtocolID), handleStream)
-
-	// Start a DHT, for use in peer discovery. We can't just make a new DHT
-	// client because we want each peer to maintain its own local copy of the
-	// DHT, so that the bootstrapping node of the DHT can go down without
-	// inhibiting future peer discovery.
-	ctx := context.Background()
-	bsPeers, err := config.GetBootstrapPeers(ourAddresses)
-	if err != nil {
-		panic(err)
-	}
-	bootstrapPeers := make([]peer.AddrInfo, len(bsPeers))
-	for i, addr := range bsPeers {
-		var peerinfo *peer.AddrInfo
-		peerinfo, err = peer.AddrInfoFromP2pAddr(addr)
-		if err != nil {
-			err = fmt.Errorf("unable to get address info from address: %v", addr)
-			panic(err)
-		}
-		bootstrapPeers[i] = *peerinfo
-	}
-	logger.Infof("Final check of bootstrap peers: %v", bootstrapPeers)
-	kademliaDHT, err := dht.New(ctx, host, dht.BootstrapPeers(bootstrapPeers...), dht.Mode(dht.ModeAutoServer))
-	if err != nil {
-		panic(err)
-	}
-	kademliaDHT.RoutingTable().PeerAdded = func(id peer.ID) {
-		logger.Info("DHT Peer %s has been added.")
-	}
-	// Bootstrap the DHT. In the default configuration, this spawns a Background
-	// thread that will refresh the peer table every five minutes.
-	logger.Debug("Bootstrapping the DHT")
-	if err = kademliaDHT.Bootstrap(ctx); err != nil {
-		panic(err)
-	}
-
-	refreshed := true
-	for !refreshed {
-		logger.Info("Refreshing the DHT")
-		ch := kademliaDHT.RefreshRoutingTable()
-		err = <-ch
-		if err != nil {
-			logger.Errorf("Error refreshing routing table: %v", err)
-			// Wait a bit to let bootstrapping finish (really bootstrap should block until it's ready, but that isn't the case yet.)
-			time.Sleep(10 * time.Second)
-		} else {
-			refreshed = true
-		}
-	}
-	time.Sleep(1 * time.Second)
-
-	// We use a rendezvous point "meet me here" to announce our location.
-	// This is like telling your friends to meet you at the Eiffel Tower.
-	logger.Info("Announcing ourselves...")
-	routingDiscovery := drouting.NewRoutingDiscovery(kademliaDHT)
-	dutil.Advertise(ctx, routingDiscovery, config.RendezvousString)
-	logger.Debug("Successfully announced!")
-
-	// Now, look for others who have announced
-	// This is like your friend telling you the location to meet you.
-	logger.Debug("Searching for other peers...")
-	peerChan, err := routingDiscovery.FindPeers(ctx, config.RendezvousString)
-	if err != nil {
-		panic(err)
-	}
-
-	for peer := range peerChan {
-		if peer.ID == host.ID() {
-			continue
-		}
-		logger.Debug("Found peer:", peer)
-
-		logger.Debug("Connecting to:", peer)
-		stream, err := host.NewStream(ctx, peer.ID, protocol.ID(config.ProtocolID))
-
-		if err != nil {
-			logger.Warning("Connection failed:", err)
-			continue
-		} else {
-			rw := bufio.NewReadWriter(bufio.NewReader(stream), bufio.NewWriter(stream))
-			go writeData(rw)
-			go readData(rw)
-		}
-
-		logger.Info("Connected to:", peer)
-	}
-
-	select {}
-}
-
-func handleStream(stream network.Stream) {
-	logger.Info("Got a new stream!")
-
-	// Create a buffer stream for non-blocking read and write.
-	rw := bufio.NewReadWriter(bufio.NewReader(stream), bufio.NewWriter(stream))
-
-	go readData(rw)
-	go writeData(rw)
-
-	// 'stream' will stay open until you close it (or the other side closes it).
-}
-
-func readData(rw *bufio.ReadWriter) {
-	for {
-		str, err := rw.ReadString('\n')
-		if err != nil {
-			fmt.Println("Error reading from buffer")
-			panic(err)
-		}
-
-		if str == "" {
-			return
-		}
-		if str != "\n" {
-			// Green console colour: 	\x1b[32m
-			// Reset console colour: 	\x1b[0m
-			fmt.Printf("\x1b[32m%s\x1b[0m> ", str)
-		}
-
-	}
-}
-
-func writeData(rw *bufio.ReadWriter) {
-	stdReader := bufio.NewReader(os.Stdin)
-
-	for {
-		fmt.Print("> ")
-		sendData, err := stdReader.ReadString('\n')
-		if err != nil {
-			fmt.Println("Error reading from stdin")
-			panic(err)
-		}
-
-		_, err = rw.WriteString(fmt.Sprintf("%s\n", sendData))
-		if err != nil {
-			fmt.Println("Error writing to buffer")
-			panic(err)
-		}
-		err = rw.Flush()
-		if err != nil {
-			fmt.Println("Error flushing buffer")
-			panic(err)
-		}
-	}
-}
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package chat
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	p2pconfig "github.com/libp2p/go-libp2p/config"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	drouting "github.com/libp2p/go-libp2p/p2p/discovery/routing"
+	dutil "github.com/libp2p/go-libp2p/p2p/discovery/util"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/rightfoot-consulting/p2pbbs/bbscrypto"
+	"github.com/rightfoot-consulting/p2pbbs/bbsdht"
+	"github.com/rightfoot-consulting/p2pbbs/headless"
+	"github.com/rightfoot-consulting/p2pbbs/sanitize"
+	"github.com/rightfoot-consulting/p2pbbs/transcript"
+	"github.com/rightfoot-consulting/p2pbbs/trust"
+)
+
+var logger = log.Logger("chatnode")
+
+type ChatNode struct {
+	Config *Configuration
+	// Headless takes JSON commands from stdin and writes JSON events to stdout, without
+	// prompts or colours, so scripts can drive the node.
+	Headless bool
+	trust    *trust.Store
+	// exchanges endorsements with the peers we chat with, so their badges stay current
+	trustService *trust.Service
+	self         peer.ID
+	// transcript records the lines we send and receive, nil when transcripts are disabled
+	transcript *transcript.Log
+
+	host      host.Host
+	discovery *drouting.RoutingDiscovery
+	events    *headless.Writer
+
+	streamsLock sync.Mutex
+	streams     map[peer.ID]*bufio.ReadWriter
+}
+
+func NewChatNode(config *Configuration) (node *ChatNode, err error) {
+	node = &ChatNode{
+		Config: config,
+	}
+	return
+}
+
+func (node *ChatNode) Query() {
+	config := node.Config
+	listenAddresses, err := config.GetListenAddresses()
+	if err != nil {
+		panic(err)
+	}
+	if len(listenAddresses) < 1 {
+		err = fmt.Errorf("no Listen IPs configured")
+		panic(err)
+	}
+	var sk crypto.PrivKey = nil
+	if node.Config.KeyFile != "" {
+		sk, err = bbscrypto.LoadPrivateKey(config.KeyFile)
+		if err != nil {
+			panic(err)
+		}
+	}
+	var options []p2pconfig.Option = []p2pconfig.Option{
+		libp2p.ListenAddrs([]multiaddr.Multiaddr(listenAddresses)...),
+		libp2p.Identity(sk),
+	}
+	swarm, err := config.PrivateNetwork()
+	if err != nil {
+		panic(err)
+	}
+	options = append(options, swarm...)
+	host, err := libp2p.New(options...)
+	if err != nil {
+		panic(err)
+	}
+	ourAddresses := make([]string, len(host.Addrs()))
+	for i, addr := range host.Addrs() {
+		ourAddresses[i] = addr.String() + "/p2p/" + host.ID().String()
+	}
+	bootstrapPeers, err := config.GetBootstrapPeers(ourAddresses)
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Printf("\nThis node will listen on the following addresses:\n")
+	for _, addr := range ourAddresses {
+		fmt.Printf("\t%s\n", addr)
+	}
+	if sk != nil {
+		id, err := peer.IDFromPrivateKey(sk)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("\nNode id will be: %s\n", id.String())
+	} else {
+		fmt.Printf("\nNode id will be random\n")
+	}
+	fmt.Println("Bootstrap Peers: ")
+	for _, addr := range bootstrapPeers {
+		fmt.Printf("\t%s\n", addr.String())
+	}
+}
+
+func (node *ChatNode) Run() {
+	log.SetAllLoggers(log.LevelWarn)
+	log.SetLogLevel("chatnode", "info")
+	config := node.Config
+	if node.Headless {
+		node.events = headless.NewWriter(os.Stdout)
+		node.streams = make(map[peer.ID]*bufio.ReadWriter)
+	}
+
+	// libp2p.New constructs a new libp2p Host. Other options can be added
+	// here.
+	listenAddresses, err := config.GetListenAddresses()
+	if err != nil {
+		panic(err)
+	}
+	if len(listenAddresses) < 1 {
+		err = fmt.Errorf("no Listen IPs configured")
+		panic(err)
+	}
+	var sk crypto.PrivKey = nil
+	if node.Config.KeyFile != "" {
+		sk, err = bbscrypto.LoadPrivateKey(config.KeyFile)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	//  Set options for
+	var options []p2pconfig.Option = []p2pconfig.Option{
+		libp2p.ListenAddrs([]multiaddr.Multiaddr(listenAddresses)...),
+		libp2p.Identity(sk),
+	}
+	swarm, err := config.PrivateNetwork()
+	if err != nil {
+		panic(err)
+	}
+	options = append(options, swarm...)
+	host, err := libp2p.New(options...)
+	if err != nil {
+		panic(err)
+	}
+	ourAddresses := make([]string, len(host.Addrs()))
+	for i, addr := range host.Addrs() {
+		ourAddresses[i] = addr.String() + "/p2p/" + host.ID().String()
+	}
+	logger.Info("Host created. We are:", ourAddresses)
+	logger.Info(host.Addrs())
+	node.host = host
+
+	// Set a function as stream handler. This function is called when a peer
+	// initiates a connection and starts a stream with this peer.
+	host.SetStreamHandler(protocol.ID(config.ProtocolID), node.handleStream)
+
+	// Endorsements from the web of trust badge the peers we chat with.
+	dataDir, err := config.DataDirectory()
+	if err != nil {
+		panic(err)
+	}
+	node.trust, err = trust.LoadStore(filepath.Join(dataDir, trust.StoreFile))
+	if err != nil {
+		panic(err)
+	}
+	node.self = host.ID()
+	node.trustService = trust.NewService(host, node.trust)
+	if config.Transcripts == nil || !config.Transcripts.Disabled {
+		if config.Transcripts != nil && config.Transcripts.Encrypt && sk == nil {
+			panic(fmt.Errorf("encrypted transcripts need a static identity, use key_file"))
+		}
+		store, err := transcript.OpenStore(filepath.Join(dataDir, transcript.Dir), config.Transcripts, host.Peerstore().PrivKey(host.ID()))
+		if err != nil {
+			panic(err)
+		}
+		// there are no rooms in this chat, everything said around a rendezvous point is one
+		// transcript
+		node.transcript, err = store.Log(TranscriptRoom(config.RendezvousString), false)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	// Start a DHT, for use in peer discovery. We can't just make a new DHT
+	// client because we want each peer to maintain its own local copy of the
+	// DHT, so that the bootstrapping node of the DHT can go down without
+	// inhibiting future peer discovery.
+	ctx := context.Background()
+	bsPeers, err := config.GetBootstrapPeers(ourAddresses)
+	if err != nil {
+		panic(err)
+	}
+	bootstrapPeers := make([]peer.AddrInfo, len(bsPeers))
+	for i, addr := range bsPeers {
+		var peerinfo *peer.AddrInfo
+		peerinfo, err = peer.AddrInfoFromP2pAddr(addr)
+		if err != nil {
+			err = fmt.Errorf("unable to get address info from address: %v", addr)
+			panic(err)
+		}
+		bootstrapPeers[i] = *peerinfo
+	}
+	logger.Infof("Final check of bootstrap peers: %v", bootstrapPeers)
+	kademliaDHT, err := bbsdht.New(ctx, host, dht.ModeAutoServer, bootstrapPeers)
+	if err != nil {
+		panic(err)
+	}
+	kademliaDHT.RoutingTable().PeerAdded = func(id peer.ID) {
+		logger.Info("DHT Peer %s has been added.")
+	}
+	// Bootstrap the DHT. In the default configuration, this spawns a Background
+	// thread that will refresh the peer table every five minutes.
+	logger.Debug("Bootstrapping the DHT")
+	if err = kademliaDHT.Bootstrap(ctx); err != nil {
+		panic(err)
+	}
+
+	refreshed := true
+	for !refreshed {
+		logger.Info("Refreshing the DHT")
+		ch := kademliaDHT.RefreshRoutingTable()
+		err = <-ch
+		if err != nil {
+			logger.Errorf("Error refreshing routing table: %v", err)
+			// Wait a bit to let bootstrapping finish (really bootstrap should block until it's ready, but that isn't the case yet.)
+			time.Sleep(10 * time.Second)
+		} else {
+			refreshed = true
+		}
+	}
+	time.Sleep(1 * time.Second)
+
+	node.discovery = drouting.NewRoutingDiscovery(kademliaDHT)
+	// a headless node runs until its commands end, otherwise forever
+	var done chan struct{}
+	if node.Headless {
+		done = make(chan struct{})
+		go func() {
+			node.readCommands(ctx)
+			close(done)
+		}()
+	}
+	if err = node.join(ctx, config.RendezvousString); err != nil {
+		panic(err)
+	}
+
+	<-done
+}
+
+// join announces us at a rendezvous point and connects to the peers already there.
+func (node *ChatNode) join(ctx context.Context, rendezvous string) error {
+	// We use a rendezvous point "meet me here" to announce our location.
+	// This is like telling your friends to meet you at the Eiffel Tower.
+	logger.Info("Announcing ourselves...")
+	dutil.Advertise(ctx, node.discovery, rendezvous)
+	logger.Debug("Successfully announced!")
+
+	// Now, look for others who have announced
+	// This is like your friend telling you the location to meet you.
+	logger.Debug("Searching for other peers...")
+	peerChan, err := node.discovery.FindPeers(ctx, rendezvous)
+	if err != nil {
+		return err
+	}
+
+	for peer := range peerChan {
+		if peer.ID == node.host.ID() {
+			continue
+		}
+		logger.Debug("Found peer:", peer)
+		node.connect(ctx, peer.ID)
+	}
+	return nil
+}
+
+// connect opens a chat stream to a peer.
+func (node *ChatNode) connect(ctx context.Context, id peer.ID) error {
+	logger.Debug("Connecting to:", id)
+	stream, err := node.host.NewStream(ctx, id, protocol.ID(node.Config.ProtocolID))
+	if err != nil {
+		logger.Warning("Connection failed:", err)
+		return err
+	}
+	node.chatOver(stream)
+	logger.Info("Connected to:", id)
+	return nil
+}
+
+func (node *ChatNode) handleStream(stream network.Stream) {
+	logger.Info("Got a new stream!")
+	node.chatOver(stream)
+
+	// 'stream' will stay open until you close it (or the other side closes it).
+}
+
+// chatOver starts chatting with the peer at the other end of stream.
+func (node *ChatNode) chatOver(stream network.Stream) {
+	// Create a buffer stream for non-blocking read and write.
+	rw := bufio.NewReadWriter(bufio.NewReader(stream), bufio.NewWriter(stream))
+	remote := stream.Conn().RemotePeer()
+	node.trustService.ExchangeOnce(context.Background(), []peer.ID{remote})
+
+	if node.Headless {
+		node.streamsLock.Lock()
+		node.streams[remote] = rw
+		node.streamsLock.Unlock()
+		node.events.Write(&headless.Event{Type: headless.PeerJoined, Peer: remote.String(), Trust: node.trust.Evaluate(node.self, remote).Status.String()})
+	} else {
+		go node.writeData(rw)
+	}
+	go node.readData(rw, remote)
+}
+
+func (node *ChatNode) readData(rw *bufio.ReadWriter, remote peer.ID) {
+	for {
+		str, err := rw.ReadString('\n')
+		if err != nil && node.Headless {
+			node.streamsLock.Lock()
+			if node.streams[remote] == rw {
+				delete(node.streams, remote)
+			}
+			node.streamsLock.Unlock()
+			node.events.Write(&headless.Event{Type: headless.PeerLeft, Peer: remote.String()})
+			return
+		}
+		if err != nil {
+			fmt.Println("Error reading from buffer")
+			panic(err)
+		}
+
+		if str == "" {
+			return
+		}
+		node.record(remote, str)
+		if node.Headless {
+			if text := strings.TrimRight(str, "\r\n"); text != "" {
+				node.events.Write(&headless.Event{
+					Type:  headless.Message,
+					From:  remote.String(),
+					Trust: node.trust.Evaluate(node.self, remote).Status.String(),
+					Text:  text,
+				})
+			}
+			continue
+		}
+		if str != "\n" {
+			// Green console colour: 	\x1b[32m
+			// Reset console colour: 	\x1b[0m
+			// the badge is looked up for every message, endorsements may arrive at any time
+			fmt.Printf("%s \x1b[32m%s\x1b[0m\n> ", node.trustBadge(remote), sanitize.Line(strings.TrimRight(str, "\r\n")))
+		}
+
+	}
+}
+
+// trustBadge marks the messages of a peer with what the web of trust says about it,
+// followed by the last 8 chars of its peer id.
+func (node *ChatNode) trustBadge(remote peer.ID) string {
+	pretty := remote.String()
+	short := pretty[len(pretty)-8:]
+	switch node.trust.Evaluate(node.self, remote).Status {
+	case trust.Trusted:
+		// Green console colour: 	\x1b[32m
+		return fmt.Sprintf("\x1b[32m✔ %s\x1b[0m", short)
+	case trust.Distrusted:
+		// Red console colour: 	\x1b[31m
+		return fmt.Sprintf("\x1b[31m✘ %s\x1b[0m", short)
+	default:
+		// Yellow console colour: 	\x1b[33m
+		return fmt.Sprintf("\x1b[33m? %s\x1b[0m", short)
+	}
+}
+
+func (node *ChatNode) writeData(rw *bufio.ReadWriter) {
+	stdReader := bufio.NewReader(os.Stdin)
+
+	for {
+		fmt.Print("> ")
+		sendData, err := stdReader.ReadString('\n')
+		if err != nil {
+			fmt.Println("Error reading from stdin")
+			panic(err)
+		}
+
+		_, err = rw.WriteString(fmt.Sprintf("%s\n", sendData))
+		if err != nil {
+			fmt.Println("Error writing to buffer")
+			panic(err)
+		}
+		err = rw.Flush()
+		if err != nil {
+			fmt.Println("Error flushing buffer")
+			panic(err)
+		}
+		node.record(node.self, sendData)
+	}
+}
+
+// TranscriptRoom names the transcript of the chat around a rendezvous point, apart from the
+// chatv2 rooms.
+func TranscriptRoom(rendezvous string) string {
+	return "chat:" + rendezvous
+}
+
+// record adds a line sent or received to the transcript, peers are named by the end of their
+// peer id as in the chat.
+func (node *ChatNode) record(from peer.ID, text string) {
+	text = strings.TrimRight(text, "\r\n")
+	if node.transcript == nil || text == "" {
+		return
+	}
+	pretty := from.String()
+	entry := &transcript.Entry{
+		Time: time.Now(),
+		Room: node.transcript.Room(),
+		From: pretty,
+		Nick: pretty[len(pretty)-8:],
+		Text: text,
+	}
+	if err := node.transcript.Append(entry); err != nil {
+		logger.Warnf("unable to add to the transcript: %v", err)
+	}
+}
@@ -0,0 +1,104 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package chat
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/rightfoot-consulting/p2pbbs/headless"
+)
+
+// readCommands runs the JSON commands read from stdin.  There are no rooms in this chat: join
+// connects to a peer address, or announces us at another rendezvous point when given a room,
+// send goes to every connected peer and dm to one of them.
+func (node *ChatNode) readCommands(ctx context.Context) {
+	err := headless.ReadCommands(os.Stdin, node.events, func(c *headless.Command) error {
+		switch c.Type {
+		case headless.Send:
+			if err := node.sendAll(c.Text); err != nil {
+				return err
+			}
+			node.record(node.self, c.Text)
+			return nil
+		case headless.DM:
+			id, err := peer.Decode(c.Peer)
+			if err != nil {
+				return fmt.Errorf("%s is not a peer id", c.Peer)
+			}
+			if err = node.sendTo(id, c.Text); err != nil {
+				return err
+			}
+			node.record(node.self, c.Text)
+			return nil
+		case headless.Join:
+			if c.Peer != "" {
+				info, err := peer.AddrInfoFromString(c.Peer)
+				if err != nil {
+					return err
+				}
+				if err = node.host.Connect(ctx, *info); err != nil {
+					return err
+				}
+				return node.connect(ctx, info.ID)
+			}
+			if c.Room == "" {
+				return fmt.Errorf("give a peer address or a rendezvous string as room")
+			}
+			go func() {
+				if err := node.join(ctx, c.Room); err != nil {
+					node.events.Errorf("join: %v", err)
+				}
+			}()
+			return nil
+		default:
+			return fmt.Errorf("unknown command")
+		}
+	})
+	if err != nil {
+		node.events.Errorf("reading commands: %v", err)
+	}
+}
+
+// sendAll sends a line to every connected peer.
+func (node *ChatNode) sendAll(text string) error {
+	node.streamsLock.Lock()
+	ids := make([]peer.ID, 0, len(node.streams))
+	for id := range node.streams {
+		ids = append(ids, id)
+	}
+	node.streamsLock.Unlock()
+	if len(ids) == 0 {
+		return fmt.Errorf("no peers connected")
+	}
+	for _, id := range ids {
+		if err := node.sendTo(id, text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendTo sends a line to a connected peer.
+func (node *ChatNode) sendTo(id peer.ID, text string) error {
+	if strings.ContainsAny(text, "\r\n") {
+		return fmt.Errorf("messages are a single line")
+	}
+	if text == "" {
+		return fmt.Errorf("no text given")
+	}
+	node.streamsLock.Lock()
+	defer node.streamsLock.Unlock()
+	rw, ok := node.streams[id]
+	if !ok {
+		return fmt.Errorf("not connected to %s", id)
+	}
+	if _, err := rw.WriteString(text + "\n"); err != nil {
+		return err
+	}
+	return rw.Flush()
+}
@@ -1,116 +1,266 @@
-/*
-Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
-This code borrows heavily from:
-
-	https://github.com/libp2p/go-libp2p/blob/master/examples/pubsub/chat/chatroom.go
-*/
-package chatv2
-
-import (
-	"context"
-	"encoding/json"
-
-	"github.com/libp2p/go-libp2p/core/peer"
-)
-
-// ChatRoomBufSize is the number of incoming messages to buffer for each topic.
-const ChatRoomBufSize = 128
-
-// ChatRoom represents a subscription to a single PubSub topic. Messages
-// can be published to the topic with ChatRoom.Publish, and received
-// messages are pushed to the Messages channel.
-type ChatRoom struct {
-	// Messages is a channel of messages received from other peers in the chat room
-	Messages chan *ChatMessage
-
-	ctx   context.Context
-	ps    *pubsub.PubSub
-	topic *pubsub.Topic
-	sub   *pubsub.Subscription
-
-	roomName string
-	self     peer.ID
-	nick     string
-}
-
-// ChatMessage gets converted to/from JSON and sent in the body of pubsub messages.
-type ChatMessage struct {
-	Message    string
-	SenderID   string
-	SenderNick string
-}
-
-// JoinChatRoom tries to subscribe to the PubSub topic for the room name, returning
-// a ChatRoom on success.
-func JoinChatRoom(ctx context.Context, ps *pubsub.PubSub, selfID peer.ID, nickname string, roomName string) (*ChatRoom, error) {
-	// join the pubsub topic
-	topic, err := ps.Join(topicName(roomName))
-	if err != nil {
-		return nil, err
-	}
-
-	// and subscribe to it
-	sub, err := topic.Subscribe()
-	if err != nil {
-		return nil, err
-	}
-
-	cr := &ChatRoom{
-		ctx:      ctx,
-		ps:       ps,
-		topic:    topic,
-		sub:      sub,
-		self:     selfID,
-		nick:     nickname,
-		roomName: roomName,
-		Messages: make(chan *ChatMessage, ChatRoomBufSize),
-	}
-
-	// start reading messages from the subscription in a loop
-	go cr.readLoop()
-	return cr, nil
-}
-
-// Publish sends a message to the pubsub topic.
-func (cr *ChatRoom) Publish(message string) error {
-	m := ChatMessage{
-		Message:    message,
-		SenderID:   cr.self.String(),
-		SenderNick: cr.nick,
-	}
-	msgBytes, err := json.Marshal(m)
-	if err != nil {
-		return err
-	}
-	return cr.topic.Publish(cr.ctx, msgBytes)
-}
-
-func (cr *ChatRoom) ListPeers() []peer.ID {
-	return cr.ps.ListPeers(topicName(cr.roomName))
-}
-
-// readLoop pulls messages from the pubsub topic and pushes them onto the Messages channel.
-func (cr *ChatRoom) readLoop() {
-	for {
-		msg, err := cr.sub.Next(cr.ctx)
-		if err != nil {
-			close(cr.Messages)
-			return
-		}
-		// only forward messages delivered by others
-		if msg.ReceivedFrom == cr.self {
-			continue
-		}
-		cm := new(ChatMessage)
-		err = json.Unmarshal(msg.Data, cm)
-		if err != nil {
-			continue
-		}
-		// send valid messages onto the Messages channel
-		cr.Messages <- cm
-	}
-}
-
-func topicName(roomName string) string {
-	return "chat-room:" + roomName
-}
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+This code borrows heavily from:
+
+	https://github.com/libp2p/go-libp2p/blob/master/examples/pubsub/chat/chatroom.go
+*/
+package chatv2
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/rightfoot-consulting/p2pbbs/moderation"
+	"github.com/rightfoot-consulting/p2pbbs/private"
+)
+
+// ChatRoomBufSize is the number of incoming messages to buffer for each topic.
+const ChatRoomBufSize = 128
+
+// ErrSilenced is returned when publishing to a room whose moderators muted or banned us.
+var ErrSilenced = errors.New("the moderators of this room have muted or banned you")
+
+// ChatRoom represents a subscription to a single PubSub topic. Messages
+// can be published to the topic with ChatRoom.Publish, and received
+// messages are pushed to the Messages channel.
+type ChatRoom struct {
+	// Messages is a channel of messages received from other peers in the chat room
+	Messages chan *ChatMessage
+
+	ctx   context.Context
+	ps    *pubsub.PubSub
+	topic *pubsub.Topic
+	sub   *pubsub.Subscription
+
+	roomName   string
+	self       peer.ID
+	nick       string
+	moderation *moderation.Store
+
+	// the id of a private room and the keys its messages are sealed with, empty for public rooms
+	private string
+	keys    *private.Store
+
+	thread   *Thread
+	presence *Presence
+}
+
+// ChatMessage gets converted to/from JSON and sent in the body of pubsub messages.  Messages
+// with an ID can be referred to by later ones: a reply names the message it answers in
+// ReplyTo, a reaction the message it reacts to in Reaction with the reaction as its text, and
+// Edits and Deletes change an earlier message of the same sender.  Clients that don't know the
+// references show the text, which is why a deletion carries text too.
+type ChatMessage struct {
+	Message    string
+	SenderID   string
+	SenderNick string
+	Timestamp  time.Time
+	ID         string `json:",omitempty"`
+	ReplyTo    string `json:",omitempty"`
+	Reaction   string `json:",omitempty"`
+	Edits      string `json:",omitempty"`
+	Deletes    string `json:",omitempty"`
+}
+
+// subscribeChatRoom returns a ChatRoom reading its own subscription to an already joined
+// topic, which lets several local users share the topic of a room.  Messages from peers the
+// moderators of the room silenced are dropped when decisions isn't nil.  The messages of the
+// private room with id privateRoom are sealed with the keys in keys.
+func subscribeChatRoom(ctx context.Context, ps *pubsub.PubSub, topic *pubsub.Topic, selfID peer.ID, nickname string, roomName string, decisions *moderation.Store, keys *private.Store, privateRoom string) (*ChatRoom, error) {
+	// subscribe to the topic
+	sub, err := topic.Subscribe()
+	if err != nil {
+		return nil, err
+	}
+
+	cr := &ChatRoom{
+		ctx:        ctx,
+		ps:         ps,
+		topic:      topic,
+		sub:        sub,
+		self:       selfID,
+		nick:       nickname,
+		roomName:   roomName,
+		moderation: decisions,
+		private:    privateRoom,
+		keys:       keys,
+		thread:     NewThread(),
+		Messages:   make(chan *ChatMessage, ChatRoomBufSize),
+	}
+
+	// start reading messages from the subscription in a loop
+	go cr.readLoop()
+	return cr, nil
+}
+
+// Publish sends a message to the pubsub topic.
+func (cr *ChatRoom) Publish(message string) error {
+	return cr.PublishMessage(&ChatMessage{Message: message})
+}
+
+// PublishMessage sends a message with the references set in cm to the pubsub topic.  The
+// sender, the time and an ID when cm has none are filled in, and the message is added to the
+// thread of the room.
+func (cr *ChatRoom) PublishMessage(cm *ChatMessage) error {
+	if cr.Moderation().Silenced(cr.self, time.Now()) {
+		return ErrSilenced
+	}
+	if cm.ID == "" {
+		cm.ID = NewMessageID()
+	}
+	if cm.Deletes != "" && cm.Message == "" {
+		cm.Message = DeletedText
+	}
+	cm.SenderID = cr.self.String()
+	cm.SenderNick = cr.nick
+	cm.Timestamp = time.Now().UTC()
+	msgBytes, err := json.Marshal(cm)
+	if err != nil {
+		return err
+	}
+	if cr.Private() {
+		err = publishPrivate(cr.ctx, cr.topic, cr.keys, cr.private, msgBytes)
+	} else {
+		err = cr.topic.Publish(cr.ctx, msgBytes)
+	}
+	if err == nil {
+		cr.thread.Add(cm)
+		if cr.presence != nil {
+			cr.presence.Touch()
+		}
+	}
+	return err
+}
+
+func (cr *ChatRoom) ListPeers() []peer.ID {
+	return cr.ps.ListPeers(cr.topic.String())
+}
+
+// Name returns the name of the room.
+func (cr *ChatRoom) Name() string {
+	return cr.roomName
+}
+
+// Private reports whether the room is a private room, whose messages only its members can read.
+func (cr *ChatRoom) Private() bool {
+	return cr.private != ""
+}
+
+// Members returns the newest membership record of a private room.
+func (cr *ChatRoom) Members() (*private.Membership, bool) {
+	if !cr.Private() {
+		return nil, false
+	}
+	return cr.keys.Latest(cr.private)
+}
+
+// Nick returns the nick we publish under.
+func (cr *ChatRoom) Nick() string {
+	return cr.nick
+}
+
+// Thread returns the messages of the room seen since it was joined, with their replies,
+// reactions and edits.
+func (cr *ChatRoom) Thread() *Thread {
+	return cr.thread
+}
+
+// Moderation returns the state of the room once its moderators' actions are applied.
+func (cr *ChatRoom) Moderation() *moderation.View {
+	if cr.moderation == nil {
+		return &moderation.View{Scope: moderation.RoomScope(cr.roomName)}
+	}
+	return cr.moderation.View(moderation.RoomScope(cr.roomName))
+}
+
+// Presence returns who is in the room and what they are doing, nil when the room was joined
+// without presence.
+func (cr *ChatRoom) Presence() *Presence {
+	return cr.presence
+}
+
+// Leave cancels the subscription to the room, which closes the Messages channel, and tells the
+// room we left.  The topic stays joined for other local users.
+func (cr *ChatRoom) Leave() {
+	if cr.presence != nil {
+		cr.presence.Leave()
+	}
+	cr.sub.Cancel()
+}
+
+// readLoop pulls messages from the pubsub topic and pushes them onto the Messages channel.
+func (cr *ChatRoom) readLoop() {
+	for {
+		msg, err := cr.sub.Next(cr.ctx)
+		if err != nil {
+			close(cr.Messages)
+			return
+		}
+		cm, ok := chatMessage(msg, cr.Private())
+		if !ok {
+			continue
+		}
+		// only forward messages from others, which includes other local users of our node
+		if msg.ReceivedFrom == cr.self && cm.SenderNick == cr.nick {
+			continue
+		}
+		if cr.Moderation().Silenced(msg.GetFrom(), time.Now()) {
+			continue
+		}
+		cr.thread.Add(cm)
+		// send valid messages onto the Messages channel
+		cr.Messages <- cm
+	}
+}
+
+// chatMessage returns the chat message carried by a message on the topic of a room.  The
+// validator of a private room already opened it, its membership records carry none.
+func chatMessage(msg *pubsub.Message, private bool) (cm *ChatMessage, ok bool) {
+	if private {
+		// every subscription to the topic gets the same message, so it is copied
+		opened, ok := msg.ValidatorData.(*ChatMessage)
+		if !ok {
+			return nil, false
+		}
+		copied := *opened
+		cm = &copied
+	} else {
+		cm = new(ChatMessage)
+		if json.Unmarshal(msg.Data, cm) != nil {
+			return nil, false
+		}
+	}
+	// the sender id in the body is only a claim, the pubsub signature tells us who sent it
+	cm.SenderID = msg.GetFrom().String()
+	return cm, true
+}
+
+// joinRoomTopic joins the topic of a room, registering the validator every message on it must
+// pass and scoring the peers on it unless scoring is disabled.
+func joinRoomTopic(ps *pubsub.PubSub, roomName string, scoring *ScoringConfig) (topic *pubsub.Topic, err error) {
+	name := topicName(roomName)
+	if err = ps.RegisterTopicValidator(name, newMessageValidator(scoring).Validate); err != nil {
+		return
+	}
+	topic, err = ps.Join(name)
+	if err != nil {
+		ps.UnregisterTopicValidator(name)
+		return
+	}
+	if !scoring.Disabled {
+		if err = topic.SetScoreParams(scoring.topicScoreParams()); err != nil {
+			topic.Close()
+			ps.UnregisterTopicValidator(name)
+			return nil, err
+		}
+	}
+	return
+}
+
+func topicName(roomName string) string {
+	return "chat-room:" + roomName
+}
@@ -1,191 +1,457 @@
-/*
-Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
-This code borrows heavily from:
-
-	https://github.com/libp2p/go-libp2p/blob/master/examples/pubsub/chat/ui.go
-*/
-package chatv2
-
-import (
-	"fmt"
-	"io"
-	"time"
-
-	"github.com/gdamore/tcell"
-	"github.com/rivo/tview"
-)
-
-// ChatUI is a Text User Interface (TUI) for a ChatRoom.
-// The Run method will draw the UI to the terminal in "fullscreen"
-// mode. You can quit with Ctrl-C, or by typing "/quit" into the
-// chat prompt.
-type ChatUI struct {
-	cr        *ChatRoom
-	app       *tview.Application
-	peersList *tview.TextView
-
-	msgW    io.Writer
-	inputCh chan string
-	doneCh  chan struct{}
-}
-
-// NewChatUI returns a new ChatUI struct that controls the text UI.
-// It won't actually do anything until you call Run().
-func NewChatUI(cr *ChatRoom) *ChatUI {
-	app := tview.NewApplication()
-
-	// make a text view to contain our chat messages
-	msgBox := tview.NewTextView()
-	msgBox.SetDynamicColors(true)
-	msgBox.SetBorder(true)
-	msgBox.SetTitle(fmt.Sprintf("Room: %s", cr.roomName))
-
-	// text views are io.Writers, but they don't automatically refresh.
-	// this sets a change handler to force the app to redraw when we get
-	// new messages to display.
-	msgBox.SetChangedFunc(func() {
-		app.Draw()
-	})
-
-	// an input field for typing messages into
-	inputCh := make(chan string, 32)
-	input := tview.NewInputField().
-		SetLabel(cr.nick + " > ").
-		SetFieldWidth(0).
-		SetFieldBackgroundColor(tcell.ColorBlack)
-
-	// the done func is called when the user hits enter, or tabs out of the field
-	input.SetDoneFunc(func(key tcell.Key) {
-		if key != tcell.KeyEnter {
-			// we don't want to do anything if they just tabbed away
-			return
-		}
-		line := input.GetText()
-		if len(line) == 0 {
-			// ignore blank lines
-			return
-		}
-
-		// bail if requested
-		if line == "/quit" {
-			app.Stop()
-			return
-		}
-
-		// send the line onto the input chan and reset the field text
-		inputCh <- line
-		input.SetText("")
-	})
-
-	// make a text view to hold the list of peers in the room, updated by ui.refreshPeers()
-	peersList := tview.NewTextView()
-	peersList.SetBorder(true)
-	peersList.SetTitle("Peers")
-	peersList.SetChangedFunc(func() { app.Draw() })
-
-	// chatPanel is a horizontal box with messages on the left and peers on the right
-	// the peers list takes 20 columns, and the messages take the remaining space
-	chatPanel := tview.NewFlex().
-		AddItem(msgBox, 0, 1, false).
-		AddItem(peersList, 20, 1, false)
-
-	// flex is a vertical box with the chatPanel on top and the input field at the bottom.
-
-	flex := tview.NewFlex().
-		SetDirection(tview.FlexRow).
-		AddItem(chatPanel, 0, 1, false).
-		AddItem(input, 1, 1, true)
-
-	app.SetRoot(flex, true)
-
-	return &ChatUI{
-		cr:        cr,
-		app:       app,
-		peersList: peersList,
-		msgW:      msgBox,
-		inputCh:   inputCh,
-		doneCh:    make(chan struct{}, 1),
-	}
-}
-
-// Run starts the chat event loop in the background, then starts
-// the event loop for the text UI.
-func (ui *ChatUI) Run() error {
-	go ui.handleEvents()
-	defer ui.end()
-
-	return ui.app.Run()
-}
-
-// end signals the event loop to exit gracefully
-func (ui *ChatUI) end() {
-	ui.doneCh <- struct{}{}
-}
-
-// refreshPeers pulls the list of peers currently in the chat room and
-// displays the last 8 chars of their peer id in the Peers panel in the ui.
-func (ui *ChatUI) refreshPeers() {
-	peers := ui.cr.ListPeers()
-
-	// clear is thread-safe
-	ui.peersList.Clear()
-
-	for _, p := range peers {
-		fmt.Fprintln(ui.peersList, shortID(p))
-	}
-
-	ui.app.Draw()
-}
-
-// displayChatMessage writes a ChatMessage from the room to the message window,
-// with the sender's nick highlighted in green.
-func (ui *ChatUI) displayChatMessage(cm *ChatMessage) {
-	prompt := withColor("green", fmt.Sprintf("<%s>:", cm.SenderNick))
-	fmt.Fprintf(ui.msgW, "%s %s\n", prompt, cm.Message)
-}
-
-// displaySelfMessage writes a message from ourselves to the message window,
-// with our nick highlighted in yellow.
-func (ui *ChatUI) displaySelfMessage(msg string) {
-	prompt := withColor("yellow", fmt.Sprintf("<%s>:", ui.cr.nick))
-	fmt.Fprintf(ui.msgW, "%s %s\n", prompt, msg)
-}
-
-// handleEvents runs an event loop that sends user input to the chat room
-// and displays messages received from the chat room. It also periodically
-// refreshes the list of peers in the UI.
-func (ui *ChatUI) handleEvents() {
-	peerRefreshTicker := time.NewTicker(time.Second)
-	defer peerRefreshTicker.Stop()
-
-	for {
-		select {
-		case input := <-ui.inputCh:
-			// when the user types in a line, publish it to the chat room and print to the message window
-			err := ui.cr.Publish(input)
-			if err != nil {
-				printErr("publish error: %s", err)
-			}
-			ui.displaySelfMessage(input)
-
-		case m := <-ui.cr.Messages:
-			// when we receive a message from the chat room, print it to the message window
-			ui.displayChatMessage(m)
-
-		case <-peerRefreshTicker.C:
-			// refresh the list of peers in the chat room periodically
-			ui.refreshPeers()
-
-		case <-ui.cr.ctx.Done():
-			return
-
-		case <-ui.doneCh:
-			return
-		}
-	}
-}
-
-// withColor wraps a string with color tags for display in the messages text box.
-func withColor(color, msg string) string {
-	return fmt.Sprintf("[%s]%s[-]", color, msg)
-}
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+This code borrows heavily from:
+
+	https://github.com/libp2p/go-libp2p/blob/master/examples/pubsub/chat/ui.go
+*/
+package chatv2
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/rightfoot-consulting/p2pbbs/doors"
+	"github.com/rightfoot-consulting/p2pbbs/sanitize"
+	"github.com/rightfoot-consulting/p2pbbs/trust"
+	"github.com/rivo/tview"
+	"golang.org/x/term"
+)
+
+// ChatUI is a Text User Interface (TUI) for a ChatRoom.
+// The Run method will draw the UI to the terminal in "fullscreen"
+// mode. You can quit with Ctrl-C, or by typing "/quit" into the
+// chat prompt.
+type ChatUI struct {
+	node      *ChatV2Node
+	cr        *ChatRoom
+	app       *tview.Application
+	msgBox    *tview.TextView
+	peersList *tview.TextView
+
+	cmds    *Commands
+	pane    *messagePane
+	msgW    io.Writer
+	inputCh chan string
+	doneCh  chan struct{}
+}
+
+// NewChatUI returns a new ChatUI struct that controls the text UI.
+// It won't actually do anything until you call Run().
+func NewChatUI(node *ChatV2Node, cr *ChatRoom) *ChatUI {
+	app := tview.NewApplication()
+
+	// make a text view to contain our chat messages
+	msgBox := tview.NewTextView()
+	msgBox.SetDynamicColors(true)
+	msgBox.SetBorder(true)
+	msgBox.SetTitle(roomTitle(cr))
+
+	// text views are io.Writers, but they don't automatically refresh.
+	// this sets a change handler to force the app to redraw when we get
+	// new messages to display.
+	msgBox.SetChangedFunc(func() {
+		app.Draw()
+	})
+
+	// an input field for typing messages into
+	inputCh := make(chan string, 32)
+	input := tview.NewInputField().
+		SetLabel(cr.nick + " > ").
+		SetFieldWidth(0).
+		SetFieldBackgroundColor(tcell.ColorBlack)
+
+	// the done func is called when the user hits enter, or tabs out of the field
+	input.SetDoneFunc(func(key tcell.Key) {
+		if key != tcell.KeyEnter {
+			// we don't want to do anything if they just tabbed away
+			return
+		}
+		line := input.GetText()
+		if len(line) == 0 {
+			// ignore blank lines
+			return
+		}
+
+		// bail if requested
+		if line == "/quit" {
+			app.Stop()
+			return
+		}
+
+		// send the line onto the input chan and reset the field text
+		inputCh <- line
+		input.SetText("")
+	})
+
+	// tell the room when the user is typing a message, but not a command
+	if presence := cr.Presence(); presence != nil {
+		input.SetChangedFunc(func(text string) {
+			if text == "" || IsCommand(text) {
+				presence.StopTyping()
+			} else {
+				presence.Typing()
+			}
+		})
+	}
+
+	// make a text view to hold the list of peers in the room, updated by ui.refreshPeers()
+	peersList := tview.NewTextView()
+	peersList.SetDynamicColors(true)
+	peersList.SetBorder(true)
+	peersList.SetTitle("Peers")
+	peersList.SetChangedFunc(func() { app.Draw() })
+
+	// chatPanel is a horizontal box with messages on the left and peers on the right
+	// the peers list takes 32 columns, and the messages take the remaining space
+	chatPanel := tview.NewFlex().
+		AddItem(msgBox, 0, 1, false).
+		AddItem(peersList, 32, 1, false)
+
+	// flex is a vertical box with the chatPanel on top and the input field at the bottom.
+
+	flex := tview.NewFlex().
+		SetDirection(tview.FlexRow).
+		AddItem(chatPanel, 0, 1, false).
+		AddItem(input, 1, 1, true)
+
+	app.SetRoot(flex, true)
+
+	ui := &ChatUI{
+		node:      node,
+		cr:        cr,
+		app:       app,
+		msgBox:    msgBox,
+		peersList: peersList,
+		inputCh:   inputCh,
+		doneCh:    make(chan struct{}, 1),
+	}
+	ui.pane = &messagePane{box: msgBox, thread: cr.Thread(), name: ui.senderName}
+	ui.msgW = ui.pane
+	ui.cmds = NewCommands(node, cr, ui.displaySystemMessage)
+	ui.cmds.Door = ui.runDoor
+	ui.cmds.Sent = ui.displaySelfMessage
+	return ui
+}
+
+// Run starts the chat event loop in the background, then starts
+// the event loop for the text UI.
+func (ui *ChatUI) Run() error {
+	ui.replayTranscript()
+	go ui.handleEvents()
+	defer ui.end()
+
+	return ui.app.Run()
+}
+
+// end signals the event loop to exit gracefully
+func (ui *ChatUI) end() {
+	ui.doneCh <- struct{}{}
+}
+
+// replayTranscript shows the last messages of the room's transcript, their senders greyed out,
+// so the conversation picks up where it was left.  They go through the thread of the room, so
+// they can be replied to, reacted to and edited like messages that arrive, and their edits,
+// deletions and reactions apply to them.
+func (ui *ChatUI) replayTranscript() {
+	config := ui.node.Transcripts.Config()
+	if config.Disabled || config.Replay <= 0 {
+		return
+	}
+	log, err := ui.node.Transcripts.Log(ui.cr.Name(), ui.cr.Private())
+	if err != nil {
+		ui.displaySystemMessage(fmt.Sprintf("unable to read the transcript: %v", err))
+		return
+	}
+	entries, err := log.Last(config.Replay)
+	if err != nil {
+		ui.displaySystemMessage(fmt.Sprintf("unable to read the transcript: %v", err))
+		return
+	}
+	shown := 0
+	for _, e := range entries {
+		cm := entryMessage(e)
+		ui.cr.Thread().Add(cm)
+		if cm.Updates() == "" {
+			prompt := fmt.Sprintf("%s <%s>:", e.Time.Local().Format(time.DateTime), e.Nick)
+			ui.pane.addMessage(withColor("gray", sanitize.TviewLine(prompt)), cm)
+			shown++
+		}
+	}
+	if shown == 0 {
+		return
+	}
+	ui.pane.redraw()
+	ui.displaySystemMessage(fmt.Sprintf("%d earlier messages from the transcript", shown))
+}
+
+// refreshPeers pulls the list of peers currently in the chat room and
+// displays their names in the Peers panel in the ui.  Users whose presence we follow are shown
+// with their status, whether they are typing and how long they have been idle, peers of the
+// topic that send no presence after them.
+func (ui *ChatUI) refreshPeers() {
+	peers := ui.cr.ListPeers()
+	var present []PeerPresence
+	var b strings.Builder
+	if presence := ui.cr.Presence(); presence != nil {
+		present = presence.Peers()
+		status, text := presence.Status()
+		writePeer(&b, ui.trustBadge(ui.cr.self), ui.cr.nick, &PeerPresence{Status: status, Text: text}, 0)
+	}
+	ids := make([]peer.ID, 0, len(peers)+len(present))
+	for _, pp := range present {
+		ids = append(ids, pp.ID)
+	}
+	names := ui.cmds.DisplayNames(append(ids, peers...))
+	listed := make(map[peer.ID]bool)
+	now := time.Now()
+	for _, pp := range present {
+		listed[pp.ID] = true
+		name := names[pp.ID]
+		if pp.ID == ui.cr.self {
+			// another user of our node
+			name = pp.Nick
+		}
+		writePeer(&b, ui.trustBadge(pp.ID), name, &pp, pp.Idle(now))
+	}
+	for _, p := range peers {
+		if !listed[p] {
+			fmt.Fprintf(&b, "%s %s\n", ui.trustBadge(p), sanitize.TviewLine(names[p]))
+		}
+	}
+	ui.peersList.SetText(b.String())
+
+	// the moderators may have changed the topic since
+	ui.msgBox.SetTitle(roomTitle(ui.cr))
+
+	// swap endorsements with peers we haven't talked to yet
+	ui.node.Trust.ExchangeOnce(ui.cr.ctx, peers)
+
+	ui.app.Draw()
+}
+
+// displayPresence notes users joining and leaving the room or changing their status in the
+// message window, and shows the change in the Peers panel.
+func (ui *ChatUI) displayPresence(e *PresenceEvent) {
+	name := e.Peer.Nick
+	if e.Peer.ID != ui.cr.self {
+		if e.Kind != PresenceLeft && e.Kind != PresenceTyping {
+			if err := ui.node.Names.SeenNick(e.Peer.ID, e.Peer.Nick); err != nil {
+				ui.displaySystemMessage(fmt.Sprintf("unable to save nick: %v", err))
+			}
+		}
+		name = ui.cmds.DisplayName(e.Peer.ID)
+	}
+	switch e.Kind {
+	case PresenceJoined:
+		ui.displaySystemMessage(fmt.Sprintf("→ %s joined the room", name))
+	case PresenceLeft:
+		ui.displaySystemMessage(fmt.Sprintf("← %s left the room", name))
+	case PresenceChanged:
+		ui.displaySystemMessage(fmt.Sprintf("%s is %s", name, describeStatus(e.Peer.Status, e.Peer.Text)))
+	}
+	ui.refreshPeers()
+}
+
+// displayChatMessage writes a ChatMessage from the room to the message window,
+// with the sender's name highlighted in green.  Edits, deletions and reactions change the
+// message they refer to instead.
+func (ui *ChatUI) displayChatMessage(cm *ChatMessage) {
+	badge := withColor("yellow", "?")
+	if sender, err := peer.Decode(cm.SenderID); err == nil {
+		if err := ui.node.Names.SeenNick(sender, cm.SenderNick); err != nil {
+			ui.displaySystemMessage(fmt.Sprintf("unable to save nick: %v", err))
+		}
+		badge = ui.trustBadge(sender)
+	}
+	if cm.Updates() != "" {
+		ui.pane.redraw()
+		return
+	}
+	prompt := withColor("green", fmt.Sprintf("<%s>:", sanitize.TviewLine(ui.senderName(cm))))
+	ui.pane.addMessage(badge+" "+prompt, cm)
+}
+
+// displaySelfMessage writes a message from ourselves to the message window,
+// with our nick highlighted in yellow.
+func (ui *ChatUI) displaySelfMessage(cm *ChatMessage) {
+	if cm.Updates() != "" {
+		ui.pane.redraw()
+		return
+	}
+	prompt := withColor("yellow", fmt.Sprintf("<%s>:", sanitize.TviewLine(ui.cr.nick)))
+	ui.pane.addMessage(prompt, cm)
+}
+
+// senderName returns the name to show for the sender of a message.
+func (ui *ChatUI) senderName(cm *ChatMessage) string {
+	if sender, err := peer.Decode(cm.SenderID); err == nil {
+		return ui.cmds.DisplayName(sender)
+	}
+	return cm.SenderNick
+}
+
+// displaySystemMessage writes a notice from the UI itself, such as command output,
+// to the message window.  Command output can quote peers, so it is cleaned like their messages.
+func (ui *ChatUI) displaySystemMessage(msg string) {
+	fmt.Fprintf(ui.msgW, "%s\n", withColor("blue", sanitize.TviewLine(msg)))
+}
+
+// trustBadge marks a peer with what the web of trust says about it: a green tick for
+// trusted peers, a red cross for revoked ones and a yellow question mark otherwise.
+func (ui *ChatUI) trustBadge(id peer.ID) string {
+	switch ui.cmds.TrustStatus(id) {
+	case trust.Trusted:
+		return withColor("green", "✔")
+	case trust.Distrusted:
+		return withColor("red", "✘")
+	case trust.Self:
+		return withColor("yellow", "*")
+	default:
+		return withColor("yellow", "?")
+	}
+}
+
+// runDoor hands the terminal to a door until it exits, then posts the score it recorded.
+func (ui *ChatUI) runDoor(name string) {
+	door, err := doors.Find(ui.node.Config.DoorList(), name)
+	if err != nil {
+		ui.displaySystemMessage(err.Error())
+		return
+	}
+	width, height, _ := term.GetSize(int(os.Stdout.Fd()))
+	info := ui.node.DropInfo(ui.cr.nick, ui.node.Host.ID(), width, height)
+	var result *doors.Result
+	ui.app.Suspend(func() {
+		result, err = doors.Run(ui.cr.ctx, door, info, os.Stdin, os.Stdout)
+	})
+	if err != nil {
+		ui.displaySystemMessage(fmt.Sprintf("door %s failed: %v", door.Name, err))
+	}
+	if result == nil {
+		return
+	}
+	if result.TimedOut {
+		ui.displaySystemMessage(fmt.Sprintf("your time in %s ran out", door.Name))
+	}
+	if result.Scored {
+		if _, err = doors.PostScore(ui.node.Boards, ui.node.PrivateKey(), ui.cr.nick, door.Name, result); err != nil {
+			ui.displaySystemMessage(fmt.Sprintf("unable to post your score: %v", err))
+			return
+		}
+		ui.displaySystemMessage(fmt.Sprintf("you scored %d in %s", result.Score, door.Name))
+	}
+}
+
+// handleEvents runs an event loop that sends user input to the chat room
+// and displays messages received from the chat room. It also periodically
+// refreshes the list of peers in the UI.
+func (ui *ChatUI) handleEvents() {
+	peerRefreshTicker := time.NewTicker(time.Second)
+	defer peerRefreshTicker.Stop()
+	// a nil channel never delivers, for rooms joined without presence
+	var presenceEvents chan *PresenceEvent
+	if presence := ui.cr.Presence(); presence != nil {
+		presenceEvents = presence.Events
+	}
+
+	for {
+		select {
+		case input := <-ui.inputCh:
+			if IsCommand(input) {
+				ui.cmds.Run(input)
+				continue
+			}
+			// when the user types in a line, publish it to the chat room and print to the message window
+			cm := &ChatMessage{Message: input}
+			err := ui.cr.PublishMessage(cm)
+			if err == ErrSilenced {
+				ui.displaySystemMessage(err.Error())
+				continue
+			}
+			if err != nil {
+				printErr("publish error: %s", err)
+			}
+			ui.displaySelfMessage(cm)
+
+		case m := <-ui.cr.Messages:
+			// when we receive a message from the chat room, print it to the message window
+			ui.displayChatMessage(m)
+
+		case e := <-presenceEvents:
+			ui.displayPresence(e)
+
+		case <-peerRefreshTicker.C:
+			// refresh the list of peers in the chat room periodically
+			ui.refreshPeers()
+
+		case <-ui.cr.ctx.Done():
+			return
+
+		case <-ui.doneCh:
+			return
+		}
+	}
+}
+
+// roomTitle names the room in the title of the message window, with the topic its moderators set.
+func roomTitle(cr *ChatRoom) string {
+	if cr.Private() {
+		return fmt.Sprintf("Private room: %s", cr.roomName)
+	}
+	if topic := cr.Moderation().Topic; topic != "" {
+		return fmt.Sprintf("Room: %s - %s", cr.roomName, sanitize.TviewLine(topic))
+	}
+	return fmt.Sprintf("Room: %s", cr.roomName)
+}
+
+// writePeer writes a line of the Peers panel: the name of a user with a mark for their status,
+// a pencil when they are typing and their idle time, then the text of their status.
+func writePeer(b *strings.Builder, badge string, name string, pp *PeerPresence, idle time.Duration) {
+	marks := map[string]string{StatusOnline: withColor("green", "●"), StatusAway: withColor("yellow", "○"), StatusDND: withColor("red", "⊘")}
+	fmt.Fprintf(b, "%s %s %s", badge, sanitize.TviewLine(name), marks[pp.Status])
+	if pp.Typing {
+		b.WriteString(" ✎")
+	}
+	if idle := formatIdle(idle); idle != "" {
+		b.WriteString(withColor("gray", " "+idle))
+	}
+	b.WriteByte('\n')
+	if pp.Text != "" {
+		fmt.Fprintf(b, "  %s\n", withColor("gray", sanitize.TviewLine(pp.Text)))
+	}
+}
+
+// formatIdle writes an idle time in the largest unit that fits, nothing under a minute.
+func formatIdle(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return ""
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d/time.Minute))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh", int(d/time.Hour))
+	default:
+		return fmt.Sprintf("%dd", int(d/(24*time.Hour)))
+	}
+}
+
+// describeStatus describes a status for notices, with its text.
+func describeStatus(status string, text string) string {
+	if status == StatusDND {
+		status = "not to be disturbed"
+	}
+	if text == "" {
+		return status
+	}
+	return fmt.Sprintf("%s: %s", status, text)
+}
+
+// withColor wraps a string with color tags for display in the messages text box.
+func withColor(color, msg string) string {
+	return fmt.Sprintf("[%s]%s[-]", color, msg)
+}
@@ -0,0 +1,106 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package chatv2
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/rightfoot-consulting/p2pbbs/chat"
+	"github.com/rightfoot-consulting/p2pbbs/doors"
+	"github.com/rightfoot-consulting/p2pbbs/transcript"
+)
+
+// ChatV2Config shares its network settings and their JSON names with the chat configuration
+// so one chatconfig.json can drive both commands.
+type ChatV2Config struct {
+	Nick           string             `json:"nick"`
+	Room           string             `json:"room"`
+	KeyFile        string             `json:"key_file"`
+	Port           int                `json:"port"`
+	ListenIps      []string           `json:"listen_ips"`
+	BootstrapPeers []string           `json:"bootstrap_peers"`
+	DataDir        string             `json:"data_dir"`
+	Boards         []string           `json:"boards"`
+	Doors          []*doors.Door      `json:"doors"`
+	Scoring        *ScoringConfig     `json:"scoring"`
+	SwarmKey       string             `json:"swarm_key,omitempty"`
+	Transcripts    *transcript.Config `json:"transcripts,omitempty"`
+	Owners         map[string]string  `json:"owners,omitempty"`
+}
+
+// DefaultDataDir holds the node's local state when the configuration doesn't name a directory.
+const DefaultDataDir = chat.DefaultDataDir
+
+func LoadChatV2Config(filename string) (config *ChatV2Config, err error) {
+	// settings missing from the scoring and transcripts sections keep their defaults
+	cfg := ChatV2Config{Scoring: DefaultScoring(), Transcripts: transcript.DefaultConfig()}
+	jsonBytes, err := os.ReadFile(filename)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(jsonBytes, &cfg)
+	if err == nil {
+		config = &cfg
+	}
+	return
+}
+
+// networkConfiguration exposes the network settings as a chat configuration so the address
+// helpers can be shared.
+func (cfg *ChatV2Config) networkConfiguration() *chat.Configuration {
+	return &chat.Configuration{
+		Port:           cfg.Port,
+		BootstrapPeers: cfg.BootstrapPeers,
+		ListenIps:      cfg.ListenIps,
+		KeyFile:        cfg.KeyFile,
+		DataDir:        cfg.DataDir,
+		SwarmKey:       cfg.SwarmKey,
+	}
+}
+
+// DataDirectory returns the directory holding the node's local state, creating it if needed.
+func (cfg *ChatV2Config) DataDirectory() (string, error) {
+	return cfg.networkConfiguration().DataDirectory()
+}
+
+// DoorList returns the doors declared in the configuration, or the built in test door when
+// none are.
+func (cfg *ChatV2Config) DoorList() []*doors.Door {
+	if len(cfg.Doors) == 0 {
+		return doors.DefaultDoors()
+	}
+	return cfg.Doors
+}
+
+// PeerScoring returns the peer scoring settings, the defaults when the configuration has none.
+func (cfg *ChatV2Config) PeerScoring() *ScoringConfig {
+	if cfg.Scoring == nil {
+		return DefaultScoring()
+	}
+	return cfg.Scoring
+}
+
+// TranscriptConfig returns the transcript settings, the defaults when the configuration has
+// none.
+func (cfg *ChatV2Config) TranscriptConfig() *transcript.Config {
+	if cfg.Transcripts == nil {
+		return transcript.DefaultConfig()
+	}
+	return cfg.Transcripts
+}
+
+// ScopeOwners decodes the owners pinned for boards and rooms, keyed by moderation scope such as
+// "board:general" or "room:lobby".
+func (cfg *ChatV2Config) ScopeOwners() (owners map[string]peer.ID, err error) {
+	owners = make(map[string]peer.ID, len(cfg.Owners))
+	for scope, owner := range cfg.Owners {
+		if owners[scope], err = peer.Decode(owner); err != nil {
+			return nil, fmt.Errorf("owner of %s: %w", scope, err)
+		}
+	}
+	return
+}
@@ -1,125 +1,411 @@
-/*
-Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
-This code borrows heavily from:
-
-	https://github.com/libp2p/go-libp2p/blob/master/examples/pubsub/chat/main.go
-*/
-
-package chatv2
-
-import (
-	"context"
-	"flag"
-	"fmt"
-	"os"
-	"time"
-
-	"github.com/libp2p/go-libp2p"
-	"github.com/libp2p/go-libp2p/core/host"
-	"github.com/libp2p/go-libp2p/core/peer"
-	"github.com/libp2p/go-libp2p/p2p/discovery/mdns"
-)
-
-// DiscoveryInterval is how often we re-publish our mDNS records.
-const DiscoveryInterval = time.Hour
-
-// DiscoveryServiceTag is used in our mDNS advertisements to discover other chat peers.
-const DiscoveryServiceTag = "pubsub-chat-example"
-
-type ChatV2Config struct {
-	Nick string 'json:"nick"'
-	Room string 'json:"room"'
-	KeyFile        string   `json:"key_file"`
-}
-
-type ChatV2Node struct {
-	Config *ChatV2Config
-}
-
-func main() {
-	// parse some flags to set our nickname and the room to join
-	nickFlag := flag.String("nick", "", "nickname to use in chat. will be generated if empty")
-	roomFlag := flag.String("room", "awesome-chat-room", "name of chat room to join")
-	flag.Parse()
-
-	ctx := context.Background()
-
-	// create a new libp2p Host that listens on a random TCP port
-	h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/0.0.0.0/tcp/0"))
-	if err != nil {
-		panic(err)
-	}
-
-	// create a new PubSub service using the GossipSub router
-	ps, err := pubsub.NewGossipSub(ctx, h)
-	if err != nil {
-		panic(err)
-	}
-
-	// setup local mDNS discovery
-	if err := setupDiscovery(h); err != nil {
-		panic(err)
-	}
-
-	// use the nickname from the cli flag, or a default if blank
-	nick := *nickFlag
-	if len(nick) == 0 {
-		nick = defaultNick(h.ID())
-	}
-
-	// join the room from the cli flag, or the flag default
-	room := *roomFlag
-
-	// join the chat room
-	cr, err := JoinChatRoom(ctx, ps, h.ID(), nick, room)
-	if err != nil {
-		panic(err)
-	}
-
-	// draw the UI
-	ui := NewChatUI(cr)
-	if err = ui.Run(); err != nil {
-		printErr("error running text UI: %s", err)
-	}
-}
-
-// printErr is like fmt.Printf, but writes to stderr.
-func printErr(m string, args ...interface{}) {
-	fmt.Fprintf(os.Stderr, m, args...)
-}
-
-// defaultNick generates a nickname based on the $USER environment variable and
-// the last 8 chars of a peer ID.
-func defaultNick(p peer.ID) string {
-	return fmt.Sprintf("%s-%s", os.Getenv("USER"), shortID(p))
-}
-
-// shortID returns the last 8 chars of a base58-encoded peer id.
-func shortID(p peer.ID) string {
-	pretty := p.String()
-	return pretty[len(pretty)-8:]
-}
-
-// discoveryNotifee gets notified when we find a new peer via mDNS discovery
-type discoveryNotifee struct {
-	h host.Host
-}
-
-// HandlePeerFound connects to peers discovered via mDNS. Once they're connected,
-// the PubSub system will automatically start interacting with them if they also
-// support PubSub.
-func (n *discoveryNotifee) HandlePeerFound(pi peer.AddrInfo) {
-	fmt.Printf("discovered new peer %s\n", pi.ID)
-	err := n.h.Connect(context.Background(), pi)
-	if err != nil {
-		fmt.Printf("error connecting to peer %s: %s\n", pi.ID, err)
-	}
-}
-
-// setupDiscovery creates an mDNS discovery service and attaches it to the libp2p Host.
-// This lets us automatically discover peers on the same LAN and connect to them.
-func setupDiscovery(h host.Host) error {
-	// setup mDNS discovery to find local peers
-	s := mdns.NewMdnsService(h, DiscoveryServiceTag, &discoveryNotifee{h: h})
-	return s.Start()
-}
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+This code borrows heavily from:
+
+	https://github.com/libp2p/go-libp2p/blob/master/examples/pubsub/chat/main.go
+*/
+
+package chatv2
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/discovery/mdns"
+	"github.com/rightfoot-consulting/p2pbbs/bbscrypto"
+	"github.com/rightfoot-consulting/p2pbbs/bbsdht"
+	"github.com/rightfoot-consulting/p2pbbs/boards"
+	"github.com/rightfoot-consulting/p2pbbs/dm"
+	"github.com/rightfoot-consulting/p2pbbs/doors"
+	"github.com/rightfoot-consulting/p2pbbs/history"
+	"github.com/rightfoot-consulting/p2pbbs/moderation"
+	"github.com/rightfoot-consulting/p2pbbs/private"
+	"github.com/rightfoot-consulting/p2pbbs/profile"
+	"github.com/rightfoot-consulting/p2pbbs/search"
+	"github.com/rightfoot-consulting/p2pbbs/transcript"
+	"github.com/rightfoot-consulting/p2pbbs/trust"
+)
+
+var logger = logging.Logger("chatv2")
+
+// DiscoveryInterval is how often we re-publish our mDNS records.
+const DiscoveryInterval = time.Hour
+
+// DiscoveryServiceTag is used in our mDNS advertisements to discover other chat peers.
+const DiscoveryServiceTag = "pubsub-chat-example"
+
+// DefaultRoom is joined when the configuration doesn't name a room.
+const DefaultRoom = "awesome-chat-room"
+
+// ChatV2Node owns the libp2p host, the DHT and the PubSub service shared by the chat rooms
+// and any other services running on the node.
+type ChatV2Node struct {
+	Config      *ChatV2Config
+	Host        host.Host
+	DHT         *dht.IpfsDHT
+	PubSub      *pubsub.PubSub
+	Profiles    *profile.Directory
+	Names       *NameBook
+	Trust       *trust.Service
+	Boards      *boards.Service
+	DMs         *dm.Service
+	Moderation  *moderation.Service
+	History     *history.Service
+	Private     *private.Store
+	Search      *search.Index
+	Transcripts *transcript.Store
+	DataDir     string
+
+	ctx        context.Context
+	privateKey crypto.PrivKey
+
+	roomsLock sync.Mutex
+	rooms     map[string]*pubsub.Topic
+}
+
+func NewChatV2Node(config *ChatV2Config) (node *ChatV2Node, err error) {
+	node = &ChatV2Node{
+		Config: config,
+		rooms:  make(map[string]*pubsub.Topic),
+	}
+	return
+}
+
+// Start creates the libp2p host, joins the DHT through the configured bootstrap peers and
+// starts the GossipSub router and mDNS discovery.
+func (node *ChatV2Node) Start(ctx context.Context) (err error) {
+	node.ctx = ctx
+	node.DataDir, err = node.Config.DataDirectory()
+	if err != nil {
+		return
+	}
+	node.Names, err = LoadNameBook(filepath.Join(node.DataDir, NamesFile))
+	if err != nil {
+		return
+	}
+	netConfig := node.Config.networkConfiguration()
+	listenAddresses, err := netConfig.GetListenAddresses()
+	if err != nil {
+		return
+	}
+	if node.Config.KeyFile != "" {
+		node.privateKey, err = bbscrypto.LoadPrivateKey(node.Config.KeyFile)
+		if err != nil {
+			return
+		}
+	}
+	options, err := netConfig.PrivateNetwork()
+	if err != nil {
+		return
+	}
+	options = append(options, libp2p.ListenAddrs(listenAddresses...))
+	if node.privateKey != nil {
+		options = append(options, libp2p.Identity(node.privateKey))
+	}
+	node.Host, err = libp2p.New(options...)
+	if err != nil {
+		return
+	}
+	if node.privateKey == nil {
+		node.privateKey = node.Host.Peerstore().PrivKey(node.Host.ID())
+	}
+	node.Private, err = private.LoadStore(filepath.Join(node.DataDir, private.StoreFile), node.privateKey)
+	if err != nil {
+		return
+	}
+	trustStore, err := trust.LoadStore(filepath.Join(node.DataDir, trust.StoreFile))
+	if err != nil {
+		return
+	}
+	node.Trust = trust.NewService(node.Host, trustStore)
+
+	ourAddresses := make([]string, len(node.Host.Addrs()))
+	for i, addr := range node.Host.Addrs() {
+		ourAddresses[i] = addr.String() + "/p2p/" + node.Host.ID().String()
+	}
+	bsPeers, err := netConfig.GetBootstrapPeers(ourAddresses)
+	if err != nil {
+		return
+	}
+	bootstrapPeers, err := bbsdht.AddrInfos(bsPeers)
+	if err != nil {
+		return
+	}
+	node.DHT, err = bbsdht.New(ctx, node.Host, dht.ModeAutoServer, bootstrapPeers)
+	if err != nil {
+		return
+	}
+	if err = node.DHT.Bootstrap(ctx); err != nil {
+		return
+	}
+	node.Profiles = profile.NewDirectory(node.DHT)
+
+	// create a new PubSub service using the GossipSub router, scoring peers so that those
+	// sending messages the room validators reject end up graylisted
+	node.PubSub, err = pubsub.NewGossipSub(ctx, node.Host, node.Config.PeerScoring().options()...)
+	if err != nil {
+		return
+	}
+
+	// boards and direct messages ride on PubSub and keep what they receive in the data directory,
+	// moderation actions decide which posts the board store keeps
+	owners, err := node.Config.ScopeOwners()
+	if err != nil {
+		return
+	}
+	moderationStore, err := moderation.LoadStore(filepath.Join(node.DataDir, moderation.StoreFile), owners)
+	if err != nil {
+		return
+	}
+	node.Moderation, err = moderation.NewService(ctx, node.PubSub, moderationStore)
+	if err != nil {
+		return
+	}
+	postStore, err := boards.OpenStore(filepath.Join(node.DataDir, boards.StoreDir))
+	if err != nil {
+		return
+	}
+	postStore.Moderate(moderationStore)
+	node.Boards = boards.NewService(ctx, node.PubSub, postStore)
+	boardNames := append(node.Config.Boards, postStore.Boards()...)
+	if len(boardNames) == 0 {
+		boardNames = []string{boards.DefaultBoard}
+	}
+	for _, board := range boardNames {
+		if err = node.Boards.Join(board); err != nil {
+			return
+		}
+	}
+	// door scores are shared with every node offering the doors
+	for _, door := range node.Config.DoorList() {
+		if err = door.Validate(); err != nil {
+			return
+		}
+	}
+	if err = node.Boards.Join(doors.ScoreBoard); err != nil {
+		return
+	}
+	// posts and chat messages are indexed as they arrive
+	node.Search, err = search.Open(filepath.Join(node.DataDir, search.IndexFile))
+	if err != nil {
+		return
+	}
+	node.Search.Moderate(postStore)
+	go node.Search.Follow(ctx, postStore)
+	// an encrypted transcript sealed to a throwaway identity could never be read again
+	transcripts := node.Config.TranscriptConfig()
+	if transcripts.Encrypt && !transcripts.Disabled && node.Config.KeyFile == "" {
+		return fmt.Errorf("encrypted transcripts need a static identity, use --keyfile")
+	}
+	node.Transcripts, err = transcript.OpenStore(filepath.Join(node.DataDir, transcript.Dir), transcripts, node.privateKey)
+	if err != nil {
+		return
+	}
+	mailbox, err := dm.OpenMailbox(filepath.Join(node.DataDir, dm.MailboxDir))
+	if err != nil {
+		return
+	}
+	node.DMs, err = dm.NewService(ctx, node.PubSub, mailbox)
+	if err != nil {
+		return
+	}
+	if err = node.DMs.Listen(node.PrivateKey()); err != nil {
+		return
+	}
+	// catch up on the posts and moderation records published while we were offline
+	node.History = history.NewService(node.Host, node.Boards, moderationStore)
+	node.History.SyncOnConnect(ctx)
+
+	// setup local mDNS discovery
+	err = setupDiscovery(node.Host)
+	return
+}
+
+// PrivateKey returns the identity of the node, used to sign the records it publishes.
+func (node *ChatV2Node) PrivateKey() crypto.PrivKey {
+	return node.privateKey
+}
+
+// Nick returns the configured nickname, or a default derived from the peer id.
+func (node *ChatV2Node) Nick() string {
+	if node.Config.Nick != "" {
+		return node.Config.Nick
+	}
+	return defaultNick(node.Host.ID())
+}
+
+// DropInfo describes a user of the node to a door, on a terminal of width by height cells.
+func (node *ChatV2Node) DropInfo(user string, id peer.ID, width int, height int) *doors.DropInfo {
+	return &doors.DropInfo{
+		BBSName:       "p2pbbs",
+		Sysop:         node.Nick(),
+		UserName:      user,
+		Alias:         user,
+		PeerID:        id.String(),
+		Location:      node.Host.ID().String(),
+		Node:          1,
+		SecurityLevel: 10,
+		ANSI:          true,
+		Width:         width,
+		Height:        height,
+	}
+}
+
+// JoinRoom joins a chat room as nick.  Local users of the node share the PubSub topic of a
+// room and the presence topic beside it, each with their own subscription.  A private room we
+// are a member of is joined rather than the public room of the same name.
+func (node *ChatV2Node) JoinRoom(ctx context.Context, nick string, roomName string) (*ChatRoom, error) {
+	m, err := node.privateRoom(roomName)
+	if err != nil {
+		return nil, err
+	}
+	name := topicName(roomName)
+	if m != nil {
+		name = private.TopicName(m.Room)
+	}
+	node.roomsLock.Lock()
+	presenceTopic, ok := node.rooms[presenceTopicName(name)]
+	if !ok {
+		if m != nil {
+			presenceTopic, err = joinPresenceTopic(node.PubSub, name, node.Private, m.Room, node.Config.PeerScoring())
+		} else {
+			presenceTopic, err = joinPresenceTopic(node.PubSub, name, nil, "", node.Config.PeerScoring())
+		}
+		if err != nil {
+			node.roomsLock.Unlock()
+			return nil, err
+		}
+		node.rooms[presenceTopicName(name)] = presenceTopic
+	}
+	topic, ok := node.rooms[name]
+	if !ok {
+		if m != nil {
+			topic, err = joinPrivateTopic(node.PubSub, node.Private, m.Room, node.Config.PeerScoring())
+		} else {
+			topic, err = joinRoomTopic(node.PubSub, roomName, node.Config.PeerScoring())
+		}
+		if err != nil {
+			node.roomsLock.Unlock()
+			return nil, err
+		}
+		node.rooms[name] = topic
+		if m != nil {
+			go node.announceMembership(node.ctx, m.Room, topic)
+			go node.recordRoom(node.ctx, m.Name, topic, true)
+		} else {
+			go node.recordRoom(node.ctx, roomName, topic, false)
+		}
+	}
+	node.roomsLock.Unlock()
+	var cr *ChatRoom
+	if m != nil {
+		cr, err = subscribeChatRoom(ctx, node.PubSub, topic, node.Host.ID(), nick, m.Name, nil, node.Private, m.Room)
+	} else {
+		cr, err = subscribeChatRoom(ctx, node.PubSub, topic, node.Host.ID(), nick, roomName, node.Moderation.Store(), nil, "")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if cr.presence, err = newPresence(ctx, presenceTopic, node.Host.ID(), nick, cr.keys, cr.private); err != nil {
+		cr.Leave()
+		return nil, err
+	}
+	return cr, nil
+}
+
+// Close saves the nicks seen since the name book was last saved and shuts down the DHT and the
+// host.
+func (node *ChatV2Node) Close() error {
+	if node.Names != nil {
+		if err := node.Names.Flush(); err != nil {
+			logger.Warnf("unable to save nicks: %v", err)
+		}
+	}
+	if node.DHT != nil {
+		node.DHT.Close()
+	}
+	if node.Host != nil {
+		return node.Host.Close()
+	}
+	return nil
+}
+
+// Run starts the node, joins the configured room and draws the chat UI until the user quits.
+func (node *ChatV2Node) Run() {
+	ctx := context.Background()
+	if err := node.Start(ctx); err != nil {
+		panic(err)
+	}
+	defer node.Close()
+
+	// join the room from the config, or the default
+	room := node.Config.Room
+	if len(room) == 0 {
+		room = DefaultRoom
+	}
+
+	// join the chat room
+	cr, err := node.JoinRoom(ctx, node.Nick(), room)
+	if err != nil {
+		panic(err)
+	}
+
+	// draw the UI
+	ui := NewChatUI(node, cr)
+	if err = ui.Run(); err != nil {
+		printErr("error running text UI: %s", err)
+	}
+	cr.Leave()
+}
+
+// printErr is like fmt.Printf, but writes to stderr.
+func printErr(m string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, m, args...)
+}
+
+// defaultNick generates a nickname based on the $USER environment variable and
+// the last 8 chars of a peer ID.
+func defaultNick(p peer.ID) string {
+	return fmt.Sprintf("%s-%s", os.Getenv("USER"), shortID(p))
+}
+
+// shortID returns the last 8 chars of a base58-encoded peer id.
+func shortID(p peer.ID) string {
+	pretty := p.String()
+	return pretty[len(pretty)-8:]
+}
+
+// discoveryNotifee gets notified when we find a new peer via mDNS discovery
+type discoveryNotifee struct {
+	h host.Host
+}
+
+// HandlePeerFound connects to peers discovered via mDNS. Once they're connected,
+// the PubSub system will automatically start interacting with them if they also
+// support PubSub.
+func (n *discoveryNotifee) HandlePeerFound(pi peer.AddrInfo) {
+	printErr("discovered new peer %s\n", pi.ID)
+	err := n.h.Connect(context.Background(), pi)
+	if err != nil {
+		printErr("error connecting to peer %s: %s\n", pi.ID, err)
+	}
+}
+
+// setupDiscovery creates an mDNS discovery service and attaches it to the libp2p Host.
+// This lets us automatically discover peers on the same LAN and connect to them.
+func setupDiscovery(h host.Host) error {
+	// setup mDNS discovery to find local peers
+	s := mdns.NewMdnsService(h, DiscoveryServiceTag, &discoveryNotifee{h: h})
+	return s.Start()
+}
@@ -0,0 +1,609 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package chatv2
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/rightfoot-consulting/p2pbbs/boards"
+	"github.com/rightfoot-consulting/p2pbbs/doors"
+	"github.com/rightfoot-consulting/p2pbbs/moderation"
+	"github.com/rightfoot-consulting/p2pbbs/pow"
+	"github.com/rightfoot-consulting/p2pbbs/private"
+	"github.com/rightfoot-consulting/p2pbbs/profile"
+	"github.com/rightfoot-consulting/p2pbbs/search"
+	"github.com/rightfoot-consulting/p2pbbs/trust"
+)
+
+const (
+	// MiningProgressInterval is how often the progress of mining the stamp of a post is shown.
+	MiningProgressInterval = 2 * time.Second
+	// SearchResults is how many results /search shows.
+	SearchResults = 10
+)
+
+// Commands runs the slash commands a user types into a chat room, whichever front end they use.
+// Output goes to the print function, one line at a time, and may arrive after Run returns when
+// the command waits on the network.
+type Commands struct {
+	node  *ChatV2Node
+	cr    *ChatRoom
+	print func(line string)
+
+	// Door plays a door for the user, nil when the front end has no terminal to lend.
+	Door func(name string)
+	// Sent shows a message the commands published for the user, such as a reply or an edit.
+	Sent func(cm *ChatMessage)
+}
+
+// NewCommands returns the commands of a user in room cr.
+func NewCommands(node *ChatV2Node, cr *ChatRoom, print func(line string)) *Commands {
+	return &Commands{node: node, cr: cr, print: print}
+}
+
+// IsCommand reports whether a line typed by the user is a slash command rather than a message.
+func IsCommand(line string) bool {
+	return strings.HasPrefix(line, "/")
+}
+
+// Run runs a slash command.
+func (c *Commands) Run(line string) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return
+	}
+	switch fields[0] {
+	case "/whois":
+		if len(fields) != 2 {
+			c.print("usage: /whois <nick|peer id>")
+			return
+		}
+		c.whois(fields[1])
+	case "/petname":
+		if len(fields) < 3 {
+			c.print("usage: /petname <nick|peer id> <name>")
+			return
+		}
+		c.setPetname(fields[1], strings.Join(fields[2:], " "))
+	case "/unpetname":
+		if len(fields) != 2 {
+			c.print("usage: /unpetname <petname|peer id>")
+			return
+		}
+		c.removePetname(fields[1])
+	case "/petnames":
+		c.listPetnames()
+	case "/trust":
+		if len(fields) != 2 {
+			c.print("usage: /trust <nick|peer id>")
+			return
+		}
+		c.showTrust(fields[1])
+	case "/doors":
+		c.listDoors()
+	case "/door":
+		if len(fields) != 2 {
+			c.print("usage: /door <name>")
+			return
+		}
+		if c.Door == nil {
+			c.print("doors can only be played from a terminal")
+			return
+		}
+		c.Door(fields[1])
+	case "/post":
+		board, rest, _ := strings.Cut(strings.TrimSpace(strings.TrimPrefix(line, "/post")), " ")
+		subject, body, ok := strings.Cut(rest, "|")
+		if board == "" || !ok {
+			c.print("usage: /post <board> <subject> | <text>")
+			return
+		}
+		c.post(board, strings.TrimSpace(subject), strings.TrimSpace(body))
+	case "/mods":
+		c.listModerators()
+	case "/claim":
+		c.claim(fields[1:])
+	case "/topic":
+		if len(fields) == 1 {
+			c.showTopic()
+			return
+		}
+		c.moderate(moderation.Topic, "", strings.Join(fields[1:], " "), 0)
+	case "/mute":
+		if len(fields) != 3 {
+			c.print("usage: /mute <nick|peer id> <duration>")
+			return
+		}
+		duration, err := time.ParseDuration(fields[2])
+		if err != nil || duration <= 0 {
+			c.print(fmt.Sprintf("bad duration %s, try 10m or 2h", fields[2]))
+			return
+		}
+		c.moderatePeer(moderation.Mute, fields[1], duration)
+	case "/unmute", "/ban", "/unban":
+		if len(fields) != 2 {
+			c.print(fmt.Sprintf("usage: %s <nick|peer id>", fields[0]))
+			return
+		}
+		c.moderatePeer(moderation.Kind(fields[0][1:]), fields[1], 0)
+	case "/search":
+		if len(fields) < 2 {
+			c.print("usage: /search <words, \"phrases\", author:, room:, board:, after:, before:>")
+			return
+		}
+		c.search(strings.TrimSpace(strings.TrimPrefix(line, "/search")))
+	case "/members":
+		c.listMembers()
+	case "/add", "/remove":
+		if len(fields) < 2 {
+			c.print(fmt.Sprintf("usage: %s <nick|peer id>...", fields[0]))
+			return
+		}
+		c.changeMembers(fields[0] == "/add", fields[1:])
+	case "/reply", "/edit":
+		_, rest, _ := strings.Cut(strings.TrimSpace(line), " ")
+		ref, text, _ := strings.Cut(strings.TrimSpace(rest), " ")
+		if strings.TrimSpace(text) == "" {
+			c.print(fmt.Sprintf("usage: %s <#id> <text>", fields[0]))
+			return
+		}
+		c.refer(fields[0], ref, strings.TrimSpace(text))
+	case "/react", "/unreact":
+		if len(fields) != 3 {
+			c.print(fmt.Sprintf("usage: %s <#id> <reaction>", fields[0]))
+			return
+		}
+		c.refer(fields[0], fields[1], fields[2])
+	case "/delete":
+		if len(fields) != 2 {
+			c.print("usage: /delete <#id>")
+			return
+		}
+		c.refer(fields[0], fields[1], "")
+	case "/status":
+		c.setStatus(strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), "/status")))
+	default:
+		c.print(fmt.Sprintf("unknown command %s", fields[0]))
+	}
+}
+
+// refer publishes a message referring to an earlier message of the room, named by the start of
+// its id: a reply, a reaction or taking one back, an edit or a deletion.  Only our own messages
+// can be edited or deleted.
+func (c *Commands) refer(command string, ref string, text string) {
+	m, err := c.cr.Thread().Find(ref)
+	if err != nil {
+		c.print(err.Error())
+		return
+	}
+	self := c.cr.self.String()
+	cm := &ChatMessage{Message: text}
+	switch command {
+	case "/reply":
+		cm.ReplyTo = m.ID
+	case "/react":
+		cm.Reaction = m.ID
+	case "/unreact":
+		if cm.Deletes = c.cr.Thread().Reacted(m.ID, self, text); cm.Deletes == "" {
+			c.print(fmt.Sprintf("you didn't react to #%s with %s", shortMessageID(m.ID), text))
+			return
+		}
+		cm.Message = ""
+	case "/edit":
+		cm.Edits = m.ID
+	case "/delete":
+		cm.Deletes = m.ID
+	}
+	switch {
+	case (command == "/edit" || command == "/delete") && m.SenderID != self:
+		c.print("only your own messages can be edited or deleted")
+		return
+	case m.Deleted && command != "/reply":
+		c.print(fmt.Sprintf("#%s was deleted", shortMessageID(m.ID)))
+		return
+	case command == "/react" && len(text) > MaxReactionLength:
+		c.print(fmt.Sprintf("reactions are limited to %d bytes", MaxReactionLength))
+		return
+	}
+	if err = c.cr.PublishMessage(cm); err != nil {
+		c.print(err.Error())
+		return
+	}
+	if c.Sent != nil {
+		c.Sent(cm)
+	}
+}
+
+// setStatus sets our status in the room from what follows /status: a status, then its custom
+// text.  Text alone keeps the status, and nothing shows the current status.
+func (c *Commands) setStatus(args string) {
+	presence := c.cr.Presence()
+	if presence == nil {
+		c.print("presence isn't available in this room")
+		return
+	}
+	status, text := presence.Status()
+	if args == "" {
+		c.print(fmt.Sprintf("you are %s", describeStatus(status, text)))
+		return
+	}
+	first, rest, _ := strings.Cut(args, " ")
+	if validStatus(first) {
+		status, text = first, strings.TrimSpace(rest)
+	} else {
+		text = args
+	}
+	if err := presence.SetStatus(status, text); err != nil {
+		c.print(err.Error())
+		return
+	}
+	c.print(fmt.Sprintf("you are now %s", describeStatus(status, text)))
+}
+
+// TrustStatus returns what the web of trust says about a peer.
+func (c *Commands) TrustStatus(id peer.ID) trust.Status {
+	return c.node.Trust.Store().Evaluate(c.cr.self, id).Status
+}
+
+// showTrust prints how the web of trust reaches a peer.
+func (c *Commands) showTrust(name string) {
+	id, err := c.ResolvePeer(name)
+	if err != nil {
+		c.print(err.Error())
+		return
+	}
+	verdict := c.node.Trust.Store().Evaluate(c.cr.self, id)
+	c.print(fmt.Sprintf("%s is %s", id, verdict.Status))
+	if verdict.Status == trust.Trusted {
+		names := c.DisplayNames(verdict.Path)
+		hops := make([]string, len(verdict.Path))
+		for i, hop := range verdict.Path {
+			hops[i] = names[hop]
+		}
+		c.print(fmt.Sprintf("  vouched for as %s via %s", verdict.Nick, strings.Join(hops, " -> ")))
+	}
+}
+
+// DisplayNames returns the names to show for ids.  A petname is shown as is, otherwise
+// the declared nick is used with a short id suffix when it collides with the nick of
+// ourselves or another peer in the room.
+func (c *Commands) DisplayNames(ids []peer.ID) map[peer.ID]string {
+	active := append([]peer.ID{c.cr.self}, c.cr.ListPeers()...)
+	for _, id := range ids {
+		if !containsPeer(active, id) {
+			active = append(active, id)
+		}
+	}
+	return c.node.Names.DisplayNames(active, c.fallbackName)
+}
+
+// DisplayName returns the name to show for a single peer.
+func (c *Commands) DisplayName(id peer.ID) string {
+	return c.DisplayNames([]peer.ID{id})[id]
+}
+
+// fallbackName names peers we haven't seen a message from: ourselves by our own nick,
+// others by their profile nick or short id.
+func (c *Commands) fallbackName(id peer.ID) string {
+	if id == c.cr.self {
+		return c.cr.nick
+	}
+	if c.node.Profiles != nil {
+		if prof := c.node.Profiles.Cached(id); prof != nil {
+			return prof.Nick
+		}
+	}
+	return shortID(id)
+}
+
+func containsPeer(ids []peer.ID, id peer.ID) bool {
+	for _, other := range ids {
+		if other == id {
+			return true
+		}
+	}
+	return false
+}
+
+// whois looks up the profile of a peer in the room, named by peer id, short id or profile
+// nick, and prints it once the DHT answers.
+func (c *Commands) whois(name string) {
+	id, err := c.ResolvePeer(name)
+	if err != nil {
+		c.print(err.Error())
+		return
+	}
+	if c.node.Profiles == nil {
+		c.print("profiles are not available")
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(c.cr.ctx, profile.LookupTimeout)
+		defer cancel()
+		prof, err := c.node.Profiles.Lookup(ctx, id)
+		if err != nil {
+			c.print(fmt.Sprintf("no profile found for %s: %v", id, err))
+			return
+		}
+		c.print(fmt.Sprintf("%s is %s", id, prof.Nick))
+		if prof.Bio != "" {
+			c.print("  bio: " + prof.Bio)
+		}
+		if prof.Contact != "" {
+			c.print("  contact: " + prof.Contact)
+		}
+		if prof.AvatarHash != "" {
+			c.print("  avatar: " + prof.AvatarHash)
+		}
+		c.print("  updated: " + prof.UpdatedAt.Local().Format(time.RFC1123))
+	}()
+}
+
+// ResolvePeer finds the peer a user means by name: a full peer id, a petname, or the
+// short id, declared nick or profile nick of a peer currently in the room.
+func (c *Commands) ResolvePeer(name string) (peer.ID, error) {
+	if id, err := peer.Decode(name); err == nil {
+		return id, nil
+	}
+	peers := c.cr.ListPeers()
+	if id, ok := c.node.Names.Lookup(name, peers); ok {
+		return id, nil
+	}
+	names := c.DisplayNames(peers)
+	for _, p := range peers {
+		if shortID(p) == name || names[p] == name {
+			return p, nil
+		}
+		if c.node.Profiles != nil {
+			if prof := c.node.Profiles.Cached(p); prof != nil && prof.Nick == name {
+				return p, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no peer named %s in the room", name)
+}
+
+// setPetname gives a peer a local name that overrides the nick it declares.
+func (c *Commands) setPetname(name string, petname string) {
+	id, err := c.ResolvePeer(name)
+	if err != nil {
+		c.print(err.Error())
+		return
+	}
+	if err = c.node.Names.SetPetname(id, petname); err != nil {
+		c.print(err.Error())
+		return
+	}
+	c.print(fmt.Sprintf("%s is now known as %s", id, petname))
+}
+
+// removePetname forgets the local name of a peer.
+func (c *Commands) removePetname(name string) {
+	id, err := c.ResolvePeer(name)
+	if err != nil {
+		c.print(err.Error())
+		return
+	}
+	if err = c.node.Names.RemovePetname(id); err != nil {
+		c.print(err.Error())
+		return
+	}
+	c.print(fmt.Sprintf("removed the petname of %s", id))
+}
+
+// listPetnames prints every petname with the peer id it belongs to.
+func (c *Commands) listPetnames() {
+	ids := c.node.Names.ListPetnames()
+	if len(ids) == 0 {
+		c.print("no petnames set")
+		return
+	}
+	for _, id := range ids {
+		petname, _ := c.node.Names.Petname(id)
+		c.print(fmt.Sprintf("%s %s", petname, id))
+	}
+}
+
+// listDoors prints the doors that can be played and the best score in each.
+func (c *Commands) listDoors() {
+	for _, door := range c.node.Config.DoorList() {
+		line := fmt.Sprintf("%s - %s", door.Name, door.Description)
+		if best := doors.HighScores(c.node.Boards.Store(), door.Name, 1); len(best) > 0 {
+			line += fmt.Sprintf(" (high score %d by %s)", best[0].Score, best[0].Nick)
+		}
+		c.print(line)
+	}
+}
+
+// listModerators prints who owns and moderates the room.
+func (c *Commands) listModerators() {
+	view := c.cr.Moderation()
+	if !view.Moderated() {
+		c.print("nobody moderates this room, its owner is pinned in the owners section of the configuration")
+		return
+	}
+	owner, moderators := view.Manifest.OwnerID(), view.Manifest.ModeratorIDs()
+	names := c.DisplayNames(append([]peer.ID{owner}, moderators...))
+	c.print(fmt.Sprintf("owner: %s (%s)", names[owner], owner))
+	for _, id := range moderators {
+		c.print(fmt.Sprintf("moderator: %s (%s)", names[id], id))
+	}
+}
+
+// claim publishes a manifest naming the moderators of the room, which only the owner pinned for
+// it in the configuration may do.  The owner claims the room again to change its moderators.
+func (c *Commands) claim(names []string) {
+	scope := moderation.RoomScope(c.cr.roomName)
+	if owner, ok := c.node.Moderation.Store().Owner(scope); !ok || owner != c.cr.self {
+		c.print(fmt.Sprintf("only the owner pinned for %s in the owners section of the configuration can claim it", scope))
+		return
+	}
+	moderators := make([]peer.ID, 0, len(names))
+	for _, name := range names {
+		id, err := c.ResolvePeer(name)
+		if err != nil {
+			c.print(err.Error())
+			return
+		}
+		moderators = append(moderators, id)
+	}
+	m, err := moderation.NewManifest(c.node.PrivateKey(), scope, moderators, nil)
+	if err != nil {
+		c.print(err.Error())
+		return
+	}
+	if err = c.node.Moderation.PublishManifest(m); err != nil {
+		c.print(fmt.Sprintf("unable to claim the room: %v", err))
+		return
+	}
+	c.print(fmt.Sprintf("you own %s with %d moderators", c.cr.roomName, len(moderators)))
+}
+
+// showTopic prints the topic the moderators set.
+func (c *Commands) showTopic() {
+	if topic := c.cr.Moderation().Topic; topic != "" {
+		c.print("topic: " + topic)
+		return
+	}
+	c.print("no topic set")
+}
+
+// moderatePeer signs and publishes an action against a peer in the room.
+func (c *Commands) moderatePeer(kind moderation.Kind, name string, duration time.Duration) {
+	id, err := c.ResolvePeer(name)
+	if err != nil {
+		c.print(err.Error())
+		return
+	}
+	c.moderate(kind, id.String(), "", duration)
+}
+
+// moderate signs and publishes an action on the room, which only applies when we moderate it.
+func (c *Commands) moderate(kind moderation.Kind, target string, topic string, duration time.Duration) {
+	if !c.cr.Moderation().IsModerator(c.cr.self) {
+		c.print("you don't moderate this room")
+		return
+	}
+	a, err := moderation.NewAction(c.node.PrivateKey(), moderation.RoomScope(c.cr.roomName), kind, target, topic, duration, "")
+	if err != nil {
+		c.print(err.Error())
+		return
+	}
+	if err = c.node.Moderation.PublishAction(a); err != nil {
+		c.print(fmt.Sprintf("unable to publish the %s action: %v", kind, err))
+		return
+	}
+	c.print(a.String())
+}
+
+// post starts a thread on a board.  Mining the stamp the board asks for runs in the background,
+// its progress shown every MiningProgressInterval.
+func (c *Commands) post(board string, subject string, body string) {
+	p, err := boards.NewPost(c.node.PrivateKey(), c.cr.nick, board, subject, body, nil)
+	if err != nil {
+		c.print(err.Error())
+		return
+	}
+	go func() {
+		shown := time.Now()
+		err := c.node.Boards.Mine(c.cr.ctx, p, func(tried uint64, difficulty int) {
+			if time.Since(shown) < MiningProgressInterval {
+				return
+			}
+			shown = time.Now()
+			c.print(fmt.Sprintf("mining a %d bit stamp for your post on %s: %d of about %d hashes", difficulty, board, tried, pow.Expected(difficulty)))
+		})
+		if err == nil {
+			err = c.node.Boards.Publish(p)
+		}
+		if err != nil {
+			c.print(fmt.Sprintf("unable to post on %s: %v", board, err))
+			return
+		}
+		c.print(fmt.Sprintf("posted %q on %s", subject, board))
+	}()
+}
+
+// search prints the best results of a search of the posts and chat messages seen by the node.
+func (c *Commands) search(text string) {
+	q, err := search.ParseQuery(text, time.Now())
+	if err != nil {
+		c.print(err.Error())
+		return
+	}
+	results := c.node.Search.Search(q, SearchResults)
+	if len(results) == 0 {
+		c.print("nothing found")
+		return
+	}
+	for _, r := range results {
+		c.print(r.Line(60))
+	}
+}
+
+// listMembers prints the members of a private room.
+func (c *Commands) listMembers() {
+	m, ok := c.cr.Members()
+	if !ok {
+		c.print("this is a public room, anyone who knows its name can read it")
+		return
+	}
+	ids := m.MemberIDs()
+	names := c.DisplayNames(ids)
+	c.print(fmt.Sprintf("%d members, key %d", len(ids), m.Epoch))
+	for _, id := range ids {
+		role := "member"
+		if id == m.OwnerID() {
+			role = "owner"
+		}
+		c.print(fmt.Sprintf("%s: %s (%s)", role, names[id], id))
+	}
+}
+
+// changeMembers adds or removes members of a private room we own.  Removing members rotates the
+// key of the room, new members get a join code to pass on.
+func (c *Commands) changeMembers(add bool, names []string) {
+	m, ok := c.cr.Members()
+	if !ok {
+		c.print("only private rooms have members")
+		return
+	}
+	ids := make([]peer.ID, 0, len(names))
+	for _, name := range names {
+		id, err := c.ResolvePeer(name)
+		if err != nil {
+			c.print(err.Error())
+			return
+		}
+		ids = append(ids, id)
+	}
+	var next *private.Membership
+	var err error
+	if add {
+		next, err = m.Add(c.node.PrivateKey(), ids...)
+	} else {
+		next, err = m.Remove(c.node.PrivateKey(), ids...)
+	}
+	if err == nil {
+		_, err = c.node.Private.Add(next)
+	}
+	if err != nil {
+		c.print(err.Error())
+		return
+	}
+	if !add {
+		c.print(fmt.Sprintf("removed %d members, the room has a new key", len(ids)))
+		return
+	}
+	code, err := next.Code()
+	if err != nil {
+		c.print(err.Error())
+		return
+	}
+	c.print(fmt.Sprintf("added %d members, they join with: p2pbbs private join %s", len(ids), code))
+}
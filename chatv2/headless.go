@@ -0,0 +1,290 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package chatv2
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/rightfoot-consulting/p2pbbs/headless"
+)
+
+// headlessPeerRefresh is how often the peers of the joined rooms are checked for arrivals and
+// departures.
+const headlessPeerRefresh = time.Second
+
+// RunHeadless starts the node and joins the configured room like Run, but takes JSON commands
+// from stdin and writes JSON events to stdout instead of drawing the UI.  It returns once stdin
+// ends.
+func (node *ChatV2Node) RunHeadless() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := node.Start(ctx); err != nil {
+		panic(err)
+	}
+	defer node.Close()
+
+	h := &headlessSession{
+		node:   node,
+		ctx:    ctx,
+		events: headless.NewWriter(os.Stdout),
+		rooms:  make(map[string]*headlessRoom),
+	}
+	room := node.Config.Room
+	if len(room) == 0 {
+		room = DefaultRoom
+	}
+	if err := h.join(room); err != nil {
+		panic(err)
+	}
+	go h.relayMessages()
+	go h.watchPeers()
+	if err := h.run(os.Stdin); err != nil {
+		printErr("error reading commands: %s\n", err)
+	}
+	h.mu.Lock()
+	for _, room := range h.rooms {
+		room.cr.Leave()
+	}
+	h.mu.Unlock()
+}
+
+// headlessRoom is a room joined by a headless session, with the peers last seen in it.
+type headlessRoom struct {
+	cr    *ChatRoom
+	peers map[peer.ID]bool
+}
+
+// headlessSession serves the commands of one headless node.
+type headlessSession struct {
+	node   *ChatV2Node
+	ctx    context.Context
+	events *headless.Writer
+
+	mu    sync.Mutex
+	rooms map[string]*headlessRoom
+	first string
+}
+
+// run handles commands from r until it ends.
+func (h *headlessSession) run(r io.Reader) error {
+	return headless.ReadCommands(r, h.events, func(c *headless.Command) error {
+		switch c.Type {
+		case headless.Send:
+			return h.send(c)
+		case headless.Join:
+			return h.join(c.Room)
+		case headless.DM:
+			return h.dm(c.Peer, c.Text)
+		case headless.SetStatus:
+			return h.setStatus(c.Room, c.Status, c.Text)
+		default:
+			return fmt.Errorf("unknown command")
+		}
+	})
+}
+
+// join joins a room and starts reporting its messages.
+func (h *headlessSession) join(name string) error {
+	if name == "" {
+		return fmt.Errorf("no room given")
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.rooms[name]; ok {
+		return nil
+	}
+	cr, err := h.node.JoinRoom(h.ctx, h.node.Nick(), name)
+	if err != nil {
+		return err
+	}
+	h.rooms[name] = &headlessRoom{cr: cr, peers: make(map[peer.ID]bool)}
+	if h.first == "" {
+		h.first = name
+	}
+	go h.readRoom(cr)
+	if presence := cr.Presence(); presence != nil {
+		go h.readPresence(cr.Name(), presence)
+	}
+	return nil
+}
+
+// setStatus sets our status in a room, in every joined room when none is given.
+func (h *headlessSession) setStatus(name string, status string, text string) error {
+	if status == "" {
+		status = StatusOnline
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.rooms[name]; name != "" && !ok {
+		return fmt.Errorf("not in room %s, join it first", name)
+	}
+	for room, r := range h.rooms {
+		if name != "" && room != name {
+			continue
+		}
+		presence := r.cr.Presence()
+		if presence == nil {
+			return fmt.Errorf("presence isn't available in %s", room)
+		}
+		if err := presence.SetStatus(status, text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// send publishes a message to a room, the first room joined when none is given.
+func (h *headlessSession) send(c *headless.Command) error {
+	if strings.TrimSpace(c.Text) == "" && c.Deletes == "" {
+		return fmt.Errorf("no text given")
+	}
+	name := c.Room
+	h.mu.Lock()
+	if name == "" {
+		name = h.first
+	}
+	room, ok := h.rooms[name]
+	h.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("not in room %s, join it first", name)
+	}
+	cm := &ChatMessage{Message: c.Text, ID: c.ID, ReplyTo: c.ReplyTo, Reaction: c.Reaction, Edits: c.Edits, Deletes: c.Deletes}
+	if cm.ID == "" {
+		cm.ID = NewMessageID()
+	}
+	if !validReferences(cm) {
+		return fmt.Errorf("ids are lower case hex, and a message refers to one other at most")
+	}
+	return room.cr.PublishMessage(cm)
+}
+
+// dm sends a direct message to a peer named by peer id or petname.
+func (h *headlessSession) dm(name string, text string) error {
+	if strings.TrimSpace(text) == "" {
+		return fmt.Errorf("no text given")
+	}
+	id, err := peer.Decode(name)
+	if err != nil {
+		var ok bool
+		if id, ok = h.node.Names.Lookup(name, nil); !ok {
+			return fmt.Errorf("no peer named %s", name)
+		}
+	}
+	_, err = h.node.DMs.Send(h.node.PrivateKey(), h.node.Nick(), id, text)
+	return err
+}
+
+// trust returns what the web of trust says about a peer.
+func (h *headlessSession) trust(id peer.ID) string {
+	return h.node.Trust.Store().Evaluate(h.node.Host.ID(), id).Status.String()
+}
+
+// readRoom reports the messages of a room.
+func (h *headlessSession) readRoom(cr *ChatRoom) {
+	for cm := range cr.Messages {
+		id, err := peer.Decode(cm.SenderID)
+		if err != nil {
+			continue
+		}
+		if err = h.node.Names.SeenNick(id, cm.SenderNick); err != nil {
+			h.events.Errorf("unable to save nick: %v", err)
+		}
+		h.events.Write(&headless.Event{
+			Type:     headless.Message,
+			From:     cm.SenderID,
+			Nick:     cm.SenderNick,
+			Trust:    h.trust(id),
+			Room:     cr.Name(),
+			Text:     cm.Message,
+			ID:       cm.ID,
+			ReplyTo:  cm.ReplyTo,
+			Reaction: cm.Reaction,
+			Edits:    cm.Edits,
+			Deletes:  cm.Deletes,
+		})
+	}
+}
+
+// readPresence reports the users of a room joining, leaving, changing their status and typing.
+func (h *headlessSession) readPresence(room string, presence *Presence) {
+	for {
+		select {
+		case e := <-presence.Events:
+			h.events.Write(&headless.Event{
+				Type:   headless.Presence,
+				From:   e.Peer.ID.String(),
+				Nick:   e.Peer.Nick,
+				Trust:  h.trust(e.Peer.ID),
+				Room:   room,
+				Text:   e.Peer.Text,
+				Change: e.Kind,
+				Status: e.Peer.Status,
+				Typing: e.Peer.Typing,
+			})
+		case <-h.ctx.Done():
+			return
+		}
+	}
+}
+
+// relayMessages reports the direct messages sent to us.
+func (h *headlessSession) relayMessages() {
+	self := h.node.Host.ID()
+	messages, stop := h.node.DMs.Mailbox().Subscribe(self)
+	defer stop()
+	for {
+		select {
+		case m := <-messages:
+			if m.FromID() == self {
+				continue
+			}
+			h.events.Write(&headless.Event{
+				Type:  headless.Message,
+				From:  m.From,
+				Nick:  m.Nick,
+				Trust: h.trust(m.FromID()),
+				Text:  m.Body,
+			})
+		case <-h.ctx.Done():
+			return
+		}
+	}
+}
+
+// watchPeers reports peers joining and leaving the joined rooms.
+func (h *headlessSession) watchPeers() {
+	ticker := time.NewTicker(headlessPeerRefresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-h.ctx.Done():
+			return
+		}
+		h.mu.Lock()
+		for name, room := range h.rooms {
+			current := make(map[peer.ID]bool)
+			for _, id := range room.cr.ListPeers() {
+				current[id] = true
+				if !room.peers[id] {
+					h.events.Write(&headless.Event{Type: headless.PeerJoined, Room: name, Peer: id.String(), Trust: h.trust(id)})
+				}
+			}
+			for id := range room.peers {
+				if !current[id] {
+					h.events.Write(&headless.Event{Type: headless.PeerLeft, Room: name, Peer: id.String()})
+				}
+			}
+			room.peers = current
+		}
+		h.mu.Unlock()
+	}
+}
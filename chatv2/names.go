@@ -0,0 +1,276 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package chatv2
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/rightfoot-consulting/p2pbbs/sanitize"
+)
+
+const (
+	// NamesFile is the name of the file in the data directory holding petnames and seen nicks.
+	NamesFile = "names.json"
+	// MaxSeenNicks bounds the nicks a name book remembers, the peers seen longest ago are
+	// forgotten first.
+	MaxSeenNicks = 4096
+	// SeenNickTTL is how long the nick of a peer who isn't seen again is remembered.
+	SeenNickTTL = 90 * 24 * time.Hour
+	// NickSaveDelay is how long newly seen nicks wait to be saved, so a stream of new peers
+	// doesn't rewrite the file for each of them.
+	NickSaveDelay = 10 * time.Second
+)
+
+// NameBook remembers the nicks peers have declared for themselves and the petnames the
+// local user has given them.  Petnames are private to this node and always win over a
+// self declared nick, which any peer can copy.
+type NameBook struct {
+	file string
+
+	mu       sync.Mutex
+	petnames map[peer.ID]string
+	nicks    map[peer.ID]string
+	seen     map[peer.ID]time.Time
+	saving   *time.Timer
+}
+
+// nameBookFile is the on disk form of a NameBook.
+type nameBookFile struct {
+	Petnames map[peer.ID]string    `json:"petnames"`
+	Nicks    map[peer.ID]string    `json:"nicks"`
+	Seen     map[peer.ID]time.Time `json:"seen,omitempty"`
+}
+
+// LoadNameBook reads the name book from file, a missing file gives an empty book.  Nicks not
+// seen for SeenNickTTL are forgotten.
+func LoadNameBook(file string) (book *NameBook, err error) {
+	book = &NameBook{
+		file:     file,
+		petnames: make(map[peer.ID]string),
+		nicks:    make(map[peer.ID]string),
+		seen:     make(map[peer.ID]time.Time),
+	}
+	jsonBytes, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return book, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var contents nameBookFile
+	err = json.Unmarshal(jsonBytes, &contents)
+	if err != nil {
+		return nil, err
+	}
+	for id, name := range contents.Petnames {
+		book.petnames[id] = name
+	}
+	now := time.Now()
+	for id, nick := range contents.Nicks {
+		// nicks saved before they were dated count as seen now
+		seen, ok := contents.Seen[id]
+		if !ok {
+			seen = now
+		}
+		if now.Sub(seen) < SeenNickTTL {
+			book.nicks[id], book.seen[id] = nick, seen
+		}
+	}
+	book.evict()
+	return
+}
+
+// SetPetname assigns a local name to a peer and saves the book.
+func (nb *NameBook) SetPetname(id peer.ID, name string) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return fmt.Errorf("petname can't be empty")
+	}
+	nb.mu.Lock()
+	defer nb.mu.Unlock()
+	for other, petname := range nb.petnames {
+		if other != id && strings.EqualFold(petname, name) {
+			return fmt.Errorf("petname %s is already used for %s", name, other)
+		}
+	}
+	nb.petnames[id] = name
+	return nb.save()
+}
+
+// RemovePetname forgets the local name of a peer and saves the book.
+func (nb *NameBook) RemovePetname(id peer.ID) error {
+	nb.mu.Lock()
+	defer nb.mu.Unlock()
+	delete(nb.petnames, id)
+	return nb.save()
+}
+
+// Petname returns the local name of a peer, if it has one.
+func (nb *NameBook) Petname(id peer.ID) (string, bool) {
+	nb.mu.Lock()
+	defer nb.mu.Unlock()
+	name, ok := nb.petnames[id]
+	return name, ok
+}
+
+// ListPetnames returns the peers with petnames sorted by petname.
+func (nb *NameBook) ListPetnames() []peer.ID {
+	nb.mu.Lock()
+	defer nb.mu.Unlock()
+	ids := make([]peer.ID, 0, len(nb.petnames))
+	for id := range nb.petnames {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return strings.ToLower(nb.petnames[ids[i]]) < strings.ToLower(nb.petnames[ids[j]])
+	})
+	return ids
+}
+
+// SeenNick records the nick a peer declared, saving the book NickSaveDelay later when it
+// changed.  The nick is sanitized first, so names that look alike are stored alike.  Once
+// there are MaxSeenNicks the peer seen longest ago is forgotten.
+func (nb *NameBook) SeenNick(id peer.ID, nick string) error {
+	nick = sanitize.Name(nick)
+	if nick == "" {
+		return nil
+	}
+	nb.mu.Lock()
+	defer nb.mu.Unlock()
+	nb.seen[id] = time.Now()
+	if nb.nicks[id] == nick {
+		return nil
+	}
+	nb.nicks[id] = nick
+	nb.evict()
+	if nb.saving == nil {
+		nb.saving = time.AfterFunc(NickSaveDelay, func() {
+			if err := nb.Flush(); err != nil {
+				logger.Warnf("unable to save nicks: %v", err)
+			}
+		})
+	}
+	return nil
+}
+
+// Flush saves nicks seen since the book was last saved.
+func (nb *NameBook) Flush() error {
+	nb.mu.Lock()
+	defer nb.mu.Unlock()
+	if nb.saving == nil {
+		return nil
+	}
+	return nb.save()
+}
+
+// evict forgets the nicks of the peers seen longest ago beyond MaxSeenNicks, the caller must
+// hold the lock.
+func (nb *NameBook) evict() {
+	for len(nb.nicks) > MaxSeenNicks {
+		var oldest peer.ID
+		for id := range nb.nicks {
+			if oldest == "" || nb.seen[id].Before(nb.seen[oldest]) {
+				oldest = id
+			}
+		}
+		delete(nb.nicks, oldest)
+		delete(nb.seen, oldest)
+	}
+}
+
+// Nick returns the last nick a peer declared.
+func (nb *NameBook) Nick(id peer.ID) (string, bool) {
+	nb.mu.Lock()
+	defer nb.mu.Unlock()
+	nick, ok := nb.nicks[id]
+	return nick, ok
+}
+
+// Lookup finds a peer by petname, or failing that by declared nick among candidates.
+func (nb *NameBook) Lookup(name string, candidates []peer.ID) (peer.ID, bool) {
+	nb.mu.Lock()
+	defer nb.mu.Unlock()
+	for id, petname := range nb.petnames {
+		if strings.EqualFold(petname, name) {
+			return id, true
+		}
+	}
+	for _, id := range candidates {
+		if nick, ok := nb.nicks[id]; ok && strings.EqualFold(nick, name) {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+// DisplayNames returns the name to show for each of ids.  Peers that never declared a nick
+// are named by fallback.
+func (nb *NameBook) DisplayNames(ids []peer.ID, fallback func(peer.ID) string) map[peer.ID]string {
+	nb.mu.Lock()
+	nicks := make(map[peer.ID]string, len(ids))
+	petnames := make(map[peer.ID]string)
+	for _, id := range ids {
+		if nick, ok := nb.nicks[id]; ok {
+			nicks[id] = nick
+		} else {
+			nicks[id] = fallback(id)
+		}
+		if petname, ok := nb.petnames[id]; ok {
+			petnames[id] = petname
+		}
+	}
+	nb.mu.Unlock()
+	return Disambiguate(nicks, petnames)
+}
+
+// save writes the book to its file, the caller must hold the lock.
+func (nb *NameBook) save() error {
+	if nb.saving != nil {
+		nb.saving.Stop()
+		nb.saving = nil
+	}
+	jsonBytes, err := json.MarshalIndent(&nameBookFile{Petnames: nb.petnames, Nicks: nb.nicks, Seen: nb.seen}, "", "    ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(nb.file, jsonBytes, 0600)
+}
+
+// Disambiguate returns the names to display for a set of peers given the nick each declared.
+// Petnames are used as they are.  When two or more peers without petnames share a nick,
+// the last 8 chars of each peer id are appended so they can be told apart.
+func Disambiguate(nicks map[peer.ID]string, petnames map[peer.ID]string) map[peer.ID]string {
+	counts := make(map[string]int)
+	for id, nick := range nicks {
+		if _, ok := petnames[id]; !ok {
+			counts[strings.ToLower(nick)]++
+		}
+	}
+	// a petname may also collide with someone else's declared nick
+	for _, petname := range petnames {
+		if _, ok := counts[strings.ToLower(petname)]; ok {
+			counts[strings.ToLower(petname)]++
+		}
+	}
+	names := make(map[peer.ID]string, len(nicks))
+	for id, nick := range nicks {
+		if petname, ok := petnames[id]; ok {
+			names[id] = petname
+		} else if counts[strings.ToLower(nick)] > 1 {
+			names[id] = fmt.Sprintf("%s#%s", nick, shortID(id))
+		} else {
+			names[id] = nick
+		}
+	}
+	return names
+}
@@ -0,0 +1,108 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package chatv2
+
+import (
+	"crypto/rand"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func randomPeer(t *testing.T) peer.ID {
+	t.Helper()
+	sk, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := peer.IDFromPrivateKey(sk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestDisambiguate(t *testing.T) {
+	alice, bob, mallory := randomPeer(t), randomPeer(t), randomPeer(t)
+	nicks := map[peer.ID]string{alice: "alice", bob: "bob", mallory: "Alice"}
+
+	names := Disambiguate(nicks, nil)
+	if names[bob] != "bob" {
+		t.Errorf("unique nick changed to %s", names[bob])
+	}
+	if names[alice] != "alice#"+shortID(alice) || names[mallory] != "Alice#"+shortID(mallory) {
+		t.Errorf("colliding nicks not disambiguated: %s, %s", names[alice], names[mallory])
+	}
+
+	// once the real alice has a petname the impostor keeps the suffix
+	names = Disambiguate(nicks, map[peer.ID]string{alice: "alice"})
+	if names[alice] != "alice" {
+		t.Errorf("petname not used, got %s", names[alice])
+	}
+	if names[mallory] != "Alice#"+shortID(mallory) {
+		t.Errorf("nick colliding with a petname not disambiguated: %s", names[mallory])
+	}
+}
+
+func TestNameBookPersistence(t *testing.T) {
+	file := filepath.Join(t.TempDir(), NamesFile)
+	alice, bob := randomPeer(t), randomPeer(t)
+
+	book, err := LoadNameBook(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = book.SetPetname(alice, "Alice from accounting"); err != nil {
+		t.Fatal(err)
+	}
+	if err = book.SetPetname(bob, "alice from ACCOUNTING"); err == nil {
+		t.Errorf("duplicate petname accepted")
+	}
+	if err = book.SeenNick(bob, "bobby"); err != nil {
+		t.Fatal(err)
+	}
+	if err = book.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := LoadNameBook(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name, _ := reloaded.Petname(alice); name != "Alice from accounting" {
+		t.Errorf("petname not persisted, got %q", name)
+	}
+	if nick, _ := reloaded.Nick(bob); nick != "bobby" {
+		t.Errorf("nick not persisted, got %q", nick)
+	}
+	if id, ok := reloaded.Lookup("BOBBY", []peer.ID{bob}); !ok || id != bob {
+		t.Errorf("lookup by nick failed")
+	}
+}
+
+func TestSeenNicksBounded(t *testing.T) {
+	book, err := LoadNameBook(filepath.Join(t.TempDir(), NamesFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := peer.ID("peer-first")
+	book.SeenNick(first, "first")
+	book.seen[first] = time.Now().Add(-time.Hour)
+	for i := 0; i < MaxSeenNicks; i++ {
+		book.SeenNick(peer.ID(fmt.Sprintf("peer%d", i)), fmt.Sprintf("nick%d", i))
+	}
+	if len(book.nicks) != MaxSeenNicks {
+		t.Errorf("remembered %d nicks", len(book.nicks))
+	}
+	if _, ok := book.Nick(first); ok {
+		t.Error("the peer seen longest ago wasn't forgotten")
+	}
+	if err = book.Flush(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,134 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package chatv2
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/rightfoot-consulting/p2pbbs/sanitize"
+	"github.com/rivo/tview"
+)
+
+const (
+	// MaxPaneLines is how many lines the message window keeps.
+	MaxPaneLines = ThreadMessages
+	// ReplyContextLength is how much of the message a reply answers is shown above it.
+	ReplyContextLength = 40
+)
+
+// paneLine is a line of the message window: text written as is, or a chat message drawn from
+// the thread of the room after the prefix naming its sender.
+type paneLine struct {
+	text    string
+	message *ChatMessage
+}
+
+// messagePane holds what the message window shows, so messages already shown can be drawn
+// again when they are edited, deleted or reacted to.  It is an io.Writer of whole lines, which
+// are shown as they are written.
+type messagePane struct {
+	box    *tview.TextView
+	thread *Thread
+	// name returns the name to show for the sender of a message
+	name func(cm *ChatMessage) string
+
+	mu    sync.Mutex
+	lines []paneLine
+}
+
+// Write adds lines of text, which may hold colour tags.
+func (p *messagePane) Write(data []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, text := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		p.add(paneLine{text: text})
+	}
+	p.draw()
+	return len(data), nil
+}
+
+// addMessage adds a chat message after prefix, which names its sender.
+func (p *messagePane) addMessage(prefix string, cm *ChatMessage) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.add(paneLine{text: prefix, message: cm})
+	p.draw()
+}
+
+// redraw draws every line again, after the thread changed messages already shown.
+func (p *messagePane) redraw() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.draw()
+}
+
+// add adds a line, forgetting the oldest beyond MaxPaneLines.  The caller must hold the lock.
+func (p *messagePane) add(line paneLine) {
+	p.lines = append(p.lines, line)
+	if len(p.lines) > MaxPaneLines {
+		p.lines = p.lines[len(p.lines)-MaxPaneLines:]
+	}
+}
+
+// draw sets the text of the message window.  The caller must hold the lock.
+func (p *messagePane) draw() {
+	var b strings.Builder
+	for _, line := range p.lines {
+		if line.message == nil {
+			b.WriteString(line.text)
+		} else {
+			p.drawMessage(&b, line.text, line.message)
+		}
+		b.WriteByte('\n')
+	}
+	p.box.SetText(b.String())
+}
+
+// drawMessage writes a chat message as it stands: what it replies to on a line of its own, then
+// its text marked when it was edited, the reactions to it and the start of its id.  Messages
+// without an id, from clients that don't know about references, are only their text.
+func (p *messagePane) drawMessage(b *strings.Builder, prefix string, cm *ChatMessage) {
+	m, ok := p.thread.Get(cm.ID)
+	if !ok || m.ChatMessage != cm {
+		fmt.Fprintf(b, "%s %s", prefix, sanitize.TviewLine(cm.Message))
+		return
+	}
+	if cm.ReplyTo != "" {
+		quote := fmt.Sprintf("  ↳ #%s", shortMessageID(cm.ReplyTo))
+		if replied, ok := p.thread.Get(cm.ReplyTo); ok {
+			quote = fmt.Sprintf("  ↳ <%s> %s", p.name(replied.ChatMessage), snippet(replied))
+		}
+		fmt.Fprintf(b, "%s\n", withColor("gray", sanitize.TviewLine(quote)))
+	}
+	if m.Deleted {
+		fmt.Fprintf(b, "%s %s", prefix, withColor("gray", "(deleted)"))
+	} else {
+		fmt.Fprintf(b, "%s %s", prefix, sanitize.TviewLine(m.Text))
+	}
+	if m.Edited && !m.Deleted {
+		b.WriteString(withColor("gray", " (edited)"))
+	}
+	if len(m.Reactions) > 0 {
+		counts := make([]string, len(m.Reactions))
+		for i, r := range m.Reactions {
+			counts[i] = fmt.Sprintf("%s %d", r.Text, r.Count)
+		}
+		b.WriteString(withColor("yellow", sanitize.TviewLine("  "+strings.Join(counts, "  "))))
+	}
+	b.WriteString(withColor("gray", " #"+shortMessageID(cm.ID)))
+}
+
+// snippet returns the start of the text of a message a reply answers.
+func snippet(m *ThreadMessage) string {
+	if m.Deleted {
+		return "(deleted)"
+	}
+	text := []rune(m.Text)
+	if len(text) <= ReplyContextLength {
+		return m.Text
+	}
+	return string(text[:ReplyContextLength]) + "…"
+}
@@ -0,0 +1,511 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package chatv2
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/rightfoot-consulting/p2pbbs/private"
+)
+
+const (
+	// HeartbeatInterval is how often a user in a room says they are still there.
+	HeartbeatInterval = 15 * time.Second
+	// PresenceTimeout is how long a user is kept in a room without a heartbeat.
+	PresenceTimeout = 3 * HeartbeatInterval
+	// JoinRetry is how often a user who just joined says so again until somebody answers, as
+	// the first may go out before the topic has any peers to deliver it to.
+	JoinRetry = 2 * time.Second
+	// TypingRefresh is how often a user who keeps typing says so again.
+	TypingRefresh = 3 * time.Second
+	// TypingTimeout is how long a user is shown typing after they last said so, and how long
+	// after the last key we say we stopped.
+	TypingTimeout = 2 * TypingRefresh
+	// MaxPresenceBytes bounds the encoded size of a presence message.
+	MaxPresenceBytes = 1 << 10
+	// MaxStatusLength bounds the custom text of a status.
+	MaxStatusLength = 64
+	// PresenceEventBufSize is how many presence events wait for the front end before more are
+	// dropped.
+	PresenceEventBufSize = 64
+	// presenceSessionBytes is the size of the random session id of a user, written as hex.
+	presenceSessionBytes = 8
+)
+
+// Statuses a user can set.
+const (
+	StatusOnline = "online"
+	StatusAway   = "away"
+	StatusDND    = "dnd"
+)
+
+// Kinds of presence messages.
+const (
+	presenceJoin      = "join"
+	presenceHeartbeat = "heartbeat"
+	presenceTyping    = "typing"
+	presenceStopped   = "stopped"
+	presenceLeave     = "leave"
+)
+
+// Kinds of presence events.
+const (
+	// PresenceJoined reports a user who just joined the room.
+	PresenceJoined = "joined"
+	// PresenceSeen reports a user who was already in the room when we joined, or came back
+	// after we lost track of them.
+	PresenceSeen = "seen"
+	// PresenceLeft reports a user who left the room or stopped sending heartbeats.
+	PresenceLeft = "left"
+	// PresenceChanged reports a user who changed their status.
+	PresenceChanged = "changed"
+	// PresenceTyping reports a user who started or stopped typing.
+	PresenceTyping = "typing"
+)
+
+// PresenceMessage is published on the presence topic of a room, which sits beside its chat
+// topic.  Session tells apart the users of a node, who share its peer id.  Active is when the
+// user last typed or sent a message, which gives their idle time.
+type PresenceMessage struct {
+	Kind      string
+	Session   string
+	Nick      string
+	Status    string
+	Text      string `json:",omitempty"`
+	Active    time.Time
+	Timestamp time.Time
+}
+
+// PeerPresence is what we know of a user in a room.
+type PeerPresence struct {
+	ID      peer.ID
+	Session string
+	Nick    string
+	Status  string
+	Text    string
+	Typing  bool
+	Active  time.Time
+	Seen    time.Time
+
+	typingUntil time.Time
+}
+
+// Idle returns how long the user hasn't typed or sent anything.
+func (p *PeerPresence) Idle(now time.Time) time.Duration {
+	return max(now.Sub(p.Active), 0)
+}
+
+// PresenceEvent is a change to the users in a room.
+type PresenceEvent struct {
+	Kind string
+	Peer PeerPresence
+}
+
+// validStatus reports whether status is one a user can set.
+func validStatus(status string) bool {
+	return status == StatusOnline || status == StatusAway || status == StatusDND
+}
+
+// parsePresence decodes and checks the presence message msg carries in data, which is its
+// payload unless the room encrypts it.
+func parsePresence(msg *pubsub.Message, data []byte, now time.Time) (pm *PresenceMessage, err error) {
+	if len(data) > MaxPresenceBytes {
+		return nil, fmt.Errorf("presence of %d bytes", len(data))
+	}
+	if msg.Signature == nil {
+		return nil, fmt.Errorf("unsigned presence")
+	}
+	pm = new(PresenceMessage)
+	if err = json.Unmarshal(data, pm); err != nil {
+		return nil, err
+	}
+	switch pm.Kind {
+	case presenceJoin, presenceHeartbeat, presenceTyping, presenceStopped, presenceLeave:
+	default:
+		return nil, fmt.Errorf("unknown presence %q", pm.Kind)
+	}
+	switch {
+	case !validMessageID(pm.Session):
+		return nil, fmt.Errorf("invalid session %q", pm.Session)
+	case pm.Nick == "" || len(pm.Nick) > MaxNickLength || !utf8.ValidString(pm.Nick):
+		return nil, fmt.Errorf("invalid nick %q", pm.Nick)
+	case !validStatus(pm.Status):
+		return nil, fmt.Errorf("invalid status %q", pm.Status)
+	case len(pm.Text) > MaxStatusLength || !utf8.ValidString(pm.Text):
+		return nil, fmt.Errorf("invalid status text")
+	case pm.Timestamp.Before(now.Add(-MaxClockSkew)) || pm.Timestamp.After(now.Add(MaxClockSkew)):
+		return nil, errClockSkew
+	case pm.Active.After(pm.Timestamp):
+		return nil, fmt.Errorf("active after it was sent")
+	}
+	return
+}
+
+// presenceValidator checks the messages on the presence topic of a room, opening those of
+// private rooms, which are sealed like their chat messages.  Senders get an allowance of their
+// own, as large as that of chat messages, so heartbeats and typing don't use up what they may
+// say.  Like chat messages, presence over the allowance is ignored rather than rejected.
+type presenceValidator struct {
+	room   string
+	keys   *private.Store
+	limits *messageValidator
+}
+
+// Validate is the pubsub.ValidatorEx of a presence topic.
+func (v *presenceValidator) Validate(_ context.Context, _ peer.ID, msg *pubsub.Message) pubsub.ValidationResult {
+	data := msg.Data
+	if v.keys != nil {
+		e := new(private.Envelope)
+		if err := json.Unmarshal(msg.Data, e); err != nil {
+			logger.Debugf("rejecting presence from %s: %v", msg.GetFrom(), err)
+			return pubsub.ValidationReject
+		}
+		var err error
+		data, err = v.keys.Open(v.room, msg.GetFrom(), e)
+		if errors.Is(err, private.ErrNotMember) || errors.Is(err, private.ErrUnknownEpoch) {
+			return pubsub.ValidationIgnore
+		}
+		if err != nil {
+			logger.Debugf("rejecting presence from %s: %v", msg.GetFrom(), err)
+			return pubsub.ValidationReject
+		}
+	}
+	now := time.Now()
+	pm, err := parsePresence(msg, data, now)
+	if err == errClockSkew {
+		return pubsub.ValidationIgnore
+	}
+	if err != nil {
+		logger.Debugf("rejecting presence from %s: %v", msg.GetFrom(), err)
+		return pubsub.ValidationReject
+	}
+	if !v.limits.allow(msg.GetFrom(), now) {
+		logger.Debugf("ignoring presence from %s: sending too fast", msg.GetFrom())
+		return pubsub.ValidationIgnore
+	}
+	msg.ValidatorData = pm
+	return pubsub.ValidationAccept
+}
+
+// presenceTopicName names the presence topic beside the chat topic called topic.
+func presenceTopicName(topic string) string {
+	return topic + "/presence"
+}
+
+// joinPresenceTopic joins the presence topic beside a chat topic.  keys and room seal the
+// presence of a private room, keys is nil for public rooms.  Senders get an allowance of the
+// burst and interval of scoring.
+func joinPresenceTopic(ps *pubsub.PubSub, chatTopic string, keys *private.Store, room string, scoring *ScoringConfig) (topic *pubsub.Topic, err error) {
+	name := presenceTopicName(chatTopic)
+	validator := &presenceValidator{room: room, keys: keys, limits: newMessageValidator(scoring)}
+	if err = ps.RegisterTopicValidator(name, validator.Validate); err != nil {
+		return
+	}
+	topic, err = ps.Join(name)
+	if err != nil {
+		ps.UnregisterTopicValidator(name)
+	}
+	return
+}
+
+// Presence tracks the users in a room from their heartbeats, and tells them about ours.
+type Presence struct {
+	// Events reports users joining and leaving the room, changing their status and typing.
+	// Events nobody reads are dropped once PresenceEventBufSize are waiting.
+	Events chan *PresenceEvent
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	topic   *pubsub.Topic
+	sub     *pubsub.Subscription
+	self    peer.ID
+	session string
+	nick    string
+	// the id of a private room and the keys its presence is sealed with, nil for public rooms
+	room string
+	keys *private.Store
+	// when we joined, and said so until somebody answered
+	started time.Time
+
+	mu       sync.Mutex
+	status   string
+	text     string
+	active   time.Time
+	typing   bool
+	lastKey  time.Time
+	beat     time.Time
+	answered time.Time
+	peers    map[string]*PeerPresence
+}
+
+// newPresence starts telling the users of a room about us, as nick, until ctx ends or Leave
+// is called.
+func newPresence(ctx context.Context, topic *pubsub.Topic, self peer.ID, nick string, keys *private.Store, room string) (*Presence, error) {
+	sub, err := topic.Subscribe()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	p := &Presence{
+		Events:  make(chan *PresenceEvent, PresenceEventBufSize),
+		ctx:     ctx,
+		cancel:  cancel,
+		topic:   topic,
+		sub:     sub,
+		self:    self,
+		session: NewMessageID(),
+		nick:    nick,
+		room:    room,
+		keys:    keys,
+		status:  StatusOnline,
+		started: time.Now(),
+		active:  time.Now(),
+		peers:   make(map[string]*PeerPresence),
+	}
+	go p.readLoop()
+	go p.run()
+	return p, nil
+}
+
+// Peers returns the other users in the room, by nick.
+func (p *Presence) Peers() []PeerPresence {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	peers := make([]PeerPresence, 0, len(p.peers))
+	for _, pp := range p.peers {
+		peers = append(peers, *pp)
+	}
+	sort.Slice(peers, func(i, j int) bool {
+		if a, b := strings.ToLower(peers[i].Nick), strings.ToLower(peers[j].Nick); a != b {
+			return a < b
+		}
+		return peers[i].ID < peers[j].ID
+	})
+	return peers
+}
+
+// Status returns our status and its custom text.
+func (p *Presence) Status() (status string, text string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.status, p.text
+}
+
+// SetStatus changes our status and its custom text, and tells the room.
+func (p *Presence) SetStatus(status string, text string) error {
+	switch {
+	case !validStatus(status):
+		return fmt.Errorf("unknown status %s, use %s, %s or %s", status, StatusOnline, StatusAway, StatusDND)
+	case len(text) > MaxStatusLength || !utf8.ValidString(text):
+		return fmt.Errorf("the text of a status is limited to %d bytes", MaxStatusLength)
+	}
+	p.mu.Lock()
+	p.status, p.text = status, text
+	p.mu.Unlock()
+	return p.publish(presenceHeartbeat)
+}
+
+// Typing notes that the user pressed a key, telling the room they are typing unless it knows.
+func (p *Presence) Typing() {
+	p.mu.Lock()
+	now := time.Now()
+	p.lastKey, p.active = now, now
+	started := !p.typing
+	p.typing = true
+	p.mu.Unlock()
+	if started {
+		go p.publish(presenceTyping)
+	}
+}
+
+// StopTyping tells the room the user stopped typing, when they were.
+func (p *Presence) StopTyping() {
+	p.mu.Lock()
+	stopped := p.typing
+	p.typing = false
+	p.mu.Unlock()
+	if stopped {
+		go p.publish(presenceStopped)
+	}
+}
+
+// Touch notes that the user sent a message, which also ends their typing.
+func (p *Presence) Touch() {
+	p.mu.Lock()
+	p.active = time.Now()
+	p.mu.Unlock()
+	p.StopTyping()
+}
+
+// Leave tells the room we left and stops the heartbeats.
+func (p *Presence) Leave() {
+	if p.ctx.Err() == nil {
+		p.publish(presenceLeave)
+	}
+	p.cancel()
+	p.sub.Cancel()
+}
+
+// publish sends a presence message of kind with our current state.
+func (p *Presence) publish(kind string) error {
+	p.mu.Lock()
+	pm := &PresenceMessage{
+		Kind:      kind,
+		Session:   p.session,
+		Nick:      p.nick,
+		Status:    p.status,
+		Text:      p.text,
+		Active:    p.active.UTC(),
+		Timestamp: time.Now().UTC(),
+	}
+	if kind != presenceLeave {
+		p.beat = pm.Timestamp
+	}
+	p.mu.Unlock()
+	data, err := json.Marshal(pm)
+	if err != nil {
+		return err
+	}
+	if p.keys != nil {
+		e, err := p.keys.Seal(p.room, data)
+		if err != nil {
+			return err
+		}
+		if data, err = json.Marshal(e); err != nil {
+			return err
+		}
+	}
+	return p.topic.Publish(p.ctx, data)
+}
+
+// run sends our heartbeats and typing, and forgets users who went quiet, until the presence
+// ends.
+func (p *Presence) run() {
+	if err := p.publish(presenceJoin); err != nil {
+		logger.Debugf("unable to announce ourselves: %v", err)
+	}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-p.ctx.Done():
+			return
+		}
+		now := time.Now()
+		var events []*PresenceEvent
+		p.mu.Lock()
+		heartbeat := now.Sub(p.beat) >= HeartbeatInterval
+		rejoin := len(p.peers) == 0 && now.Sub(p.started) < HeartbeatInterval && now.Sub(p.beat) >= JoinRetry
+		if p.typing && now.Sub(p.lastKey) >= TypingTimeout {
+			p.typing = false
+			go p.publish(presenceStopped)
+		} else if p.typing && now.Sub(p.beat) >= TypingRefresh {
+			go p.publish(presenceTyping)
+			heartbeat = false
+		}
+		for key, pp := range p.peers {
+			switch {
+			case now.Sub(pp.Seen) >= PresenceTimeout:
+				delete(p.peers, key)
+				events = append(events, &PresenceEvent{Kind: PresenceLeft, Peer: *pp})
+			case pp.Typing && now.After(pp.typingUntil):
+				pp.Typing = false
+				events = append(events, &PresenceEvent{Kind: PresenceTyping, Peer: *pp})
+			}
+		}
+		p.mu.Unlock()
+		if rejoin {
+			if err := p.publish(presenceJoin); err != nil {
+				logger.Debugf("unable to announce ourselves: %v", err)
+			}
+		} else if heartbeat {
+			if err := p.publish(presenceHeartbeat); err != nil {
+				logger.Debugf("unable to send a heartbeat: %v", err)
+			}
+		}
+		p.emit(events...)
+	}
+}
+
+// readLoop follows the presence of the other users in the room.
+func (p *Presence) readLoop() {
+	for {
+		msg, err := p.sub.Next(p.ctx)
+		if err != nil {
+			return
+		}
+		pm, ok := msg.ValidatorData.(*PresenceMessage)
+		if !ok || msg.GetFrom() == p.self && pm.Session == p.session {
+			continue
+		}
+		p.emit(p.update(msg.GetFrom(), pm)...)
+	}
+}
+
+// update applies a presence message from a user, returning what changed.
+func (p *Presence) update(from peer.ID, pm *PresenceMessage) (events []*PresenceEvent) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	key := from.String() + "/" + pm.Session
+	pp, known := p.peers[key]
+	if pm.Kind == presenceLeave {
+		if known {
+			delete(p.peers, key)
+			events = append(events, &PresenceEvent{Kind: PresenceLeft, Peer: *pp})
+		}
+		return
+	}
+	if !known {
+		pp = &PeerPresence{ID: from, Session: pm.Session}
+		p.peers[key] = pp
+	}
+	changed := known && (pp.Nick != pm.Nick || pp.Status != pm.Status || pp.Text != pm.Text)
+	pp.Nick, pp.Status, pp.Text, pp.Active, pp.Seen = pm.Nick, pm.Status, pm.Text, pm.Active, now
+	switch {
+	case !known && pm.Kind == presenceJoin:
+		events = append(events, &PresenceEvent{Kind: PresenceJoined, Peer: *pp})
+	case !known:
+		events = append(events, &PresenceEvent{Kind: PresenceSeen, Peer: *pp})
+	case changed:
+		events = append(events, &PresenceEvent{Kind: PresenceChanged, Peer: *pp})
+	}
+	typing := pp.Typing
+	switch pm.Kind {
+	case presenceTyping:
+		pp.Typing, pp.typingUntil = true, now.Add(TypingTimeout)
+	case presenceStopped:
+		pp.Typing = false
+	}
+	if pp.Typing != typing {
+		events = append(events, &PresenceEvent{Kind: PresenceTyping, Peer: *pp})
+	}
+	// a user who just joined learns about us without waiting for our next heartbeat
+	if pm.Kind == presenceJoin && now.Sub(p.answered) >= time.Second {
+		p.answered = now
+		go p.publish(presenceHeartbeat)
+	}
+	return
+}
+
+// emit hands events to the front end, dropping them when it doesn't keep up.
+func (p *Presence) emit(events ...*PresenceEvent) {
+	for _, e := range events {
+		select {
+		case p.Events <- e:
+		default:
+		}
+	}
+}
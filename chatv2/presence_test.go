@@ -0,0 +1,115 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package chatv2
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+)
+
+func TestParsePresence(t *testing.T) {
+	alice := randomPeer(t)
+	now := time.Now()
+	valid := func() *PresenceMessage {
+		return &PresenceMessage{Kind: presenceHeartbeat, Session: "5e55", Nick: "alice", Status: StatusAway, Text: "lunch", Active: now.Add(-time.Minute), Timestamp: now}
+	}
+	encodePresence := func(pm *PresenceMessage) []byte {
+		data, err := json.Marshal(pm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	if pm, err := parsePresence(pubsubMessage(t, alice, nil), encodePresence(valid()), now); err != nil || pm.Text != "lunch" {
+		t.Errorf("valid presence parsed to %+v: %v", pm, err)
+	}
+	for name, change := range map[string]func(pm *PresenceMessage){
+		"unknown kind":   func(pm *PresenceMessage) { pm.Kind = "dance" },
+		"bad session":    func(pm *PresenceMessage) { pm.Session = "Session" },
+		"no nick":        func(pm *PresenceMessage) { pm.Nick = "" },
+		"unknown status": func(pm *PresenceMessage) { pm.Status = "busy" },
+		"long text":      func(pm *PresenceMessage) { pm.Text = strings.Repeat("z", MaxStatusLength+1) },
+		"old":            func(pm *PresenceMessage) { pm.Timestamp = now.Add(-2 * MaxClockSkew) },
+		"active later":   func(pm *PresenceMessage) { pm.Active = now.Add(time.Second) },
+	} {
+		pm := valid()
+		change(pm)
+		if _, err := parsePresence(pubsubMessage(t, alice, nil), encodePresence(pm), now); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+	unsigned := pubsubMessage(t, alice, nil)
+	unsigned.Signature = nil
+	if _, err := parsePresence(unsigned, encodePresence(valid()), now); err == nil {
+		t.Error("accepted unsigned presence")
+	}
+
+	// presence over the allowance is dropped without penalizing the relay
+	v := &presenceValidator{limits: newMessageValidator(&ScoringConfig{MessageBurst: 2, MessageInterval: 1000})}
+	for i, want := range []pubsub.ValidationResult{pubsub.ValidationAccept, pubsub.ValidationAccept, pubsub.ValidationIgnore} {
+		if r := v.Validate(context.Background(), alice, pubsubMessage(t, alice, encodePresence(valid()))); r != want {
+			t.Errorf("presence %d of a flood got %v, want %v", i, r, want)
+		}
+	}
+	if r := v.Validate(context.Background(), alice, pubsubMessage(t, alice, []byte("beat"))); r != pubsub.ValidationReject {
+		t.Errorf("malformed presence got %v", r)
+	}
+}
+
+func TestPresenceUpdate(t *testing.T) {
+	alice := randomPeer(t)
+	// answered just now, so joins aren't answered on a topic the test doesn't have
+	p := &Presence{Events: make(chan *PresenceEvent, PresenceEventBufSize), peers: make(map[string]*PeerPresence), answered: time.Now()}
+	beat := func(kind string, session string, status string) []*PresenceEvent {
+		return p.update(alice, &PresenceMessage{Kind: kind, Session: session, Nick: "alice", Status: status, Timestamp: time.Now()})
+	}
+	kinds := func(events []*PresenceEvent) (kinds []string) {
+		for _, e := range events {
+			kinds = append(kinds, e.Kind)
+		}
+		return
+	}
+	for i, test := range []struct {
+		kind    string
+		session string
+		status  string
+		want    string
+	}{
+		{presenceJoin, "a1", StatusOnline, PresenceJoined},
+		{presenceHeartbeat, "a1", StatusOnline, ""},
+		{presenceHeartbeat, "a1", StatusDND, PresenceChanged},
+		{presenceTyping, "a1", StatusDND, PresenceTyping},
+		{presenceTyping, "a1", StatusDND, ""},
+		{presenceStopped, "a1", StatusDND, PresenceTyping},
+		// another user of the same node
+		{presenceHeartbeat, "a2", StatusOnline, PresenceSeen},
+		{presenceLeave, "a1", StatusDND, PresenceLeft},
+		{presenceLeave, "a1", StatusDND, ""},
+	} {
+		if got := strings.Join(kinds(beat(test.kind, test.session, test.status)), ","); got != test.want {
+			t.Errorf("%d: %s gave %q, want %q", i, test.kind, got, test.want)
+		}
+	}
+	if peers := p.Peers(); len(peers) != 1 || peers[0].Session != "a2" {
+		t.Errorf("peers %+v", peers)
+	}
+}
+
+func TestFormatIdle(t *testing.T) {
+	for d, want := range map[time.Duration]string{
+		30 * time.Second: "",
+		90 * time.Second: "1m",
+		5 * time.Hour:    "5h",
+		50 * time.Hour:   "2d",
+	} {
+		if got := formatIdle(d); got != want {
+			t.Errorf("%v: %q, want %q", d, got, want)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package chatv2
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/rightfoot-consulting/p2pbbs/private"
+)
+
+const (
+	// MaxPacketBytes bounds what is published on the topic of a private room, the announcement
+	// of a room with the most members it may have fits.
+	MaxPacketBytes = 128 << 10
+	// AnnounceInterval is how often members of a private room republish its membership, so
+	// members who were offline when it changed catch up.
+	AnnounceInterval = time.Minute
+)
+
+// privatePacket is what travels on the topic of a private room: the announcement of its
+// membership, or a chat message sealed under the group key.  Both are opaque to anyone who isn't
+// a member.
+type privatePacket struct {
+	Announcement *private.Announcement `json:"announcement,omitempty"`
+	Envelope     *private.Envelope     `json:"envelope,omitempty"`
+}
+
+// privateValidator checks the messages on the topic of a private room.  Chat messages are
+// opened and then checked like those of public rooms, and handed to the subscriptions already
+// decoded.  Messages we can't judge, from members we don't know of yet or under a key we don't
+// have yet, are ignored.
+type privateValidator struct {
+	room     string
+	store    *private.Store
+	messages *messageValidator
+}
+
+// Validate is the pubsub.ValidatorEx of a private room topic.
+func (v *privateValidator) Validate(_ context.Context, _ peer.ID, msg *pubsub.Message) pubsub.ValidationResult {
+	if len(msg.Data) > MaxPacketBytes {
+		logger.Debugf("rejecting private message from %s: %d bytes", msg.GetFrom(), len(msg.Data))
+		return pubsub.ValidationReject
+	}
+	p := new(privatePacket)
+	if err := json.Unmarshal(msg.Data, p); err != nil {
+		logger.Debugf("rejecting private message from %s: %v", msg.GetFrom(), err)
+		return pubsub.ValidationReject
+	}
+	switch {
+	case p.Announcement != nil:
+		return v.validateAnnouncement(msg, p.Announcement)
+	case p.Envelope != nil:
+		data, err := v.store.Open(v.room, msg.GetFrom(), p.Envelope)
+		if errors.Is(err, private.ErrNotMember) || errors.Is(err, private.ErrUnknownEpoch) {
+			logger.Debugf("ignoring private message from %s: %v", msg.GetFrom(), err)
+			return pubsub.ValidationIgnore
+		}
+		if err != nil {
+			logger.Debugf("rejecting private message from %s: %v", msg.GetFrom(), err)
+			return pubsub.ValidationReject
+		}
+		cm, result := v.messages.validate(msg, data)
+		if cm != nil {
+			msg.ValidatorData = cm
+		}
+		return result
+	}
+	return pubsub.ValidationReject
+}
+
+// validateAnnouncement accepts records of the room signed by its owner, ignoring outdated ones
+// and those we can't open, as members removed from the room no longer can.
+func (v *privateValidator) validateAnnouncement(msg *pubsub.Message, a *private.Announcement) pubsub.ValidationResult {
+	m, err := v.store.OpenAnnouncement(v.room, a)
+	if errors.Is(err, private.ErrNotMember) {
+		logger.Debugf("ignoring membership from %s: %v", msg.GetFrom(), err)
+		return pubsub.ValidationIgnore
+	}
+	if err != nil {
+		logger.Debugf("rejecting membership from %s: %v", msg.GetFrom(), err)
+		return pubsub.ValidationReject
+	}
+	if latest, ok := v.store.Latest(v.room); ok && m.Version < latest.Version {
+		return pubsub.ValidationIgnore
+	}
+	msg.ValidatorData = m
+	return pubsub.ValidationAccept
+}
+
+// joinPrivateTopic joins the topic of a private room, like joinRoomTopic does for public rooms.
+func joinPrivateTopic(ps *pubsub.PubSub, store *private.Store, room string, scoring *ScoringConfig) (topic *pubsub.Topic, err error) {
+	name := private.TopicName(room)
+	validator := &privateValidator{room: room, store: store, messages: newMessageValidator(scoring)}
+	if err = ps.RegisterTopicValidator(name, validator.Validate); err != nil {
+		return
+	}
+	topic, err = ps.Join(name)
+	if err != nil {
+		ps.UnregisterTopicValidator(name)
+		return
+	}
+	if !scoring.Disabled {
+		if err = topic.SetScoreParams(scoring.topicScoreParams()); err != nil {
+			topic.Close()
+			ps.UnregisterTopicValidator(name)
+			return nil, err
+		}
+	}
+	return
+}
+
+// publishPrivate seals a chat message to a private room and publishes it.
+func publishPrivate(ctx context.Context, topic *pubsub.Topic, store *private.Store, room string, data []byte) error {
+	e, err := store.Seal(room, data)
+	if err != nil {
+		return err
+	}
+	packet, err := json.Marshal(&privatePacket{Envelope: e})
+	if err != nil {
+		return err
+	}
+	return topic.Publish(ctx, packet)
+}
+
+// announceMembership keeps the members of a private room in step until ctx ends.  Records
+// announced on its topic are added to the store.  The newest record is published when it changes
+// here, and every AnnounceInterval unless another member announced it since.
+func (node *ChatV2Node) announceMembership(ctx context.Context, room string, topic *pubsub.Topic) {
+	sub, err := topic.Subscribe()
+	if err != nil {
+		logger.Warnf("unable to announce the members of %s: %v", room, err)
+		return
+	}
+	defer sub.Cancel()
+	changes, stop := node.Private.Subscribe()
+	defer stop()
+	received := make(chan *private.Membership)
+	go func() {
+		defer close(received)
+		for {
+			msg, err := sub.Next(ctx)
+			if err != nil {
+				return
+			}
+			if m, ok := msg.ValidatorData.(*private.Membership); ok && msg.ReceivedFrom != node.Host.ID() {
+				select {
+				case received <- m:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	var heard uint64
+	announce := func() {
+		m, ok := node.Private.Latest(room)
+		if !ok || m.Version <= heard {
+			return
+		}
+		a, err := node.Private.Announcement(room)
+		var packet []byte
+		if err == nil {
+			packet, err = json.Marshal(&privatePacket{Announcement: a})
+		}
+		if err == nil {
+			err = topic.Publish(ctx, packet)
+		}
+		if err != nil {
+			logger.Debugf("unable to announce the members of %s: %v", room, err)
+		}
+	}
+	announce()
+	ticker := time.NewTicker(AnnounceInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case m, ok := <-received:
+			if !ok {
+				return
+			}
+			if _, err = node.Private.Add(m); err != nil {
+				logger.Debugf("rejected membership of %s: %v", room, err)
+			}
+			heard = max(heard, m.Version)
+		case changed := <-changes:
+			if changed == room {
+				announce()
+			}
+		case <-ticker.C:
+			announce()
+			heard = 0
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// privateRoom finds the private room called name, nil when it is a public room.
+func (node *ChatV2Node) privateRoom(name string) (*private.Membership, error) {
+	m, err := node.Private.Find(name)
+	if errors.Is(err, private.ErrNoRoom) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !m.IsMember(node.Host.ID()) {
+		return nil, fmt.Errorf("%s: %w", name, private.ErrNotMember)
+	}
+	return m, nil
+}
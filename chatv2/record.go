@@ -0,0 +1,147 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package chatv2
+
+import (
+	"context"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/rightfoot-consulting/p2pbbs/moderation"
+	"github.com/rightfoot-consulting/p2pbbs/search"
+	"github.com/rightfoot-consulting/p2pbbs/transcript"
+)
+
+// recordRoom adds the chat messages of a room to the search index and to its transcript until
+// ctx ends.  It reads its own subscription to the topic, so messages are recorded once however
+// many local users are in the room, and ours are recorded too.  The search index is kept in
+// plaintext, so messages of private rooms only go to their sealed transcript, and those earlier
+// versions indexed are removed from it.  Transcripts keep the IDs and references of messages,
+// with their reactions, edits and deletions, so rooms replaying them can resolve them again.
+func (node *ChatV2Node) recordRoom(ctx context.Context, room string, topic *pubsub.Topic, private bool) {
+	sub, err := topic.Subscribe()
+	if err != nil {
+		logger.Warnf("unable to record %s: %v", room, err)
+		return
+	}
+	defer sub.Cancel()
+	if private {
+		// a public room of the same name loses its messages too, they can't be told apart
+		if _, err = node.Search.Remove(func(d *search.Document) bool { return d.Kind == search.Chat && d.Scope == room }); err != nil {
+			logger.Warnf("unable to remove the messages of %s from the search index: %v", room, err)
+		}
+	}
+	var log *transcript.Log
+	if !node.Transcripts.Config().Disabled {
+		if log, err = node.Transcripts.Log(room, private); err != nil {
+			logger.Warnf("unable to keep the transcript of %s: %v", room, err)
+		}
+	}
+	// resolves edits and deletions the way the rooms shown to users do
+	thread := NewThread()
+	for {
+		msg, err := sub.Next(ctx)
+		if err != nil {
+			return
+		}
+		cm, ok := chatMessage(msg, private)
+		if !ok {
+			continue
+		}
+		if !private && node.Moderation.Store().View(moderation.RoomScope(room)).Silenced(msg.GetFrom(), time.Now()) {
+			continue
+		}
+		m := thread.Add(cm)
+		if log != nil {
+			if err = node.recordEntry(log, thread, cm, m); err != nil {
+				logger.Warnf("unable to add a message to the transcript of %s: %v", room, err)
+			}
+		}
+		if !private && m != nil && cm.Reaction == "" {
+			if err = node.indexMessage(room, cm, m); err != nil {
+				logger.Warnf("unable to index a message in %s: %v", room, err)
+			}
+		}
+	}
+}
+
+// recordEntry appends a message to the transcript of its room, m being what thread made of it.
+// The text of deleted messages and of their edits is removed from the transcript, whichever of
+// them arrived first.
+func (node *ChatV2Node) recordEntry(log *transcript.Log, thread *Thread, cm *ChatMessage, m *ThreadMessage) error {
+	entry := transcriptEntry(log.Room(), cm)
+	changes := cm.ID
+	if cm.Edits != "" {
+		changes = cm.Edits
+	}
+	if target, ok := thread.Get(changes); ok && target.Deleted && cm.Reaction == "" && cm.Deletes == "" {
+		entry.Text = ""
+	}
+	if err := log.Append(entry); err != nil {
+		return err
+	}
+	if m != nil && m.Deleted && m.ID != "" && (cm.Deletes == m.ID || cm.ID == m.ID) {
+		return log.Redact(m.SenderID, m.ID)
+	}
+	return nil
+}
+
+// indexMessage keeps the search index in step with the message of a public room m is, as thread
+// left it after cm: new messages are added, edits replace the text of the message they change and
+// deletions remove it.
+func (node *ChatV2Node) indexMessage(room string, cm *ChatMessage, m *ThreadMessage) (err error) {
+	switch {
+	case m.ID == "":
+		// from a client that doesn't know about references
+	case cm.Updates() == "" && !m.Deleted:
+	case cm.Edits != "" && m.Text != cm.Message:
+		// an older edit arriving late
+		return
+	case cm.Edits != "" || m.Deleted:
+		_, err = node.Search.Remove(func(d *search.Document) bool {
+			return d.Kind == search.Chat && d.Scope == room && d.Message == m.ID && d.Author == m.SenderID
+		})
+		if err != nil || m.Deleted {
+			return
+		}
+	default:
+		return
+	}
+	d := search.ChatDocument(room, m.SenderID, m.SenderNick, m.Text, m.Timestamp)
+	d.Message = m.ID
+	d.ReplyTo = m.ReplyTo
+	_, err = node.Search.Add(d)
+	return
+}
+
+// transcriptEntry returns the transcript entry of a chat message.
+func transcriptEntry(room string, cm *ChatMessage) *transcript.Entry {
+	return &transcript.Entry{
+		Time:     cm.Timestamp,
+		Room:     room,
+		From:     cm.SenderID,
+		Nick:     cm.SenderNick,
+		Text:     cm.Message,
+		ID:       cm.ID,
+		ReplyTo:  cm.ReplyTo,
+		Reaction: cm.Reaction,
+		Edits:    cm.Edits,
+		Deletes:  cm.Deletes,
+	}
+}
+
+// entryMessage returns the chat message a transcript entry was made of.
+func entryMessage(e *transcript.Entry) *ChatMessage {
+	return &ChatMessage{
+		Message:    e.Text,
+		SenderID:   e.From,
+		SenderNick: e.Nick,
+		Timestamp:  e.Time,
+		ID:         e.ID,
+		ReplyTo:    e.ReplyTo,
+		Reaction:   e.Reaction,
+		Edits:      e.Edits,
+		Deletes:    e.Deletes,
+	}
+}
@@ -0,0 +1,96 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package chatv2
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rightfoot-consulting/p2pbbs/search"
+	"github.com/rightfoot-consulting/p2pbbs/transcript"
+)
+
+func TestRecord(t *testing.T) {
+	dir := t.TempDir()
+	ix, err := search.Open(filepath.Join(dir, search.IndexFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := transcript.OpenStore(filepath.Join(dir, transcript.Dir), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log, err := store.Log("lobby", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := &ChatV2Node{Search: ix}
+	thread := NewThread()
+	at := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	record := func(cm *ChatMessage) {
+		t.Helper()
+		at = at.Add(time.Second)
+		cm.SenderNick = cm.SenderID
+		cm.Timestamp = at
+		m := thread.Add(cm)
+		if err := node.recordEntry(log, thread, cm, m); err != nil {
+			t.Fatal(err)
+		}
+		if m != nil && cm.Reaction == "" {
+			if err := node.indexMessage("lobby", cm, m); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	found := func(text string) (texts []string) {
+		q, _ := search.ParseQuery(text, time.Now())
+		for _, r := range ix.Search(q, 10) {
+			texts = append(texts, r.Document.Message+":"+r.Document.Text)
+		}
+		return
+	}
+
+	record(&ChatMessage{SenderID: "alice", ID: "a1", Message: "the relay is down"})
+	record(&ChatMessage{SenderID: "bob", ID: "b1", Message: "which relay?", ReplyTo: "a1"})
+	record(&ChatMessage{SenderID: "alice", ID: "a2", Message: "the relay in berlin is down", Edits: "a1"})
+	record(&ChatMessage{SenderID: "bob", ID: "b2", Message: "👍", Reaction: "a1"})
+	if got := found("relay"); len(got) != 2 || !strings.Contains(strings.Join(got, "|"), "a1:the relay in berlin") {
+		t.Errorf("found %q after the edit", got)
+	}
+
+	// a deletion drops the text from the index and the transcript, even of edits arriving later
+	record(&ChatMessage{SenderID: "bob", ID: "b3", Message: "my password is hunter2"})
+	record(&ChatMessage{SenderID: "bob", ID: "b4", Message: DeletedText, Deletes: "b3"})
+	record(&ChatMessage{SenderID: "bob", ID: "b5", Message: "my password is hunter3", Edits: "b3"})
+	if got := found("password"); len(got) != 0 {
+		t.Errorf("found %q after the deletion", got)
+	}
+	for _, file := range []string{filepath.Join(dir, search.IndexFile), filepath.Join(dir, transcript.Dir, "lobby", "000001.jsonl")} {
+		if data, err := os.ReadFile(file); err != nil || strings.Contains(string(data), "hunter") {
+			t.Errorf("%s keeps the deleted text: %v", file, err)
+		}
+	}
+
+	// replaying the transcript gives the messages back with their ids and references
+	entries, err := log.Entries()
+	if err != nil || len(entries) != 7 {
+		t.Fatalf("recorded %d entries: %v", len(entries), err)
+	}
+	replayed := NewThread()
+	for _, e := range entries {
+		replayed.Add(entryMessage(e))
+	}
+	if m, ok := replayed.Get("a1"); !ok || m.Text != "the relay in berlin is down" || len(m.Reactions) != 1 {
+		t.Errorf("replayed %+v", m)
+	}
+	if m, ok := replayed.Get("b1"); !ok || m.ReplyTo != "a1" {
+		t.Errorf("replayed the reply as %+v", m)
+	}
+	if m, ok := replayed.Get("b3"); !ok || !m.Deleted {
+		t.Errorf("replayed the deleted message as %+v", m)
+	}
+}
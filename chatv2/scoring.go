@@ -0,0 +1,126 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package chatv2
+
+import (
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// ScoringConfig tunes GossipSub peer scoring.  Every node scores the peers it talks to: peers
+// that forward messages the room validators reject lose score, and peers whose score falls
+// below the thresholds stop receiving gossip from us, then our publishes, and are finally
+// graylisted, every message they send being dropped, until their penalties decay.
+type ScoringConfig struct {
+	// Disabled turns peer scoring off, validators still drop bad messages.
+	Disabled bool `json:"disabled"`
+	// GossipThreshold is the score below which we stop gossiping with a peer.
+	GossipThreshold float64 `json:"gossip_threshold"`
+	// PublishThreshold is the score below which we stop publishing to a peer.
+	PublishThreshold float64 `json:"publish_threshold"`
+	// GraylistThreshold is the score below which we ignore a peer altogether.
+	GraylistThreshold float64 `json:"graylist_threshold"`
+	// InvalidMessageWeight is the penalty for the square of the number of rejected messages
+	// a peer sent on a room, it must be negative.
+	InvalidMessageWeight float64 `json:"invalid_message_weight"`
+	// PenaltyDecay is how many minutes it takes for a penalty to fade away.
+	PenaltyDecay int `json:"penalty_decay"`
+	// IPColocationWeight penalizes the square of the number of peers beyond
+	// IPColocationThreshold sharing an IP address, 0 to allow any number.
+	IPColocationWeight    float64 `json:"ip_colocation_weight"`
+	IPColocationThreshold int     `json:"ip_colocation_threshold"`
+	// MessageBurst is how many messages a peer may publish at once on a room, and
+	// MessageInterval how many milliseconds it waits for another once the burst is spent.
+	// Every user of a BBS node shares its peer id, so busy nodes need a larger allowance.
+	// Messages beyond it are dropped without penalizing whoever relayed them.
+	MessageBurst    int `json:"message_burst"`
+	MessageInterval int `json:"message_interval"`
+}
+
+// DefaultScoring graylists a peer after a handful of rejected messages within the decay window.
+func DefaultScoring() *ScoringConfig {
+	return &ScoringConfig{
+		GossipThreshold:       -500,
+		PublishThreshold:      -1000,
+		GraylistThreshold:     -2500,
+		InvalidMessageWeight:  -100,
+		PenaltyDecay:          60,
+		IPColocationWeight:    -10,
+		IPColocationThreshold: 10,
+		MessageBurst:          MessageBurst,
+		MessageInterval:       int(MessageInterval / time.Millisecond),
+	}
+}
+
+// rateLimit returns the allowance of a sender, the defaults standing in for missing settings.
+func (sc *ScoringConfig) rateLimit() (burst int, interval time.Duration) {
+	burst, interval = sc.MessageBurst, time.Duration(sc.MessageInterval)*time.Millisecond
+	if burst <= 0 {
+		burst = MessageBurst
+	}
+	if interval <= 0 {
+		interval = MessageInterval
+	}
+	return
+}
+
+// options returns the PubSub options enabling peer scoring, GossipSub checks the parameters
+// when the router starts.
+func (sc *ScoringConfig) options() []pubsub.Option {
+	if sc.Disabled {
+		return nil
+	}
+	return []pubsub.Option{pubsub.WithPeerScore(sc.peerScoreParams(), sc.thresholds())}
+}
+
+func (sc *ScoringConfig) decay() float64 {
+	return pubsub.ScoreParameterDecay(time.Duration(sc.PenaltyDecay) * time.Minute)
+}
+
+// peerScoreParams scores every topic, the topics of rooms get their parameters as they are joined.
+func (sc *ScoringConfig) peerScoreParams() *pubsub.PeerScoreParams {
+	return &pubsub.PeerScoreParams{
+		Topics:                      make(map[string]*pubsub.TopicScoreParams),
+		TopicScoreCap:               10,
+		AppSpecificScore:            func(peer.ID) float64 { return 0 },
+		AppSpecificWeight:           1,
+		IPColocationFactorWeight:    sc.IPColocationWeight,
+		IPColocationFactorThreshold: sc.IPColocationThreshold,
+		BehaviourPenaltyWeight:      -10,
+		BehaviourPenaltyThreshold:   6,
+		BehaviourPenaltyDecay:       sc.decay(),
+		DecayInterval:               time.Second,
+		DecayToZero:                 0.01,
+		RetainScore:                 time.Duration(sc.PenaltyDecay) * time.Minute,
+	}
+}
+
+// topicScoreParams scores peers on a room: a little credit for time in the mesh and for
+// delivering messages first, a large penalty for rejected messages.  Rooms are often quiet, so
+// peers aren't penalized for delivering few messages.
+func (sc *ScoringConfig) topicScoreParams() *pubsub.TopicScoreParams {
+	return &pubsub.TopicScoreParams{
+		TopicWeight:                    1,
+		TimeInMeshWeight:               0.01,
+		TimeInMeshQuantum:              time.Second,
+		TimeInMeshCap:                  300,
+		FirstMessageDeliveriesWeight:   0.5,
+		FirstMessageDeliveriesDecay:    sc.decay(),
+		FirstMessageDeliveriesCap:      10,
+		InvalidMessageDeliveriesWeight: sc.InvalidMessageWeight,
+		InvalidMessageDeliveriesDecay:  sc.decay(),
+	}
+}
+
+func (sc *ScoringConfig) thresholds() *pubsub.PeerScoreThresholds {
+	return &pubsub.PeerScoreThresholds{
+		GossipThreshold:             sc.GossipThreshold,
+		PublishThreshold:            sc.PublishThreshold,
+		GraylistThreshold:           sc.GraylistThreshold,
+		AcceptPXThreshold:           10,
+		OpportunisticGraftThreshold: 3,
+	}
+}
@@ -0,0 +1,316 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package chatv2
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// MessageIDBytes is the size of the random ID of a chat message, which is written as hex.
+	MessageIDBytes = 8
+	// ShortIDLength is how much of its ID is shown next to a message.
+	ShortIDLength = 6
+	// MaxMessageIDLength bounds the IDs messages are named and referred to by.
+	MaxMessageIDLength = 64
+	// MaxReactionLength bounds the text of a reaction.
+	MaxReactionLength = 32
+	// ThreadMessages is how many messages of a room a thread remembers.
+	ThreadMessages = 1000
+	// ThreadPending is how many edits, deletions and reactions a thread keeps while the message
+	// they refer to hasn't arrived yet.
+	ThreadPending = 256
+	// DeletedText is the text of a deletion, shown by clients that don't know about deletions.
+	DeletedText = "(deleted a message)"
+)
+
+// NewMessageID returns a random ID for a chat message.
+func NewMessageID() string {
+	id := make([]byte, MessageIDBytes)
+	if _, err := rand.Read(id); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(id)
+}
+
+// shortMessageID returns the start of a message ID that is shown next to the message.
+func shortMessageID(id string) string {
+	return id[:min(len(id), ShortIDLength)]
+}
+
+// Updates returns the ID of the message an edit, deletion or reaction changes, empty for
+// messages that are shown on their own.
+func (cm *ChatMessage) Updates() string {
+	switch {
+	case cm.Reaction != "":
+		return cm.Reaction
+	case cm.Edits != "":
+		return cm.Edits
+	default:
+		return cm.Deletes
+	}
+}
+
+// validReferences reports whether the ID and the references of a message are well formed.  A
+// message refers to at most one other, and only messages with an ID may refer to others, so
+// they can be deleted in turn.
+func validReferences(cm *ChatMessage) bool {
+	refs := 0
+	for _, ref := range []string{cm.ReplyTo, cm.Reaction, cm.Edits, cm.Deletes} {
+		if ref == "" {
+			continue
+		}
+		if !validMessageID(ref) {
+			return false
+		}
+		refs++
+	}
+	if cm.ID == "" {
+		return refs == 0
+	}
+	return refs <= 1 && validMessageID(cm.ID)
+}
+
+// validMessageID reports whether id is lower case hex no longer than MaxMessageIDLength.
+func validMessageID(id string) bool {
+	if id == "" || len(id) > MaxMessageIDLength {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// later reports whether a was written after b, comparing IDs when they were written at the
+// same time so every peer picks the same one.
+func later(a *ChatMessage, b *ChatMessage) bool {
+	if !a.Timestamp.Equal(b.Timestamp) {
+		return a.Timestamp.After(b.Timestamp)
+	}
+	return a.ID > b.ID
+}
+
+// ThreadMessage is a message as its edits, deletion and reactions left it when it was looked up.
+type ThreadMessage struct {
+	// ChatMessage is the message as it was first sent.
+	*ChatMessage
+	// Text is the text of the newest edit, the text of the message when it wasn't edited.
+	Text      string
+	Edited    bool
+	Deleted   bool
+	Reactions []Reaction
+}
+
+// Reaction counts the peers who reacted to a message the same way.
+type Reaction struct {
+	Text  string
+	Count int
+}
+
+// threadEntry is what a thread knows of a message.
+type threadEntry struct {
+	cm        *ChatMessage
+	text      string
+	deleted   bool
+	edit      *ChatMessage
+	reactions map[string]*ChatMessage
+}
+
+// snapshot returns the message as it stands, the caller must hold the lock of the thread.
+func (e *threadEntry) snapshot() *ThreadMessage {
+	m := &ThreadMessage{ChatMessage: e.cm, Text: e.text, Edited: e.edit != nil, Deleted: e.deleted}
+	peers := make(map[string]map[string]bool)
+	for _, r := range e.reactions {
+		if peers[r.Message] == nil {
+			peers[r.Message] = make(map[string]bool)
+		}
+		peers[r.Message][r.SenderID] = true
+	}
+	for text, who := range peers {
+		m.Reactions = append(m.Reactions, Reaction{Text: text, Count: len(who)})
+	}
+	sort.Slice(m.Reactions, func(i, j int) bool {
+		if m.Reactions[i].Count != m.Reactions[j].Count {
+			return m.Reactions[i].Count > m.Reactions[j].Count
+		}
+		return m.Reactions[i].Text < m.Reactions[j].Text
+	})
+	return m
+}
+
+// Thread resolves the references between the messages of a room.  Only the sender of a message
+// can edit or delete it, which the pubsub signature proves, and of several edits the one
+// written last wins wherever they arrive first.  Deletions are final.  Changes that arrive
+// before the message they change are held until it does.  It is safe for concurrent use.
+type Thread struct {
+	mu      sync.Mutex
+	entries map[string]*threadEntry
+	order   []string
+	pending []*ChatMessage
+}
+
+// NewThread returns an empty thread.
+func NewThread() *Thread {
+	return &Thread{entries: make(map[string]*threadEntry)}
+}
+
+// Add adds a message to the thread, returning the message it changed: itself when it is shown
+// on its own, nil when what it changes isn't known yet or may not be changed by its sender.
+func (t *Thread) Add(cm *ChatMessage) *ThreadMessage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if ref := cm.Updates(); ref != "" {
+		target, ok := t.entries[ref]
+		if !ok {
+			t.pending = append(t.pending, cm)
+			if len(t.pending) > ThreadPending {
+				t.pending = t.pending[1:]
+			}
+			return nil
+		}
+		if changed := t.update(target, cm); changed != nil {
+			return changed.snapshot()
+		}
+		return nil
+	}
+	e := &threadEntry{cm: cm, text: cm.Message}
+	// a message reusing the ID of another is still shown, but can't be referred to
+	if _, ok := t.entries[cm.ID]; cm.ID != "" && !ok {
+		t.remember(e)
+	}
+	return e.snapshot()
+}
+
+// Get returns the message with an ID.
+func (t *Thread) Get(id string) (*ThreadMessage, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e, ok := t.entries[id]
+	if !ok {
+		return nil, false
+	}
+	return e.snapshot(), true
+}
+
+// Find returns the message whose ID starts with prefix, which may start with #.  Reactions
+// aren't found, they are taken back with Reacted.
+func (t *Thread) Find(prefix string) (*ThreadMessage, error) {
+	prefix = strings.TrimPrefix(prefix, "#")
+	if prefix == "" {
+		return nil, fmt.Errorf("no message given")
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var found *threadEntry
+	for id, e := range t.entries {
+		if !strings.HasPrefix(id, prefix) || e.cm.Reaction != "" {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("#%s names more than one message, give more of its id", prefix)
+		}
+		found = e
+	}
+	if found == nil {
+		return nil, fmt.Errorf("no message #%s", prefix)
+	}
+	return found.snapshot(), nil
+}
+
+// Reacted returns the ID of the reaction of a sender to the message with id, empty when they
+// didn't react with text.
+func (t *Thread) Reacted(id string, from string, text string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if e, ok := t.entries[id]; ok {
+		for reaction, r := range e.reactions {
+			if r.SenderID == from && r.Message == text {
+				return reaction
+			}
+		}
+	}
+	return ""
+}
+
+// update applies a change to the message it refers to, returning the message it changed or nil
+// when it may not.  Reactions can be taken back, so they are remembered too.  The caller must
+// hold the lock.
+func (t *Thread) update(target *threadEntry, cm *ChatMessage) *threadEntry {
+	changed := t.apply(target, cm)
+	if _, ok := t.entries[cm.ID]; cm.Reaction != "" && changed != nil && !ok {
+		t.remember(&threadEntry{cm: cm, text: cm.Message})
+	}
+	return changed
+}
+
+// apply changes target as cm says.  The caller must hold the lock.
+func (t *Thread) apply(target *threadEntry, cm *ChatMessage) *threadEntry {
+	switch {
+	case cm.Reaction != "":
+		if target.cm.Reaction != "" || target.deleted {
+			return nil
+		}
+		if target.reactions == nil {
+			target.reactions = make(map[string]*ChatMessage)
+		}
+		target.reactions[cm.ID] = cm
+		return target
+	case cm.SenderID != target.cm.SenderID:
+		return nil
+	case cm.Deletes != "" && target.cm.Reaction != "":
+		// taking a reaction back
+		reacted, ok := t.entries[target.cm.Reaction]
+		if !ok {
+			return nil
+		}
+		delete(reacted.reactions, target.cm.ID)
+		return reacted
+	case cm.Deletes != "":
+		target.deleted = true
+		target.text = ""
+		target.reactions = nil
+		return target
+	case target.deleted || target.cm.Reaction != "":
+		return nil
+	}
+	if target.edit == nil || later(cm, target.edit) {
+		target.edit = cm
+		target.text = cm.Message
+	}
+	return target
+}
+
+// remember adds a message to those that can be referred to, forgetting the oldest once there
+// are ThreadMessages, then applies the changes to it that arrived before it.  The caller must
+// hold the lock.
+func (t *Thread) remember(e *threadEntry) {
+	id := e.cm.ID
+	t.entries[id] = e
+	t.order = append(t.order, id)
+	if len(t.order) > ThreadMessages {
+		delete(t.entries, t.order[0])
+		t.order = t.order[1:]
+	}
+	var ready []*ChatMessage
+	waiting := t.pending[:0]
+	for _, p := range t.pending {
+		if p.Updates() == id {
+			ready = append(ready, p)
+		} else {
+			waiting = append(waiting, p)
+		}
+	}
+	t.pending = waiting
+	for _, p := range ready {
+		t.update(e, p)
+	}
+}
@@ -0,0 +1,96 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package chatv2
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestThread(t *testing.T) {
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	message := func(from string, id string, seconds int, text string) *ChatMessage {
+		return &ChatMessage{Message: text, SenderID: from, SenderNick: from, Timestamp: start.Add(time.Duration(seconds) * time.Second), ID: id}
+	}
+	th := NewThread()
+	original := th.Add(message("alice", "a1", 0, "helo"))
+	if original == nil || original.Text != "helo" || original.Edited {
+		t.Fatalf("added %+v", original)
+	}
+	reply := message("bob", "b1", 1, "hi")
+	reply.ReplyTo = "a1"
+	th.Add(reply)
+
+	// the newest edit by the author wins in whatever order they arrive, others' edits don't count
+	second, first, forged := message("alice", "a3", 5, "hello!"), message("alice", "a2", 3, "hello"), message("bob", "b2", 9, "bye")
+	for _, edit := range []*ChatMessage{second, first, forged} {
+		edit.Edits = "a1"
+	}
+	if th.Add(second) == nil || th.Add(first) == nil {
+		t.Error("edits didn't change the message")
+	}
+	if th.Add(forged) != nil {
+		t.Error("somebody else edited the message")
+	}
+	if m, _ := th.Get("a1"); m.Text != "hello!" || !m.Edited {
+		t.Errorf("edited to %q", m.Text)
+	}
+
+	// reactions count peers, and can be taken back by whoever reacted
+	for i, who := range []string{"bob", "carol", "dave", "bob"} {
+		r := message(who, fmt.Sprintf("c%d", i), 10+i, "👍")
+		if who == "dave" {
+			r.Message = "🎉"
+		}
+		r.Reaction = "a1"
+		th.Add(r)
+	}
+	if m, _ := th.Get("a1"); !slices.Equal(m.Reactions, []Reaction{{"👍", 2}, {"🎉", 1}}) {
+		t.Errorf("reactions %v", m.Reactions)
+	}
+	takeBack := message("dave", "d1", 20, DeletedText)
+	takeBack.Deletes = th.Reacted("a1", "dave", "🎉")
+	if m := th.Add(takeBack); m == nil || m.ID != "a1" || len(m.Reactions) != 1 {
+		t.Errorf("took a reaction back to %v", m)
+	}
+
+	// changes that arrive first wait for their message
+	early := message("carol", "e2", 31, "👀")
+	early.Reaction = "e1"
+	if th.Add(early) != nil {
+		t.Error("reacted to a message that hasn't arrived")
+	}
+	late := th.Add(message("erin", "e1", 30, "late"))
+	if len(late.Reactions) != 1 || late.Reactions[0].Text != "👀" {
+		t.Errorf("the early reaction gave %v", late.Reactions)
+	}
+
+	// deletions are final, and a reused id doesn't take over a message
+	remove := message("alice", "a4", 40, DeletedText)
+	remove.Deletes = "a1"
+	th.Add(remove)
+	th.Add(first)
+	if m, _ := th.Get("a1"); !m.Deleted || m.Text != "" || m.Reactions != nil {
+		t.Errorf("deleted message reads %q", m.Text)
+	}
+	if th.Add(message("mallory", "b1", 50, "mine")) == nil {
+		t.Error("a message reusing an id wasn't shown")
+	}
+	if m, _ := th.Get("b1"); m.SenderID != "bob" {
+		t.Errorf("b1 belongs to %s", m.SenderID)
+	}
+
+	if m, err := th.Find("#b"); err != nil || m.ID != "b1" {
+		t.Errorf("found %v: %v", m, err)
+	}
+	th.Add(message("alice", "a5", 60, "again"))
+	if _, err := th.Find("a"); err == nil {
+		t.Error("an ambiguous prefix found a message")
+	}
+	if _, err := th.Find("c1"); err == nil {
+		t.Error("found a reaction")
+	}
+}
@@ -0,0 +1,145 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package chatv2
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+	// MaxMessageBytes bounds the encoded size of a chat message.
+	MaxMessageBytes = 8 << 10
+	// MaxMessageLength bounds the text of a chat message.
+	MaxMessageLength = 4 << 10
+	// MaxNickLength bounds the nick a sender declares.
+	MaxNickLength = 64
+	// MaxClockSkew is how far the timestamp of a message may be from our clock.
+	MaxClockSkew = 2 * time.Minute
+	// MessageBurst is how many messages a sender may publish at once, unless the scoring
+	// configuration says otherwise.
+	MessageBurst = 10
+	// MessageInterval is how often a sender earns another message once its burst is spent,
+	// unless the scoring configuration says otherwise.
+	MessageInterval = 500 * time.Millisecond
+)
+
+// messageValidator checks every message on the topic of a room before it is delivered or
+// forwarded.  Malformed and forged messages are rejected, which counts against the peer that
+// sent them when peer scoring is enabled.  Messages with a timestamp outside the skew window are
+// ignored, as the clock of the sender may simply be wrong, and so are messages from senders over
+// their allowance: relays can't tell a flood from a busy BBS node whose users share its peer id,
+// and whether a relayed message fits the allowance depends on when it arrives.
+type messageValidator struct {
+	burst    int
+	interval time.Duration
+
+	mu      sync.Mutex
+	senders map[peer.ID]*allowance
+}
+
+// allowance is a token bucket holding how many messages a sender may still publish.
+type allowance struct {
+	tokens  float64
+	updated time.Time
+}
+
+// newMessageValidator returns a validator allowing senders the burst and interval of sc.
+func newMessageValidator(sc *ScoringConfig) *messageValidator {
+	burst, interval := sc.rateLimit()
+	return &messageValidator{burst: burst, interval: interval, senders: make(map[peer.ID]*allowance)}
+}
+
+// Validate is the pubsub.ValidatorEx of a room topic.
+func (v *messageValidator) Validate(_ context.Context, _ peer.ID, msg *pubsub.Message) pubsub.ValidationResult {
+	_, result := v.validate(msg, msg.Data)
+	return result
+}
+
+// validate checks data, the chat message carried by msg, returning it when it is accepted.
+func (v *messageValidator) validate(msg *pubsub.Message, data []byte) (*ChatMessage, pubsub.ValidationResult) {
+	now := time.Now()
+	cm, err := parseChatMessage(msg, data, now)
+	if err == errClockSkew {
+		logger.Debugf("ignoring message from %s: %v", msg.GetFrom(), err)
+		return nil, pubsub.ValidationIgnore
+	}
+	if err != nil {
+		logger.Debugf("rejecting message from %s: %v", msg.GetFrom(), err)
+		return nil, pubsub.ValidationReject
+	}
+	if !v.allow(msg.GetFrom(), now) {
+		logger.Debugf("ignoring message from %s (%s): sending too fast", msg.GetFrom(), cm.SenderNick)
+		return nil, pubsub.ValidationIgnore
+	}
+	return cm, pubsub.ValidationAccept
+}
+
+// errClockSkew is returned for messages whose timestamp is too far from our clock.
+var errClockSkew = fmt.Errorf("timestamp more than %v from our clock", MaxClockSkew)
+
+// parseChatMessage decodes the chat message msg carries in data, which is its payload unless the
+// room encrypts it, and checks its size, its schema and references, that it is signed by the
+// sender it names and that it was sent within MaxClockSkew of now.  The router has already verified the signature,
+// under the strict signing policy it only lets signed messages through.
+func parseChatMessage(msg *pubsub.Message, data []byte, now time.Time) (cm *ChatMessage, err error) {
+	if len(data) > MaxMessageBytes {
+		return nil, fmt.Errorf("message of %d bytes", len(data))
+	}
+	if msg.Signature == nil {
+		return nil, fmt.Errorf("unsigned message")
+	}
+	cm = new(ChatMessage)
+	if err = json.Unmarshal(data, cm); err != nil {
+		return nil, err
+	}
+	switch {
+	case cm.SenderID != msg.GetFrom().String():
+		return nil, fmt.Errorf("message signed by %s claims to be from %s", msg.GetFrom(), cm.SenderID)
+	case cm.Message == "" || len(cm.Message) > MaxMessageLength || !utf8.ValidString(cm.Message):
+		return nil, fmt.Errorf("invalid message text")
+	case cm.SenderNick == "" || len(cm.SenderNick) > MaxNickLength || !utf8.ValidString(cm.SenderNick):
+		return nil, fmt.Errorf("invalid nick %q", cm.SenderNick)
+	case !validReferences(cm):
+		return nil, fmt.Errorf("invalid message id or reference")
+	case cm.Reaction != "" && len(cm.Message) > MaxReactionLength:
+		return nil, fmt.Errorf("reaction of %d bytes", len(cm.Message))
+	case cm.Timestamp.Before(now.Add(-MaxClockSkew)) || cm.Timestamp.After(now.Add(MaxClockSkew)):
+		return nil, errClockSkew
+	}
+	return
+}
+
+// allow spends a token of the sender's allowance, reporting whether it had one left.
+func (v *messageValidator) allow(id peer.ID, now time.Time) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	a, ok := v.senders[id]
+	if !ok {
+		// forget senders whose allowance has refilled so the map doesn't grow forever
+		if len(v.senders) > 1024 {
+			for other, b := range v.senders {
+				if now.Sub(b.updated) > time.Duration(v.burst)*v.interval {
+					delete(v.senders, other)
+				}
+			}
+		}
+		a = &allowance{tokens: float64(v.burst), updated: now}
+		v.senders[id] = a
+	}
+	a.tokens = min(a.tokens+float64(now.Sub(a.updated))/float64(v.interval), float64(v.burst))
+	a.updated = now
+	if a.tokens < 1 {
+		return false
+	}
+	a.tokens--
+	return true
+}
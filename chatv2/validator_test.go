@@ -0,0 +1,122 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package chatv2
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	pb "github.com/libp2p/go-libp2p-pubsub/pb"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func pubsubMessage(t *testing.T, from peer.ID, data []byte) *pubsub.Message {
+	t.Helper()
+	return &pubsub.Message{Message: &pb.Message{Data: data, From: []byte(from), Signature: []byte("signed")}}
+}
+
+func encode(t *testing.T, cm *ChatMessage) []byte {
+	t.Helper()
+	data, err := json.Marshal(cm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestValidator(t *testing.T) {
+	alice, mallory := randomPeer(t), randomPeer(t)
+	now := time.Now()
+	valid := func() *ChatMessage {
+		return &ChatMessage{Message: "hello", SenderID: alice.String(), SenderNick: "alice", Timestamp: now}
+	}
+	v := newMessageValidator(DefaultScoring())
+	if r := v.Validate(context.Background(), alice, pubsubMessage(t, alice, encode(t, valid()))); r != pubsub.ValidationAccept {
+		t.Errorf("a valid message got %v", r)
+	}
+
+	for name, test := range map[string]struct {
+		data   []byte
+		from   peer.ID
+		result pubsub.ValidationResult
+	}{
+		"not json":   {[]byte("hello"), alice, pubsub.ValidationReject},
+		"too large":  {[]byte(`"` + strings.Repeat("a", MaxMessageBytes) + `"`), alice, pubsub.ValidationReject},
+		"forged":     {encode(t, valid()), mallory, pubsub.ValidationReject},
+		"empty":      {encode(t, &ChatMessage{SenderID: alice.String(), SenderNick: "alice", Timestamp: now}), alice, pubsub.ValidationReject},
+		"no nick":    {encode(t, &ChatMessage{Message: "hi", SenderID: alice.String(), Timestamp: now}), alice, pubsub.ValidationReject},
+		"stale":      {encode(t, &ChatMessage{Message: "hi", SenderID: alice.String(), SenderNick: "alice", Timestamp: now.Add(-time.Hour)}), alice, pubsub.ValidationIgnore},
+		"from later": {encode(t, &ChatMessage{Message: "hi", SenderID: alice.String(), SenderNick: "alice", Timestamp: now.Add(time.Hour)}), alice, pubsub.ValidationIgnore},
+		"bad id":     {encode(t, &ChatMessage{Message: "hi", SenderID: alice.String(), SenderNick: "alice", Timestamp: now, ID: "Not-Hex"}), alice, pubsub.ValidationReject},
+		"no id":      {encode(t, &ChatMessage{Message: "hi", SenderID: alice.String(), SenderNick: "alice", Timestamp: now, ReplyTo: "1a"}), alice, pubsub.ValidationReject},
+		"two refs":   {encode(t, &ChatMessage{Message: "hi", SenderID: alice.String(), SenderNick: "alice", Timestamp: now, ID: "2b", ReplyTo: "1a", Edits: "1a"}), alice, pubsub.ValidationReject},
+		"long react": {encode(t, &ChatMessage{Message: strings.Repeat("+", MaxReactionLength+1), SenderID: alice.String(), SenderNick: "alice", Timestamp: now, ID: "2b", Reaction: "1a"}), alice, pubsub.ValidationReject},
+	} {
+		if r := v.Validate(context.Background(), test.from, pubsubMessage(t, test.from, test.data)); r != test.result {
+			t.Errorf("%s: got %v, want %v", name, r, test.result)
+		}
+	}
+
+	unsigned := pubsubMessage(t, alice, encode(t, valid()))
+	unsigned.Signature = nil
+	if r := v.Validate(context.Background(), alice, unsigned); r != pubsub.ValidationReject {
+		t.Errorf("an unsigned message got %v", r)
+	}
+
+	// a burst is allowed, then one message per interval
+	flood := newMessageValidator(&ScoringConfig{})
+	for i := 0; i < MessageBurst; i++ {
+		if !flood.allow(alice, now) {
+			t.Fatalf("message %d of the burst refused", i)
+		}
+	}
+	if flood.allow(alice, now) {
+		t.Error("allowed more than a burst")
+	}
+	if !flood.allow(mallory, now) {
+		t.Error("one sender spent the allowance of another")
+	}
+	if !flood.allow(alice, now.Add(MessageInterval)) || flood.allow(alice, now.Add(MessageInterval)) {
+		t.Error("the allowance doesn't refill one message per interval")
+	}
+
+	// a flood is dropped without penalizing the relay, as the allowance of the configuration
+	busy := newMessageValidator(&ScoringConfig{MessageBurst: 2, MessageInterval: 1000})
+	for i, want := range []pubsub.ValidationResult{pubsub.ValidationAccept, pubsub.ValidationAccept, pubsub.ValidationIgnore} {
+		if r := busy.Validate(context.Background(), alice, pubsubMessage(t, alice, encode(t, valid()))); r != want {
+			t.Errorf("message %d of a flood got %v, want %v", i, r, want)
+		}
+	}
+	if !busy.allow(alice, time.Now().Add(time.Second)) || busy.allow(alice, time.Now().Add(time.Second)) {
+		t.Error("the configured interval doesn't refill one message")
+	}
+}
+
+func TestDefaultScoring(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	scoring := DefaultScoring()
+	ps, err := pubsub.NewGossipSub(ctx, h, scoring.options()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	topic, err := joinRoomTopic(ps, "lobby", scoring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer topic.Close()
+	if _, err = joinRoomTopic(ps, "lobby", scoring); err == nil {
+		t.Error("joined a room twice")
+	}
+}
@@ -0,0 +1,183 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rightfoot-consulting/p2pbbs/accounts"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// accountCmd groups the commands that manage the local accounts of a node
+var accountCmd = &cobra.Command{
+	Use:   "account",
+	Short: "Manage the local users served by serve-ssh and serve-telnet",
+	Long: `Local accounts let several people use the BBS through one node.  Every account has its own
+p2pbbs identity, so the posts and messages of each user are signed with their own key.`,
+}
+
+// accountAddCmd represents the account add command
+var accountAddCmd = &cobra.Command{
+	Use:   "add <name>",
+	Short: "Create a local account",
+	Long: `Creates a local account with a new identity, or with an existing one from --identity. For example:
+
+			account add alice --authorized-keys ~/.ssh/id_ed25519.pub
+			Creates alice and lets the holder of that SSH key log in as alice
+		.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println("account add called")
+		identity, err := cmd.Flags().GetString("identity")
+		if err != nil {
+			panic(err)
+		}
+		store := loadAccounts(cmd)
+		account, err := store.Create(args[0], identity)
+		if err != nil {
+			panic(err)
+		}
+		authorizeKeys(cmd, store, account.Name)
+		printAccount(account)
+	},
+}
+
+// accountAuthorizeCmd represents the account authorize command
+var accountAuthorizeCmd = &cobra.Command{
+	Use:   "authorize <name>",
+	Short: "Let more SSH keys log in to an account",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println("account authorize called")
+		store := loadAccounts(cmd)
+		authorizeKeys(cmd, store, args[0])
+		account, _ := store.Get(args[0])
+		printAccount(account)
+	},
+}
+
+// accountPasswdCmd represents the account passwd command
+var accountPasswdCmd = &cobra.Command{
+	Use:   "passwd <name>",
+	Short: "Set the password used to log in over telnet",
+	Long: `Sets the password of an account, read from the terminal or from the first line of stdin.
+Telnet sends passwords in the clear, only use it on networks you trust.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println("account passwd called")
+		store := loadAccounts(cmd)
+		password := readPassword("Password: ")
+		if term.IsTerminal(int(os.Stdin.Fd())) && readPassword("Again: ") != password {
+			panic(fmt.Errorf("the passwords don't match"))
+		}
+		if err := store.SetPassword(args[0], password); err != nil {
+			panic(err)
+		}
+		fmt.Printf("password of %s changed\n", args[0])
+	},
+}
+
+// accountListCmd represents the account list command
+var accountListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the local accounts",
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println("account list called")
+		for _, account := range loadAccounts(cmd).All() {
+			printAccount(account)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(accountCmd)
+	accountCmd.AddCommand(accountAddCmd)
+	accountCmd.AddCommand(accountAuthorizeCmd)
+	accountCmd.AddCommand(accountPasswdCmd)
+	accountCmd.AddCommand(accountListCmd)
+	for _, cmd := range []*cobra.Command{accountAddCmd, accountAuthorizeCmd, accountPasswdCmd, accountListCmd} {
+		addChatV2Flags(cmd)
+	}
+	for _, cmd := range []*cobra.Command{accountAddCmd, accountAuthorizeCmd} {
+		cmd.Flags().StringArrayP("authorized-keys", "a", []string{}, "A file in the OpenSSH authorized_keys format, or a single public key line")
+	}
+	accountAddCmd.Flags().StringP("identity", "i", "", "Use an existing key file as the identity of the account instead of generating one")
+}
+
+func loadAccounts(cmd *cobra.Command) *accounts.Store {
+	config, err := loadChatV2Config(cmd)
+	if err != nil {
+		panic(err)
+	}
+	dataDir, err := config.DataDirectory()
+	if err != nil {
+		panic(err)
+	}
+	store, err := accounts.LoadStore(filepath.Join(dataDir, accounts.StoreFile))
+	if err != nil {
+		panic(err)
+	}
+	return store
+}
+
+// authorizeKeys adds every key named by --authorized-keys to an account.
+func authorizeKeys(cmd *cobra.Command, store *accounts.Store, name string) {
+	params, err := cmd.Flags().GetStringArray("authorized-keys")
+	if err != nil {
+		panic(err)
+	}
+	for _, param := range params {
+		contents := []byte(param)
+		if fileContents, err := os.ReadFile(param); err == nil {
+			contents = fileContents
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(contents))
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			if err = store.AuthorizeKey(name, line); err != nil {
+				panic(err)
+			}
+		}
+	}
+}
+
+// readPassword prompts for a password without echo on a terminal, or reads a line from stdin.
+func readPassword(prompt string) string {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			panic(err)
+		}
+		return strings.TrimRight(line, "\r\n")
+	}
+	fmt.Print(prompt)
+	password, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Println()
+	if err != nil {
+		panic(err)
+	}
+	return string(password)
+}
+
+func printAccount(account *accounts.Account) {
+	_, id, err := account.Identity()
+	if err != nil {
+		panic(err)
+	}
+	login := "no password"
+	if account.PasswordHash != "" {
+		login = "password set"
+	}
+	fmt.Printf("%s %s (%d ssh keys, %s)\n", account.Name, id, len(account.AuthorizedKeys), login)
+}
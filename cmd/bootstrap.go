@@ -0,0 +1,238 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package cmd
+
+import (
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/rightfoot-consulting/p2pbbs/bbscrypto"
+	"github.com/rightfoot-consulting/p2pbbs/chat"
+	"github.com/spf13/cobra"
+)
+
+// bootstrapCmd groups the commands used to manage a cluster of bootstrap nodes
+var bootstrapCmd = &cobra.Command{
+	Use:   "bootstrap",
+	Short: "Manage the bootstrap nodes of a p2pbbs cluster",
+	Long: `Bootstrap nodes are long lived dht nodes with static identities that clients
+use to find the rest of the network.  Use the sub commands to provision them.`,
+}
+
+// bootstrapInitCmd represents the bootstrap init command
+var bootstrapInitCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Generate keys and configuration for a cluster of bootstrap nodes",
+	Long: `Generates a private key and a dhtnode configuration for each bootstrap node, then
+writes a client chatconfig.json whose bootstrap peers point at the generated nodes. For example:
+
+			bootstrap init --count 5 --base-port 4001 --ip 127.0.0.1
+			Writes bootstrap1.key..bootstrap5.key, dhtnode1.json..dhtnode5.json and chatconfig.json
+			to the current directory, the nodes listen on ports 4001 through 4005.
+
+			Start each node with: dhtnode --config dhtnode1.json
+
+			bootstrap init --private-swarm
+			Also writes a new swarm key to the configurations, nodes without it can't connect
+		.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println("bootstrap init called")
+		provisioner, err := newClusterProvisioner(cmd)
+		if err != nil {
+			panic(err)
+		}
+		err = provisioner.provision()
+		if err != nil {
+			panic(err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(bootstrapCmd)
+	bootstrapCmd.AddCommand(bootstrapInitCmd)
+	bootstrapInitCmd.Flags().Int32P("count", "n", 5, "Number of bootstrap nodes to provision")
+	bootstrapInitCmd.Flags().Int32P("base-port", "p", 4001, "Listen port of the first node, each following node uses the next port")
+	bootstrapInitCmd.Flags().StringP("ip", "i", "127.0.0.1", "IP address the bootstrap nodes listen on and clients dial")
+	bootstrapInitCmd.Flags().StringP("out-dir", "o", ".", "Directory the keys and configuration files are written to")
+	bootstrapInitCmd.Flags().Int32("client-port", 6666, "Listen port written to the client chatconfig.json")
+	bootstrapInitCmd.Flags().StringP("group", "g", "rendezvous", "Rendezvous string written to the client chatconfig.json")
+	bootstrapInitCmd.Flags().String("protocol-id", "/chat/1.1.0", "Protocol id written to the generated configurations")
+	bootstrapInitCmd.Flags().BoolP("force", "f", false, "Overwrite existing key and configuration files")
+	bootstrapInitCmd.Flags().Bool("private-swarm", false, "Generate a swarm key so only nodes holding it can connect, it is written to every configuration")
+}
+
+type clusterProvisioner struct {
+	count      int
+	basePort   int
+	ip         string
+	outDir     string
+	clientPort int
+	group      string
+	protocolID string
+	force      bool
+	swarmKey   string
+}
+
+func newClusterProvisioner(cmd *cobra.Command) (provisioner *clusterProvisioner, err error) {
+	count, err := cmd.Flags().GetInt32("count")
+	if err != nil {
+		return
+	}
+	basePort, err := cmd.Flags().GetInt32("base-port")
+	if err != nil {
+		return
+	}
+	ip, err := cmd.Flags().GetString("ip")
+	if err != nil {
+		return
+	}
+	outDir, err := cmd.Flags().GetString("out-dir")
+	if err != nil {
+		return
+	}
+	clientPort, err := cmd.Flags().GetInt32("client-port")
+	if err != nil {
+		return
+	}
+	group, err := cmd.Flags().GetString("group")
+	if err != nil {
+		return
+	}
+	protocolID, err := cmd.Flags().GetString("protocol-id")
+	if err != nil {
+		return
+	}
+	force, err := cmd.Flags().GetBool("force")
+	if err != nil {
+		return
+	}
+	privateSwarm, err := cmd.Flags().GetBool("private-swarm")
+	if err != nil {
+		return
+	}
+	var swarmKey string
+	if privateSwarm {
+		swarmKey, err = chat.NewSwarmKey()
+		if err != nil {
+			return
+		}
+	}
+	if count < 1 {
+		err = fmt.Errorf("invalid node count %d", count)
+		return
+	}
+	if basePort < 1 || int(basePort)+int(count)-1 > 65535 {
+		err = fmt.Errorf("invalid base port %d for %d nodes", basePort, count)
+		return
+	}
+	provisioner = &clusterProvisioner{
+		count:      int(count),
+		basePort:   int(basePort),
+		ip:         ip,
+		outDir:     outDir,
+		clientPort: int(clientPort),
+		group:      group,
+		protocolID: protocolID,
+		force:      force,
+		swarmKey:   swarmKey,
+	}
+	return
+}
+
+// provision generates a key per node, then writes the node and client configurations
+// so that every bootstrap address carries the peer id of the key that will answer on it.
+// Nothing is written when a file it would replace exists, unless forced.
+func (cp *clusterProvisioner) provision() (err error) {
+	err = os.MkdirAll(cp.outDir, 0755)
+	if err != nil {
+		return
+	}
+	keyFiles := make([]string, cp.count)
+	configFiles := make([]string, cp.count)
+	for i := 0; i < cp.count; i++ {
+		keyFiles[i] = filepath.Join(cp.outDir, fmt.Sprintf("bootstrap%d.key", i+1))
+		configFiles[i] = filepath.Join(cp.outDir, fmt.Sprintf("dhtnode%d.json", i+1))
+	}
+	clientFile := filepath.Join(cp.outDir, "chatconfig.json")
+	files := append(append([]string{clientFile}, keyFiles...), configFiles...)
+	for _, file := range files {
+		if err = cp.checkOverwrite(file); err != nil {
+			return
+		}
+	}
+
+	addresses := make([]string, cp.count)
+	for i := 0; i < cp.count; i++ {
+		var id peer.ID
+		id, err = cp.generateKey(keyFiles[i])
+		if err != nil {
+			return
+		}
+		addresses[i] = fmt.Sprintf("/ip4/%s/tcp/%d/p2p/%s", cp.ip, cp.basePort+i, id.String())
+		fmt.Printf("Node %d: %s\n", i+1, addresses[i])
+	}
+
+	for i := 0; i < cp.count; i++ {
+		others := make([]string, 0, cp.count-1)
+		others = append(others, addresses[:i]...)
+		others = append(others, addresses[i+1:]...)
+		nodeConfig := &chat.Configuration{
+			Port:             cp.basePort + i,
+			RendezvousString: cp.group,
+			BootstrapPeers:   others,
+			ListenIps:        []string{cp.ip},
+			ProtocolID:       cp.protocolID,
+			KeyFile:          keyFiles[i],
+			SwarmKey:         cp.swarmKey,
+		}
+		err = nodeConfig.SaveChatConfig(configFiles[i])
+		if err != nil {
+			return
+		}
+		fmt.Printf("Wrote node configuration: %s\n", configFiles[i])
+	}
+
+	clientConfig := &chat.Configuration{
+		Port:             cp.clientPort,
+		RendezvousString: cp.group,
+		BootstrapPeers:   addresses,
+		ListenIps:        []string{cp.ip},
+		ProtocolID:       cp.protocolID,
+		SwarmKey:         cp.swarmKey,
+	}
+	err = clientConfig.SaveChatConfig(clientFile)
+	if err != nil {
+		return
+	}
+	fmt.Printf("Wrote client configuration: %s\n", clientFile)
+	return
+}
+
+// checkOverwrite refuses to replace an existing file unless forced.  Empty placeholder files
+// are always replaced.
+func (cp *clusterProvisioner) checkOverwrite(file string) (err error) {
+	if info, statErr := os.Stat(file); statErr == nil && info.Size() > 0 && !cp.force {
+		err = fmt.Errorf("%s already exists, use --force to replace it", file)
+	}
+	return
+}
+
+// generateKey writes a new ed25519 key to keyFile.
+func (cp *clusterProvisioner) generateKey(keyFile string) (id peer.ID, err error) {
+	privateKey, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		return
+	}
+	id, err = peer.IDFromPrivateKey(privateKey)
+	if err != nil {
+		return
+	}
+	err = bbscrypto.SavePrivateKey(keyFile, privateKey)
+	return
+}
@@ -0,0 +1,71 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/rightfoot-consulting/p2pbbs/bbscrypto"
+	"github.com/rightfoot-consulting/p2pbbs/chat"
+)
+
+func TestProvision(t *testing.T) {
+	dir := t.TempDir()
+	swarmKey, err := chat.NewSwarmKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp := &clusterProvisioner{count: 3, basePort: 4001, ip: "127.0.0.1", outDir: dir, clientPort: 6666, group: "test", protocolID: "/chat/1.1.0", swarmKey: swarmKey}
+	if err = cp.provision(); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := chat.LoadChatConfig(filepath.Join(dir, "chatconfig.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(client.BootstrapPeers) != 3 || client.SwarmKey != swarmKey || client.Port != 6666 {
+		t.Fatalf("client configuration %+v", client)
+	}
+	for i, name := range []string{"dhtnode1.json", "dhtnode2.json", "dhtnode3.json"} {
+		node, err := chat.LoadChatConfig(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if node.Port != 4001+i || len(node.BootstrapPeers) != 2 || node.SwarmKey != swarmKey {
+			t.Errorf("%s: %+v", name, node)
+		}
+		// every node is dialled at the peer id of its key, and doesn't dial itself
+		key, err := bbscrypto.LoadPrivateKey(node.KeyFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, _ := peer.IDFromPrivateKey(key)
+		if !strings.HasSuffix(client.BootstrapPeers[i], "/p2p/"+id.String()) {
+			t.Errorf("%s is dialled at %s", id, client.BootstrapPeers[i])
+		}
+		for _, other := range node.BootstrapPeers {
+			if other == client.BootstrapPeers[i] {
+				t.Errorf("%s bootstraps from itself", name)
+			}
+		}
+	}
+
+	// nothing is replaced without --force, not even the client configuration
+	before, _ := os.ReadFile(filepath.Join(dir, "chatconfig.json"))
+	if err = cp.provision(); err == nil {
+		t.Fatal("provisioned over existing files")
+	}
+	if after, _ := os.ReadFile(filepath.Join(dir, "chatconfig.json")); string(after) != string(before) {
+		t.Error("the client configuration was replaced")
+	}
+	cp.force = true
+	if err = cp.provision(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -5,6 +5,7 @@ package cmd
 
 import (
 	"fmt"
+	"os"
 
 	"github.com/rightfoot-consulting/p2pbbs/chat"
 	"github.com/spf13/cobra"
@@ -21,9 +22,22 @@ var chatCmd = &cobra.Command{
 
 			chat --listen 6655 --config /etc/chat/config.json
 			Will listen on port 6655 and use /etc/chat/config.json for configuration
+
+			chat --config chatconfig.json --headless
+			Reads JSON commands such as {"type":"send","text":"hi"} from stdin, one per line, and
+			writes JSON events such as {"type":"message","from":"12D3KooW...","text":"hi"} to stdout
 		.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("chat called")
+		headless, err := cmd.Flags().GetBool("headless")
+		if err != nil {
+			panic(err)
+		}
+		if headless {
+			// stdout only carries JSON events
+			fmt.Fprintln(os.Stderr, "chat called")
+		} else {
+			fmt.Println("chat called")
+		}
 
 		query, err := cmd.Flags().GetBool("query")
 		if err != nil {
@@ -77,6 +91,7 @@ var chatCmd = &cobra.Command{
 		if err != nil {
 			panic(err)
 		}
+		node.Headless = headless
 		if query {
 			node.Query()
 		} else {
@@ -94,6 +109,7 @@ func init() {
 	chatCmd.Flags().StringP("group", "g", "", "Unique string to identify group of nodes. Default provided in config.")
 	chatCmd.Flags().StringArrayP("bootstrap-peers", "b", []string{}, "Adds a public peer multiaddreses to the bootstrap list")
 	chatCmd.Flags().Int32P("port", "p", -1, "Specifies the listen port")
+	chatCmd.Flags().Bool("headless", false, "Read JSON commands from stdin and write JSON events to stdout instead of prompting")
 	/*
 		chatCmd.Flags().Int32P("port", "p", 6666, "Specifies the listen port")
 		chatCmd.Flags().StringP("protocol-id", "i", "/chat/1.1.0", "Sets a protocol id for stream headers")
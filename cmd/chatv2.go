@@ -5,35 +5,121 @@ package cmd
 
 import (
 	"fmt"
+	"os"
 
+	"github.com/rightfoot-consulting/p2pbbs/chatv2"
 	"github.com/spf13/cobra"
 )
 
 // chatv2Cmd represents the chatv2 command
 var chatv2Cmd = &cobra.Command{
 	Use:   "chatv2",
-	Short: "A brief description of your command",
-	Long: `A longer description that spans multiple lines and likely contains examples
-and usage of using your command. For example:
+	Short: "Start a pubsub chat room in a text UI",
+	Long: `Use this command to join a chat room built on libp2p pubsub. Peers are found with mDNS on the
+local network and through the DHT of the configured bootstrap peers. For example:
 
-Cobra is a CLI library for Go that empowers applications.
-This application is a tool to generate the needed files
-to quickly create a Cobra application.`,
+			chatv2 --nick alice --room lobby
+			Joins the room 'lobby' as alice with a random identity
+
+			chatv2 --config chatconfig.json --keyfile alice.key
+			Uses the bootstrap peers of chatconfig.json and a static identity
+
+			chatv2 --room lobby --headless
+			Reads JSON commands such as {"type":"send","room":"lobby","text":"hi"} from stdin, one per
+			line, and writes JSON events such as {"type":"peer-joined","room":"lobby","peer":"12D3KooW..."}
+			to stdout instead of drawing the text UI
+		.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("chatv2 called")
+		headless, err := cmd.Flags().GetBool("headless")
+		if err != nil {
+			panic(err)
+		}
+		if headless {
+			// stdout only carries JSON events
+			fmt.Fprintln(os.Stderr, "chatv2 called")
+		} else {
+			fmt.Println("chatv2 called")
+		}
+		config, err := loadChatV2Config(cmd)
+		if err != nil {
+			panic(err)
+		}
+		nick, err := cmd.Flags().GetString("nick")
+		if err != nil {
+			panic(err)
+		}
+		if nick != "" {
+			config.Nick = nick
+		}
+		room, err := cmd.Flags().GetString("room")
+		if err != nil {
+			panic(err)
+		}
+		if room != "" {
+			config.Room = room
+		}
+
+		node, err := chatv2.NewChatV2Node(config)
+		if err != nil {
+			panic(err)
+		}
+		if headless {
+			node.RunHeadless()
+		} else {
+			node.Run()
+		}
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(chatv2Cmd)
+	addChatV2Flags(chatv2Cmd)
+	chatv2Cmd.Flags().StringP("nick", "n", "", "Nickname to use in chat, generated from $USER and the peer id if empty")
+	chatv2Cmd.Flags().StringP("room", "r", "", "Name of the chat room to join (default '"+chatv2.DefaultRoom+"')")
+	chatv2Cmd.Flags().Bool("headless", false, "Read JSON commands from stdin and write JSON events to stdout instead of drawing the text UI")
+}
 
-	// Here you will define your flags and configuration settings.
-
-	// Cobra supports Persistent Flags which will work for this command
-	// and all subcommands, e.g.:
-	// chatv2Cmd.PersistentFlags().String("foo", "", "A help for foo")
+// addChatV2Flags adds the flags shared by every command that starts a chatv2 node.
+func addChatV2Flags(cmd *cobra.Command) {
+	cmd.Flags().StringP("config", "c", "", "Location of a configuration file with bootstrap peers, optional")
+	cmd.Flags().StringP("keyfile", "k", "", "Specifies a key file to use for a static identity")
+	cmd.Flags().StringArrayP("bootstrap-peers", "b", []string{}, "Adds a public peer multiaddreses to the bootstrap list")
+	cmd.Flags().StringP("data-dir", "d", "", "Directory for local state such as petnames (default '"+chatv2.DefaultDataDir+"')")
+}
 
-	// Cobra supports local flags which will only run when this command
-	// is called directly, e.g.:
-	// chatv2Cmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+// loadChatV2Config reads the configuration named by --config, if any, and applies the
+// flags added by addChatV2Flags.
+func loadChatV2Config(cmd *cobra.Command) (config *chatv2.ChatV2Config, err error) {
+	configFile, err := cmd.Flags().GetString("config")
+	if err != nil {
+		return
+	}
+	if configFile != "" {
+		config, err = chatv2.LoadChatV2Config(configFile)
+		if err != nil {
+			return
+		}
+	} else {
+		config = &chatv2.ChatV2Config{}
+	}
+	keyFile, err := cmd.Flags().GetString("keyfile")
+	if err != nil {
+		return
+	}
+	if keyFile != "" {
+		config.KeyFile = keyFile
+	}
+	bsPeers, err := cmd.Flags().GetStringArray("bootstrap-peers")
+	if err != nil {
+		return
+	}
+	config.BootstrapPeers = append(config.BootstrapPeers, bsPeers...)
+	dataDir, err := cmd.Flags().GetString("data-dir")
+	if err != nil {
+		return
+	}
+	if dataDir != "" {
+		config.DataDir = dataDir
+	}
+	return
 }
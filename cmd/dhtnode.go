@@ -4,36 +4,114 @@ Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
 
+	"github.com/libp2p/go-libp2p"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/rightfoot-consulting/p2pbbs/bbscrypto"
+	"github.com/rightfoot-consulting/p2pbbs/bbsdht"
+	"github.com/rightfoot-consulting/p2pbbs/chat"
 	"github.com/spf13/cobra"
 )
 
 // dhtnodeCmd represents the dhtnode command
 var dhtnodeCmd = &cobra.Command{
 	Use:   "dhtnode",
-	Short: "A brief description of your command",
-	Long: `A longer description that spans multiple lines and likely contains examples
-and usage of using your command. For example:
+	Short: "Run a bootstrap dht node",
+	Long: `Runs a dht server node with a static identity that clients can use to bootstrap.
+The configuration files are written by 'bootstrap init'. For example:
 
-Cobra is a CLI library for Go that empowers applications.
-This application is a tool to generate the needed files
-to quickly create a Cobra application.`,
+			dhtnode --config dhtnode1.json
+		.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		fmt.Println("dhtnode called")
+		configFile, err := cmd.Flags().GetString("config")
+		if err != nil {
+			panic(err)
+		}
+		config, err := chat.LoadChatConfig(configFile)
+		if err != nil {
+			panic(err)
+		}
+		node := &dhtNode{config: config}
+		node.run()
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(dhtnodeCmd)
+	dhtnodeCmd.Flags().StringP("config", "c", "dhtnode.json", "Location of the node configuration file written by 'bootstrap init'")
+}
+
+type dhtNode struct {
+	config *chat.Configuration
+	node   host.Host
+	dht    *dht.IpfsDHT
+}
+
+func (dn *dhtNode) run() {
+	listenAddresses, err := dn.config.GetListenAddresses()
+	if err != nil {
+		panic(err)
+	}
+	if dn.config.KeyFile == "" {
+		panic(fmt.Errorf("bootstrap nodes need a key_file for a static identity"))
+	}
+	sk, err := bbscrypto.LoadPrivateKey(dn.config.KeyFile)
+	if err != nil {
+		panic(err)
+	}
+	options, err := dn.config.PrivateNetwork()
+	if err != nil {
+		panic(err)
+	}
+	dn.node, err = libp2p.New(append(options,
+		libp2p.ListenAddrs(listenAddresses...),
+		libp2p.Identity(sk),
+	)...)
+	if err != nil {
+		panic(err)
+	}
+	ourAddresses := make([]string, len(dn.node.Addrs()))
+	for i, addr := range dn.node.Addrs() {
+		ourAddresses[i] = addr.String() + "/p2p/" + dn.node.ID().String()
+		fmt.Printf("Listening on: %s\n", ourAddresses[i])
+	}
+
+	bsPeers, err := dn.config.GetBootstrapPeers(ourAddresses)
+	if err != nil {
+		panic(err)
+	}
+	bootstrapPeers, err := bbsdht.AddrInfos(bsPeers)
+	if err != nil {
+		panic(err)
+	}
 
-	// Here you will define your flags and configuration settings.
+	ctx := context.Background()
+	dn.dht, err = bbsdht.New(ctx, dn.node, dht.ModeServer, bootstrapPeers)
+	if err != nil {
+		panic(err)
+	}
+	if err = dn.dht.Bootstrap(ctx); err != nil {
+		panic(err)
+	}
+	fmt.Println("DHT node running use CTRL-C to quit.")
 
-	// Cobra supports Persistent Flags which will work for this command
-	// and all subcommands, e.g.:
-	// dhtnodeCmd.PersistentFlags().String("foo", "", "A help for foo")
+	// wait for a SIGINT or SIGTERM signal
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
+	<-ch
+	fmt.Println("Received signal, shutting down...")
 
-	// Cobra supports local flags which will only run when this command
-	// is called directly, e.g.:
-	// dhtnodeCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	if err := dn.dht.Close(); err != nil {
+		panic(err)
+	}
+	if err := dn.node.Close(); err != nil {
+		panic(err)
+	}
 }
@@ -0,0 +1,102 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/rightfoot-consulting/p2pbbs/boards"
+	"github.com/rightfoot-consulting/p2pbbs/doors"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// doorCmd groups the door game commands
+var doorCmd = &cobra.Command{
+	Use:   "door",
+	Short: "List the door games and run the built in ones",
+	Long: `Doors are external programs users play from the BBS menu of serve-ssh and serve-telnet, or
+with /door in chatv2.  They are declared in the "doors" list of the configuration:
+
+			"doors": [{"name": "tw2002", "description": "Trade Wars", "command": "/usr/games/tw2002",
+				"args": ["-d", "{dropfile}"], "drop_file": "door.sys", "time_limit": 30}]
+
+The door gets a DOOR.SYS, DORINFO1.DEF or door.json drop file describing the user and talks to
+them over its standard input and output.  A door that writes a number to {scorefile} has it
+posted to the shared "doors" board as the user's score.  Without a "doors" list the built in
+guess door is offered.
+		.`,
+}
+
+// doorListCmd represents the door list command
+var doorListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the doors and their high scores",
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println("door list called")
+		config, err := loadChatV2Config(cmd)
+		if err != nil {
+			panic(err)
+		}
+		dataDir, err := config.DataDirectory()
+		if err != nil {
+			panic(err)
+		}
+		store, err := boards.OpenStore(filepath.Join(dataDir, boards.StoreDir))
+		if err != nil {
+			panic(err)
+		}
+		for _, door := range config.DoorList() {
+			fmt.Printf("%s - %s (%s, %s)\n", door.Name, door.Description, door.Format(), door.Limit())
+			for i, score := range doors.HighScores(store, door.Name, 10) {
+				fmt.Printf("    %2d. %-20s %6d  %s\n", i+1, score.Nick, score.Score, score.Author)
+			}
+		}
+	},
+}
+
+// doorGuessCmd is the built in guess door, run by the BBS with a door.json drop file.  It
+// talks only to the player, so it doesn't announce itself like the other commands.
+var doorGuessCmd = &cobra.Command{
+	Use:    "guess",
+	Short:  "The built in guess the number door",
+	Hidden: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		dropFile, err := cmd.Flags().GetString("drop-file")
+		if err != nil {
+			panic(err)
+		}
+		scoreFile, err := cmd.Flags().GetString("score-file")
+		if err != nil {
+			panic(err)
+		}
+		info, err := doors.ReadDropInfo(dropFile)
+		if err != nil {
+			panic(err)
+		}
+		// a local terminal echoes for itself, a remote one relies on the door
+		echo := !term.IsTerminal(int(os.Stdin.Fd()))
+		score, err := doors.Guess(os.Stdin, os.Stdout, info, echo)
+		if err != nil {
+			return
+		}
+		if score > 0 && scoreFile != "" {
+			if err = os.WriteFile(scoreFile, []byte(strconv.Itoa(score)), 0600); err != nil {
+				panic(err)
+			}
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(doorCmd)
+	doorCmd.AddCommand(doorListCmd)
+	doorCmd.AddCommand(doorGuessCmd)
+	addChatV2Flags(doorListCmd)
+	doorGuessCmd.Flags().String("drop-file", "", "door.json drop file describing the player")
+	doorGuessCmd.Flags().String("score-file", "", "File the score is written to")
+}
@@ -0,0 +1,66 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// exportCmd groups the commands writing the boards out in other formats
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Write the boards out in other formats",
+}
+
+// exportHtmlCmd represents the export html command
+var exportHtmlCmd = &cobra.Command{
+	Use:   "html <dir>",
+	Short: "Write the boards as a static HTML site with Atom and RSS feeds",
+	Long: `Writes the boards in the data directory to dir as a static site: an index of boards, a page
+per board and thread, a permalink page per post named after its content id, and an Atom and an
+RSS feed for every board and thread.  Links are relative, so the directory can be published
+anywhere; give --base-url so feed readers get absolute links.  With --sync the node first joins
+the network for a while to catch up. For example:
+
+			export html ./site --board announcements --base-url https://bbs.example.org/
+			Writes a mirror of the announcements board to ./site
+		.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println("export html called")
+		config, err := loadChatV2Config(cmd)
+		if err != nil {
+			panic(err)
+		}
+		sync, err := cmd.Flags().GetDuration("sync")
+		if err != nil {
+			panic(err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		store := openBoardStore(ctx, config, sync == 0)
+		if sync > 0 {
+			fmt.Printf("Collecting posts from the network for %s\n", sync)
+			time.Sleep(sync)
+		}
+		pages, err := archiveSite(cmd, store).Export(args[0])
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("Wrote %d pages to %s\n", pages, args[0])
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+	exportCmd.AddCommand(exportHtmlCmd)
+	addChatV2Flags(exportHtmlCmd)
+	addArchiveFlags(exportHtmlCmd)
+	exportHtmlCmd.Flags().Duration("sync", 0, "Join the network for this long before exporting (default export the stored posts)")
+}
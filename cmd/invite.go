@@ -0,0 +1,342 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/rightfoot-consulting/p2pbbs/bbscrypto"
+	"github.com/rightfoot-consulting/p2pbbs/chat"
+	"github.com/rightfoot-consulting/p2pbbs/chatv2"
+	"github.com/rightfoot-consulting/p2pbbs/invite"
+	"github.com/rightfoot-consulting/p2pbbs/qrcode"
+	"github.com/rightfoot-consulting/p2pbbs/trust"
+	"github.com/spf13/cobra"
+)
+
+// inviteCmd groups the commands that create and accept invites
+var inviteCmd = &cobra.Command{
+	Use:   "invite",
+	Short: "Invite peers to the network with signed invite codes",
+	Long: `An invite code carries everything a new peer needs to join: bootstrap peer addresses, the
+rendezvous string and room, the owners pinned for boards and rooms and, when the swarm or room
+is private, their keys.  Codes are signed
+by the peer who made them and expire.  Invites to a private swarm or room are secrets, share
+them the way you would share a password.`,
+}
+
+// inviteCreateCmd represents the invite create command
+var inviteCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Create an invite signed by the identity in --keyfile",
+	Long: `Creates an invite to the bootstrap peers, room, pinned owners and swarm key of the configuration.
+For example:
+
+			invite create --config chatconfig.json --keyfile alice.key --room lobby --qr
+			Prints an invite to lobby valid for a day, with a QR code to scan from a phone
+
+			invite create --keyfile alice.key --private ops --for 12D3KooW... --valid 1h
+			Adds 12D3KooW... to the private room ops and prints an invite only that peer can accept
+		.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println("invite create called")
+		config, err := loadChatV2Config(cmd)
+		if err != nil {
+			panic(err)
+		}
+		if config.KeyFile == "" {
+			panic(fmt.Errorf("invites are signed, use --keyfile"))
+		}
+		privateKey, err := bbscrypto.LoadPrivateKey(config.KeyFile)
+		if err != nil {
+			panic(err)
+		}
+		peers, err := cmd.Flags().GetStringArray("peer")
+		if err != nil {
+			panic(err)
+		}
+		room, err := cmd.Flags().GetString("room")
+		if err != nil {
+			panic(err)
+		}
+		rendezvous, err := cmd.Flags().GetString("rendezvous")
+		if err != nil {
+			panic(err)
+		}
+		valid, err := cmd.Flags().GetDuration("valid")
+		if err != nil {
+			panic(err)
+		}
+		forParam, err := cmd.Flags().GetString("for")
+		if err != nil {
+			panic(err)
+		}
+		privateRoom, err := cmd.Flags().GetString("private")
+		if err != nil {
+			panic(err)
+		}
+		qr, err := cmd.Flags().GetBool("qr")
+		if err != nil {
+			panic(err)
+		}
+
+		inv := &invite.Invite{
+			Peers:      append(peers, config.BootstrapPeers...),
+			Rendezvous: rendezvous,
+			Room:       room,
+			SwarmKey:   config.SwarmKey,
+			Owners:     config.Owners,
+		}
+		if configFile, _ := cmd.Flags().GetString("config"); configFile != "" && inv.Rendezvous == "" {
+			chatConfig, err := chat.LoadChatConfig(configFile)
+			if err != nil {
+				panic(err)
+			}
+			inv.Rendezvous = chatConfig.RendezvousString
+		}
+		if inv.Room == "" {
+			inv.Room = config.Room
+		}
+		if len(inv.Peers) == 0 {
+			panic(fmt.Errorf("no peers to invite to, use --peer or a --config with bootstrap peers"))
+		}
+		if forParam != "" {
+			to, err := peer.Decode(forParam)
+			if err != nil {
+				panic(err)
+			}
+			inv.To = to.String()
+			if privateRoom != "" {
+				store, _ := loadPrivateStore(cmd)
+				m, err := store.Find(privateRoom)
+				if err != nil {
+					panic(err)
+				}
+				if !m.IsMember(to) {
+					if m, err = m.Add(privateKey, to); err != nil {
+						panic(err)
+					}
+					if _, err = store.Add(m); err != nil {
+						panic(err)
+					}
+				}
+				inv.Private = m
+				inv.Room = m.Name
+			}
+		} else if privateRoom != "" {
+			panic(fmt.Errorf("invites to a private room are made out to a peer, use --for"))
+		}
+		if inv.Room == "" {
+			inv.Room = chatv2.DefaultRoom
+		}
+		if err = inv.Sign(privateKey, valid); err != nil {
+			panic(err)
+		}
+		code, err := inv.Code()
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("Invite to %s valid until %s:\n\n%s\n\nAccept it with: p2pbbs invite accept <code>\n", inv.Room, inv.Expires.Local().Format(time.DateTime), code)
+		if inv.SwarmKey != "" || inv.Private != nil {
+			fmt.Println("The invite holds keys, share it only with the peer it is for.")
+		}
+		if qr {
+			c, err := qrcode.Encode([]byte(code), qrcode.Low)
+			if err != nil {
+				panic(fmt.Errorf("the invite is too long for a QR code: %w", err))
+			}
+			fmt.Printf("\n%s", c.Terminal())
+		}
+	},
+}
+
+// inviteAcceptCmd represents the invite accept command
+var inviteAcceptCmd = &cobra.Command{
+	Use:   "accept <code>",
+	Short: "Accept an invite and join the room it is for",
+	Long: `Verifies an invite, adds its peers, rendezvous string, room, owners and swarm key to the
+configuration, keeps the private room it carries, then joins the room. Owners the configuration
+already pins are kept. Anyone can sign an invite, so the signer is shown with its petname and
+what the web of trust says about it, and the invite is only accepted once confirmed. For example:
+
+			invite accept --keyfile bob.key p2pbbs-invite:7L0HWm...
+			Writes chatconfig.json and joins the room of the invite
+
+			invite accept --config my.json --no-join --yes p2pbbs-invite:7L0HWm...
+			Only writes my.json without asking, join later with: chatv2 --config my.json
+		.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println("invite accept called")
+		noJoin, err := cmd.Flags().GetBool("no-join")
+		if err != nil {
+			panic(err)
+		}
+		nick, err := cmd.Flags().GetString("nick")
+		if err != nil {
+			panic(err)
+		}
+		inv, err := invite.Parse(args[0])
+		if err != nil {
+			panic(err)
+		}
+		configFile, err := cmd.Flags().GetString("config")
+		if err != nil {
+			panic(err)
+		}
+		if configFile == "" {
+			configFile = "chatconfig.json"
+			if err = cmd.Flags().Set("config", configFile); err != nil {
+				panic(err)
+			}
+		}
+		keyFile, err := cmd.Flags().GetString("keyfile")
+		if err != nil {
+			panic(err)
+		}
+		if inv.To != "" {
+			if keyFile == "" {
+				panic(fmt.Errorf("the invite is for %s, use --keyfile", inv.To))
+			}
+			privateKey, err := bbscrypto.LoadPrivateKey(keyFile)
+			if err != nil {
+				panic(err)
+			}
+			id, err := peer.IDFromPrivateKey(privateKey)
+			if err != nil {
+				panic(err)
+			}
+			if !inv.IsFor(id) {
+				panic(invite.ErrNotForUs)
+			}
+		}
+		yes, err := cmd.Flags().GetBool("yes")
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("Invite to %s from %s, valid until %s\n", inv.Room, describeSigner(cmd, inv), inv.Expires.Local().Format(time.DateTime))
+		fmt.Printf("It adds %d bootstrap peers", len(inv.Peers))
+		if inv.SwarmKey != "" {
+			fmt.Print(", replaces the swarm key")
+		}
+		if len(inv.Owners) > 0 {
+			fmt.Printf(", pins the owners of %d boards and rooms", len(inv.Owners))
+		}
+		fmt.Printf(" in %s\n", configFile)
+		if !yes && !confirm("Accept it? [y/N] ") {
+			fmt.Println("Invite not accepted")
+			return
+		}
+		if err = inv.Merge(configFile); err != nil {
+			panic(err)
+		}
+		fmt.Printf("Invite from %s to %s written to %s\n", inv.From, inv.Room, configFile)
+		if inv.Private != nil {
+			store, _ := loadPrivateStore(cmd)
+			if _, err = store.Add(inv.Private); err != nil {
+				panic(err)
+			}
+			printMembership(inv.Private)
+		}
+		if noJoin {
+			return
+		}
+		config, err := loadChatV2Config(cmd)
+		if err != nil {
+			panic(err)
+		}
+		if nick != "" {
+			config.Nick = nick
+		}
+		node, err := chatv2.NewChatV2Node(config)
+		if err != nil {
+			panic(err)
+		}
+		node.Run()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(inviteCmd)
+	inviteCmd.AddCommand(inviteCreateCmd, inviteAcceptCmd)
+	addChatV2Flags(inviteCreateCmd)
+	addChatV2Flags(inviteAcceptCmd)
+	inviteCreateCmd.Flags().StringArrayP("peer", "p", nil, "Adds a peer multiaddress to those of the configuration, repeat for each peer")
+	inviteCreateCmd.Flags().StringP("room", "r", "", "Room to invite to (default the room of the configuration, or '"+chatv2.DefaultRoom+"')")
+	inviteCreateCmd.Flags().String("rendezvous", "", "Rendezvous string of the invite (default the rendezvous_string of the configuration)")
+	inviteCreateCmd.Flags().Duration("valid", 24*time.Hour, "How long the invite can be accepted for")
+	inviteCreateCmd.Flags().String("for", "", "Peer id of the only peer who can accept the invite")
+	inviteCreateCmd.Flags().String("private", "", "Private room to add the peer of --for to and invite them into")
+	inviteCreateCmd.Flags().Bool("qr", false, "Also print the invite as a QR code")
+	inviteAcceptCmd.Flags().Bool("no-join", false, "Only write the configuration, don't join the room")
+	inviteAcceptCmd.Flags().StringP("nick", "n", "", "Nickname to use in chat, generated from $USER and the peer id if empty")
+	inviteAcceptCmd.Flags().BoolP("yes", "y", false, "Accept the invite without asking for confirmation")
+}
+
+// describeSigner names the signer of an invite for the peer accepting it: its peer id, the
+// petname we gave it and what the web of trust says about it.  Without a static identity the web
+// of trust can't be asked.
+func describeSigner(cmd *cobra.Command, inv *invite.Invite) string {
+	from, err := peer.Decode(inv.From)
+	if err != nil {
+		panic(err)
+	}
+	config, err := loadChatV2Config(cmd)
+	if err != nil {
+		// the configuration the invite is written to may not exist yet
+		config = &chatv2.ChatV2Config{}
+		config.KeyFile, _ = cmd.Flags().GetString("keyfile")
+		config.DataDir, _ = cmd.Flags().GetString("data-dir")
+	}
+	dataDir, err := config.DataDirectory()
+	if err != nil {
+		panic(err)
+	}
+	var notes []string
+	names, err := chatv2.LoadNameBook(filepath.Join(dataDir, chatv2.NamesFile))
+	if err != nil {
+		panic(err)
+	}
+	if petname, ok := names.Petname(from); ok {
+		notes = append(notes, "petname "+petname)
+	}
+	if config.KeyFile == "" {
+		notes = append(notes, "not checked against the web of trust without --keyfile")
+		return fmt.Sprintf("%s (%s)", from, strings.Join(notes, ", "))
+	}
+	privateKey, err := bbscrypto.LoadPrivateKey(config.KeyFile)
+	if err != nil {
+		panic(err)
+	}
+	self, err := peer.IDFromPrivateKey(privateKey)
+	if err != nil {
+		panic(err)
+	}
+	store, err := trust.LoadStore(filepath.Join(dataDir, trust.StoreFile))
+	if err != nil {
+		panic(err)
+	}
+	verdict := store.Evaluate(self, from)
+	if verdict.Status == trust.Trusted {
+		notes = append(notes, "trusted as "+verdict.Nick)
+	} else {
+		notes = append(notes, verdict.Status.String())
+	}
+	return fmt.Sprintf("%s (%s)", from, strings.Join(notes, ", "))
+}
+
+// confirm asks a yes or no question on stdin, anything but yes is no.
+func confirm(prompt string) bool {
+	fmt.Print(prompt)
+	line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer := strings.ToLower(strings.TrimSpace(line))
+	return answer == "y" || answer == "yes"
+}
@@ -4,40 +4,129 @@ Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
+	"os"
 
-	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/rightfoot-consulting/p2pbbs/bbscrypto"
 	"github.com/spf13/cobra"
 )
 
 // keyinfoCmd represents the keyinfo command
 var keyinfoCmd = &cobra.Command{
-	Use:   "keyinfo",
-	Short: "Read a private key and print out it's corresponding peer id",
-	Long: `Peer ids are a multihash of the public key for a node.  This key is read from the private key file generated with generateKey.
- This command will read the private key file and print out the corresponding peer id.`,
+	Use:   "keyinfo [file...]",
+	Short: "Describe private keys, public keys and peer ids",
+	Long: `Peer ids are a multihash of the public key for a node.  This command reads key files generated with
+generateKey, public keys or peer ids and prints everything that can be derived from them: the key type, the
+curve or bit size, the public key, the peer id in base58 and CID form and warnings about file permissions.
+For example:
+
+			keyinfo bootstrap1.key bootstrap2.key
+			Describes two private key files
+
+			keyinfo --peer-id 12D3KooWDJMbnbWfKFLfPZffkJMiovaRMCCy3aGTExsE1GBLek2P --json
+			Prints what can be learned from a peer id as JSON
+		.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("keyinfo called")
+		asJSON, err := cmd.Flags().GetBool("json")
+		if err != nil {
+			panic(err)
+		}
 		fileName, err := cmd.Flags().GetString("file")
 		if err != nil {
 			panic(err)
 		}
-		fmt.Printf("Reading file '%s'\n", fileName)
-
-		privateKey, err := bbscrypto.LoadPrivateKey(fileName)
+		peerIDs, err := cmd.Flags().GetStringArray("peer-id")
 		if err != nil {
 			panic(err)
 		}
-		id, err := peer.IDFromPrivateKey(privateKey)
+		publicKeys, err := cmd.Flags().GetStringArray("public-key")
 		if err != nil {
 			panic(err)
 		}
-		fmt.Printf("Id: %s\n\n", id.String())
+		if !asJSON {
+			fmt.Println("keyinfo called")
+		}
+
+		files := args
+		if fileName != "" {
+			files = append([]string{fileName}, files...)
+		}
+		if len(files)+len(peerIDs)+len(publicKeys) == 0 {
+			fmt.Fprintln(os.Stderr, "nothing to inspect, pass key files, --peer-id or --public-key")
+			os.Exit(1)
+		}
+
+		failed := false
+		infos := make([]*bbscrypto.KeyInfo, 0, len(files)+len(peerIDs)+len(publicKeys))
+		for _, file := range files {
+			info, err := bbscrypto.InspectKeyFile(file)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				failed = true
+				continue
+			}
+			infos = append(infos, info)
+		}
+		for _, value := range append(peerIDs, publicKeys...) {
+			info, err := bbscrypto.InspectKey([]byte(value))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", value, err)
+				failed = true
+				continue
+			}
+			infos = append(infos, info)
+		}
+
+		if asJSON {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			if err := encoder.Encode(infos); err != nil {
+				panic(err)
+			}
+		} else {
+			for _, info := range infos {
+				printKeyInfo(info)
+			}
+		}
+		if failed {
+			os.Exit(1)
+		}
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(keyinfoCmd)
 	keyinfoCmd.Flags().StringP("file", "f", "", "Specifies the private key to read in, this is used as the basis for the peer id.")
+	keyinfoCmd.Flags().BoolP("json", "j", false, "Print the results as a JSON array")
+	keyinfoCmd.Flags().StringArrayP("peer-id", "p", []string{}, "Describe a peer id given in base58 or CID form")
+	keyinfoCmd.Flags().StringArrayP("public-key", "k", []string{}, "Describe a public key protobuf given in multibase, base58 or base64")
+}
+
+func printKeyInfo(info *bbscrypto.KeyInfo) {
+	if info.File != "" {
+		fmt.Printf("File:       %s\n", info.File)
+	}
+	fmt.Printf("Kind:       %s\n", info.Kind)
+	if info.KeyType != "" {
+		fmt.Printf("Key type:   %s\n", info.KeyType)
+	}
+	if info.Curve != "" {
+		fmt.Printf("Curve:      %s\n", info.Curve)
+	}
+	if info.Bits > 0 {
+		fmt.Printf("Bits:       %d\n", info.Bits)
+	}
+	if info.PublicKey != "" {
+		fmt.Printf("Public key: %s\n", info.PublicKey)
+	}
+	if info.PeerID != "" {
+		fmt.Printf("Id:         %s\n", info.PeerID)
+		fmt.Printf("Id (CID):   %s\n", info.PeerIDCid)
+	}
+	fmt.Printf("Encrypted:  %t\n", info.Encrypted)
+	for _, warning := range info.Warnings {
+		fmt.Printf("Warning:    %s\n", warning)
+	}
+	fmt.Println()
 }
@@ -0,0 +1,238 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/rightfoot-consulting/p2pbbs/bbscrypto"
+	"github.com/rightfoot-consulting/p2pbbs/moderation"
+	"github.com/rightfoot-consulting/p2pbbs/sanitize"
+	"github.com/spf13/cobra"
+)
+
+// moderateCmd groups the commands that manage moderators and moderation actions
+var moderateCmd = &cobra.Command{
+	Use:   "moderate",
+	Short: "Own and moderate boards and chat rooms",
+	Long: `The owner of a board or room is pinned in the owners section of the configuration, which invites
+carry, and names its moderators in a signed manifest.  Moderators sign actions that every node verifies and applies: deleting and pinning posts, setting
+the topic, and muting or banning peers.  Manifests and actions are kept in the data directory and
+reach other nodes when they next sync history with this node.`,
+}
+
+// moderateClaimCmd represents the moderate claim command
+var moderateClaimCmd = &cobra.Command{
+	Use:   "claim",
+	Short: "Claim a board or room, or change its moderators",
+	Long: `Signs a manifest naming the moderators of a board or room, as the identity in --keyfile which must
+be the owner pinned for it in the owners section of the configuration. The owner claims it again
+to change the moderators. For example:
+
+			moderate claim --keyfile alice.key --board general --moderator 12D3KooW...
+			Makes 12D3KooW... a moderator of the general board, which alice owns
+			moderate claim --keyfile alice.key --board general --stamp-bits 16 --stamp-target 60
+			Asks every post on general for a proof of work of 16 bits, more past 60 posts an hour
+		.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println("moderate claim called")
+		moderatorParams, err := cmd.Flags().GetStringSlice("moderator")
+		if err != nil {
+			panic(err)
+		}
+		moderators := make([]peer.ID, len(moderatorParams))
+		for i, param := range moderatorParams {
+			if moderators[i], err = peer.Decode(param); err != nil {
+				panic(err)
+			}
+		}
+		var stamp *moderation.StampPolicy
+		if cmd.Flags().Changed("stamp-bits") {
+			stamp = new(moderation.StampPolicy)
+			if stamp.Bits, err = cmd.Flags().GetInt("stamp-bits"); err != nil {
+				panic(err)
+			}
+			if stamp.MaxBits, err = cmd.Flags().GetInt("stamp-max-bits"); err != nil {
+				panic(err)
+			}
+			if stamp.TargetPerHour, err = cmd.Flags().GetInt("stamp-target"); err != nil {
+				panic(err)
+			}
+		}
+		store, privateKey := loadModerationStore(cmd)
+		m, err := moderation.NewManifest(privateKey, moderationScope(cmd), moderators, stamp)
+		if err != nil {
+			panic(err)
+		}
+		if _, err = store.AddManifest(m); err != nil {
+			panic(err)
+		}
+		printManifest(m)
+	},
+}
+
+// moderateShowCmd represents the moderate show command
+var moderateShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Show who moderates a board or room and what they did",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println("moderate show called")
+		store, _ := loadModerationStore(cmd)
+		scope := moderationScope(cmd)
+		view := store.View(scope)
+		if !view.Moderated() {
+			fmt.Printf("nobody moderates %s\n", scope)
+			return
+		}
+		printManifest(view.Manifest)
+		if view.Topic != "" {
+			fmt.Printf("Topic: %s\n", sanitize.Line(view.Topic))
+		}
+		fmt.Println("Actions:")
+		for _, a := range store.Actions(scope) {
+			fmt.Printf("\t%s %s\n", a.Created.Local().Format(time.DateTime), sanitize.Line(a.String()))
+		}
+	},
+}
+
+// moderateActionCmds sign one kind of moderation action each
+var moderateActionCmds = []*cobra.Command{
+	newModerateActionCmd(moderation.Delete, "delete <post id>", "Delete a post from every node"),
+	newModerateActionCmd(moderation.Pin, "pin <post id>", "Show a thread before the others on its board"),
+	newModerateActionCmd(moderation.Unpin, "unpin <post id>", "Stop pinning a thread"),
+	newModerateActionCmd(moderation.Topic, "topic <text>", "Set the topic of a board or room"),
+	newModerateActionCmd(moderation.Mute, "mute <peer id>", "Silence a peer for --for"),
+	newModerateActionCmd(moderation.Unmute, "unmute <peer id>", "Lift the mute of a peer"),
+	newModerateActionCmd(moderation.Ban, "ban <peer id>", "Silence a peer until it is unbanned"),
+	newModerateActionCmd(moderation.Unban, "unban <peer id>", "Lift the ban of a peer"),
+}
+
+func init() {
+	rootCmd.AddCommand(moderateCmd)
+	moderateCmd.AddCommand(moderateClaimCmd)
+	moderateCmd.AddCommand(moderateShowCmd)
+	moderateCmd.AddCommand(moderateActionCmds...)
+	for _, cmd := range append([]*cobra.Command{moderateClaimCmd, moderateShowCmd}, moderateActionCmds...) {
+		addChatV2Flags(cmd)
+		cmd.Flags().String("board", "", "The board to moderate")
+		cmd.Flags().String("room", "", "The chat room to moderate")
+		cmd.MarkFlagsOneRequired("board", "room")
+		cmd.MarkFlagsMutuallyExclusive("board", "room")
+	}
+	moderateClaimCmd.Flags().StringSlice("moderator", nil, "The peer id of a moderator, repeat for each moderator")
+	moderateClaimCmd.Flags().Int("stamp-bits", 0, "The proof of work posts on the board must carry, in bits, each bit doubling the work")
+	moderateClaimCmd.Flags().Int("stamp-max-bits", 0, "The most the proof of work may adapt to, defaults to the maximum of 32 bits")
+	moderateClaimCmd.Flags().Int("stamp-target", 0, "Posts an hour beyond which the proof of work rises a bit for every doubling, 0 for a fixed difficulty")
+}
+
+// newModerateActionCmd returns the command signing actions of the given kind.
+func newModerateActionCmd(kind moderation.Kind, use string, short string) *cobra.Command {
+	args := cobra.ExactArgs(1)
+	if kind == moderation.Topic {
+		args = cobra.MinimumNArgs(1)
+	}
+	actionCmd := &cobra.Command{
+		Use:   use,
+		Short: short,
+		Args:  args,
+		Run: func(cmd *cobra.Command, args []string) {
+			fmt.Printf("moderate %s called\n", kind)
+			reason, err := cmd.Flags().GetString("reason")
+			if err != nil {
+				panic(err)
+			}
+			var duration time.Duration
+			if kind == moderation.Mute {
+				if duration, err = cmd.Flags().GetDuration("for"); err != nil {
+					panic(err)
+				}
+			}
+			target, topic := args[0], ""
+			if kind == moderation.Topic {
+				target, topic = "", strings.Join(args, " ")
+			}
+			store, privateKey := loadModerationStore(cmd)
+			a, err := moderation.NewAction(privateKey, moderationScope(cmd), kind, target, topic, duration, reason)
+			if err != nil {
+				panic(err)
+			}
+			if _, err = store.AddAction(a); err != nil {
+				panic(err)
+			}
+			fmt.Println(a)
+		},
+	}
+	actionCmd.Flags().String("reason", "", "Why the action was taken, shown to other peers")
+	if kind == moderation.Mute {
+		actionCmd.Flags().Duration("for", time.Hour, "How long the mute lasts")
+	}
+	return actionCmd
+}
+
+// moderationScope returns the scope named by --board or --room.
+func moderationScope(cmd *cobra.Command) string {
+	board, err := cmd.Flags().GetString("board")
+	if err != nil {
+		panic(err)
+	}
+	if board != "" {
+		return moderation.BoardScope(board)
+	}
+	room, err := cmd.Flags().GetString("room")
+	if err != nil {
+		panic(err)
+	}
+	return moderation.RoomScope(room)
+}
+
+// loadModerationStore opens the moderation store in the data directory and returns it with the
+// identity in --keyfile.
+func loadModerationStore(cmd *cobra.Command) (store *moderation.Store, privateKey crypto.PrivKey) {
+	config, err := loadChatV2Config(cmd)
+	if err != nil {
+		panic(err)
+	}
+	if config.KeyFile == "" {
+		panic(fmt.Errorf("moderation needs a static identity, use --keyfile"))
+	}
+	privateKey, err = bbscrypto.LoadPrivateKey(config.KeyFile)
+	if err != nil {
+		panic(err)
+	}
+	dataDir, err := config.DataDirectory()
+	if err != nil {
+		panic(err)
+	}
+	owners, err := config.ScopeOwners()
+	if err != nil {
+		panic(err)
+	}
+	store, err = moderation.LoadStore(filepath.Join(dataDir, moderation.StoreFile), owners)
+	if err != nil {
+		panic(err)
+	}
+	return
+}
+
+// printManifest prints a manifest, which may be a peer's, cleaned for the terminal.
+func printManifest(m *moderation.Manifest) {
+	fmt.Printf("%s owned by %s since %s\n", sanitize.Line(m.Scope), sanitize.Line(m.Owner), m.Created.Local().Format(time.DateTime))
+	for _, moderator := range m.Moderators {
+		fmt.Printf("\tmoderator %s\n", sanitize.Line(moderator))
+	}
+	if m.Stamp != nil {
+		fmt.Printf("\tposts need a %d bit stamp", m.Stamp.Bits)
+		if m.Stamp.TargetPerHour > 0 {
+			fmt.Printf(", rising past %d posts an hour", m.Stamp.TargetPerHour)
+		}
+		fmt.Println()
+	}
+}
@@ -0,0 +1,244 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/rightfoot-consulting/p2pbbs/bbscrypto"
+	"github.com/rightfoot-consulting/p2pbbs/private"
+	"github.com/spf13/cobra"
+)
+
+// privateCmd groups the commands that manage private chat rooms
+var privateCmd = &cobra.Command{
+	Use:   "private",
+	Short: "Create and manage private chat rooms",
+	Long: `Private rooms are joined like any other chat room, but only their members can find or read them.
+Messages are encrypted under a group key the owner seals to each member's public key, and removing
+members rotates the key.  The topic of a private room is a hash of its random id rather than its
+name.  New members join with the code printed when they are added; the records are kept in the
+data directory and reach the other members the next time they are in the room.`,
+}
+
+// privateCreateCmd represents the private create command
+var privateCreateCmd = &cobra.Command{
+	Use:   "create <name>",
+	Short: "Create a private room owned by the identity in --keyfile",
+	Long: `Creates a private room with a new key. For example:
+
+			private create --keyfile alice.key ops --member 12D3KooW...
+			Creates the private room ops with alice as its owner and 12D3KooW... as a member
+		.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println("private create called")
+		memberParams, err := cmd.Flags().GetStringSlice("member")
+		if err != nil {
+			panic(err)
+		}
+		store, privateKey := loadPrivateStore(cmd)
+		m, err := private.NewRoom(privateKey, args[0], decodePeers(memberParams))
+		if err != nil {
+			panic(err)
+		}
+		if _, err = store.Add(m); err != nil {
+			panic(err)
+		}
+		printMembership(m)
+		printJoinCode(m)
+	},
+}
+
+// privateListCmd represents the private list command
+var privateListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the private rooms this identity belongs to",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println("private list called")
+		store, privateKey := loadPrivateStore(cmd)
+		self, err := peer.IDFromPrivateKey(privateKey)
+		if err != nil {
+			panic(err)
+		}
+		for _, m := range store.Rooms() {
+			status := fmt.Sprintf("%d members", len(m.Members))
+			if !m.IsMember(self) {
+				status = "removed"
+			}
+			fmt.Printf("%s\t%s\t%s\n", m.Name, m.Room, status)
+		}
+	},
+}
+
+// privateShowCmd represents the private show command
+var privateShowCmd = &cobra.Command{
+	Use:   "show <room>",
+	Short: "Show the members of a private room",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println("private show called")
+		store, _ := loadPrivateStore(cmd)
+		m, err := store.Find(args[0])
+		if err != nil {
+			panic(err)
+		}
+		printMembership(m)
+	},
+}
+
+// privateAddCmd represents the private add command
+var privateAddCmd = &cobra.Command{
+	Use:   "add <room> <peer id>...",
+	Short: "Add members to a private room and print their join code",
+	Args:  cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println("private add called")
+		store, privateKey := loadPrivateStore(cmd)
+		m, err := store.Find(args[0])
+		if err != nil {
+			panic(err)
+		}
+		next, err := m.Add(privateKey, decodePeers(args[1:])...)
+		if err != nil {
+			panic(err)
+		}
+		if _, err = store.Add(next); err != nil {
+			panic(err)
+		}
+		printMembership(next)
+		printJoinCode(next)
+	},
+}
+
+// privateRemoveCmd represents the private remove command
+var privateRemoveCmd = &cobra.Command{
+	Use:   "remove <room> <peer id>...",
+	Short: "Remove members from a private room, rotating its key",
+	Args:  cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println("private remove called")
+		store, privateKey := loadPrivateStore(cmd)
+		m, err := store.Find(args[0])
+		if err != nil {
+			panic(err)
+		}
+		next, err := m.Remove(privateKey, decodePeers(args[1:])...)
+		if err != nil {
+			panic(err)
+		}
+		if _, err = store.Add(next); err != nil {
+			panic(err)
+		}
+		printMembership(next)
+	},
+}
+
+// privateCodeCmd represents the private code command
+var privateCodeCmd = &cobra.Command{
+	Use:   "code <room>",
+	Short: "Print the join code of a private room again",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println("private code called")
+		store, _ := loadPrivateStore(cmd)
+		m, err := store.Find(args[0])
+		if err != nil {
+			panic(err)
+		}
+		printJoinCode(m)
+	},
+}
+
+// privateJoinCmd represents the private join command
+var privateJoinCmd = &cobra.Command{
+	Use:   "join <code>",
+	Short: "Join a private room with the code its owner gave you",
+	Long: `Verifies a join code and keeps the private room it describes, which is then joined by name. For
+example:
+
+			private join --keyfile bob.key eyJyb29tIjoi...
+			chatv2 --keyfile bob.key --room ops
+		.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println("private join called")
+		store, _ := loadPrivateStore(cmd)
+		m, err := private.ParseCode(args[0])
+		if err != nil {
+			panic(err)
+		}
+		if _, err = store.Add(m); err != nil {
+			panic(err)
+		}
+		printMembership(m)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(privateCmd)
+	privateCmds := []*cobra.Command{privateCreateCmd, privateListCmd, privateShowCmd, privateAddCmd, privateRemoveCmd, privateCodeCmd, privateJoinCmd}
+	privateCmd.AddCommand(privateCmds...)
+	for _, cmd := range privateCmds {
+		addChatV2Flags(cmd)
+	}
+	privateCreateCmd.Flags().StringSlice("member", nil, "The peer id of a member, repeat for each member")
+}
+
+// loadPrivateStore opens the private room store in the data directory for the identity in
+// --keyfile and returns it with the identity.
+func loadPrivateStore(cmd *cobra.Command) (store *private.Store, privateKey crypto.PrivKey) {
+	config, err := loadChatV2Config(cmd)
+	if err != nil {
+		panic(err)
+	}
+	if config.KeyFile == "" {
+		panic(fmt.Errorf("private rooms need a static identity, use --keyfile"))
+	}
+	privateKey, err = bbscrypto.LoadPrivateKey(config.KeyFile)
+	if err != nil {
+		panic(err)
+	}
+	dataDir, err := config.DataDirectory()
+	if err != nil {
+		panic(err)
+	}
+	store, err = private.LoadStore(filepath.Join(dataDir, private.StoreFile), privateKey)
+	if err != nil {
+		panic(err)
+	}
+	return
+}
+
+// decodePeers decodes peer ids given on the command line.
+func decodePeers(params []string) []peer.ID {
+	ids := make([]peer.ID, len(params))
+	for i, param := range params {
+		var err error
+		if ids[i], err = peer.Decode(param); err != nil {
+			panic(err)
+		}
+	}
+	return ids
+}
+
+func printMembership(m *private.Membership) {
+	fmt.Printf("private room %s (%s) owned by %s, key %d since %s\n", m.Name, m.Room, m.Owner, m.Epoch, m.Created.Local().Format(time.DateTime))
+	for _, member := range m.Members {
+		fmt.Printf("\tmember %s\n", member)
+	}
+}
+
+func printJoinCode(m *private.Membership) {
+	code, err := m.Code()
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("New members join with:\n\n\tp2pbbs private join %s\n", code)
+}
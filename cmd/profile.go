@@ -0,0 +1,180 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/mr-tron/base58/base58"
+	"github.com/rightfoot-consulting/p2pbbs/bbsdht"
+	"github.com/rightfoot-consulting/p2pbbs/chatv2"
+	"github.com/rightfoot-consulting/p2pbbs/profile"
+	"github.com/rightfoot-consulting/p2pbbs/sanitize"
+	"github.com/spf13/cobra"
+)
+
+// profileTimeout bounds how long the profile commands wait for the DHT.
+const profileTimeout = 2 * time.Minute
+
+// profileCmd groups the commands that manage signed user profiles
+var profileCmd = &cobra.Command{
+	Use:   "profile",
+	Short: "Publish and look up signed user profiles",
+	Long: `Profiles describe a peer with a nick, bio, contact and avatar hash.  They are signed with the
+peer's key and stored in the DHT under /bbsprofile/<peer id>.`,
+}
+
+// profileSetCmd represents the profile set command
+var profileSetCmd = &cobra.Command{
+	Use:   "set",
+	Short: "Sign and publish the profile of a static identity",
+	Long: `Publishes a profile for the identity in --keyfile.  Fields that aren't given keep the value of the
+currently published profile. For example:
+
+			profile set --keyfile alice.key --config chatconfig.json --nick alice --bio "Sysop"
+		.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println("profile set called")
+		node, cancel := startProfileNode(cmd)
+		defer cancel()
+		defer node.Close()
+		if node.Config.KeyFile == "" {
+			panic(fmt.Errorf("profiles need a static identity, use --keyfile"))
+		}
+
+		ctx, cancelLookup := context.WithTimeout(context.Background(), profileTimeout)
+		defer cancelLookup()
+		current, err := node.Profiles.Lookup(ctx, node.Host.ID())
+		if err != nil {
+			current = &profile.Profile{}
+		}
+		for _, field := range []struct {
+			flag  string
+			value *string
+		}{
+			{"nick", &current.Nick},
+			{"bio", &current.Bio},
+			{"contact", &current.Contact},
+		} {
+			if cmd.Flags().Changed(field.flag) {
+				*field.value, err = cmd.Flags().GetString(field.flag)
+				if err != nil {
+					panic(err)
+				}
+			}
+		}
+		if cmd.Flags().Changed("avatar") {
+			avatarFile, err := cmd.Flags().GetString("avatar")
+			if err != nil {
+				panic(err)
+			}
+			current.AvatarHash, err = hashAvatar(avatarFile)
+			if err != nil {
+				panic(err)
+			}
+		}
+		if current.Nick == "" {
+			current.Nick = node.Nick()
+		}
+
+		err = node.Profiles.Publish(ctx, node.PrivateKey(), current)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("Published profile for %s\n", node.Host.ID())
+		printProfile(current)
+	},
+}
+
+// profileShowCmd represents the profile show command
+var profileShowCmd = &cobra.Command{
+	Use:   "show <peer id>",
+	Short: "Look up and verify the profile of a peer",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println("profile show called")
+		id, err := peer.Decode(args[0])
+		if err != nil {
+			panic(err)
+		}
+		node, cancel := startProfileNode(cmd)
+		defer cancel()
+		defer node.Close()
+
+		ctx, cancelLookup := context.WithTimeout(context.Background(), profileTimeout)
+		defer cancelLookup()
+		p, err := node.Profiles.Lookup(ctx, id)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "No profile found for %s: %v\n", id, err)
+			os.Exit(1)
+		}
+		printProfile(p)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(profileCmd)
+	profileCmd.AddCommand(profileSetCmd)
+	profileCmd.AddCommand(profileShowCmd)
+	addChatV2Flags(profileSetCmd)
+	addChatV2Flags(profileShowCmd)
+	profileSetCmd.Flags().StringP("nick", "n", "", "Nickname to publish")
+	profileSetCmd.Flags().String("bio", "", "A short description of yourself")
+	profileSetCmd.Flags().String("contact", "", "How to reach you outside of p2pbbs")
+	profileSetCmd.Flags().String("avatar", "", "An image file, only its hash is published")
+}
+
+// startProfileNode starts a chatv2 node from the command flags and waits until the DHT has peers.
+func startProfileNode(cmd *cobra.Command) (node *chatv2.ChatV2Node, cancel context.CancelFunc) {
+	config, err := loadChatV2Config(cmd)
+	if err != nil {
+		panic(err)
+	}
+	node, err = chatv2.NewChatV2Node(config)
+	if err != nil {
+		panic(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	if err = node.Start(ctx); err != nil {
+		panic(err)
+	}
+	waitCtx, cancelWait := context.WithTimeout(ctx, profileTimeout)
+	defer cancelWait()
+	if err = bbsdht.WaitForPeers(waitCtx, node.DHT); err != nil {
+		panic(err)
+	}
+	return
+}
+
+// hashAvatar returns the base58 multihash style sha256 of an image file.
+func hashAvatar(file string) (string, error) {
+	contents, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(contents)
+	// 0x12 0x20 is the multihash prefix of a 32 byte sha2-256 digest
+	return base58.Encode(append([]byte{0x12, 0x20}, sum[:]...)), nil
+}
+
+// printProfile prints a profile, which may be a peer's, cleaned for the terminal.
+func printProfile(p *profile.Profile) {
+	fmt.Printf("Id:       %s\n", sanitize.Line(p.PeerID))
+	fmt.Printf("Nick:     %s\n", sanitize.Line(p.Nick))
+	if p.Bio != "" {
+		fmt.Printf("Bio:      %s\n", sanitize.Text(p.Bio))
+	}
+	if p.Contact != "" {
+		fmt.Printf("Contact:  %s\n", sanitize.Line(p.Contact))
+	}
+	if p.AvatarHash != "" {
+		fmt.Printf("Avatar:   %s\n", sanitize.Line(p.AvatarHash))
+	}
+	fmt.Printf("Updated:  %s\n", p.UpdatedAt.Local().Format(time.RFC1123))
+}
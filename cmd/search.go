@@ -0,0 +1,113 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/rightfoot-consulting/p2pbbs/sanitize"
+	"github.com/rightfoot-consulting/p2pbbs/search"
+	"github.com/spf13/cobra"
+)
+
+// searchCmd represents the search command
+var searchCmd = &cobra.Command{
+	Use:   "search <query>",
+	Short: "Search the posts and chat messages this node has seen",
+	Long: `Searches the index of posts and chat messages kept in the data directory, without joining the
+network.  Chat messages are indexed while chatv2 is in their room.  Words must all appear,
+quoted words must appear together and words starting with - must not.  author: takes a nick or
+peer id, room: and board: limit the search to chat rooms or boards, after: and before: take a
+date or how long ago.  Results are ranked, the newest first when the query has no words. For
+example:
+
+			search relay "private swarm" -tor
+			Finds what mentions relay and private swarm but not tor
+
+			search author:alice board:general after:2024-03-01 before:7d
+			Lists alice's posts on general from March 2024 until a week ago
+
+			search --rebuild
+			Indexes the stored posts afresh, dropping those that were deleted
+		.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println("search called")
+		config, err := loadChatV2Config(cmd)
+		if err != nil {
+			panic(err)
+		}
+		limit, err := cmd.Flags().GetInt("limit")
+		if err != nil {
+			panic(err)
+		}
+		rebuild, err := cmd.Flags().GetBool("rebuild")
+		if err != nil {
+			panic(err)
+		}
+		dataDir, err := config.DataDirectory()
+		if err != nil {
+			panic(err)
+		}
+		store := openBoardStore(context.Background(), config, true)
+		ix, err := search.Open(filepath.Join(dataDir, search.IndexFile))
+		if err != nil {
+			panic(err)
+		}
+		ix.Moderate(store)
+		if rebuild {
+			count, err := ix.Rebuild(store)
+			if err != nil {
+				panic(err)
+			}
+			fmt.Printf("Indexed %d posts and messages\n", count)
+		} else if _, err = ix.AddPosts(store); err != nil {
+			panic(err)
+		}
+		if len(args) == 0 {
+			if !rebuild {
+				panic(fmt.Errorf("nothing to search for"))
+			}
+			return
+		}
+		q, err := search.ParseQuery(queryFromArgs(args), time.Now())
+		if err != nil {
+			panic(err)
+		}
+		results := ix.Search(q, limit)
+		for _, r := range results {
+			fmt.Println(sanitize.Line(r.Line(72)))
+		}
+		fmt.Printf("%d results\n", len(results))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(searchCmd)
+	addChatV2Flags(searchCmd)
+	searchCmd.Flags().IntP("limit", "l", 20, "How many results to show, 0 for all")
+	searchCmd.Flags().Bool("rebuild", false, "Index the stored posts afresh before searching")
+}
+
+// queryFromArgs joins the arguments of search into a query, quoting again the phrases and
+// filter values the shell took the quotes off.
+func queryFromArgs(args []string) string {
+	fields := make([]string, len(args))
+	for i, arg := range args {
+		fields[i] = arg
+		if !strings.ContainsFunc(arg, unicode.IsSpace) || strings.Contains(arg, `"`) {
+			continue
+		}
+		if key, value, ok := strings.Cut(arg, ":"); ok && !strings.ContainsFunc(key, unicode.IsSpace) {
+			fields[i] = fmt.Sprintf(`%s:"%s"`, key, value)
+		} else {
+			fields[i] = `"` + arg + `"`
+		}
+	}
+	return strings.Join(fields, " ")
+}
@@ -0,0 +1,111 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/rightfoot-consulting/p2pbbs/boards"
+	"github.com/rightfoot-consulting/p2pbbs/chatv2"
+	"github.com/rightfoot-consulting/p2pbbs/gopherbbs"
+	"github.com/spf13/cobra"
+)
+
+// serveGopherCmd represents the serve-gopher command
+var serveGopherCmd = &cobra.Command{
+	Use:   "serve-gopher",
+	Short: "Serve the boards read only over gopher",
+	Long: `Starts a node and a gopher server (RFC 1436) publishing the boards it holds: boards are
+directories, threads are menus and posts are text files.  Selectors use post content ids, so
+links stay valid.  Gopher+ clients can ask for item attributes. For example:
+
+			serve-gopher --config chatconfig.json --listen :7070 --host bbs.example.org
+			Serves the boards on port 7070, with menus linking back to bbs.example.org
+
+			serve-gopher --offline
+			Serves the posts already in the data directory without joining the network
+		.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println("serve-gopher called")
+		config, err := loadChatV2Config(cmd)
+		if err != nil {
+			panic(err)
+		}
+		address, err := cmd.Flags().GetString("listen")
+		if err != nil {
+			panic(err)
+		}
+		host, err := cmd.Flags().GetString("host")
+		if err != nil {
+			panic(err)
+		}
+		port, err := cmd.Flags().GetInt("port")
+		if err != nil {
+			panic(err)
+		}
+		plus, err := cmd.Flags().GetBool("gopher-plus")
+		if err != nil {
+			panic(err)
+		}
+		offline, err := cmd.Flags().GetBool("offline")
+		if err != nil {
+			panic(err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		store := openBoardStore(ctx, config, offline)
+		server := gopherbbs.NewServer(store, host, port, plus)
+		go func() {
+			if err := server.ListenAndServe(ctx, address); err != nil {
+				fmt.Fprintf(os.Stderr, "gopher server stopped: %v\n", err)
+				cancel()
+			}
+		}()
+		fmt.Printf("Serving the boards over gopher on %s\n", address)
+
+		waitForShutdown(ctx)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(serveGopherCmd)
+	addChatV2Flags(serveGopherCmd)
+	serveGopherCmd.Flags().StringP("listen", "l", gopherbbs.DefaultAddress, "Address to accept gopher connections on")
+	serveGopherCmd.Flags().String("host", "localhost", "Host name clients use to reach the server, put in menus")
+	serveGopherCmd.Flags().Int("port", 0, "Port clients use to reach the server, put in menus (default the listening port)")
+	serveGopherCmd.Flags().Bool("gopher-plus", false, "Mark menu items as Gopher+ items")
+	serveGopherCmd.Flags().Bool("offline", false, "Serve the stored posts without starting a node")
+}
+
+// openBoardStore returns the post store of the data directory, kept up to date by a running
+// node unless offline is set.  The node stops when ctx ends.
+func openBoardStore(ctx context.Context, config *chatv2.ChatV2Config, offline bool) *boards.Store {
+	if offline {
+		dataDir, err := config.DataDirectory()
+		if err != nil {
+			panic(err)
+		}
+		store, err := boards.OpenStore(filepath.Join(dataDir, boards.StoreDir))
+		if err != nil {
+			panic(err)
+		}
+		return store
+	}
+	node, err := chatv2.NewChatV2Node(config)
+	if err != nil {
+		panic(err)
+	}
+	if err = node.Start(ctx); err != nil {
+		panic(err)
+	}
+	go func() {
+		<-ctx.Done()
+		node.Close()
+	}()
+	return node.Boards.Store()
+}
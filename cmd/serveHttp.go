@@ -0,0 +1,93 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/rightfoot-consulting/p2pbbs/archive"
+	"github.com/rightfoot-consulting/p2pbbs/boards"
+	"github.com/rightfoot-consulting/p2pbbs/httpbbs"
+	"github.com/spf13/cobra"
+)
+
+// serveHttpCmd represents the serve-http command
+var serveHttpCmd = &cobra.Command{
+	Use:   "serve-http",
+	Short: "Serve the boards read only over HTTP with Atom and RSS feeds",
+	Long: `Starts a node and an HTTP server publishing the boards it holds as HTML pages, with an Atom
+and an RSS feed for every board and thread.  Posts have permalinks built from their content ids,
+/post/<id>.html, so links stay valid.  The same posts are served signed as JSON under /api/, so
+a mirror can check them.  The pages are the ones 'export html' writes. For example:
+
+			serve-http --config chatconfig.json --board announcements --base-url https://bbs.example.org/
+			Serves only the announcements board on 127.0.0.1:8080, its feeds linking to bbs.example.org
+
+			serve-http --offline --listen :8080
+			Serves the posts already in the data directory on every interface without joining the network
+		.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println("serve-http called")
+		config, err := loadChatV2Config(cmd)
+		if err != nil {
+			panic(err)
+		}
+		address, err := cmd.Flags().GetString("listen")
+		if err != nil {
+			panic(err)
+		}
+		offline, err := cmd.Flags().GetBool("offline")
+		if err != nil {
+			panic(err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		store := openBoardStore(ctx, config, offline)
+		server := httpbbs.NewServer(store, archiveSite(cmd, store))
+		go func() {
+			if err := server.ListenAndServe(ctx, address); err != nil {
+				fmt.Fprintf(os.Stderr, "http server stopped: %v\n", err)
+				cancel()
+			}
+		}()
+		fmt.Printf("Serving the boards over HTTP on %s\n", address)
+
+		waitForShutdown(ctx)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(serveHttpCmd)
+	addChatV2Flags(serveHttpCmd)
+	addArchiveFlags(serveHttpCmd)
+	serveHttpCmd.Flags().StringP("listen", "l", httpbbs.DefaultAddress, "Address to accept HTTP connections on")
+	serveHttpCmd.Flags().Bool("offline", false, "Serve the stored posts without starting a node")
+}
+
+// archiveSite returns the site described by the flags added by addArchiveFlags.
+func archiveSite(cmd *cobra.Command, store *boards.Store) *archive.Site {
+	title, err := cmd.Flags().GetString("title")
+	if err != nil {
+		panic(err)
+	}
+	baseURL, err := cmd.Flags().GetString("base-url")
+	if err != nil {
+		panic(err)
+	}
+	only, err := cmd.Flags().GetStringArray("board")
+	if err != nil {
+		panic(err)
+	}
+	return archive.NewSite(store, title, baseURL).Limit(only...)
+}
+
+// addArchiveFlags adds the flags shaping the pages of the board archive.
+func addArchiveFlags(cmd *cobra.Command) {
+	cmd.Flags().String("title", "p2pbbs", "Title of the archive")
+	cmd.Flags().String("base-url", "", "Absolute URL of the archive, used in the feeds (default relative links)")
+	cmd.Flags().StringArray("board", nil, "Only publish this board, may be repeated (default every board)")
+}
@@ -0,0 +1,66 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/rightfoot-consulting/p2pbbs/chatv2"
+	"github.com/rightfoot-consulting/p2pbbs/ircbbs"
+	"github.com/spf13/cobra"
+)
+
+// serveIrcCmd represents the serve-irc command
+var serveIrcCmd = &cobra.Command{
+	Use:   "serve-irc",
+	Short: "Bridge IRC clients into the chat rooms",
+	Long: `Starts a node and an IRC server whose channels are the chatv2 rooms, #general being the room
+general.  Private messages to remote peers are sent as p2p direct messages, and NAMES lists the
+peers in a room under their p2p nicks.  Clients speak for the node without a password, so the
+server only listens on the loopback interface. For example:
+
+			serve-irc --config chatconfig.json
+			Serves IRC on 127.0.0.1:6667, connect with '/server localhost 6667' and '/join #general'
+		.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println("serve-irc called")
+		config, err := loadChatV2Config(cmd)
+		if err != nil {
+			panic(err)
+		}
+		address, err := cmd.Flags().GetString("listen")
+		if err != nil {
+			panic(err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		node, err := chatv2.NewChatV2Node(config)
+		if err != nil {
+			panic(err)
+		}
+		if err = node.Start(ctx); err != nil {
+			panic(err)
+		}
+		defer node.Close()
+		server := ircbbs.NewServer(node)
+		go func() {
+			if err := server.ListenAndServe(ctx, address); err != nil {
+				fmt.Fprintf(os.Stderr, "irc server stopped: %v\n", err)
+				cancel()
+			}
+		}()
+		fmt.Printf("Serving IRC on %s as %s\n", address, node.Host.ID())
+
+		waitForShutdown(ctx)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(serveIrcCmd)
+	addChatV2Flags(serveIrcCmd)
+	serveIrcCmd.Flags().StringP("listen", "l", ircbbs.DefaultAddress, "Loopback address to accept IRC connections on")
+}
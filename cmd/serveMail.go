@@ -0,0 +1,90 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/rightfoot-consulting/p2pbbs/chatv2"
+	"github.com/rightfoot-consulting/p2pbbs/mailbbs"
+	"github.com/spf13/cobra"
+)
+
+// serveMailCmd represents the serve-mail command
+var serveMailCmd = &cobra.Command{
+	Use:   "serve-mail",
+	Short: "Send and read direct messages with a mail client",
+	Long: `Starts a node with an SMTP submission server and a POP3 server so an ordinary mail client
+can be used as the node's direct message inbox.  Mail to <peer id>@p2pbbs.local, or to
+<petname>@p2pbbs.local, is sent as a direct message sealed so only that peer can read it, the
+subject becoming the first line.  The POP3 server offers the direct messages the node has
+received, and messages deleted there are only hidden from POP3.  Both servers speak for the
+node, and only POP3 asks for a password, so they only listen on the loopback interface. For example:
+
+			serve-mail --config chatconfig.json --password hunter2
+			Accepts mail on 127.0.0.1:2525 and serves the inbox on 127.0.0.1:1110
+		.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println("serve-mail called")
+		config, err := loadChatV2Config(cmd)
+		if err != nil {
+			panic(err)
+		}
+		smtpAddress, err := cmd.Flags().GetString("smtp")
+		if err != nil {
+			panic(err)
+		}
+		pop3Address, err := cmd.Flags().GetString("pop3")
+		if err != nil {
+			panic(err)
+		}
+		password, err := cmd.Flags().GetString("password")
+		if err != nil {
+			panic(err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		node, err := chatv2.NewChatV2Node(config)
+		if err != nil {
+			panic(err)
+		}
+		if err = node.Start(ctx); err != nil {
+			panic(err)
+		}
+		defer node.Close()
+		deleted, err := mailbbs.LoadDeleted(filepath.Join(node.DataDir, mailbbs.DeletedFile))
+		if err != nil {
+			panic(err)
+		}
+		smtpServer := mailbbs.NewSMTPServer(node.DMs, node.Names, node.PrivateKey(), node.Nick())
+		pop3Server := mailbbs.NewPOP3Server(node.DMs.Mailbox(), deleted, node.Host.ID(), password)
+		go func() {
+			if err := smtpServer.ListenAndServe(ctx, smtpAddress); err != nil {
+				fmt.Fprintf(os.Stderr, "smtp server stopped: %v\n", err)
+				cancel()
+			}
+		}()
+		go func() {
+			if err := pop3Server.ListenAndServe(ctx, pop3Address); err != nil {
+				fmt.Fprintf(os.Stderr, "pop3 server stopped: %v\n", err)
+				cancel()
+			}
+		}()
+		fmt.Printf("Accepting mail on %s and serving the inbox over POP3 on %s as %s\n", smtpAddress, pop3Address, node.Host.ID())
+
+		waitForShutdown(ctx)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(serveMailCmd)
+	addChatV2Flags(serveMailCmd)
+	serveMailCmd.Flags().String("smtp", mailbbs.DefaultSMTPAddress, "Loopback address to accept mail for the network on")
+	serveMailCmd.Flags().String("pop3", mailbbs.DefaultPOP3Address, "Loopback address to serve the inbox over POP3 on")
+	serveMailCmd.Flags().String("password", "", "Password POP3 clients must give, any password is accepted when empty")
+}
@@ -0,0 +1,71 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/rightfoot-consulting/p2pbbs/chatv2"
+	"github.com/rightfoot-consulting/p2pbbs/nntpbbs"
+	"github.com/spf13/cobra"
+)
+
+// serveNntpCmd represents the serve-nntp command
+var serveNntpCmd = &cobra.Command{
+	Use:   "serve-nntp",
+	Short: "Serve the boards to newsreaders over NNTP",
+	Long: `Starts a node and an NNTP server presenting every board as a newsgroup, general as
+p2pbbs.general.  Message-IDs are built from post content ids and References from reply links.
+Articles posted from a newsreader are signed with the node's identity and published to the
+network without it logging in, so the server only listens on the loopback interface. For example:
+
+			serve-nntp --config chatconfig.json
+			Serves the boards on 127.0.0.1:1119, point the newsreader at news://localhost:1119
+		.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println("serve-nntp called")
+		config, err := loadChatV2Config(cmd)
+		if err != nil {
+			panic(err)
+		}
+		address, err := cmd.Flags().GetString("listen")
+		if err != nil {
+			panic(err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		node, err := chatv2.NewChatV2Node(config)
+		if err != nil {
+			panic(err)
+		}
+		if err = node.Start(ctx); err != nil {
+			panic(err)
+		}
+		defer node.Close()
+		numbers, err := nntpbbs.LoadNumbers(filepath.Join(node.DataDir, nntpbbs.NumbersFile))
+		if err != nil {
+			panic(err)
+		}
+		server := nntpbbs.NewServer(node.Boards, numbers, node.PrivateKey(), node.Nick())
+		go func() {
+			if err := server.ListenAndServe(ctx, address); err != nil {
+				fmt.Fprintf(os.Stderr, "nntp server stopped: %v\n", err)
+				cancel()
+			}
+		}()
+		fmt.Printf("Serving the boards over NNTP on %s as %s\n", address, node.Host.ID())
+
+		waitForShutdown(ctx)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(serveNntpCmd)
+	addChatV2Flags(serveNntpCmd)
+	serveNntpCmd.Flags().StringP("listen", "l", nntpbbs.DefaultAddress, "Loopback address to accept newsreader connections on")
+}
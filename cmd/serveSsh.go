@@ -0,0 +1,116 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/rightfoot-consulting/p2pbbs/accounts"
+	"github.com/rightfoot-consulting/p2pbbs/chatv2"
+	"github.com/rightfoot-consulting/p2pbbs/sshbbs"
+	"github.com/spf13/cobra"
+)
+
+// serveSshCmd represents the serve-ssh command
+var serveSshCmd = &cobra.Command{
+	Use:   "serve-ssh",
+	Short: "Serve the BBS to local accounts over SSH",
+	Long: `Starts a node and an SSH server presenting a BBS menu of boards, threads, chat rooms and direct
+messages.  Users log in with an SSH key authorized for a local account, see the account command,
+and share the node. For example:
+
+			serve-ssh --config chatconfig.json --listen :2222
+			Serves the BBS on port 2222, users connect with 'ssh -p 2222 host'
+		.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println("serve-ssh called")
+		config, err := loadChatV2Config(cmd)
+		if err != nil {
+			panic(err)
+		}
+		address, err := cmd.Flags().GetString("listen")
+		if err != nil {
+			panic(err)
+		}
+		hostKeyFile, err := cmd.Flags().GetString("host-key")
+		if err != nil {
+			panic(err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		node, store := startFrontEndNode(ctx, config)
+		defer node.Close()
+		if hostKeyFile == "" {
+			hostKeyFile = filepath.Join(node.DataDir, sshbbs.HostKeyFile)
+		}
+		hostKey, err := sshbbs.LoadHostKey(hostKeyFile)
+		if err != nil {
+			panic(err)
+		}
+		server := sshbbs.NewServer(node, store, hostKey)
+		go func() {
+			if err := server.ListenAndServe(ctx, address); err != nil {
+				fmt.Fprintf(os.Stderr, "ssh server stopped: %v\n", err)
+				cancel()
+			}
+		}()
+		fmt.Printf("Serving the BBS over SSH on %s as %s\n", address, node.Host.ID())
+
+		waitForShutdown(ctx)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(serveSshCmd)
+	addChatV2Flags(serveSshCmd)
+	serveSshCmd.Flags().StringP("listen", "l", sshbbs.DefaultAddress, "Address to accept SSH connections on")
+	serveSshCmd.Flags().String("host-key", "", "SSH host key file, generated if missing (default '"+sshbbs.HostKeyFile+"' in the data directory)")
+}
+
+// startFrontEndNode starts the node shared by the users of a front end and loads its local
+// accounts.
+func startFrontEndNode(ctx context.Context, config *chatv2.ChatV2Config) (node *chatv2.ChatV2Node, store *accounts.Store) {
+	node, err := chatv2.NewChatV2Node(config)
+	if err != nil {
+		panic(err)
+	}
+	if err = node.Start(ctx); err != nil {
+		panic(err)
+	}
+	store, err = accounts.LoadStore(filepath.Join(node.DataDir, accounts.StoreFile))
+	if err != nil {
+		panic(err)
+	}
+	if len(store.All()) == 0 {
+		fmt.Println("There are no local accounts yet, add one with 'p2pbbs account add'")
+	}
+	// receive direct messages for every account, not just the ones logged in
+	for _, account := range store.All() {
+		privateKey, _, err := account.Identity()
+		if err != nil {
+			panic(err)
+		}
+		if err = node.DMs.Listen(privateKey); err != nil {
+			panic(err)
+		}
+	}
+	return
+}
+
+// waitForShutdown blocks until a SIGINT or SIGTERM signal arrives or ctx ends.
+func waitForShutdown(ctx context.Context) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case <-ch:
+		fmt.Println("Received signal, shutting down...")
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,70 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/rightfoot-consulting/p2pbbs/telnetbbs"
+	"github.com/spf13/cobra"
+)
+
+// serveTelnetCmd represents the serve-telnet command
+var serveTelnetCmd = &cobra.Command{
+	Use:   "serve-telnet",
+	Short: "Serve the BBS to local accounts over telnet",
+	Long: `Starts a node and a telnet server presenting the BBS menu in ANSI colour, drawn with CP437 box
+characters for retro terminals or UTF-8 for modern ones.  Users log in with the name and password
+of a local account, see 'account passwd'.  Telnet is not encrypted. For example:
+
+			serve-telnet --config chatconfig.json --listen :2323
+			Serves the BBS on port 2323 in CP437, users connect with 'telnet host 2323'
+
+			serve-telnet --charset utf-8
+			Serves the BBS to terminals expecting UTF-8
+		.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println("serve-telnet called")
+		config, err := loadChatV2Config(cmd)
+		if err != nil {
+			panic(err)
+		}
+		address, err := cmd.Flags().GetString("listen")
+		if err != nil {
+			panic(err)
+		}
+		charsetParam, err := cmd.Flags().GetString("charset")
+		if err != nil {
+			panic(err)
+		}
+		charset, err := telnetbbs.ParseCharset(charsetParam)
+		if err != nil {
+			panic(err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		node, store := startFrontEndNode(ctx, config)
+		defer node.Close()
+		server := telnetbbs.NewServer(node, store, charset)
+		go func() {
+			if err := server.ListenAndServe(ctx, address); err != nil {
+				fmt.Fprintf(os.Stderr, "telnet server stopped: %v\n", err)
+				cancel()
+			}
+		}()
+		fmt.Printf("Serving the BBS over telnet on %s as %s\n", address, node.Host.ID())
+
+		waitForShutdown(ctx)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(serveTelnetCmd)
+	addChatV2Flags(serveTelnetCmd)
+	serveTelnetCmd.Flags().StringP("listen", "l", telnetbbs.DefaultAddress, "Address to accept telnet connections on")
+	serveTelnetCmd.Flags().String("charset", string(telnetbbs.CP437), "Character set of the terminals: 'cp437' or 'utf-8'")
+}
@@ -0,0 +1,71 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/rightfoot-consulting/p2pbbs/chatv2"
+	"github.com/rightfoot-consulting/p2pbbs/webui"
+	"github.com/spf13/cobra"
+)
+
+// serveWebCmd represents the serve-web command
+var serveWebCmd = &cobra.Command{
+	Use:   "serve-web",
+	Short: "Use the node from a web browser",
+	Long: `Starts a node and a web UI for it on the loopback interface: chat rooms with the same slash
+commands as chatv2, the board threads, direct messages, the peers in each room and their
+profiles.  The browser talks to the node over a WebSocket and the node signs and checks every
+message, exactly as in the terminal.  A new token is made every time the node starts and only
+the link printed at startup lets a browser in. For example:
+
+			serve-web --config chatconfig.json
+			Prints a link like http://127.0.0.1:8686/?token=... to open in a browser
+		.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println("serve-web called")
+		config, err := loadChatV2Config(cmd)
+		if err != nil {
+			panic(err)
+		}
+		address, err := cmd.Flags().GetString("listen")
+		if err != nil {
+			panic(err)
+		}
+		token, err := webui.NewToken()
+		if err != nil {
+			panic(err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		node, err := chatv2.NewChatV2Node(config)
+		if err != nil {
+			panic(err)
+		}
+		if err = node.Start(ctx); err != nil {
+			panic(err)
+		}
+		defer node.Close()
+		server := webui.NewServer(node, token)
+		go func() {
+			if err := server.ListenAndServe(ctx, address); err != nil {
+				fmt.Fprintf(os.Stderr, "web server stopped: %v\n", err)
+				cancel()
+			}
+		}()
+		fmt.Printf("Serving the web UI for %s, open %s\n", node.Host.ID(), webui.URL(address, token))
+
+		waitForShutdown(ctx)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(serveWebCmd)
+	addChatV2Flags(serveWebCmd)
+	serveWebCmd.Flags().StringP("listen", "l", webui.DefaultAddress, "Loopback address to serve the web UI on")
+}
@@ -0,0 +1,140 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/rightfoot-consulting/p2pbbs/bbscrypto"
+	"github.com/rightfoot-consulting/p2pbbs/sanitize"
+	"github.com/rightfoot-consulting/p2pbbs/transcript"
+	"github.com/spf13/cobra"
+)
+
+// transcriptCmd groups the commands that read the chat transcripts
+var transcriptCmd = &cobra.Command{
+	Use:   "transcript",
+	Short: "List and export the transcripts of chat rooms",
+	Long: `chat and chatv2 keep a transcript of every room they are in, in the transcripts directory of the
+data directory, and chatv2 shows the end of it when a room is joined.  The transcripts section of
+the configuration turns them off with "disabled", seals them to the key file with "encrypt" and
+sets "max_file_bytes", "max_files" and how many messages to "replay".  Transcripts of private
+rooms are always sealed, reading sealed transcripts needs the --keyfile they were written with.`,
+}
+
+// transcriptListCmd represents the transcript list command
+var transcriptListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the rooms with a transcript",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println("transcript list called")
+		store := openTranscripts(cmd)
+		rooms, err := store.Rooms()
+		if err != nil {
+			panic(err)
+		}
+		for _, room := range rooms {
+			fmt.Println(sanitize.Line(room))
+		}
+	},
+}
+
+// transcriptExportCmd represents the transcript export command
+var transcriptExportCmd = &cobra.Command{
+	Use:   "export <room>",
+	Short: "Write out the transcript of a room as text, JSON or markdown",
+	Long: `Writes every message kept in the transcript of a room, named as transcript list names it, as
+its edits left it. Deleted messages, reactions and the edits themselves are left out. For
+example:
+
+			transcript export ops --format markdown --output ops.md
+			Writes the transcript of the room ops as a markdown document
+
+			transcript export private:ops --keyfile alice.key --format json
+			Prints the transcript of alice's private room ops as JSON
+		.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Fprintln(os.Stderr, "transcript export called")
+		format, err := cmd.Flags().GetString("format")
+		if err != nil {
+			panic(err)
+		}
+		output, err := cmd.Flags().GetString("output")
+		if err != nil {
+			panic(err)
+		}
+		if !slices.Contains(transcript.Formats, format) {
+			panic(fmt.Errorf("unknown format %s, use one of %s", format, strings.Join(transcript.Formats, ", ")))
+		}
+		store := openTranscripts(cmd)
+		log, err := store.Find(args[0])
+		if errors.Is(err, os.ErrNotExist) {
+			panic(fmt.Errorf("no transcript of %s, see transcript list", args[0]))
+		}
+		if err != nil {
+			panic(err)
+		}
+		entries, err := log.Entries()
+		if err != nil {
+			panic(err)
+		}
+		var w io.Writer = os.Stdout
+		if output != "" {
+			f, err := os.OpenFile(output, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+			if err != nil {
+				panic(err)
+			}
+			defer f.Close()
+			w = f
+		}
+		if err = transcript.Export(w, args[0], transcript.Resolve(entries), format, time.Local); err != nil {
+			panic(err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(transcriptCmd)
+	transcriptCmds := []*cobra.Command{transcriptListCmd, transcriptExportCmd}
+	transcriptCmd.AddCommand(transcriptCmds...)
+	for _, cmd := range transcriptCmds {
+		addChatV2Flags(cmd)
+	}
+	transcriptExportCmd.Flags().StringP("format", "f", "text", "The format to write: "+strings.Join(transcript.Formats, ", "))
+	transcriptExportCmd.Flags().StringP("output", "o", "", "A file to write the transcript to instead of stdout")
+}
+
+// openTranscripts opens the transcripts in the data directory, with the identity in --keyfile
+// to read sealed ones when given.
+func openTranscripts(cmd *cobra.Command) *transcript.Store {
+	config, err := loadChatV2Config(cmd)
+	if err != nil {
+		panic(err)
+	}
+	var privateKey crypto.PrivKey
+	if config.KeyFile != "" {
+		if privateKey, err = bbscrypto.LoadPrivateKey(config.KeyFile); err != nil {
+			panic(err)
+		}
+	}
+	dataDir, err := config.DataDirectory()
+	if err != nil {
+		panic(err)
+	}
+	store, err := transcript.OpenStore(filepath.Join(dataDir, transcript.Dir), config.TranscriptConfig(), privateKey)
+	if err != nil {
+		panic(err)
+	}
+	return store
+}
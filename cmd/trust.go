@@ -0,0 +1,170 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/rightfoot-consulting/p2pbbs/bbscrypto"
+	"github.com/rightfoot-consulting/p2pbbs/sanitize"
+	"github.com/rightfoot-consulting/p2pbbs/trust"
+	"github.com/spf13/cobra"
+)
+
+// trustCmd groups the commands that manage web of trust endorsements
+var trustCmd = &cobra.Command{
+	Use:   "trust",
+	Short: "Vouch for the peer ids of people you know",
+	Long: `Endorsements are signed statements that a peer id belongs to the person using a nick.  They are
+stored in the data directory and exchanged with peers when chatting, so trust can be extended
+through people you fully trust.`,
+}
+
+// trustSignCmd represents the trust sign command
+var trustSignCmd = &cobra.Command{
+	Use:   "sign <peer id>",
+	Short: "Endorse a peer id",
+	Long: `Signs an endorsement of a peer id with the identity in --keyfile. For example:
+
+			trust sign --keyfile alice.key --nick bob --level full 12D3KooW...
+			Vouches that 12D3KooW... is bob and that bob's own endorsements can be trusted
+		.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println("trust sign called")
+		nick, err := cmd.Flags().GetString("nick")
+		if err != nil {
+			panic(err)
+		}
+		levelParam, err := cmd.Flags().GetString("level")
+		if err != nil {
+			panic(err)
+		}
+		level, err := trust.ParseLevel(levelParam)
+		if err != nil {
+			panic(err)
+		}
+		if level == trust.Revoked {
+			panic(fmt.Errorf("use 'trust revoke' to revoke an endorsement"))
+		}
+		endorse(cmd, args[0], nick, level)
+	},
+}
+
+// trustRevokeCmd represents the trust revoke command
+var trustRevokeCmd = &cobra.Command{
+	Use:   "revoke <peer id>",
+	Short: "Revoke trust in a peer id",
+	Long: `Signs a revocation that replaces any earlier endorsement of the peer id by the identity in --keyfile.
+Peers that fully trust you will treat the peer id as revoked as well.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println("trust revoke called")
+		endorse(cmd, args[0], "", trust.Revoked)
+	},
+}
+
+// trustShowCmd represents the trust show command
+var trustShowCmd = &cobra.Command{
+	Use:   "show [peer id]",
+	Short: "List endorsements, or show how a peer id is trusted",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println("trust show called")
+		store, privateKey := loadTrustStore(cmd)
+		if len(args) == 0 {
+			for _, e := range store.All() {
+				printEndorsement(e)
+			}
+			return
+		}
+		target, err := peer.Decode(args[0])
+		if err != nil {
+			panic(err)
+		}
+		self, err := peer.IDFromPrivateKey(privateKey)
+		if err != nil {
+			panic(err)
+		}
+		verdict := store.Evaluate(self, target)
+		fmt.Printf("%s is %s\n", target, verdict.Status)
+		if verdict.Status == trust.Trusted {
+			fmt.Printf("Vouched for as: %s\n", sanitize.Line(verdict.Nick))
+			fmt.Println("Path:")
+			for _, hop := range verdict.Path {
+				fmt.Printf("\t%s\n", hop)
+			}
+		}
+		fmt.Println("Endorsements:")
+		for _, e := range store.About(target) {
+			printEndorsement(e)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(trustCmd)
+	trustCmd.AddCommand(trustSignCmd)
+	trustCmd.AddCommand(trustRevokeCmd)
+	trustCmd.AddCommand(trustShowCmd)
+	for _, cmd := range []*cobra.Command{trustSignCmd, trustRevokeCmd, trustShowCmd} {
+		addChatV2Flags(cmd)
+	}
+	trustSignCmd.Flags().StringP("nick", "n", "", "The nick of the person the peer id belongs to")
+	trustSignCmd.Flags().StringP("level", "l", "marginal", "How much to trust the peer: 'marginal' vouches for the identity only, 'full' also trusts its endorsements")
+}
+
+// loadTrustStore opens the endorsement store in the data directory and returns it with the
+// identity in --keyfile.
+func loadTrustStore(cmd *cobra.Command) (store *trust.Store, privateKey crypto.PrivKey) {
+	config, err := loadChatV2Config(cmd)
+	if err != nil {
+		panic(err)
+	}
+	if config.KeyFile == "" {
+		panic(fmt.Errorf("the web of trust needs a static identity, use --keyfile"))
+	}
+	privateKey, err = bbscrypto.LoadPrivateKey(config.KeyFile)
+	if err != nil {
+		panic(err)
+	}
+	dataDir, err := config.DataDirectory()
+	if err != nil {
+		panic(err)
+	}
+	store, err = trust.LoadStore(filepath.Join(dataDir, trust.StoreFile))
+	if err != nil {
+		panic(err)
+	}
+	return
+}
+
+func endorse(cmd *cobra.Command, subjectParam string, nick string, level trust.Level) {
+	subject, err := peer.Decode(subjectParam)
+	if err != nil {
+		panic(err)
+	}
+	store, privateKey := loadTrustStore(cmd)
+	e, err := trust.NewEndorsement(privateKey, subject, nick, level)
+	if err != nil {
+		panic(err)
+	}
+	if _, err = store.Add(e); err != nil {
+		panic(err)
+	}
+	printEndorsement(e)
+}
+
+// printEndorsement prints an endorsement, which may be a peer's, cleaned for the terminal.
+func printEndorsement(e *trust.Endorsement) {
+	nick := e.Nick
+	if nick == "" {
+		nick = "-"
+	}
+	fmt.Println(sanitize.Line(fmt.Sprintf("%s %-8s %s -> %s (%s)", e.Created.Local().Format(time.DateTime), e.Level, e.Endorser, e.Subject, nick)))
+}
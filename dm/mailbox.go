@@ -0,0 +1,254 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package dm
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// MailboxDir is the name of the directory in the data directory holding direct messages.
+const MailboxDir = "dm"
+
+// Conversation summarises the messages exchanged with one peer.
+type Conversation struct {
+	Peer  peer.ID
+	Nick  string
+	Count int
+	Last  time.Time
+}
+
+// Mailbox keeps the messages sent and received by the local identities, one append only JSON
+// lines file per identity.  Sealed messages are saved as they travel and opened in memory with
+// the keys given to Unlock.
+type Mailbox struct {
+	dir string
+
+	mu          sync.Mutex
+	keys        map[peer.ID]crypto.PrivKey
+	messages    map[peer.ID][]*Message
+	seen        map[peer.ID]map[string]bool
+	subscribers map[chan *Message]peer.ID
+}
+
+// OpenMailbox creates the mailbox directory if needed.  Identities are loaded the first time
+// they are used.
+func OpenMailbox(dir string) (mailbox *Mailbox, err error) {
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return
+	}
+	mailbox = &Mailbox{
+		dir:         dir,
+		keys:        make(map[peer.ID]crypto.PrivKey),
+		messages:    make(map[peer.ID][]*Message),
+		seen:        make(map[peer.ID]map[string]bool),
+		subscribers: make(map[chan *Message]peer.ID),
+	}
+	return
+}
+
+// Unlock gives the mailbox the key of a local identity so it can open the sealed messages of
+// that identity, returning the identity.
+func (mb *Mailbox) Unlock(privateKey crypto.PrivKey) (owner peer.ID, err error) {
+	owner, err = peer.IDFromPrivateKey(privateKey)
+	if err != nil {
+		return
+	}
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	if _, ok := mb.keys[owner]; ok {
+		return
+	}
+	mb.keys[owner] = privateKey
+	for _, m := range mb.messages[owner] {
+		if m.Body == "" {
+			m.Open(privateKey)
+		}
+	}
+	return
+}
+
+// Add saves a message in the mailbox of owner, who must be its sender or recipient.  Messages
+// already in the mailbox are ignored, as are sealed messages the owner's key can't open.
+func (mb *Mailbox) Add(owner peer.ID, m *Message) (added bool, err error) {
+	if err = m.Verify(); err != nil {
+		return
+	}
+	if m.FromID() != owner && m.ToID() != owner {
+		return
+	}
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	if err = mb.load(owner); err != nil {
+		return
+	}
+	if mb.seen[owner][m.ID] {
+		return
+	}
+	if key, ok := mb.keys[owner]; ok && m.Body == "" {
+		if err = m.Open(key); err != nil {
+			return
+		}
+	}
+	jsonBytes, err := json.Marshal(m)
+	if err != nil {
+		return
+	}
+	f, err := os.OpenFile(mb.file(owner), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	if _, err = f.Write(append(jsonBytes, '\n')); err != nil {
+		return
+	}
+	mb.seen[owner][m.ID] = true
+	mb.messages[owner] = append(mb.messages[owner], m)
+	for ch, subscriber := range mb.subscribers {
+		if subscriber != owner {
+			continue
+		}
+		select {
+		case ch <- m:
+		default:
+		}
+	}
+	return true, nil
+}
+
+// key returns the key given to Unlock for a local identity, or nil.
+func (mb *Mailbox) key(owner peer.ID) crypto.PrivKey {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	return mb.keys[owner]
+}
+
+// Conversations lists the peers owner exchanged messages with, most recent first.
+func (mb *Mailbox) Conversations(owner peer.ID) (conversations []*Conversation, err error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	if err = mb.load(owner); err != nil {
+		return
+	}
+	byPeer := make(map[peer.ID]*Conversation)
+	for _, m := range mb.messages[owner] {
+		other := m.ToID()
+		if other == owner {
+			other = m.FromID()
+		}
+		c, ok := byPeer[other]
+		if !ok {
+			c = &Conversation{Peer: other}
+			byPeer[other] = c
+		}
+		c.Count++
+		if m.FromID() == other {
+			c.Nick = m.Nick
+		}
+		if m.Created.After(c.Last) {
+			c.Last = m.Created
+		}
+	}
+	for _, c := range byPeer {
+		conversations = append(conversations, c)
+	}
+	sort.Slice(conversations, func(i, j int) bool { return conversations[i].Last.After(conversations[j].Last) })
+	return
+}
+
+// With returns the messages between owner and other, oldest first.
+func (mb *Mailbox) With(owner peer.ID, other peer.ID) (messages []*Message, err error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	if err = mb.load(owner); err != nil {
+		return
+	}
+	for _, m := range mb.messages[owner] {
+		if m.FromID() == other || m.ToID() == other {
+			messages = append(messages, m)
+		}
+	}
+	sort.SliceStable(messages, func(i, j int) bool { return messages[i].Created.Before(messages[j].Created) })
+	return
+}
+
+// Received returns the messages sent to owner, oldest first.
+func (mb *Mailbox) Received(owner peer.ID) (messages []*Message, err error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	if err = mb.load(owner); err != nil {
+		return
+	}
+	for _, m := range mb.messages[owner] {
+		if m.ToID() == owner && m.FromID() != owner {
+			messages = append(messages, m)
+		}
+	}
+	sort.SliceStable(messages, func(i, j int) bool { return messages[i].Created.Before(messages[j].Created) })
+	return
+}
+
+// Subscribe returns a channel receiving every message added to the mailbox of owner from now
+// on.  Call the returned function to stop receiving.
+func (mb *Mailbox) Subscribe(owner peer.ID) (<-chan *Message, func()) {
+	ch := make(chan *Message, 32)
+	mb.mu.Lock()
+	mb.subscribers[ch] = owner
+	mb.mu.Unlock()
+	return ch, func() {
+		mb.mu.Lock()
+		delete(mb.subscribers, ch)
+		mb.mu.Unlock()
+	}
+}
+
+// load reads the mailbox file of owner the first time it is used, the caller must hold the
+// lock.
+func (mb *Mailbox) load(owner peer.ID) error {
+	if _, ok := mb.seen[owner]; ok {
+		return nil
+	}
+	seen := make(map[string]bool)
+	var messages []*Message
+	f, err := os.Open(mb.file(owner))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err == nil {
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 0, 64<<10), 4*MaxBodyLength)
+		for scanner.Scan() {
+			m := new(Message)
+			if json.Unmarshal(scanner.Bytes(), m) != nil || m.Verify() != nil || seen[m.ID] {
+				continue
+			}
+			if key, ok := mb.keys[owner]; ok && m.Open(key) != nil {
+				continue
+			}
+			seen[m.ID] = true
+			messages = append(messages, m)
+		}
+		if err = scanner.Err(); err != nil {
+			return err
+		}
+	}
+	mb.seen[owner] = seen
+	mb.messages[owner] = messages
+	return nil
+}
+
+func (mb *Mailbox) file(owner peer.ID) string {
+	return filepath.Join(mb.dir, owner.String()+".jsonl")
+}
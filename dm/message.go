@@ -0,0 +1,211 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package dm
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/rightfoot-consulting/p2pbbs/bbscrypto"
+)
+
+const (
+	// MaxBodyLength bounds the text of a direct message.
+	MaxBodyLength = 16 << 10
+	// MaxClockSkew is how far in the future a message may be dated before it is rejected.
+	MaxClockSkew = 10 * time.Minute
+)
+
+// Message is a direct message signed by its sender.  ID is the content id of the signed
+// message and is used to drop duplicates.
+//
+// The body travels in Box, sealed so that only the sender and the recipient can read it, and
+// Body is only filled in once the message has been opened.  A sealed message is never encoded
+// with its Body.  Messages from older clients may carry their Body in the clear instead.
+type Message struct {
+	ID        string    `json:"id,omitempty"`
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	Nick      string    `json:"nick"`
+	Body      string    `json:"body"`
+	Box       []byte    `json:"box,omitempty"`
+	Created   time.Time `json:"created"`
+	PublicKey []byte    `json:"public_key,omitempty"`
+	Signature []byte    `json:"signature,omitempty"`
+}
+
+// NewMessage creates and signs a message to a peer from the owner of privateKey, sealing the
+// body.  Messages that can't be sealed, because a peer's key isn't ed25519, aren't sent at all.
+func NewMessage(privateKey crypto.PrivKey, nick string, to peer.ID, body string) (m *Message, err error) {
+	from, err := peer.IDFromPrivateKey(privateKey)
+	if err != nil {
+		return
+	}
+	if body == "" || len(body) > MaxBodyLength {
+		return nil, fmt.Errorf("message body is %d bytes, it must be between 1 and %d", len(body), MaxBodyLength)
+	}
+	m = &Message{
+		From:    from.String(),
+		To:      to.String(),
+		Nick:    nick,
+		Body:    body,
+		Created: time.Now().UTC(),
+	}
+	recipientKey, err := to.ExtractPublicKey()
+	if err != nil || !bbscrypto.Sealable(privateKey, recipientKey) {
+		return nil, fmt.Errorf("messages to %s can't be encrypted: %w", to, bbscrypto.ErrNotSealable)
+	}
+	if m.Box, err = bbscrypto.Seal(privateKey, recipientKey, []byte(body)); err != nil {
+		return nil, err
+	}
+	m.PublicKey, err = bbscrypto.VerificationKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	data, err := m.signingBytes()
+	if err != nil {
+		return nil, err
+	}
+	m.Signature, err = privateKey.Sign(data)
+	if err != nil {
+		return nil, err
+	}
+	m.ID, err = m.contentID()
+	if err != nil {
+		return nil, err
+	}
+	if err = m.Verify(); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Verify checks that the message is well formed, signed by its sender and that ID matches
+// its content.
+func (m *Message) Verify() (err error) {
+	from, err := peer.Decode(m.From)
+	if err != nil {
+		return
+	}
+	if _, err = peer.Decode(m.To); err != nil {
+		return
+	}
+	if m.Sealed() {
+		if len(m.Box) <= bbscrypto.SealOverhead || len(m.Box) > MaxBodyLength+bbscrypto.SealOverhead {
+			return fmt.Errorf("sealed message is %d bytes, it must be between %d and %d", len(m.Box), bbscrypto.SealOverhead+1, MaxBodyLength+bbscrypto.SealOverhead)
+		}
+	} else if m.Body == "" || len(m.Body) > MaxBodyLength {
+		return fmt.Errorf("message body is %d bytes, it must be between 1 and %d", len(m.Body), MaxBodyLength)
+	}
+	if m.Created.After(time.Now().Add(MaxClockSkew)) {
+		return fmt.Errorf("message created in the future: %v", m.Created)
+	}
+	data, err := m.signingBytes()
+	if err != nil {
+		return
+	}
+	if err = bbscrypto.Verify(from, m.PublicKey, data, m.Signature); err != nil {
+		return
+	}
+	id, err := m.contentID()
+	if err != nil {
+		return
+	}
+	if id != m.ID {
+		return fmt.Errorf("message id %s does not match its content %s", m.ID, id)
+	}
+	return
+}
+
+// Sealed reports whether the body of the message travels in its Box.
+func (m *Message) Sealed() bool {
+	return len(m.Box) > 0
+}
+
+// Open fills in the Body of a sealed message with privateKey, which must belong to its sender
+// or its recipient.  Opening a message that isn't sealed does nothing.
+func (m *Message) Open(privateKey crypto.PrivKey) (err error) {
+	if !m.Sealed() {
+		return
+	}
+	self, err := peer.IDFromPrivateKey(privateKey)
+	if err != nil {
+		return
+	}
+	other := m.FromID()
+	if other == self {
+		other = m.ToID()
+	}
+	peerKey, err := other.ExtractPublicKey()
+	if err != nil {
+		return
+	}
+	body, err := bbscrypto.Open(privateKey, peerKey, m.Box)
+	if err != nil {
+		return
+	}
+	if len(body) == 0 || len(body) > MaxBodyLength {
+		return fmt.Errorf("message body is %d bytes, it must be between 1 and %d", len(body), MaxBodyLength)
+	}
+	m.Body = string(body)
+	return
+}
+
+// MarshalJSON leaves out the Body of sealed messages so it is never published or saved.
+func (m *Message) MarshalJSON() ([]byte, error) {
+	type message Message
+	encoded := message(*m)
+	if m.Sealed() {
+		encoded.Body = ""
+	}
+	return json.Marshal(&encoded)
+}
+
+// UnmarshalJSON ignores any Body sent along with a sealed message, only Open may set it.
+func (m *Message) UnmarshalJSON(data []byte) error {
+	type message Message
+	decoded := new(message)
+	if err := json.Unmarshal(data, decoded); err != nil {
+		return err
+	}
+	*m = Message(*decoded)
+	if m.Sealed() {
+		m.Body = ""
+	}
+	return nil
+}
+
+// FromID returns the decoded sender, the message must have been verified.
+func (m *Message) FromID() peer.ID {
+	id, _ := peer.Decode(m.From)
+	return id
+}
+
+// ToID returns the decoded recipient, the message must have been verified.
+func (m *Message) ToID() peer.ID {
+	id, _ := peer.Decode(m.To)
+	return id
+}
+
+// signingBytes is the JSON encoding of the message without its id or signature.
+func (m *Message) signingBytes() ([]byte, error) {
+	unsigned := *m
+	unsigned.ID = ""
+	unsigned.Signature = nil
+	return json.Marshal(&unsigned)
+}
+
+// contentID hashes the signed message without its id.
+func (m *Message) contentID() (string, error) {
+	content := *m
+	content.ID = ""
+	data, err := json.Marshal(&content)
+	if err != nil {
+		return "", err
+	}
+	return bbscrypto.ContentID(data)
+}
@@ -0,0 +1,80 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package dm
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/rightfoot-consulting/p2pbbs/bbscrypto"
+)
+
+func newKey(t *testing.T, keyType int) (crypto.PrivKey, peer.ID) {
+	t.Helper()
+	sk, _, err := crypto.GenerateKeyPairWithReader(keyType, 2048, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := peer.IDFromPrivateKey(sk)
+	return sk, id
+}
+
+func TestSealedMessage(t *testing.T) {
+	alice, aliceID := newKey(t, crypto.Ed25519)
+	bob, bobID := newKey(t, crypto.Ed25519)
+	mallory, _ := newKey(t, crypto.Ed25519)
+
+	m, err := NewMessage(alice, "alice", bobID, "meet at noon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.Sealed() || strings.Contains(string(data), "noon") {
+		t.Fatalf("the body travels in the clear: %s", data)
+	}
+
+	// the recipient and the sender can open it, nobody else can
+	for _, key := range []crypto.PrivKey{bob, alice} {
+		received := new(Message)
+		if err = json.Unmarshal(data, received); err != nil {
+			t.Fatal(err)
+		}
+		if err = received.Verify(); err != nil {
+			t.Fatal(err)
+		}
+		if err = received.Open(key); err != nil || received.Body != "meet at noon" {
+			t.Errorf("opened %q: %v", received.Body, err)
+		}
+	}
+	received := new(Message)
+	json.Unmarshal(data, received)
+	if err = received.Open(mallory); err == nil {
+		t.Error("a third peer opened the message")
+	}
+
+	// a tampered box no longer verifies
+	received.Box[len(received.Box)-1] ^= 1
+	if err = received.Verify(); err == nil {
+		t.Error("a tampered message verified")
+	}
+	if received.FromID() != aliceID {
+		t.Errorf("from %s", received.FromID())
+	}
+}
+
+func TestUnsealableMessage(t *testing.T) {
+	alice, _ := newKey(t, crypto.Ed25519)
+	_, rsaID := newKey(t, crypto.RSA)
+	if _, err := NewMessage(alice, "alice", rsaID, "hello"); !errors.Is(err, bbscrypto.ErrNotSealable) {
+		t.Errorf("a message to an rsa peer gave %v", err)
+	}
+}
@@ -0,0 +1,212 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package dm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/rightfoot-consulting/p2pbbs/bbscrypto"
+)
+
+const (
+	// OutboxFile is the name of the file in the mailbox directory holding the messages that
+	// haven't reached their recipients yet.
+	OutboxFile = "outbox.json"
+	// MaxOutbox bounds the messages waiting for their recipients.
+	MaxOutbox = 1000
+	// OutboxTTL is how long a message is offered to a recipient who doesn't confirm it.
+	OutboxTTL = 7 * 24 * time.Hour
+	// RetryInterval is how long a message waits to be sent again the first time, the wait
+	// doubles with every attempt up to MaxRetryInterval.
+	RetryInterval = time.Minute
+	// MaxRetryInterval bounds the wait between attempts.
+	MaxRetryInterval = time.Hour
+)
+
+// ErrOutboxFull is returned when MaxOutbox messages are already waiting for their recipients.
+var ErrOutboxFull = errors.New("too many messages are waiting for their recipients")
+
+// Receipt tells the sender of a message that it reached the mailbox of its recipient, who
+// signs it.
+type Receipt struct {
+	Receipt   string `json:"receipt"`
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Signature []byte `json:"signature,omitempty"`
+}
+
+// NewReceipt signs a receipt for m with the key of its recipient.
+func NewReceipt(privateKey crypto.PrivKey, m *Message) (r *Receipt, err error) {
+	r = &Receipt{Receipt: m.ID, From: m.To, To: m.From}
+	data, err := r.signingBytes()
+	if err != nil {
+		return nil, err
+	}
+	if r.Signature, err = privateKey.Sign(data); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Verify checks that the receipt was signed by the recipient it names.  Only keys embedded in
+// peer ids are accepted, as direct messages are only sealed between ed25519 keys.
+func (r *Receipt) Verify() error {
+	from, err := peer.Decode(r.From)
+	if err != nil {
+		return err
+	}
+	data, err := r.signingBytes()
+	if err != nil {
+		return err
+	}
+	return bbscrypto.Verify(from, nil, data, r.Signature)
+}
+
+// signingBytes is the JSON encoding of the receipt without its signature.
+func (r *Receipt) signingBytes() ([]byte, error) {
+	unsigned := *r
+	unsigned.Signature = nil
+	return json.Marshal(&unsigned)
+}
+
+// outboxEntry is a message waiting for its recipient.
+type outboxEntry struct {
+	Message  *Message  `json:"message"`
+	Attempts int       `json:"attempts"`
+	Next     time.Time `json:"next"`
+	// heard is whether anybody listened on the recipient's topic at the last attempt
+	heard bool
+}
+
+// Outbox keeps the messages sent to peers until they confirm them with a receipt, so messages
+// reach recipients that were offline when they were sent.  It is saved as a JSON file.
+type Outbox struct {
+	file string
+
+	mu      sync.Mutex
+	entries map[string]*outboxEntry
+}
+
+// LoadOutbox reads the outbox in file, a missing file gives an empty outbox.  Messages that no
+// longer verify or waited longer than OutboxTTL are dropped.
+func LoadOutbox(file string) (outbox *Outbox, err error) {
+	outbox = &Outbox{file: file, entries: make(map[string]*outboxEntry)}
+	jsonBytes, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return outbox, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []*outboxEntry
+	if err = json.Unmarshal(jsonBytes, &entries); err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.Message != nil && e.Message.Verify() == nil && time.Since(e.Message.Created) < OutboxTTL {
+			outbox.entries[e.Message.ID] = e
+		}
+	}
+	return
+}
+
+// Add queues a message for its recipient.
+func (o *Outbox) Add(m *Message) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.entries) >= MaxOutbox {
+		return ErrOutboxFull
+	}
+	o.entries[m.ID] = &outboxEntry{Message: m, Next: time.Now()}
+	return o.save()
+}
+
+// Delivered removes the message a receipt confirms, reporting whether it was waiting.
+func (o *Outbox) Delivered(r *Receipt) (bool, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	e, ok := o.entries[r.Receipt]
+	if !ok || e.Message.To != r.From || e.Message.From != r.To {
+		return false, nil
+	}
+	delete(o.entries, r.Receipt)
+	return true, o.save()
+}
+
+// Pending returns the messages sent by owner that haven't been confirmed yet, oldest first.
+func (o *Outbox) Pending(owner peer.ID) []*Message {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var messages []*Message
+	for _, e := range o.entries {
+		if e.Message.From == owner.String() {
+			messages = append(messages, e.Message)
+		}
+	}
+	sort.Slice(messages, func(i, j int) bool { return messages[i].Created.Before(messages[j].Created) })
+	return messages
+}
+
+// due returns the messages to send again at now: those whose wait is over, and those whose
+// recipient nobody listened for at the last attempt when listening says somebody does now.
+// Messages waiting longer than OutboxTTL are dropped.  Each returned message is counted as
+// attempted.
+func (o *Outbox) due(now time.Time, listening func(to peer.ID) bool) (messages []*Message, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	changed := false
+	for id, e := range o.entries {
+		if now.Sub(e.Message.Created) >= OutboxTTL {
+			logger.Infof("giving up on message %s to %s", id, e.Message.To)
+			delete(o.entries, id)
+			changed = true
+			continue
+		}
+		heard := listening(e.Message.ToID())
+		if now.Before(e.Next) && (e.heard || !heard) {
+			continue
+		}
+		e.heard = heard
+		e.Attempts++
+		e.Next = now.Add(retryWait(e.Attempts))
+		messages = append(messages, e.Message)
+		changed = true
+	}
+	if changed {
+		err = o.save()
+	}
+	return
+}
+
+// retryWait returns how long to wait after a number of attempts.
+func retryWait(attempts int) time.Duration {
+	wait := RetryInterval
+	for i := 1; i < attempts && wait < MaxRetryInterval; i++ {
+		wait *= 2
+	}
+	return min(wait, MaxRetryInterval)
+}
+
+// save writes the outbox to its file, the caller must hold the lock.
+func (o *Outbox) save() error {
+	entries := make([]*outboxEntry, 0, len(o.entries))
+	for _, e := range o.entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Message.Created.Before(entries[j].Message.Created) })
+	jsonBytes, err := json.MarshalIndent(entries, "", "    ")
+	if err != nil {
+		return fmt.Errorf("saving the outbox: %w", err)
+	}
+	return os.WriteFile(o.file, jsonBytes, 0600)
+}
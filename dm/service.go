@@ -0,0 +1,250 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package dm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+var logger = logging.Logger("dm")
+
+// RetryTick is how often the outbox is checked for messages to send again.
+const RetryTick = 15 * time.Second
+
+// Service delivers direct messages over a PubSub topic per recipient.  Every local identity
+// listens on its own topic, so one node can receive messages for several users.  Sent messages
+// wait in an outbox and are published again until the recipient answers with a receipt, so
+// they reach recipients that were offline.
+type Service struct {
+	ctx     context.Context
+	ps      *pubsub.PubSub
+	mailbox *Mailbox
+	outbox  *Outbox
+
+	mu        sync.Mutex
+	topics    map[peer.ID]*pubsub.Topic
+	listening map[peer.ID]bool
+}
+
+// NewService returns a service that keeps the messages of local identities in mailbox, and
+// the messages they sent that weren't confirmed yet in the outbox beside it.
+func NewService(ctx context.Context, ps *pubsub.PubSub, mailbox *Mailbox) (s *Service, err error) {
+	outbox, err := LoadOutbox(filepath.Join(mailbox.dir, OutboxFile))
+	if err != nil {
+		return
+	}
+	s = &Service{
+		ctx:       ctx,
+		ps:        ps,
+		mailbox:   mailbox,
+		outbox:    outbox,
+		topics:    make(map[peer.ID]*pubsub.Topic),
+		listening: make(map[peer.ID]bool),
+	}
+	go s.retryLoop()
+	return
+}
+
+// Mailbox returns the mailbox the service fills.
+func (s *Service) Mailbox() *Mailbox {
+	return s.mailbox
+}
+
+// Listen subscribes to the topic of the local identity owning privateKey so messages sent to
+// it are opened and saved.  Listening again for the same identity does nothing.
+func (s *Service) Listen(privateKey crypto.PrivKey) (err error) {
+	id, err := s.mailbox.Unlock(privateKey)
+	if err != nil {
+		return
+	}
+	topic, err := s.topic(id)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listening[id] {
+		return
+	}
+	sub, err := topic.Subscribe()
+	if err != nil {
+		return
+	}
+	s.listening[id] = true
+	go s.readLoop(id, sub)
+	return
+}
+
+// Outbox returns the messages waiting for their recipients.
+func (s *Service) Outbox() *Outbox {
+	return s.outbox
+}
+
+// Send signs a message to a peer with privateKey, sealing it when their keys allow, saves it
+// in the sender's mailbox and queues it in the outbox.  It is published on the recipient's
+// topic right away and again until the recipient confirms it, so a failed first attempt isn't
+// an error.  The sender listens on its own topic to hear the receipt.
+func (s *Service) Send(privateKey crypto.PrivKey, nick string, to peer.ID, body string) (m *Message, err error) {
+	if err = s.Listen(privateKey); err != nil {
+		return
+	}
+	m, err = NewMessage(privateKey, nick, to, body)
+	if err != nil {
+		return
+	}
+	if err = s.outbox.Add(m); err != nil {
+		return
+	}
+	if _, err = s.mailbox.Add(m.FromID(), m); err != nil {
+		return
+	}
+	if err := s.publish(m); err != nil {
+		logger.Infof("message %s to %s will be sent again: %v", m.ID, to, err)
+	}
+	return
+}
+
+// publish sends a message on the topic of its recipient.
+func (s *Service) publish(m *Message) error {
+	msgBytes, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	topic, err := s.topic(m.ToID())
+	if err != nil {
+		return err
+	}
+	return topic.Publish(s.ctx, msgBytes)
+}
+
+// retryLoop publishes the messages of the outbox again until the service's context ends.
+// Messages are sent early when their recipient's topic gains listeners.
+func (s *Service) retryLoop() {
+	ticker := time.NewTicker(RetryTick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.retry(now)
+		}
+	}
+}
+
+// retry publishes the messages of the outbox that are due at now.
+func (s *Service) retry(now time.Time) {
+	messages, err := s.outbox.due(now, func(to peer.ID) bool {
+		topic, err := s.topic(to)
+		return err == nil && len(topic.ListPeers()) > 0
+	})
+	if err != nil {
+		logger.Warnf("updating the outbox: %v", err)
+	}
+	for _, m := range messages {
+		if err = s.publish(m); err != nil {
+			logger.Debugf("sending message %s to %s again: %v", m.ID, m.To, err)
+		}
+	}
+}
+
+// topic returns the topic of a recipient, joining it the first time.
+func (s *Service) topic(id peer.ID) (topic *pubsub.Topic, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if topic, ok := s.topics[id]; ok {
+		return topic, nil
+	}
+	topic, err = s.ps.Join(topicName(id))
+	if err != nil {
+		return
+	}
+	s.topics[id] = topic
+	return
+}
+
+// readLoop saves the messages sent to a local identity, answering each with a receipt, and
+// clears the outbox of the messages it confirms, until the service's context ends.
+func (s *Service) readLoop(id peer.ID, sub *pubsub.Subscription) {
+	for {
+		msg, err := sub.Next(s.ctx)
+		if err != nil {
+			return
+		}
+		r := new(Receipt)
+		if err = json.Unmarshal(msg.Data, r); err != nil {
+			continue
+		}
+		if r.Receipt != "" {
+			s.receive(id, r)
+			continue
+		}
+		m := new(Message)
+		if err = json.Unmarshal(msg.Data, m); err != nil {
+			continue
+		}
+		if m.To != id.String() {
+			continue
+		}
+		if _, err = s.mailbox.Add(id, m); err != nil {
+			logger.Debugf("rejected message from %s: %v", msg.GetFrom(), err)
+			continue
+		}
+		// duplicates are confirmed again, the first receipt may have been lost
+		if err = s.confirm(id, m); err != nil {
+			logger.Debugf("confirming message %s: %v", m.ID, err)
+		}
+	}
+}
+
+// confirm publishes a receipt for a message received by a local identity on its sender's topic.
+func (s *Service) confirm(id peer.ID, m *Message) error {
+	privateKey := s.mailbox.key(id)
+	if privateKey == nil {
+		return fmt.Errorf("%s is locked", id)
+	}
+	r, err := NewReceipt(privateKey, m)
+	if err != nil {
+		return err
+	}
+	receiptBytes, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	topic, err := s.topic(m.FromID())
+	if err != nil {
+		return err
+	}
+	return topic.Publish(s.ctx, receiptBytes)
+}
+
+// receive removes the message a receipt sent to a local identity confirms from the outbox.
+func (s *Service) receive(id peer.ID, r *Receipt) {
+	if r.To != id.String() {
+		return
+	}
+	if err := r.Verify(); err != nil {
+		logger.Debugf("rejected receipt from %s: %v", r.From, err)
+		return
+	}
+	if delivered, err := s.outbox.Delivered(r); err != nil {
+		logger.Warnf("updating the outbox: %v", err)
+	} else if delivered {
+		logger.Debugf("message %s reached %s", r.Receipt, r.From)
+	}
+}
+
+func topicName(id peer.ID) string {
+	return "dm:" + id.String()
+}
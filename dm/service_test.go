@@ -0,0 +1,108 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package dm
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestOutbox(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	host, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer host.Close()
+	ps, err := pubsub.NewGossipSub(ctx, host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	mailbox, err := OpenMailbox(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewService(ctx, ps, mailbox)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice, aliceID := newKey(t, crypto.Ed25519)
+	bob, bobID := newKey(t, crypto.Ed25519)
+	mallory, _ := newKey(t, crypto.Ed25519)
+
+	// bob isn't listening, so the message waits in the outbox, which survives a restart
+	m, err := s.Send(alice, "alice", bobID, "are you there?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending := s.Outbox().Pending(aliceID); len(pending) != 1 || pending[0].ID != m.ID {
+		t.Fatalf("pending %v", pending)
+	}
+	saved, err := LoadOutbox(filepath.Join(dir, OutboxFile))
+	if err != nil || len(saved.Pending(aliceID)) != 1 {
+		t.Fatalf("saved outbox %v: %v", saved, err)
+	}
+
+	// only bob can confirm the message
+	forged := &Receipt{Receipt: m.ID, From: bobID.String(), To: aliceID.String()}
+	data, _ := forged.signingBytes()
+	forged.Signature, _ = mallory.Sign(data)
+	if forged.Verify() == nil {
+		t.Error("accepted a receipt signed by someone else")
+	}
+	s.receive(aliceID, forged)
+	if len(s.Outbox().Pending(aliceID)) != 1 {
+		t.Fatal("a forged receipt cleared the outbox")
+	}
+
+	// once bob listens the message is sent again, and his receipt clears the outbox
+	received, stop := mailbox.Subscribe(bobID)
+	defer stop()
+	if err = s.Listen(bob); err != nil {
+		t.Fatal(err)
+	}
+	// let the new subscription settle before publishing to it
+	time.Sleep(100 * time.Millisecond)
+	s.retry(time.Now().Add(RetryInterval))
+	select {
+	case got := <-received:
+		if got.ID != m.ID || got.Body != "are you there?" {
+			t.Errorf("received %+v", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("message not sent again")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(s.Outbox().Pending(aliceID)) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the receipt didn't clear the outbox")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// messages nobody confirms are given up
+	if err = s.Outbox().Add(m); err != nil {
+		t.Fatal(err)
+	}
+	if due, _ := s.Outbox().due(m.Created.Add(OutboxTTL), func(peer.ID) bool { return false }); len(due) != 0 || len(s.Outbox().Pending(aliceID)) != 0 {
+		t.Errorf("expired message still queued")
+	}
+}
+
+func TestRetryWait(t *testing.T) {
+	for attempts, want := range map[int]time.Duration{1: RetryInterval, 2: 2 * RetryInterval, 3: 4 * RetryInterval, 20: MaxRetryInterval} {
+		if got := retryWait(attempts); got != want {
+			t.Errorf("%d attempts: %v, want %v", attempts, got, want)
+		}
+	}
+}
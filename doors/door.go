@@ -0,0 +1,115 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package doors
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// BuiltinPrefix marks a door command implemented by p2pbbs itself.
+const BuiltinPrefix = "builtin:"
+
+// DefaultTimeLimit is how long a door may run when its configuration doesn't say.
+const DefaultTimeLimit = 30 * time.Minute
+
+// DropFormat is the kind of drop file written for a door.
+type DropFormat string
+
+const (
+	// DoorSys is the 52 line DOOR.SYS format understood by most doors.
+	DoorSys DropFormat = "door.sys"
+	// Dorinfo is the DORINFO1.DEF format of RBBS and QuickBBS.
+	Dorinfo DropFormat = "dorinfo1.def"
+	// JSON is a p2pbbs specific drop file that also carries the user's peer id.
+	JSON DropFormat = "door.json"
+)
+
+// Door is an external program users can run from the BBS, declared in the configuration.
+// Args may contain {dropfile}, {dropdir} and {scorefile}, which are replaced with the paths
+// of the session's drop file, the directory holding it and the file the door may write a
+// score to.
+type Door struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	Command     string     `json:"command"`
+	Args        []string   `json:"args"`
+	DropFile    DropFormat `json:"drop_file"`
+	TimeLimit   int        `json:"time_limit"`
+	Dir         string     `json:"dir"`
+}
+
+// DefaultDoors are offered when the configuration doesn't declare any.
+func DefaultDoors() []*Door {
+	return []*Door{
+		{
+			Name:        "guess",
+			Description: "Guess the number, a test door",
+			Command:     BuiltinPrefix + "guess",
+			DropFile:    JSON,
+			TimeLimit:   5,
+		},
+	}
+}
+
+// Find returns the door called name.
+func Find(doors []*Door, name string) (*Door, error) {
+	for _, door := range doors {
+		if strings.EqualFold(door.Name, name) {
+			return door, nil
+		}
+	}
+	return nil, fmt.Errorf("no door named %s", name)
+}
+
+// Limit returns how long the door may run.
+func (door *Door) Limit() time.Duration {
+	if door.TimeLimit <= 0 {
+		return DefaultTimeLimit
+	}
+	return time.Duration(door.TimeLimit) * time.Minute
+}
+
+// Format returns the drop file format of the door, DOOR.SYS unless configured otherwise.
+func (door *Door) Format() DropFormat {
+	if door.DropFile == "" {
+		return DoorSys
+	}
+	return DropFormat(strings.ToLower(string(door.DropFile)))
+}
+
+// Validate checks the declaration of a door.
+func (door *Door) Validate() error {
+	if door.Name == "" || strings.ContainsAny(door.Name, " :/\\") {
+		return fmt.Errorf("invalid door name %q", door.Name)
+	}
+	if door.Command == "" {
+		return fmt.Errorf("door %s has no command", door.Name)
+	}
+	switch door.Format() {
+	case DoorSys, Dorinfo, JSON:
+	default:
+		return fmt.Errorf("door %s has unknown drop file format %s", door.Name, door.DropFile)
+	}
+	return nil
+}
+
+// commandLine resolves the program and arguments to run, built in doors are run by the
+// p2pbbs executable itself.
+func (door *Door) commandLine(replacer *strings.Replacer) (program string, args []string, err error) {
+	program = door.Command
+	if name, ok := strings.CutPrefix(door.Command, BuiltinPrefix); ok {
+		if program, err = os.Executable(); err != nil {
+			return
+		}
+		args = []string{"door", name, "--drop-file", "{dropfile}", "--score-file", "{scorefile}"}
+	}
+	args = append(args, door.Args...)
+	for i, arg := range args {
+		args[i] = replacer.Replace(arg)
+	}
+	return
+}
@@ -0,0 +1,104 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package doors
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/rightfoot-consulting/p2pbbs/boards"
+)
+
+func TestDropFiles(t *testing.T) {
+	info := &DropInfo{BBSName: "p2pbbs", Sysop: "Sys Op", UserName: "alice", PeerID: "12D3KooW", MinutesLeft: 5, ANSI: true, Height: 24}
+	dir := t.TempDir()
+
+	file, err := WriteDropFile(dir, DoorSys, info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(file)
+	lines := strings.Split(strings.TrimSuffix(string(data), "\r\n"), "\r\n")
+	if len(lines) != 52 || lines[9] != "alice" || lines[18] != "5" || lines[19] != "GR" {
+		t.Errorf("unexpected DOOR.SYS %q", lines)
+	}
+
+	file, err = WriteDropFile(dir, Dorinfo, info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ = os.ReadFile(file)
+	lines = strings.Split(strings.TrimSuffix(string(data), "\r\n"), "\r\n")
+	if len(lines) != 13 || lines[1] != "Sys" || lines[6] != "alice" || lines[7] != "." {
+		t.Errorf("unexpected DORINFO1.DEF %q", lines)
+	}
+
+	file, err = WriteDropFile(dir, JSON, info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadDropInfo(file)
+	if err != nil || read.PeerID != info.PeerID {
+		t.Errorf("unexpected door.json %+v: %v", read, err)
+	}
+}
+
+func TestRun(t *testing.T) {
+	door := &Door{
+		Name:     "echo",
+		Command:  "/bin/sh",
+		Args:     []string{"-c", `read name; echo "hello $name"; echo 42 > {scorefile}; test -s "$P2PBBS_DROPFILE" && test -z "$P2PBBS_TEST_SECRET"`},
+		DropFile: Dorinfo,
+	}
+	// doors don't see the node's environment
+	t.Setenv("P2PBBS_TEST_SECRET", "hunter2")
+	var out bytes.Buffer
+	result, err := Run(context.Background(), door, &DropInfo{UserName: "alice"}, strings.NewReader("bob\n"), &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "hello bob\r\n" {
+		t.Errorf("unexpected output %q", out.String())
+	}
+	if !result.Scored || result.Score != 42 || result.TimedOut {
+		t.Errorf("unexpected result %+v", result)
+	}
+}
+
+func TestHighScores(t *testing.T) {
+	store, err := boards.OpenStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	post := func(nick string, key crypto.PrivKey, subject string) {
+		p, err := boards.NewPost(key, nick, ScoreBoard, subject, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = store.Add(p); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	alice, _, _ := crypto.GenerateEd25519Key(nil)
+	bob, _, _ := crypto.GenerateEd25519Key(nil)
+	post("alice", alice, "guess score 300")
+	post("alice", alice, "guess score 500")
+	post("bob", bob, "guess score 400")
+	post("bob", bob, "other score 900")
+	post("bob", bob, "guess score lots")
+
+	scores := HighScores(store, "guess", 10)
+	if len(scores) != 2 || scores[0].Nick != "alice" || scores[0].Score != 500 || scores[1].Score != 400 {
+		t.Errorf("unexpected scores %+v", scores)
+	}
+	if scores := HighScores(store, "guess", 1); len(scores) != 1 {
+		t.Errorf("limit ignored, got %d scores", len(scores))
+	}
+}
@@ -0,0 +1,185 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package doors
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DropInfo describes the user and session to a door.
+type DropInfo struct {
+	BBSName       string    `json:"bbs_name"`
+	Sysop         string    `json:"sysop"`
+	Door          string    `json:"door"`
+	UserName      string    `json:"user_name"`
+	Alias         string    `json:"alias"`
+	PeerID        string    `json:"peer_id"`
+	Location      string    `json:"location"`
+	Node          int       `json:"node"`
+	SecurityLevel int       `json:"security_level"`
+	MinutesLeft   int       `json:"minutes_left"`
+	ANSI          bool      `json:"ansi"`
+	Width         int       `json:"width"`
+	Height        int       `json:"height"`
+	ScoreFile     string    `json:"score_file"`
+	Started       time.Time `json:"started"`
+}
+
+// ReadDropInfo loads a door.json drop file, used by the built in doors.
+func ReadDropInfo(file string) (info *DropInfo, err error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return
+	}
+	info = &DropInfo{}
+	err = json.Unmarshal(data, info)
+	return
+}
+
+// DropFileName returns the name of the drop file of a format, in the case doors look for.
+func DropFileName(format DropFormat) string {
+	switch format {
+	case DoorSys:
+		return "DOOR.SYS"
+	case Dorinfo:
+		return "DORINFO1.DEF"
+	default:
+		return string(format)
+	}
+}
+
+// WriteDropFile writes the drop file of format into dir, returning its path.
+func WriteDropFile(dir string, format DropFormat, info *DropInfo) (file string, err error) {
+	var data []byte
+	switch format {
+	case DoorSys:
+		data = doorSys(info)
+	case Dorinfo:
+		data = dorinfo(info)
+	case JSON:
+		if data, err = json.MarshalIndent(info, "", "    "); err != nil {
+			return
+		}
+	default:
+		return "", fmt.Errorf("unknown drop file format %s", format)
+	}
+	file = filepath.Join(dir, DropFileName(format))
+	err = os.WriteFile(file, data, 0600)
+	return
+}
+
+// dropLines joins drop file lines with the CR LF line ends DOS doors expect.
+func dropLines(lines []string) []byte {
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+// names splits a user name into the first and last names of the DOS formats.
+func names(name string) (first string, last string) {
+	first, last, _ = strings.Cut(strings.TrimSpace(name), " ")
+	if last == "" {
+		last = "."
+	}
+	return
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "Y"
+	}
+	return "N"
+}
+
+// doorSys renders the 52 line DOOR.SYS format.  The door talks to the user over its standard
+// input and output, so the port is the local console COM0.
+func doorSys(info *DropInfo) []byte {
+	date := info.Started.Format("01/02/06")
+	graphics := "NG"
+	if info.ANSI {
+		graphics = "GR"
+	}
+	return dropLines([]string{
+		"COM0:",
+		"0",
+		"8",
+		fmt.Sprint(info.Node),
+		"0",
+		"Y",
+		"N",
+		"N",
+		"N",
+		info.UserName,
+		info.Location,
+		"",
+		"",
+		"",
+		fmt.Sprint(info.SecurityLevel),
+		"1",
+		date,
+		fmt.Sprint(info.MinutesLeft * 60),
+		fmt.Sprint(info.MinutesLeft),
+		graphics,
+		fmt.Sprint(info.Height),
+		"Y",
+		"",
+		"",
+		"12/31/99",
+		"1",
+		"Z",
+		"0",
+		"0",
+		"0",
+		"0",
+		"01/01/70",
+		"",
+		"",
+		info.Sysop,
+		info.Alias,
+		"00:00",
+		"Y",
+		yesNo(info.ANSI),
+		"Y",
+		"7",
+		"0",
+		date,
+		info.Started.Format("15:04"),
+		info.Started.Format("15:04"),
+		"0",
+		"0",
+		"0",
+		"0",
+		info.PeerID,
+		"0",
+		"0",
+	})
+}
+
+// dorinfo renders the DORINFO1.DEF format.
+func dorinfo(info *DropInfo) []byte {
+	sysopFirst, sysopLast := names(info.Sysop)
+	userFirst, userLast := names(info.UserName)
+	graphics := "0"
+	if info.ANSI {
+		graphics = "1"
+	}
+	return dropLines([]string{
+		info.BBSName,
+		sysopFirst,
+		sysopLast,
+		"COM0",
+		"0 BAUD,N,8,1",
+		"0",
+		userFirst,
+		userLast,
+		info.Location,
+		graphics,
+		fmt.Sprint(info.SecurityLevel),
+		fmt.Sprint(info.MinutesLeft),
+		"-1",
+	})
+}
@@ -0,0 +1,103 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package doors
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// GuessTries is how many guesses the guess door allows.
+const GuessTries = 7
+
+// maxGuessLine bounds what the guess door reads as one answer.
+const maxGuessLine = 16
+
+// Guess is the built in test door: the player has GuessTries tries to find a number between 1
+// and 100, scoring 100 points for every try left over.  When echo is set the door echoes what
+// the player types, as remote terminals leave that to the BBS.
+func Guess(in io.Reader, out io.Writer, info *DropInfo, echo bool) (score int, err error) {
+	if score, err = playGuess(in, out, info, echo); err != nil {
+		return
+	}
+	// let the player read the outcome before the BBS redraws its menu
+	fmt.Fprintf(out, "\r\nPress Enter to return to %s.", info.BBSName)
+	in.Read(make([]byte, 1))
+	return
+}
+
+func playGuess(in io.Reader, out io.Writer, info *DropInfo, echo bool) (score int, err error) {
+	number := rand.Intn(100) + 1
+	fmt.Fprintf(out, "\x1b[2J\x1b[H\x1b[1;33mGuess the number\x1b[0m\r\n\r\n")
+	fmt.Fprintf(out, "Welcome %s.  I'm thinking of a number between 1 and 100,\r\n", info.UserName)
+	fmt.Fprintf(out, "you have %d tries to find it.  You have %d minutes.\r\n\r\n", GuessTries, info.MinutesLeft)
+	for try := 1; try <= GuessTries; try++ {
+		fmt.Fprintf(out, "Guess %d: ", try)
+		var line string
+		if line, err = readLine(in, out, echo); err != nil {
+			return
+		}
+		guess, convErr := strconv.Atoi(line)
+		switch {
+		case line == "q" || line == "Q":
+			fmt.Fprintf(out, "Bye.\r\n")
+			return
+		case convErr != nil || guess < 1 || guess > 100:
+			fmt.Fprintf(out, "That's not a number between 1 and 100.\r\n")
+			try--
+		case guess < number:
+			fmt.Fprintf(out, "Higher.\r\n")
+		case guess > number:
+			fmt.Fprintf(out, "Lower.\r\n")
+		default:
+			score = (GuessTries - try + 1) * 100
+			fmt.Fprintf(out, "\r\n\x1b[1;32mYou got it in %d!\x1b[0m  You score %d.\r\n", try, score)
+			return
+		}
+	}
+	fmt.Fprintf(out, "\r\nOut of tries, the number was %d.\r\n", number)
+	return
+}
+
+// readLine reads a non empty line a key at a time, the way a door reads from a remote
+// terminal.
+func readLine(in io.Reader, out io.Writer, echo bool) (line string, err error) {
+	var typed []byte
+	b := make([]byte, 1)
+	for {
+		if _, err = in.Read(b); err != nil {
+			return
+		}
+		switch b[0] {
+		case '\r', '\n':
+			if len(typed) == 0 {
+				continue
+			}
+			if echo {
+				io.WriteString(out, "\r\n")
+			}
+			return strings.TrimSpace(string(typed)), nil
+		case 3, 4:
+			return "", io.EOF
+		case 8, 127:
+			if len(typed) > 0 {
+				typed = typed[:len(typed)-1]
+				if echo {
+					io.WriteString(out, "\b \b")
+				}
+			}
+		default:
+			if b[0] < ' ' || len(typed) >= maxGuessLine {
+				continue
+			}
+			typed = append(typed, b[0])
+			if echo {
+				out.Write(b)
+			}
+		}
+	}
+}
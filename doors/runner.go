@@ -0,0 +1,154 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package doors
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+)
+
+var logger = logging.Logger("doors")
+
+// ScoreFileName is the file in the drop directory a door writes the user's score to, as a
+// single integer.
+const ScoreFileName = "SCORE.TXT"
+
+// Result is what came of running a door.
+type Result struct {
+	Score    int
+	Scored   bool
+	TimedOut bool
+	Elapsed  time.Duration
+}
+
+// Run writes a drop file describing the user and runs the door until it exits or its time
+// limit passes.  The door talks to the user over stdin and stdout.  When they aren't files,
+// stdin is copied to the door in the background until it returns an error, so callers
+// lending a terminal must end their reader once Run returns.
+func Run(ctx context.Context, door *Door, info *DropInfo, stdin io.Reader, stdout io.Writer) (result *Result, err error) {
+	if err = door.Validate(); err != nil {
+		return
+	}
+	dir, err := os.MkdirTemp("", "p2pbbs-door-")
+	if err != nil {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	limit := door.Limit()
+	session := *info
+	session.Door = door.Name
+	session.ScoreFile = filepath.Join(dir, ScoreFileName)
+	session.Started = time.Now()
+	if session.MinutesLeft <= 0 || session.MinutesLeft > int(limit/time.Minute) {
+		session.MinutesLeft = int(limit / time.Minute)
+	}
+	dropFile, err := WriteDropFile(dir, door.Format(), &session)
+	if err != nil {
+		return
+	}
+	replacer := strings.NewReplacer("{dropfile}", dropFile, "{dropdir}", dir, "{scorefile}", session.ScoreFile)
+	program, args, err := door.commandLine(replacer)
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, limit)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, program, args...)
+	cmd.Dir = dir
+	if door.Dir != "" {
+		cmd.Dir = door.Dir
+	}
+	cmd.Env = doorEnv(dir, dropFile, &session)
+	// don't wait forever for children of the door holding its output open
+	cmd.WaitDelay = time.Second
+	if f, ok := stdin.(*os.File); ok {
+		cmd.Stdin = f
+	} else {
+		var pipe io.WriteCloser
+		if pipe, err = cmd.StdinPipe(); err != nil {
+			return
+		}
+		go func() {
+			io.Copy(pipe, stdin)
+			pipe.Close()
+		}()
+	}
+	if f, ok := stdout.(*os.File); ok {
+		cmd.Stdout, cmd.Stderr = f, f
+	} else {
+		out := &crlfWriter{w: stdout}
+		cmd.Stdout, cmd.Stderr = out, out
+	}
+
+	logger.Infof("running door %s for %s", door.Name, info.UserName)
+	result = &Result{}
+	err = cmd.Run()
+	result.Elapsed = time.Since(session.Started)
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		result.TimedOut = true
+		err = nil
+	}
+	if data, readErr := os.ReadFile(session.ScoreFile); readErr == nil {
+		if score, parseErr := strconv.Atoi(strings.TrimSpace(string(data))); parseErr == nil {
+			result.Score, result.Scored = score, true
+		}
+	}
+	return
+}
+
+// doorEnv returns the environment of a door: where its files are and what the user's terminal
+// is like, but nothing of the node's own environment, which may hold secrets, beyond the PATH
+// to find programs on.
+func doorEnv(dir string, dropFile string, info *DropInfo) []string {
+	term := "dumb"
+	if info.ANSI {
+		term = "ansi"
+	}
+	env := []string{
+		"HOME=" + dir,
+		"TMPDIR=" + dir,
+		"TERM=" + term,
+		"COLUMNS=" + strconv.Itoa(info.Width),
+		"LINES=" + strconv.Itoa(info.Height),
+		"P2PBBS_DROPFILE=" + dropFile,
+		"P2PBBS_SCOREFILE=" + info.ScoreFile,
+	}
+	if path, ok := os.LookupEnv("PATH"); ok {
+		env = append(env, "PATH="+path)
+	}
+	return env
+}
+
+// crlfWriter turns bare line feeds into CR LF, remote terminals have no line discipline to do
+// it for doors written for a local console.
+type crlfWriter struct {
+	w    io.Writer
+	last byte
+}
+
+func (cw *crlfWriter) Write(p []byte) (int, error) {
+	out := make([]byte, 0, len(p)+8)
+	for _, b := range p {
+		if b == '\n' && cw.last != '\r' {
+			out = append(out, '\r')
+		}
+		out = append(out, b)
+		cw.last = b
+	}
+	if _, err := cw.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,89 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package doors
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/rightfoot-consulting/p2pbbs/boards"
+)
+
+// ScoreBoard is the board door scores are posted to, shared by every node running the door.
+const ScoreBoard = "doors"
+
+// scoreSubject is the subject of a score post, the door's name and the score.
+const scoreSubject = "%s score %d"
+
+// Score is a score posted to the score board.  Scores are claimed by the players: the door
+// reports them and the player signs them, so a player can post any score they like.  The
+// signature only proves who claims it.
+type Score struct {
+	Door   string
+	Nick   string
+	Author peer.ID
+	Score  int
+	Posted time.Time
+}
+
+// PostScore publishes a signed post recording a user's score in a door to the score board.
+func PostScore(service *boards.Service, privateKey crypto.PrivKey, nick string, door string, result *Result) (p *boards.Post, err error) {
+	subject := fmt.Sprintf(scoreSubject, door, result.Score)
+	body := fmt.Sprintf("%s scored %d playing %s for %s.", nick, result.Score, door, result.Elapsed.Round(time.Second))
+	if p, err = boards.NewPost(privateKey, nick, ScoreBoard, subject, body, nil); err != nil {
+		return
+	}
+	err = service.Publish(p)
+	return
+}
+
+// HighScores returns the best score of each player of a door, highest first, at most limit
+// of them.
+func HighScores(store *boards.Store, door string, limit int) []*Score {
+	best := make(map[peer.ID]*Score)
+	for _, p := range store.Posts(ScoreBoard) {
+		score, ok := parseScore(p, door)
+		if !ok {
+			continue
+		}
+		if current, ok := best[score.Author]; !ok || score.Score > current.Score {
+			best[score.Author] = score
+		}
+	}
+	scores := make([]*Score, 0, len(best))
+	for _, score := range best {
+		scores = append(scores, score)
+	}
+	sort.Slice(scores, func(i, j int) bool {
+		if scores[i].Score != scores[j].Score {
+			return scores[i].Score > scores[j].Score
+		}
+		return scores[i].Posted.Before(scores[j].Posted)
+	})
+	if len(scores) > limit {
+		scores = scores[:limit]
+	}
+	return scores
+}
+
+// parseScore reads the score from a post on the score board if it is one for door.
+func parseScore(p *boards.Post, door string) (score *Score, ok bool) {
+	if !p.IsThread() {
+		return
+	}
+	name, value, found := strings.Cut(p.Subject, " score ")
+	if !found || !strings.EqualFold(name, door) {
+		return
+	}
+	points, err := strconv.Atoi(value)
+	if err != nil {
+		return
+	}
+	return &Score{Door: name, Nick: p.Nick, Author: p.AuthorID(), Score: points, Posted: p.Created}, true
+}
@@ -0,0 +1,210 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package gopherbbs
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rightfoot-consulting/p2pbbs/boards"
+)
+
+// item types used in menus
+const (
+	typeText  byte = '0'
+	typeMenu  byte = '1'
+	typeError byte = '3'
+	typeInfo  byte = 'i'
+)
+
+// selector prefixes, followed by a board name or a post content id
+const (
+	boardSelector  = "/board/"
+	threadSelector = "/thread/"
+	postSelector   = "/post/"
+)
+
+// dateFormat is how dates are shown in menus.
+const dateFormat = "2006-01-02 15:04"
+
+// maxAbstract bounds how much of a post is sent as its Gopher+ abstract.
+const maxAbstract = 240
+
+// item is an entry in a menu.
+type item struct {
+	kind     byte
+	display  string
+	selector string
+	modified time.Time
+	size     int
+	abstract string
+}
+
+// resource is what a selector names: a menu or a text file, and the item describing it.
+type resource struct {
+	self *item
+	menu []*item
+	text string
+}
+
+// resolve finds what a selector names.
+func (s *Server) resolve(selector string) (*resource, error) {
+	switch {
+	case selector == "" || selector == "/":
+		return s.rootMenu(), nil
+	case strings.HasPrefix(selector, boardSelector):
+		return s.boardMenu(strings.TrimPrefix(selector, boardSelector))
+	case strings.HasPrefix(selector, threadSelector):
+		return s.threadMenu(strings.TrimPrefix(selector, threadSelector))
+	case strings.HasPrefix(selector, postSelector):
+		return s.postText(strings.TrimPrefix(selector, postSelector))
+	default:
+		return nil, errNotFound
+	}
+}
+
+func info(format string, args ...interface{}) *item {
+	return &item{kind: typeInfo, display: fmt.Sprintf(format, args...)}
+}
+
+// rootMenu lists the boards.
+func (s *Server) rootMenu() *resource {
+	menu := []*item{
+		info("p2pbbs - a bulletin board on a peer to peer network"),
+		info("Read only archive of the boards held by this node"),
+		info(""),
+	}
+	for _, board := range s.store.Boards() {
+		menu = append(menu, s.boardItem(board))
+	}
+	return &resource{
+		self: &item{kind: typeMenu, display: "p2pbbs boards", selector: "/"},
+		menu: menu,
+	}
+}
+
+func (s *Server) boardItem(board string) *item {
+	threads := s.store.Threads(board)
+	it := &item{
+		kind:     typeMenu,
+		display:  fmt.Sprintf("%s (%d threads)", board, len(threads)),
+		selector: boardSelector + board,
+		abstract: fmt.Sprintf("The %s board", board),
+	}
+	if len(threads) > 0 {
+		it.modified = threads[0].LastPost
+	}
+	return it
+}
+
+// boardMenu lists the threads of a board, the most recently active first.
+func (s *Server) boardMenu(board string) (*resource, error) {
+	if !boards.ValidBoardName(board) {
+		return nil, errNotFound
+	}
+	threads := s.store.Threads(board)
+	if len(threads) == 0 {
+		return nil, errNotFound
+	}
+	menu := []*item{info("Board %s, %d threads", board, len(threads)), info("")}
+	for _, t := range threads {
+		it := s.threadItem(t.Root)
+		it.display = fmt.Sprintf("%s - %s, %d replies, last %s", t.Root.Subject, author(t.Root), t.Replies, t.LastPost.Local().Format(dateFormat))
+		it.modified = t.LastPost
+		menu = append(menu, it)
+	}
+	return &resource{self: s.boardItem(board), menu: menu}, nil
+}
+
+func (s *Server) threadItem(root *boards.Post) *item {
+	return &item{
+		kind:     typeMenu,
+		display:  root.Subject,
+		selector: threadSelector + root.ID,
+		modified: root.Created,
+		abstract: abstract(root),
+	}
+}
+
+// threadMenu lists the posts of a thread oldest first, replies indented under what they
+// answer.
+func (s *Server) threadMenu(id string) (*resource, error) {
+	posts := s.store.Thread(id)
+	if len(posts) == 0 || !posts[0].IsThread() {
+		return nil, errNotFound
+	}
+	root := posts[0]
+	depth := map[string]int{root.ID: 0}
+	menu := []*item{
+		info("%s", root.Subject),
+		info("on %s, started by %s", root.Board, author(root)),
+		info(""),
+	}
+	for _, p := range posts {
+		if parent, ok := depth[p.ReplyTo]; ok && p.ID != root.ID {
+			depth[p.ID] = parent + 1
+		}
+		it := s.postItem(p)
+		it.display = fmt.Sprintf("%s%s - %s, %s", strings.Repeat("  ", depth[p.ID]), p.Subject, author(p), p.Created.Local().Format(dateFormat))
+		menu = append(menu, it)
+	}
+	menu = append(menu, info(""), &item{kind: typeMenu, display: "Back to " + root.Board, selector: boardSelector + root.Board})
+	return &resource{self: s.threadItem(root), menu: menu}, nil
+}
+
+func (s *Server) postItem(p *boards.Post) *item {
+	return &item{
+		kind:     typeText,
+		display:  p.Subject,
+		selector: postSelector + p.ID,
+		modified: p.Created,
+		size:     len(postText(p)),
+		abstract: abstract(p),
+	}
+}
+
+// postText serves a post as a text file with mail like headers.
+func (s *Server) postText(id string) (*resource, error) {
+	p, ok := s.store.Get(id)
+	if !ok {
+		return nil, errNotFound
+	}
+	return &resource{self: s.postItem(p), text: postText(p)}, nil
+}
+
+func postText(p *boards.Post) string {
+	var text strings.Builder
+	fmt.Fprintf(&text, "Subject: %s\n", p.Subject)
+	fmt.Fprintf(&text, "From: %s <%s>\n", p.Nick, p.Author)
+	fmt.Fprintf(&text, "Board: %s\n", p.Board)
+	fmt.Fprintf(&text, "Date: %s\n", p.Created.UTC().Format(time.RFC1123Z))
+	fmt.Fprintf(&text, "Post-ID: %s\n", p.ID)
+	if !p.IsThread() {
+		fmt.Fprintf(&text, "In-Reply-To: %s\n", p.ReplyTo)
+		fmt.Fprintf(&text, "Thread: %s\n", p.Thread)
+	}
+	text.WriteString("\n")
+	text.WriteString(p.Body)
+	return text.String()
+}
+
+// author names the author of a post by nick and the end of the peer id, since anyone can
+// claim any nick.
+func author(p *boards.Post) string {
+	short := p.Author
+	if len(short) > 8 {
+		short = short[len(short)-8:]
+	}
+	return p.Nick + "#" + short
+}
+
+// abstract is the start of a post's text.
+func abstract(p *boards.Post) string {
+	text := strings.TrimSpace(p.Body)
+	if len(text) > maxAbstract {
+		text = strings.ToValidUTF8(text[:maxAbstract], "") + "..."
+	}
+	return text
+}
@@ -0,0 +1,240 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package gopherbbs
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/rightfoot-consulting/p2pbbs/boards"
+)
+
+var logger = logging.Logger("gopherbbs")
+
+// DefaultAddress is where the server listens when no address is given, gopher's port 70 needs
+// root.
+const DefaultAddress = ":7070"
+
+// RequestTimeout bounds how long a client may take to send its selector.
+const RequestTimeout = 30 * time.Second
+
+// maxRequestLength bounds the request line, selectors are short.
+const maxRequestLength = 1024
+
+// errNotFound is returned for selectors that don't name anything in the store.
+var errNotFound = errors.New("no such item")
+
+// Server is a read only gopher server (RFC 1436) for the posts of a board store.  Boards are
+// directories, threads are menus and posts are text files.  Selectors are built from board
+// names and post content ids so links to them never change.  Gopher+ clients can ask for
+// item attributes.
+type Server struct {
+	store *boards.Store
+	host  string
+	port  int
+	plus  bool
+}
+
+// NewServer returns a server for the posts in store.  host and port are put in menus for
+// clients to connect back to, a port of 0 is taken from the listener.  When plus is set, menus
+// mark their items as Gopher+ items.
+func NewServer(store *boards.Store, host string, port int, plus bool) *Server {
+	return &Server{
+		store: store,
+		host:  host,
+		port:  port,
+		plus:  plus,
+	}
+}
+
+// ListenAndServe accepts connections on address until ctx ends.
+func (s *Server) ListenAndServe(ctx context.Context, address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ctx, listener)
+}
+
+// Serve accepts connections on listener until ctx ends.
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+	if s.port == 0 {
+		if addr, ok := listener.Addr().(*net.TCPAddr); ok {
+			s.port = addr.Port
+		}
+	}
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go s.handleConn(conn)
+	}
+}
+
+// handleConn answers the single request a gopher connection carries.
+func (s *Server) handleConn(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(RequestTimeout))
+	line, err := bufio.NewReader(io.LimitReader(conn, maxRequestLength)).ReadString('\n')
+	if err != nil && line == "" {
+		return
+	}
+	w := bufio.NewWriter(conn)
+	defer w.Flush()
+	selector, plusRequest := parseRequest(line)
+	logger.Debugf("%s requested %q %q", conn.RemoteAddr(), selector, plusRequest)
+	res, err := s.resolve(selector)
+	switch {
+	case plusRequest == "":
+		if err != nil {
+			s.writeError(w, err)
+			return
+		}
+		s.writeResource(w, res)
+	case plusRequest[0] == '+':
+		if err != nil {
+			s.writePlusError(w, err)
+			return
+		}
+		io.WriteString(w, "+-1\r\n")
+		s.writeResource(w, res)
+	case plusRequest[0] == '!':
+		if err != nil {
+			s.writePlusError(w, err)
+			return
+		}
+		io.WriteString(w, "+-1\r\n")
+		s.writeAttributes(w, res.self)
+		io.WriteString(w, ".\r\n")
+	case plusRequest[0] == '$':
+		if err == nil && res.menu == nil {
+			err = errors.New("not a directory")
+		}
+		if err != nil {
+			s.writePlusError(w, err)
+			return
+		}
+		io.WriteString(w, "+-1\r\n")
+		for _, it := range res.menu {
+			if it.kind != typeInfo {
+				s.writeAttributes(w, it)
+			}
+		}
+		io.WriteString(w, ".\r\n")
+	default:
+		s.writePlusError(w, errors.New("unsupported request"))
+	}
+}
+
+// parseRequest splits a request line into the selector and the Gopher+ request type, if any.
+// A search string, which no item here takes, is ignored.
+func parseRequest(line string) (selector string, plusRequest string) {
+	fields := strings.Split(strings.TrimRight(line, "\r\n"), "\t")
+	selector = fields[0]
+	if len(fields) > 1 {
+		last := fields[len(fields)-1]
+		if last != "" && strings.ContainsRune("+!$", rune(last[0])) {
+			plusRequest = last
+		}
+	}
+	return
+}
+
+// writeResource sends a menu or a text file, both ended by a lone dot.
+func (s *Server) writeResource(w io.Writer, res *resource) {
+	if res.menu != nil {
+		for _, it := range res.menu {
+			s.writeItem(w, it)
+		}
+	} else {
+		writeText(w, res.text)
+	}
+	io.WriteString(w, ".\r\n")
+}
+
+// writeItem sends one menu line.
+func (s *Server) writeItem(w io.Writer, it *item) {
+	if it.kind == typeInfo {
+		io.WriteString(w, string(typeInfo)+clean(it.display)+"\t\tnull.host\t1\r\n")
+		return
+	}
+	io.WriteString(w, s.itemLine(it))
+	if s.plus {
+		io.WriteString(w, "\t+")
+	}
+	io.WriteString(w, "\r\n")
+}
+
+// itemLine is the menu line of an item without its line end.
+func (s *Server) itemLine(it *item) string {
+	return string(it.kind) + clean(it.display) + "\t" + it.selector + "\t" + s.host + "\t" + strconv.Itoa(s.port)
+}
+
+// writeAttributes sends the Gopher+ attribute block of an item.
+func (s *Server) writeAttributes(w io.Writer, it *item) {
+	io.WriteString(w, "+INFO: "+s.itemLine(it)+"\t+\r\n")
+	io.WriteString(w, "+ADMIN:\r\n Admin: p2pbbs <"+s.host+">\r\n")
+	if !it.modified.IsZero() {
+		modified := it.modified.UTC()
+		io.WriteString(w, " Mod-Date: "+modified.Format("Mon Jan 2 15:04:05 2006")+" <"+modified.Format("20060102150405")+">\r\n")
+	}
+	if it.kind == typeText {
+		io.WriteString(w, "+VIEWS:\r\n text/plain: <"+strconv.Itoa((it.size+1023)/1024)+"k>\r\n")
+	} else {
+		io.WriteString(w, "+VIEWS:\r\n application/gopher+-menu: <1k>\r\n")
+	}
+	if it.abstract != "" {
+		io.WriteString(w, "+ABSTRACT:\r\n")
+		for _, line := range strings.Split(it.abstract, "\n") {
+			io.WriteString(w, " "+strings.TrimRight(line, "\r")+"\r\n")
+		}
+	}
+}
+
+// writeError sends an error menu, the RFC 1436 way of reporting a bad selector.
+func (s *Server) writeError(w io.Writer, err error) {
+	io.WriteString(w, string(typeError)+clean(err.Error())+"\t\terror.host\t1\r\n.\r\n")
+}
+
+// writePlusError sends a Gopher+ error, code 1 meaning the item isn't available.
+func (s *Server) writePlusError(w io.Writer, err error) {
+	io.WriteString(w, "--1\r\n1 p2pbbs <"+s.host+">\r\n"+clean(err.Error())+"\r\n.\r\n")
+}
+
+// writeText sends a text file with CR LF line ends, doubling the dot that starts a line so it
+// isn't taken for the end of the file.
+func writeText(w io.Writer, text string) {
+	for _, line := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.HasPrefix(line, ".") {
+			line = "." + line
+		}
+		io.WriteString(w, line+"\r\n")
+	}
+}
+
+// clean keeps text from breaking the tab separated fields and lines of a menu.
+func clean(text string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '\t' || r == '\r' || r == '\n' {
+			return ' '
+		}
+		return r
+	}, text)
+}
@@ -0,0 +1,79 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package gopherbbs
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/rightfoot-consulting/p2pbbs/boards"
+)
+
+func request(t *testing.T, address string, line string) string {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, line+"\r\n")
+	data, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestGopherMenus(t *testing.T) {
+	store, err := boards.OpenStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _, _ := crypto.GenerateEd25519Key(nil)
+	root, _ := boards.NewPost(key, "alice", "general", "Hello", "first line\n.dotted line", nil)
+	reply, _ := boards.NewPost(key, "bob", "", "", "welcome", root)
+	for _, p := range []*boards.Post{root, reply} {
+		if _, err = store.Add(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go NewServer(store, "gopher.test", 70, false).Serve(ctx, listener)
+	address := listener.Addr().String()
+
+	if menu := request(t, address, ""); !strings.Contains(menu, "1general (1 threads)\t/board/general\tgopher.test\t70\r\n") {
+		t.Errorf("root menu missing the board:\n%s", menu)
+	}
+	if menu := request(t, address, "/board/general"); !strings.Contains(menu, "\t/thread/"+root.ID+"\t") {
+		t.Errorf("board menu missing the thread:\n%s", menu)
+	}
+	menu := request(t, address, "/thread/"+root.ID)
+	if !strings.Contains(menu, "\t/post/"+root.ID+"\t") || !strings.Contains(menu, "0  Re: Hello") {
+		t.Errorf("thread menu missing posts:\n%s", menu)
+	}
+	text := request(t, address, "/post/"+root.ID)
+	if !strings.Contains(text, "Post-ID: "+root.ID+"\r\n") || !strings.HasSuffix(text, "first line\r\n..dotted line\r\n.\r\n") {
+		t.Errorf("unexpected post:\n%s", text)
+	}
+	if menu := request(t, address, "/post/missing"); !strings.HasPrefix(menu, "3") {
+		t.Errorf("expected an error item, got:\n%s", menu)
+	}
+
+	attributes := request(t, address, "/post/"+reply.ID+"\t!")
+	if !strings.HasPrefix(attributes, "+-1\r\n+INFO: 0Re: Hello\t/post/"+reply.ID) || !strings.Contains(attributes, "+ABSTRACT:\r\n welcome\r\n") {
+		t.Errorf("unexpected attributes:\n%s", attributes)
+	}
+	if plus := request(t, address, "/nowhere\t+"); !strings.HasPrefix(plus, "--1\r\n1 ") {
+		t.Errorf("expected a Gopher+ error, got:\n%s", plus)
+	}
+}
@@ -0,0 +1,152 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package headless
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Command types read from the input.
+const (
+	// Send publishes Text, to Room or to every connected peer.
+	Send = "send"
+	// Join joins Room, or connects to the peer address in Peer.
+	Join = "join"
+	// DM sends Text to the single peer named by Peer.
+	DM = "dm"
+	// SetStatus sets our Status in Room, or in every joined room when Room is empty, with Text
+	// as its custom text.
+	SetStatus = "status"
+)
+
+// Event types written to the output.
+const (
+	// Message is a message from From, to Room or directly to us when Room is empty.
+	Message = "message"
+	// PeerJoined reports that Peer joined Room, or connected when Room is empty.
+	PeerJoined = "peer-joined"
+	// PeerLeft reports that Peer left Room, or disconnected when Room is empty.
+	PeerLeft = "peer-left"
+	// Presence reports a user of Room joining, leaving, changing their Status or typing, as
+	// Change says.
+	Presence = "presence"
+	// Error reports a command that failed, or a problem of the node.
+	Error = "error"
+)
+
+// MaxLineLength bounds a command line.
+const MaxLineLength = 64 << 10
+
+// Command is a line of input.  Scripts and supervisors drive a headless node by writing
+// commands, one JSON object per line, and reading back events the same way:
+//
+//	{"type":"join","room":"lobby"}
+//	{"type":"send","room":"lobby","text":"hello"}
+//	{"type":"send","room":"lobby","text":"+1","reaction":"9f2c41d07a3e5b86"}
+//	{"type":"dm","peer":"12D3KooW...","text":"hello"}
+//	{"type":"status","status":"away","text":"lunch"}
+//
+//	{"type":"message","time":"...","from":"12D3KooW...","nick":"bob","room":"lobby","text":"hi"}
+//	{"type":"peer-joined","time":"...","room":"lobby","peer":"12D3KooW..."}
+//	{"type":"presence","time":"...","from":"12D3KooW...","nick":"bob","room":"lobby","change":"typing","status":"online","typing":true}
+//	{"type":"error","time":"...","error":"dm: no peer named carol"}
+//
+// A send to a room may name the id of the message, and refer to an earlier message by its id
+// in one of reply_to, reaction, edits and deletes, as the events of a room report them.
+type Command struct {
+	Type     string `json:"type"`
+	Room     string `json:"room,omitempty"`
+	Peer     string `json:"peer,omitempty"`
+	Text     string `json:"text,omitempty"`
+	ID       string `json:"id,omitempty"`
+	ReplyTo  string `json:"reply_to,omitempty"`
+	Reaction string `json:"reaction,omitempty"`
+	Edits    string `json:"edits,omitempty"`
+	Deletes  string `json:"deletes,omitempty"`
+	Status   string `json:"status,omitempty"`
+}
+
+// Event is a line of output.  From is the peer id proven by the transport or the signature of
+// the message, Nick only what the sender claims.
+type Event struct {
+	Type  string    `json:"type"`
+	Time  time.Time `json:"time"`
+	From  string    `json:"from,omitempty"`
+	Nick  string    `json:"nick,omitempty"`
+	Trust string    `json:"trust,omitempty"`
+	Room  string    `json:"room,omitempty"`
+	Peer  string    `json:"peer,omitempty"`
+	Text  string    `json:"text,omitempty"`
+	Error string    `json:"error,omitempty"`
+	// the id of a message and the earlier message it refers to, when its sender gave them
+	ID       string `json:"id,omitempty"`
+	ReplyTo  string `json:"reply_to,omitempty"`
+	Reaction string `json:"reaction,omitempty"`
+	Edits    string `json:"edits,omitempty"`
+	Deletes  string `json:"deletes,omitempty"`
+	// the presence of a user: what changed, their status and whether they are typing
+	Change string `json:"change,omitempty"`
+	Status string `json:"status,omitempty"`
+	Typing bool   `json:"typing,omitempty"`
+}
+
+// Writer writes events as JSON lines.  It is safe for concurrent use.
+type Writer struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+}
+
+// NewWriter returns a writer of events to w.
+func NewWriter(w io.Writer) *Writer {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	return &Writer{encoder: encoder}
+}
+
+// Write writes an event, stamping it with the current time when it has none.
+func (w *Writer) Write(e *Event) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.encoder.Encode(e)
+}
+
+// Errorf writes an error event.
+func (w *Writer) Errorf(format string, args ...interface{}) {
+	w.Write(&Event{Type: Error, Error: fmt.Sprintf(format, args...)})
+}
+
+// ReadCommands reads commands from r until it ends and passes them to handle.  Lines that
+// aren't commands and commands handle fails are reported as error events on w, so one bad
+// line doesn't stop a script.
+func ReadCommands(r io.Reader, w *Writer, handle func(c *Command) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 4096), MaxLineLength)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		c := new(Command)
+		if err := json.Unmarshal(line, c); err != nil {
+			w.Errorf("not a command: %v", err)
+			continue
+		}
+		if err := handle(c); err != nil {
+			w.Errorf("%s: %v", c.Type, err)
+		}
+	}
+	if errors.Is(scanner.Err(), bufio.ErrTooLong) {
+		return fmt.Errorf("commands are limited to %d bytes", MaxLineLength)
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,75 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package headless
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestReadCommands(t *testing.T) {
+	input := strings.Join([]string{
+		`{"type":"join","room":"lobby"}`,
+		``,
+		`not json`,
+		`{"type":"send","room":"lobby","text":"hello <world>"}`,
+		`{"type":"dm","peer":"carol","text":"hi"}`,
+	}, "\n")
+	var output bytes.Buffer
+	w := NewWriter(&output)
+	var handled []*Command
+	err := ReadCommands(strings.NewReader(input), w, func(c *Command) error {
+		handled = append(handled, c)
+		if c.Type == DM {
+			return fmt.Errorf("no peer named %s", c.Peer)
+		}
+		return w.Write(&Event{Type: Message, From: "12D3KooW", Room: c.Room, Text: c.Text})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(handled) != 3 || handled[1].Text != "hello <world>" {
+		t.Fatalf("unexpected commands %+v", handled)
+	}
+
+	var events []*Event
+	scanner := bufio.NewScanner(&output)
+	for scanner.Scan() {
+		if bytes.Contains(scanner.Bytes(), []byte(`\u003c`)) {
+			t.Errorf("html escaped output: %s", scanner.Text())
+		}
+		e := new(Event)
+		if err := json.Unmarshal(scanner.Bytes(), e); err != nil {
+			t.Fatalf("%v: %s", err, scanner.Text())
+		}
+		if e.Time.IsZero() {
+			t.Errorf("event without a time: %s", scanner.Text())
+		}
+		events = append(events, e)
+	}
+	want := []string{Message, Error, Message, Error}
+	if len(events) != len(want) {
+		t.Fatalf("got %d events, want %d", len(events), len(want))
+	}
+	for i, e := range events {
+		if e.Type != want[i] {
+			t.Errorf("event %d is %s, want %s", i, e.Type, want[i])
+		}
+	}
+	if events[3].Error != "dm: no peer named carol" {
+		t.Errorf("unexpected error %q", events[3].Error)
+	}
+}
+
+func TestLongLine(t *testing.T) {
+	input := `{"type":"send","text":"` + strings.Repeat("x", MaxLineLength) + `"}`
+	err := ReadCommands(strings.NewReader(input), NewWriter(&bytes.Buffer{}), func(c *Command) error { return nil })
+	if err == nil {
+		t.Error("accepted a line longer than MaxLineLength")
+	}
+}
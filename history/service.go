@@ -0,0 +1,173 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package history
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/rightfoot-consulting/p2pbbs/boards"
+	"github.com/rightfoot-consulting/p2pbbs/moderation"
+)
+
+var logger = logging.Logger("history")
+
+// ProtocolID is the libp2p protocol history is synced over.
+const ProtocolID = protocol.ID("/p2pbbs/history/1.0.0")
+
+const (
+	// MaxRequestBytes bounds the request of a peer.
+	MaxRequestBytes = 64 << 10
+	// MaxResponseBytes bounds how much a peer may send us in one sync.
+	MaxResponseBytes = 32 << 20
+	// MaxPostsPerBoard is how many of the newest posts of a board are sent in one sync.
+	MaxPostsPerBoard = 500
+	// SyncTimeout bounds a single sync with a peer.
+	SyncTimeout = time.Minute
+)
+
+// request names the boards a peer wants the history of.
+type request struct {
+	Boards []string `json:"boards"`
+}
+
+// response is the history of the requested boards, with every moderation record so actions
+// reach nodes that were offline when they were published.
+type response struct {
+	Moderation *moderation.Records `json:"moderation"`
+	Posts      []*boards.Post      `json:"posts"`
+}
+
+// Service catches a node up with what it missed while offline: when a peer connects we ask it
+// for the moderation records it holds and the recent posts of our boards.  Moderation records
+// are applied first, so deleted posts and posts by banned peers are refused as they arrive.
+type Service struct {
+	host       host.Host
+	boards     *boards.Service
+	moderation *moderation.Store
+
+	mu     sync.Mutex
+	synced map[peer.ID]bool
+}
+
+// NewService registers the sync protocol on h, keeping what peers send in the stores of
+// boardService and moderationStore.
+func NewService(h host.Host, boardService *boards.Service, moderationStore *moderation.Store) *Service {
+	s := &Service{
+		host:       h,
+		boards:     boardService,
+		moderation: moderationStore,
+		synced:     make(map[peer.ID]bool),
+	}
+	h.SetStreamHandler(ProtocolID, s.handleStream)
+	return s
+}
+
+// Sync asks p for the history of our boards and keeps what verifies.
+func (s *Service) Sync(ctx context.Context, p peer.ID) (added int, err error) {
+	ctx, cancel := context.WithTimeout(ctx, SyncTimeout)
+	defer cancel()
+	stream, err := s.host.NewStream(ctx, p, ProtocolID)
+	if err != nil {
+		return
+	}
+	defer stream.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		stream.SetDeadline(deadline)
+	}
+	if err = json.NewEncoder(stream).Encode(&request{Boards: s.boards.Boards()}); err != nil {
+		stream.Reset()
+		return
+	}
+	if err = stream.CloseWrite(); err != nil {
+		return
+	}
+	resp := new(response)
+	if err = json.NewDecoder(io.LimitReader(stream, MaxResponseBytes)).Decode(resp); err != nil {
+		return
+	}
+	return s.merge(resp)
+}
+
+// SyncOnce syncs in the background with every peer we haven't synced with yet during this
+// session.
+func (s *Service) SyncOnce(ctx context.Context, peers []peer.ID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range peers {
+		if s.synced[p] || p == s.host.ID() {
+			continue
+		}
+		s.synced[p] = true
+		go func(p peer.ID) {
+			added, err := s.Sync(ctx, p)
+			if err != nil {
+				logger.Debugf("history sync with %s failed: %v", p, err)
+				return
+			}
+			logger.Debugf("received %d new records from %s", added, p)
+		}(p)
+	}
+}
+
+// SyncOnConnect syncs with every peer the first time it connects, until ctx ends.
+func (s *Service) SyncOnConnect(ctx context.Context) {
+	notifee := &network.NotifyBundle{
+		ConnectedF: func(_ network.Network, conn network.Conn) {
+			s.SyncOnce(ctx, []peer.ID{conn.RemotePeer()})
+		},
+	}
+	s.host.Network().Notify(notifee)
+	context.AfterFunc(ctx, func() { s.host.Network().StopNotify(notifee) })
+}
+
+func (s *Service) handleStream(stream network.Stream) {
+	defer stream.Close()
+	stream.SetDeadline(time.Now().Add(SyncTimeout))
+	req := new(request)
+	if err := json.NewDecoder(io.LimitReader(stream, MaxRequestBytes)).Decode(req); err != nil {
+		logger.Debugf("bad history request from %s: %v", stream.Conn().RemotePeer(), err)
+		stream.Reset()
+		return
+	}
+	resp := &response{Moderation: s.moderation.All(), Posts: make([]*boards.Post, 0)}
+	store := s.boards.Store()
+	for _, board := range req.Boards {
+		if !boards.ValidBoardName(board) {
+			continue
+		}
+		posts := store.Posts(board)
+		if len(posts) > MaxPostsPerBoard {
+			posts = posts[len(posts)-MaxPostsPerBoard:]
+		}
+		resp.Posts = append(resp.Posts, posts...)
+	}
+	if err := json.NewEncoder(stream).Encode(resp); err != nil {
+		stream.Reset()
+	}
+}
+
+// merge keeps the records of a response that verify, moderation first.
+func (s *Service) merge(resp *response) (added int, err error) {
+	if resp.Moderation != nil {
+		if added, err = s.moderation.AddAll(resp.Moderation); err != nil {
+			return
+		}
+	}
+	store := s.boards.Store()
+	for _, p := range resp.Posts {
+		if ok, err := store.Add(p); ok && err == nil {
+			added++
+		}
+	}
+	return
+}
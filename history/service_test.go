@@ -0,0 +1,103 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package history
+
+import (
+	"context"
+	"crypto/rand"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/rightfoot-consulting/p2pbbs/boards"
+	"github.com/rightfoot-consulting/p2pbbs/moderation"
+)
+
+type testNode struct {
+	history    *Service
+	boards     *boards.Service
+	moderation *moderation.Store
+}
+
+// newTestNode starts a node that pins the owners of the scopes in owners.
+func newTestNode(t *testing.T, ctx context.Context, owners map[string]peer.ID) *testNode {
+	t.Helper()
+	h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { h.Close() })
+	ps, err := pubsub.NewGossipSub(ctx, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	store, err := boards.OpenStore(filepath.Join(dir, boards.StoreDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decisions, err := moderation.LoadStore(filepath.Join(dir, moderation.StoreFile), owners)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Moderate(decisions)
+	node := &testNode{boards: boards.NewService(ctx, ps, store), moderation: decisions}
+	if err = node.boards.Join("general"); err != nil {
+		t.Fatal(err)
+	}
+	node.history = NewService(h, node.boards, decisions)
+	return node
+}
+
+func TestSync(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	owner, _, _ := crypto.GenerateEd25519Key(rand.Reader)
+	ownerID, _ := peer.IDFromPrivateKey(owner)
+	scope := moderation.BoardScope("general")
+	owners := map[string]peer.ID{scope: ownerID}
+	alice, bob := newTestNode(t, ctx, owners), newTestNode(t, ctx, owners)
+
+	spammer, _, _ := crypto.GenerateEd25519Key(rand.Reader)
+	spammerID, _ := peer.IDFromPrivateKey(spammer)
+	welcome, _ := boards.NewPost(owner, "owner", "general", "Welcome", "hello", nil)
+	spam, _ := boards.NewPost(spammer, "spammer", "general", "Buy now", "cheap", nil)
+	for _, p := range []*boards.Post{welcome, spam} {
+		if _, err := alice.boards.Store().Add(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	manifest, _ := moderation.NewManifest(owner, scope, nil, nil)
+	ban, _ := moderation.NewAction(owner, scope, moderation.Ban, spammerID.String(), "", 0, "spam")
+	if _, err := alice.moderation.AddManifest(manifest); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := alice.moderation.AddAction(ban); err != nil {
+		t.Fatal(err)
+	}
+
+	aliceHost := alice.history.host
+	bob.history.host.Peerstore().AddAddrs(aliceHost.ID(), aliceHost.Addrs(), time.Hour)
+	added, err := bob.history.Sync(ctx, aliceHost.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the manifest, the ban and the welcome post, the ban arriving first keeps the spam out
+	if added != 3 {
+		t.Errorf("synced %d records", added)
+	}
+	if _, ok := bob.boards.Store().Get(welcome.ID); !ok {
+		t.Error("the welcome post wasn't synced")
+	}
+	if _, ok := bob.boards.Store().Get(spam.ID); ok {
+		t.Error("a post by a banned peer was synced")
+	}
+	if !bob.moderation.View(scope).Banned(spammerID) {
+		t.Error("the ban wasn't synced")
+	}
+}
@@ -0,0 +1,171 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package httpbbs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/rightfoot-consulting/p2pbbs/archive"
+	"github.com/rightfoot-consulting/p2pbbs/boards"
+)
+
+var logger = logging.Logger("httpbbs")
+
+// DefaultAddress is where the server listens when no address is given.
+const DefaultAddress = "127.0.0.1:8080"
+
+// APIPrefix starts the paths of the JSON API.
+const APIPrefix = "/api/"
+
+// Server serves the boards read only over HTTP: the pages and feeds of the archive, and under
+// /api/ the signed posts as JSON so mirrors can check them.  Only the boards the site shows
+// are served.
+//
+//	/api/boards            the boards with their thread and post counts
+//	/api/boards/<name>     the threads of a board, most recently active first
+//	/api/threads/<id>      the posts of a thread, oldest first
+//	/api/posts/<id>        a post
+type Server struct {
+	store *boards.Store
+	site  *archive.Site
+}
+
+// NewServer returns a server for the posts in store, rendered by site.
+func NewServer(store *boards.Store, site *archive.Site) *Server {
+	return &Server{store: store, site: site}
+}
+
+// ListenAndServe accepts connections on address until ctx ends.
+func (s *Server) ListenAndServe(ctx context.Context, address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ctx, listener)
+}
+
+// Serve accepts connections on listener until ctx ends.
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+	server := &http.Server{Handler: s, ReadHeaderTimeout: 30 * time.Second}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	if err := server.Serve(listener); err != nil && ctx.Err() == nil {
+		return err
+	}
+	return nil
+}
+
+// ServeHTTP answers a request for a page, a feed or the API.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "the boards are read only", http.StatusMethodNotAllowed)
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, APIPrefix) {
+		s.serveAPI(w, r, strings.TrimPrefix(r.URL.Path, APIPrefix))
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	if path == "" || strings.HasSuffix(path, "/") {
+		path += "index.html"
+	}
+	site := s.site
+	if site.BaseURL() == "" {
+		// feed readers need absolute links, take them from the address the client used
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		site = site.WithBaseURL(scheme + "://" + r.Host + "/")
+	}
+	var page bytes.Buffer
+	contentType, err := site.Render(&page, path)
+	if errors.Is(err, archive.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		logger.Errorf("rendering %s: %v", path, err)
+		http.Error(w, "the page could not be rendered", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Write(page.Bytes())
+}
+
+// boardSummary describes a board in the API.
+type boardSummary struct {
+	Name    string `json:"name"`
+	Threads int    `json:"threads"`
+	Posts   int    `json:"posts"`
+}
+
+// threadSummary describes a thread in the API.
+type threadSummary struct {
+	ID       string    `json:"id"`
+	Subject  string    `json:"subject"`
+	Author   string    `json:"author"`
+	Nick     string    `json:"nick"`
+	Created  time.Time `json:"created"`
+	Replies  int       `json:"replies"`
+	LastPost time.Time `json:"last_post"`
+}
+
+// serveAPI answers a request under APIPrefix.
+func (s *Server) serveAPI(w http.ResponseWriter, r *http.Request, path string) {
+	kind, arg, _ := strings.Cut(path, "/")
+	var result interface{}
+	switch {
+	case kind == "boards" && arg == "":
+		summaries := make([]*boardSummary, 0)
+		for _, board := range s.store.Boards() {
+			if !s.site.Shows(board) {
+				continue
+			}
+			summaries = append(summaries, &boardSummary{Name: board, Threads: len(s.store.Threads(board)), Posts: len(s.store.Posts(board))})
+		}
+		result = summaries
+	case kind == "boards" && boards.ValidBoardName(arg) && s.site.Shows(arg):
+		summaries := make([]*threadSummary, 0)
+		for _, t := range s.store.Threads(arg) {
+			summaries = append(summaries, &threadSummary{
+				ID:       t.Root.ID,
+				Subject:  t.Root.Subject,
+				Author:   t.Root.Author,
+				Nick:     t.Root.Nick,
+				Created:  t.Root.Created,
+				Replies:  t.Replies,
+				LastPost: t.LastPost,
+			})
+		}
+		result = summaries
+	case kind == "threads" && arg != "":
+		if posts := s.store.Thread(arg); len(posts) > 0 && posts[0].IsThread() && s.site.Shows(posts[0].Board) {
+			result = posts
+		}
+	case kind == "posts" && arg != "":
+		if p, ok := s.store.Get(arg); ok && s.site.Shows(p.Board) {
+			result = p
+		}
+	}
+	if result == nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "    ")
+	encoder.Encode(result)
+}
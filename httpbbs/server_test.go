@@ -0,0 +1,77 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package httpbbs
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/rightfoot-consulting/p2pbbs/archive"
+	"github.com/rightfoot-consulting/p2pbbs/boards"
+)
+
+func TestServer(t *testing.T) {
+	store, err := boards.OpenStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _, _ := crypto.GenerateEd25519Key(nil)
+	root, _ := boards.NewPost(key, "alice", "general", "Hello", "first", nil)
+	store.Add(root)
+	server := httptest.NewServer(NewServer(store, archive.NewSite(store, "Test BBS", "")))
+	defer server.Close()
+
+	for path, want := range map[string]string{
+		"/":                            "text/html",
+		"/board/general/":              "text/html",
+		"/board/general/atom.xml":      "application/atom+xml",
+		"/thread/" + root.ID + ".html": "text/html",
+		"/api/boards":                  "application/json",
+	} {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), want) {
+			t.Errorf("%s: %s %s", path, resp.Status, resp.Header.Get("Content-Type"))
+		}
+	}
+
+	resp, err := http.Get(server.URL + "/api/posts/" + root.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var p boards.Post
+	if err = json.NewDecoder(resp.Body).Decode(&p); err != nil {
+		t.Fatal(err)
+	}
+	if err = p.Verify(); err != nil {
+		t.Errorf("served post doesn't verify: %v", err)
+	}
+
+	for _, path := range []string{"/post/unknown.html", "/api/threads/unknown", "/board/general/feed.json"} {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("%s: %s", path, resp.Status)
+		}
+	}
+	resp, err = http.Post(server.URL+"/api/boards", "application/json", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("POST answered with %s", resp.Status)
+	}
+}
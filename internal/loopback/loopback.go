@@ -0,0 +1,25 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+// Package loopback opens the listeners of the servers that speak for the node without asking
+// their clients who they are.  Whoever can connect to them can act as the node, so they only
+// listen on the loopback interface.
+package loopback
+
+import (
+	"fmt"
+	"net"
+)
+
+// Listen listens on address, refusing any address that isn't on the loopback interface.  name
+// names the server in the error.
+func Listen(name string, address string) (net.Listener, error) {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, fmt.Errorf("the %s only listens on the loopback interface, not %s", name, host)
+	}
+	return net.Listen("tcp", address)
+}
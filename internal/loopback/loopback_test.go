@@ -0,0 +1,23 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package loopback
+
+import "testing"
+
+func TestListen(t *testing.T) {
+	for _, address := range []string{"127.0.0.1:0", "[::1]:0", "localhost:0"} {
+		listener, err := Listen("server", address)
+		if err != nil {
+			t.Logf("%s: %v", address, err)
+			continue
+		}
+		listener.Close()
+	}
+	for _, address := range []string{":0", "0.0.0.0:0", "[::]:0", "192.0.2.1:0", "example.com:0", "127.0.0.1"} {
+		if listener, err := Listen("server", address); err == nil {
+			listener.Close()
+			t.Errorf("listened on %s", address)
+		}
+	}
+}
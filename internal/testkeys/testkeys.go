@@ -0,0 +1,33 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+// Package testkeys makes the throwaway identities tests sign records with.
+package testkeys
+
+import (
+	"crypto/rand"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Identity is an ed25519 key and the peer id it gives.
+type Identity struct {
+	Key crypto.PrivKey
+	ID  peer.ID
+}
+
+// New returns a fresh identity, failing the test when none can be made.
+func New(t testing.TB) Identity {
+	t.Helper()
+	sk, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := peer.IDFromPrivateKey(sk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Identity{Key: sk, ID: id}
+}
@@ -0,0 +1,291 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package invite
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	maddr "github.com/multiformats/go-multiaddr"
+	"github.com/rightfoot-consulting/p2pbbs/bbscrypto"
+	"github.com/rightfoot-consulting/p2pbbs/chat"
+	"github.com/rightfoot-consulting/p2pbbs/moderation"
+	"github.com/rightfoot-consulting/p2pbbs/private"
+)
+
+const (
+	// Prefix starts every invite code, so codes pasted in the wrong place are recognised.
+	Prefix = "p2pbbs-invite:"
+	// MaxBytes bounds the decoded size of an invite.
+	MaxBytes = 64 << 10
+	// MaxPeers bounds the peer addresses an invite carries.
+	MaxPeers = 32
+)
+
+var (
+	// ErrExpired is returned for invites past their expiry.
+	ErrExpired = errors.New("the invite has expired")
+	// ErrNotForUs is returned when an invite made out to another peer is accepted.
+	ErrNotForUs = errors.New("the invite is for another peer")
+)
+
+// Invite tells a new peer how to join: the bootstrap peers to dial, the rendezvous string and
+// room to use, the owners pinned for boards and rooms and, for private swarms and rooms, their
+// keys.  It is signed by the peer who made it and expires.  An invite made out to a peer with To can only be accepted by that peer, and
+// must be when it carries a private room.
+type Invite struct {
+	From       string              `json:"from"`
+	To         string              `json:"to,omitempty"`
+	Peers      []string            `json:"peers"`
+	Rendezvous string              `json:"rendezvous,omitempty"`
+	Room       string              `json:"room,omitempty"`
+	SwarmKey   string              `json:"swarm_key,omitempty"`
+	Owners     map[string]string   `json:"owners,omitempty"`
+	Private    *private.Membership `json:"private,omitempty"`
+	Created    time.Time           `json:"created"`
+	Expires    time.Time           `json:"expires"`
+	PublicKey  []byte              `json:"public_key,omitempty"`
+	Signature  []byte              `json:"signature,omitempty"`
+}
+
+// Sign dates the invite, lets it expire after valid, and signs it as the peer of privateKey.
+func (inv *Invite) Sign(privateKey crypto.PrivKey, valid time.Duration) (err error) {
+	from, err := peer.IDFromPrivateKey(privateKey)
+	if err != nil {
+		return
+	}
+	inv.From = from.String()
+	inv.Created = time.Now().UTC()
+	inv.Expires = inv.Created.Add(valid)
+	inv.PublicKey, err = bbscrypto.VerificationKey(privateKey)
+	if err != nil {
+		return
+	}
+	inv.Signature = nil
+	data, err := inv.signingBytes()
+	if err != nil {
+		return
+	}
+	inv.Signature, err = privateKey.Sign(data)
+	if err != nil {
+		return
+	}
+	return inv.Verify()
+}
+
+// Verify checks that the invite is well formed, signed by its author and not expired.
+func (inv *Invite) Verify() (err error) {
+	from, err := peer.Decode(inv.From)
+	if err != nil {
+		return fmt.Errorf("invalid author %q: %w", inv.From, err)
+	}
+	var to peer.ID
+	if inv.To != "" {
+		if to, err = peer.Decode(inv.To); err != nil {
+			return fmt.Errorf("invalid recipient %q: %w", inv.To, err)
+		}
+	}
+	if len(inv.Peers) > MaxPeers {
+		return fmt.Errorf("%d peers, the limit is %d", len(inv.Peers), MaxPeers)
+	}
+	if _, err = inv.PeerAddresses(); err != nil {
+		return
+	}
+	if inv.SwarmKey != "" {
+		if _, err = chat.DecodeSwarmKey(inv.SwarmKey); err != nil {
+			return
+		}
+	}
+	if len(inv.Owners) > moderation.MaxScopes {
+		return fmt.Errorf("%d owners, the limit is %d", len(inv.Owners), moderation.MaxScopes)
+	}
+	for scope, owner := range inv.Owners {
+		if !moderation.ValidScope(scope) {
+			return fmt.Errorf("invalid scope %q", scope)
+		}
+		if _, err = peer.Decode(owner); err != nil {
+			return fmt.Errorf("invalid owner of %s: %w", scope, err)
+		}
+	}
+	if inv.Private != nil {
+		if err = inv.Private.Verify(); err != nil {
+			return fmt.Errorf("private room: %w", err)
+		}
+		if inv.Room != inv.Private.Name {
+			return fmt.Errorf("the invite is to %q but carries the private room %q", inv.Room, inv.Private.Name)
+		}
+		if to == "" || !inv.Private.IsMember(to) {
+			return fmt.Errorf("the invite carries a private room its recipient isn't a member of")
+		}
+	}
+	data, err := inv.signingBytes()
+	if err != nil {
+		return
+	}
+	if err = bbscrypto.Verify(from, inv.PublicKey, data, inv.Signature); err != nil {
+		return
+	}
+	if !inv.Expires.After(inv.Created) {
+		return fmt.Errorf("the invite expires before it was made")
+	}
+	if time.Now().After(inv.Expires) {
+		return ErrExpired
+	}
+	return
+}
+
+// IsFor reports whether the invite can be accepted by id.
+func (inv *Invite) IsFor(id peer.ID) bool {
+	return inv.To == "" || inv.To == id.String()
+}
+
+// PeerAddresses decodes the addresses of the peers to dial.
+func (inv *Invite) PeerAddresses() (addrs []maddr.Multiaddr, err error) {
+	for _, s := range inv.Peers {
+		addr, err := maddr.NewMultiaddr(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid peer address %q: %w", s, err)
+		}
+		addrs = append(addrs, addr)
+	}
+	return
+}
+
+// Code encodes the invite as a single line of text: the Prefix and the deflated JSON of the
+// invite in URL safe base64.
+func (inv *Invite) Code() (code string, err error) {
+	data, err := json.Marshal(inv)
+	if err != nil {
+		return
+	}
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.BestCompression)
+	if err != nil {
+		return
+	}
+	if _, err = w.Write(data); err != nil {
+		return
+	}
+	if err = w.Close(); err != nil {
+		return
+	}
+	code = Prefix + base64.RawURLEncoding.EncodeToString(buf.Bytes())
+	return
+}
+
+// Parse decodes and verifies an invite code.
+func Parse(code string) (inv *Invite, err error) {
+	code, ok := strings.CutPrefix(strings.TrimSpace(code), Prefix)
+	if !ok {
+		return nil, fmt.Errorf("not an invite, invites start with %s", Prefix)
+	}
+	compressed, err := base64.RawURLEncoding.DecodeString(code)
+	if err != nil {
+		return nil, fmt.Errorf("invalid invite: %w", err)
+	}
+	data, err := io.ReadAll(io.LimitReader(flate.NewReader(bytes.NewReader(compressed)), MaxBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("invalid invite: %w", err)
+	}
+	if len(data) > MaxBytes {
+		return nil, fmt.Errorf("invalid invite: more than %d bytes", MaxBytes)
+	}
+	inv = new(Invite)
+	if err = json.Unmarshal(data, inv); err != nil {
+		return nil, fmt.Errorf("invalid invite: %w", err)
+	}
+	if err = inv.Verify(); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Merge writes the invite into the configuration file, creating it if needed.  The peers are
+// added to the bootstrap peers, the owners to the pinned owners, without replacing the owner the
+// file already pins for a scope, the rendezvous string, room and swarm key replace those of the
+// file and everything else in it is kept.  The file is only readable by its owner, as it may
+// hold a swarm key.
+func (inv *Invite) Merge(file string) (err error) {
+	config := make(map[string]json.RawMessage)
+	data, err := os.ReadFile(file)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		err = nil
+	case err != nil:
+		return
+	default:
+		if err = json.Unmarshal(data, &config); err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+	}
+	var peers []string
+	if raw, ok := config["bootstrap_peers"]; ok {
+		if err = json.Unmarshal(raw, &peers); err != nil {
+			return fmt.Errorf("%s: bootstrap_peers: %w", file, err)
+		}
+	}
+	for _, p := range inv.Peers {
+		if !slices.Contains(peers, p) {
+			peers = append(peers, p)
+		}
+	}
+	set := func(name string, value any) {
+		if err == nil {
+			config[name], err = json.Marshal(value)
+		}
+	}
+	set("bootstrap_peers", peers)
+	if len(inv.Owners) > 0 {
+		owners := make(map[string]string)
+		if raw, ok := config["owners"]; ok {
+			if err = json.Unmarshal(raw, &owners); err != nil {
+				return fmt.Errorf("%s: owners: %w", file, err)
+			}
+		}
+		for scope, owner := range inv.Owners {
+			if _, ok := owners[scope]; !ok {
+				owners[scope] = owner
+			}
+		}
+		set("owners", owners)
+	}
+	if inv.Rendezvous != "" {
+		set("rendezvous_string", inv.Rendezvous)
+	}
+	if inv.Room != "" {
+		set("room", inv.Room)
+	}
+	if inv.SwarmKey != "" {
+		set("swarm_key", inv.SwarmKey)
+	}
+	if err != nil {
+		return
+	}
+	data, err = json.MarshalIndent(config, "", "    ")
+	if err != nil {
+		return
+	}
+	if err = os.WriteFile(file, data, 0600); err != nil {
+		return
+	}
+	return os.Chmod(file, 0600)
+}
+
+// signingBytes is the JSON encoding of the invite without its signature.
+func (inv *Invite) signingBytes() ([]byte, error) {
+	unsigned := *inv
+	unsigned.Signature = nil
+	return json.Marshal(&unsigned)
+}
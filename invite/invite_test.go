@@ -0,0 +1,145 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package invite
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/rightfoot-consulting/p2pbbs/chat"
+	"github.com/rightfoot-consulting/p2pbbs/private"
+)
+
+const bootstrapPeer = "/ip4/127.0.0.1/tcp/4001/p2p/12D3KooWQYhTNQdmr3ArTeUHRYzFg94BKyTkoWBDWez9kSCVe2Xo"
+
+func newKey(t *testing.T) (crypto.PrivKey, peer.ID) {
+	t.Helper()
+	sk, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := peer.IDFromPrivateKey(sk)
+	return sk, id
+}
+
+func TestInvite(t *testing.T) {
+	aliceKey, _ := newKey(t)
+	bobKey, bob := newKey(t)
+	_, carol := newKey(t)
+	swarmKey, err := chat.NewSwarmKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	room, err := private.NewRoom(aliceKey, "ops", []peer.ID{bob})
+	if err != nil {
+		t.Fatal(err)
+	}
+	inv := &Invite{To: bob.String(), Peers: []string{bootstrapPeer}, Rendezvous: "meet", Room: "ops", SwarmKey: swarmKey, Private: room}
+	if err = inv.Sign(aliceKey, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	code, err := inv.Code()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(code, Prefix) || strings.ContainsAny(code, " \n+/=") {
+		t.Errorf("the code doesn't fit on a line: %s", code)
+	}
+	accepted, err := Parse(" " + code + "\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !accepted.IsFor(bob) || accepted.IsFor(carol) {
+		t.Error("the invite was made out to the wrong peer")
+	}
+	if accepted.SwarmKey != swarmKey || accepted.Private.Room != room.Room {
+		t.Errorf("decoded %+v", accepted)
+	}
+	if _, err = accepted.Private.Key(bobKey); err != nil {
+		t.Errorf("bob can't open the room key: %v", err)
+	}
+
+	// tampering breaks the signature, private rooms need a recipient among their members
+	forged := *accepted
+	forged.Peers = []string{"/ip4/10.0.0.1/tcp/4001/p2p/" + carol.String()}
+	if forged.Verify() == nil {
+		t.Error("a tampered invite verified")
+	}
+	forged = *accepted
+	forged.To = carol.String()
+	if err = forged.Sign(aliceKey, time.Hour); err == nil {
+		t.Error("signed a private room to a peer who isn't a member")
+	}
+	if _, err = Parse(strings.TrimPrefix(code, Prefix)); err == nil {
+		t.Error("parsed a code without its prefix")
+	}
+	expired := &Invite{Peers: []string{bootstrapPeer}, Room: "lobby"}
+	if err = expired.Sign(aliceKey, -time.Minute); err == nil {
+		t.Error("signed an invite that expires before it was made")
+	}
+	expired.Created = time.Now().Add(-2 * time.Hour)
+	expired.Expires = expired.Created.Add(time.Hour)
+	expired.Signature = nil
+	data, _ := expired.signingBytes()
+	expired.Signature, _ = aliceKey.Sign(data)
+	if err = expired.Verify(); err != ErrExpired {
+		t.Errorf("accepted an expired invite: %v", err)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "chatconfig.json")
+	original := `{"port": 6666, "bootstrap_peers": ["/ip4/127.0.0.1/tcp/4002/p2p/12D3KooWQYhTNQdmr3ArTeUHRYzFg94BKyTkoWBDWez9kSCVe2Xo"], "nick": "bob", "room": "lobby",
+		"owners": {"room:lobby": "12D3KooWQYhTNQdmr3ArTeUHRYzFg94BKyTkoWBDWez9kSCVe2Xo"}}`
+	if err := os.WriteFile(file, []byte(original), 0644); err != nil {
+		t.Fatal(err)
+	}
+	swarmKey, _ := chat.NewSwarmKey()
+	_, ownerID := newKey(t)
+	owner := ownerID.String()
+	inv := &Invite{Peers: []string{bootstrapPeer, bootstrapPeer}, Rendezvous: "meet", Room: "ops", SwarmKey: swarmKey,
+		Owners: map[string]string{"room:ops": owner, "room:lobby": owner}}
+	if err := inv.Merge(file); err != nil {
+		t.Fatal(err)
+	}
+	config, err := chat.LoadChatConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Port != 6666 || len(config.BootstrapPeers) != 2 || config.RendezvousString != "meet" || config.SwarmKey != swarmKey {
+		t.Errorf("merged into %+v", config)
+	}
+	if _, err = config.PrivateNetwork(); err != nil {
+		t.Error(err)
+	}
+	data, _ := os.ReadFile(file)
+	var fields map[string]any
+	json.Unmarshal(data, &fields)
+	if fields["nick"] != "bob" || fields["room"] != "ops" {
+		t.Errorf("merged into %s", data)
+	}
+	// an invite pins owners for new scopes but doesn't replace those already pinned
+	owners, _ := fields["owners"].(map[string]any)
+	if owners["room:ops"] != owner || owners["room:lobby"] == owner {
+		t.Errorf("merged owners %v", owners)
+	}
+	if info, _ := os.Stat(file); info.Mode().Perm() != 0600 {
+		t.Errorf("a configuration with a swarm key is readable by others: %v", info.Mode())
+	}
+
+	created := filepath.Join(t.TempDir(), "new.json")
+	if err = inv.Merge(created); err != nil {
+		t.Fatal(err)
+	}
+	if config, err = chat.LoadChatConfig(created); err != nil || len(config.BootstrapPeers) != 1 {
+		t.Errorf("created %+v: %v", config, err)
+	}
+}
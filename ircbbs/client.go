@@ -0,0 +1,577 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package ircbbs
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/rightfoot-consulting/p2pbbs/chatv2"
+)
+
+// IdleTimeout is how long a client may send nothing, not even a PING, before it is
+// disconnected.
+const IdleTimeout = 10 * time.Minute
+
+// maxLineLength bounds the lines a client sends, RFC 2812 allows 512 bytes.
+const maxLineLength = 4096
+
+// client is a connection from an IRC client.
+type client struct {
+	server *Server
+	node   *chatv2.ChatV2Node
+	conn   net.Conn
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	writeMu sync.Mutex
+	w       *bufio.Writer
+
+	// set by the connection's goroutine only
+	nick       string
+	user       string
+	realname   string
+	registered bool
+
+	mu       sync.Mutex
+	rooms    map[string]*chatv2.ChatRoom
+	channels map[string]string
+	peers    map[string]peer.ID
+}
+
+func newClient(ctx context.Context, s *Server, conn net.Conn) *client {
+	c := &client{
+		server:   s,
+		node:     s.node,
+		conn:     conn,
+		w:        bufio.NewWriter(conn),
+		rooms:    make(map[string]*chatv2.ChatRoom),
+		channels: make(map[string]string),
+		peers:    make(map[string]peer.ID),
+	}
+	c.ctx, c.cancel = context.WithCancel(ctx)
+	return c
+}
+
+// serve reads commands until the client quits or goes away.
+func (c *client) serve() {
+	defer c.close()
+	go func() {
+		<-c.ctx.Done()
+		c.conn.Close()
+	}()
+	reader := bufio.NewReaderSize(c.conn, maxLineLength)
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(IdleTimeout))
+		line, err := reader.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			// discard the rest of an overlong line
+			for err == bufio.ErrBufferFull {
+				_, err = reader.ReadSlice('\n')
+			}
+			continue
+		}
+		if err != nil {
+			return
+		}
+		m, ok := parseMessage(string(line))
+		if !ok {
+			continue
+		}
+		if !c.handle(m) {
+			return
+		}
+	}
+}
+
+// close leaves the rooms of a client that has gone, telling the local clients that shared them.
+func (c *client) close() {
+	told := map[*client]bool{c: true}
+	line := formatMessage(c.prefix(), "QUIT", "Quit")
+	for _, channel := range c.joined() {
+		for _, member := range c.server.members(channel) {
+			if !told[member] {
+				member.write(line)
+				told[member] = true
+			}
+		}
+		c.part(channel, "", false)
+	}
+	c.server.unregister(c)
+	c.cancel()
+}
+
+// write sends a line to the client.
+func (c *client) write(line string) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.w.WriteString(line)
+	c.w.Flush()
+}
+
+// reply sends a numeric reply.
+func (c *client) reply(code string, params ...string) {
+	nick := c.nick
+	if nick == "" {
+		nick = "*"
+	}
+	c.write(formatMessage(serverName, code, append([]string{nick}, params...)...))
+}
+
+// prefix is the source of the client's own messages.
+func (c *client) prefix() string {
+	return c.nick + "!" + c.user + "@" + serverName
+}
+
+// peerPrefix is the source of messages from a remote peer.
+func peerPrefix(name string, id peer.ID) string {
+	return name + "!" + short(id) + "@p2p"
+}
+
+func short(id peer.ID) string {
+	s := id.String()
+	if len(s) > 8 {
+		s = s[len(s)-8:]
+	}
+	return s
+}
+
+// handle runs one command, returning false when the client quits.
+func (c *client) handle(m *message) bool {
+	switch m.command {
+	case "CAP":
+		if len(m.params) > 0 && strings.EqualFold(m.params[0], "LS") {
+			c.write(formatMessage(serverName, "CAP", "*", "LS", ""))
+		} else if len(m.params) > 1 && strings.EqualFold(m.params[0], "REQ") {
+			c.write(formatMessage(serverName, "CAP", "*", "NAK", m.params[1]))
+		}
+		return true
+	case "PASS":
+		// there is no password to check, the server only answers the loopback interface
+		return true
+	case "NICK":
+		c.setNick(m.params)
+		return true
+	case "USER":
+		if c.registered {
+			c.reply("462", "You may not reregister")
+			return true
+		}
+		if len(m.params) < 4 {
+			c.reply("461", "USER", "Not enough parameters")
+			return true
+		}
+		c.user, c.realname = ircNick(m.params[0]), m.params[3]
+		c.welcome()
+		return true
+	case "PING":
+		token := serverName
+		if len(m.params) > 0 {
+			token = m.params[0]
+		}
+		c.write(formatMessage(serverName, "PONG", serverName, token))
+		return true
+	case "PONG":
+		return true
+	case "QUIT":
+		c.write(formatMessage("", "ERROR", "Closing link"))
+		return false
+	}
+	if !c.registered {
+		c.reply("451", "You have not registered")
+		return true
+	}
+	switch m.command {
+	case "JOIN":
+		c.join(m.params)
+	case "PART":
+		if len(m.params) == 0 {
+			c.reply("461", "PART", "Not enough parameters")
+			break
+		}
+		reason := c.nick
+		if len(m.params) > 1 {
+			reason = m.params[1]
+		}
+		for _, channel := range strings.Split(m.params[0], ",") {
+			c.part(channel, reason, true)
+		}
+	case "PRIVMSG", "NOTICE":
+		if len(m.params) < 2 || m.params[1] == "" {
+			if m.command == "PRIVMSG" {
+				c.reply("412", "No text to send")
+			}
+			break
+		}
+		for _, target := range strings.Split(m.params[0], ",") {
+			c.privmsg(m.command, target, m.params[1])
+		}
+	case "NAMES":
+		if len(m.params) == 0 {
+			for _, channel := range c.joined() {
+				c.names(channel)
+			}
+			break
+		}
+		for _, channel := range strings.Split(m.params[0], ",") {
+			c.names(channel)
+		}
+	case "WHO":
+		mask := "*"
+		if len(m.params) > 0 {
+			mask = m.params[0]
+		}
+		c.who(mask)
+	case "TOPIC":
+		c.topic(m.params)
+	case "MODE":
+		c.mode(m.params)
+	case "MOTD":
+		c.reply("422", "MOTD File is missing")
+	default:
+		c.reply("421", m.command, "Unknown command")
+	}
+	return true
+}
+
+// setNick picks the client's nick.  Rooms are joined under a nick, so it can't change once the
+// client has registered.
+func (c *client) setNick(params []string) {
+	if len(params) == 0 || params[0] == "" {
+		c.reply("431", "No nickname given")
+		return
+	}
+	nick := params[0]
+	switch {
+	case c.registered:
+		c.reply("447", "Nicks can't be changed on p2pbbs, reconnect to use another one")
+	case !validNick(nick):
+		c.reply("432", nick, "Erroneous nickname")
+	case !c.server.register(c, nick):
+		c.reply("433", nick, "Nickname is already in use")
+	default:
+		c.nick = nick
+		c.welcome()
+	}
+}
+
+// welcome completes the registration once both NICK and USER have been sent.
+func (c *client) welcome() {
+	if c.registered || c.nick == "" || c.user == "" {
+		return
+	}
+	c.registered = true
+	c.reply("001", fmt.Sprintf("Welcome to p2pbbs, %s", c.prefix()))
+	c.reply("002", fmt.Sprintf("Your host is %s, bridging the chat rooms of %s", serverName, c.node.Host.ID()))
+	c.reply("003", "Channels are p2pbbs chat rooms, private messages are p2p direct messages")
+	c.reply("004", serverName, "p2pbbs", "i", "nt")
+	c.reply("005", fmt.Sprintf("NICKLEN=%d", MaxNickLength), "CHANTYPES=#", "CHANNELLEN=50", "NETWORK=p2pbbs", "CASEMAPPING=ascii", "are supported by this server")
+	c.reply("422", "MOTD File is missing")
+	go c.relayMessages()
+}
+
+// joined returns the channels the client is in.
+func (c *client) joined() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	channels := make([]string, 0, len(c.channels))
+	for _, channel := range c.channels {
+		channels = append(channels, channel)
+	}
+	return channels
+}
+
+func (c *client) inChannel(channel string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.rooms[strings.ToLower(channel)]
+	return ok
+}
+
+func (c *client) room(channel string) (*chatv2.ChatRoom, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cr, ok := c.rooms[strings.ToLower(channel)]
+	return cr, ok
+}
+
+// join joins the chat rooms of channels, JOIN 0 leaves them all.
+func (c *client) join(params []string) {
+	if len(params) == 0 {
+		c.reply("461", "JOIN", "Not enough parameters")
+		return
+	}
+	if params[0] == "0" {
+		for _, channel := range c.joined() {
+			c.part(channel, c.nick, true)
+		}
+		return
+	}
+	for _, channel := range strings.Split(params[0], ",") {
+		name, ok := channelRoom(channel)
+		if !ok {
+			c.reply("403", channel, "No such channel")
+			continue
+		}
+		if c.inChannel(channel) {
+			continue
+		}
+		cr, err := c.node.JoinRoom(c.ctx, c.nick, name)
+		if err != nil {
+			c.reply("403", channel, err.Error())
+			continue
+		}
+		c.mu.Lock()
+		c.rooms[strings.ToLower(channel)] = cr
+		c.channels[strings.ToLower(channel)] = channel
+		c.mu.Unlock()
+		go c.relayRoom(channel, cr)
+		c.server.broadcast(channel, formatMessage(c.prefix(), "JOIN", channel))
+		c.topic([]string{channel})
+		c.names(channel)
+	}
+}
+
+// part leaves the room of a channel, telling the local clients in it when notify is set.
+func (c *client) part(channel string, reason string, notify bool) {
+	cr, ok := c.room(channel)
+	if !ok {
+		if notify {
+			c.reply("442", channel, "You're not on that channel")
+		}
+		return
+	}
+	if notify {
+		c.server.broadcast(channel, formatMessage(c.prefix(), "PART", channel, reason))
+	}
+	c.mu.Lock()
+	delete(c.rooms, strings.ToLower(channel))
+	delete(c.channels, strings.ToLower(channel))
+	c.mu.Unlock()
+	cr.Leave()
+}
+
+// relayRoom passes the messages of a room to the client until it leaves the room.
+func (c *client) relayRoom(channel string, cr *chatv2.ChatRoom) {
+	for cm := range cr.Messages {
+		id, err := peer.Decode(cm.SenderID)
+		if err != nil {
+			continue
+		}
+		if id != c.node.Host.ID() {
+			c.node.Names.SeenNick(id, cm.SenderNick)
+		}
+		prefix := peerPrefix(c.nameFor(id, cm.SenderNick), id)
+		if local, ok := c.server.client(cm.SenderNick); ok && id == c.node.Host.ID() {
+			prefix = local.prefix()
+		}
+		for _, line := range splitText(cm.Message) {
+			c.write(formatMessage(prefix, "PRIVMSG", channel, line))
+		}
+	}
+}
+
+// relayMessages passes the direct messages sent to the node to the client until it goes.
+func (c *client) relayMessages() {
+	self := c.node.Host.ID()
+	messages, stop := c.node.DMs.Mailbox().Subscribe(self)
+	defer stop()
+	for {
+		select {
+		case m := <-messages:
+			from := m.FromID()
+			if from == self {
+				continue
+			}
+			c.node.Names.SeenNick(from, m.Nick)
+			prefix := peerPrefix(c.nameFor(from, m.Nick), from)
+			for _, line := range splitText(m.Body) {
+				c.write(formatMessage(prefix, "PRIVMSG", c.nick, line))
+			}
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+// nameFor returns the IRC nick of a peer: its petname or declared nick, with the end of its
+// peer id added when another peer or a local client already goes by that nick.
+func (c *client) nameFor(id peer.ID, nick string) string {
+	if id == c.node.Host.ID() {
+		return ircNick(nick)
+	}
+	if petname, ok := c.node.Names.Petname(id); ok {
+		nick = petname
+	}
+	name := ircNick(nick)
+	_, local := c.server.client(name)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if other, ok := c.peers[strings.ToLower(name)]; local || (ok && other != id) {
+		name = ircNick(nick + "|" + short(id))
+	}
+	c.peers[strings.ToLower(name)] = id
+	return name
+}
+
+// lookupPeer finds the remote peer an IRC nick stands for: a nick the client has seen, a
+// petname or a peer id.
+func (c *client) lookupPeer(name string) (peer.ID, bool) {
+	c.mu.Lock()
+	id, ok := c.peers[strings.ToLower(name)]
+	c.mu.Unlock()
+	if ok {
+		return id, true
+	}
+	if id, ok := c.node.Names.Lookup(name, nil); ok {
+		return id, true
+	}
+	if id, err := peer.Decode(name); err == nil {
+		return id, true
+	}
+	return "", false
+}
+
+// privmsg sends text to a channel's room, to a local client or as a direct message to a
+// remote peer.  NOTICE gets no error replies.
+func (c *client) privmsg(command string, target string, text string) {
+	if strings.HasPrefix(target, "#") {
+		cr, ok := c.room(target)
+		if !ok {
+			if command == "PRIVMSG" {
+				c.reply("404", target, "Cannot send to channel")
+			}
+			return
+		}
+		if err := cr.Publish(text); err != nil && command == "PRIVMSG" {
+			c.reply("404", target, err.Error())
+		}
+		return
+	}
+	if other, ok := c.server.client(target); ok {
+		other.write(formatMessage(c.prefix(), command, other.nick, text))
+		return
+	}
+	id, ok := c.lookupPeer(target)
+	if !ok {
+		if command == "PRIVMSG" {
+			c.reply("401", target, "No such nick")
+		}
+		return
+	}
+	if _, err := c.node.DMs.Send(c.node.PrivateKey(), c.nick, id, text); err != nil && command == "PRIVMSG" {
+		c.write(formatMessage(serverName, "NOTICE", c.nick, fmt.Sprintf("unable to message %s: %v", target, err)))
+	}
+}
+
+// member is someone in a channel, a local client or a remote peer.
+type member struct {
+	nick     string
+	user     string
+	host     string
+	realname string
+}
+
+// members lists the local clients and the remote peers in a channel.
+func (c *client) members(channel string) []member {
+	members := make([]member, 0)
+	for _, local := range c.server.members(channel) {
+		members = append(members, member{local.nick, local.user, serverName, local.realname})
+	}
+	if cr, ok := c.room(channel); ok {
+		for _, id := range cr.ListPeers() {
+			nick, ok := c.node.Names.Nick(id)
+			if !ok && c.node.Profiles != nil {
+				if prof := c.node.Profiles.Cached(id); prof != nil {
+					nick, ok = prof.Nick, true
+				}
+			}
+			if !ok {
+				nick = short(id)
+			}
+			members = append(members, member{c.nameFor(id, nick), short(id), "p2p", id.String()})
+		}
+	}
+	return members
+}
+
+// names lists the nicks in a channel, remote peers under their p2p nicks.
+func (c *client) names(channel string) {
+	var line []string
+	length := 0
+	for _, m := range c.members(channel) {
+		if length+len(m.nick) > maxTextLength {
+			c.reply("353", "=", channel, strings.Join(line, " "))
+			line, length = nil, 0
+		}
+		line = append(line, m.nick)
+		length += len(m.nick) + 1
+	}
+	if len(line) > 0 {
+		c.reply("353", "=", channel, strings.Join(line, " "))
+	}
+	c.reply("366", channel, "End of /NAMES list")
+}
+
+// who describes the members of a channel, or a single nick.
+func (c *client) who(mask string) {
+	if strings.HasPrefix(mask, "#") {
+		for _, m := range c.members(mask) {
+			c.reply("352", mask, m.user, m.host, serverName, m.nick, "H", "0 "+m.realname)
+		}
+	} else if other, ok := c.server.client(mask); ok {
+		c.reply("352", "*", other.user, serverName, serverName, other.nick, "H", "0 "+other.realname)
+	} else if id, ok := c.lookupPeer(mask); ok {
+		c.reply("352", "*", short(id), "p2p", serverName, mask, "H", "0 "+id.String())
+	}
+	c.reply("315", mask, "End of /WHO list")
+}
+
+// topic shows or sets the topic of a channel.
+func (c *client) topic(params []string) {
+	if len(params) == 0 {
+		c.reply("461", "TOPIC", "Not enough parameters")
+		return
+	}
+	channel := params[0]
+	if !c.inChannel(channel) {
+		c.reply("442", channel, "You're not on that channel")
+		return
+	}
+	if len(params) > 1 {
+		c.server.setTopic(channel, params[1])
+		c.server.broadcast(channel, formatMessage(c.prefix(), "TOPIC", channel, params[1]))
+		return
+	}
+	if topic := c.server.topic(channel); topic != "" {
+		c.reply("332", channel, topic)
+	} else {
+		c.reply("331", channel, "No topic is set")
+	}
+}
+
+// mode answers the mode queries clients make after joining, modes can't be changed.
+func (c *client) mode(params []string) {
+	if len(params) == 0 {
+		c.reply("461", "MODE", "Not enough parameters")
+		return
+	}
+	target := params[0]
+	switch {
+	case strings.HasPrefix(target, "#") && len(params) > 1 && strings.Trim(params[1], "+") == "b":
+		c.reply("368", target, "End of channel ban list")
+	case strings.HasPrefix(target, "#"):
+		c.reply("324", target, "+nt")
+	case strings.EqualFold(target, c.nick):
+		c.reply("221", "+i")
+	default:
+		c.reply("401", target, "No such nick")
+	}
+}
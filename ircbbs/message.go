@@ -0,0 +1,134 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package ircbbs
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxNickLength bounds nicks, as advertised in NICKLEN.
+const MaxNickLength = 30
+
+// maxTextLength bounds the text of a PRIVMSG we send so the whole line fits in 512 bytes.
+const maxTextLength = 400
+
+// message is a line of the IRC protocol (RFC 2812 section 2.3).
+type message struct {
+	prefix  string
+	command string
+	params  []string
+}
+
+// parseMessage splits a line into its prefix, command and parameters.
+func parseMessage(line string) (m *message, ok bool) {
+	line = strings.TrimRight(line, "\r\n")
+	m = &message{}
+	if strings.HasPrefix(line, ":") {
+		var found bool
+		if m.prefix, line, found = strings.Cut(line[1:], " "); !found {
+			return nil, false
+		}
+	}
+	line = strings.TrimLeft(line, " ")
+	for line != "" {
+		if strings.HasPrefix(line, ":") {
+			m.params = append(m.params, line[1:])
+			break
+		}
+		param, rest, _ := strings.Cut(line, " ")
+		if m.command == "" {
+			m.command = strings.ToUpper(param)
+		} else {
+			m.params = append(m.params, param)
+		}
+		line = strings.TrimLeft(rest, " ")
+	}
+	return m, m.command != ""
+}
+
+// formatMessage renders a line, the last parameter as a trailing parameter when it needs to be.
+func formatMessage(prefix string, command string, params ...string) string {
+	var line strings.Builder
+	if prefix != "" {
+		line.WriteString(":" + prefix + " ")
+	}
+	line.WriteString(command)
+	for i, param := range params {
+		param = strings.Map(func(r rune) rune {
+			if r == '\r' || r == '\n' || r == 0 {
+				return ' '
+			}
+			return r
+		}, param)
+		if i == len(params)-1 && (param == "" || strings.HasPrefix(param, ":") || strings.Contains(param, " ")) {
+			param = ":" + param
+		}
+		line.WriteString(" " + param)
+	}
+	line.WriteString("\r\n")
+	return line.String()
+}
+
+// validNick reports whether a nick chosen by an IRC client can be used.
+func validNick(nick string) bool {
+	if nick == "" || len(nick) > MaxNickLength || strings.ContainsAny(nick[:1], "0123456789-") {
+		return false
+	}
+	for _, r := range nick {
+		if !nickRune(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func nickRune(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("[]\\`_^{|}-", r)
+}
+
+// ircNick turns a p2p nick, which may contain anything, into a nick IRC clients accept.
+func ircNick(nick string) string {
+	name := strings.Map(func(r rune) rune {
+		if r == '#' {
+			return '|'
+		}
+		if !nickRune(r) {
+			return '_'
+		}
+		return r
+	}, nick)
+	if name == "" || strings.ContainsAny(name[:1], "0123456789-") {
+		name = "_" + name
+	}
+	if len(name) > MaxNickLength {
+		name = name[:MaxNickLength]
+	}
+	return name
+}
+
+// splitText breaks chat text into lines short enough for PRIVMSG.
+func splitText(text string) []string {
+	lines := make([]string, 0)
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		for len(line) > maxTextLength {
+			cut := maxTextLength
+			for cut > 0 && !utf8.RuneStart(line[cut]) {
+				cut--
+			}
+			lines = append(lines, line[:cut])
+			line = line[cut:]
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// channelRoom returns the chat room of a channel, #general is the room general.
+func channelRoom(channel string) (string, bool) {
+	room, ok := strings.CutPrefix(channel, "#")
+	return room, ok && room != "" && len(room) <= 50 && !strings.ContainsAny(room, " ,\x07")
+}
@@ -0,0 +1,64 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package ircbbs
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestParseMessage(t *testing.T) {
+	m, ok := parseMessage(":alice!a@host PRIVMSG #general :hello there\r\n")
+	if !ok || m.prefix != "alice!a@host" || m.command != "PRIVMSG" || !reflect.DeepEqual(m.params, []string{"#general", "hello there"}) {
+		t.Errorf("unexpected message %+v", m)
+	}
+	m, ok = parseMessage("user  bob 0 * :Bob Smith")
+	if !ok || m.command != "USER" || !reflect.DeepEqual(m.params, []string{"bob", "0", "*", "Bob Smith"}) {
+		t.Errorf("unexpected message %+v", m)
+	}
+	if _, ok = parseMessage(":prefix-only"); ok {
+		t.Error("accepted a line without a command")
+	}
+}
+
+func TestFormatMessage(t *testing.T) {
+	if line := formatMessage("p2pbbs", "353", "alice", "=", "#general", "alice bob"); line != ":p2pbbs 353 alice = #general :alice bob\r\n" {
+		t.Errorf("unexpected line %q", line)
+	}
+	if line := formatMessage("", "PRIVMSG", "#general", "evil\r\nQUIT"); line != "PRIVMSG #general :evil  QUIT\r\n" {
+		t.Errorf("line breaks not removed: %q", line)
+	}
+}
+
+func TestNicks(t *testing.T) {
+	for nick, want := range map[string]string{
+		"alice":      "alice",
+		"alice#1234": "alice|1234",
+		"José":       "Jos_",
+		"42":         "_42",
+		"":           "_",
+	} {
+		if got := ircNick(nick); got != want || !validNick(got) {
+			t.Errorf("ircNick(%q) = %q, want %q", nick, got, want)
+		}
+	}
+	if validNick("bad nick") || validNick("-dash") {
+		t.Error("accepted an invalid nick")
+	}
+}
+
+func TestSplitText(t *testing.T) {
+	long := strings.Repeat("é", maxTextLength)
+	lines := splitText("one\r\n\ntwo\n" + long)
+	if len(lines) != 4 || lines[0] != "one" || lines[1] != "two" || lines[2]+lines[3] != long {
+		t.Errorf("unexpected lines %q", lines)
+	}
+	for _, line := range lines {
+		if len(line) > maxTextLength || !utf8.ValidString(line) {
+			t.Errorf("bad split %q", line)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package ircbbs
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/rightfoot-consulting/p2pbbs/chatv2"
+	"github.com/rightfoot-consulting/p2pbbs/internal/loopback"
+	"github.com/rightfoot-consulting/p2pbbs/moderation"
+)
+
+var logger = logging.Logger("ircbbs")
+
+// DefaultAddress is where the server listens when no address is given.  Clients don't log in
+// and speak for the node, so the server refuses addresses off the loopback interface.
+const DefaultAddress = "127.0.0.1:6667"
+
+// serverName is the name the server uses as the prefix of its replies.
+const serverName = "p2pbbs"
+
+// Server is an IRC server whose channels are the chat rooms of a node, #general being the room
+// general, and whose private messages to remote peers are p2p direct messages.  Clients speak
+// for the node: room messages are published by it and direct messages signed with its
+// identity.  A topic set by the moderators of a room wins over one kept by the server for its
+// own clients.
+type Server struct {
+	node *chatv2.ChatV2Node
+
+	mu      sync.Mutex
+	clients map[string]*client
+	topics  map[string]string
+}
+
+// NewServer returns a server for the rooms and direct messages of node.
+func NewServer(node *chatv2.ChatV2Node) *Server {
+	return &Server{
+		node:    node,
+		clients: make(map[string]*client),
+		topics:  make(map[string]string),
+	}
+}
+
+// ListenAndServe accepts connections on address, which must be a loopback address, until ctx
+// ends.
+func (s *Server) ListenAndServe(ctx context.Context, address string) error {
+	listener, err := loopback.Listen("IRC server", address)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ctx, listener)
+}
+
+// Serve accepts connections on listener until ctx ends.
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go newClient(ctx, s, conn).serve()
+	}
+}
+
+// register claims a nick for a client, returning false when another client has it.
+func (s *Server) register(c *client, nick string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := strings.ToLower(nick)
+	if other, ok := s.clients[key]; ok && other != c {
+		return false
+	}
+	delete(s.clients, strings.ToLower(c.nick))
+	s.clients[key] = c
+	return true
+}
+
+// unregister frees the nick of a client that has gone.
+func (s *Server) unregister(c *client) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.clients[strings.ToLower(c.nick)] == c {
+		delete(s.clients, strings.ToLower(c.nick))
+	}
+}
+
+// client returns the local client using nick.
+func (s *Server) client(nick string) (*client, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.clients[strings.ToLower(nick)]
+	return c, ok
+}
+
+// members returns the local clients in a channel.
+func (s *Server) members(channel string) []*client {
+	s.mu.Lock()
+	clients := make([]*client, 0, len(s.clients))
+	for _, c := range s.clients {
+		clients = append(clients, c)
+	}
+	s.mu.Unlock()
+	members := make([]*client, 0)
+	for _, c := range clients {
+		if c.inChannel(channel) {
+			members = append(members, c)
+		}
+	}
+	return members
+}
+
+// topic returns the topic of a channel, the one the moderators of the room set taking precedence.
+func (s *Server) topic(channel string) string {
+	if name, ok := channelRoom(channel); ok && s.node.Moderation != nil {
+		view := s.node.Moderation.Store().View(moderation.RoomScope(name))
+		if view.Topic != "" {
+			return view.Topic
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.topics[strings.ToLower(channel)]
+}
+
+// setTopic changes the topic of a channel.
+func (s *Server) setTopic(channel string, topic string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.topics[strings.ToLower(channel)] = topic
+}
+
+// broadcast sends a line to the local clients in a channel.
+func (s *Server) broadcast(channel string, line string) {
+	for _, c := range s.members(channel) {
+		c.write(line)
+	}
+}
@@ -0,0 +1,70 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package mailbbs
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"sync"
+)
+
+// DeletedFile is the name of the file in the data directory listing the messages deleted by
+// POP3 clients.
+const DeletedFile = "pop3-deleted.json"
+
+// Deleted remembers the direct messages a mail client has deleted.  The messages stay in the
+// mailbox, where the other front ends still show them, they are only hidden from POP3.
+type Deleted struct {
+	file string
+
+	mu       sync.Mutex
+	Messages map[string]bool `json:"messages"`
+}
+
+// LoadDeleted reads the deleted messages saved in file, starting afresh if it doesn't exist.
+func LoadDeleted(file string) (deleted *Deleted, err error) {
+	deleted = &Deleted{file: file, Messages: make(map[string]bool)}
+	jsonBytes, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return deleted, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(jsonBytes, deleted); err != nil {
+		return nil, err
+	}
+	if deleted.Messages == nil {
+		deleted.Messages = make(map[string]bool)
+	}
+	return
+}
+
+// Has reports whether a message has been deleted.
+func (d *Deleted) Has(id string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.Messages[id]
+}
+
+// Add deletes messages and saves the list.
+func (d *Deleted) Add(ids ...string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, id := range ids {
+		d.Messages[id] = true
+	}
+	return d.save()
+}
+
+// save writes the list to the file, the caller holds the lock.
+func (d *Deleted) save() error {
+	jsonBytes, err := json.MarshalIndent(d, "", "    ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(d.file, jsonBytes, 0600)
+}
@@ -0,0 +1,185 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package mailbbs
+
+import (
+	"context"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/rightfoot-consulting/p2pbbs/chatv2"
+	"github.com/rightfoot-consulting/p2pbbs/dm"
+)
+
+// pop3 sends a command and checks that it succeeded.
+func pop3(t *testing.T, conn *textproto.Conn, format string, args ...interface{}) string {
+	t.Helper()
+	if err := conn.PrintfLine(format, args...); err != nil {
+		t.Fatal(err)
+	}
+	line, err := conn.ReadLine()
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, ok := strings.CutPrefix(line, "+OK")
+	if !ok {
+		t.Fatalf("%s: %s", format, line)
+	}
+	return strings.TrimSpace(status)
+}
+
+func TestMailGateway(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	host, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer host.Close()
+	ps, err := pubsub.NewGossipSub(ctx, host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	mailbox, err := dm.OpenMailbox(filepath.Join(dir, dm.MailboxDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dms, err := dm.NewService(ctx, ps, mailbox)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice, _, _ := crypto.GenerateEd25519Key(nil)
+	bob, _, _ := crypto.GenerateEd25519Key(nil)
+	aliceID, _ := peer.IDFromPrivateKey(alice)
+	bobID, _ := peer.IDFromPrivateKey(bob)
+	if err = dms.Listen(bob); err != nil {
+		t.Fatal(err)
+	}
+	names, err := chatv2.LoadNameBook(filepath.Join(dir, "names.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = names.SetPetname(bobID, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	deleted, err := LoadDeleted(filepath.Join(dir, DeletedFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	smtpListener, _ := net.Listen("tcp", "127.0.0.1:0")
+	go NewSMTPServer(dms, names, alice, "alice").Serve(ctx, smtpListener)
+	pop3Listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	go NewPOP3Server(mailbox, deleted, bobID, "secret").Serve(ctx, pop3Listener)
+
+	received, stop := mailbox.Subscribe(bobID)
+	defer stop()
+	// submit sends the test message, as a client retrying it would
+	submit := func() {
+		client, err := smtp.Dial(smtpListener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Quit()
+		if err = client.Mail("alice@example.com"); err != nil {
+			t.Fatal(err)
+		}
+		if err = client.Rcpt("carol@example.com"); err == nil {
+			t.Error("accepted a recipient outside " + Domain)
+		}
+		if err = client.Rcpt("nobody@p2pbbs.local"); err == nil {
+			t.Error("accepted an unknown petname")
+		}
+		if err = client.Rcpt("bob@P2PBBS.local"); err != nil {
+			t.Fatal(err)
+		}
+		w, err := client.Data()
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte("From: alice@example.com\r\nTo: bob@p2pbbs.local\r\nSubject: =?utf-8?q?Caf=C3=A9?=\r\n" +
+			"Content-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\n" +
+			"See you at noon=\r\n.\r\n.dotted line\r\n"))
+		if err = w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	submit()
+
+	var m *dm.Message
+	select {
+	case m = <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("message not delivered")
+	}
+	if !m.Sealed() || m.Body != "Café\n\nSee you at noon.\n.dotted line" {
+		t.Fatalf("unexpected message %+v", m)
+	}
+	// the same mail submitted again isn't sent twice
+	submit()
+	if pending := dms.Outbox().Pending(aliceID); len(pending) > 1 {
+		t.Errorf("resubmitted mail queued again: %d messages", len(pending))
+	}
+	saved, err := os.ReadFile(filepath.Join(dir, dm.MailboxDir, bobID.String()+".jsonl"))
+	if err != nil || strings.Contains(string(saved), "noon") {
+		t.Errorf("message saved in the clear: %s %v", saved, err)
+	}
+
+	netConn, err := net.Dial("tcp", pop3Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := textproto.NewConn(netConn)
+	defer conn.Close()
+	conn.ReadLine()
+	pop3(t, conn, "USER bob")
+	if err = conn.PrintfLine("PASS wrong"); err != nil {
+		t.Fatal(err)
+	}
+	if line, _ := conn.ReadLine(); !strings.HasPrefix(line, "-ERR") {
+		t.Errorf("accepted a wrong password: %s", line)
+	}
+	pop3(t, conn, "USER bob")
+	pop3(t, conn, "PASS secret")
+	if uid := pop3(t, conn, "UIDL 1"); uid != "1 "+m.ID {
+		t.Errorf("unexpected uid %q", uid)
+	}
+	pop3(t, conn, "RETR 1")
+	lines, _ := conn.ReadDotLines()
+	text := strings.Join(lines, "\n")
+	if !strings.Contains(text, "Subject: =?utf-8?q?Caf=C3=A9?=") || !strings.Contains(text, "X-P2PBBS-Sealed: yes") ||
+		!strings.HasSuffix(text, "\n\nCafé\n\nSee you at noon.\n.dotted line") {
+		t.Errorf("unexpected message:\n%s", text)
+	}
+	pop3(t, conn, "DELE 1")
+	if status := pop3(t, conn, "STAT"); status != "0 0" {
+		t.Errorf("unexpected status %q", status)
+	}
+	pop3(t, conn, "QUIT")
+	if !deleted.Has(m.ID) {
+		t.Error("message not deleted")
+	}
+}
+
+func TestParseMail(t *testing.T) {
+	text, err := parseMail([]byte("Subject: Hi\r\nMIME-Version: 1.0\r\nContent-Type: multipart/alternative; boundary=b\r\n\r\n" +
+		"--b\r\nContent-Type: text/html\r\n\r\n<p>hello</p>\r\n" +
+		"--b\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: base64\r\n\r\naGVsbG8=\r\n--b--\r\n"))
+	if err != nil || text != "Hi\n\nhello" {
+		t.Errorf("parsed %q, %v", text, err)
+	}
+	if _, err = parseMail([]byte("Content-Type: image/png\r\n\r\nxxxx")); err == nil {
+		t.Error("accepted an image")
+	}
+}
@@ -0,0 +1,165 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package mailbbs
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/rightfoot-consulting/p2pbbs/chatv2"
+	"github.com/rightfoot-consulting/p2pbbs/dm"
+)
+
+// Domain is the mail domain of the p2p network, peers are addressed as <peer id>@p2pbbs.local
+// or by the petname given to them.
+const Domain = "p2pbbs.local"
+
+// maxSubjectLength bounds the subject taken from the first line of a direct message.
+const maxSubjectLength = 78
+
+// address returns the mail address of a peer.
+func address(id string) string {
+	return id + "@" + Domain
+}
+
+// resolveRecipient finds the peer a mail address on Domain is for, the local part being a peer
+// id or a petname.
+func resolveRecipient(names *chatv2.NameBook, addr string) (id peer.ID, err error) {
+	at := strings.LastIndex(addr, "@")
+	if at < 0 || !strings.EqualFold(addr[at+1:], Domain) {
+		return "", fmt.Errorf("only addresses @%s are delivered", Domain)
+	}
+	local := addr[:at]
+	if unquoted, ok := strings.CutPrefix(local, `"`); ok {
+		local = strings.ReplaceAll(strings.TrimSuffix(unquoted, `"`), `\`, "")
+	}
+	if id, err = peer.Decode(local); err == nil {
+		return
+	}
+	if names != nil {
+		if id, ok := names.Lookup(local, nil); ok {
+			return id, nil
+		}
+	}
+	return "", fmt.Errorf("%s is neither a peer id nor a petname", local)
+}
+
+// formatMessage renders a direct message as an RFC 5322 message with CRLF line endings.  The
+// subject is the first line of the text, which is where the text of mail sent through the
+// gateway puts its subject.
+func formatMessage(m *dm.Message) []byte {
+	body := strings.ReplaceAll(m.Body, "\r\n", "\n")
+	subject, _, _ := strings.Cut(strings.TrimSpace(body), "\n")
+	if utf8.RuneCountInString(subject) > maxSubjectLength {
+		subject = string([]rune(subject)[:maxSubjectLength-3]) + "..."
+	}
+	sealed := "no"
+	if m.Sealed() {
+		sealed = "yes"
+	}
+	var msg bytes.Buffer
+	for _, h := range [][2]string{
+		{"Message-ID", "<" + m.ID + "@" + Domain + ">"},
+		{"Date", m.Created.Local().Format(time.RFC1123Z)},
+		{"From", (&mail.Address{Name: m.Nick, Address: address(m.From)}).String()},
+		{"To", "<" + address(m.To) + ">"},
+		{"Subject", mime.QEncoding.Encode("utf-8", subject)},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "text/plain; charset=utf-8"},
+		{"Content-Transfer-Encoding", "8bit"},
+		{"X-P2PBBS-Sealed", sealed},
+	} {
+		fmt.Fprintf(&msg, "%s: %s\r\n", h[0], h[1])
+	}
+	msg.WriteString("\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	msg.WriteString("\r\n")
+	return msg.Bytes()
+}
+
+// parseMail turns mail submitted to the gateway into the text of a direct message: the
+// subject, a blank line and the first plain text part of the body.
+func parseMail(data []byte) (text string, err error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return
+	}
+	decoder := new(mime.WordDecoder)
+	subject, err := decoder.DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		return
+	}
+	body, err := plainText(msg.Header, msg.Body, 0)
+	if err != nil {
+		return
+	}
+	subject = strings.TrimSpace(subject)
+	body = strings.Trim(strings.ReplaceAll(body, "\r\n", "\n"), "\n")
+	switch {
+	case subject == "":
+		text = body
+	case body == "":
+		text = subject
+	default:
+		text = subject + "\n\n" + body
+	}
+	if text == "" {
+		err = errors.New("the message is empty")
+	}
+	return
+}
+
+// header is the part of a mail or MIME part header plainText needs.
+type header interface {
+	Get(key string) string
+}
+
+// plainText returns the text of a part, decoding its transfer encoding and looking inside
+// multipart bodies for the first text/plain part.
+func plainText(h header, body io.Reader, depth int) (string, error) {
+	mediaType, params, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		mediaType = "text/plain"
+	}
+	switch strings.ToLower(h.Get("Content-Transfer-Encoding")) {
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	}
+	if strings.HasPrefix(mediaType, "multipart/") && depth < 3 {
+		parts := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := parts.NextRawPart()
+			if err != nil {
+				return "", errors.New("the message has no plain text part")
+			}
+			if text, err := plainText(part.Header, part, depth+1); err == nil {
+				return text, nil
+			}
+		}
+	}
+	if mediaType != "text/plain" {
+		return "", fmt.Errorf("%s parts can't be sent", mediaType)
+	}
+	text, err := io.ReadAll(body)
+	if err != nil {
+		return "", err
+	}
+	if !utf8.Valid(text) {
+		return "", errors.New("only UTF-8 text can be sent")
+	}
+	return string(text), nil
+}
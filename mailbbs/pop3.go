@@ -0,0 +1,258 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package mailbbs
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/rightfoot-consulting/p2pbbs/dm"
+	"github.com/rightfoot-consulting/p2pbbs/internal/loopback"
+)
+
+// DefaultPOP3Address is where the POP3 server listens when no address is given.
+const DefaultPOP3Address = "127.0.0.1:1110"
+
+// POP3Server lets mail clients download the direct messages received by one identity (RFC
+// 1939).  The identity must be listening for messages so its sealed messages can be opened.
+type POP3Server struct {
+	mailbox  *dm.Mailbox
+	deleted  *Deleted
+	owner    peer.ID
+	password string
+}
+
+// NewPOP3Server returns a server for the messages sent to owner.  Clients log in with any
+// user name and password, unless password is set in which case it must match, so without one
+// anyone who can reach the loopback interface can read the messages.
+func NewPOP3Server(mailbox *dm.Mailbox, deleted *Deleted, owner peer.ID, password string) *POP3Server {
+	return &POP3Server{mailbox: mailbox, deleted: deleted, owner: owner, password: password}
+}
+
+// ListenAndServe accepts connections on address, which must be a loopback address, until ctx
+// ends.
+func (s *POP3Server) ListenAndServe(ctx context.Context, address string) error {
+	listener, err := loopback.Listen("POP3 server", address)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ctx, listener)
+}
+
+// Serve accepts connections on listener until ctx ends.
+func (s *POP3Server) Serve(ctx context.Context, listener net.Listener) error {
+	return serve(ctx, listener, s.handleConn)
+}
+
+// pop3Session is the state of one POP3 connection.  messages is the maildrop as it was when
+// the client logged in, nil before then.
+type pop3Session struct {
+	*POP3Server
+	conn     net.Conn
+	text     *textproto.Conn
+	user     string
+	messages []*dm.Message
+	marked   map[int]bool
+}
+
+func (s *POP3Server) handleConn(conn net.Conn) {
+	sess := &pop3Session{POP3Server: s, conn: conn, text: textproto.NewConn(conn)}
+	defer sess.text.Close()
+	sess.ok("p2pbbs POP3 server ready")
+	for {
+		conn.SetReadDeadline(time.Now().Add(IdleTimeout))
+		line, err := sess.text.ReadLine()
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			sess.fail("What?")
+			continue
+		}
+		command, args := strings.ToUpper(fields[0]), fields[1:]
+		if command == "QUIT" {
+			sess.quit()
+			return
+		}
+		if sess.messages == nil {
+			err = sess.authorization(command, args)
+		} else {
+			err = sess.transaction(command, args)
+		}
+		if err != nil {
+			logger.Debugf("connection from %s ended: %v", conn.RemoteAddr(), err)
+			return
+		}
+	}
+}
+
+func (sess *pop3Session) ok(format string, args ...interface{}) error {
+	return sess.text.PrintfLine("+OK %s", fmt.Sprintf(format, args...))
+}
+
+func (sess *pop3Session) fail(format string, args ...interface{}) error {
+	return sess.text.PrintfLine("-ERR %s", fmt.Sprintf(format, args...))
+}
+
+// multiline sends a positive response followed by dot terminated data.
+func (sess *pop3Session) multiline(status string, data []byte) error {
+	if err := sess.ok("%s", status); err != nil {
+		return err
+	}
+	w := sess.text.DotWriter()
+	w.Write(bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n")))
+	return w.Close()
+}
+
+// authorization runs a command before the client has logged in.
+func (sess *pop3Session) authorization(command string, args []string) error {
+	switch command {
+	case "CAPA":
+		return sess.multiline("Capability list follows", []byte("USER\nUIDL\nTOP\nRESP-CODES\nIMPLEMENTATION p2pbbs\n"))
+	case "USER":
+		if len(args) != 1 {
+			return sess.fail("Syntax: USER name")
+		}
+		sess.user = args[0]
+		return sess.ok("Send your password")
+	case "PASS":
+		if sess.user == "" {
+			return sess.fail("Send USER first")
+		}
+		password := strings.Join(args, " ")
+		if sess.password != "" && subtle.ConstantTimeCompare([]byte(password), []byte(sess.password)) != 1 {
+			sess.user = ""
+			return sess.fail("[AUTH] Wrong password")
+		}
+		received, err := sess.mailbox.Received(sess.owner)
+		if err != nil {
+			return sess.fail("[SYS/TEMP] %v", err)
+		}
+		sess.messages = make([]*dm.Message, 0, len(received))
+		for _, m := range received {
+			if !sess.deleted.Has(m.ID) {
+				sess.messages = append(sess.messages, m)
+			}
+		}
+		sess.marked = make(map[int]bool)
+		return sess.ok("%s has %d messages", sess.user, len(sess.messages))
+	default:
+		return sess.fail("Log in with USER and PASS first")
+	}
+}
+
+// transaction runs a command once the client has logged in.
+func (sess *pop3Session) transaction(command string, args []string) error {
+	switch command {
+	case "CAPA":
+		return sess.authorization(command, args)
+	case "STAT":
+		count, size := 0, 0
+		for n, m := range sess.messages {
+			if !sess.marked[n+1] {
+				count++
+				size += len(formatMessage(m))
+			}
+		}
+		return sess.ok("%d %d", count, size)
+	case "LIST", "UIDL":
+		listing := func(n int, m *dm.Message) string {
+			if command == "UIDL" {
+				return fmt.Sprintf("%d %s", n, m.ID)
+			}
+			return fmt.Sprintf("%d %d", n, len(formatMessage(m)))
+		}
+		if len(args) > 0 {
+			n, m, ok := sess.message(args[0])
+			if !ok {
+				return sess.fail("No such message")
+			}
+			return sess.ok("%s", listing(n, m))
+		}
+		var lines bytes.Buffer
+		for i, m := range sess.messages {
+			if !sess.marked[i+1] {
+				fmt.Fprintf(&lines, "%s\n", listing(i+1, m))
+			}
+		}
+		return sess.multiline("Scan listing follows", lines.Bytes())
+	case "RETR":
+		if len(args) != 1 {
+			return sess.fail("Syntax: RETR msg")
+		}
+		_, m, ok := sess.message(args[0])
+		if !ok {
+			return sess.fail("No such message")
+		}
+		data := formatMessage(m)
+		return sess.multiline(fmt.Sprintf("%d octets", len(data)), data)
+	case "TOP":
+		if len(args) != 2 {
+			return sess.fail("Syntax: TOP msg lines")
+		}
+		_, m, ok := sess.message(args[0])
+		lines, err := strconv.Atoi(args[1])
+		if !ok || err != nil || lines < 0 {
+			return sess.fail("No such message")
+		}
+		msg := formatMessage(m)
+		headerEnd := bytes.Index(msg, []byte("\r\n\r\n")) + 4
+		bodyLines := bytes.SplitAfter(msg[headerEnd:], []byte("\r\n"))
+		if lines < len(bodyLines) {
+			bodyLines = bodyLines[:lines]
+		}
+		data := append(msg[:headerEnd:headerEnd], bytes.Join(bodyLines, nil)...)
+		return sess.multiline("Top of message follows", data)
+	case "DELE":
+		if len(args) != 1 {
+			return sess.fail("Syntax: DELE msg")
+		}
+		n, _, ok := sess.message(args[0])
+		if !ok {
+			return sess.fail("No such message")
+		}
+		sess.marked[n] = true
+		return sess.ok("Message %d deleted", n)
+	case "RSET":
+		sess.marked = make(map[int]bool)
+		return sess.ok("%d messages", len(sess.messages))
+	case "NOOP":
+		return sess.ok("")
+	default:
+		return sess.fail("Command not recognized")
+	}
+}
+
+// message returns a message that hasn't been marked as deleted by its number.
+func (sess *pop3Session) message(arg string) (n int, m *dm.Message, ok bool) {
+	n, err := strconv.Atoi(arg)
+	if err != nil || n < 1 || n > len(sess.messages) || sess.marked[n] {
+		return 0, nil, false
+	}
+	return n, sess.messages[n-1], true
+}
+
+// quit ends the session, deleting the marked messages when the client had logged in.
+func (sess *pop3Session) quit() {
+	ids := make([]string, 0, len(sess.marked))
+	for n := range sess.marked {
+		ids = append(ids, sess.messages[n-1].ID)
+	}
+	if len(ids) > 0 {
+		if err := sess.deleted.Add(ids...); err != nil {
+			sess.fail("[SYS/TEMP] %v", err)
+			return
+		}
+	}
+	sess.ok("p2pbbs POP3 server signing off (%d messages deleted)", len(ids))
+}
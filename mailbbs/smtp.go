@@ -0,0 +1,298 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package mailbbs
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/rightfoot-consulting/p2pbbs/bbscrypto"
+	"github.com/rightfoot-consulting/p2pbbs/chatv2"
+	"github.com/rightfoot-consulting/p2pbbs/dm"
+	"github.com/rightfoot-consulting/p2pbbs/internal/loopback"
+)
+
+var logger = logging.Logger("mailbbs")
+
+// DefaultSMTPAddress is where the submission server listens when no address is given.  Mail
+// clients don't log in and send as the node, so the servers refuse addresses off the loopback
+// interface.
+const DefaultSMTPAddress = "127.0.0.1:2525"
+
+// IdleTimeout is how long a mail client may sit idle before it is disconnected.
+const IdleTimeout = 10 * time.Minute
+
+const (
+	// maxMailSize bounds the submitted message, headers included, as advertised in SIZE.
+	maxMailSize = 4 * dm.MaxBodyLength
+	// maxRecipients bounds the recipients of one message.
+	maxRecipients = 100
+	// sentTTL is how long a message is remembered as sent to a recipient, so a client retrying
+	// after a partial failure doesn't send it twice.
+	sentTTL = 24 * time.Hour
+)
+
+// SMTPServer accepts mail for <peer>@p2pbbs.local and delivers each message as a sealed p2p
+// direct message signed with the node's key.  It is a submission server, mail for any other
+// domain is refused rather than relayed.  Messages are queued in the outbox of dms until their
+// recipients confirm them, so offline recipients get them when they come back.
+type SMTPServer struct {
+	dms        *dm.Service
+	names      *chatv2.NameBook
+	privateKey crypto.PrivKey
+	nick       string
+
+	mu   sync.Mutex
+	sent map[[sha256.Size]byte]time.Time
+}
+
+// NewSMTPServer returns a server sending direct messages through dms from the owner of
+// privateKey, recipients being looked up in names when they aren't peer ids.
+func NewSMTPServer(dms *dm.Service, names *chatv2.NameBook, privateKey crypto.PrivKey, nick string) *SMTPServer {
+	return &SMTPServer{dms: dms, names: names, privateKey: privateKey, nick: nick, sent: make(map[[sha256.Size]byte]time.Time)}
+}
+
+// send queues data for a recipient unless it was already sent to them, returning the id of the
+// message or "" for a repeat.
+func (s *SMTPServer) send(to peer.ID, data []byte, text string) (id string, err error) {
+	key := sha256.Sum256(append([]byte(to.String()+"\n"), data...))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for k, expires := range s.sent {
+		if now.After(expires) {
+			delete(s.sent, k)
+		}
+	}
+	if _, ok := s.sent[key]; ok {
+		return
+	}
+	m, err := s.dms.Send(s.privateKey, s.nick, to, text)
+	if err != nil {
+		return
+	}
+	s.sent[key] = now.Add(sentTTL)
+	return m.ID, nil
+}
+
+// ListenAndServe accepts connections on address, which must be a loopback address, until ctx
+// ends.
+func (s *SMTPServer) ListenAndServe(ctx context.Context, address string) error {
+	listener, err := loopback.Listen("SMTP server", address)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ctx, listener)
+}
+
+// Serve accepts connections on listener until ctx ends.
+func (s *SMTPServer) Serve(ctx context.Context, listener net.Listener) error {
+	return serve(ctx, listener, s.handleConn)
+}
+
+// smtpSession is the state of one SMTP connection.
+type smtpSession struct {
+	*SMTPServer
+	conn       net.Conn
+	text       *textproto.Conn
+	greeted    bool
+	mailFrom   bool
+	recipients []peer.ID
+}
+
+func (s *SMTPServer) handleConn(conn net.Conn) {
+	sess := &smtpSession{SMTPServer: s, conn: conn, text: textproto.NewConn(conn)}
+	defer sess.text.Close()
+	sess.reply(220, "%s ESMTP p2pbbs ready", Domain)
+	for {
+		conn.SetReadDeadline(time.Now().Add(IdleTimeout))
+		line, err := sess.text.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		verb = strings.ToUpper(verb)
+		if verb == "QUIT" {
+			sess.reply(221, "2.0.0 Bye")
+			return
+		}
+		if err = sess.handle(verb, strings.TrimSpace(arg)); err != nil {
+			logger.Debugf("connection from %s ended: %v", conn.RemoteAddr(), err)
+			return
+		}
+	}
+}
+
+// reply sends a single line response.
+func (sess *smtpSession) reply(code int, format string, args ...interface{}) error {
+	return sess.text.PrintfLine("%d %s", code, fmt.Sprintf(format, args...))
+}
+
+// reset forgets the message being submitted.
+func (sess *smtpSession) reset() {
+	sess.mailFrom = false
+	sess.recipients = nil
+}
+
+// handle runs one command, returning an error only when the connection is broken.
+func (sess *smtpSession) handle(verb string, arg string) error {
+	switch verb {
+	case "HELO":
+		sess.greeted = true
+		sess.reset()
+		return sess.reply(250, "%s", Domain)
+	case "EHLO":
+		sess.greeted = true
+		sess.reset()
+		for _, extension := range []string{Domain, "8BITMIME", fmt.Sprintf("SIZE %d", maxMailSize), "ENHANCEDSTATUSCODES"} {
+			if err := sess.text.PrintfLine("250-%s", extension); err != nil {
+				return err
+			}
+		}
+		return sess.reply(250, "HELP")
+	case "MAIL":
+		from, ok := cutPath(arg, "FROM:")
+		switch {
+		case !sess.greeted:
+			return sess.reply(503, "5.5.1 Say HELO or EHLO first")
+		case sess.mailFrom:
+			return sess.reply(503, "5.5.1 Sender already given")
+		case !ok:
+			return sess.reply(501, "5.5.4 Syntax: MAIL FROM:<address>")
+		}
+		// the message is sent as the node whatever the envelope says
+		logger.Debugf("mail from %q", from)
+		sess.mailFrom = true
+		return sess.reply(250, "2.1.0 Ok")
+	case "RCPT":
+		to, ok := cutPath(arg, "TO:")
+		switch {
+		case !sess.mailFrom:
+			return sess.reply(503, "5.5.1 Need MAIL first")
+		case !ok:
+			return sess.reply(501, "5.5.4 Syntax: RCPT TO:<address>")
+		case len(sess.recipients) >= maxRecipients:
+			return sess.reply(452, "4.5.3 Too many recipients")
+		}
+		id, err := resolveRecipient(sess.names, to)
+		if err != nil {
+			return sess.reply(550, "5.1.1 <%s>: %v", to, err)
+		}
+		recipientKey, err := id.ExtractPublicKey()
+		if err != nil || !bbscrypto.Sealable(sess.privateKey, recipientKey) {
+			return sess.reply(550, "5.7.1 <%s>: messages to this peer can't be encrypted", to)
+		}
+		sess.recipients = append(sess.recipients, id)
+		return sess.reply(250, "2.1.5 Ok")
+	case "DATA":
+		if len(sess.recipients) == 0 {
+			return sess.reply(503, "5.5.1 Need RCPT first")
+		}
+		return sess.data()
+	case "RSET":
+		sess.reset()
+		return sess.reply(250, "2.0.0 Ok")
+	case "NOOP":
+		return sess.reply(250, "2.0.0 Ok")
+	case "VRFY":
+		return sess.reply(252, "2.1.5 Send some mail and see")
+	case "HELP":
+		return sess.reply(214, "2.0.0 Send mail to <peer id or petname>@%s", Domain)
+	default:
+		return sess.reply(502, "5.5.2 Command not recognized")
+	}
+}
+
+// data reads the message and sends it to every recipient.
+func (sess *smtpSession) data() error {
+	if err := sess.reply(354, "End data with <CR><LF>.<CR><LF>"); err != nil {
+		return err
+	}
+	reader := sess.text.DotReader()
+	data, err := io.ReadAll(io.LimitReader(reader, maxMailSize+1))
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(io.Discard, reader); err != nil {
+		return err
+	}
+	recipients := sess.recipients
+	sess.reset()
+	if len(data) > maxMailSize {
+		return sess.reply(552, "5.3.4 Message is bigger than %d bytes", maxMailSize)
+	}
+	text, err := parseMail(data)
+	if err != nil {
+		return sess.reply(554, "5.6.0 %v", err)
+	}
+	if len(text) > dm.MaxBodyLength {
+		return sess.reply(552, "5.3.4 Message text is bigger than %d bytes", dm.MaxBodyLength)
+	}
+	// every recipient is tried, those that already have the message from an earlier attempt
+	// are skipped, so the client retrying after a failure only reaches the ones that failed
+	ids := make([]string, 0, len(recipients))
+	var failed []string
+	for _, to := range recipients {
+		id, err := sess.send(to, data, text)
+		if err != nil {
+			logger.Infof("queueing mail to %s: %v", to, err)
+			failed = append(failed, to.String())
+			continue
+		}
+		if id != "" {
+			ids = append(ids, id)
+		}
+	}
+	if len(failed) > 0 {
+		return sess.reply(451, "4.3.0 Delivery to %s failed, retry to reach them", strings.Join(failed, " "))
+	}
+	return sess.reply(250, "2.0.0 Queued as %s", strings.Join(ids, " "))
+}
+
+// cutPath returns the address of a MAIL FROM or RCPT TO argument, ignoring any parameters
+// after it.
+func cutPath(arg string, prefix string) (string, bool) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", false
+	}
+	path := strings.TrimSpace(arg[len(prefix):])
+	if !strings.HasPrefix(path, "<") {
+		return "", false
+	}
+	addr, _, ok := strings.Cut(path[1:], ">")
+	return addr, ok
+}
+
+// serve accepts connections on listener until ctx ends, handling each on its own goroutine
+// and closing them all when ctx ends.
+func serve(ctx context.Context, listener net.Listener, handle func(conn net.Conn)) error {
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go func() {
+			stop := context.AfterFunc(ctx, func() { conn.Close() })
+			defer stop()
+			handle(conn)
+		}()
+	}
+}
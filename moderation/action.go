@@ -0,0 +1,227 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package moderation
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/rightfoot-consulting/p2pbbs/bbscrypto"
+)
+
+// Kind is what a moderation action does.
+type Kind string
+
+const (
+	// Delete removes the post named by Target from every node's board store.
+	Delete Kind = "delete"
+	// Pin shows the thread named by Target before the others on its board.
+	Pin Kind = "pin"
+	// Unpin undoes Pin.
+	Unpin Kind = "unpin"
+	// Topic sets the topic of the board or room to Topic.
+	Topic Kind = "topic"
+	// Mute silences the peer named by Target until Until.
+	Mute Kind = "mute"
+	// Unmute undoes Mute.
+	Unmute Kind = "unmute"
+	// Ban silences the peer named by Target until it is unbanned.
+	Ban Kind = "ban"
+	// Unban undoes Ban.
+	Unban Kind = "unban"
+)
+
+// Kinds lists every kind of action.
+var Kinds = []Kind{Delete, Pin, Unpin, Topic, Mute, Unmute, Ban, Unban}
+
+const (
+	// MaxTopicLength bounds the topic of a board or room.
+	MaxTopicLength = 256
+	// MaxReasonLength bounds the reason given for an action.
+	MaxReasonLength = 256
+)
+
+// ParseKind converts the name of a kind of action back into a Kind.
+func ParseKind(val string) (Kind, error) {
+	for _, kind := range Kinds {
+		if string(kind) == strings.ToLower(val) {
+			return kind, nil
+		}
+	}
+	return "", fmt.Errorf("invalid moderation action %s", val)
+}
+
+// targetsPeer reports whether the target of a kind of action is a peer id rather than a post.
+func (k Kind) targetsPeer() bool {
+	return k == Mute || k == Unmute || k == Ban || k == Unban
+}
+
+// Action is a moderation action signed by a moderator of Scope.  Nodes apply the actions of
+// peers the current manifest of the scope names as owner or moderator, in the order they were
+// created.  ID is the content id of the signed action.
+type Action struct {
+	ID        string    `json:"id,omitempty"`
+	Scope     string    `json:"scope"`
+	Kind      Kind      `json:"kind"`
+	Target    string    `json:"target,omitempty"`
+	Until     time.Time `json:"until,omitempty"`
+	Topic     string    `json:"topic,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	Moderator string    `json:"moderator"`
+	Created   time.Time `json:"created"`
+	PublicKey []byte    `json:"public_key,omitempty"`
+	Signature []byte    `json:"signature,omitempty"`
+}
+
+// NewAction creates and signs an action on scope by the owner of privateKey.  target is a post
+// id or a peer id depending on kind, topic is only used by Topic and duration only by Mute.
+func NewAction(privateKey crypto.PrivKey, scope string, kind Kind, target string, topic string, duration time.Duration, reason string) (a *Action, err error) {
+	moderator, err := peer.IDFromPrivateKey(privateKey)
+	if err != nil {
+		return
+	}
+	a = &Action{
+		Scope:     scope,
+		Kind:      kind,
+		Target:    target,
+		Topic:     strings.TrimSpace(topic),
+		Reason:    strings.TrimSpace(reason),
+		Moderator: moderator.String(),
+		Created:   time.Now().UTC(),
+	}
+	if kind == Mute {
+		a.Until = a.Created.Add(duration)
+	}
+	a.PublicKey, err = bbscrypto.VerificationKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	data, err := a.signingBytes()
+	if err != nil {
+		return nil, err
+	}
+	a.Signature, err = privateKey.Sign(data)
+	if err != nil {
+		return nil, err
+	}
+	a.ID, err = a.contentID()
+	if err != nil {
+		return nil, err
+	}
+	if err = a.Verify(); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Verify checks that the action is well formed, signed by its moderator and that ID matches its
+// content.  Whether the moderator may act on the scope is up to the store.
+func (a *Action) Verify() (err error) {
+	if !ValidScope(a.Scope) {
+		return fmt.Errorf("invalid scope %q", a.Scope)
+	}
+	if _, err = ParseKind(string(a.Kind)); err != nil {
+		return
+	}
+	switch {
+	case a.Kind.targetsPeer():
+		if _, err = peer.Decode(a.Target); err != nil {
+			return fmt.Errorf("invalid peer %q: %w", a.Target, err)
+		}
+	case a.Kind == Topic:
+		if a.Target != "" {
+			return fmt.Errorf("a topic has no target")
+		}
+	default:
+		if a.Target == "" || len(a.Target) > 128 {
+			return fmt.Errorf("invalid post %q", a.Target)
+		}
+	}
+	if a.Kind == Mute && !a.Until.After(a.Created) {
+		return fmt.Errorf("a mute must end after it starts")
+	}
+	if len(a.Topic) > MaxTopicLength || strings.ContainsAny(a.Topic, "\r\n") {
+		return fmt.Errorf("invalid topic %q", a.Topic)
+	}
+	if len(a.Reason) > MaxReasonLength || strings.ContainsAny(a.Reason, "\r\n") {
+		return fmt.Errorf("invalid reason %q", a.Reason)
+	}
+	moderator, err := peer.Decode(a.Moderator)
+	if err != nil {
+		return
+	}
+	if a.Created.After(time.Now().Add(MaxClockSkew)) {
+		return fmt.Errorf("action created in the future: %v", a.Created)
+	}
+	data, err := a.signingBytes()
+	if err != nil {
+		return
+	}
+	if err = bbscrypto.Verify(moderator, a.PublicKey, data, a.Signature); err != nil {
+		return
+	}
+	id, err := a.contentID()
+	if err != nil {
+		return
+	}
+	if id != a.ID {
+		return fmt.Errorf("action id %s does not match its content %s", a.ID, id)
+	}
+	return
+}
+
+// ModeratorID returns the decoded moderator, the action must have been verified.
+func (a *Action) ModeratorID() peer.ID {
+	id, _ := peer.Decode(a.Moderator)
+	return id
+}
+
+// pastTense describes what each kind of action did.
+var pastTense = map[Kind]string{
+	Delete: "deleted",
+	Pin:    "pinned",
+	Unpin:  "unpinned",
+	Mute:   "muted",
+	Unmute: "unmuted",
+	Ban:    "banned",
+	Unban:  "unbanned",
+}
+
+// String describes the action for people.
+func (a *Action) String() string {
+	what := fmt.Sprintf("%s %s in %s", pastTense[a.Kind], a.Target, a.Scope)
+	switch a.Kind {
+	case Topic:
+		what = fmt.Sprintf("set the topic of %s to %q", a.Scope, a.Topic)
+	case Mute:
+		what += " until " + a.Until.Local().Format(time.DateTime)
+	}
+	if a.Reason != "" {
+		what += " (" + a.Reason + ")"
+	}
+	return fmt.Sprintf("%s %s", a.Moderator, what)
+}
+
+// signingBytes is the JSON encoding of the action without its id or signature.
+func (a *Action) signingBytes() ([]byte, error) {
+	unsigned := *a
+	unsigned.ID = ""
+	unsigned.Signature = nil
+	return json.Marshal(&unsigned)
+}
+
+// contentID hashes the signed action without its id.
+func (a *Action) contentID() (string, error) {
+	content := *a
+	content.ID = ""
+	data, err := json.Marshal(&content)
+	if err != nil {
+		return "", err
+	}
+	return bbscrypto.ContentID(data)
+}
@@ -0,0 +1,168 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package moderation
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/rightfoot-consulting/p2pbbs/bbscrypto"
+)
+
+const (
+	// MaxModerators bounds the moderators a manifest may name.
+	MaxModerators = 64
+	// MaxClockSkew is how far in the future a record may be dated before it is rejected.
+	MaxClockSkew = 10 * time.Minute
+)
+
+// validScope matches the scopes moderation applies to: a board or a chat room.
+var validScope = regexp.MustCompile(`^(board|room):[^\s]{1,64}$`)
+
+// BoardScope returns the scope of a board.
+func BoardScope(board string) string {
+	return "board:" + board
+}
+
+// RoomScope returns the scope of a chat room.
+func RoomScope(room string) string {
+	return "room:" + room
+}
+
+// ValidScope reports whether scope names a board or a room.
+func ValidScope(scope string) bool {
+	return validScope.MatchString(scope)
+}
+
+// Manifest names who runs a board or room: the owner who signs it and the moderators the owner
+// appoints, and for a board the proof of work posts must carry.  Nodes only accept manifests
+// from the owner they pin for the scope, a newer one replacing the last.
+type Manifest struct {
+	Scope      string       `json:"scope"`
+	Owner      string       `json:"owner"`
+	Moderators []string     `json:"moderators,omitempty"`
+	Stamp      *StampPolicy `json:"stamp,omitempty"`
+	Created    time.Time    `json:"created"`
+	PublicKey  []byte       `json:"public_key,omitempty"`
+	Signature  []byte       `json:"signature,omitempty"`
+}
+
+// NewManifest creates and signs a manifest for scope owned by the owner of privateKey.  stamp is
+// nil when posts need no proof of work.
+func NewManifest(privateKey crypto.PrivKey, scope string, moderators []peer.ID, stamp *StampPolicy) (m *Manifest, err error) {
+	owner, err := peer.IDFromPrivateKey(privateKey)
+	if err != nil {
+		return
+	}
+	m = &Manifest{
+		Scope:   scope,
+		Owner:   owner.String(),
+		Stamp:   stamp,
+		Created: time.Now().UTC(),
+	}
+	for _, id := range moderators {
+		if id != owner {
+			m.Moderators = append(m.Moderators, id.String())
+		}
+	}
+	m.PublicKey, err = bbscrypto.VerificationKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	data, err := m.signingBytes()
+	if err != nil {
+		return nil, err
+	}
+	m.Signature, err = privateKey.Sign(data)
+	if err != nil {
+		return nil, err
+	}
+	if err = m.Verify(); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Verify checks that the manifest is well formed and signed by its owner.
+func (m *Manifest) Verify() (err error) {
+	if !ValidScope(m.Scope) {
+		return fmt.Errorf("invalid scope %q", m.Scope)
+	}
+	owner, err := peer.Decode(m.Owner)
+	if err != nil {
+		return
+	}
+	if len(m.Moderators) > MaxModerators {
+		return fmt.Errorf("%d moderators, the limit is %d", len(m.Moderators), MaxModerators)
+	}
+	for _, moderator := range m.Moderators {
+		if _, err = peer.Decode(moderator); err != nil {
+			return fmt.Errorf("invalid moderator %q: %w", moderator, err)
+		}
+	}
+	if m.Stamp != nil {
+		if !strings.HasPrefix(m.Scope, BoardScope("")) {
+			return fmt.Errorf("only boards take a proof of work")
+		}
+		if err = m.Stamp.Validate(); err != nil {
+			return
+		}
+	}
+	if m.Created.After(time.Now().Add(MaxClockSkew)) {
+		return fmt.Errorf("manifest created in the future: %v", m.Created)
+	}
+	data, err := m.signingBytes()
+	if err != nil {
+		return
+	}
+	return bbscrypto.Verify(owner, m.PublicKey, data, m.Signature)
+}
+
+// OwnerID returns the decoded owner, the manifest must have been verified.
+func (m *Manifest) OwnerID() peer.ID {
+	id, _ := peer.Decode(m.Owner)
+	return id
+}
+
+// ModeratorIDs returns the decoded moderators other than the owner.
+func (m *Manifest) ModeratorIDs() []peer.ID {
+	ids := make([]peer.ID, 0, len(m.Moderators))
+	for _, moderator := range m.Moderators {
+		if id, err := peer.Decode(moderator); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// IsModerator reports whether id may moderate the scope, which the owner always may.
+func (m *Manifest) IsModerator(id peer.ID) bool {
+	if id.String() == m.Owner {
+		return true
+	}
+	for _, moderator := range m.Moderators {
+		if moderator == id.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// Name returns the board or room name of the scope.
+func (m *Manifest) Name() string {
+	_, name, _ := strings.Cut(m.Scope, ":")
+	return name
+}
+
+// signingBytes is the JSON encoding of the manifest without its signature.
+func (m *Manifest) signingBytes() ([]byte, error) {
+	unsigned := *m
+	unsigned.Signature = nil
+	return json.Marshal(&unsigned)
+}
@@ -0,0 +1,138 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package moderation
+
+import (
+	"context"
+	"encoding/json"
+
+	logging "github.com/ipfs/go-log/v2"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+var logger = logging.Logger("moderation")
+
+// TopicName is the PubSub topic manifests and actions are published on.
+const TopicName = "moderation"
+
+// envelope carries either a manifest or an action over PubSub.
+type envelope struct {
+	Manifest *Manifest `json:"manifest,omitempty"`
+	Action   *Action   `json:"action,omitempty"`
+}
+
+// Service publishes manifests and actions to every node and keeps the ones other peers publish
+// that verify and are authorized.  Records are checked before they are delivered or forwarded.
+type Service struct {
+	ctx   context.Context
+	store *Store
+	topic *pubsub.Topic
+}
+
+// NewService joins the moderation topic, keeping what arrives in store.
+func NewService(ctx context.Context, ps *pubsub.PubSub, store *Store) (s *Service, err error) {
+	s = &Service{ctx: ctx, store: store}
+	if err = ps.RegisterTopicValidator(TopicName, s.validate); err != nil {
+		return nil, err
+	}
+	topic, err := ps.Join(TopicName)
+	if err != nil {
+		ps.UnregisterTopicValidator(TopicName)
+		return nil, err
+	}
+	sub, err := topic.Subscribe()
+	if err != nil {
+		topic.Close()
+		ps.UnregisterTopicValidator(TopicName)
+		return nil, err
+	}
+	s.topic = topic
+	go s.readLoop(sub)
+	return
+}
+
+// Store returns the store the service fills.
+func (s *Service) Store() *Store {
+	return s.store
+}
+
+// PublishManifest saves a manifest and sends it to the other peers.
+func (s *Service) PublishManifest(m *Manifest) (err error) {
+	if _, err = s.store.AddManifest(m); err != nil {
+		return
+	}
+	return s.publish(&envelope{Manifest: m})
+}
+
+// PublishAction saves an action and sends it to the other peers.
+func (s *Service) PublishAction(a *Action) (err error) {
+	if _, err = s.store.AddAction(a); err != nil {
+		return
+	}
+	return s.publish(&envelope{Action: a})
+}
+
+func (s *Service) publish(e *envelope) error {
+	msgBytes, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return s.topic.Publish(s.ctx, msgBytes)
+}
+
+// validate is the pubsub.ValidatorEx of the moderation topic.  Malformed and forged records
+// are rejected.  Records the store can't use yet are ignored rather than rejected: other nodes
+// may pin other owners, or have seen a manifest naming a moderator that hasn't reached us.
+func (s *Service) validate(_ context.Context, _ peer.ID, msg *pubsub.Message) pubsub.ValidationResult {
+	e := new(envelope)
+	if err := json.Unmarshal(msg.Data, e); err != nil || (e.Manifest == nil) == (e.Action == nil) {
+		logger.Debugf("rejecting moderation record from %s: malformed", msg.GetFrom())
+		return pubsub.ValidationReject
+	}
+	var err error
+	if e.Manifest != nil {
+		err = e.Manifest.Verify()
+	} else {
+		err = e.Action.Verify()
+	}
+	if err != nil {
+		logger.Debugf("rejecting moderation record from %s: %v", msg.GetFrom(), err)
+		return pubsub.ValidationReject
+	}
+	if e.Manifest != nil {
+		err = s.store.CheckManifest(e.Manifest)
+	} else {
+		err = s.store.CheckAction(e.Action)
+	}
+	if err != nil {
+		logger.Debugf("ignoring moderation record from %s: %v", msg.GetFrom(), err)
+		return pubsub.ValidationIgnore
+	}
+	return pubsub.ValidationAccept
+}
+
+// readLoop saves the records published on the topic until the service's context ends.  The
+// validator has already checked them.
+func (s *Service) readLoop(sub *pubsub.Subscription) {
+	for {
+		msg, err := sub.Next(s.ctx)
+		if err != nil {
+			return
+		}
+		e := new(envelope)
+		if err = json.Unmarshal(msg.Data, e); err != nil {
+			continue
+		}
+		switch {
+		case e.Manifest != nil:
+			_, err = s.store.AddManifest(e.Manifest)
+		case e.Action != nil:
+			_, err = s.store.AddAction(e.Action)
+		}
+		if err != nil {
+			logger.Debugf("rejected moderation record from %s: %v", msg.GetFrom(), err)
+		}
+	}
+}
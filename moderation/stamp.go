@@ -0,0 +1,60 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package moderation
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/rightfoot-consulting/p2pbbs/pow"
+)
+
+// StampWindow is how far back the posts a board received are counted to adapt its difficulty.
+const StampWindow = time.Hour
+
+// StampPolicy is the proof of work the owner of a board asks of every post: a hashcash stamp of
+// at least Bits over the signed post.  When TargetPerHour is set the difficulty adapts to the
+// volume of the board, one more bit for every doubling of the posts received in the last
+// StampWindow beyond the target, up to MaxBits.
+type StampPolicy struct {
+	Bits          int `json:"bits"`
+	MaxBits       int `json:"max_bits,omitempty"`
+	TargetPerHour int `json:"target_per_hour,omitempty"`
+}
+
+// Validate checks the difficulties are within what can be mined.
+func (sp *StampPolicy) Validate() error {
+	if sp.Bits < 0 || sp.Bits > pow.MaxBits {
+		return fmt.Errorf("stamp difficulty must be between 0 and %d bits, not %d", pow.MaxBits, sp.Bits)
+	}
+	if sp.MaxBits != 0 && (sp.MaxBits < sp.Bits || sp.MaxBits > pow.MaxBits) {
+		return fmt.Errorf("maximum stamp difficulty must be between %d and %d bits, not %d", sp.Bits, pow.MaxBits, sp.MaxBits)
+	}
+	if sp.TargetPerHour < 0 {
+		return fmt.Errorf("invalid target of %d posts an hour", sp.TargetPerHour)
+	}
+	return nil
+}
+
+// Required returns the difficulty to mine a stamp at when the board received recent posts in the
+// last StampWindow.
+func (sp *StampPolicy) Required(recent int) int {
+	if sp.TargetPerHour == 0 || recent <= sp.TargetPerHour {
+		return sp.Bits
+	}
+	maxBits := sp.MaxBits
+	if maxBits == 0 {
+		maxBits = pow.MaxBits
+	}
+	extra := int(math.Ceil(math.Log2(float64(recent) / float64(sp.TargetPerHour))))
+	return min(sp.Bits+extra, maxBits)
+}
+
+// Accepts reports whether a stamp of the given difficulty is enough when the board received
+// recent posts in the last StampWindow.  Nodes count the posts they hold, which differ a little
+// from node to node, so a stamp one bit short of an adapted difficulty still passes.
+func (sp *StampPolicy) Accepts(bits int, recent int) bool {
+	return bits >= max(sp.Bits, sp.Required(recent)-1)
+}
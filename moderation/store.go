@@ -0,0 +1,374 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package moderation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// StoreFile is the name of the file in the data directory holding manifests and actions.
+const StoreFile = "moderation.json"
+
+// MaxScopes bounds the boards and rooms a node pins owners for, and so the manifests it keeps.
+const MaxScopes = 256
+
+var (
+	// ErrNoManifest is returned for actions on a scope nobody has claimed.
+	ErrNoManifest = errors.New("nobody moderates this board or room")
+	// ErrNotPinned is returned for manifests on a scope the node pins no owner for.
+	ErrNotPinned = errors.New("no owner is pinned for this board or room")
+)
+
+// Records is what a store holds, and what is exchanged when syncing history.
+type Records struct {
+	Manifests []*Manifest `json:"manifests"`
+	Actions   []*Action   `json:"actions"`
+}
+
+// Store keeps the manifests and the actions of their moderators, persisted as JSON in the
+// node's data directory.  Only the owner pinned for a scope, in the node's configuration or the
+// invite it joined with, may sign its manifest, so every node pinning the same owners agrees on
+// who moderates, whichever manifests reach it first.
+type Store struct {
+	file   string
+	owners map[string]peer.ID
+
+	mu          sync.RWMutex
+	manifests   map[string]*Manifest
+	actions     map[string]*Action
+	scoped      map[string][]*Action
+	views       map[string]*View
+	subscribers map[chan string]struct{}
+}
+
+// LoadStore reads the records in file, a missing file gives an empty store.  owners pins the
+// owner of each scope that can be moderated.  Records that no longer verify, and manifests not
+// signed by the pinned owner, are dropped.
+func LoadStore(file string, owners map[string]peer.ID) (store *Store, err error) {
+	if len(owners) > MaxScopes {
+		return nil, fmt.Errorf("%d owners pinned, the limit is %d", len(owners), MaxScopes)
+	}
+	for scope := range owners {
+		if !ValidScope(scope) {
+			return nil, fmt.Errorf("invalid scope %q", scope)
+		}
+	}
+	store = &Store{
+		file:        file,
+		owners:      owners,
+		manifests:   make(map[string]*Manifest),
+		actions:     make(map[string]*Action),
+		scoped:      make(map[string][]*Action),
+		views:       make(map[string]*View),
+		subscribers: make(map[chan string]struct{}),
+	}
+	jsonBytes, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var records Records
+	if err = json.Unmarshal(jsonBytes, &records); err != nil {
+		return nil, err
+	}
+	for _, m := range records.Manifests {
+		if m.Verify() == nil && store.pinned(m) == nil {
+			store.mergeManifest(m)
+		}
+	}
+	for _, a := range records.Actions {
+		if a.Verify() == nil {
+			store.mergeAction(a)
+		}
+	}
+	return
+}
+
+// AddManifest verifies a manifest and keeps it if the pinned owner of its scope signed it more
+// recently than the one it replaces.  The store is saved when it changes.
+func (s *Store) AddManifest(m *Manifest) (changed bool, err error) {
+	if err = m.Verify(); err != nil {
+		return
+	}
+	if err = s.pinned(m); err != nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if changed = s.mergeManifest(m); changed {
+		err = s.save()
+		s.notify(m.Scope)
+	}
+	return
+}
+
+// AddAction verifies an action and keeps it if it is new and its moderator may act on the
+// scope.  The store is saved when it changes.
+func (s *Store) AddAction(a *Action) (added bool, err error) {
+	if err = a.Verify(); err != nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err = s.authorize(a); err != nil {
+		return
+	}
+	if added = s.mergeAction(a); added {
+		err = s.save()
+		s.notify(a.Scope)
+	}
+	return
+}
+
+// AddAll adds a batch of records, skipping invalid ones, and saves once.  Manifests are added
+// first so the actions they authorize are accepted.
+func (s *Store) AddAll(records *Records) (added int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	changed := make(map[string]bool)
+	for _, m := range records.Manifests {
+		if m.Verify() != nil || s.pinned(m) != nil {
+			continue
+		}
+		if s.mergeManifest(m) {
+			changed[m.Scope] = true
+			added++
+		}
+	}
+	for _, a := range records.Actions {
+		if a.Verify() != nil || s.authorize(a) != nil {
+			continue
+		}
+		if s.mergeAction(a) {
+			changed[a.Scope] = true
+			added++
+		}
+	}
+	if added > 0 {
+		err = s.save()
+	}
+	for scope := range changed {
+		s.notify(scope)
+	}
+	return
+}
+
+// Owner returns the owner pinned for a scope.
+func (s *Store) Owner(scope string) (peer.ID, bool) {
+	owner, ok := s.owners[scope]
+	return owner, ok
+}
+
+// CheckManifest reports why a verified manifest can't be added, or nil when it can.
+func (s *Store) CheckManifest(m *Manifest) error {
+	return s.pinned(m)
+}
+
+// CheckAction reports why a verified action can't be added, or nil when it can.
+func (s *Store) CheckAction(a *Action) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.authorize(a)
+}
+
+// Manifest returns the manifest of a scope.
+func (s *Store) Manifest(scope string) (*Manifest, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	m, ok := s.manifests[scope]
+	return m, ok
+}
+
+// Actions returns the actions on a scope, oldest first.
+func (s *Store) Actions(scope string) []*Action {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]*Action(nil), s.scoped[scope]...)
+}
+
+// All returns the records on the given scopes, every record when none are given.
+func (s *Store) All(scopes ...string) *Records {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	wanted := make(map[string]bool)
+	for _, scope := range scopes {
+		wanted[scope] = true
+	}
+	records := &Records{Manifests: make([]*Manifest, 0), Actions: make([]*Action, 0)}
+	for scope, m := range s.manifests {
+		if len(wanted) == 0 || wanted[scope] {
+			records.Manifests = append(records.Manifests, m)
+		}
+	}
+	sort.Slice(records.Manifests, func(i, j int) bool { return records.Manifests[i].Scope < records.Manifests[j].Scope })
+	for _, a := range s.sortedActions() {
+		if len(wanted) == 0 || wanted[a.Scope] {
+			records.Actions = append(records.Actions, a)
+		}
+	}
+	return records
+}
+
+// View applies the actions on a scope by its current moderators and returns the result.  A
+// scope without a manifest gives an empty view.  Views are checked for every message and post,
+// so the view of a scope is kept until the scope changes and is shared by the callers, who must
+// not change it.
+func (s *Store) View(scope string) *View {
+	s.mu.RLock()
+	v, ok := s.views[scope]
+	s.mu.RUnlock()
+	if ok {
+		return v
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if v, ok = s.views[scope]; !ok {
+		v = s.view(scope)
+		s.views[scope] = v
+	}
+	return v
+}
+
+// view applies the actions on a scope by its current moderators, the caller must hold the lock.
+func (s *Store) view(scope string) *View {
+	v := &View{
+		Scope:   scope,
+		deleted: make(map[string]bool),
+		pinned:  make(map[string]time.Time),
+		banned:  make(map[peer.ID]bool),
+		muted:   make(map[peer.ID]time.Time),
+	}
+	m, ok := s.manifests[scope]
+	if !ok {
+		return v
+	}
+	v.Manifest = m
+	for _, a := range s.scoped[scope] {
+		if m.IsModerator(a.ModeratorID()) {
+			v.apply(a)
+		}
+	}
+	return v
+}
+
+// Subscribe returns a channel receiving the scope of every change from now on.  Call the
+// returned function to stop receiving.
+func (s *Store) Subscribe() (<-chan string, func()) {
+	ch := make(chan string, 64)
+	s.mu.Lock()
+	s.subscribers[ch] = struct{}{}
+	s.mu.Unlock()
+	return ch, func() {
+		s.mu.Lock()
+		delete(s.subscribers, ch)
+		s.mu.Unlock()
+	}
+}
+
+// pinned checks that m is signed by the owner pinned for its scope.  The pins don't change, so
+// no lock is needed.
+func (s *Store) pinned(m *Manifest) error {
+	owner, ok := s.owners[m.Scope]
+	if !ok {
+		return fmt.Errorf("%s: %w", m.Scope, ErrNotPinned)
+	}
+	if m.OwnerID() != owner {
+		return fmt.Errorf("%s is owned by %s", m.Scope, owner)
+	}
+	return nil
+}
+
+// authorize checks that the moderator of a may act on its scope, the caller must hold the lock.
+func (s *Store) authorize(a *Action) error {
+	m, ok := s.manifests[a.Scope]
+	if !ok {
+		return ErrNoManifest
+	}
+	if !m.IsModerator(a.ModeratorID()) {
+		return fmt.Errorf("%s does not moderate %s", a.Moderator, a.Scope)
+	}
+	return nil
+}
+
+// sortedActions returns every action oldest first, the caller must hold the lock.
+func (s *Store) sortedActions() []*Action {
+	all := make([]*Action, 0, len(s.actions))
+	for _, a := range s.actions {
+		all = append(all, a)
+	}
+	sort.Slice(all, func(i, j int) bool { return older(all[i], all[j]) })
+	return all
+}
+
+// older reports whether a sorts before b, oldest first and by id when created together.
+func older(a *Action, b *Action) bool {
+	if a.Created.Equal(b.Created) {
+		return a.ID < b.ID
+	}
+	return a.Created.Before(b.Created)
+}
+
+// mergeManifest keeps m if it is newer than the manifest it would replace, the caller must
+// hold the lock and have checked the owner.
+func (s *Store) mergeManifest(m *Manifest) bool {
+	if current, ok := s.manifests[m.Scope]; ok && !m.Created.After(current.Created) {
+		return false
+	}
+	s.manifests[m.Scope] = m
+	return true
+}
+
+// mergeAction keeps a if it is new, in order among the actions on its scope, the caller must
+// hold the lock.
+func (s *Store) mergeAction(a *Action) bool {
+	if _, ok := s.actions[a.ID]; ok {
+		return false
+	}
+	s.actions[a.ID] = a
+	actions := s.scoped[a.Scope]
+	i := sort.Search(len(actions), func(i int) bool { return older(a, actions[i]) })
+	actions = append(actions, nil)
+	copy(actions[i+1:], actions[i:])
+	actions[i] = a
+	s.scoped[a.Scope] = actions
+	return true
+}
+
+// notify drops the view of a scope that changed and tells the subscribers, the caller must
+// hold the lock.
+func (s *Store) notify(scope string) {
+	delete(s.views, scope)
+	for ch := range s.subscribers {
+		select {
+		case ch <- scope:
+		default:
+			// a slow subscriber misses live updates but can always re-read the store
+		}
+	}
+}
+
+// save writes the store to its file, the caller must hold the lock.
+func (s *Store) save() error {
+	records := &Records{Manifests: make([]*Manifest, 0, len(s.manifests)), Actions: s.sortedActions()}
+	for _, m := range s.manifests {
+		records.Manifests = append(records.Manifests, m)
+	}
+	sort.Slice(records.Manifests, func(i, j int) bool { return records.Manifests[i].Scope < records.Manifests[j].Scope })
+	jsonBytes, err := json.MarshalIndent(records, "", "    ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.file, jsonBytes, 0600)
+}
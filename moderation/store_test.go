@@ -0,0 +1,137 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package moderation
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/rightfoot-consulting/p2pbbs/internal/testkeys"
+)
+
+func act(t *testing.T, store *Store, by testkeys.Identity, kind Kind, target string, topic string, duration time.Duration) (*Action, error) {
+	t.Helper()
+	a, err := NewAction(by.Key, BoardScope("general"), kind, target, topic, duration, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.AddAction(a)
+	return a, err
+}
+
+func TestModeration(t *testing.T) {
+	owner, moderator, spammer, squatter := testkeys.New(t), testkeys.New(t), testkeys.New(t), testkeys.New(t)
+	file := filepath.Join(t.TempDir(), StoreFile)
+	scope := BoardScope("general")
+	owners := map[string]peer.ID{scope: owner.ID}
+	store, err := LoadStore(file, owners)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = act(t, store, owner, Topic, "", "welcome", 0); err != ErrNoManifest {
+		t.Errorf("acted on an unclaimed board: %v", err)
+	}
+
+	manifest, err := NewManifest(owner.Key, scope, []peer.ID{moderator.ID}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = store.AddManifest(manifest); err != nil {
+		t.Fatal(err)
+	}
+	claim, _ := NewManifest(squatter.Key, scope, nil, nil)
+	if _, err = store.AddManifest(claim); err == nil {
+		t.Error("a second owner took over the board")
+	}
+	// nobody owns a scope without a pinned owner, however early they claim it
+	unpinned, _ := NewManifest(squatter.Key, RoomScope("general"), nil, nil)
+	if _, err = store.AddManifest(unpinned); !errors.Is(err, ErrNotPinned) {
+		t.Errorf("claimed a room nobody pins: %v", err)
+	}
+
+	if _, err = act(t, store, spammer, Ban, moderator.ID.String(), "", 0); err == nil {
+		t.Error("accepted an action from somebody who doesn't moderate the board")
+	}
+	for _, a := range []struct {
+		kind     Kind
+		target   string
+		topic    string
+		duration time.Duration
+	}{
+		{Topic, "", "welcome", 0},
+		{Pin, "post1", "", 0},
+		{Pin, "post2", "", 0},
+		{Delete, "post2", "", 0},
+		{Mute, spammer.ID.String(), "", time.Hour},
+		{Ban, owner.ID.String(), "", 0},
+	} {
+		if _, err = act(t, store, moderator, a.kind, a.target, a.topic, a.duration); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now := time.Now()
+	view := store.View(scope)
+	if view.Topic != "welcome" || !view.Deleted("post2") || view.IsPinned("post2") || !view.IsPinned("post1") {
+		t.Errorf("unexpected view %+v", view)
+	}
+	if !view.Silenced(spammer.ID, now) || view.Silenced(spammer.ID, now.Add(2*time.Hour)) {
+		t.Error("the mute doesn't last an hour")
+	}
+	if view.Silenced(owner.ID, now) {
+		t.Error("a moderator silenced the owner")
+	}
+
+	// a node that syncs the records ends up with the same view
+	other, err := LoadStore(filepath.Join(t.TempDir(), StoreFile), owners)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added, err := other.AddAll(store.All()); err != nil || added != 7 {
+		t.Errorf("synced %d records: %v", added, err)
+	}
+	if v := other.View(scope); v.Topic != "welcome" || !v.Deleted("post2") || !v.Silenced(spammer.ID, now) {
+		t.Errorf("unexpected synced view %+v", v)
+	}
+	// the view is kept until an action changes the scope
+	synced := other.View(scope)
+	if other.View(scope) != synced {
+		t.Error("the view was rebuilt although the scope didn't change")
+	}
+	if _, err = act(t, other, moderator, Delete, "post1", "", 0); err != nil {
+		t.Fatal(err)
+	}
+	if v := other.View(scope); v == synced || !v.Deleted("post1") {
+		t.Error("the view didn't change with the scope")
+	}
+
+	// actions stop applying once their moderator is dismissed
+	time.Sleep(time.Millisecond)
+	dismissal, _ := NewManifest(owner.Key, scope, nil, nil)
+	if _, err = store.AddManifest(dismissal); err != nil {
+		t.Fatal(err)
+	}
+	if store.View(scope).Silenced(spammer.ID, now) {
+		t.Error("the mute of a dismissed moderator still applies")
+	}
+
+	reloaded, err := LoadStore(file, owners)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reloaded.Actions(scope)) != 6 || reloaded.View(scope).Topic != "" {
+		t.Errorf("reloaded %d actions", len(reloaded.Actions(scope)))
+	}
+	// a node pinning another owner drops the manifest, and with it the moderators' actions
+	repinned, err := LoadStore(file, map[string]peer.ID{scope: squatter.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repinned.View(scope).Moderated() {
+		t.Error("kept a manifest from an owner that isn't pinned")
+	}
+}
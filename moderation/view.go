@@ -0,0 +1,108 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package moderation
+
+import (
+	"sort"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// View is the state of a board or room once the actions of its moderators are applied.
+type View struct {
+	Scope    string
+	Manifest *Manifest
+	Topic    string
+
+	deleted map[string]bool
+	pinned  map[string]time.Time
+	banned  map[peer.ID]bool
+	muted   map[peer.ID]time.Time
+}
+
+// apply applies an action, actions must be applied oldest first.  The owner can't be silenced.
+func (v *View) apply(a *Action) {
+	if a.Kind.targetsPeer() && a.Target == v.Manifest.Owner {
+		return
+	}
+	target, _ := peer.Decode(a.Target)
+	switch a.Kind {
+	case Delete:
+		v.deleted[a.Target] = true
+		delete(v.pinned, a.Target)
+	case Pin:
+		if !v.deleted[a.Target] {
+			v.pinned[a.Target] = a.Created
+		}
+	case Unpin:
+		delete(v.pinned, a.Target)
+	case Topic:
+		v.Topic = a.Topic
+	case Mute:
+		v.muted[target] = a.Until
+	case Unmute:
+		delete(v.muted, target)
+	case Ban:
+		v.banned[target] = true
+	case Unban:
+		delete(v.banned, target)
+	}
+}
+
+// Moderated reports whether anybody moderates the scope.
+func (v *View) Moderated() bool {
+	return v.Manifest != nil
+}
+
+// IsModerator reports whether id may moderate the scope.
+func (v *View) IsModerator(id peer.ID) bool {
+	return v.Manifest != nil && v.Manifest.IsModerator(id)
+}
+
+// Stamp returns the proof of work posts must carry, nil when none is needed.
+func (v *View) Stamp() *StampPolicy {
+	if v.Manifest == nil {
+		return nil
+	}
+	return v.Manifest.Stamp
+}
+
+// Deleted reports whether a moderator deleted the post with the given id.
+func (v *View) Deleted(id string) bool {
+	return v.deleted[id]
+}
+
+// Pinned returns the ids of the pinned posts, most recently pinned first.
+func (v *View) Pinned() []string {
+	ids := make([]string, 0, len(v.pinned))
+	for id := range v.pinned {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return v.pinned[ids[i]].After(v.pinned[ids[j]]) })
+	return ids
+}
+
+// IsPinned reports whether the post with the given id is pinned.
+func (v *View) IsPinned(id string) bool {
+	_, ok := v.pinned[id]
+	return ok
+}
+
+// Banned reports whether id is banned.
+func (v *View) Banned(id peer.ID) bool {
+	return v.banned[id]
+}
+
+// MutedUntil returns when the mute of id ends, and whether it is muted at all at time at.
+func (v *View) MutedUntil(id peer.ID, at time.Time) (time.Time, bool) {
+	until, ok := v.muted[id]
+	return until, ok && at.Before(until)
+}
+
+// Silenced reports whether id may not post or speak at time at, being banned or muted.
+func (v *View) Silenced(id peer.ID, at time.Time) bool {
+	_, muted := v.MutedUntil(id, at)
+	return muted || v.Banned(id)
+}
@@ -0,0 +1,180 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package nntpbbs
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/rightfoot-consulting/p2pbbs/boards"
+)
+
+// GroupPrefix starts the name of the newsgroup of every board, general is p2pbbs.general.
+const GroupPrefix = "p2pbbs."
+
+// Domain is the right hand side of message ids and author addresses.
+const Domain = "p2pbbs.local"
+
+// maxReferences bounds how many ancestors are listed in References.
+const maxReferences = 20
+
+// groupName returns the newsgroup of a board.
+func groupName(board string) string {
+	return GroupPrefix + board
+}
+
+// boardName returns the board of a newsgroup.
+func boardName(group string) (string, bool) {
+	board, ok := strings.CutPrefix(strings.ToLower(group), GroupPrefix)
+	return board, ok && boards.ValidBoardName(board)
+}
+
+// messageID returns the Message-ID of a post, built from its content id.
+func messageID(id string) string {
+	return "<" + id + "@" + Domain + ">"
+}
+
+// postID returns the content id in a Message-ID.
+func postID(msgID string) (string, bool) {
+	inner, ok := strings.CutPrefix(msgID, "<")
+	if !ok {
+		return "", false
+	}
+	id, ok := strings.CutSuffix(inner, "@"+Domain+">")
+	return id, ok
+}
+
+// header is a header line of an article.
+type header struct {
+	name  string
+	value string
+}
+
+// references returns the Message-IDs of the posts a post answers, oldest first, by following
+// the reply links back to the start of the thread.
+func references(store *boards.Store, p *boards.Post) []string {
+	refs := make([]string, 0)
+	for parent := p.ReplyTo; parent != "" && len(refs) < maxReferences; {
+		refs = append([]string{messageID(parent)}, refs...)
+		ancestor, ok := store.Get(parent)
+		if !ok {
+			break
+		}
+		parent = ancestor.ReplyTo
+	}
+	if p.Thread != "" && (len(refs) == 0 || refs[0] != messageID(p.Thread)) {
+		refs = append([]string{messageID(p.Thread)}, refs...)
+	}
+	return refs
+}
+
+// from is the From header of a post.
+func from(p *boards.Post) string {
+	return (&mail.Address{Name: p.Nick, Address: p.Author + "@" + Domain}).String()
+}
+
+// articleHeaders returns the headers of the article for a post.
+func articleHeaders(store *boards.Store, p *boards.Post) []header {
+	headers := []header{
+		{"Path", "p2pbbs!not-for-mail"},
+		{"From", from(p)},
+		{"Newsgroups", groupName(p.Board)},
+		{"Subject", mime.QEncoding.Encode("utf-8", clean(p.Subject))},
+		{"Date", p.Created.UTC().Format(time.RFC1123Z)},
+		{"Message-ID", messageID(p.ID)},
+	}
+	if refs := references(store, p); len(refs) > 0 {
+		headers = append(headers, header{"References", strings.Join(refs, " ")})
+	}
+	return append(headers,
+		header{"X-P2PBBS-Author", p.Author},
+		header{"MIME-Version", "1.0"},
+		header{"Content-Type", "text/plain; charset=utf-8"},
+		header{"Content-Transfer-Encoding", "8bit"},
+		header{"Lines", fmt.Sprint(lineCount(p.Body))},
+	)
+}
+
+// overview returns the OVER line of an article: number, subject, from, date, message id,
+// references, bytes and lines.
+func overview(store *boards.Store, number int, p *boards.Post) string {
+	fields := []string{
+		fmt.Sprint(number),
+		mime.QEncoding.Encode("utf-8", clean(p.Subject)),
+		from(p),
+		p.Created.UTC().Format(time.RFC1123Z),
+		messageID(p.ID),
+		strings.Join(references(store, p), " "),
+		fmt.Sprint(len(p.Body)),
+		fmt.Sprint(lineCount(p.Body)),
+	}
+	for i, field := range fields {
+		fields[i] = strings.ReplaceAll(field, "\t", " ")
+	}
+	return strings.Join(fields, "\t")
+}
+
+func lineCount(body string) int {
+	return strings.Count(strings.TrimRight(body, "\n"), "\n") + 1
+}
+
+// clean keeps text on one header line.
+func clean(text string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '\r' || r == '\n' || r == '\t' {
+			return ' '
+		}
+		return r
+	}, text)
+}
+
+// submission is an article posted by a newsreader.
+type submission struct {
+	groups     []string
+	subject    string
+	references []string
+	body       string
+}
+
+// parseSubmission reads the headers and body of a posted article.
+func parseSubmission(data []byte) (sub *submission, err error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return
+	}
+	sub = &submission{references: strings.Fields(msg.Header.Get("References"))}
+	for _, group := range strings.Split(msg.Header.Get("Newsgroups"), ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			sub.groups = append(sub.groups, group)
+		}
+	}
+	if len(sub.groups) == 0 {
+		return nil, errors.New("no Newsgroups header")
+	}
+	decoder := new(mime.WordDecoder)
+	if sub.subject, err = decoder.DecodeHeader(msg.Header.Get("Subject")); err != nil {
+		return nil, err
+	}
+	var body io.Reader = msg.Body
+	switch strings.ToLower(msg.Header.Get("Content-Transfer-Encoding")) {
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	}
+	bodyBytes, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	sub.body = strings.TrimRight(strings.ReplaceAll(string(bodyBytes), "\r\n", "\n"), "\n")
+	return
+}
@@ -0,0 +1,95 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package nntpbbs
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"sync"
+
+	"github.com/rightfoot-consulting/p2pbbs/boards"
+)
+
+// NumbersFile is the name of the file in the data directory holding the article numbers.
+const NumbersFile = "nntp-numbers.json"
+
+// Numbers gives the posts of each board the article numbers newsreaders keep track of.  Posts
+// are numbered in the order the server first sees them and the numbers are saved, so a post
+// arriving late from the network gets a new number rather than shifting the others.
+type Numbers struct {
+	file string
+
+	mu     sync.Mutex
+	Boards map[string][]string `json:"boards"`
+	index  map[string]map[string]int
+}
+
+// LoadNumbers reads the article numbers saved in file, starting afresh if it doesn't exist.
+func LoadNumbers(file string) (numbers *Numbers, err error) {
+	numbers = &Numbers{
+		file:   file,
+		Boards: make(map[string][]string),
+		index:  make(map[string]map[string]int),
+	}
+	jsonBytes, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return numbers, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(jsonBytes, numbers); err != nil {
+		return nil, err
+	}
+	for board, ids := range numbers.Boards {
+		numbers.index[board] = make(map[string]int, len(ids))
+		for i, id := range ids {
+			numbers.index[board][id] = i + 1
+		}
+	}
+	return
+}
+
+// Sync numbers the posts of a board that don't have a number yet and returns the ids of the
+// board's articles, article n being at index n-1.
+func (n *Numbers) Sync(board string, posts []*boards.Post) (ids []string, err error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	index, ok := n.index[board]
+	if !ok {
+		index = make(map[string]int)
+		n.index[board] = index
+	}
+	changed := false
+	for _, p := range posts {
+		if _, ok := index[p.ID]; !ok {
+			n.Boards[board] = append(n.Boards[board], p.ID)
+			index[p.ID] = len(n.Boards[board])
+			changed = true
+		}
+	}
+	if changed {
+		err = n.save()
+	}
+	return append([]string(nil), n.Boards[board]...), err
+}
+
+// Number returns the article number of a post on a board.
+func (n *Numbers) Number(board string, id string) (int, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	number, ok := n.index[board][id]
+	return number, ok
+}
+
+// save writes the numbers to the file, the caller holds the lock.
+func (n *Numbers) save() error {
+	jsonBytes, err := json.MarshalIndent(n, "", "    ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(n.file, jsonBytes, 0600)
+}
@@ -0,0 +1,474 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package nntpbbs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/rightfoot-consulting/p2pbbs/boards"
+	"github.com/rightfoot-consulting/p2pbbs/internal/loopback"
+)
+
+var logger = logging.Logger("nntpbbs")
+
+// DefaultAddress is where the server listens when no address is given.  Newsreaders don't log
+// in and post as the node, so the server refuses addresses off the loopback interface.
+const DefaultAddress = "127.0.0.1:1119"
+
+// IdleTimeout is how long a newsreader may sit idle before it is disconnected.
+const IdleTimeout = 10 * time.Minute
+
+// maxArticleLength bounds a posted article, headers included.
+const maxArticleLength = boards.MaxBodyLength + 16<<10
+
+// Server is an NNTP server (RFC 3977) presenting each board as a newsgroup and each post as an
+// article.  Articles posted by newsreaders are signed with the node's identity and published
+// to the network.
+type Server struct {
+	service    *boards.Service
+	numbers    *Numbers
+	privateKey crypto.PrivKey
+	nick       string
+}
+
+// NewServer returns a server for the boards of service, signing posted articles with
+// privateKey as nick.
+func NewServer(service *boards.Service, numbers *Numbers, privateKey crypto.PrivKey, nick string) *Server {
+	return &Server{
+		service:    service,
+		numbers:    numbers,
+		privateKey: privateKey,
+		nick:       nick,
+	}
+}
+
+// ListenAndServe accepts connections on address, which must be a loopback address, until ctx
+// ends.
+func (s *Server) ListenAndServe(ctx context.Context, address string) error {
+	listener, err := loopback.Listen("NNTP server", address)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ctx, listener)
+}
+
+// Serve accepts connections on listener until ctx ends.
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go s.handleConn(ctx, conn)
+	}
+}
+
+// session is the state of one newsreader connection.
+type session struct {
+	*Server
+	conn    net.Conn
+	text    *textproto.Conn
+	group   string
+	board   string
+	current int
+}
+
+func (s *Server) handleConn(ctx context.Context, conn net.Conn) {
+	sess := &session{Server: s, conn: conn, text: textproto.NewConn(conn)}
+	defer sess.text.Close()
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	sess.reply(200, "p2pbbs NNTP service ready, posting allowed")
+	for {
+		conn.SetReadDeadline(time.Now().Add(IdleTimeout))
+		line, err := sess.text.ReadLine()
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			sess.reply(500, "What?")
+			continue
+		}
+		command, args := strings.ToUpper(fields[0]), fields[1:]
+		if command == "QUIT" {
+			sess.reply(205, "Goodbye")
+			return
+		}
+		if err = sess.handle(command, args); err != nil {
+			logger.Debugf("connection from %s ended: %v", conn.RemoteAddr(), err)
+			return
+		}
+	}
+}
+
+// reply sends a single line response.
+func (sess *session) reply(code int, format string, args ...interface{}) error {
+	return sess.text.PrintfLine("%d %s", code, fmt.Sprintf(format, args...))
+}
+
+// multiline sends a response followed by dot terminated lines.
+func (sess *session) multiline(code int, status string, lines []string) error {
+	if err := sess.reply(code, "%s", status); err != nil {
+		return err
+	}
+	w := sess.text.DotWriter()
+	for _, line := range lines {
+		fmt.Fprintf(w, "%s\n", line)
+	}
+	return w.Close()
+}
+
+// handle runs one command, returning an error only when the connection is broken.
+func (sess *session) handle(command string, args []string) error {
+	switch command {
+	case "CAPABILITIES":
+		return sess.multiline(101, "Capability list:", []string{
+			"VERSION 2", "READER", "POST", "LIST ACTIVE NEWSGROUPS OVERVIEW.FMT", "OVER", "IMPLEMENTATION p2pbbs",
+		})
+	case "MODE":
+		if len(args) == 1 && strings.EqualFold(args[0], "READER") {
+			return sess.reply(200, "Posting allowed")
+		}
+		return sess.reply(501, "Unknown mode")
+	case "DATE":
+		return sess.reply(111, "%s", time.Now().UTC().Format("20060102150405"))
+	case "HELP":
+		return sess.multiline(100, "Help text follows", []string{
+			"ARTICLE|HEAD|BODY|STAT [message-id|number]", "GROUP group", "LAST", "LIST [ACTIVE|NEWSGROUPS|OVERVIEW.FMT]",
+			"LISTGROUP [group [range]]", "NEXT", "OVER [range|message-id]", "POST", "QUIT",
+		})
+	case "LIST":
+		return sess.list(args)
+	case "GROUP":
+		if len(args) != 1 {
+			return sess.reply(501, "Syntax: GROUP group")
+		}
+		return sess.selectGroup(args[0], false, "")
+	case "LISTGROUP":
+		switch len(args) {
+		case 0:
+			if sess.board == "" {
+				return sess.reply(412, "No newsgroup selected")
+			}
+			return sess.selectGroup(sess.group, true, "")
+		case 1:
+			return sess.selectGroup(args[0], true, "")
+		default:
+			return sess.selectGroup(args[0], true, args[1])
+		}
+	case "ARTICLE", "HEAD", "BODY", "STAT":
+		return sess.article(command, args)
+	case "NEXT", "LAST":
+		return sess.move(command == "NEXT")
+	case "OVER", "XOVER":
+		return sess.over(args)
+	case "POST":
+		return sess.post()
+	default:
+		return sess.reply(500, "Unknown command")
+	}
+}
+
+// articles returns the ids of the articles of a board, article n at index n-1.
+func (sess *session) articles(board string) ([]string, error) {
+	return sess.numbers.Sync(board, sess.service.Store().Posts(board))
+}
+
+func (sess *session) list(args []string) error {
+	keyword := "ACTIVE"
+	if len(args) > 0 {
+		keyword = strings.ToUpper(args[0])
+	}
+	switch keyword {
+	case "ACTIVE", "NEWSGROUPS":
+		lines := make([]string, 0)
+		for _, board := range sess.service.Boards() {
+			if keyword == "NEWSGROUPS" {
+				lines = append(lines, fmt.Sprintf("%s\tThe %s board", groupName(board), board))
+				continue
+			}
+			ids, err := sess.articles(board)
+			if err != nil {
+				return sess.reply(403, "%v", err)
+			}
+			low, high := bounds(ids)
+			lines = append(lines, fmt.Sprintf("%s %d %d y", groupName(board), high, low))
+		}
+		return sess.multiline(215, "List follows", lines)
+	case "OVERVIEW.FMT":
+		return sess.multiline(215, "Order of fields in overview database", []string{
+			"Subject:", "From:", "Date:", "Message-ID:", "References:", ":bytes", ":lines",
+		})
+	default:
+		return sess.reply(501, "Unsupported LIST keyword")
+	}
+}
+
+// bounds returns the low and high water marks of a group, 1 and 0 when it is empty.
+func bounds(ids []string) (low int, high int) {
+	if len(ids) == 0 {
+		return 1, 0
+	}
+	return 1, len(ids)
+}
+
+// selectGroup makes a group current, listing its article numbers in rng for LISTGROUP.
+func (sess *session) selectGroup(group string, listNumbers bool, rng string) error {
+	board, ok := boardName(group)
+	if !ok || !sess.hasBoard(board) {
+		return sess.reply(411, "No such newsgroup")
+	}
+	ids, err := sess.articles(board)
+	if err != nil {
+		return sess.reply(403, "%v", err)
+	}
+	sess.group, sess.board = groupName(board), board
+	low, high := bounds(ids)
+	sess.current = 0
+	if len(ids) > 0 {
+		sess.current = low
+	}
+	status := fmt.Sprintf("%d %d %d %s", len(ids), low, high, sess.group)
+	if !listNumbers {
+		return sess.reply(211, "%s", status)
+	}
+	first, last := low, high
+	if rng != "" {
+		if first, last, ok = parseRange(rng, high); !ok {
+			return sess.reply(501, "Bad range")
+		}
+	}
+	lines := make([]string, 0)
+	for n := max(first, 1); n <= min(last, high); n++ {
+		lines = append(lines, strconv.Itoa(n))
+	}
+	return sess.multiline(211, status+" list follows", lines)
+}
+
+func (sess *session) hasBoard(board string) bool {
+	for _, name := range sess.service.Boards() {
+		if name == board {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRange reads an article range: n, n- or n-m.
+func parseRange(rng string, high int) (first int, last int, ok bool) {
+	low, up, isRange := strings.Cut(rng, "-")
+	first, err := strconv.Atoi(low)
+	if err != nil {
+		return 0, 0, false
+	}
+	switch {
+	case !isRange:
+		last = first
+	case up == "":
+		last = high
+	default:
+		if last, err = strconv.Atoi(up); err != nil {
+			return 0, 0, false
+		}
+	}
+	return first, last, true
+}
+
+// find returns the post named by a command argument, a message id or an article number in
+// the current group, or the current article when there is no argument.  number is 0 for a
+// message id.  When the post can't be found the error response has been sent and ok is false.
+func (sess *session) find(args []string) (p *boards.Post, number int, ok bool, err error) {
+	if len(args) > 0 && strings.HasPrefix(args[0], "<") {
+		id, valid := postID(args[0])
+		if valid {
+			p, ok = sess.service.Store().Get(id)
+		}
+		if !ok {
+			err = sess.reply(430, "No article with that message-id")
+		}
+		return
+	}
+	if sess.board == "" {
+		return nil, 0, false, sess.reply(412, "No newsgroup selected")
+	}
+	number = sess.current
+	if len(args) > 0 {
+		var convErr error
+		if number, convErr = strconv.Atoi(args[0]); convErr != nil {
+			return nil, 0, false, sess.reply(501, "Bad article number")
+		}
+	} else if number == 0 {
+		return nil, 0, false, sess.reply(420, "Current article number is invalid")
+	}
+	ids, err := sess.articles(sess.board)
+	if err != nil {
+		return nil, 0, false, sess.reply(403, "%v", err)
+	}
+	if number < 1 || number > len(ids) {
+		return nil, 0, false, sess.reply(423, "No article with that number")
+	}
+	if p, ok = sess.service.Store().Get(ids[number-1]); !ok {
+		return nil, 0, false, sess.reply(423, "No article with that number")
+	}
+	if len(args) > 0 {
+		sess.current = number
+	}
+	return
+}
+
+func (sess *session) article(command string, args []string) error {
+	p, number, ok, err := sess.find(args)
+	if !ok {
+		return err
+	}
+	status := fmt.Sprintf("%d %s", number, messageID(p.ID))
+	if command == "STAT" {
+		return sess.reply(223, "%s", status)
+	}
+	lines := make([]string, 0)
+	if command != "BODY" {
+		for _, h := range articleHeaders(sess.service.Store(), p) {
+			lines = append(lines, h.name+": "+h.value)
+		}
+	}
+	if command == "ARTICLE" {
+		lines = append(lines, "")
+	}
+	if command != "HEAD" {
+		lines = append(lines, strings.Split(strings.ReplaceAll(p.Body, "\r\n", "\n"), "\n")...)
+	}
+	code := map[string]int{"ARTICLE": 220, "HEAD": 221, "BODY": 222}[command]
+	return sess.multiline(code, status, lines)
+}
+
+// move goes to the next or previous article of the current group.
+func (sess *session) move(next bool) error {
+	if sess.board == "" {
+		return sess.reply(412, "No newsgroup selected")
+	}
+	if sess.current == 0 {
+		return sess.reply(420, "Current article number is invalid")
+	}
+	ids, err := sess.articles(sess.board)
+	if err != nil {
+		return sess.reply(403, "%v", err)
+	}
+	number := sess.current - 1
+	if next {
+		number = sess.current + 1
+	}
+	if number < 1 {
+		return sess.reply(422, "No previous article in this group")
+	}
+	if number > len(ids) {
+		return sess.reply(421, "No next article in this group")
+	}
+	sess.current = number
+	return sess.reply(223, "%d %s", number, messageID(ids[number-1]))
+}
+
+func (sess *session) over(args []string) error {
+	store := sess.service.Store()
+	if len(args) > 0 && strings.HasPrefix(args[0], "<") {
+		p, _, ok, err := sess.find(args)
+		if !ok {
+			return err
+		}
+		return sess.multiline(224, "Overview information follows", []string{overview(store, 0, p)})
+	}
+	if sess.board == "" {
+		return sess.reply(412, "No newsgroup selected")
+	}
+	ids, err := sess.articles(sess.board)
+	if err != nil {
+		return sess.reply(403, "%v", err)
+	}
+	first, last := sess.current, sess.current
+	if len(args) > 0 {
+		var ok bool
+		if first, last, ok = parseRange(args[0], len(ids)); !ok {
+			return sess.reply(501, "Bad range")
+		}
+	} else if sess.current == 0 {
+		return sess.reply(420, "Current article number is invalid")
+	}
+	lines := make([]string, 0)
+	for n := max(first, 1); n <= min(last, len(ids)); n++ {
+		if p, ok := store.Get(ids[n-1]); ok {
+			lines = append(lines, overview(store, n, p))
+		}
+	}
+	if len(lines) == 0 {
+		return sess.reply(423, "No articles in that range")
+	}
+	return sess.multiline(224, "Overview information follows", lines)
+}
+
+// post receives an article from the newsreader and publishes it to each of its newsgroups, as
+// a reply when References names a post we hold.
+func (sess *session) post() error {
+	if err := sess.reply(340, "Send article to be posted. End with <CR-LF>.<CR-LF>"); err != nil {
+		return err
+	}
+	// only what fits is kept, the rest of an article too long is read and dropped
+	reader := sess.text.DotReader()
+	data, err := io.ReadAll(io.LimitReader(reader, maxArticleLength+1))
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(io.Discard, reader); err != nil {
+		return err
+	}
+	if len(data) > maxArticleLength {
+		return sess.reply(441, "Article too long")
+	}
+	sub, err := parseSubmission(data)
+	if err != nil {
+		return sess.reply(441, "Posting failed: %v", err)
+	}
+	var parent *boards.Post
+	if len(sub.references) > 0 {
+		if id, ok := postID(sub.references[len(sub.references)-1]); ok {
+			parent, _ = sess.service.Store().Get(id)
+		}
+	}
+	for _, group := range sub.groups {
+		board, ok := boardName(group)
+		if !ok {
+			return sess.reply(441, "Posting failed: no such newsgroup %s", group)
+		}
+		replyTo := parent
+		if replyTo != nil && replyTo.Board != board {
+			replyTo = nil
+		}
+		p, err := boards.NewPost(sess.privateKey, sess.nick, board, sub.subject, sub.body, replyTo)
+		if err == nil {
+			err = sess.service.Publish(p)
+		}
+		if err != nil {
+			return sess.reply(441, "Posting failed: %v", err)
+		}
+		logger.Infof("posted %s to %s from a newsreader", p.ID, board)
+	}
+	return sess.reply(240, "Article received OK")
+}
@@ -0,0 +1,122 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package nntpbbs
+
+import (
+	"context"
+	"net"
+	"net/textproto"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/libp2p/go-libp2p"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/rightfoot-consulting/p2pbbs/boards"
+)
+
+// newsreader sends a command and checks the response code.
+func newsreader(t *testing.T, conn *textproto.Conn, code int, format string, args ...interface{}) string {
+	t.Helper()
+	if err := conn.PrintfLine(format, args...); err != nil {
+		t.Fatal(err)
+	}
+	_, message, err := conn.ReadResponse(code)
+	if err != nil {
+		t.Fatalf("%s: %v", format, err)
+	}
+	return message
+}
+
+func TestNewsgroups(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	host, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer host.Close()
+	ps, err := pubsub.NewGossipSub(ctx, host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	store, err := boards.OpenStore(filepath.Join(dir, boards.StoreDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := boards.NewService(ctx, ps, store)
+	key, _, _ := crypto.GenerateEd25519Key(nil)
+	root, _ := boards.NewPost(key, "alice", "general", "Hello", "first\n.dot", nil)
+	if err = service.Publish(root); err != nil {
+		t.Fatal(err)
+	}
+	numbers, err := LoadNumbers(filepath.Join(dir, NumbersFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodeKey, _, _ := crypto.GenerateEd25519Key(nil)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go NewServer(service, numbers, nodeKey, "node").Serve(ctx, listener)
+
+	netConn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := textproto.NewConn(netConn)
+	defer conn.Close()
+	if _, _, err = conn.ReadResponse(200); err != nil {
+		t.Fatal(err)
+	}
+	newsreader(t, conn, 215, "LIST")
+	lines, _ := conn.ReadDotLines()
+	if len(lines) != 1 || lines[0] != "p2pbbs.general 1 1 y" {
+		t.Errorf("unexpected active list %q", lines)
+	}
+	if status := newsreader(t, conn, 211, "GROUP p2pbbs.general"); status != "1 1 1 p2pbbs.general" {
+		t.Errorf("unexpected group status %q", status)
+	}
+	newsreader(t, conn, 220, "ARTICLE 1")
+	article, _ := conn.ReadDotLines()
+	text := strings.Join(article, "\n")
+	if !strings.Contains(text, "Message-ID: <"+root.ID+"@p2pbbs.local>") || !strings.HasSuffix(text, "\n\nfirst\n.dot") {
+		t.Errorf("unexpected article:\n%s", text)
+	}
+
+	newsreader(t, conn, 340, "POST")
+	w := conn.DotWriter()
+	w.Write([]byte("Newsgroups: p2pbbs.general\nSubject: =?utf-8?q?Re=3A_Hello?=\nReferences: <" + root.ID + "@p2pbbs.local>\n\nhi from a newsreader\n"))
+	w.Close()
+	if _, _, err = conn.ReadResponse(240); err != nil {
+		t.Fatal(err)
+	}
+	replies := store.Replies(root.ID)
+	if len(replies) != 1 || replies[0].Subject != "Re: Hello" || replies[0].Body != "hi from a newsreader" {
+		t.Fatalf("unexpected replies %+v", replies)
+	}
+	if err = replies[0].Verify(); err != nil {
+		t.Error(err)
+	}
+
+	// an article too long is refused, and the session goes on
+	newsreader(t, conn, 340, "POST")
+	w = conn.DotWriter()
+	w.Write([]byte("Newsgroups: p2pbbs.general\nSubject: Long\n\n" + strings.Repeat("spam spam spam\n", maxArticleLength/15+1)))
+	w.Close()
+	if _, _, err = conn.ReadResponse(441); err != nil {
+		t.Fatal(err)
+	}
+
+	newsreader(t, conn, 224, "OVER 1-")
+	overviews, _ := conn.ReadDotLines()
+	if len(overviews) != 2 || !strings.HasSuffix(strings.Split(overviews[1], "\t")[5], root.ID+"@p2pbbs.local>") {
+		t.Errorf("unexpected overview %q", overviews)
+	}
+	newsreader(t, conn, 430, "HEAD <missing@p2pbbs.local>")
+	newsreader(t, conn, 205, "QUIT")
+}
@@ -0,0 +1,64 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package pow
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+)
+
+// MaxBits bounds the difficulty of a stamp, 2^32 hashes being hours of work for a laptop.
+const MaxBits = 32
+
+// ProgressInterval is how many hashes Mine tries between calls of its progress function.
+const ProgressInterval = 1 << 16
+
+// Bits returns the number of leading zero bits of the SHA-256 hash of data followed by the big
+// endian nonce, the difficulty the nonce stamps data with.
+func Bits(data []byte, nonce uint64) int {
+	h := sha256.New()
+	h.Write(data)
+	var n [8]byte
+	binary.BigEndian.PutUint64(n[:], nonce)
+	h.Write(n[:])
+	sum := h.Sum(nil)
+	zeros := 0
+	for _, b := range sum {
+		if b != 0 {
+			return zeros + bits.LeadingZeros8(b)
+		}
+		zeros += 8
+	}
+	return zeros
+}
+
+// Mine searches for a nonce stamping data with at least difficulty bits, which takes about
+// 2^difficulty hashes.  progress, when not nil, is called with the number of hashes tried so far
+// every ProgressInterval hashes.  Mine gives up when ctx ends.
+func Mine(ctx context.Context, data []byte, difficulty int, progress func(tried uint64)) (nonce uint64, err error) {
+	if difficulty < 0 || difficulty > MaxBits {
+		return 0, fmt.Errorf("difficulty must be between 0 and %d bits, not %d", MaxBits, difficulty)
+	}
+	for ; ; nonce++ {
+		if Bits(data, nonce) >= difficulty {
+			return
+		}
+		if (nonce+1)%ProgressInterval == 0 {
+			if err = ctx.Err(); err != nil {
+				return
+			}
+			if progress != nil {
+				progress(nonce + 1)
+			}
+		}
+	}
+}
+
+// Expected returns the number of hashes mining a stamp of difficulty bits takes on average.
+func Expected(difficulty int) uint64 {
+	return 1 << difficulty
+}
@@ -0,0 +1,36 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package pow
+
+import (
+	"context"
+	"testing"
+)
+
+func TestMine(t *testing.T) {
+	data := []byte("post id")
+	calls := 0
+	nonce, err := Mine(context.Background(), data, 16, func(tried uint64) { calls++ })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Bits(data, nonce) < 16 {
+		t.Errorf("nonce %d only stamps %d bits", nonce, Bits(data, nonce))
+	}
+	if want := int(nonce / ProgressInterval); calls != want {
+		t.Errorf("progress called %d times after %d hashes", calls, nonce)
+	}
+	if Bits([]byte("other post id"), nonce) >= 16 && Bits([]byte("third post id"), nonce) >= 16 {
+		t.Error("the stamp doesn't depend on the data")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = Mine(ctx, data, MaxBits, nil); err != context.Canceled {
+		t.Errorf("mining didn't stop with its context: %v", err)
+	}
+	if _, err = Mine(context.Background(), data, MaxBits+1, nil); err == nil {
+		t.Error("mined a stamp beyond the maximum difficulty")
+	}
+}
@@ -0,0 +1,371 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package private
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/rightfoot-consulting/p2pbbs/bbscrypto"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+const (
+	// KeySize is the length of a group key.
+	KeySize = 32
+	// MaxMembers bounds the members of a room, every membership record seals the key to each.
+	MaxMembers = 256
+	// MaxClockSkew is how far in the future a membership may be dated before it is rejected.
+	MaxClockSkew = 10 * time.Minute
+	// roomIDSize is the length of the random id of a room.
+	roomIDSize = 16
+)
+
+var (
+	// ErrNotOwner is returned when someone other than its owner changes a room.
+	ErrNotOwner = errors.New("only the owner of a private room can change its members")
+	// ErrNotMember is returned for messages to or from peers who aren't members of a room.
+	ErrNotMember = errors.New("not a member of this private room")
+)
+
+// validName matches the names of private rooms, which are joined like public ones.
+var validName = regexp.MustCompile(`^[^\s]{1,64}$`)
+
+// TopicName returns the PubSub topic of a room.  It is a hash of the room's random id, which only
+// members learn, so neither the name of the room nor its id can be read from the topic.
+func TopicName(room string) string {
+	sum := sha256.Sum256([]byte("p2pbbs private room " + room))
+	return "private-room:" + hex.EncodeToString(sum[:roomIDSize])
+}
+
+// Membership lists the members of a private room and carries its group key, sealed to each
+// member's public key by the owner who signs the record.  Version counts the changes to the
+// room.  Epoch counts its keys: adding members keeps the key, removing them rotates it so they
+// can't read what follows.
+type Membership struct {
+	Room      string            `json:"room"`
+	Name      string            `json:"name"`
+	Owner     string            `json:"owner"`
+	Version   uint64            `json:"version"`
+	Epoch     uint64            `json:"epoch"`
+	Members   []string          `json:"members"`
+	Keys      map[string][]byte `json:"keys"`
+	Created   time.Time         `json:"created"`
+	PublicKey []byte            `json:"public_key,omitempty"`
+	Signature []byte            `json:"signature,omitempty"`
+}
+
+// NewRoom creates a private room owned by the owner of privateKey, with a fresh id and key, and
+// members besides the owner.
+func NewRoom(privateKey crypto.PrivKey, name string, members []peer.ID) (m *Membership, err error) {
+	owner, err := peer.IDFromPrivateKey(privateKey)
+	if err != nil {
+		return
+	}
+	id := make([]byte, roomIDSize)
+	if _, err = io.ReadFull(rand.Reader, id); err != nil {
+		return
+	}
+	key, err := newKey()
+	if err != nil {
+		return
+	}
+	m = &Membership{Room: hex.EncodeToString(id), Name: name, Owner: owner.String()}
+	return m.next(privateKey, key, 1, append([]peer.ID{owner}, members...))
+}
+
+// Add returns the next record of the room with more members, who get the current key.
+func (m *Membership) Add(privateKey crypto.PrivKey, members ...peer.ID) (next *Membership, err error) {
+	key, err := m.Key(privateKey)
+	if err != nil {
+		return
+	}
+	return m.next(privateKey, key, m.Epoch, append(m.MemberIDs(), members...))
+}
+
+// Remove returns the next record of the room without some members, under a new key.
+func (m *Membership) Remove(privateKey crypto.PrivKey, members ...peer.ID) (next *Membership, err error) {
+	if _, err = m.Key(privateKey); err != nil {
+		return
+	}
+	removed := make(map[peer.ID]bool)
+	for _, id := range members {
+		if id == m.OwnerID() {
+			return nil, fmt.Errorf("the owner can't leave a private room")
+		}
+		if !m.IsMember(id) {
+			return nil, fmt.Errorf("%s: %w", id, ErrNotMember)
+		}
+		removed[id] = true
+	}
+	remaining := make([]peer.ID, 0, len(m.Members))
+	for _, id := range m.MemberIDs() {
+		if !removed[id] {
+			remaining = append(remaining, id)
+		}
+	}
+	key, err := newKey()
+	if err != nil {
+		return
+	}
+	return m.next(privateKey, key, m.Epoch+1, remaining)
+}
+
+// next signs the record following m, sealing key to members.
+func (m *Membership) next(privateKey crypto.PrivKey, key []byte, epoch uint64, members []peer.ID) (next *Membership, err error) {
+	if owner, _ := peer.IDFromPrivateKey(privateKey); owner.String() != m.Owner {
+		return nil, ErrNotOwner
+	}
+	next = &Membership{
+		Room:    m.Room,
+		Name:    m.Name,
+		Owner:   m.Owner,
+		Version: m.Version + 1,
+		Epoch:   epoch,
+		Keys:    make(map[string][]byte),
+		Created: time.Now().UTC(),
+	}
+	for _, id := range members {
+		if _, ok := next.Keys[id.String()]; ok {
+			continue
+		}
+		memberKey, err := id.ExtractPublicKey()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", id, err)
+		}
+		if !bbscrypto.Sealable(privateKey, memberKey) {
+			return nil, fmt.Errorf("%s: %w", id, bbscrypto.ErrNotSealable)
+		}
+		if next.Keys[id.String()], err = bbscrypto.Seal(privateKey, memberKey, key); err != nil {
+			return nil, err
+		}
+		next.Members = append(next.Members, id.String())
+	}
+	sort.Strings(next.Members)
+	next.PublicKey, err = bbscrypto.VerificationKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	data, err := next.signingBytes()
+	if err != nil {
+		return nil, err
+	}
+	next.Signature, err = privateKey.Sign(data)
+	if err != nil {
+		return nil, err
+	}
+	if err = next.Verify(); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Verify checks that the record is well formed, seals the key to every member and is signed by
+// the owner.
+func (m *Membership) Verify() (err error) {
+	if id, decodeErr := hex.DecodeString(m.Room); decodeErr != nil || len(id) != roomIDSize {
+		return fmt.Errorf("invalid room id %q", m.Room)
+	}
+	if !validName.MatchString(m.Name) {
+		return fmt.Errorf("invalid room name %q", m.Name)
+	}
+	owner, err := peer.Decode(m.Owner)
+	if err != nil {
+		return
+	}
+	if m.Version == 0 || m.Epoch == 0 || m.Epoch > m.Version {
+		return fmt.Errorf("invalid version %d and epoch %d", m.Version, m.Epoch)
+	}
+	if len(m.Members) > MaxMembers {
+		return fmt.Errorf("%d members, the limit is %d", len(m.Members), MaxMembers)
+	}
+	if !sort.StringsAreSorted(m.Members) || len(m.Keys) != len(m.Members) {
+		return fmt.Errorf("members and keys don't match")
+	}
+	for _, member := range m.Members {
+		if _, err = peer.Decode(member); err != nil {
+			return fmt.Errorf("invalid member %q: %w", member, err)
+		}
+		if len(m.Keys[member]) != KeySize+bbscrypto.SealOverhead {
+			return fmt.Errorf("invalid key for %s", member)
+		}
+	}
+	if !m.IsMember(owner) {
+		return fmt.Errorf("the owner isn't a member")
+	}
+	if m.Created.After(time.Now().Add(MaxClockSkew)) {
+		return fmt.Errorf("membership created in the future: %v", m.Created)
+	}
+	data, err := m.signingBytes()
+	if err != nil {
+		return
+	}
+	return bbscrypto.Verify(owner, m.PublicKey, data, m.Signature)
+}
+
+// Key opens the group key sealed to the owner of privateKey.
+func (m *Membership) Key(privateKey crypto.PrivKey) (key []byte, err error) {
+	self, err := peer.IDFromPrivateKey(privateKey)
+	if err != nil {
+		return
+	}
+	sealed, ok := m.Keys[self.String()]
+	if !ok {
+		return nil, ErrNotMember
+	}
+	ownerKey, err := m.OwnerID().ExtractPublicKey()
+	if err != nil {
+		return
+	}
+	if key, err = bbscrypto.Open(privateKey, ownerKey, sealed); err != nil {
+		return
+	}
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("group key is %d bytes", len(key))
+	}
+	return
+}
+
+// OwnerID returns the decoded owner, the record must have been verified.
+func (m *Membership) OwnerID() peer.ID {
+	id, _ := peer.Decode(m.Owner)
+	return id
+}
+
+// MemberIDs returns the decoded members, the owner among them.
+func (m *Membership) MemberIDs() []peer.ID {
+	ids := make([]peer.ID, 0, len(m.Members))
+	for _, member := range m.Members {
+		if id, err := peer.Decode(member); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// IsMember reports whether id is a member of the room.
+func (m *Membership) IsMember(id peer.ID) bool {
+	_, ok := m.Keys[id.String()]
+	return ok
+}
+
+// Code encodes the record as a join code, which is all a new member needs to join the room.
+func (m *Membership) Code() (string, error) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// ParseCode decodes and verifies a join code.
+func ParseCode(code string) (m *Membership, err error) {
+	data, err := base64.RawURLEncoding.DecodeString(code)
+	if err != nil {
+		return nil, fmt.Errorf("invalid join code: %w", err)
+	}
+	m = new(Membership)
+	if err = json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("invalid join code: %w", err)
+	}
+	if err = m.Verify(); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// signingBytes is the JSON encoding of the record without its signature.
+func (m *Membership) signingBytes() ([]byte, error) {
+	unsigned := *m
+	unsigned.Signature = nil
+	return json.Marshal(&unsigned)
+}
+
+// Announcement is how a membership record travels on the topic of its room.  Anyone can
+// subscribe to the topic, so the record, which names the room, its owner and its members, is
+// sealed under the group key of its epoch.  Keys holds the group key sealed to each member, in
+// no particular order and without saying whose each is, so members who missed a rotation can
+// still open it by trying theirs.
+type Announcement struct {
+	Keys   [][]byte  `json:"keys"`
+	Record *Envelope `json:"record"`
+}
+
+// Announce seals m for its topic, key being the group key of its epoch.
+func (m *Membership) Announce(key []byte) (a *Announcement, err error) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return
+	}
+	a = &Announcement{Keys: make([][]byte, 0, len(m.Keys))}
+	if a.Record, err = Seal(key, m.Epoch, data); err != nil {
+		return nil, err
+	}
+	for _, sealed := range m.Keys {
+		a.Keys = append(a.Keys, sealed)
+	}
+	// the boxes start with random nonces, so sorting them hides which member each is for
+	sort.Slice(a.Keys, func(i, j int) bool { return bytes.Compare(a.Keys[i], a.Keys[j]) < 0 })
+	return
+}
+
+// Envelope is a message encrypted under the group key of an epoch.
+type Envelope struct {
+	Epoch uint64 `json:"epoch"`
+	Box   []byte `json:"box"`
+}
+
+// nonceSize is the length of the random nonce that starts every box.
+const nonceSize = 24
+
+// Seal encrypts data under key with NaCl secretbox.
+func Seal(key []byte, epoch uint64, data []byte) (e *Envelope, err error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("group key is %d bytes", len(key))
+	}
+	var nonce [nonceSize]byte
+	if _, err = io.ReadFull(rand.Reader, nonce[:]); err != nil {
+		return
+	}
+	var k [KeySize]byte
+	copy(k[:], key)
+	return &Envelope{Epoch: epoch, Box: secretbox.Seal(nonce[:], data, &nonce, &k)}, nil
+}
+
+// Open decrypts an envelope with the key of its epoch.
+func (e *Envelope) Open(key []byte) (data []byte, err error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("group key is %d bytes", len(key))
+	}
+	if len(e.Box) < nonceSize+secretbox.Overhead {
+		return nil, errors.New("box is too short")
+	}
+	var nonce [nonceSize]byte
+	copy(nonce[:], e.Box)
+	var k [KeySize]byte
+	copy(k[:], key)
+	data, ok := secretbox.Open(nil, e.Box[nonceSize:], &nonce, &k)
+	if !ok {
+		return nil, errors.New("box could not be opened")
+	}
+	return
+}
+
+func newKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	_, err := io.ReadFull(rand.Reader, key)
+	return key, err
+}
@@ -0,0 +1,339 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package private
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/rightfoot-consulting/p2pbbs/bbscrypto"
+)
+
+// StoreFile is the name of the file in the data directory holding membership records.
+const StoreFile = "private.json"
+
+var (
+	// ErrNoRoom is returned when no private room matches a name or id.
+	ErrNoRoom = errors.New("no such private room")
+	// ErrUnknownEpoch is returned for messages under a key we haven't been given yet.
+	ErrUnknownEpoch = errors.New("message under a key we don't have yet")
+)
+
+// Store keeps the membership records of the private rooms a local identity belongs to, the last
+// record of every epoch so that messages under older keys can still be read.  The keys stay
+// sealed on disk and are opened with the identity's private key when needed.
+type Store struct {
+	file       string
+	privateKey crypto.PrivKey
+	self       peer.ID
+
+	mu          sync.RWMutex
+	rooms       map[string][]*Membership
+	keys        map[string][]byte
+	subscribers map[chan string]struct{}
+}
+
+// LoadStore reads the records in file for the owner of privateKey, a missing file gives an empty
+// store.  Records that no longer verify are dropped.
+func LoadStore(file string, privateKey crypto.PrivKey) (store *Store, err error) {
+	self, err := peer.IDFromPrivateKey(privateKey)
+	if err != nil {
+		return
+	}
+	store = &Store{
+		file:        file,
+		privateKey:  privateKey,
+		self:        self,
+		rooms:       make(map[string][]*Membership),
+		keys:        make(map[string][]byte),
+		subscribers: make(map[chan string]struct{}),
+	}
+	jsonBytes, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var records []*Membership
+	if err = json.Unmarshal(jsonBytes, &records); err != nil {
+		return nil, err
+	}
+	for _, m := range records {
+		if m.Verify() == nil {
+			store.merge(m)
+		}
+	}
+	return
+}
+
+// Add verifies a record and keeps it if it is newer than the ones we have of its room, from the
+// same owner.  A room we don't know yet is only kept when it counts us as a member.  The store
+// is saved when it changes.
+func (s *Store) Add(m *Membership) (changed bool, err error) {
+	if err = m.Verify(); err != nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	records, ok := s.rooms[m.Room]
+	switch {
+	case !ok && !m.IsMember(s.self):
+		return false, ErrNotMember
+	case ok && records[0].Owner != m.Owner:
+		return false, fmt.Errorf("private room %s is owned by %s", m.Room, records[0].Owner)
+	}
+	if changed = s.merge(m); changed {
+		err = s.save()
+		s.notify(m.Room)
+	}
+	return
+}
+
+// Latest returns the newest record of a room.
+func (s *Store) Latest(room string) (*Membership, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	records, ok := s.rooms[room]
+	if !ok {
+		return nil, false
+	}
+	return records[len(records)-1], true
+}
+
+// Rooms returns the newest record of every room, by name.
+func (s *Store) Rooms() []*Membership {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	rooms := make([]*Membership, 0, len(s.rooms))
+	for _, records := range s.rooms {
+		rooms = append(rooms, records[len(records)-1])
+	}
+	sort.Slice(rooms, func(i, j int) bool {
+		if rooms[i].Name != rooms[j].Name {
+			return rooms[i].Name < rooms[j].Name
+		}
+		return rooms[i].Room < rooms[j].Room
+	})
+	return rooms
+}
+
+// Find returns the newest record of the room with an id or name, failing when several rooms
+// share the name.
+func (s *Store) Find(name string) (m *Membership, err error) {
+	var found []*Membership
+	for _, room := range s.Rooms() {
+		if room.Room == name {
+			return room, nil
+		}
+		if room.Name == name {
+			found = append(found, room)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("%s: %w", name, ErrNoRoom)
+	case 1:
+		return found[0], nil
+	default:
+		return nil, fmt.Errorf("%d private rooms are called %s, use the id of one", len(found), name)
+	}
+}
+
+// Seal encrypts a message to a room under its newest key.
+func (s *Store) Seal(room string, data []byte) (e *Envelope, err error) {
+	m, ok := s.Latest(room)
+	if !ok {
+		return nil, ErrNoRoom
+	}
+	key, err := s.key(m)
+	if err != nil {
+		return
+	}
+	return Seal(key, m.Epoch, data)
+}
+
+// Open decrypts a message sent to a room.  The sender must be a member as far as the newest
+// record we have goes, so removed members can't go on using an old key.
+func (s *Store) Open(room string, from peer.ID, e *Envelope) (data []byte, err error) {
+	s.mu.RLock()
+	records, ok := s.rooms[room]
+	var m *Membership
+	for _, record := range records {
+		if record.Epoch == e.Epoch {
+			m = record
+		}
+	}
+	s.mu.RUnlock()
+	switch {
+	case !ok:
+		return nil, ErrNoRoom
+	case !records[len(records)-1].IsMember(from):
+		return nil, fmt.Errorf("%s: %w", from, ErrNotMember)
+	case m == nil:
+		return nil, ErrUnknownEpoch
+	}
+	key, err := s.key(m)
+	if err != nil {
+		return
+	}
+	return e.Open(key)
+}
+
+// Announcement seals the newest record of a room for its topic.
+func (s *Store) Announcement(room string) (a *Announcement, err error) {
+	m, ok := s.Latest(room)
+	if !ok {
+		return nil, ErrNoRoom
+	}
+	key, err := s.key(m)
+	if err != nil {
+		return
+	}
+	return m.Announce(key)
+}
+
+// OpenAnnouncement opens and verifies the record an announcement on the topic of a room carries.
+// The key of its epoch is the one we hold, or the one sealed to us among its keys; when neither
+// is there ErrNotMember is returned, as the record isn't meant for us.  The record must be of
+// the same room and owner as ours, it still has to be added to the store.
+func (s *Store) OpenAnnouncement(room string, a *Announcement) (m *Membership, err error) {
+	if a.Record == nil || len(a.Keys) > MaxMembers {
+		return nil, errors.New("invalid announcement")
+	}
+	s.mu.RLock()
+	records, ok := s.rooms[room]
+	var known *Membership
+	for _, record := range records {
+		if record.Epoch == a.Record.Epoch {
+			known = record
+		}
+	}
+	s.mu.RUnlock()
+	if !ok {
+		return nil, ErrNoRoom
+	}
+	var key []byte
+	if known != nil {
+		if key, err = s.key(known); err != nil {
+			return
+		}
+	} else if key, err = s.findKey(records[0].OwnerID(), a.Keys); err != nil {
+		return
+	}
+	data, err := a.Record.Open(key)
+	if err != nil {
+		return
+	}
+	m = new(Membership)
+	if err = json.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	if err = m.Verify(); err != nil {
+		return nil, err
+	}
+	switch {
+	case m.Room != room:
+		return nil, fmt.Errorf("announcement of %s on the topic of %s", m.Room, room)
+	case m.Owner != records[0].Owner:
+		return nil, ErrNotOwner
+	case m.Epoch != a.Record.Epoch:
+		return nil, fmt.Errorf("record of epoch %d sealed under epoch %d", m.Epoch, a.Record.Epoch)
+	}
+	return
+}
+
+// findKey opens the group key sealed to us by owner among keys.
+func (s *Store) findKey(owner peer.ID, keys [][]byte) (key []byte, err error) {
+	ownerKey, err := owner.ExtractPublicKey()
+	if err != nil {
+		return
+	}
+	for _, sealed := range keys {
+		if key, err = bbscrypto.Open(s.privateKey, ownerKey, sealed); err == nil && len(key) == KeySize {
+			return key, nil
+		}
+	}
+	return nil, ErrNotMember
+}
+
+// Subscribe returns a channel receiving the id of every room whose membership changes, and a
+// function to stop the subscription.
+func (s *Store) Subscribe() (<-chan string, func()) {
+	ch := make(chan string, 64)
+	s.mu.Lock()
+	s.subscribers[ch] = struct{}{}
+	s.mu.Unlock()
+	return ch, func() {
+		s.mu.Lock()
+		delete(s.subscribers, ch)
+		s.mu.Unlock()
+	}
+}
+
+// key opens and caches our key in a record.
+func (s *Store) key(m *Membership) (key []byte, err error) {
+	cacheKey := fmt.Sprintf("%s/%d", m.Room, m.Epoch)
+	s.mu.RLock()
+	key, ok := s.keys[cacheKey]
+	s.mu.RUnlock()
+	if ok {
+		return
+	}
+	if key, err = m.Key(s.privateKey); err != nil {
+		return
+	}
+	s.mu.Lock()
+	s.keys[cacheKey] = key
+	s.mu.Unlock()
+	return
+}
+
+// merge keeps m if it is newer than the records of its room, replacing the record of its epoch.
+func (s *Store) merge(m *Membership) bool {
+	records := s.rooms[m.Room]
+	if len(records) > 0 && records[len(records)-1].Version >= m.Version {
+		return false
+	}
+	if len(records) > 0 && records[len(records)-1].Epoch == m.Epoch {
+		records = records[:len(records)-1]
+	}
+	s.rooms[m.Room] = append(records, m)
+	return true
+}
+
+func (s *Store) notify(room string) {
+	for ch := range s.subscribers {
+		select {
+		case ch <- room:
+		default:
+			// a slow subscriber misses live updates but can always re-read the store
+		}
+	}
+}
+
+func (s *Store) save() error {
+	records := make([]*Membership, 0, len(s.rooms))
+	for _, room := range s.rooms {
+		records = append(records, room...)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Room != records[j].Room {
+			return records[i].Room < records[j].Room
+		}
+		return records[i].Version < records[j].Version
+	})
+	jsonBytes, err := json.MarshalIndent(records, "", "    ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.file, jsonBytes, 0600)
+}
@@ -0,0 +1,208 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package private
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/rightfoot-consulting/p2pbbs/internal/testkeys"
+)
+
+func openStore(t *testing.T, who testkeys.Identity) *Store {
+	t.Helper()
+	store, err := LoadStore(filepath.Join(t.TempDir(), StoreFile), who.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestPrivateRoom(t *testing.T) {
+	owner, alice, bob, mallory := testkeys.New(t), testkeys.New(t), testkeys.New(t), testkeys.New(t)
+	room, err := NewRoom(owner.Key, "ops", []peer.ID{alice.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(TopicName(room.Room), "ops") || strings.Contains(TopicName(room.Room), room.Room) {
+		t.Errorf("the topic %s gives the room away", TopicName(room.Room))
+	}
+	if _, err = room.Add(alice.Key, mallory.ID); err != ErrNotOwner {
+		t.Errorf("a member changed the room: %v", err)
+	}
+
+	stores := map[string]*Store{"owner": openStore(t, owner), "alice": openStore(t, alice), "bob": openStore(t, bob)}
+	if _, err = stores["bob"].Add(room); err != ErrNotMember {
+		t.Errorf("kept a room we weren't invited to: %v", err)
+	}
+	code, err := room.Code()
+	if err != nil {
+		t.Fatal(err)
+	}
+	joined, err := ParseCode(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stores["owner"].Add(room)
+	if _, err = stores["alice"].Add(joined); err != nil {
+		t.Fatal(err)
+	}
+
+	// members read each other, others can't
+	e, err := stores["alice"].Seal(room.Room, []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := stores["owner"].Open(room.Room, alice.ID, e); err != nil || string(data) != "hello" {
+		t.Errorf("the owner read %q: %v", data, err)
+	}
+	if _, err = stores["owner"].Open(room.Room, mallory.ID, e); !errors.Is(err, ErrNotMember) {
+		t.Errorf("accepted a message from a stranger: %v", err)
+	}
+	if _, err = room.Key(bob.Key); err != ErrNotMember {
+		t.Errorf("opened the key of a room we don't belong to: %v", err)
+	}
+
+	// adding keeps the key, removing rotates it
+	added, err := room.Add(owner.Key, bob.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ownerKey, _ := room.Key(owner.Key)
+	if bobKey, err := added.Key(bob.Key); err != nil || added.Epoch != room.Epoch || !bytes.Equal(bobKey, ownerKey) {
+		t.Errorf("the new member got key %d: %v", added.Epoch, err)
+	}
+	removed, err := added.Remove(owner.Key, alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed.Epoch != room.Epoch+1 || removed.IsMember(alice.ID) {
+		t.Errorf("removing a member gave key %d with members %v", removed.Epoch, removed.Members)
+	}
+	if newKey, _ := removed.Key(owner.Key); bytes.Equal(newKey, ownerKey) {
+		t.Error("the key didn't rotate")
+	}
+	if _, err = removed.Key(alice.Key); err != ErrNotMember {
+		t.Errorf("a removed member opened the new key: %v", err)
+	}
+	for _, m := range []*Membership{added, removed} {
+		for name, store := range stores {
+			if _, err = store.Add(m); err != nil {
+				t.Fatalf("%s refused version %d: %v", name, m.Version, err)
+			}
+		}
+	}
+	if changed, _ := stores["owner"].Add(added); changed {
+		t.Error("an older record replaced a newer one")
+	}
+
+	// a removed member can't go on talking with the old key, but messages sealed under it before
+	// the rotation are still readable
+	if _, err = stores["bob"].Open(room.Room, alice.ID, e); !errors.Is(err, ErrNotMember) {
+		t.Errorf("accepted a message from a removed member: %v", err)
+	}
+	old, _ := Seal(ownerKey, room.Epoch, []byte("before"))
+	if data, err := stores["bob"].Open(room.Room, owner.ID, old); err != nil || string(data) != "before" {
+		t.Errorf("read %q under the old key: %v", data, err)
+	}
+	e, _ = stores["bob"].Seal(room.Room, []byte("after"))
+	if e.Epoch != removed.Epoch {
+		t.Errorf("sealed under key %d after the rotation", e.Epoch)
+	}
+	if _, err = stores["alice"].Open(room.Room, bob.ID, e); err != ErrUnknownEpoch && !errors.Is(err, ErrNotMember) {
+		t.Errorf("a removed member read the new key: %v", err)
+	}
+
+	// records survive a reload, keys stay sealed
+	reloaded, err := LoadStore(stores["bob"].file, bob.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := reloaded.Open(room.Room, owner.ID, old); err != nil || string(data) != "before" {
+		t.Errorf("read %q after reloading: %v", data, err)
+	}
+
+	forged := *removed
+	forged.Members = append([]string{mallory.ID.String()}, forged.Members...)
+	if forged.Verify() == nil {
+		t.Error("a tampered record verified")
+	}
+	key, _ := newKey()
+	squatted, err := (&Membership{Room: room.Room, Name: "ops", Owner: mallory.ID.String(), Version: removed.Version}).next(mallory.Key, key, 1, []peer.ID{mallory.ID, bob.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = stores["bob"].Add(squatted); err == nil {
+		t.Error("somebody else took over the room")
+	}
+}
+
+func TestAnnouncement(t *testing.T) {
+	owner, alice, bob, mallory := testkeys.New(t), testkeys.New(t), testkeys.New(t), testkeys.New(t)
+	room, err := NewRoom(owner.Key, "ops", []peer.ID{alice.ID, bob.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stores := map[string]*Store{"owner": openStore(t, owner), "alice": openStore(t, alice), "bob": openStore(t, bob)}
+	for name, store := range stores {
+		if _, err = store.Add(room); err != nil {
+			t.Fatalf("%s refused the room: %v", name, err)
+		}
+	}
+
+	// bob is removed while alice is away, she catches up from the announcement of the new key
+	removed, err := room.Remove(owner.Key, bob.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stores["owner"].Add(removed)
+	a, err := stores["owner"].Announcement(room.Room)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"ops", owner.ID.String(), alice.ID.String(), bob.ID.String()} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("the announcement gives %s away", secret)
+		}
+	}
+	m, err := stores["alice"].OpenAnnouncement(room.Room, a)
+	if err != nil || m.Version != removed.Version {
+		t.Fatalf("a member opened %v: %v", m, err)
+	}
+	if _, err = stores["alice"].Add(m); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = stores["alice"].OpenAnnouncement(room.Room, a); err != nil {
+		t.Errorf("a member holding the key failed to open the announcement: %v", err)
+	}
+	if _, err = stores["bob"].OpenAnnouncement(room.Room, a); !errors.Is(err, ErrNotMember) {
+		t.Errorf("a removed member opened the announcement: %v", err)
+	}
+	if _, err = openStore(t, mallory).OpenAnnouncement(room.Room, a); !errors.Is(err, ErrNoRoom) {
+		t.Errorf("a stranger opened the announcement: %v", err)
+	}
+
+	// announcements of another room, or under a key their record isn't of, are refused
+	other, err := NewRoom(owner.Key, "ops", []peer.ID{alice.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stores["owner"].Add(other)
+	a, err = stores["owner"].Announcement(other.Room)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = stores["alice"].OpenAnnouncement(room.Room, a); err == nil {
+		t.Error("accepted the announcement of another room")
+	}
+}
@@ -0,0 +1,132 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package profile
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
+)
+
+// DirectoryTTL is how long a fetched profile is served from the cache before it is refreshed.
+const DirectoryTTL = 10 * time.Minute
+
+// LookupTimeout bounds the background lookups started by Cached.
+const LookupTimeout = 30 * time.Second
+
+// Directory publishes our profile and caches the profiles of other peers fetched from the DHT.
+type Directory struct {
+	store routing.ValueStore
+
+	mu      sync.Mutex
+	entries map[peer.ID]*directoryEntry
+}
+
+type directoryEntry struct {
+	profile *Profile
+	fetched time.Time
+	pending bool
+}
+
+// NewDirectory returns a Directory backed by a DHT or any other value store.
+func NewDirectory(store routing.ValueStore) *Directory {
+	return &Directory{
+		store:   store,
+		entries: make(map[peer.ID]*directoryEntry),
+	}
+}
+
+// Publish signs profile with privateKey and stores it in the DHT.
+func (d *Directory) Publish(ctx context.Context, privateKey crypto.PrivKey, p *Profile) (err error) {
+	err = p.Sign(privateKey)
+	if err != nil {
+		return
+	}
+	value, err := p.Marshal()
+	if err != nil {
+		return
+	}
+	id, err := peer.Decode(p.PeerID)
+	if err != nil {
+		return
+	}
+	err = d.store.PutValue(ctx, Key(id), value)
+	if err == nil {
+		d.remember(id, p)
+	}
+	return
+}
+
+// Lookup fetches the profile of id from the DHT.  The DHT has already validated the record but
+// it is verified again so a misconfigured store can't hand us a forged profile.  Only verified
+// profiles are cached, a failed lookup keeps what was cached before.
+func (d *Directory) Lookup(ctx context.Context, id peer.ID) (p *Profile, err error) {
+	p, err = d.fetch(ctx, id)
+	if err != nil {
+		d.remember(id, nil)
+		return nil, err
+	}
+	d.remember(id, p)
+	return
+}
+
+// fetch gets the profile of id from the DHT and verifies it.
+func (d *Directory) fetch(ctx context.Context, id peer.ID) (p *Profile, err error) {
+	value, err := d.store.GetValue(ctx, Key(id))
+	if err != nil {
+		return
+	}
+	p, err = Unmarshal(value)
+	if err != nil {
+		return nil, err
+	}
+	if p.PeerID != id.String() {
+		return nil, routing.ErrNotFound
+	}
+	if err = p.Verify(); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Cached returns the profile of id if one has been fetched, it never blocks.  Missing or stale
+// entries are refreshed in the background so a later call can return them.
+func (d *Directory) Cached(id peer.ID) *Profile {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	entry, ok := d.entries[id]
+	if !ok {
+		entry = &directoryEntry{}
+		d.entries[id] = entry
+	}
+	if !entry.pending && time.Since(entry.fetched) > DirectoryTTL {
+		entry.pending = true
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), LookupTimeout)
+			defer cancel()
+			d.Lookup(ctx, id)
+		}()
+	}
+	return entry.profile
+}
+
+// remember caches a verified profile of id, or notes that a lookup of it failed when p is nil.
+func (d *Directory) remember(id peer.ID, p *Profile) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	entry, ok := d.entries[id]
+	if !ok {
+		entry = &directoryEntry{}
+		d.entries[id] = entry
+	}
+	entry.pending = false
+	entry.fetched = time.Now()
+	if p != nil {
+		entry.profile = p
+	}
+}
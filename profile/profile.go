@@ -0,0 +1,117 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package profile
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/rightfoot-consulting/p2pbbs/bbscrypto"
+)
+
+// Namespace is the DHT namespace profile records are stored under, the full key of a
+// profile is /bbsprofile/<peerID>.
+const Namespace = "bbsprofile"
+
+// Limits on the profile fields, records exceeding them are rejected by the Validator.
+const (
+	MaxNickLength       = 32
+	MaxBioLength        = 512
+	MaxContactLength    = 256
+	MaxAvatarHashLength = 128
+	// MaxClockSkew is how far in the future an UpdatedAt time may be before it is rejected.
+	MaxClockSkew = 10 * time.Minute
+)
+
+// Profile is a self published description of a peer, signed by the peer's own key.
+type Profile struct {
+	PeerID     string    `json:"peer_id"`
+	Nick       string    `json:"nick"`
+	Bio        string    `json:"bio,omitempty"`
+	Contact    string    `json:"contact,omitempty"`
+	AvatarHash string    `json:"avatar_hash,omitempty"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	PublicKey  []byte    `json:"public_key,omitempty"`
+	Signature  []byte    `json:"signature,omitempty"`
+}
+
+// Key returns the DHT key of the profile belonging to id.
+func Key(id peer.ID) string {
+	return "/" + Namespace + "/" + id.String()
+}
+
+// Sign stamps the profile with the current time and the identity of privateKey, then signs it.
+func (p *Profile) Sign(privateKey crypto.PrivKey) (err error) {
+	id, err := peer.IDFromPrivateKey(privateKey)
+	if err != nil {
+		return
+	}
+	p.PeerID = id.String()
+	p.UpdatedAt = time.Now().UTC()
+	p.Signature = nil
+	p.PublicKey, err = bbscrypto.VerificationKey(privateKey)
+	if err != nil {
+		return
+	}
+	data, err := p.signingBytes()
+	if err != nil {
+		return
+	}
+	p.Signature, err = privateKey.Sign(data)
+	return
+}
+
+// Verify checks the field limits and that the profile was signed by the peer it describes.
+func (p *Profile) Verify() (err error) {
+	id, err := peer.Decode(p.PeerID)
+	if err != nil {
+		return
+	}
+	if p.Nick == "" || len(p.Nick) > MaxNickLength || strings.ContainsAny(p.Nick, "\r\n") {
+		return fmt.Errorf("invalid nick %q", p.Nick)
+	}
+	if len(p.Bio) > MaxBioLength {
+		return fmt.Errorf("bio is longer than %d bytes", MaxBioLength)
+	}
+	if len(p.Contact) > MaxContactLength {
+		return fmt.Errorf("contact is longer than %d bytes", MaxContactLength)
+	}
+	if len(p.AvatarHash) > MaxAvatarHashLength {
+		return fmt.Errorf("avatar hash is longer than %d bytes", MaxAvatarHashLength)
+	}
+	if p.UpdatedAt.After(time.Now().Add(MaxClockSkew)) {
+		return fmt.Errorf("profile updated in the future: %v", p.UpdatedAt)
+	}
+	data, err := p.signingBytes()
+	if err != nil {
+		return
+	}
+	return bbscrypto.Verify(id, p.PublicKey, data, p.Signature)
+}
+
+// Marshal encodes the profile as the value stored in the DHT.
+func (p *Profile) Marshal() ([]byte, error) {
+	return json.Marshal(p)
+}
+
+// Unmarshal decodes a DHT value into a profile without verifying it.
+func Unmarshal(value []byte) (p *Profile, err error) {
+	var profile Profile
+	err = json.Unmarshal(value, &profile)
+	if err == nil {
+		p = &profile
+	}
+	return
+}
+
+// signingBytes is the JSON encoding of the profile without its signature.
+func (p *Profile) signingBytes() ([]byte, error) {
+	unsigned := *p
+	unsigned.Signature = nil
+	return json.Marshal(&unsigned)
+}
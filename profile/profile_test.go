@@ -0,0 +1,125 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package profile
+
+import (
+	"context"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
+)
+
+func signedProfile(t *testing.T, sk crypto.PrivKey, nick string) []byte {
+	t.Helper()
+	p := &Profile{Nick: nick, Bio: "testing"}
+	if err := p.Sign(sk); err != nil {
+		t.Fatal(err)
+	}
+	value, err := p.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return value
+}
+
+func TestValidator(t *testing.T) {
+	sk, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, err := crypto.GenerateKeyPairWithReader(crypto.RSA, 2048, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := peer.IDFromPrivateKey(sk)
+	otherID, _ := peer.IDFromPrivateKey(other)
+	v := Validator{}
+
+	value := signedProfile(t, sk, "alice")
+	if err := v.Validate(Key(id), value); err != nil {
+		t.Errorf("valid profile rejected: %v", err)
+	}
+	if err := v.Validate(Key(otherID), value); err == nil {
+		t.Errorf("profile accepted under another peer's key")
+	}
+
+	// rsa peer ids don't embed the key, it has to travel with the record
+	otherValue := signedProfile(t, other, "bob")
+	if err := v.Validate(Key(otherID), otherValue); err != nil {
+		t.Errorf("valid rsa profile rejected: %v", err)
+	}
+
+	tampered, _ := Unmarshal(value)
+	tampered.Nick = "mallory"
+	tamperedValue, _ := tampered.Marshal()
+	if err := v.Validate(Key(id), tamperedValue); err == nil {
+		t.Errorf("tampered profile accepted")
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	newer := signedProfile(t, sk, "alice2")
+	best, err := v.Select(Key(id), [][]byte{value, tamperedValue, newer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if best != 2 {
+		t.Errorf("selected record %d, expected the newest", best)
+	}
+}
+
+// valueStore is a routing.ValueStore that doesn't validate what it stores.
+type valueStore map[string][]byte
+
+func (vs valueStore) PutValue(_ context.Context, key string, value []byte, _ ...routing.Option) error {
+	vs[key] = value
+	return nil
+}
+
+func (vs valueStore) GetValue(_ context.Context, key string, _ ...routing.Option) ([]byte, error) {
+	value, ok := vs[key]
+	if !ok {
+		return nil, routing.ErrNotFound
+	}
+	return value, nil
+}
+
+func (vs valueStore) SearchValue(ctx context.Context, key string, _ ...routing.Option) (<-chan []byte, error) {
+	return nil, routing.ErrNotSupported
+}
+
+func TestDirectory(t *testing.T) {
+	sk, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := peer.IDFromPrivateKey(sk)
+	store := valueStore{}
+	d := NewDirectory(store)
+
+	value := signedProfile(t, sk, "alice")
+	forged, _ := Unmarshal(value)
+	forged.Nick = "mallory"
+	store[Key(id)], _ = forged.Marshal()
+	if _, err = d.Lookup(context.Background(), id); err == nil {
+		t.Fatal("looked up a forged profile")
+	}
+	if p := d.Cached(id); p != nil {
+		t.Fatalf("cached the forged profile %+v", p)
+	}
+
+	store[Key(id)] = value
+	if p, err := d.Lookup(context.Background(), id); err != nil || p.Nick != "alice" {
+		t.Fatalf("looked up %+v: %v", p, err)
+	}
+	// a later failure keeps the verified profile
+	store[Key(id)] = []byte("garbage")
+	d.Lookup(context.Background(), id)
+	if p := d.Cached(id); p == nil || p.Nick != "alice" {
+		t.Errorf("cached %+v", p)
+	}
+}
@@ -0,0 +1,56 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package profile
+
+import (
+	"fmt"
+
+	record "github.com/libp2p/go-libp2p-record"
+)
+
+// Validator validates and selects profile records stored under the bbsprofile DHT namespace.
+type Validator struct{}
+
+var _ record.Validator = Validator{}
+
+// Validate rejects records that are malformed, not signed by their owner or stored under
+// another peer's key.
+func (v Validator) Validate(key string, value []byte) error {
+	ns, id, err := record.SplitKey(key)
+	if err != nil {
+		return err
+	}
+	if ns != Namespace {
+		return fmt.Errorf("key %s is not in the %s namespace", key, Namespace)
+	}
+	p, err := Unmarshal(value)
+	if err != nil {
+		return err
+	}
+	if p.PeerID != id {
+		return fmt.Errorf("profile of %s stored under key %s", p.PeerID, key)
+	}
+	return p.Verify()
+}
+
+// Select picks the most recently updated valid profile, earlier records win ties so the
+// choice is stable.
+func (v Validator) Select(key string, values [][]byte) (int, error) {
+	best := -1
+	var bestProfile *Profile
+	for i, value := range values {
+		if v.Validate(key, value) != nil {
+			continue
+		}
+		p, _ := Unmarshal(value)
+		if bestProfile == nil || p.UpdatedAt.After(bestProfile.UpdatedAt) {
+			best = i
+			bestProfile = p
+		}
+	}
+	if best < 0 {
+		return 0, fmt.Errorf("no valid profile records for %s", key)
+	}
+	return best, nil
+}
@@ -0,0 +1,200 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package sanitize
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// MaxCombiningMarks bounds the combining marks kept on a single character, more only serve to
+// smear text over the lines around it.
+const MaxCombiningMarks = 4
+
+// Text cleans untrusted text before it is shown in a terminal.  Escape sequences are removed
+// with their parameters, as are control characters other than newlines and tabs, so a peer can't
+// move the cursor, recolour or retitle the terminal.  Invisible formatting characters go too:
+// bidi overrides and isolates that reorder what is shown, zero-width spaces, and joiners other
+// than those between two characters, where scripts and emoji need them.  The result is in
+// Unicode normal form C.
+func Text(s string) string {
+	return clean(s, false, norm.NFC)
+}
+
+// Line is like Text for text that must stay on one line, such as chat messages and subjects:
+// newlines and tabs become spaces.
+func Line(s string) string {
+	return clean(s, true, norm.NFC)
+}
+
+// Name is like Line for nicks and other names people tell apart by their look.  Every invisible
+// formatting character is removed and compatibility characters are folded, normal form KC, so
+// names that look the same are the same.
+func Name(s string) string {
+	return strings.TrimSpace(clean(s, true, norm.NFKC))
+}
+
+// bracketed matches what tview may take for a colour or region tag, or for an escaped tag whose
+// last opening bracket it would drop.  tview.Escape only escapes the former.
+var bracketed = regexp.MustCompile(`(\[[^\[\]]+\[*)\]`)
+
+// TviewText is Text with the colour and region tags of tview escaped, for a TextView with dynamic
+// colours or regions.
+func TviewText(s string) string {
+	return bracketed.ReplaceAllString(Text(s), "$1[]")
+}
+
+// TviewLine is Line with the tags of tview escaped.
+func TviewLine(s string) string {
+	return bracketed.ReplaceAllString(Line(s), "$1[]")
+}
+
+func clean(s string, oneLine bool, form norm.Form) string {
+	s = stripEscapes(strings.ToValidUTF8(s, string(utf8.RuneError)))
+	var b strings.Builder
+	b.Grow(len(s))
+	joiners := false
+	for _, r := range s {
+		switch {
+		case r == '\n' || r == '\u2028' || r == '\u2029':
+			if oneLine {
+				b.WriteByte(' ')
+			} else {
+				b.WriteByte('\n')
+			}
+		case r == '\t':
+			if oneLine {
+				b.WriteByte(' ')
+			} else {
+				b.WriteByte('\t')
+			}
+		case unicode.IsControl(r):
+			// carriage returns, backspaces, bells, DEL and the C1 controls
+		case isJoiner(r) && form == norm.NFC:
+			joiners = true
+			b.WriteRune(r)
+		case unicode.Is(unicode.Cf, r):
+			// invisible, or reorders the text around it
+		default:
+			b.WriteRune(r)
+		}
+	}
+	s = b.String()
+	if joiners {
+		s = dropLoneJoiners(s)
+	}
+	return limitMarks(form.String(s))
+}
+
+// isJoiner reports whether r is a zero width joiner or non-joiner, which scripts and emoji need
+// between characters.
+func isJoiner(r rune) bool {
+	return r == '\u200c' || r == '\u200d'
+}
+
+// dropLoneJoiners removes the joiners that don't sit between two visible characters.
+func dropLoneJoiners(s string) string {
+	rs := []rune(s)
+	visible := func(i int) bool {
+		return i >= 0 && i < len(rs) && !isJoiner(rs[i]) && !unicode.IsSpace(rs[i])
+	}
+	var b strings.Builder
+	for i, r := range rs {
+		if isJoiner(r) && !(visible(i-1) && visible(i+1)) {
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// stripEscapes removes terminal escape sequences: CSI sequences such as colours and cursor
+// movement, OSC, DCS and other string sequences such as window titles and hyperlinks, up to
+// their terminator, and two character escapes.  Both the 7 bit ESC forms and the 8 bit C1
+// introducers are recognised.
+func stripEscapes(s string) string {
+	if !strings.ContainsAny(s, "\x1b\u009b\u009d\u0090\u009e\u009f\u0098") {
+		return s
+	}
+	var b strings.Builder
+	rs := []rune(s)
+	for i := 0; i < len(rs); i++ {
+		r := rs[i]
+		switch {
+		case r == '\x1b' && i+1 < len(rs) && rs[i+1] == '[', r == '\u009b':
+			if r == '\x1b' {
+				i++
+			}
+			// parameter and intermediate bytes, then a final byte
+			for i+1 < len(rs) && rs[i+1] >= 0x20 && rs[i+1] <= 0x3f {
+				i++
+			}
+			for i+1 < len(rs) && rs[i+1] >= 0x20 && rs[i+1] <= 0x2f {
+				i++
+			}
+			if i+1 < len(rs) && rs[i+1] >= 0x40 && rs[i+1] <= 0x7e {
+				i++
+			}
+		case r == '\x1b' && i+1 < len(rs) && strings.ContainsRune("]PX^_", rs[i+1]), r == '\u009d', r == '\u0090', r == '\u0098', r == '\u009e', r == '\u009f':
+			if r == '\x1b' {
+				i++
+			}
+			// a string runs to BEL or ST, or the end of the text
+			for i+1 < len(rs) {
+				i++
+				if rs[i] == '\a' || rs[i] == '\u009c' {
+					break
+				}
+				if rs[i] == '\x1b' && i+1 < len(rs) && rs[i+1] == '\\' {
+					i++
+					break
+				}
+			}
+		case r == '\x1b':
+			// ESC and the character it introduces
+			if i+1 < len(rs) {
+				i++
+			}
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// limitMarks keeps at most MaxCombiningMarks combining marks on each character.
+func limitMarks(s string) string {
+	marks := 0
+	overflow := false
+	for _, r := range s {
+		if unicode.Is(unicode.M, r) {
+			if marks++; marks > MaxCombiningMarks {
+				overflow = true
+				break
+			}
+		} else {
+			marks = 0
+		}
+	}
+	if !overflow {
+		return s
+	}
+	var b strings.Builder
+	marks = 0
+	for _, r := range s {
+		if unicode.Is(unicode.M, r) {
+			if marks++; marks > MaxCombiningMarks {
+				continue
+			}
+		} else {
+			marks = 0
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
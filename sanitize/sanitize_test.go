@@ -0,0 +1,132 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package sanitize
+
+import (
+	"strings"
+	"testing"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/rivo/tview"
+	"golang.org/x/text/unicode/norm"
+)
+
+var examples = []struct {
+	name, in, text, line string
+}{
+	{"plain", "hello world", "hello world", "hello world"},
+	{"colour", "\x1b[31mred\x1b[0m text", "red text", "red text"},
+	{"cursor", "fake\x1b[2K\x1b[1Greal", "fakereal", "fakereal"},
+	{"title", "\x1b]0;pwned\x07hi", "hi", "hi"},
+	{"hyperlink", "\x1b]8;;http://evil\x1b\\click\x1b]8;;\x1b\\", "click", "click"},
+	{"c1 csi", "a\u009b31mb", "ab", "ab"},
+	{"lone escape", "a\x1b", "a", "a"},
+	{"carriage return", "safe\roverwritten", "safeoverwritten", "safeoverwritten"},
+	{"backspace and bell", "ab\b\bc\a", "abc", "abc"},
+	{"newlines", "one\ntwo\tthree\u2028four", "one\ntwo\tthree\nfour", "one two three four"},
+	{"bidi override", "file\u202egnp.exe", "filegnp.exe", "filegnp.exe"},
+	{"bidi isolate", "\u2067abc\u2069", "abc", "abc"},
+	{"zero width", "ad\u200bmin\ufeff", "admin", "admin"},
+	{"emoji joiner", "\U0001f469\u200d\U0001f4bb", "\U0001f469\u200d\U0001f4bb", "\U0001f469\u200d\U0001f4bb"},
+	{"lone joiner", "\u200dx\u200d \u200d\u200dy", "x y", "x y"},
+	{"normal form", "e\u0301", "\u00e9", "\u00e9"},
+	{"zalgo", "a\u0300\u0301\u0302\u0303\u0304\u0305\u0306b", "\u00e0\u0301\u0302\u0303\u0304b", "\u00e0\u0301\u0302\u0303\u0304b"},
+	{"invalid utf8", "a\xffb", "a\ufffdb", "a\ufffdb"},
+}
+
+func TestText(t *testing.T) {
+	for _, e := range examples {
+		if got := Text(e.in); got != e.text {
+			t.Errorf("%s: Text(%q) = %q, want %q", e.name, e.in, got, e.text)
+		}
+		if got := Line(e.in); got != e.line {
+			t.Errorf("%s: Line(%q) = %q, want %q", e.name, e.in, got, e.line)
+		}
+	}
+	if got := Name(" \uff41\uff44\uff4d\uff49\uff4e\u200d "); got != "admin" {
+		t.Errorf("Name folded to %q", got)
+	}
+	if got := TviewLine(`[red]alert["region"]`); got == `[red]alert["region"]` {
+		t.Errorf("tags weren't escaped: %q", got)
+	}
+}
+
+// checkClean fails when the sanitized form of in can still affect a terminal.
+func checkClean(t *testing.T, in string, out string, oneLine bool) {
+	t.Helper()
+	if !utf8.ValidString(out) {
+		t.Fatalf("%q became invalid UTF-8 %q", in, out)
+	}
+	marks := 0
+	for _, r := range out {
+		switch {
+		case r == '\n' || r == '\t':
+			if oneLine {
+				t.Fatalf("%q kept a line break or tab: %q", in, out)
+			}
+		case unicode.IsControl(r):
+			t.Fatalf("%q kept the control character %U: %q", in, r, out)
+		case unicode.Is(unicode.Cf, r) && !isJoiner(r):
+			t.Fatalf("%q kept the format character %U: %q", in, r, out)
+		}
+		if unicode.Is(unicode.M, r) {
+			marks++
+		} else {
+			marks = 0
+		}
+		if marks > MaxCombiningMarks {
+			t.Fatalf("%q kept %d combining marks: %q", in, marks, out)
+		}
+	}
+	if !norm.NFC.IsNormalString(out) {
+		t.Fatalf("%q isn't normalized: %q", in, out)
+	}
+}
+
+func addSeeds(f *testing.F) {
+	for _, e := range examples {
+		f.Add(e.in)
+	}
+	f.Add("[red]x[-] [::b]bold[\"r1\"]region[\"\"]")
+}
+
+func FuzzText(f *testing.F) {
+	addSeeds(f)
+	f.Fuzz(func(t *testing.T, in string) {
+		out := Text(in)
+		checkClean(t, in, out, false)
+		if again := Text(out); again != out {
+			t.Fatalf("Text isn't idempotent on %q: %q then %q", in, out, again)
+		}
+	})
+}
+
+func FuzzLine(f *testing.F) {
+	addSeeds(f)
+	f.Fuzz(func(t *testing.T, in string) {
+		out := Line(in)
+		checkClean(t, in, out, true)
+		if again := Line(out); again != out {
+			t.Fatalf("Line isn't idempotent on %q: %q then %q", in, out, again)
+		}
+		name := Name(in)
+		if strings.ContainsAny(name, "\u200c\u200d") || !norm.NFKC.IsNormalString(name) {
+			t.Fatalf("Name(%q) = %q", in, name)
+		}
+	})
+}
+
+// FuzzTview checks tview shows the escaped text as is, with no colours or regions.
+func FuzzTview(f *testing.F) {
+	addSeeds(f)
+	f.Fuzz(func(t *testing.T, in string) {
+		line := Line(in)
+		view := tview.NewTextView().SetDynamicColors(true).SetRegions(true)
+		view.SetText(TviewLine(in))
+		if shown := view.GetText(true); shown != line {
+			t.Fatalf("tview shows %q as %q, want %q", in, shown, line)
+		}
+	})
+}
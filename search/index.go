@@ -0,0 +1,399 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package search
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/rightfoot-consulting/p2pbbs/boards"
+	"golang.org/x/text/unicode/norm"
+)
+
+var logger = logging.Logger("search")
+
+// IndexFile is the name of the file in the data directory holding the search index.
+const IndexFile = "search.jsonl"
+
+const (
+	// maxLineBytes bounds a line of the index file, a post of the largest size fits.
+	maxLineBytes = 1 << 20
+	// bm25K1 and bm25B tune how term frequency and document length weigh on the ranking.
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// Kind tells board posts from chat messages.
+type Kind string
+
+const (
+	Post Kind = "post"
+	Chat Kind = "chat"
+)
+
+// Document is a post or chat message as the index keeps it.  Scope is the board of a post or
+// the room of a chat message.  Chat messages that have one keep the ID they are referred to by in
+// Message, and the ID of the message they reply to.
+type Document struct {
+	ID      string    `json:"id"`
+	Kind    Kind      `json:"kind"`
+	Scope   string    `json:"scope"`
+	Author  string    `json:"author"`
+	Nick    string    `json:"nick"`
+	Subject string    `json:"subject,omitempty"`
+	Text    string    `json:"text"`
+	Time    time.Time `json:"time"`
+	Message string    `json:"message,omitempty"`
+	ReplyTo string    `json:"reply_to,omitempty"`
+}
+
+// PostDocument returns the document of a board post.
+func PostDocument(p *boards.Post) *Document {
+	return &Document{
+		ID:      p.ID,
+		Kind:    Post,
+		Scope:   p.Board,
+		Author:  p.Author,
+		Nick:    p.Nick,
+		Subject: p.Subject,
+		Text:    p.Body,
+		Time:    p.Created,
+	}
+}
+
+// ChatDocument returns the document of a chat message.  Its id is a hash of the message, so
+// a message seen twice is indexed once.
+func ChatDocument(room string, author string, nick string, text string, at time.Time) *Document {
+	sum := sha256.Sum256([]byte(strings.Join([]string{room, author, at.UTC().Format(time.RFC3339Nano), text}, "\x00")))
+	return &Document{
+		ID:     "chat-" + hex.EncodeToString(sum[:16]),
+		Kind:   Chat,
+		Scope:  room,
+		Author: author,
+		Nick:   nick,
+		Text:   text,
+		Time:   at.UTC(),
+	}
+}
+
+// Index is a full-text index of posts and chat messages, kept in memory and in an append-only
+// file of documents it is loaded from.  Nothing in it needs the network.
+type Index struct {
+	file string
+
+	mu       sync.RWMutex
+	store    *boards.Store
+	docs     map[string]*Document
+	postings map[string]map[string]int
+	lengths  map[string]int
+	total    int
+}
+
+// Open loads the index in file, creating it if needed.  Lines that don't decode, like a last
+// line cut short by a crash, are skipped.
+func Open(file string) (ix *Index, err error) {
+	ix = &Index{file: file}
+	ix.reset()
+	f, err := os.Open(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return ix, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64<<10), maxLineBytes)
+	for scanner.Scan() {
+		d := new(Document)
+		if json.Unmarshal(scanner.Bytes(), d) != nil || d.ID == "" {
+			continue
+		}
+		if _, ok := ix.docs[d.ID]; !ok {
+			ix.index(d)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Add indexes a document and appends it to the file if it is new.
+func (ix *Index) Add(d *Document) (added bool, err error) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	if _, ok := ix.docs[d.ID]; ok {
+		return
+	}
+	line, err := json.Marshal(d)
+	if err != nil {
+		return
+	}
+	f, err := os.OpenFile(ix.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	_, err = f.Write(append(line, '\n'))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return
+	}
+	ix.index(d)
+	return true, nil
+}
+
+// AddPosts indexes the posts of store the index doesn't hold yet.
+func (ix *Index) AddPosts(store *boards.Store) (added int, err error) {
+	for _, board := range store.Boards() {
+		for _, p := range store.Posts(board) {
+			ok, err := ix.Add(PostDocument(p))
+			if err != nil {
+				return added, err
+			}
+			if ok {
+				added++
+			}
+		}
+	}
+	return
+}
+
+// Follow indexes the posts of store the index is missing, then those added to it until ctx
+// ends.
+func (ix *Index) Follow(ctx context.Context, store *boards.Store) {
+	posts, stop := store.Subscribe()
+	defer stop()
+	if _, err := ix.AddPosts(store); err != nil {
+		logger.Warnf("unable to index the stored posts: %v", err)
+	}
+	for {
+		select {
+		case p := <-posts:
+			if _, err := ix.Add(PostDocument(p)); err != nil {
+				logger.Warnf("unable to index post %s: %v", p.ID, err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Moderate leaves the posts store no longer holds, like those deleted by moderators, out of
+// the results.  Rebuild drops them from the index.
+func (ix *Index) Moderate(store *boards.Store) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.store = store
+}
+
+// Rebuild indexes the posts of store afresh, dropping those it no longer holds such as posts
+// deleted by moderators, and rewrites the file.  Chat messages are kept, the index is where
+// they are stored.
+func (ix *Index) Rebuild(store *boards.Store) (count int, err error) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	docs := make([]*Document, 0, len(ix.docs))
+	for _, d := range ix.docs {
+		if d.Kind == Chat {
+			docs = append(docs, d)
+		}
+	}
+	for _, board := range store.Boards() {
+		for _, p := range store.Posts(board) {
+			docs = append(docs, PostDocument(p))
+		}
+	}
+	if err = ix.rewrite(docs); err != nil {
+		return
+	}
+	return len(ix.docs), nil
+}
+
+// Remove drops the documents drop matches from the index and rewrites the file, so their text
+// is gone from the disk as well.
+func (ix *Index) Remove(drop func(d *Document) bool) (removed int, err error) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	docs := make([]*Document, 0, len(ix.docs))
+	for _, d := range ix.docs {
+		if drop(d) {
+			removed++
+		} else {
+			docs = append(docs, d)
+		}
+	}
+	if removed == 0 {
+		return
+	}
+	return removed, ix.rewrite(docs)
+}
+
+// rewrite indexes docs afresh, oldest first, and replaces the file with them.  The caller must
+// hold the lock.
+func (ix *Index) rewrite(docs []*Document) (err error) {
+	sort.Slice(docs, func(i, j int) bool { return docs[i].Time.Before(docs[j].Time) })
+	tmp, err := os.CreateTemp(filepath.Dir(ix.file), filepath.Base(ix.file)+".*")
+	if err != nil {
+		return
+	}
+	defer os.Remove(tmp.Name())
+	w := bufio.NewWriter(tmp)
+	ix.reset()
+	for _, d := range docs {
+		if _, ok := ix.docs[d.ID]; ok {
+			continue
+		}
+		line, err := json.Marshal(d)
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		w.Write(append(line, '\n'))
+		ix.index(d)
+	}
+	if err = w.Flush(); err == nil {
+		err = tmp.Chmod(0600)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return
+	}
+	return os.Rename(tmp.Name(), ix.file)
+}
+
+// Len returns the number of documents in the index.
+func (ix *Index) Len() int {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	return len(ix.docs)
+}
+
+// Search returns the documents matching q, best first, at most limit of them.  Documents are
+// ranked by BM25 over the words of the query, words of a subject counting twice, and newest
+// first when the query has no words.
+func (ix *Index) Search(q *Query, limit int) []*Result {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	words := q.words()
+	var candidates map[string]int
+	if len(words) > 0 {
+		candidates = ix.postings[words[0]]
+	}
+	results := make([]*Result, 0)
+	consider := func(id string) {
+		d := ix.docs[id]
+		for _, w := range words {
+			if ix.postings[w][id] == 0 {
+				return
+			}
+		}
+		if !q.matches(d) {
+			return
+		}
+		if d.Kind == Post && ix.store != nil {
+			if _, ok := ix.store.Get(id); !ok {
+				return
+			}
+		}
+		results = append(results, &Result{Document: d, Score: ix.score(id, words), words: words})
+	}
+	if len(words) > 0 {
+		for id := range candidates {
+			consider(id)
+		}
+	} else {
+		for id := range ix.docs {
+			consider(id)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Document.Time.After(results[j].Document.Time)
+	})
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+// score is the BM25 score of a document for the words of a query, the caller must hold the lock.
+func (ix *Index) score(id string, words []string) (score float64) {
+	n := float64(len(ix.docs))
+	average := float64(ix.total) / n
+	for _, w := range unique(words) {
+		docs := float64(len(ix.postings[w]))
+		idf := math.Log(1 + (n-docs+0.5)/(docs+0.5))
+		tf := float64(ix.postings[w][id])
+		score += idf * tf * (bm25K1 + 1) / (tf + bm25K1*(1-bm25B+bm25B*float64(ix.lengths[id])/average))
+	}
+	return
+}
+
+// index adds a document to the in memory maps, the caller must hold the lock.
+func (ix *Index) index(d *Document) {
+	ix.docs[d.ID] = d
+	words := documentWords(d)
+	for _, w := range words {
+		if ix.postings[w] == nil {
+			ix.postings[w] = make(map[string]int)
+		}
+		ix.postings[w][d.ID]++
+	}
+	ix.lengths[d.ID] = len(words)
+	ix.total += len(words)
+}
+
+// reset empties the in memory maps, the caller must hold the lock.
+func (ix *Index) reset() {
+	ix.docs = make(map[string]*Document)
+	ix.postings = make(map[string]map[string]int)
+	ix.lengths = make(map[string]int)
+	ix.total = 0
+}
+
+// documentWords returns the words a document is indexed under, those of its subject twice.
+func documentWords(d *Document) []string {
+	subject := Tokenize(d.Subject)
+	return append(append(subject, subject...), Tokenize(d.Text)...)
+}
+
+// Tokenize splits text into the words the index is built from: runs of letters and digits,
+// normalized and lower cased.
+func Tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(norm.NFKC.String(text)), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+func unique(words []string) []string {
+	seen := make(map[string]bool, len(words))
+	kept := words[:0:0]
+	for _, w := range words {
+		if !seen[w] {
+			seen[w] = true
+			kept = append(kept, w)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,139 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package search
+
+import (
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/rightfoot-consulting/p2pbbs/boards"
+)
+
+func TestParseQuery(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	q, err := ParseQuery(`Relay "private  Swarm" -tor e-mail author:"Bob Smith" from:alice board:general after:2024-03-01 before:7d`, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(q.Terms, []string{"relay"}) || len(q.Phrases) != 2 || !slices.Equal(q.Phrases[0], []string{"private", "swarm"}) ||
+		!slices.Equal(q.Phrases[1], []string{"e", "mail"}) || !slices.Equal(q.Excluded, []string{"tor"}) {
+		t.Errorf("words of %+v", q)
+	}
+	if !slices.Equal(q.Authors, []string{"Bob Smith", "alice"}) || !slices.Equal(q.Boards, []string{"general"}) || len(q.Rooms) != 0 {
+		t.Errorf("filters of %+v", q)
+	}
+	if !q.After.Equal(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)) || !q.Before.Equal(now.AddDate(0, 0, -7)) {
+		t.Errorf("dates %v and %v", q.After, q.Before)
+	}
+	for _, bad := range []string{"", `"" -word`, "after:yesterday"} {
+		if _, err = ParseQuery(bad, now); err == nil {
+			t.Errorf("parsed %q", bad)
+		}
+	}
+}
+
+func TestIndex(t *testing.T) {
+	store, err := boards.OpenStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sk, _, _ := crypto.GenerateEd25519Key(rand.Reader)
+	relays, _ := boards.NewPost(sk, "alice", "general", "Running a relay", "How do I keep my relay node up?", nil)
+	swarm, _ := boards.NewPost(sk, "alice", "general", "Private swarm", "The swarm key goes in chatconfig.json, then every relay needs it too.", nil)
+	other, _ := boards.NewPost(sk, "alice", "offtopic", "Cats", "Cats are better than relay racing", nil)
+	for _, p := range []*boards.Post{relays, swarm, other} {
+		if _, err = store.Add(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	file := filepath.Join(t.TempDir(), IndexFile)
+	ix, err := Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added, err := ix.AddPosts(store); err != nil || added != 3 {
+		t.Fatalf("indexed %d posts: %v", added, err)
+	}
+	now := time.Now()
+	ix.Add(ChatDocument("lobby", "12D3KooWBob", "bob", "anyone running a relay in a private swarm?", now.Add(-24*time.Hour)))
+	ix.Add(ChatDocument("lobby", "12D3KooWBob", "bob", "never mind, found it", now))
+	if added, _ := ix.Add(ChatDocument("lobby", "12D3KooWBob", "bob", "never mind, found it", now)); added {
+		t.Error("indexed the same message twice")
+	}
+
+	search := func(text string) (found []string) {
+		t.Helper()
+		q, err := ParseQuery(text, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range ix.Search(q, 10) {
+			found = append(found, r.Document.Text)
+		}
+		return
+	}
+	// a relay in the subject ranks first
+	if found := search("relay"); len(found) != 4 || !strings.HasPrefix(found[0], "How do I") {
+		t.Errorf("relay found %q", found)
+	}
+	if found := search(`"private swarm"`); len(found) != 2 || !strings.HasPrefix(found[0], "The swarm key") {
+		t.Errorf("the phrase found %q", found)
+	}
+	if found := search("relay -cats board:general board:offtopic"); len(found) != 2 {
+		t.Errorf("excluding cats found %q", found)
+	}
+	if found := search("relay room:lobby author:BOB"); len(found) != 1 {
+		t.Errorf("bob's relay found %q", found)
+	}
+	if found := search("author:bob"); len(found) != 2 || found[0] != "never mind, found it" {
+		t.Errorf("bob's messages newest first %q", found)
+	}
+	if found := search("relay before:1h"); len(found) != 1 {
+		t.Errorf("relay more than an hour ago found %q", found)
+	}
+
+	r := &Result{Document: ix.docs[swarm.ID], words: []string{"relay"}}
+	if snippet := r.Snippet(24); snippet != "…every relay needs it…" {
+		t.Errorf("snippet %q", snippet)
+	}
+
+	// the index survives a reload, deleted posts are left out and a rebuild drops them
+	if ix, err = Open(file); err != nil || ix.Len() != 5 {
+		t.Fatalf("reopened %d documents: %v", ix.Len(), err)
+	}
+	smaller, _ := boards.OpenStore(t.TempDir())
+	smaller.Add(swarm)
+	ix.Moderate(smaller)
+	if found := search("relay"); len(found) != 2 {
+		t.Errorf("relay found %q in the smaller store", found)
+	}
+	if count, err := ix.Rebuild(smaller); err != nil || count != 3 {
+		t.Fatalf("rebuilt %d documents: %v", count, err)
+	}
+	if ix, err = Open(file); err != nil || ix.Len() != 3 || len(search("cats")) != 0 {
+		t.Errorf("reopened %d documents after the rebuild: %v", ix.Len(), err)
+	}
+	if info, err := os.Stat(file); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("the index is readable by others: %v", err)
+	}
+
+	// removed messages are gone from the file as well
+	chat := func(d *Document) bool { return d.Kind == Chat }
+	if removed, err := ix.Remove(chat); err != nil || removed != 2 {
+		t.Fatalf("removed %d messages: %v", removed, err)
+	}
+	if data, err := os.ReadFile(file); err != nil || strings.Contains(string(data), "never mind") {
+		t.Errorf("a removed message is still in the file: %v", err)
+	}
+	if ix, err = Open(file); err != nil || ix.Len() != 1 {
+		t.Errorf("reopened %d documents after removing the messages: %v", ix.Len(), err)
+	}
+}
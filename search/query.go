@@ -0,0 +1,256 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package search
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// ErrEmptyQuery is returned for queries with neither words nor filters.
+var ErrEmptyQuery = errors.New("nothing to search for")
+
+// Query is a parsed search.  Documents match when they hold every term, every phrase and none
+// of the excluded terms, and pass every filter that is set.
+type Query struct {
+	Terms    []string
+	Phrases  [][]string
+	Excluded []string
+	Authors  []string
+	Rooms    []string
+	Boards   []string
+	After    time.Time
+	Before   time.Time
+}
+
+// ParseQuery parses a query such as:
+//
+//	relay "private swarm" -tor author:alice board:general after:2024-03-01 before:7d
+//
+// Words are terms, quoted words are phrases and words starting with - are excluded.  author:
+// takes a nick or peer id, room: and board: limit the search to chat rooms or boards.  after:
+// and before: take a date, a time in RFC 3339 or how long ago, like 12h or 7d.  Filters of the
+// same kind are alternatives, values may be quoted.
+func ParseQuery(text string, now time.Time) (q *Query, err error) {
+	q = new(Query)
+	for _, field := range splitQuery(text) {
+		key, value, ok := strings.Cut(field.text, ":")
+		if ok && !field.quoted {
+			value = strings.Trim(value, `"`)
+			switch strings.ToLower(key) {
+			case "author", "from":
+				q.Authors = append(q.Authors, value)
+				continue
+			case "room":
+				q.Rooms = append(q.Rooms, value)
+				continue
+			case "board":
+				q.Boards = append(q.Boards, value)
+				continue
+			case "after", "since":
+				if q.After, err = parseTime(value, now); err != nil {
+					return nil, err
+				}
+				continue
+			case "before", "until":
+				if q.Before, err = parseTime(value, now); err != nil {
+					return nil, err
+				}
+				continue
+			}
+		}
+		if !field.quoted && strings.HasPrefix(field.text, "-") {
+			q.Excluded = append(q.Excluded, Tokenize(field.text)...)
+			continue
+		}
+		// words like e-mail are phrases of their parts
+		switch words := Tokenize(field.text); {
+		case len(words) == 1:
+			q.Terms = append(q.Terms, words[0])
+		case len(words) > 1:
+			q.Phrases = append(q.Phrases, words)
+		}
+	}
+	if len(q.Terms) == 0 && len(q.Phrases) == 0 && len(q.Authors) == 0 && len(q.Rooms) == 0 &&
+		len(q.Boards) == 0 && q.After.IsZero() && q.Before.IsZero() {
+		return nil, ErrEmptyQuery
+	}
+	return
+}
+
+type queryField struct {
+	text   string
+	quoted bool
+}
+
+// splitQuery splits a query at spaces outside of quotes.  A field starting with a quote is a
+// phrase, a quote after key: quotes the value.
+func splitQuery(text string) (fields []queryField) {
+	var current strings.Builder
+	quoted, inQuotes := false, false
+	flush := func() {
+		if current.Len() > 0 || quoted {
+			fields = append(fields, queryField{text: current.String(), quoted: quoted})
+		}
+		current.Reset()
+		quoted = false
+	}
+	for _, r := range text {
+		switch {
+		case r == '"' && !inQuotes && current.Len() == 0:
+			quoted, inQuotes = true, true
+		case r == '"' && inQuotes && quoted:
+			inQuotes = false
+			flush()
+		case r == '"':
+			inQuotes = !inQuotes
+			current.WriteRune(r)
+		case unicode.IsSpace(r) && !inQuotes:
+			flush()
+		default:
+			current.WriteRune(r)
+		}
+	}
+	flush()
+	return
+}
+
+// parseTime reads the value of after: and before:.
+func parseTime(value string, now time.Time) (t time.Time, err error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n >= 0 {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	if t, err = time.ParseInLocation(time.DateOnly, value, now.Location()); err == nil {
+		return
+	}
+	if t, err = time.Parse(time.RFC3339, value); err == nil {
+		return
+	}
+	return t, fmt.Errorf("bad time %q, try 2024-03-01, 12h or 7d", value)
+}
+
+// words returns the words a document must hold: the terms and the words of the phrases.
+func (q *Query) words() []string {
+	words := slices.Clone(q.Terms)
+	for _, phrase := range q.Phrases {
+		words = append(words, phrase...)
+	}
+	return words
+}
+
+// matches checks a document against the filters, phrases and excluded terms of the query.
+func (q *Query) matches(d *Document) bool {
+	if len(q.Authors) > 0 && !slices.ContainsFunc(q.Authors, func(a string) bool {
+		return a == d.Author || strings.EqualFold(a, d.Nick)
+	}) {
+		return false
+	}
+	if len(q.Rooms) > 0 || len(q.Boards) > 0 {
+		scopes := q.Boards
+		if d.Kind == Chat {
+			scopes = q.Rooms
+		}
+		if !slices.Contains(scopes, d.Scope) {
+			return false
+		}
+	}
+	if !q.After.IsZero() && d.Time.Before(q.After) {
+		return false
+	}
+	if !q.Before.IsZero() && !d.Time.Before(q.Before) {
+		return false
+	}
+	if len(q.Phrases) == 0 && len(q.Excluded) == 0 {
+		return true
+	}
+	subject, text := Tokenize(d.Subject), Tokenize(d.Text)
+	for _, w := range q.Excluded {
+		if slices.Contains(subject, w) || slices.Contains(text, w) {
+			return false
+		}
+	}
+	for _, phrase := range q.Phrases {
+		if !containsPhrase(subject, phrase) && !containsPhrase(text, phrase) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsPhrase(words []string, phrase []string) bool {
+	for i := 0; i+len(phrase) <= len(words); i++ {
+		if slices.Equal(words[i:i+len(phrase)], phrase) {
+			return true
+		}
+	}
+	return false
+}
+
+// Result is a document matching a query with its score.
+type Result struct {
+	Document *Document
+	Score    float64
+
+	words []string
+}
+
+// Snippet returns the text of the result on one line, cut to about width characters around
+// the first word of the query it holds.
+func (r *Result) Snippet(width int) string {
+	text := []rune(strings.Join(strings.Fields(r.Document.Text), " "))
+	if len(text) <= width {
+		return string(text)
+	}
+	lower := []rune(strings.ToLower(string(text)))
+	start := 0
+	if len(lower) == len(text) {
+		first := len(lower)
+		for _, w := range r.words {
+			if i := strings.Index(string(lower), w); i >= 0 {
+				first = min(first, len([]rune(string(lower)[:i])))
+			}
+		}
+		if first < len(lower) {
+			start = max(0, min(first-width/3, len(text)-width))
+		}
+	}
+	// cut at spaces rather than through words
+	snippet := string(text[start : start+width])
+	if start > 0 {
+		if _, rest, ok := strings.Cut(snippet, " "); ok {
+			snippet = rest
+		}
+		snippet = "…" + snippet
+	}
+	if start+width < len(text) {
+		if i := strings.LastIndex(snippet, " "); i > 0 {
+			snippet = snippet[:i]
+		}
+		snippet += "…"
+	}
+	return snippet
+}
+
+// Line describes the result on one line: when and where it was written, by whom, and a snippet
+// of about width characters.
+func (r *Result) Line(width int) string {
+	d := r.Document
+	where := "#" + d.Scope
+	text := r.Snippet(width)
+	if d.Kind == Post {
+		where = "board " + d.Scope
+		text = fmt.Sprintf("%s: %s", d.Subject, text)
+	}
+	return fmt.Sprintf("%s %s <%s> %s", d.Time.Local().Format(time.DateTime), where, d.Nick, text)
+}
@@ -0,0 +1,237 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package sshbbs
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/rightfoot-consulting/p2pbbs/accounts"
+	"github.com/rightfoot-consulting/p2pbbs/bbsui"
+	"github.com/rightfoot-consulting/p2pbbs/chatv2"
+	"golang.org/x/crypto/ssh"
+)
+
+var logger = logging.Logger("sshbbs")
+
+// HostKeyFile is the name of the file in the data directory holding the server's host key.
+const HostKeyFile = "ssh_host_ed25519_key"
+
+// DefaultAddress is where the server listens when no address is given.
+const DefaultAddress = ":2222"
+
+// accountExtension carries the name of the authenticated account from the public key
+// callback to the connection handler.
+const accountExtension = "p2pbbs-account"
+
+// Server is an SSH server presenting the BBS menu to the local accounts of a node.  Users log
+// in with an SSH key authorized for their account, the SSH user name is ignored.
+type Server struct {
+	node     *chatv2.ChatV2Node
+	accounts *accounts.Store
+	config   *ssh.ServerConfig
+}
+
+// NewServer returns a server for the accounts in store, identified to clients by hostKey.
+func NewServer(node *chatv2.ChatV2Node, store *accounts.Store, hostKey ssh.Signer) *Server {
+	s := &Server{
+		node:     node,
+		accounts: store,
+	}
+	s.config = &ssh.ServerConfig{
+		PublicKeyCallback: s.authenticate,
+		ServerVersion:     "SSH-2.0-p2pbbs",
+	}
+	s.config.AddHostKey(hostKey)
+	return s
+}
+
+// LoadHostKey reads the host key in file, generating and saving an ed25519 key the first time.
+func LoadHostKey(file string) (signer ssh.Signer, err error) {
+	pemBytes, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		var privateKey ed25519.PrivateKey
+		_, privateKey, err = ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return
+		}
+		var block *pem.Block
+		block, err = ssh.MarshalPrivateKey(privateKey, "p2pbbs host key")
+		if err != nil {
+			return
+		}
+		pemBytes = pem.EncodeToMemory(block)
+		err = os.WriteFile(file, pemBytes, 0600)
+	}
+	if err != nil {
+		return
+	}
+	return ssh.ParsePrivateKey(pemBytes)
+}
+
+// ListenAndServe accepts connections on address until ctx ends.
+func (s *Server) ListenAndServe(ctx context.Context, address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ctx, listener)
+}
+
+// Serve accepts connections on listener until ctx ends.
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go s.handleConn(ctx, conn)
+	}
+}
+
+func (s *Server) authenticate(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	account, ok := s.accounts.ByPublicKey(key)
+	if !ok {
+		return nil, fmt.Errorf("key %s is not authorized", ssh.FingerprintSHA256(key))
+	}
+	return &ssh.Permissions{Extensions: map[string]string{accountExtension: account.Name}}, nil
+}
+
+func (s *Server) handleConn(ctx context.Context, conn net.Conn) {
+	sconn, channels, requests, err := ssh.NewServerConn(conn, s.config)
+	if err != nil {
+		logger.Debugf("ssh handshake with %s failed: %v", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+	defer sconn.Close()
+	go ssh.DiscardRequests(requests)
+	go func() {
+		<-ctx.Done()
+		sconn.Close()
+	}()
+
+	account, ok := s.accounts.Get(sconn.Permissions.Extensions[accountExtension])
+	if !ok {
+		return
+	}
+	logger.Infof("%s logged in from %s", account.Name, sconn.RemoteAddr())
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only sessions are supported")
+			continue
+		}
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			logger.Debugf("unable to accept channel from %s: %v", account.Name, err)
+			continue
+		}
+		go s.handleSession(ctx, account, channel, channelRequests)
+	}
+	logger.Infof("%s logged off", account.Name)
+}
+
+// ptyRequest is the payload of a "pty-req" request, RFC 4254 section 6.2.
+type ptyRequest struct {
+	Term     string
+	Columns  uint32
+	Rows     uint32
+	WidthPx  uint32
+	HeightPx uint32
+	Modes    string
+}
+
+// windowChange is the payload of a "window-change" request, RFC 4254 section 6.7.
+type windowChange struct {
+	Columns  uint32
+	Rows     uint32
+	WidthPx  uint32
+	HeightPx uint32
+}
+
+// handleSession answers the requests of a session channel and runs the BBS menu once the
+// client asks for a shell.
+func (s *Server) handleSession(ctx context.Context, account *accounts.Account, channel ssh.Channel, requests <-chan *ssh.Request) {
+	var pty *ptyRequest
+	var tty *bbsui.Tty
+	for req := range requests {
+		switch req.Type {
+		case "pty-req":
+			pty = new(ptyRequest)
+			if err := ssh.Unmarshal(req.Payload, pty); err != nil {
+				pty = nil
+			}
+			req.Reply(pty != nil, nil)
+		case "window-change":
+			var size windowChange
+			if err := ssh.Unmarshal(req.Payload, &size); err == nil && tty != nil {
+				tty.Resize(int(size.Columns), int(size.Rows))
+			}
+		case "env":
+			req.Reply(true, nil)
+		case "shell":
+			if pty == nil || tty != nil {
+				req.Reply(false, nil)
+				if tty == nil {
+					fmt.Fprint(channel, "p2pbbs needs a terminal, connect with ssh -t\r\n")
+					closeChannel(channel, 1)
+					return
+				}
+				continue
+			}
+			req.Reply(true, nil)
+			tty = bbsui.NewTty(&sessionChannel{Channel: channel}, int(pty.Columns), int(pty.Rows))
+			go s.runMenu(ctx, account, tty, pty.Term)
+		default:
+			// exec and subsystems such as sftp are not offered
+			req.Reply(false, nil)
+		}
+	}
+}
+
+func (s *Server) runMenu(ctx context.Context, account *accounts.Account, tty *bbsui.Tty, term string) {
+	defer tty.Close()
+	session, err := bbsui.NewSession(ctx, s.node, s.accounts, account)
+	if err != nil {
+		fmt.Fprintf(tty, "unable to start session: %v\r\n", err)
+		return
+	}
+	screen, err := bbsui.NewScreen(tty, term)
+	if err != nil {
+		fmt.Fprintf(tty, "unable to use terminal %s: %v\r\n", term, err)
+		return
+	}
+	if err = session.Run(screen, tty); err != nil {
+		logger.Debugf("session of %s ended: %v", account.Name, err)
+	}
+}
+
+// sessionChannel reports a successful exit to the client when the session closes.
+type sessionChannel struct {
+	ssh.Channel
+}
+
+func (c *sessionChannel) Close() error {
+	return closeChannel(c.Channel, 0)
+}
+
+func closeChannel(channel ssh.Channel, status uint32) error {
+	channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+	return channel.Close()
+}
@@ -0,0 +1,97 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package sshbbs
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rightfoot-consulting/p2pbbs/accounts"
+	"golang.org/x/crypto/ssh"
+)
+
+func newSigner(t *testing.T) ssh.Signer {
+	t.Helper()
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func TestLoopbackSession(t *testing.T) {
+	dir := t.TempDir()
+	store, err := accounts.LoadStore(filepath.Join(dir, accounts.StoreFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = store.Create("alice", ""); err != nil {
+		t.Fatal(err)
+	}
+	alice, mallory := newSigner(t), newSigner(t)
+	if err = store.AuthorizeKey("alice", string(ssh.MarshalAuthorizedKey(alice.PublicKey()))); err != nil {
+		t.Fatal(err)
+	}
+	hostKey, err := LoadHostKey(filepath.Join(dir, HostKeyFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go NewServer(nil, store, hostKey).Serve(ctx, listener)
+	dial := func(signer ssh.Signer) (*ssh.Client, error) {
+		return ssh.Dial("tcp", listener.Addr().String(), &ssh.ClientConfig{
+			User:            "anyone",
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+			HostKeyCallback: ssh.FixedHostKey(hostKey.PublicKey()),
+		})
+	}
+
+	if _, err = dial(mallory); err == nil {
+		t.Fatal("logged in with a key that isn't authorized")
+	}
+	client, err := dial(alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// commands aren't offered, and a shell needs a terminal
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = session.Run("cat /etc/passwd"); err == nil {
+		t.Error("ran a command")
+	}
+	session, err = client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, err := session.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = session.Shell(); err == nil {
+		t.Error("started a shell without a terminal")
+	}
+	if text, _ := io.ReadAll(output); !strings.Contains(string(text), "needs a terminal") {
+		t.Errorf("shell without a terminal said %q", text)
+	}
+}
@@ -0,0 +1,116 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package telnetbbs
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// Charset is the character set used on the wire.
+type Charset string
+
+const (
+	// CP437 is the IBM PC character set of classic BBS terminals, with box drawing characters.
+	CP437 Charset = "cp437"
+	// UTF8 suits modern terminals.
+	UTF8 Charset = "utf-8"
+)
+
+// ParseCharset converts the name of a character set into a Charset.
+func ParseCharset(val string) (Charset, error) {
+	switch strings.ToLower(val) {
+	case "cp437", "ibm437", "ansi":
+		return CP437, nil
+	case "utf-8", "utf8":
+		return UTF8, nil
+	default:
+		return "", fmt.Errorf("invalid charset %s, use cp437 or utf-8", val)
+	}
+}
+
+// cp437Writer converts the UTF-8 drawn by the screen into CP437.  Runes CP437 can't show are
+// replaced with '?'.
+type cp437Writer struct {
+	w       io.Writer
+	partial []byte
+}
+
+func (cw *cp437Writer) Write(p []byte) (int, error) {
+	data := p
+	if len(cw.partial) > 0 {
+		data = append(cw.partial, p...)
+		cw.partial = nil
+	}
+	out := make([]byte, 0, len(data))
+	for len(data) > 0 {
+		if data[0] < utf8.RuneSelf {
+			out = append(out, data[0])
+			data = data[1:]
+			continue
+		}
+		if !utf8.FullRune(data) {
+			// keep the start of a rune split across writes for the next write
+			cw.partial = append([]byte(nil), data...)
+			break
+		}
+		r, size := utf8.DecodeRune(data)
+		data = data[size:]
+		if b, ok := charmap.CodePage437.EncodeRune(r); ok {
+			out = append(out, b)
+		} else {
+			out = append(out, '?')
+		}
+	}
+	if _, err := cw.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// cp437Reader converts what a CP437 terminal types into UTF-8.
+type cp437Reader struct {
+	r       io.Reader
+	pending []byte
+}
+
+func (cr *cp437Reader) Read(p []byte) (n int, err error) {
+	if len(cr.pending) == 0 {
+		buf := make([]byte, len(p))
+		var read int
+		read, err = cr.r.Read(buf)
+		for _, b := range buf[:read] {
+			if b < utf8.RuneSelf {
+				cr.pending = append(cr.pending, b)
+			} else {
+				cr.pending = utf8.AppendRune(cr.pending, charmap.CodePage437.DecodeByte(b))
+			}
+		}
+	}
+	n = copy(p, cr.pending)
+	cr.pending = cr.pending[n:]
+	if n > 0 {
+		err = nil
+	}
+	return
+}
+
+// terminal joins the character set conversions with the telnet connection.
+type terminal struct {
+	io.Reader
+	io.Writer
+	io.Closer
+}
+
+// newTerminal returns the connection as seen by the screen for a character set.
+func newTerminal(conn *Conn, charset Charset) io.ReadWriteCloser {
+	if charset == CP437 {
+		return &terminal{Reader: &cp437Reader{r: conn}, Writer: &cp437Writer{w: conn}, Closer: conn}
+	}
+	return conn
+}
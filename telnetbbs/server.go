@@ -0,0 +1,194 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package telnetbbs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/rightfoot-consulting/p2pbbs/accounts"
+	"github.com/rightfoot-consulting/p2pbbs/bbsui"
+	"github.com/rightfoot-consulting/p2pbbs/chatv2"
+)
+
+var logger = logging.Logger("telnetbbs")
+
+// DefaultAddress is where the server listens when no address is given.
+const DefaultAddress = ":2323"
+
+// LoginAttempts is how many times a user may get the password wrong before being disconnected.
+const LoginAttempts = 3
+
+// LoginTimeout bounds how long a connection may sit at the login prompt.
+const LoginTimeout = 2 * time.Minute
+
+// maxLineLength bounds what the login prompt accepts.
+const maxLineLength = 128
+
+// banner is shown before the login prompt, in ANSI colour.
+const banner = "\r\n\x1b[1;36m  p2pbbs\x1b[0m - a bulletin board on a peer to peer network\r\n\r\n"
+
+// Server is a telnet server presenting the BBS menu to the local accounts of a node.  Users
+// log in with the name and password of their account.
+type Server struct {
+	node     *chatv2.ChatV2Node
+	accounts *accounts.Store
+	charset  Charset
+}
+
+// NewServer returns a server for the accounts in store drawing the menu in charset.
+func NewServer(node *chatv2.ChatV2Node, store *accounts.Store, charset Charset) *Server {
+	return &Server{
+		node:     node,
+		accounts: store,
+		charset:  charset,
+	}
+}
+
+// ListenAndServe accepts connections on address until ctx ends.
+func (s *Server) ListenAndServe(ctx context.Context, address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ctx, listener)
+}
+
+// Serve accepts connections on listener until ctx ends.
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go s.handleConn(ctx, conn)
+	}
+}
+
+func (s *Server) handleConn(ctx context.Context, netConn net.Conn) {
+	conn := NewConn(netConn)
+	defer conn.Close()
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	if err := conn.Negotiate(); err != nil {
+		return
+	}
+	netConn.SetDeadline(time.Now().Add(LoginTimeout))
+	account, err := s.login(conn)
+	if err != nil {
+		logger.Debugf("login from %s failed: %v", netConn.RemoteAddr(), err)
+		return
+	}
+	netConn.SetDeadline(time.Time{})
+	logger.Infof("%s logged in from %s", account.Name, netConn.RemoteAddr())
+	defer logger.Infof("%s logged off", account.Name)
+
+	session, err := bbsui.NewSession(ctx, s.node, s.accounts, account)
+	if err != nil {
+		fmt.Fprintf(conn, "unable to start session: %v\r\n", err)
+		return
+	}
+	width, height := conn.WindowSize()
+	tty := bbsui.NewTty(newTerminal(conn, s.charset), width, height)
+	conn.OnResize(tty.Resize)
+	screen, err := bbsui.NewScreen(tty, s.terminalType(conn))
+	if err != nil {
+		fmt.Fprintf(conn, "unable to draw the menu: %v\r\n", err)
+		return
+	}
+	if err = session.Run(screen, tty); err != nil {
+		logger.Debugf("session of %s ended: %v", account.Name, err)
+	}
+}
+
+// terminalType picks the terminfo entry for the client.  Retro terminals report types tcell
+// doesn't know, such as ansi-bbs, and are drawn as plain ANSI.
+func (s *Server) terminalType(conn *Conn) string {
+	term := conn.TerminalType()
+	if _, err := tcell.LookupTerminfo(term); err == nil && term != "" {
+		return term
+	}
+	if s.charset == CP437 {
+		return "ansi"
+	}
+	return "xterm"
+}
+
+// login prompts for a name and password until they match an account.
+func (s *Server) login(conn *Conn) (account *accounts.Account, err error) {
+	if _, err = io.WriteString(conn, banner); err != nil {
+		return
+	}
+	for attempt := 0; attempt < LoginAttempts; attempt++ {
+		var name, password string
+		if name, err = prompt(conn, "login: ", true); err != nil {
+			return
+		}
+		if name == "" {
+			attempt--
+			continue
+		}
+		if password, err = prompt(conn, "password: ", false); err != nil {
+			return
+		}
+		account, err = s.accounts.Authenticate(name, password)
+		if err == nil {
+			return
+		}
+		io.WriteString(conn, "\r\nLogin incorrect\r\n\r\n")
+	}
+	return nil, accounts.ErrLoginFailed
+}
+
+// prompt reads a line from the user, echoing it when echo is set since the server told the
+// client it would do the echoing.
+func prompt(conn *Conn, label string, echo bool) (line string, err error) {
+	if _, err = io.WriteString(conn, label); err != nil {
+		return
+	}
+	var typed []byte
+	b := make([]byte, 1)
+	for {
+		if _, err = conn.Read(b); err != nil {
+			return
+		}
+		switch b[0] {
+		case '\r', '\n':
+			_, err = io.WriteString(conn, "\r\n")
+			return strings.TrimSpace(string(typed)), err
+		case 3, 4:
+			return "", io.EOF
+		case 8, 127:
+			if len(typed) > 0 {
+				typed = typed[:len(typed)-1]
+				if echo {
+					io.WriteString(conn, "\b \b")
+				}
+			}
+		default:
+			if b[0] < ' ' || len(typed) >= maxLineLength {
+				continue
+			}
+			typed = append(typed, b[0])
+			if echo {
+				conn.Write(b)
+			}
+		}
+	}
+}
@@ -0,0 +1,279 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package telnetbbs
+
+import (
+	"bufio"
+	"encoding/binary"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Telnet commands, RFC 854.
+const (
+	cmdSE   = 240
+	cmdSB   = 250
+	cmdWILL = 251
+	cmdWONT = 252
+	cmdDO   = 253
+	cmdDONT = 254
+	cmdIAC  = 255
+)
+
+// Telnet options we negotiate.
+const (
+	optEcho  = 1  // RFC 857
+	optSGA   = 3  // suppress go ahead, RFC 858
+	optTType = 24 // terminal type, RFC 1091
+	optNAWS  = 31 // negotiate about window size, RFC 1073
+)
+
+const (
+	ttypeIs   = 0
+	ttypeSend = 1
+)
+
+// maxSubnegotiation bounds the option data a client can send us.
+const maxSubnegotiation = 256
+
+type parseState int
+
+const (
+	stateData parseState = iota
+	stateIAC
+	stateOption
+	stateSB
+	stateSBIAC
+)
+
+// Conn speaks the telnet protocol over a network connection.  Reads return the data the user
+// typed with commands and option negotiation removed, writes escape IAC bytes.  The server
+// echoes and suppresses go ahead, which puts clients in character at a time mode, and asks
+// for the window size and terminal type.
+type Conn struct {
+	conn net.Conn
+	r    *bufio.Reader
+
+	writeLock sync.Mutex
+
+	// parser state, only used by Read
+	state   parseState
+	command byte
+	sb      []byte
+	lastCR  bool
+
+	mu       sync.Mutex
+	width    int
+	height   int
+	termType string
+	onResize func(width int, height int)
+}
+
+// NewConn wraps a connection accepted by a telnet server.
+func NewConn(conn net.Conn) *Conn {
+	return &Conn{
+		conn:   conn,
+		r:      bufio.NewReader(conn),
+		width:  80,
+		height: 24,
+	}
+}
+
+// Negotiate sends the options we want to the client.  The answers are handled by Read.
+func (c *Conn) Negotiate() error {
+	return c.send(
+		cmdIAC, cmdWILL, optEcho,
+		cmdIAC, cmdWILL, optSGA,
+		cmdIAC, cmdDO, optSGA,
+		cmdIAC, cmdDO, optNAWS,
+		cmdIAC, cmdDO, optTType,
+	)
+}
+
+// WindowSize returns the last size reported by the client, 80 by 24 until it reports one.
+func (c *Conn) WindowSize() (width int, height int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.width, c.height
+}
+
+// TerminalType returns the terminal type reported by the client in lower case, or an empty
+// string.
+func (c *Conn) TerminalType() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.termType
+}
+
+// OnResize registers a function called when the client reports a new window size.
+func (c *Conn) OnResize(cb func(width int, height int)) {
+	c.mu.Lock()
+	c.onResize = cb
+	c.mu.Unlock()
+}
+
+// Read returns data typed by the user.  A carriage return is delivered without the NUL or
+// line feed telnet clients send after it.
+func (c *Conn) Read(p []byte) (n int, err error) {
+	for n < len(p) {
+		if n > 0 && c.r.Buffered() == 0 {
+			return
+		}
+		var b byte
+		b, err = c.r.ReadByte()
+		if err != nil {
+			return
+		}
+		if data, ok := c.parse(b); ok {
+			p[n] = data
+			n++
+		}
+	}
+	return
+}
+
+// parse advances the protocol state machine by one byte, returning user data when there is
+// some.
+func (c *Conn) parse(b byte) (data byte, ok bool) {
+	switch c.state {
+	case stateData:
+		if b == cmdIAC {
+			c.state = stateIAC
+			return
+		}
+		if c.lastCR && (b == 0 || b == '\n') {
+			c.lastCR = false
+			return
+		}
+		c.lastCR = b == '\r'
+		return b, true
+	case stateIAC:
+		switch b {
+		case cmdIAC:
+			c.state = stateData
+			return cmdIAC, true
+		case cmdWILL, cmdWONT, cmdDO, cmdDONT:
+			c.command = b
+			c.state = stateOption
+		case cmdSB:
+			c.sb = c.sb[:0]
+			c.state = stateSB
+		default:
+			// NOP, go ahead, break, interrupt and friends have no meaning for the menu
+			c.state = stateData
+		}
+	case stateOption:
+		c.state = stateData
+		c.handleOption(c.command, b)
+	case stateSB:
+		if b == cmdIAC {
+			c.state = stateSBIAC
+		} else if len(c.sb) < maxSubnegotiation {
+			c.sb = append(c.sb, b)
+		}
+	case stateSBIAC:
+		switch b {
+		case cmdSE:
+			c.state = stateData
+			c.handleSubnegotiation(c.sb)
+		case cmdIAC:
+			c.state = stateSB
+			if len(c.sb) < maxSubnegotiation {
+				c.sb = append(c.sb, cmdIAC)
+			}
+		default:
+			c.state = stateData
+		}
+	}
+	return
+}
+
+// handleOption answers the client's side of option negotiation.  Options we asked for are
+// simply acknowledged, anything else is refused.
+func (c *Conn) handleOption(command byte, option byte) {
+	switch command {
+	case cmdWILL:
+		switch option {
+		case optTType:
+			c.send(cmdIAC, cmdSB, optTType, ttypeSend, cmdIAC, cmdSE)
+		case optNAWS, optSGA:
+		default:
+			c.send(cmdIAC, cmdDONT, option)
+		}
+	case cmdDO:
+		switch option {
+		case optEcho, optSGA:
+		default:
+			c.send(cmdIAC, cmdWONT, option)
+		}
+	}
+}
+
+func (c *Conn) handleSubnegotiation(data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	switch data[0] {
+	case optNAWS:
+		if len(data) != 5 {
+			return
+		}
+		width := int(binary.BigEndian.Uint16(data[1:3]))
+		height := int(binary.BigEndian.Uint16(data[3:5]))
+		if width == 0 || height == 0 {
+			return
+		}
+		c.mu.Lock()
+		c.width, c.height = width, height
+		onResize := c.onResize
+		c.mu.Unlock()
+		if onResize != nil {
+			onResize(width, height)
+		}
+	case optTType:
+		if len(data) > 1 && data[1] == ttypeIs {
+			c.mu.Lock()
+			c.termType = strings.ToLower(string(data[2:]))
+			c.mu.Unlock()
+		}
+	}
+}
+
+// Write sends data to the client, doubling IAC bytes.
+func (c *Conn) Write(p []byte) (n int, err error) {
+	escaped := p
+	for i, b := range p {
+		if b == cmdIAC {
+			escaped = make([]byte, 0, len(p)+8)
+			escaped = append(escaped, p[:i]...)
+			for _, b := range p[i:] {
+				if b == cmdIAC {
+					escaped = append(escaped, cmdIAC)
+				}
+				escaped = append(escaped, b)
+			}
+			break
+		}
+	}
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	if _, err = c.conn.Write(escaped); err != nil {
+		return
+	}
+	return len(p), nil
+}
+
+// Close closes the connection.
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+// send writes protocol bytes to the client as they are.
+func (c *Conn) send(bytes ...byte) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	_, err := c.conn.Write(bytes)
+	return err
+}
@@ -0,0 +1,65 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package telnetbbs
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestConnParsesOptions(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	conn := NewConn(server)
+	resized := make(chan [2]int, 1)
+	conn.OnResize(func(width int, height int) { resized <- [2]int{width, height} })
+
+	go func() {
+		client.Write([]byte{
+			cmdIAC, cmdWILL, optNAWS,
+			cmdIAC, cmdSB, optNAWS, 0, 132, 0, 43, cmdIAC, cmdSE,
+			'h', 'i', '\r', 0,
+			cmdIAC, cmdIAC,
+			cmdIAC, cmdSB, optTType, ttypeIs, 'A', 'N', 'S', 'I', cmdIAC, cmdSE,
+			'!',
+		})
+	}()
+	got := make([]byte, 0)
+	buf := make([]byte, 16)
+	for len(got) < 5 {
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, buf[:n]...)
+	}
+	if !bytes.Equal(got, []byte{'h', 'i', '\r', cmdIAC, '!'}) {
+		t.Errorf("unexpected data %v", got)
+	}
+	if size := <-resized; size != [2]int{132, 43} {
+		t.Errorf("unexpected window size %v", size)
+	}
+	if conn.TerminalType() != "ansi" {
+		t.Errorf("unexpected terminal type %q", conn.TerminalType())
+	}
+}
+
+func TestCP437Output(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	term := newTerminal(NewConn(server), CP437)
+	go func() {
+		// a box corner split across two writes, and a rune CP437 doesn't have
+		frame := []byte("╔═✔")
+		term.Write(frame[:1])
+		term.Write(frame[1:])
+		term.Close()
+	}()
+	got, _ := io.ReadAll(client)
+	if !bytes.Equal(got, []byte{0xc9, 0xcd, '?'}) {
+		t.Errorf("unexpected output % x", got)
+	}
+}
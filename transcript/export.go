@@ -0,0 +1,59 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package transcript
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/rightfoot-consulting/p2pbbs/sanitize"
+)
+
+// Formats lists the formats a transcript can be exported in.
+var Formats = []string{"text", "json", "markdown"}
+
+// markdownSpecial escapes what markdown would read as formatting in a message.
+var markdownSpecial = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`, "<", "&lt;", ">", "&gt;", "#", `\#`, "|", `\|`, "~", `\~`,
+)
+
+// Export writes entries to w in format: text has a line per message, json is an array of the
+// entries and markdown a list of messages under a heading per day.  Times are written in loc.
+// Text from peers is cleaned of terminal escapes and, in markdown, of formatting.
+func Export(w io.Writer, room string, entries []*Entry, format string, loc *time.Location) (err error) {
+	out := bufio.NewWriter(w)
+	switch format {
+	case "text":
+		for _, e := range entries {
+			fmt.Fprintf(out, "%s <%s> %s\n", e.Time.In(loc).Format(time.DateTime), sanitize.Line(e.Nick), sanitize.Line(e.Text))
+		}
+	case "json":
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "    ")
+		if entries == nil {
+			entries = []*Entry{}
+		}
+		if err = encoder.Encode(entries); err != nil {
+			return
+		}
+	case "markdown":
+		fmt.Fprintf(out, "# %s\n", markdownSpecial.Replace(sanitize.Line(room)))
+		day := ""
+		for _, e := range entries {
+			at := e.Time.In(loc)
+			if d := at.Format(time.DateOnly); d != day {
+				day = d
+				fmt.Fprintf(out, "\n## %s\n\n", day)
+			}
+			fmt.Fprintf(out, "- %s **%s**: %s\n", at.Format(time.TimeOnly), markdownSpecial.Replace(sanitize.Line(e.Nick)), markdownSpecial.Replace(sanitize.Line(e.Text)))
+		}
+	default:
+		return fmt.Errorf("unknown format %q, use one of %s", format, strings.Join(Formats, ", "))
+	}
+	return out.Flush()
+}
@@ -0,0 +1,491 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package transcript
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/rightfoot-consulting/p2pbbs/bbscrypto"
+)
+
+// Dir is the name of the directory in the data directory holding the transcripts.
+const Dir = "transcripts"
+
+const (
+	// DefaultMaxFileBytes is the size a transcript file grows to before the next is started.
+	DefaultMaxFileBytes = 1 << 20
+	// DefaultMaxFiles is how many files of a transcript are kept, the oldest are removed.
+	DefaultMaxFiles = 16
+	// DefaultReplay is how many messages of its transcript a room shows when it is joined.
+	DefaultReplay = 50
+	// fileSuffix ends the name of every transcript file.
+	fileSuffix = ".jsonl"
+	// maxLineBytes bounds a line of a transcript file.
+	maxLineBytes = 1 << 20
+)
+
+// ErrLocked is returned for sealed entries read without the key they were sealed to.
+var ErrLocked = errors.New("the transcript is encrypted, use the key it was written with")
+
+// Config sets how transcripts are kept.  Transcripts are written unless Disabled.  Encrypted
+// transcripts seal each entry to the node's key, so they can only be read with its key file.
+type Config struct {
+	Disabled     bool  `json:"disabled"`
+	Encrypt      bool  `json:"encrypt"`
+	MaxFileBytes int64 `json:"max_file_bytes"`
+	MaxFiles     int   `json:"max_files"`
+	Replay       int   `json:"replay"`
+}
+
+// DefaultConfig returns the settings used when the configuration has none.
+func DefaultConfig() *Config {
+	return &Config{
+		MaxFileBytes: DefaultMaxFileBytes,
+		MaxFiles:     DefaultMaxFiles,
+		Replay:       DefaultReplay,
+	}
+}
+
+// Entry is a message in a transcript.  Messages of chatv2 rooms are named by ID and may refer
+// to another: the one they reply to, react to, edit or delete.
+type Entry struct {
+	Time     time.Time `json:"time"`
+	Room     string    `json:"room"`
+	From     string    `json:"from"`
+	Nick     string    `json:"nick"`
+	Text     string    `json:"text"`
+	ID       string    `json:"id,omitempty"`
+	ReplyTo  string    `json:"reply_to,omitempty"`
+	Reaction string    `json:"reaction,omitempty"`
+	Edits    string    `json:"edits,omitempty"`
+	Deletes  string    `json:"deletes,omitempty"`
+}
+
+// Updates reports whether the entry changes another message rather than being one.
+func (e *Entry) Updates() bool {
+	return e.Reaction != "" || e.Edits != "" || e.Deletes != ""
+}
+
+// Resolve returns the messages of entries as their edits and deletions left them, oldest first,
+// for reading outside a room.  Edits by the sender of a message replace its text, the one
+// written last winning, messages their sender deleted are left out, and so are the reactions,
+// edits and deletions themselves.
+func Resolve(entries []*Entry) (messages []*Entry) {
+	// the first message with an ID is the one references name
+	named := make(map[string]*Entry)
+	for _, e := range entries {
+		if _, ok := named[e.ID]; e.ID != "" && !e.Updates() && !ok {
+			named[e.ID] = e
+		}
+	}
+	edits := make(map[string]*Entry)
+	deleted := make(map[string]bool)
+	for _, e := range entries {
+		target, ok := named[e.Edits+e.Deletes]
+		switch {
+		case e.Reaction != "" || !ok || target.From != e.From:
+		case e.Deletes != "":
+			deleted[e.Deletes] = true
+		case edits[e.Edits] == nil || e.Time.After(edits[e.Edits].Time) ||
+			e.Time.Equal(edits[e.Edits].Time) && e.ID > edits[e.Edits].ID:
+			edits[e.Edits] = e
+		}
+	}
+	for _, e := range entries {
+		if e.Updates() {
+			continue
+		}
+		if e.ID != "" && named[e.ID] == e {
+			if deleted[e.ID] {
+				continue
+			}
+			if edit, ok := edits[e.ID]; ok {
+				resolved := *e
+				resolved.Text = edit.Text
+				e = &resolved
+			}
+		}
+		messages = append(messages, e)
+	}
+	return
+}
+
+// line is how an entry is written, in the clear or sealed.
+type line struct {
+	*Entry
+	Sealed []byte `json:"sealed,omitempty"`
+}
+
+// Store keeps the transcripts of the rooms a node is in, a directory per room.
+type Store struct {
+	dir    string
+	config *Config
+	key    crypto.PrivKey
+
+	mu   sync.Mutex
+	logs map[string]*Log
+}
+
+// OpenStore opens the transcripts under dir, creating it if needed.  key seals the entries of
+// encrypted transcripts and opens them, it may be nil when reading transcripts in the clear.
+func OpenStore(dir string, config *Config, key crypto.PrivKey) (store *Store, err error) {
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return
+	}
+	if config == nil {
+		config = DefaultConfig()
+	}
+	if config.Encrypt && key != nil && !bbscrypto.Sealable(key, key.GetPublic()) {
+		return nil, fmt.Errorf("encrypted transcripts: %w", bbscrypto.ErrNotSealable)
+	}
+	store = &Store{dir: dir, config: config, key: key, logs: make(map[string]*Log)}
+	return
+}
+
+// Config returns the settings of the store.
+func (s *Store) Config() *Config {
+	return s.config
+}
+
+// Log returns the transcript of a room.  The transcripts of private rooms are always sealed.
+func (s *Store) Log(room string, private bool) (l *Log, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name := room
+	if private {
+		name = "private:" + room
+	}
+	if l, ok := s.logs[name]; ok {
+		return l, nil
+	}
+	l = &Log{
+		dir:     filepath.Join(s.dir, dirName(name)),
+		room:    room,
+		config:  s.config,
+		key:     s.key,
+		encrypt: s.config.Encrypt || private,
+	}
+	if err = l.open(); err != nil {
+		return nil, err
+	}
+	s.logs[name] = l
+	return
+}
+
+// Rooms returns the names of the rooms with a transcript, sorted.  Private rooms are named
+// private:<room>, which is also what Log is given.
+func (s *Store) Rooms() (rooms []string, err error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if name, err := url.PathUnescape(e.Name()); e.IsDir() && err == nil {
+			rooms = append(rooms, name)
+		}
+	}
+	sort.Strings(rooms)
+	return
+}
+
+// Find returns the transcript of a room named as Rooms names them, or ErrNotExist.
+func (s *Store) Find(name string) (*Log, error) {
+	if _, err := os.Stat(filepath.Join(s.dir, dirName(name))); err != nil {
+		return nil, err
+	}
+	room, private := strings.CutPrefix(name, "private:")
+	return s.Log(room, private)
+}
+
+// Log is the transcript of one room: numbered JSON lines files that are only ever appended to.
+// When a file reaches MaxFileBytes the next is started, and the oldest are removed so at most
+// MaxFiles are kept.
+type Log struct {
+	dir     string
+	room    string
+	config  *Config
+	key     crypto.PrivKey
+	encrypt bool
+
+	mu      sync.Mutex
+	current int
+	size    int64
+}
+
+// open finds the file entries are appended to, the caller must own the log.
+func (l *Log) open() (err error) {
+	if err = os.MkdirAll(l.dir, 0700); err != nil {
+		return
+	}
+	files, err := l.files()
+	if err != nil || len(files) == 0 {
+		l.current = 1
+		return
+	}
+	l.current = files[len(files)-1]
+	info, err := os.Stat(l.file(l.current))
+	if err == nil {
+		l.size = info.Size()
+	}
+	return
+}
+
+// Append writes an entry at the end of the transcript, starting the next file when the current
+// one is full.
+func (l *Log) Append(e *Entry) (err error) {
+	data, err := l.encode(e)
+	if err != nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.size > 0 && l.size+int64(len(data)) > l.config.MaxFileBytes {
+		l.current++
+		l.size = 0
+		if err = l.prune(); err != nil {
+			return
+		}
+	}
+	f, err := os.OpenFile(l.file(l.current), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	n, err := f.Write(data)
+	l.size += int64(n)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return
+}
+
+// Redact removes the text of the message from sent with an ID and of its edits from every file of
+// the transcript, once it was deleted.  The entries are kept so the deletion still applies.
+func (l *Log) Redact(from string, id string) (err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	files, err := l.files()
+	if err != nil {
+		return
+	}
+	for _, number := range files {
+		entries, err := l.read(number)
+		if err != nil {
+			return err
+		}
+		redacted := false
+		for _, e := range entries {
+			if e.From == from && e.Text != "" && (e.ID == id && !e.Updates() || e.Edits == id) {
+				e.Text = ""
+				redacted = true
+			}
+		}
+		if redacted {
+			if err = l.rewrite(number, entries); err != nil {
+				return err
+			}
+		}
+	}
+	return
+}
+
+// rewrite replaces a file of the transcript with entries.  The caller must hold the lock.
+func (l *Log) rewrite(number int, entries []*Entry) (err error) {
+	tmp, err := os.CreateTemp(l.dir, fmt.Sprintf("%06d.*", number))
+	if err != nil {
+		return
+	}
+	defer os.Remove(tmp.Name())
+	w := bufio.NewWriter(tmp)
+	var size int64
+	for _, e := range entries {
+		data, err := l.encode(e)
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		n, _ := w.Write(data)
+		size += int64(n)
+	}
+	if err = w.Flush(); err == nil {
+		err = tmp.Chmod(0600)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return
+	}
+	if err = os.Rename(tmp.Name(), l.file(number)); err == nil && number == l.current {
+		l.size = size
+	}
+	return
+}
+
+// Last returns the last n entries of the transcript, oldest first.
+func (l *Log) Last(n int) (entries []*Entry, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	files, err := l.files()
+	if err != nil {
+		return
+	}
+	for i := len(files) - 1; i >= 0 && len(entries) < n; i-- {
+		read, err := l.read(files[i])
+		if err != nil {
+			return nil, err
+		}
+		entries = append(read, entries...)
+	}
+	if len(entries) > n {
+		entries = entries[len(entries)-n:]
+	}
+	return
+}
+
+// Entries returns every entry of the transcript, oldest first.
+func (l *Log) Entries() (entries []*Entry, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	files, err := l.files()
+	if err != nil {
+		return
+	}
+	for _, number := range files {
+		read, err := l.read(number)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, read...)
+	}
+	return
+}
+
+// Room returns the name of the room.
+func (l *Log) Room() string {
+	return l.room
+}
+
+// encode returns the line of an entry, sealed to our key when the transcript is encrypted.
+func (l *Log) encode(e *Entry) ([]byte, error) {
+	data, err := json.Marshal(e)
+	if err != nil || !l.encrypt {
+		return append(data, '\n'), err
+	}
+	if l.key == nil {
+		return nil, ErrLocked
+	}
+	sealed, err := bbscrypto.Seal(l.key, l.key.GetPublic(), data)
+	if err != nil {
+		return nil, err
+	}
+	data, err = json.Marshal(&line{Sealed: sealed})
+	return append(data, '\n'), err
+}
+
+// read decodes the entries of a file, skipping lines cut short by a crash.  The caller must
+// hold the lock.
+func (l *Log) read(number int) (entries []*Entry, err error) {
+	f, err := os.Open(l.file(number))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64<<10), maxLineBytes)
+	for scanner.Scan() {
+		decoded := line{Entry: new(Entry)}
+		if json.Unmarshal(scanner.Bytes(), &decoded) != nil {
+			continue
+		}
+		if decoded.Sealed != nil {
+			if decoded.Entry, err = l.unseal(decoded.Sealed); err != nil {
+				return nil, err
+			}
+		}
+		entries = append(entries, decoded.Entry)
+	}
+	return entries, scanner.Err()
+}
+
+// unseal opens a sealed entry.
+func (l *Log) unseal(sealed []byte) (e *Entry, err error) {
+	if l.key == nil {
+		return nil, ErrLocked
+	}
+	data, err := bbscrypto.Open(l.key, l.key.GetPublic(), sealed)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrLocked, err)
+	}
+	e = new(Entry)
+	err = json.Unmarshal(data, e)
+	return
+}
+
+// files returns the numbers of the files of the transcript in order, the caller must hold the
+// lock.
+func (l *Log) files() (numbers []int, err error) {
+	matches, err := filepath.Glob(filepath.Join(l.dir, "*"+fileSuffix))
+	if err != nil {
+		return
+	}
+	for _, match := range matches {
+		if n, err := strconv.Atoi(strings.TrimSuffix(filepath.Base(match), fileSuffix)); err == nil && n > 0 {
+			numbers = append(numbers, n)
+		}
+	}
+	sort.Ints(numbers)
+	return
+}
+
+// prune removes the oldest files so that no more than MaxFiles are kept once the current one is
+// written, the caller must hold the lock.
+func (l *Log) prune() error {
+	files, err := l.files()
+	if err != nil {
+		return err
+	}
+	for _, number := range files {
+		if number > l.current-max(l.config.MaxFiles, 1) {
+			break
+		}
+		if err = os.Remove(l.file(number)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *Log) file(number int) string {
+	return filepath.Join(l.dir, fmt.Sprintf("%06d%s", number, fileSuffix))
+}
+
+// dirName escapes the name of a room for use as a directory name on any system, reversibly:
+// bytes other than letters, digits, - and _ are written as %XX.
+func dirName(room string) string {
+	var b strings.Builder
+	for i := 0; i < len(room); i++ {
+		c := room[i]
+		if c == '-' || c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,215 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package transcript
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+)
+
+func entry(i int) *Entry {
+	return &Entry{
+		Time: time.Date(2024, 3, 1, 12, 0, i, 0, time.UTC),
+		Room: "ops",
+		From: "12D3KooWAlice",
+		Nick: "alice",
+		Text: fmt.Sprintf("message %d", i),
+	}
+}
+
+func TestLog(t *testing.T) {
+	dir := t.TempDir()
+	config := &Config{MaxFileBytes: 512, MaxFiles: 3, Replay: 5}
+	store, err := OpenStore(dir, config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := store.Log("ops", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 40; i++ {
+		if err = l.Append(entry(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "ops", "*"+fileSuffix))
+	if len(files) != config.MaxFiles {
+		t.Errorf("kept %d files", len(files))
+	}
+	for _, file := range files {
+		if info, _ := os.Stat(file); info.Size() > config.MaxFileBytes {
+			t.Errorf("%s grew to %d bytes", file, info.Size())
+		}
+	}
+
+	// a new store reads what the last one wrote, and appends after it
+	store, _ = OpenStore(dir, config, nil)
+	l, _ = store.Log("ops", false)
+	l.Append(entry(40))
+	last, err := l.Last(5)
+	if err != nil || len(last) != 5 || last[0].Text != "message 36" || last[4].Text != "message 40" {
+		t.Errorf("last entries %v: %v", last, err)
+	}
+	all, _ := l.Entries()
+	if len(all) < 10 || all[len(all)-1].Text != "message 40" || all[0].Text == "message 0" {
+		t.Errorf("kept %d entries from %s", len(all), all[0].Text)
+	}
+}
+
+func TestEncrypted(t *testing.T) {
+	dir := t.TempDir()
+	key, _, _ := crypto.GenerateEd25519Key(rand.Reader)
+	other, _, _ := crypto.GenerateEd25519Key(rand.Reader)
+	store, err := OpenStore(dir, &Config{MaxFileBytes: DefaultMaxFileBytes, MaxFiles: 1}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// private rooms are sealed even when transcripts aren't encrypted, and may share a name
+	// with a public room
+	for _, private := range []bool{false, true} {
+		l, _ := store.Log("../ops", private)
+		if err = l.Append(entry(1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rooms, err := store.Rooms()
+	if err != nil || !slices.Equal(rooms, []string{"../ops", "private:../ops"}) {
+		t.Fatalf("rooms %q: %v", rooms, err)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*", "*"+fileSuffix))
+	for _, file := range files {
+		data, _ := os.ReadFile(file)
+		if sealed := strings.Contains(filepath.Dir(file), "private"); sealed == bytes.Contains(data, []byte("message 1")) {
+			t.Errorf("%s holds %s", file, data)
+		}
+	}
+	for _, test := range []struct {
+		key crypto.PrivKey
+		err error
+	}{{key, nil}, {other, ErrLocked}, {nil, ErrLocked}} {
+		store, _ := OpenStore(dir, nil, test.key)
+		l, err := store.Find("private:../ops")
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries, err := l.Entries()
+		if !errors.Is(err, test.err) || err == nil && (len(entries) != 1 || entries[0].Text != "message 1") {
+			t.Errorf("read %v: %v", entries, err)
+		}
+	}
+	if _, err = store.Find("lobby"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("found a transcript that was never written: %v", err)
+	}
+}
+
+func TestExport(t *testing.T) {
+	entries := []*Entry{entry(1), {Time: time.Date(2024, 3, 2, 9, 30, 0, 0, time.UTC), Nick: "bob*", Text: "**bold** \x1b[31mred"}}
+	var buf bytes.Buffer
+	if err := Export(&buf, "ops", entries, "text", time.UTC); err != nil {
+		t.Fatal(err)
+	}
+	if want := "2024-03-01 12:00:01 <alice> message 1\n2024-03-02 09:30:00 <bob*> **bold** red\n"; buf.String() != want {
+		t.Errorf("text export %q", buf.String())
+	}
+	buf.Reset()
+	Export(&buf, "ops", entries, "markdown", time.UTC)
+	if want := "# ops\n\n## 2024-03-01\n\n- 12:00:01 **alice**: message 1\n\n## 2024-03-02\n\n- 09:30:00 **bob\\***: \\*\\*bold\\*\\* red\n"; buf.String() != want {
+		t.Errorf("markdown export %q", buf.String())
+	}
+	buf.Reset()
+	Export(&buf, "ops", entries, "json", time.UTC)
+	var decoded []*Entry
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil || len(decoded) != 2 || decoded[1].Text != entries[1].Text {
+		t.Errorf("json export %s: %v", buf.String(), err)
+	}
+	if err := Export(&buf, "ops", entries, "html", time.UTC); err == nil {
+		t.Error("exported an unknown format")
+	}
+}
+
+func TestRedact(t *testing.T) {
+	dir := t.TempDir()
+	key, _, _ := crypto.GenerateEd25519Key(rand.Reader)
+	store, err := OpenStore(dir, &Config{MaxFileBytes: 512, MaxFiles: 8}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, private := range []bool{false, true} {
+		l, _ := store.Log("ops", private)
+		secret := &Entry{Time: time.Now(), From: "12D3KooWAlice", Text: "the password is hunter2", ID: "a1"}
+		edit := &Entry{Time: time.Now(), From: "12D3KooWAlice", Text: "the password is hunter3", ID: "a2", Edits: "a1"}
+		forged := &Entry{Time: time.Now(), From: "12D3KooWMallory", Text: "keep me", ID: "a1"}
+		// the message and its edit end up in different files
+		for _, e := range []*Entry{secret, entry(1), entry(2), entry(3), edit, forged} {
+			if err = l.Append(e); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err = l.Redact("12D3KooWAlice", "a1"); err != nil {
+			t.Fatal(err)
+		}
+		entries, err := l.Entries()
+		if err != nil || len(entries) != 6 {
+			t.Fatalf("kept %d entries: %v", len(entries), err)
+		}
+		for _, e := range entries {
+			if strings.Contains(e.Text, "hunter") || e.From == "12D3KooWMallory" && e.Text != "keep me" {
+				t.Errorf("private %v: %+v after redacting", private, e)
+			}
+		}
+		files, _ := filepath.Glob(filepath.Join(dir, "*", "*"))
+		for _, file := range files {
+			if data, _ := os.ReadFile(file); bytes.Contains(data, []byte("hunter")) || !strings.HasSuffix(file, fileSuffix) {
+				t.Errorf("%s is left after redacting", file)
+			}
+		}
+		// the current file is still appended to
+		if err = l.Append(entry(4)); err != nil {
+			t.Fatal(err)
+		}
+		if last, _ := l.Last(1); len(last) != 1 || last[0].Text != "message 4" {
+			t.Errorf("appended %v after redacting", last)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	at := func(s int) time.Time { return time.Date(2024, 3, 1, 12, 0, s, 0, time.UTC) }
+	entries := []*Entry{
+		{Time: at(0), From: "alice", Text: "hello", ID: "01"},
+		{Time: at(1), From: "bob", Text: "hi alice", ID: "02", ReplyTo: "01"},
+		{Time: at(2), From: "bob", Text: "👍", ID: "03", Reaction: "01"},
+		{Time: at(4), From: "alice", Text: "hello everyone", ID: "05", Edits: "01"},
+		// an older edit arriving after a newer one
+		{Time: at(3), From: "alice", Text: "hello all", ID: "04", Edits: "01"},
+		{Time: at(5), From: "bob", Text: "hijacked", ID: "06", Edits: "01"},
+		{Time: at(6), From: "alice", Text: "oops", ID: "07"},
+		{Time: at(7), From: "alice", Text: "(deleted a message)", ID: "08", Deletes: "07"},
+		{Time: at(8), From: "bob", Text: "(deleted a message)", ID: "09", Deletes: "02"},
+		{Time: at(9), From: "carol", Text: "no id"},
+	}
+	var got []string
+	for _, e := range Resolve(entries) {
+		got = append(got, e.From+": "+e.Text)
+	}
+	want := []string{"alice: hello everyone", "carol: no id"}
+	if !slices.Equal(got, want) {
+		t.Errorf("resolved %q, want %q", got, want)
+	}
+	if entries[0].Text != "hello" {
+		t.Error("resolving changed the entries")
+	}
+}
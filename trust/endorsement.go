@@ -0,0 +1,150 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package trust
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/rightfoot-consulting/p2pbbs/bbscrypto"
+)
+
+// Level is how much an endorser vouches for a subject.
+type Level int
+
+const (
+	// Revoked withdraws an earlier endorsement and marks the subject as not to be trusted.
+	Revoked Level = iota
+	// Marginal vouches for the identity of the subject but not for the endorsements it makes.
+	Marginal
+	// Full vouches for the subject and for the endorsements it makes in turn.
+	Full
+)
+
+// MaxClockSkew is how far in the future an endorsement may be dated before it is rejected.
+const MaxClockSkew = 10 * time.Minute
+
+func (l Level) String() string {
+	switch l {
+	case Revoked:
+		return "revoked"
+	case Marginal:
+		return "marginal"
+	case Full:
+		return "full"
+	default:
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+}
+
+// ParseLevel converts the name of a level back into a Level.
+func ParseLevel(val string) (Level, error) {
+	switch strings.ToLower(val) {
+	case "revoked":
+		return Revoked, nil
+	case "marginal":
+		return Marginal, nil
+	case "full":
+		return Full, nil
+	default:
+		return Revoked, fmt.Errorf("invalid trust level %s", val)
+	}
+}
+
+// Endorsement is a signed statement by Endorser that Subject is the peer id of the person
+// known as Nick.  A newer endorsement from the same endorser about the same subject
+// replaces an older one, which is how endorsements are revoked.
+type Endorsement struct {
+	Endorser  string    `json:"endorser"`
+	Subject   string    `json:"subject"`
+	Nick      string    `json:"nick"`
+	Level     Level     `json:"level"`
+	Created   time.Time `json:"created"`
+	PublicKey []byte    `json:"public_key,omitempty"`
+	Signature []byte    `json:"signature,omitempty"`
+}
+
+// NewEndorsement creates and signs an endorsement of subject by the owner of privateKey.
+func NewEndorsement(privateKey crypto.PrivKey, subject peer.ID, nick string, level Level) (e *Endorsement, err error) {
+	endorser, err := peer.IDFromPrivateKey(privateKey)
+	if err != nil {
+		return
+	}
+	if endorser == subject {
+		err = fmt.Errorf("can't endorse yourself")
+		return
+	}
+	e = &Endorsement{
+		Endorser: endorser.String(),
+		Subject:  subject.String(),
+		Nick:     nick,
+		Level:    level,
+		Created:  time.Now().UTC(),
+	}
+	e.PublicKey, err = bbscrypto.VerificationKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	data, err := e.signingBytes()
+	if err != nil {
+		return nil, err
+	}
+	e.Signature, err = privateKey.Sign(data)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// Verify checks that the endorsement is well formed and signed by its endorser.
+func (e *Endorsement) Verify() (err error) {
+	endorser, err := peer.Decode(e.Endorser)
+	if err != nil {
+		return
+	}
+	subject, err := peer.Decode(e.Subject)
+	if err != nil {
+		return
+	}
+	if endorser == subject {
+		return fmt.Errorf("self endorsement by %s", endorser)
+	}
+	if e.Level < Revoked || e.Level > Full {
+		return fmt.Errorf("invalid trust level %d", e.Level)
+	}
+	if len(e.Nick) > 64 || strings.ContainsAny(e.Nick, "\r\n") {
+		return fmt.Errorf("invalid nick %q", e.Nick)
+	}
+	if e.Created.After(time.Now().Add(MaxClockSkew)) {
+		return fmt.Errorf("endorsement created in the future: %v", e.Created)
+	}
+	data, err := e.signingBytes()
+	if err != nil {
+		return
+	}
+	return bbscrypto.Verify(endorser, e.PublicKey, data, e.Signature)
+}
+
+// EndorserID returns the decoded endorser, the endorsement must have been verified.
+func (e *Endorsement) EndorserID() peer.ID {
+	id, _ := peer.Decode(e.Endorser)
+	return id
+}
+
+// SubjectID returns the decoded subject, the endorsement must have been verified.
+func (e *Endorsement) SubjectID() peer.ID {
+	id, _ := peer.Decode(e.Subject)
+	return id
+}
+
+// signingBytes is the JSON encoding of the endorsement without its signature.
+func (e *Endorsement) signingBytes() ([]byte, error) {
+	unsigned := *e
+	unsigned.Signature = nil
+	return json.Marshal(&unsigned)
+}
@@ -0,0 +1,138 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package trust
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+var logger = logging.Logger("trust")
+
+// ProtocolID is the libp2p protocol endorsements are exchanged over.
+const ProtocolID = protocol.ID("/p2pbbs/trust/1.0.0")
+
+// MaxExchangeBytes bounds how much a peer may send us in one exchange.
+const MaxExchangeBytes = 4 << 20
+
+// MaxExchangeEndorsements bounds how many endorsements a peer may send us in one exchange.
+const MaxExchangeEndorsements = 4096
+
+// ExchangeTimeout bounds a single exchange with a peer.
+const ExchangeTimeout = 30 * time.Second
+
+// Service swaps endorsements with other peers.  Both sides send every endorsement they
+// hold and keep the ones from the other side that verify, are newer than their own and were
+// made by endorsers they trust.
+type Service struct {
+	host  host.Host
+	store *Store
+
+	mu        sync.Mutex
+	exchanged map[peer.ID]bool
+}
+
+// NewService registers the exchange protocol on h, storing what peers send in store.
+func NewService(h host.Host, store *Store) *Service {
+	s := &Service{
+		host:      h,
+		store:     store,
+		exchanged: make(map[peer.ID]bool),
+	}
+	h.SetStreamHandler(ProtocolID, s.handleStream)
+	return s
+}
+
+// Store returns the endorsement store the service fills.
+func (s *Service) Store() *Store {
+	return s.store
+}
+
+// Exchange sends our endorsements to p and merges the ones it sends back.
+func (s *Service) Exchange(ctx context.Context, p peer.ID) (added int, err error) {
+	ctx, cancel := context.WithTimeout(ctx, ExchangeTimeout)
+	defer cancel()
+	stream, err := s.host.NewStream(ctx, p, ProtocolID)
+	if err != nil {
+		return
+	}
+	defer stream.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		stream.SetDeadline(deadline)
+	}
+	if err = s.send(stream); err != nil {
+		stream.Reset()
+		return
+	}
+	if err = stream.CloseWrite(); err != nil {
+		return
+	}
+	return s.receive(stream)
+}
+
+// ExchangeOnce runs an exchange in the background with every peer we haven't exchanged
+// with yet during this session.
+func (s *Service) ExchangeOnce(ctx context.Context, peers []peer.ID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range peers {
+		if s.exchanged[p] {
+			continue
+		}
+		s.exchanged[p] = true
+		go func(p peer.ID) {
+			added, err := s.Exchange(ctx, p)
+			if err != nil {
+				logger.Debugf("endorsement exchange with %s failed: %v", p, err)
+				return
+			}
+			logger.Debugf("received %d new endorsements from %s", added, p)
+		}(p)
+	}
+}
+
+func (s *Service) handleStream(stream network.Stream) {
+	defer stream.Close()
+	stream.SetDeadline(time.Now().Add(ExchangeTimeout))
+	added, err := s.receive(stream)
+	if err != nil {
+		logger.Debugf("bad endorsements from %s: %v", stream.Conn().RemotePeer(), err)
+		stream.Reset()
+		return
+	}
+	logger.Debugf("received %d new endorsements from %s", added, stream.Conn().RemotePeer())
+	if err = s.send(stream); err != nil {
+		stream.Reset()
+	}
+}
+
+func (s *Service) send(w io.Writer) error {
+	all := s.store.All()
+	// the newest, as the other side keeps no more than MaxExchangeEndorsements
+	if len(all) > MaxExchangeEndorsements {
+		all = all[len(all)-MaxExchangeEndorsements:]
+	}
+	return json.NewEncoder(w).Encode(all)
+}
+
+func (s *Service) receive(r io.Reader) (added int, err error) {
+	var endorsements []*Endorsement
+	err = json.NewDecoder(io.LimitReader(r, MaxExchangeBytes)).Decode(&endorsements)
+	if err != nil {
+		return
+	}
+	if len(endorsements) > MaxExchangeEndorsements {
+		endorsements = endorsements[:MaxExchangeEndorsements]
+	}
+	return s.store.AddFrom(s.host.ID(), endorsements)
+}
@@ -0,0 +1,297 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package trust
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// StoreFile is the name of the file in the data directory holding known endorsements.
+const StoreFile = "trust.json"
+
+// MaxPerEndorser bounds how many subjects one endorser's endorsements are kept for, so a
+// trusted peer can't fill the store on its own.
+const MaxPerEndorser = 1024
+
+// MaxPathLength is the longest chain of endorsements that still makes a peer trusted.
+const MaxPathLength = 4
+
+// Status summarises what the local web of trust says about a peer.
+type Status int
+
+const (
+	Unknown Status = iota
+	Trusted
+	Distrusted
+	Self
+)
+
+func (s Status) String() string {
+	switch s {
+	case Trusted:
+		return "trusted"
+	case Distrusted:
+		return "revoked"
+	case Self:
+		return "self"
+	default:
+		return "unknown"
+	}
+}
+
+// Verdict is the result of evaluating a peer against the web of trust.  For trusted peers
+// Path runs from ourselves to the peer and Nick is the name the last endorser vouched for.
+type Verdict struct {
+	Status Status
+	Path   []peer.ID
+	Nick   string
+}
+
+// Store holds the newest endorsement from every endorser about every subject, persisted as
+// JSON in the node's data directory.
+type Store struct {
+	file string
+
+	mu           sync.RWMutex
+	endorsements map[string]*Endorsement
+	// counts the subjects each endorser endorsed
+	subjects map[string]int
+}
+
+// LoadStore reads the endorsements in file, a missing file gives an empty store.  Records
+// that no longer verify are dropped.
+func LoadStore(file string) (store *Store, err error) {
+	store = &Store{
+		file:         file,
+		endorsements: make(map[string]*Endorsement),
+		subjects:     make(map[string]int),
+	}
+	jsonBytes, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var endorsements []*Endorsement
+	if err = json.Unmarshal(jsonBytes, &endorsements); err != nil {
+		return nil, err
+	}
+	for _, e := range endorsements {
+		if e.Verify() == nil {
+			store.merge(e)
+		}
+	}
+	return
+}
+
+// Add verifies an endorsement and keeps it if it is newer than what we know from the same
+// endorser about the same subject.  The store is saved when it changes.
+func (s *Store) Add(e *Endorsement) (changed bool, err error) {
+	if err = e.Verify(); err != nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	changed = s.merge(e)
+	if changed {
+		err = s.save()
+	}
+	return
+}
+
+// AddFrom adds the endorsements a peer sent us, skipping invalid ones and keeping only those
+// whose endorser self fully trusts, the endorsers Evaluate follows, so strangers and peers only
+// marginally trusted can't fill the store.  Endorsers the batch itself makes fully trusted count
+// too.  The store is saved once.
+func (s *Store) AddFrom(self peer.ID, endorsements []*Endorsement) (added int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pending := endorsements
+	for len(pending) > 0 {
+		var waiting []*Endorsement
+		progress := false
+		followed := s.followed(self)
+		for _, e := range pending {
+			if !followed[e.EndorserID()] {
+				waiting = append(waiting, e)
+				continue
+			}
+			if e.Verify() == nil && s.merge(e) {
+				added++
+				progress = true
+			}
+		}
+		if !progress {
+			break
+		}
+		pending = waiting
+	}
+	if added > 0 {
+		err = s.save()
+	}
+	return
+}
+
+// All returns every endorsement, oldest first.
+func (s *Store) All() []*Endorsement {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	all := make([]*Endorsement, 0, len(s.endorsements))
+	for _, e := range s.endorsements {
+		all = append(all, e)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Created.Before(all[j].Created) })
+	return all
+}
+
+// About returns the endorsements whose subject is id.
+func (s *Store) About(id peer.ID) []*Endorsement {
+	about := make([]*Endorsement, 0)
+	for _, e := range s.All() {
+		if e.Subject == id.String() {
+			about = append(about, e)
+		}
+	}
+	return about
+}
+
+// Evaluate decides whether self should trust target.  A target is distrusted when we, or a
+// peer we fully trust, revoked it.  It is trusted when a chain of at most MaxPathLength
+// endorsements leads from self to target in which every intermediate peer is fully trusted.
+func (s *Store) Evaluate(self peer.ID, target peer.ID) Verdict {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.evaluate(self, target)
+}
+
+// evaluate is Evaluate for a caller holding the lock.
+func (s *Store) evaluate(self peer.ID, target peer.ID) Verdict {
+	if self == target {
+		return Verdict{Status: Self, Path: []peer.ID{self}}
+	}
+	edges := s.edges()
+	for _, e := range edges[self] {
+		if e.SubjectID() == target && e.Level == Revoked {
+			return Verdict{Status: Distrusted}
+		}
+	}
+	for _, direct := range edges[self] {
+		if direct.Level != Full {
+			continue
+		}
+		for _, e := range edges[direct.SubjectID()] {
+			if e.SubjectID() == target && e.Level == Revoked {
+				return Verdict{Status: Distrusted}
+			}
+		}
+	}
+
+	// breadth first search so the shortest chain is reported, a peer reached through a
+	// marginal endorsement can still be expanded if a full endorsement reaches it later
+	previous := map[peer.ID]peer.ID{self: ""}
+	expanded := map[peer.ID]bool{self: true}
+	nicks := make(map[peer.ID]string)
+	frontier := []peer.ID{self}
+	for depth := 0; depth < MaxPathLength && len(frontier) > 0; depth++ {
+		var next []peer.ID
+		for _, from := range frontier {
+			for _, e := range edges[from] {
+				to := e.SubjectID()
+				if e.Level == Revoked || expanded[to] {
+					continue
+				}
+				if _, seen := previous[to]; !seen || e.Level == Full {
+					previous[to] = from
+					nicks[to] = e.Nick
+				}
+				if to == target {
+					return Verdict{Status: Trusted, Path: tracePath(previous, target), Nick: nicks[target]}
+				}
+				if e.Level == Full {
+					expanded[to] = true
+					next = append(next, to)
+				}
+			}
+		}
+		frontier = next
+	}
+	return Verdict{Status: Unknown}
+}
+
+// followed returns the peers whose endorsements evaluate follows for self: self and the peers
+// reached through a chain of full endorsements short enough to be extended.
+func (s *Store) followed(self peer.ID) map[peer.ID]bool {
+	edges := s.edges()
+	followed := map[peer.ID]bool{self: true}
+	frontier := []peer.ID{self}
+	for depth := 1; depth < MaxPathLength && len(frontier) > 0; depth++ {
+		var next []peer.ID
+		for _, from := range frontier {
+			for _, e := range edges[from] {
+				if to := e.SubjectID(); e.Level == Full && !followed[to] {
+					followed[to] = true
+					next = append(next, to)
+				}
+			}
+		}
+		frontier = next
+	}
+	return followed
+}
+
+// edges returns the endorsements by endorser.
+func (s *Store) edges() map[peer.ID][]*Endorsement {
+	edges := make(map[peer.ID][]*Endorsement)
+	for _, e := range s.endorsements {
+		edges[e.EndorserID()] = append(edges[e.EndorserID()], e)
+	}
+	return edges
+}
+
+func tracePath(previous map[peer.ID]peer.ID, target peer.ID) []peer.ID {
+	path := []peer.ID{}
+	for id := target; id != ""; id = previous[id] {
+		path = append([]peer.ID{id}, path...)
+	}
+	return path
+}
+
+// merge keeps e if it is newer than the endorsement it would replace, and its endorser has
+// endorsed fewer than MaxPerEndorser subjects.  The caller must hold the lock.
+func (s *Store) merge(e *Endorsement) bool {
+	key := e.Endorser + "/" + e.Subject
+	current, ok := s.endorsements[key]
+	switch {
+	case ok && !e.Created.After(current.Created):
+		return false
+	case !ok && s.subjects[e.Endorser] >= MaxPerEndorser:
+		return false
+	case !ok:
+		s.subjects[e.Endorser]++
+	}
+	s.endorsements[key] = e
+	return true
+}
+
+// save writes the store to its file, the caller must hold the lock.
+func (s *Store) save() error {
+	all := make([]*Endorsement, 0, len(s.endorsements))
+	for _, e := range s.endorsements {
+		all = append(all, e)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Created.Before(all[j].Created) })
+	jsonBytes, err := json.MarshalIndent(all, "", "    ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.file, jsonBytes, 0600)
+}
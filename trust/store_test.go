@@ -0,0 +1,116 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package trust
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rightfoot-consulting/p2pbbs/internal/testkeys"
+)
+
+func endorse(t *testing.T, store *Store, by testkeys.Identity, subject testkeys.Identity, nick string, level Level) {
+	t.Helper()
+	e, err := NewEndorsement(by.Key, subject.ID, nick, level)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = store.Add(e); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	store, err := LoadStore(filepath.Join(t.TempDir(), StoreFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	me, alice, bob, carol, dave := testkeys.New(t), testkeys.New(t), testkeys.New(t), testkeys.New(t), testkeys.New(t)
+
+	endorse(t, store, me, alice, "alice", Full)
+	endorse(t, store, alice, bob, "bob", Marginal)
+	endorse(t, store, me, carol, "carol", Marginal)
+	endorse(t, store, carol, dave, "dave", Full)
+
+	verdict := store.Evaluate(me.ID, bob.ID)
+	if verdict.Status != Trusted || verdict.Nick != "bob" || len(verdict.Path) != 3 {
+		t.Errorf("bob should be trusted through alice, got %+v", verdict)
+	}
+	// carol is only marginally trusted so her endorsements don't count
+	if verdict := store.Evaluate(me.ID, dave.ID); verdict.Status != Unknown {
+		t.Errorf("dave should be unknown, got %v", verdict.Status)
+	}
+
+	// a revocation by someone we fully trust overrides the path
+	time.Sleep(time.Millisecond)
+	endorse(t, store, alice, bob, "", Revoked)
+	if verdict := store.Evaluate(me.ID, bob.ID); verdict.Status != Distrusted {
+		t.Errorf("bob should be revoked, got %v", verdict.Status)
+	}
+
+	reloaded, err := LoadStore(store.file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reloaded.All()) != 4 {
+		t.Errorf("expected 4 endorsements after reload, got %d", len(reloaded.All()))
+	}
+}
+
+func TestTamperedEndorsement(t *testing.T) {
+	me, alice := testkeys.New(t), testkeys.New(t)
+	e, err := NewEndorsement(me.Key, alice.ID, "alice", Marginal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Level = Full
+	if err = e.Verify(); err == nil {
+		t.Errorf("tampered endorsement verified")
+	}
+}
+
+func TestAddFrom(t *testing.T) {
+	store, err := LoadStore(filepath.Join(t.TempDir(), StoreFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	me, alice, bob, carol, mallory := testkeys.New(t), testkeys.New(t), testkeys.New(t), testkeys.New(t), testkeys.New(t)
+	endorse(t, store, me, alice, "alice", Full)
+	sign := func(by testkeys.Identity, subject testkeys.Identity, level Level) *Endorsement {
+		e, err := NewEndorsement(by.Key, subject.ID, "", level)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+
+	// bob becomes trusted through alice in the same batch, mallory is a stranger
+	batch := []*Endorsement{sign(bob, carol, Full), sign(mallory, carol, Revoked), sign(alice, bob, Full)}
+	if added, err := store.AddFrom(me.ID, batch); err != nil || added != 2 {
+		t.Errorf("added %d: %v", added, err)
+	}
+	if verdict := store.Evaluate(me.ID, carol.ID); verdict.Status != Trusted || len(verdict.Path) != 4 {
+		t.Errorf("carol should be trusted through alice and bob, got %+v", verdict)
+	}
+	if len(store.About(carol.ID)) != 1 {
+		t.Error("kept the endorsement of a stranger")
+	}
+
+	// dave is only marginally trusted, so their endorsements aren't followed or kept
+	dave, erin := testkeys.New(t), testkeys.New(t)
+	endorse(t, store, me, dave, "dave", Marginal)
+	if added, err := store.AddFrom(me.ID, []*Endorsement{sign(dave, erin, Full)}); err != nil || added != 0 {
+		t.Errorf("added %d endorsements of a marginal endorser: %v", added, err)
+	}
+
+	// an endorser can't fill the store
+	for i := 0; i < MaxPerEndorser+10; i++ {
+		store.merge(&Endorsement{Endorser: alice.ID.String(), Subject: fmt.Sprintf("subject%d", i), Created: time.Now()})
+	}
+	if store.subjects[alice.ID.String()] != MaxPerEndorser {
+		t.Errorf("kept %d subjects of one endorser", store.subjects[alice.ID.String()])
+	}
+}
@@ -0,0 +1,140 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package webui
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/websocket"
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/rightfoot-consulting/p2pbbs/chatv2"
+	"github.com/rightfoot-consulting/p2pbbs/internal/loopback"
+)
+
+var logger = logging.Logger("webui")
+
+// DefaultAddress is where the web UI listens when no address is given.
+const DefaultAddress = "127.0.0.1:8686"
+
+// TokenCookie is the cookie the browser keeps the launch token in once it has opened the link
+// printed at startup.
+const TokenCookie = "p2pbbs_token"
+
+//go:embed static
+var static embed.FS
+
+// Server serves a browser UI for a node: the chat rooms, the board threads, direct messages,
+// the peers and their profiles.  The browser speaks for the node, so the server only listens
+// on the loopback interface and only answers browsers holding the token made at launch.
+type Server struct {
+	node     *chatv2.ChatV2Node
+	token    string
+	files    http.Handler
+	upgrader websocket.Upgrader
+}
+
+// NewToken returns a fresh random token to protect a server with.
+func NewToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// NewServer returns a server for node that browsers must present token to.
+func NewServer(node *chatv2.ChatV2Node, token string) *Server {
+	files, err := fs.Sub(static, "static")
+	if err != nil {
+		panic(err)
+	}
+	s := &Server{node: node, token: token, files: http.FileServer(http.FS(files))}
+	s.upgrader = websocket.Upgrader{CheckOrigin: sameOrigin}
+	return s
+}
+
+// URL returns the address a browser opens to log in to the server listening on address.
+func URL(address string, token string) string {
+	return fmt.Sprintf("http://%s/?token=%s", address, url.QueryEscape(token))
+}
+
+// ListenAndServe accepts connections on address, which must be a loopback address, until ctx
+// ends.
+func (s *Server) ListenAndServe(ctx context.Context, address string) error {
+	listener, err := loopback.Listen("web UI", address)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ctx, listener)
+}
+
+// Serve accepts connections on listener until ctx ends.
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+	server := &http.Server{
+		Handler:           s,
+		ReadHeaderTimeout: 30 * time.Second,
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+	}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	if err := server.Serve(listener); err != nil && ctx.Err() == nil {
+		return err
+	}
+	return nil
+}
+
+// ServeHTTP checks the token, then serves the UI's files or its WebSocket.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "default-src 'self'; connect-src 'self'; frame-ancestors 'none'")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	if token := r.URL.Query().Get("token"); token != "" {
+		if !s.validToken(token) {
+			http.Error(w, "wrong token, open the link printed when the node started", http.StatusUnauthorized)
+			return
+		}
+		// keep the token in a cookie and take it out of the address bar and history
+		http.SetCookie(w, &http.Cookie{Name: TokenCookie, Value: token, Path: "/", HttpOnly: true, SameSite: http.SameSiteStrictMode})
+		http.Redirect(w, r, r.URL.Path, http.StatusSeeOther)
+		return
+	}
+	cookie, err := r.Cookie(TokenCookie)
+	if err != nil || !s.validToken(cookie.Value) {
+		http.Error(w, "open the link printed when the node started", http.StatusUnauthorized)
+		return
+	}
+	if r.URL.Path == "/ws" {
+		conn, err := s.upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			logger.Debugf("websocket upgrade failed: %v", err)
+			return
+		}
+		newSession(r.Context(), s.node, conn).run()
+		return
+	}
+	s.files.ServeHTTP(w, r)
+}
+
+func (s *Server) validToken(token string) bool {
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) == 1
+}
+
+// sameOrigin only lets pages served by the UI itself open its WebSocket, so other sites open in
+// the browser can't use the cookie to speak for the node.
+func sameOrigin(r *http.Request) bool {
+	origin, err := url.Parse(r.Header.Get("Origin"))
+	return err == nil && origin.Host == r.Host
+}
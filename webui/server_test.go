@@ -0,0 +1,79 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package webui
+
+import (
+	"context"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestToken(t *testing.T) {
+	token, err := NewToken()
+	if err != nil || len(token) != 32 {
+		t.Fatalf("bad token %q: %v", token, err)
+	}
+	server := httptest.NewServer(NewServer(nil, token))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("served without the token: %s", resp.Status)
+	}
+	resp, err = http.Get(server.URL + "/?token=wrong")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("served with a wrong token: %s", resp.Status)
+	}
+
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Jar: jar}
+	resp, err = client.Get(URL(strings.TrimPrefix(server.URL, "http://"), token))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Request.URL.RawQuery != "" || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		t.Errorf("login didn't land on the page: %s %s", resp.Status, resp.Request.URL)
+	}
+	if csp := resp.Header.Get("Content-Security-Policy"); !strings.Contains(csp, "default-src 'self'") {
+		t.Errorf("missing content security policy: %q", csp)
+	}
+	resp, err = client.Get(server.URL + "/app.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("the cookie didn't let the browser in: %s", resp.Status)
+	}
+
+	// another site open in the same browser can't use the cookie to open the WebSocket
+	header := http.Header{}
+	header.Set("Cookie", TokenCookie+"="+token)
+	header.Set("Origin", "http://evil.example.com")
+	_, resp, err = websocket.DefaultDialer.DialContext(context.Background(), "ws"+strings.TrimPrefix(server.URL, "http")+"/ws", header)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("opened the WebSocket from another origin: %v", err)
+	}
+}
+
+func TestLoopbackOnly(t *testing.T) {
+	err := NewServer(nil, "token").ListenAndServe(context.Background(), "0.0.0.0:0")
+	if err == nil || !strings.Contains(err.Error(), "loopback") {
+		t.Errorf("listened on every interface: %v", err)
+	}
+}
@@ -0,0 +1,580 @@
+/*
+Copyright © 2024 Stephen Owens steve.owens@rightfoot.consulting
+*/
+package webui
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/rightfoot-consulting/p2pbbs/boards"
+	"github.com/rightfoot-consulting/p2pbbs/chatv2"
+	"github.com/rightfoot-consulting/p2pbbs/dm"
+	"github.com/rightfoot-consulting/p2pbbs/profile"
+)
+
+const (
+	// maxRequestSize bounds a request from the browser, big enough for a post.
+	maxRequestSize = 2 * boards.MaxBodyLength
+	// writeTimeout bounds how long a browser may take to accept an event.
+	writeTimeout = 10 * time.Second
+	// peerRefresh is how often the peer lists of the joined rooms are checked.
+	peerRefresh = time.Second
+)
+
+// request is a message from the browser.  Type says what is asked for and which of the other
+// fields are used:
+//
+//	join, leave             Room
+//	say                     Room, Text: a message, or a slash command as typed in chatv2
+//	conversations           the peers direct messages were exchanged with
+//	open                    Peer: the direct messages with a peer
+//	dm                      Peer, Text
+//	boards                  the boards and their threads counts
+//	threads                 Board
+//	thread                  Thread
+//	post                    Board, Subject, Text, ReplyTo
+//	profile                 Peer, or our own profile when empty
+//	set-profile             Nick, Bio, Contact
+type request struct {
+	Type    string `json:"type"`
+	Room    string `json:"room,omitempty"`
+	Text    string `json:"text,omitempty"`
+	Peer    string `json:"peer,omitempty"`
+	Board   string `json:"board,omitempty"`
+	Thread  string `json:"thread,omitempty"`
+	Subject string `json:"subject,omitempty"`
+	ReplyTo string `json:"reply_to,omitempty"`
+	Nick    string `json:"nick,omitempty"`
+	Bio     string `json:"bio,omitempty"`
+	Contact string `json:"contact,omitempty"`
+}
+
+// event is a message to the browser.  Data holds the payload of the event type.
+type event struct {
+	Type string      `json:"type"`
+	Room string      `json:"room,omitempty"`
+	Text string      `json:"text,omitempty"`
+	Data interface{} `json:"data,omitempty"`
+}
+
+// peerInfo describes a peer as the UI shows it.
+type peerInfo struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Trust string `json:"trust"`
+}
+
+// line is a chat message or direct message.
+type line struct {
+	ID   string    `json:"id,omitempty"`
+	From peerInfo  `json:"from"`
+	To   string    `json:"to,omitempty"`
+	Text string    `json:"text"`
+	Time time.Time `json:"time"`
+	Self bool      `json:"self,omitempty"`
+}
+
+// postInfo is a board post with its author as the UI shows them.
+type postInfo struct {
+	*boards.Post
+	From peerInfo `json:"from"`
+}
+
+// conversation summarises the direct messages exchanged with a peer.
+type conversation struct {
+	Peer  peerInfo  `json:"peer"`
+	Count int       `json:"count"`
+	Last  time.Time `json:"last"`
+}
+
+// room is a chat room joined by the session.
+type room struct {
+	cr    *chatv2.ChatRoom
+	cmds  *chatv2.Commands
+	peers string
+}
+
+// session is one browser connected over the WebSocket.  Everything it shows comes from the
+// same verified sources as the terminal UI: room messages carry the sender proven by the
+// PubSub signature, and posts and direct messages are only stored once their signatures check.
+type session struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	node   *chatv2.ChatV2Node
+	self   peer.ID
+	conn   *websocket.Conn
+	events chan *event
+
+	mu    sync.Mutex
+	rooms map[string]*room
+}
+
+func newSession(ctx context.Context, node *chatv2.ChatV2Node, conn *websocket.Conn) *session {
+	sess := &session{
+		node:   node,
+		self:   node.Host.ID(),
+		conn:   conn,
+		events: make(chan *event, 256),
+		rooms:  make(map[string]*room),
+	}
+	sess.ctx, sess.cancel = context.WithCancel(ctx)
+	return sess
+}
+
+// run serves the browser until it goes away or the server stops.
+func (sess *session) run() {
+	defer sess.conn.Close()
+	defer sess.cancel()
+	defer sess.leaveAll()
+	go sess.writeLoop()
+	go sess.relay()
+	sess.send(&event{Type: "hello", Data: sess.peerInfo(sess.self, sess.node.Nick())})
+	sess.join(sess.defaultRoom())
+
+	sess.conn.SetReadLimit(maxRequestSize)
+	for {
+		req := new(request)
+		if err := sess.conn.ReadJSON(req); err != nil {
+			logger.Debugf("browser went away: %v", err)
+			return
+		}
+		sess.handle(req)
+	}
+}
+
+// send queues an event for the browser, dropping the session if it can't keep up.
+func (sess *session) send(e *event) {
+	select {
+	case sess.events <- e:
+	case <-sess.ctx.Done():
+	default:
+		logger.Warnf("browser is not keeping up, closing its session")
+		sess.cancel()
+	}
+}
+
+// system sends a notice, such as the output of a slash command, to a room.
+func (sess *session) system(room string, text string) {
+	sess.send(&event{Type: "system", Room: room, Text: text})
+}
+
+// fail tells the browser a request failed.
+func (sess *session) fail(format string, args ...interface{}) {
+	sess.send(&event{Type: "error", Text: fmt.Sprintf(format, args...)})
+}
+
+// writeLoop writes the queued events to the browser.
+func (sess *session) writeLoop() {
+	defer sess.conn.Close()
+	for {
+		select {
+		case e := <-sess.events:
+			sess.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if err := sess.conn.WriteJSON(e); err != nil {
+				sess.cancel()
+				return
+			}
+		case <-sess.ctx.Done():
+			return
+		}
+	}
+}
+
+// relay passes new direct messages and posts to the browser and keeps the peer lists of the
+// joined rooms up to date.
+func (sess *session) relay() {
+	messages, stopMessages := sess.node.DMs.Mailbox().Subscribe(sess.self)
+	defer stopMessages()
+	posts, stopPosts := sess.node.Boards.Store().Subscribe()
+	defer stopPosts()
+	ticker := time.NewTicker(peerRefresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case m := <-messages:
+			sess.send(&event{Type: "dm", Data: sess.dmLine(m)})
+		case p := <-posts:
+			sess.send(&event{Type: "post", Data: sess.postInfo(p)})
+		case <-ticker.C:
+			sess.refreshPeers()
+		case <-sess.ctx.Done():
+			return
+		}
+	}
+}
+
+// handle answers a request from the browser.
+func (sess *session) handle(req *request) {
+	switch req.Type {
+	case "join":
+		sess.join(req.Room)
+	case "leave":
+		sess.leave(req.Room)
+	case "say":
+		sess.say(req.Room, req.Text)
+	case "conversations":
+		sess.conversations()
+	case "open":
+		sess.open(req.Peer)
+	case "dm":
+		sess.sendDM(req.Peer, req.Text)
+	case "boards":
+		sess.boards()
+	case "threads":
+		sess.threads(req.Board)
+	case "thread":
+		sess.thread(req.Thread)
+	case "post":
+		sess.post(req)
+	case "profile":
+		sess.profile(req.Peer)
+	case "set-profile":
+		sess.setProfile(req)
+	default:
+		sess.fail("unknown request %q", req.Type)
+	}
+}
+
+func (sess *session) defaultRoom() string {
+	if sess.node.Config.Room != "" {
+		return sess.node.Config.Room
+	}
+	return chatv2.DefaultRoom
+}
+
+// join joins a chat room and starts passing its messages to the browser.
+func (sess *session) join(name string) {
+	name = strings.TrimSpace(name)
+	if name == "" || strings.ContainsAny(name, " \t\r\n") || len(name) > 50 {
+		sess.fail("%q is not a room name", name)
+		return
+	}
+	sess.mu.Lock()
+	if _, ok := sess.rooms[name]; ok {
+		sess.mu.Unlock()
+		sess.send(&event{Type: "joined", Room: name})
+		return
+	}
+	cr, err := sess.node.JoinRoom(sess.ctx, sess.node.Nick(), name)
+	if err != nil {
+		sess.mu.Unlock()
+		sess.fail("unable to join %s: %v", name, err)
+		return
+	}
+	r := &room{cr: cr}
+	r.cmds = chatv2.NewCommands(sess.node, cr, func(text string) { sess.system(name, text) })
+	sess.rooms[name] = r
+	sess.mu.Unlock()
+	sess.send(&event{Type: "joined", Room: name})
+	go sess.readRoom(name, r)
+}
+
+// leave stops following a chat room.
+func (sess *session) leave(name string) {
+	sess.mu.Lock()
+	r, ok := sess.rooms[name]
+	delete(sess.rooms, name)
+	sess.mu.Unlock()
+	if ok {
+		r.cr.Leave()
+	}
+}
+
+// leaveAll leaves every room when the browser goes away.
+func (sess *session) leaveAll() {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	for name, r := range sess.rooms {
+		r.cr.Leave()
+		delete(sess.rooms, name)
+	}
+}
+
+// room returns a joined room.
+func (sess *session) room(name string) (*room, bool) {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	r, ok := sess.rooms[name]
+	return r, ok
+}
+
+// readRoom passes the messages of a room to the browser until the room is left.
+func (sess *session) readRoom(name string, r *room) {
+	for cm := range r.cr.Messages {
+		sender, err := peer.Decode(cm.SenderID)
+		if err != nil {
+			continue
+		}
+		if err = sess.node.Names.SeenNick(sender, cm.SenderNick); err != nil {
+			sess.system(name, fmt.Sprintf("unable to save nick: %v", err))
+		}
+		from := peerInfo{ID: sender.String(), Name: r.cmds.DisplayName(sender), Trust: r.cmds.TrustStatus(sender).String()}
+		sess.send(&event{Type: "message", Room: name, Data: &line{From: from, Text: cm.Message, Time: time.Now()}})
+	}
+}
+
+// say runs a slash command or publishes a message to a room.
+func (sess *session) say(name string, text string) {
+	r, ok := sess.room(name)
+	if !ok {
+		sess.fail("join %s first", name)
+		return
+	}
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return
+	}
+	if chatv2.IsCommand(text) {
+		r.cmds.Run(text)
+		return
+	}
+	if err := r.cr.Publish(text); err != nil {
+		sess.fail("publish error: %v", err)
+		return
+	}
+	from := peerInfo{ID: sess.self.String(), Name: r.cr.Nick(), Trust: r.cmds.TrustStatus(sess.self).String()}
+	sess.send(&event{Type: "message", Room: name, Data: &line{From: from, Text: text, Time: time.Now(), Self: true}})
+}
+
+// refreshPeers sends the peer list of every joined room that changed.
+func (sess *session) refreshPeers() {
+	sess.mu.Lock()
+	rooms := make(map[string]*room, len(sess.rooms))
+	for name, r := range sess.rooms {
+		rooms[name] = r
+	}
+	sess.mu.Unlock()
+	for name, r := range rooms {
+		ids := r.cr.ListPeers()
+		names := r.cmds.DisplayNames(ids)
+		peers := make([]peerInfo, 0, len(ids))
+		var summary strings.Builder
+		for _, id := range ids {
+			info := peerInfo{ID: id.String(), Name: names[id], Trust: r.cmds.TrustStatus(id).String()}
+			peers = append(peers, info)
+			fmt.Fprintf(&summary, "%s %s %s\n", info.ID, info.Name, info.Trust)
+		}
+		// swap endorsements with peers we haven't talked to yet, like the terminal UI
+		sess.node.Trust.ExchangeOnce(sess.ctx, ids)
+		sess.mu.Lock()
+		changed := r.peers != summary.String()
+		r.peers = summary.String()
+		sess.mu.Unlock()
+		if changed {
+			sess.send(&event{Type: "peers", Room: name, Data: peers})
+		}
+	}
+}
+
+// peerInfo describes a peer outside a room: by petname when we gave it one, otherwise by the
+// nick it declared.
+func (sess *session) peerInfo(id peer.ID, nick string) peerInfo {
+	name := nick
+	if petname, ok := sess.node.Names.Petname(id); ok {
+		name = petname
+	} else if name == "" {
+		if seen, ok := sess.node.Names.Nick(id); ok {
+			name = seen
+		} else {
+			name = id.String()
+		}
+	}
+	return peerInfo{ID: id.String(), Name: name, Trust: sess.node.Trust.Store().Evaluate(sess.self, id).Status.String()}
+}
+
+// resolve finds the peer a user means outside a room, by peer id or petname.
+func (sess *session) resolve(name string) (peer.ID, error) {
+	if id, err := peer.Decode(name); err == nil {
+		return id, nil
+	}
+	if id, ok := sess.node.Names.Lookup(name, nil); ok {
+		return id, nil
+	}
+	return "", fmt.Errorf("%s is neither a peer id nor a petname", name)
+}
+
+func (sess *session) dmLine(m *dm.Message) *line {
+	return &line{
+		ID:   m.ID,
+		From: sess.peerInfo(m.FromID(), m.Nick),
+		To:   m.To,
+		Text: m.Body,
+		Time: m.Created,
+		Self: m.FromID() == sess.self,
+	}
+}
+
+// conversations lists the peers direct messages were exchanged with.
+func (sess *session) conversations() {
+	found, err := sess.node.DMs.Mailbox().Conversations(sess.self)
+	if err != nil {
+		sess.fail("%v", err)
+		return
+	}
+	list := make([]*conversation, 0, len(found))
+	for _, c := range found {
+		list = append(list, &conversation{Peer: sess.peerInfo(c.Peer, c.Nick), Count: c.Count, Last: c.Last})
+	}
+	sess.send(&event{Type: "conversations", Data: list})
+}
+
+// open sends the direct messages exchanged with a peer.
+func (sess *session) open(name string) {
+	id, err := sess.resolve(name)
+	if err != nil {
+		sess.fail("%v", err)
+		return
+	}
+	messages, err := sess.node.DMs.Mailbox().With(sess.self, id)
+	if err != nil {
+		sess.fail("%v", err)
+		return
+	}
+	lines := make([]*line, 0, len(messages))
+	for _, m := range messages {
+		lines = append(lines, sess.dmLine(m))
+	}
+	sess.send(&event{Type: "messages", Text: id.String(), Data: lines})
+}
+
+// sendDM sends a direct message, which comes back to the browser through the mailbox.
+func (sess *session) sendDM(name string, text string) {
+	id, err := sess.resolve(name)
+	if err != nil {
+		sess.fail("%v", err)
+		return
+	}
+	if _, err = sess.node.DMs.Send(sess.node.PrivateKey(), sess.node.Nick(), id, text); err != nil {
+		sess.fail("unable to send: %v", err)
+	}
+}
+
+func (sess *session) postInfo(p *boards.Post) *postInfo {
+	return &postInfo{Post: p, From: sess.peerInfo(p.AuthorID(), p.Nick)}
+}
+
+// boards lists the boards with their thread counts.
+func (sess *session) boards() {
+	type boardInfo struct {
+		Name    string `json:"name"`
+		Threads int    `json:"threads"`
+	}
+	store := sess.node.Boards.Store()
+	list := make([]*boardInfo, 0)
+	for _, name := range store.Boards() {
+		list = append(list, &boardInfo{Name: name, Threads: len(store.Threads(name))})
+	}
+	sess.send(&event{Type: "boards", Data: list})
+}
+
+// threads lists the threads of a board, most recently active first.
+func (sess *session) threads(board string) {
+	type threadInfo struct {
+		Root     *postInfo `json:"root"`
+		Replies  int       `json:"replies"`
+		LastPost time.Time `json:"last_post"`
+	}
+	list := make([]*threadInfo, 0)
+	for _, t := range sess.node.Boards.Store().Threads(board) {
+		list = append(list, &threadInfo{Root: sess.postInfo(t.Root), Replies: t.Replies, LastPost: t.LastPost})
+	}
+	sess.send(&event{Type: "threads", Text: board, Data: list})
+}
+
+// thread sends the posts of a thread, oldest first.
+func (sess *session) thread(id string) {
+	posts := sess.node.Boards.Store().Thread(id)
+	if len(posts) == 0 {
+		sess.fail("no thread %s", id)
+		return
+	}
+	list := make([]*postInfo, 0, len(posts))
+	for _, p := range posts {
+		// the store only keeps verified posts, but the files can be edited behind its back
+		if err := p.Verify(); err != nil {
+			logger.Warnf("dropping post %s: %v", p.ID, err)
+			continue
+		}
+		list = append(list, sess.postInfo(p))
+	}
+	sess.send(&event{Type: "thread", Text: id, Data: list})
+}
+
+// post signs and publishes a new thread, or a reply when ReplyTo is set.
+func (sess *session) post(req *request) {
+	store := sess.node.Boards.Store()
+	var parent *boards.Post
+	board := req.Board
+	if req.ReplyTo != "" {
+		var ok bool
+		if parent, ok = store.Get(req.ReplyTo); !ok {
+			sess.fail("no post %s", req.ReplyTo)
+			return
+		}
+		board = parent.Board
+	}
+	p, err := boards.NewPost(sess.node.PrivateKey(), sess.node.Nick(), board, req.Subject, req.Text, parent)
+	if err != nil {
+		sess.fail("%v", err)
+		return
+	}
+	if err = sess.node.Boards.Publish(p); err != nil {
+		sess.fail("unable to post: %v", err)
+	}
+}
+
+// profile looks up the signed profile of a peer in the DHT.
+func (sess *session) profile(name string) {
+	id := sess.self
+	if name != "" {
+		var err error
+		if id, err = sess.resolve(name); err != nil {
+			sess.fail("%v", err)
+			return
+		}
+	}
+	if sess.node.Profiles == nil {
+		sess.fail("profiles are not available")
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(sess.ctx, profile.LookupTimeout)
+		defer cancel()
+		prof, err := sess.node.Profiles.Lookup(ctx, id)
+		if err != nil {
+			sess.send(&event{Type: "profile", Text: id.String()})
+			return
+		}
+		sess.send(&event{Type: "profile", Text: id.String(), Data: prof})
+	}()
+}
+
+// setProfile signs and publishes our profile, like 'profile set'.
+func (sess *session) setProfile(req *request) {
+	if sess.node.Config.KeyFile == "" || sess.node.Profiles == nil {
+		sess.fail("profiles need a static identity, start the node with --keyfile")
+		return
+	}
+	prof := &profile.Profile{Nick: strings.TrimSpace(req.Nick), Bio: req.Bio, Contact: req.Contact}
+	if prof.Nick == "" {
+		prof.Nick = sess.node.Nick()
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(sess.ctx, profile.LookupTimeout)
+		defer cancel()
+		if current, err := sess.node.Profiles.Lookup(ctx, sess.self); err == nil {
+			prof.AvatarHash = current.AvatarHash
+		}
+		if err := sess.node.Profiles.Publish(ctx, sess.node.PrivateKey(), prof); err != nil {
+			sess.fail("unable to publish your profile: %v", err)
+			return
+		}
+		sess.send(&event{Type: "profile", Text: sess.self.String(), Data: prof})
+	}()
+}
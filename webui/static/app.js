@@ -0,0 +1,405 @@
+// The browser side of the p2pbbs web UI.  Everything goes through one WebSocket to the node,
+// which signs and verifies on our behalf; this script only draws what the node sends.  Text
+// from peers is only ever set as textContent, never parsed as HTML.
+"use strict";
+
+const state = {
+    socket: null,
+    self: null,
+    room: null,
+    rooms: new Map(),   // room name -> {messages: [], peers: []}
+    board: null,
+    thread: null,
+    dmPeer: null,
+};
+
+function $(id) {
+    return document.getElementById(id);
+}
+
+function el(tag, className, text) {
+    const node = document.createElement(tag);
+    if (className) {
+        node.className = className;
+    }
+    if (text !== undefined) {
+        node.textContent = text;
+    }
+    return node;
+}
+
+function clock(time) {
+    return new Date(time).toLocaleTimeString([], {hour: "2-digit", minute: "2-digit"});
+}
+
+function send(request) {
+    if (state.socket && state.socket.readyState === WebSocket.OPEN) {
+        state.socket.send(JSON.stringify(request));
+    }
+}
+
+function showError(text) {
+    const item = el("li", "", text);
+    $("errors").append(item);
+    setTimeout(() => item.remove(), 6000);
+}
+
+function peerName(peer) {
+    const span = el("span", "name trust-" + peer.trust, peer.name);
+    span.title = peer.id + " (" + peer.trust + ")";
+    return span;
+}
+
+function room(name) {
+    if (!state.rooms.has(name)) {
+        state.rooms.set(name, {messages: [], peers: []});
+    }
+    return state.rooms.get(name);
+}
+
+function appendLine(list, line) {
+    const item = el("li", line.self ? "self" : "");
+    item.append(el("span", "time", clock(line.time) + " "), peerName(line.from), document.createTextNode(": " + line.text));
+    list.append(item);
+    list.scrollTop = list.scrollHeight;
+}
+
+function appendSystem(list, text) {
+    list.append(el("li", "system", text));
+    list.scrollTop = list.scrollHeight;
+}
+
+// chat
+
+function drawRooms() {
+    const list = $("rooms");
+    list.replaceChildren();
+    for (const name of state.rooms.keys()) {
+        const item = el("li", name === state.room ? "active" : "", name);
+        item.addEventListener("click", () => selectRoom(name));
+        const leave = el("button", "", "×");
+        leave.title = "leave " + name;
+        leave.addEventListener("click", (e) => {
+            e.stopPropagation();
+            send({type: "leave", room: name});
+            state.rooms.delete(name);
+            if (state.room === name) {
+                state.room = state.rooms.keys().next().value || null;
+            }
+            drawRooms();
+            drawRoom();
+        });
+        item.append(" ", leave);
+        list.append(item);
+    }
+}
+
+function drawRoom() {
+    const messages = $("messages");
+    messages.replaceChildren();
+    $("peers").replaceChildren();
+    if (!state.room) {
+        return;
+    }
+    const r = room(state.room);
+    for (const entry of r.messages) {
+        if (entry.system !== undefined) {
+            appendSystem(messages, entry.system);
+        } else {
+            appendLine(messages, entry);
+        }
+    }
+    drawPeers();
+}
+
+function drawPeers() {
+    const list = $("peers");
+    list.replaceChildren();
+    for (const peer of room(state.room).peers) {
+        const item = el("li");
+        item.append(peerName(peer));
+        item.addEventListener("click", () => openConversation(peer.id));
+        list.append(item);
+    }
+}
+
+function selectRoom(name) {
+    state.room = name;
+    drawRooms();
+    drawRoom();
+}
+
+// boards
+
+function drawBoards(boards) {
+    const list = $("board-list");
+    list.replaceChildren();
+    for (const board of boards) {
+        const item = el("li", board.name === state.board ? "active" : "", board.name + " (" + board.threads + ")");
+        item.addEventListener("click", () => {
+            state.board = board.name;
+            state.thread = null;
+            send({type: "threads", board: board.name});
+            drawBoards(boards);
+        });
+        list.append(item);
+    }
+}
+
+function drawThreads(board, threads) {
+    if (board !== state.board) {
+        return;
+    }
+    $("board-title").textContent = board;
+    $("thread").replaceChildren();
+    const list = $("threads");
+    list.replaceChildren();
+    for (const t of threads) {
+        const item = el("li");
+        item.append(el("span", "", t.root.subject + " "), peerName(t.root.from),
+            el("span", "time", " " + t.replies + " replies, last " + new Date(t.last_post).toLocaleString()));
+        item.addEventListener("click", () => send({type: "thread", thread: t.root.id}));
+        list.append(item);
+    }
+    $("post-subject").hidden = false;
+    $("post-button").textContent = "New thread";
+    $("post-form").hidden = false;
+}
+
+function drawThread(id, posts) {
+    state.thread = id;
+    $("threads").replaceChildren();
+    $("board-title").textContent = posts.length ? posts[0].board + ": " + posts[0].subject : id;
+    const thread = $("thread");
+    thread.replaceChildren();
+    for (const p of posts) {
+        const article = el("article", "post");
+        const head = el("div");
+        head.append(peerName(p.from), el("span", "time", " " + new Date(p.created).toLocaleString()));
+        article.append(head, el("div", "body", p.body));
+        thread.append(article);
+    }
+    $("post-subject").hidden = true;
+    $("post-button").textContent = "Reply";
+    $("post-form").hidden = false;
+}
+
+// direct messages
+
+function drawConversations(conversations) {
+    const list = $("conversations");
+    list.replaceChildren();
+    for (const c of conversations) {
+        const item = el("li", c.peer.id === state.dmPeer ? "active" : "");
+        item.append(peerName(c.peer), el("span", "time", " " + c.count));
+        item.addEventListener("click", () => openConversation(c.peer.id));
+        list.append(item);
+    }
+}
+
+function openConversation(peer) {
+    showView("dms");
+    send({type: "open", peer: peer});
+}
+
+function drawMessages(peer, lines) {
+    state.dmPeer = peer;
+    $("dm-title").textContent = peer;
+    const list = $("dm-messages");
+    list.replaceChildren();
+    for (const line of lines) {
+        appendLine(list, line);
+    }
+    $("dm-form").hidden = false;
+    send({type: "conversations"});
+}
+
+// profiles
+
+function drawProfile(id, profile) {
+    const card = $("profile-card");
+    card.replaceChildren();
+    card.append(el("dt", "", "peer"), el("dd", "", id));
+    if (!profile) {
+        card.append(el("dd", "", "no profile published"));
+        return;
+    }
+    for (const [label, value] of [["nick", profile.nick], ["bio", profile.bio], ["contact", profile.contact],
+        ["avatar", profile.avatar_hash], ["updated", profile.updated_at && new Date(profile.updated_at).toLocaleString()]]) {
+        if (value) {
+            card.append(el("dt", "", label), el("dd", "", value));
+        }
+    }
+    if (id === state.self.id) {
+        $("profile-nick").value = profile.nick || "";
+        $("profile-bio").value = profile.bio || "";
+        $("profile-contact").value = profile.contact || "";
+    }
+}
+
+// events from the node
+
+const handlers = {
+    hello(e) {
+        state.self = e.data;
+        $("whoami").textContent = e.data.name;
+        $("whoami").title = e.data.id;
+        $("profile-nick").value = e.data.name;
+    },
+    joined(e) {
+        room(e.room);
+        selectRoom(e.room);
+    },
+    message(e) {
+        room(e.room).messages.push(e.data);
+        if (e.room === state.room) {
+            appendLine($("messages"), e.data);
+        }
+    },
+    system(e) {
+        room(e.room).messages.push({system: e.text});
+        if (e.room === state.room) {
+            appendSystem($("messages"), e.text);
+        }
+    },
+    peers(e) {
+        room(e.room).peers = e.data || [];
+        if (e.room === state.room) {
+            drawPeers();
+        }
+    },
+    boards(e) {
+        drawBoards(e.data || []);
+    },
+    threads(e) {
+        drawThreads(e.text, e.data || []);
+    },
+    thread(e) {
+        drawThread(e.text, e.data || []);
+    },
+    post(e) {
+        const p = e.data;
+        if (state.thread && (p.thread === state.thread || p.id === state.thread)) {
+            send({type: "thread", thread: state.thread});
+        } else if (!state.thread && p.board === state.board) {
+            send({type: "threads", board: state.board});
+        }
+        send({type: "boards"});
+    },
+    conversations(e) {
+        drawConversations(e.data || []);
+    },
+    messages(e) {
+        drawMessages(e.text, e.data || []);
+    },
+    dm(e) {
+        const line = e.data;
+        if (line.from.id === state.dmPeer || line.to === state.dmPeer) {
+            appendLine($("dm-messages"), line);
+        }
+        send({type: "conversations"});
+    },
+    profile(e) {
+        drawProfile(e.text, e.data);
+    },
+    error(e) {
+        showError(e.text);
+    },
+};
+
+function connect() {
+    const scheme = location.protocol === "https:" ? "wss:" : "ws:";
+    const socket = new WebSocket(scheme + "//" + location.host + "/ws");
+    state.socket = socket;
+    socket.addEventListener("open", () => {
+        $("status").textContent = "connected";
+        $("status").className = "";
+        for (const name of state.rooms.keys()) {
+            send({type: "join", room: name});
+        }
+        send({type: "boards"});
+        send({type: "conversations"});
+    });
+    socket.addEventListener("message", (msg) => {
+        const e = JSON.parse(msg.data);
+        const handler = handlers[e.type];
+        if (handler) {
+            handler(e);
+        }
+    });
+    socket.addEventListener("close", () => {
+        $("status").textContent = "disconnected, retrying…";
+        $("status").className = "down";
+        setTimeout(connect, 3000);
+    });
+}
+
+function showView(name) {
+    for (const view of document.querySelectorAll(".view")) {
+        view.hidden = view.id !== name;
+    }
+    for (const button of document.querySelectorAll("nav button")) {
+        button.classList.toggle("active", button.dataset.view === name);
+    }
+}
+
+function onSubmit(id, handler) {
+    $(id).addEventListener("submit", (e) => {
+        e.preventDefault();
+        handler();
+    });
+}
+
+document.addEventListener("DOMContentLoaded", () => {
+    for (const button of document.querySelectorAll("nav button")) {
+        button.addEventListener("click", () => showView(button.dataset.view));
+    }
+    onSubmit("join-form", () => {
+        const name = $("join-room").value.trim();
+        if (name) {
+            send({type: "join", room: name});
+            $("join-room").value = "";
+        }
+    });
+    onSubmit("say-form", () => {
+        const text = $("say-text").value;
+        if (text.trim() && state.room) {
+            send({type: "say", room: state.room, text: text});
+            $("say-text").value = "";
+        }
+    });
+    onSubmit("post-form", () => {
+        const request = {type: "post", text: $("post-body").value};
+        if (state.thread) {
+            request.reply_to = state.thread;
+        } else {
+            request.board = state.board;
+            request.subject = $("post-subject").value;
+        }
+        send(request);
+        $("post-body").value = "";
+        $("post-subject").value = "";
+    });
+    onSubmit("open-form", () => {
+        const peer = $("open-peer").value.trim();
+        if (peer) {
+            send({type: "open", peer: peer});
+            $("open-peer").value = "";
+        }
+    });
+    onSubmit("dm-form", () => {
+        const text = $("dm-text").value;
+        if (text.trim() && state.dmPeer) {
+            send({type: "dm", peer: state.dmPeer, text: text});
+            $("dm-text").value = "";
+        }
+    });
+    onSubmit("lookup-form", () => send({type: "profile", peer: $("lookup-peer").value.trim()}));
+    onSubmit("profile-form", () => send({
+        type: "set-profile",
+        nick: $("profile-nick").value,
+        bio: $("profile-bio").value,
+        contact: $("profile-contact").value,
+    }));
+    connect();
+});